@@ -0,0 +1,124 @@
+// Package audit builds the masked snapshots, diffs and chain hashes stored
+// in the administrative audit log.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// Masked replaces the value of a secret field.
+const Masked = "******"
+
+var secretSuffixes = []string{"key", "secret", "password", "passwd", "token", "credential", "credentials", "cookie"}
+
+// IsSecretField reports whether a field name looks like it holds a secret,
+// such as "key", "api_key", "SMTPToken" or "GitHubClientSecret".
+func IsSecretField(name string) bool {
+	normalized := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(normalized, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot turns v into a JSON object with secret fields masked. Values
+// that are not objects are kept under "value"; nil stays nil.
+func Snapshot(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	data, err := common.Marshal(v)
+	if err != nil {
+		return map[string]any{"value": err.Error()}
+	}
+	var decoded any
+	if err := common.Unmarshal(data, &decoded); err != nil {
+		return map[string]any{"value": string(data)}
+	}
+	object, ok := decoded.(map[string]any)
+	if !ok {
+		object = map[string]any{"value": decoded}
+	}
+	return mask(object).(map[string]any)
+}
+
+func mask(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for k, field := range value {
+			if IsSecretField(k) && !isEmpty(field) {
+				value[k] = Masked
+				continue
+			}
+			value[k] = mask(field)
+		}
+		return value
+	case []any:
+		for i := range value {
+			value[i] = mask(value[i])
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+func isEmpty(v any) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	}
+	return false
+}
+
+// Change is the before and after value of one field.
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// Diff returns the top-level fields that differ between two snapshots. A
+// masked secret that was rewritten shows as changed only when it went from
+// empty to set or back, since both sides read as Masked otherwise.
+func Diff(before, after map[string]any) map[string]Change {
+	changes := make(map[string]Change)
+	for k, b := range before {
+		a, ok := after[k]
+		if !ok && after != nil {
+			continue
+		}
+		if !reflect.DeepEqual(a, b) {
+			changes[k] = Change{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			changes[k] = Change{After: a}
+		}
+	}
+	return changes
+}
+
+// ChainHash links an entry to the previous one: it is the hex SHA-256 of
+// the previous hash followed by the entry's fields.
+func ChainHash(prevHash string, fields ...string) string {
+	h := sha256.New()
+	h.Write([]byte(prevHash))
+	for _, field := range fields {
+		h.Write([]byte{0})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package audit
+
+import "testing"
+
+func TestIsSecretField(t *testing.T) {
+	for _, name := range []string{"key", "api_key", "SMTPToken", "GitHubClientSecret", "password", "access_token"} {
+		if !IsSecretField(name) {
+			t.Errorf("%s should be secret", name)
+		}
+	}
+	for _, name := range []string{"name", "max_tokens", "ModelRatio", "quota"} {
+		if IsSecretField(name) {
+			t.Errorf("%s should not be secret", name)
+		}
+	}
+}
+
+func TestSnapshotMasksSecrets(t *testing.T) {
+	type channel struct {
+		Name   string            `json:"name"`
+		Key    string            `json:"key"`
+		Other  map[string]string `json:"other"`
+		Quota  int               `json:"quota"`
+		Secret string            `json:"secret"`
+	}
+	snap := Snapshot(&channel{Name: "a", Key: "sk-123", Other: map[string]string{"api_key": "x"}, Quota: 5})
+	if snap["key"] != Masked || snap["name"] != "a" || snap["quota"] != float64(5) {
+		t.Errorf("snapshot = %v", snap)
+	}
+	if snap["other"].(map[string]any)["api_key"] != Masked {
+		t.Errorf("nested secret not masked: %v", snap)
+	}
+	if snap["secret"] != "" {
+		t.Errorf("empty secret should stay empty: %v", snap)
+	}
+	if Snapshot(nil) != nil || Snapshot((*channel)(nil)) != nil {
+		t.Error("nil should snapshot to nil")
+	}
+	if Snapshot(3)["value"] != float64(3) {
+		t.Error("scalars are kept under value")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]any{"name": "a", "quota": float64(1), "key": Masked}
+	after := map[string]any{"name": "b", "quota": float64(1), "key": Masked, "tag": "x"}
+	changes := Diff(before, after)
+	if len(changes) != 2 || changes["name"].After != "b" || changes["tag"].After != "x" {
+		t.Errorf("changes = %v", changes)
+	}
+	if deleted := Diff(before, nil); len(deleted) != 3 {
+		t.Errorf("deletion should list every field: %v", deleted)
+	}
+}
+
+func TestChainHash(t *testing.T) {
+	first := ChainHash("", "a", "b")
+	if first == ChainHash("", "ab") {
+		t.Error("field boundaries must affect the hash")
+	}
+	if ChainHash(first, "c") == ChainHash("", "c") {
+		t.Error("previous hash must affect the hash")
+	}
+	if len(first) != 64 {
+		t.Errorf("hash length = %d", len(first))
+	}
+}
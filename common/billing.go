@@ -1,5 +1,10 @@
 package common
 
+import (
+    "fmt"
+    "strings"
+)
+
 const (
     PlanCycleDaily   = "daily"
     PlanCycleMonthly = "monthly"
@@ -18,8 +23,9 @@ const (
 )
 
 const (
-    AssignmentSubjectTypeUser  = "user"
-    AssignmentSubjectTypeToken = "token"
+    AssignmentSubjectTypeUser         = "user"
+    AssignmentSubjectTypeToken        = "token"
+    AssignmentSubjectTypeOrganization = "organization"
 )
 
 const (
@@ -38,9 +44,18 @@ const (
     RolloverPolicyCap      = "cap"
 )
 
+// Funding sources of the funding waterfall, the order in which a request's
+// quota is drawn.
+const (
+    FundingSourcePackage = "package"
+    FundingSourcePlan    = "plan"
+    FundingSourceBalance = "balance"
+)
+
 const (
     FlagReasonAbuse     = "abuse"
     FlagReasonViolation = "violation"
+    FlagReasonPII       = "pii"
 )
 
 const (
@@ -53,3 +68,27 @@ var (
     UsageCounterCacheEnabled     bool
     RequestAggregateCacheEnabled bool
 )
+
+// ParseFundingWaterfall parses a comma-separated list of funding sources such
+// as "package,plan,balance". An empty string returns nil.
+func ParseFundingWaterfall(s string) ([]string, error) {
+    if strings.TrimSpace(s) == "" {
+        return nil, nil
+    }
+    var sources []string
+    seen := make(map[string]bool)
+    for _, part := range strings.Split(s, ",") {
+        source := strings.ToLower(strings.TrimSpace(part))
+        switch source {
+        case FundingSourcePackage, FundingSourcePlan, FundingSourceBalance:
+        default:
+            return nil, fmt.Errorf("unknown funding source %q", part)
+        }
+        if seen[source] {
+            return nil, fmt.Errorf("duplicate funding source %q", source)
+        }
+        seen[source] = true
+        sources = append(sources, source)
+    }
+    return sources, nil
+}
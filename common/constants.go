@@ -222,6 +222,10 @@ const (
     TopUpStatusPending = "pending"
     TopUpStatusSuccess = "success"
     TopUpStatusExpired = "expired"
+    TopUpStatusFailed  = "failed"
+    // refund states, reachable only from success
+    TopUpStatusPartialRefunded = "partial_refunded"
+    TopUpStatusRefunded        = "refunded"
 )
 
 const (
@@ -3,12 +3,15 @@ package common
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"io"
+	"strings"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -170,3 +173,204 @@ func splitString(s string, delimiter string, n int) []string {
 	
 	return result
 }
+
+// ConversationSealInfo binds derived wrapping keys to the conversation log scheme.
+const ConversationSealInfo = "nebulagate conversation-log x25519-aes256gcm v1"
+
+// SealedPayload is the result of sealing data to one or more X25519 public keys.
+// Ciphertext and Nonce hold the AES-256-GCM encrypted payload; WrappedKeys holds
+// the per-payload data key encrypted for each recipient, in recipient order.
+type SealedPayload struct {
+	Ciphertext  string
+	Nonce       string
+	WrappedKeys []string
+}
+
+// ParseX25519PublicKey decodes a base64 (standard or URL-safe) X25519 public key.
+func ParseX25519PublicKey(publicKey string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		raw, err = base64.RawURLEncoding.DecodeString(publicKey)
+		if err != nil {
+			return nil, errors.New("invalid public key encoding")
+		}
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, errors.New("invalid X25519 public key")
+	}
+	return key, nil
+}
+
+// EncodeX25519PublicKey returns the standard base64 form accepted by ParseX25519PublicKey.
+func EncodeX25519PublicKey(publicKey *ecdh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(publicKey.Bytes())
+}
+
+// PublicKeyFingerprint returns a short, stable identifier for an X25519 public key.
+func PublicKeyFingerprint(publicKey string) (string, error) {
+	key, err := ParseX25519PublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(key.Bytes())
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// SealToPublicKeys encrypts plaintext once with a random data key and wraps that
+// key for every recipient, so any one of the matching private keys can read it.
+// The server keeps no secret that can decrypt the result.
+func SealToPublicKeys(plaintext []byte, publicKeys []string) (*SealedPayload, error) {
+	if len(publicKeys) == 0 {
+		return nil, errors.New("at least one public key is required")
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrappedKeys := make([]string, 0, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		wrappedKey, err := WrapDataKey(dataKey, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		wrappedKeys = append(wrappedKeys, wrappedKey)
+	}
+	gcm, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return &SealedPayload{
+		Ciphertext:  base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+		Nonce:       base64.StdEncoding.EncodeToString(nonce),
+		WrappedKeys: wrappedKeys,
+	}, nil
+}
+
+// WrapDataKey encrypts a data key to an X25519 public key using an ephemeral
+// key exchange. The result is base64(ephemeral public key || nonce || ciphertext).
+func WrapDataKey(dataKey []byte, publicKey string) (string, error) {
+	recipient, err := ParseX25519PublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	wrappingKey, err := deriveWrappingKey(shared, ephemeralPub, recipient.Bytes())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newAESGCM(wrappingKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	out := make([]byte, 0, len(ephemeralPub)+len(nonce)+len(dataKey)+gcm.Overhead())
+	out = append(out, ephemeralPub...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, dataKey, nil)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// UnwrapDataKey reverses WrapDataKey with the recipient's private key. The
+// server never holds user private keys; this is the reference for clients.
+func UnwrapDataKey(wrappedKey string, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, errors.New("invalid wrapped key")
+	}
+	const pubLen, nonceLen = 32, 12
+	if len(raw) <= pubLen+nonceLen {
+		return nil, errors.New("wrapped key too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw[:pubLen])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	wrappingKey, err := deriveWrappingKey(shared, raw[:pubLen], privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newAESGCM(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := gcm.Open(nil, raw[pubLen:pubLen+nonceLen], raw[pubLen+nonceLen:], nil)
+	if err != nil {
+		return nil, errors.New("failed to unwrap data key")
+	}
+	return dataKey, nil
+}
+
+// OpenSealedPayload decrypts a SealedPayload with an already unwrapped data key.
+func OpenSealedPayload(ciphertext string, nonce string, dataKey []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, errors.New("invalid encrypted data")
+	}
+	nonceBytes, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil {
+		return nil, errors.New("invalid nonce")
+	}
+	gcm, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonceBytes) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+	plaintext, err := gcm.Open(nil, nonceBytes, data, nil)
+	if err != nil {
+		return nil, errors.New("decryption failed")
+	}
+	return plaintext, nil
+}
+
+// DeriveRecoveryKey turns a recovery code and its salt into an X25519 key pair.
+// It is the reference for the client-side derivation: clients generate the
+// code and salt, register only the public key, and derive the key again to
+// regain access after losing all devices. The server never sees the code.
+func DeriveRecoveryKey(code string, salt string) (*ecdh.PrivateKey, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if normalized == "" || salt == "" {
+		return nil, errors.New("recovery code and salt are required")
+	}
+	seed := pbkdf2.Key([]byte(normalized), []byte(salt), 100000, 32, sha256.New)
+	return ecdh.X25519().NewPrivateKey(seed)
+}
+
+func deriveWrappingKey(shared []byte, ephemeralPub []byte, recipientPub []byte) ([]byte, error) {
+	salt := make([]byte, 0, len(ephemeralPub)+len(recipientPub))
+	salt = append(salt, ephemeralPub...)
+	salt = append(salt, recipientPub...)
+	return hkdf.Key(sha256.New, shared, salt, ConversationSealInfo, 32)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes for AES-256")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
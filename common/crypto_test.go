@@ -0,0 +1,75 @@
+package common
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSealToPublicKeysRoundTrip(t *testing.T) {
+	deviceKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	recoveryKey, err := DeriveRecoveryKey("ABCD-EFGH-IJKL", "salt")
+	if err != nil {
+		t.Fatalf("derive recovery key: %v", err)
+	}
+	publicKeys := []string{
+		base64.StdEncoding.EncodeToString(deviceKey.PublicKey().Bytes()),
+		base64.StdEncoding.EncodeToString(recoveryKey.PublicKey().Bytes()),
+	}
+
+	sealed, err := SealToPublicKeys([]byte("hello conversation"), publicKeys)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if len(sealed.WrappedKeys) != 2 {
+		t.Fatalf("expected 2 wrapped keys, got %d", len(sealed.WrappedKeys))
+	}
+	for i, privateKey := range []*ecdh.PrivateKey{deviceKey, recoveryKey} {
+		dataKey, err := UnwrapDataKey(sealed.WrappedKeys[i], privateKey)
+		if err != nil {
+			t.Fatalf("unwrap %d: %v", i, err)
+		}
+		plaintext, err := OpenSealedPayload(sealed.Ciphertext, sealed.Nonce, dataKey)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		if string(plaintext) != "hello conversation" {
+			t.Fatalf("unexpected plaintext %q", plaintext)
+		}
+	}
+
+	if _, err := UnwrapDataKey(sealed.WrappedKeys[0], recoveryKey); err == nil {
+		t.Fatalf("expected unwrap with a different private key to fail")
+	}
+}
+
+func TestDeriveRecoveryKeyIsDeterministic(t *testing.T) {
+	first, err := DeriveRecoveryKey("abcd-efgh", "salt")
+	if err != nil {
+		t.Fatalf("derive: %v", err)
+	}
+	second, err := DeriveRecoveryKey(" ABCDEFGH ", "salt")
+	if err != nil {
+		t.Fatalf("derive: %v", err)
+	}
+	if !first.Equal(second) {
+		t.Fatalf("expected normalized codes to derive the same key")
+	}
+	other, _ := DeriveRecoveryKey("abcd-efgh", "pepper")
+	if first.Equal(other) {
+		t.Fatalf("expected a different salt to derive a different key")
+	}
+}
+
+func TestParseX25519PublicKeyRejectsGarbage(t *testing.T) {
+	if _, err := ParseX25519PublicKey("not-a-key"); err == nil {
+		t.Fatalf("expected invalid key error")
+	}
+	if _, err := ParseX25519PublicKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Fatalf("expected invalid length error")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func Sha256Raw(data []byte) []byte {
@@ -32,3 +34,20 @@ func HmacSha256Raw(message, key []byte) []byte {
 func HmacSha256(message, key string) string {
 	return hex.EncodeToString(HmacSha256Raw([]byte(message), []byte(key)))
 }
+
+func GenerateHMACWithKey(key []byte, data string) string {
+	return HmacSha256(data, string(key))
+}
+
+func GenerateHMAC(data string) string {
+	return HmacSha256(data, CryptoSecret)
+}
+
+func Password2Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hashedPassword), err
+}
+
+func ValidatePasswordAndHash(password string, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
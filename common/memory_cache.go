@@ -0,0 +1,40 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryCacheEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+var memoryCache sync.Map
+
+// MemoryCacheGet returns a process-local cached value that has not expired.
+func MemoryCacheGet(key string) (any, bool) {
+	v, ok := memoryCache.Load(key)
+	if !ok {
+		return nil, false
+	}
+	entry := v.(memoryCacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		memoryCache.Delete(key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// MemoryCacheSet stores a process-local value; a zero ttl never expires.
+func MemoryCacheSet(key string, value any, ttl time.Duration) {
+	entry := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	memoryCache.Store(key, entry)
+}
+
+func MemoryCacheDelete(key string) {
+	memoryCache.Delete(key)
+}
@@ -5,6 +5,8 @@ type ContextKey string
 const (
     ContextKeyTokenCountMeta ContextKey = "token_count_meta"
     ContextKeyPromptTokens   ContextKey = "prompt_tokens"
+    // ContextKeyEstimatedPromptTokens is the uncalibrated local estimate, logged for tokenizer calibration
+    ContextKeyEstimatedPromptTokens ContextKey = "estimated_prompt_tokens"
 
     ContextKeyOriginalModel          ContextKey = "original_model"
     ContextKeyRequestStartTime       ContextKey = "request_start_time"
@@ -22,6 +24,11 @@ const (
     ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
     ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
     ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+    ContextKeyTokenMcpLimitEnabled   ContextKey = "token_mcp_limit_enabled"
+    ContextKeyTokenMcpLimit          ContextKey = "token_mcp_limit"
+    ContextKeyTokenConversationLog   ContextKey = "token_conversation_logging"
+    ContextKeyTokenOrganizationId    ContextKey = "token_organization_id"
+    ContextKeyTokenFundingWaterfall  ContextKey = "token_funding_waterfall"
     // Billing metadata derived from token/user
     ContextKeyBillingMode            ContextKey = "billing_mode"
     ContextKeyBillingFeatureEnabled  ContextKey = "billing_feature_enabled"
@@ -57,4 +64,25 @@ const (
     ContextKeyUserName    ContextKey = "username"
 
     ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+    // model turn of a server-side tool loop, 0 outside a loop
+    ContextKeyServerToolTurn ContextKey = "server_tool_turn"
+    // attempt of a structured output repair loop, 0 outside a loop
+    ContextKeyStructuredOutputAttempt ContextKey = "structured_output_attempt"
+    // name of the tool a json_schema was forced through on Claude, "" if none
+    ContextKeyStructuredOutputTool ContextKey = "structured_output_tool"
+    // token vault of a request whose PII is tokenized
+    ContextKeyPIIVault ContextKey = "pii_vault"
+    // PII action and entity counts of the request, for the log
+    ContextKeyPIIRedactions ContextKey = "pii_redactions"
+    ContextKeyPIIFlagged    ContextKey = "pii_flagged"
+    // response writer moderating the output of the request
+    ContextKeyOutputModeration ContextKey = "output_moderation"
+
+    /* management key related keys */
+    // scope resource a route requires of management keys, "" if they are rejected
+    ContextKeyManagementKeyScope ContextKey = "management_key_scope"
+    // action a route requires of the scope when it is not implied by the HTTP method
+    ContextKeyManagementKeyAction ContextKey = "management_key_action"
+    // id of the management key the request authenticated with, 0 otherwise
+    ContextKeyManagementKeyId ContextKey = "management_key_id"
 )
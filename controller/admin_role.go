@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hasAdminPermission reports whether the current user holds permission.
+func hasAdminPermission(c *gin.Context, permission string) bool {
+	return model.UserHasAdminPermission(c.GetInt("id"), c.GetInt("role"), permission)
+}
+
+// checkGrantable refuses to hand out permissions the current user does not
+// hold themselves.
+func checkGrantable(c *gin.Context, permissions []string) error {
+	held, err := model.GetUserAdminPermissions(c.GetInt("id"), c.GetInt("role"))
+	if err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		if !model.AdminPermissionGranted(held, permission) {
+			return errors.New("不能授予自己没有的权限：" + permission)
+		}
+	}
+	return nil
+}
+
+// checkUserManageable refuses to let the current user manage target. The
+// route's permission decides who manages users at all; on top of it nobody
+// but root manages their own account, root or admins of their own level or
+// above, or users holding admin permissions they do not hold themselves.
+func checkUserManageable(c *gin.Context, target *model.User) error {
+	myRole := c.GetInt("role")
+	if myRole == common.RoleRootUser {
+		return nil
+	}
+	if target.Id == c.GetInt("id") {
+		return errors.New("无权管理自己的账户")
+	}
+	if target.Role >= common.RoleAdminUser && myRole <= target.Role {
+		return errors.New("无权管理同权限等级或更高权限等级的用户")
+	}
+	permissions, err := model.GetUserAdminPermissions(target.Id, target.Role)
+	if err != nil {
+		return err
+	}
+	if checkGrantable(c, permissions) != nil {
+		return errors.New("无权管理拥有自己没有的管理权限的用户")
+	}
+	return nil
+}
+
+// assignableUserRole reports whether the current user may give a user the
+// integer role: root any role below root, everyone else roles below admin.
+func assignableUserRole(c *gin.Context, role int) bool {
+	if c.GetInt("role") == common.RoleRootUser {
+		return role < common.RoleRootUser
+	}
+	return role < common.RoleAdminUser
+}
+
+func GetAdminPermissionCatalog(c *gin.Context) {
+	common.ApiSuccess(c, gin.H{
+		"resources": model.AdminResources,
+		"actions":   []string{model.AdminActionRead, model.AdminActionWrite},
+	})
+}
+
+func GetAllAdminRoles(c *gin.Context) {
+	roles, err := model.GetAllAdminRoles()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, roles)
+}
+
+type adminRoleRequest struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func CreateAdminRole(c *gin.Context) {
+	req := adminRoleRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	role := model.AdminRole{Name: req.Name, Description: req.Description}
+	role.SetPermissions(req.Permissions)
+	if err := role.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkGrantable(c, req.Permissions); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := role.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "rbac.role_create", "admin_role", role.Id, nil, role)
+	common.ApiSuccess(c, role)
+}
+
+func UpdateAdminRole(c *gin.Context) {
+	req := adminRoleRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	role, err := model.GetAdminRoleById(req.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkGrantable(c, role.GetPermissions()); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	before := *role
+	role.Name = req.Name
+	role.Description = req.Description
+	role.SetPermissions(req.Permissions)
+	if err := role.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkGrantable(c, req.Permissions); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := role.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "rbac.role_update", "admin_role", role.Id, before, role)
+	common.ApiSuccess(c, role)
+}
+
+func DeleteAdminRole(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	role, err := model.GetAdminRoleById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkGrantable(c, role.GetPermissions()); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := role.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "rbac.role_delete", "admin_role", role.Id, role, nil)
+	common.ApiSuccess(c, nil)
+}
+
+// adminRoleTarget loads the user in :user_id, refusing users the current
+// user may not manage, as user management does.
+func adminRoleTarget(c *gin.Context) (*model.User, bool) {
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	if err := checkUserManageable(c, user); err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func GetUserAdminRoles(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	roles, err := model.GetUserAdminRoles(user.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	permissions, err := model.GetUserAdminPermissions(user.Id, user.Role)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"roles":       roles,
+		"permissions": permissions,
+	})
+}
+
+// UpdateUserAdminRoles replaces the admin roles assigned to a user.
+func UpdateUserAdminRoles(c *gin.Context) {
+	user, ok := adminRoleTarget(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		RoleIds []int `json:"role_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	current, err := model.GetUserAdminRoles(user.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	changed := make(map[int]*model.AdminRole)
+	for _, role := range current {
+		changed[role.Id] = role
+	}
+	roleIds := make([]int, 0, len(req.RoleIds))
+	seen := make(map[int]bool)
+	for _, id := range req.RoleIds {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, kept := changed[id]; kept {
+			delete(changed, id)
+		} else {
+			role, err := model.GetAdminRoleById(id)
+			if err != nil {
+				common.ApiErrorMsg(c, "角色不存在")
+				return
+			}
+			changed[id] = role
+		}
+		roleIds = append(roleIds, id)
+	}
+	// both granted and revoked roles must be within the current user's permissions
+	for _, role := range changed {
+		if err := checkGrantable(c, role.GetPermissions()); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if err := model.SetUserAdminRoles(user.Id, roleIds); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(user.Id, model.LogTypeManage, "管理员角色已更新")
+	previousIds := make([]int, 0, len(current))
+	for _, role := range current {
+		previousIds = append(previousIds, role.Id)
+	}
+	service.RecordAudit(c, "rbac.user_roles_update", "user", user.Id, gin.H{"role_ids": previousIds}, gin.H{"role_ids": roleIds})
+	common.ApiSuccess(c, nil)
+}
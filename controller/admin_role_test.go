@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAdminRoleTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:admin_role_manage?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.AdminRole{}, &model.UserAdminRole{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		model.DB = oldDB
+	})
+	return db
+}
+
+func adminRoleTestContext(id int, role int) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("id", id)
+	c.Set("role", role)
+	return c
+}
+
+func TestCheckUserManageable(t *testing.T) {
+	db := setupAdminRoleTestDB(t)
+	support := &model.AdminRole{Name: "test-support"}
+	support.SetPermissions([]string{"user:*", "log:read"})
+	billing := &model.AdminRole{Name: "test-billing"}
+	billing.SetPermissions([]string{"user:*", "plan:write"})
+	for _, role := range []*model.AdminRole{support, billing} {
+		if err := db.Create(role).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 1 holds support through a custom role, 2 holds billing, 3 has none
+	for userId, roleId := range map[int]int{1: support.Id, 2: billing.Id} {
+		if err := model.SetUserAdminRoles(userId, []int{roleId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commonUser := &model.User{Id: 3, Role: common.RoleCommonUser}
+	supportUser := &model.User{Id: 1, Role: common.RoleCommonUser}
+	billingUser := &model.User{Id: 2, Role: common.RoleCommonUser}
+	admin := &model.User{Id: 10, Role: common.RoleAdminUser}
+	otherAdmin := &model.User{Id: 11, Role: common.RoleAdminUser}
+	root := &model.User{Id: 100, Role: common.RoleRootUser}
+
+	cases := []struct {
+		name     string
+		operator *model.User
+		target   *model.User
+		allowed  bool
+	}{
+		{"custom role manages a common user", supportUser, commonUser, true},
+		{"custom role manages itself", supportUser, supportUser, false},
+		{"custom role manages broader custom role", supportUser, billingUser, false},
+		{"custom role manages admin", supportUser, admin, false},
+		{"admin manages custom role", admin, supportUser, true},
+		{"admin manages admin", admin, otherAdmin, false},
+		{"admin manages root", admin, root, false},
+		{"root manages admin", root, admin, true},
+	}
+	for _, tc := range cases {
+		err := checkUserManageable(adminRoleTestContext(tc.operator.Id, tc.operator.Role), tc.target)
+		if (err == nil) != tc.allowed {
+			t.Errorf("%s: allowed = %v, want %v (err %v)", tc.name, err == nil, tc.allowed, err)
+		}
+	}
+
+	if !assignableUserRole(adminRoleTestContext(1, common.RoleCommonUser), common.RoleCommonUser) ||
+		assignableUserRole(adminRoleTestContext(10, common.RoleAdminUser), common.RoleAdminUser) ||
+		!assignableUserRole(adminRoleTestContext(100, common.RoleRootUser), common.RoleAdminUser) ||
+		assignableUserRole(adminRoleTestContext(100, common.RoleRootUser), common.RoleRootUser) {
+		t.Fatal("unexpected assignable roles")
+	}
+}
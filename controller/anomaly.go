@@ -7,13 +7,14 @@ import (
     "github.com/QuantumNous/new-api/common"
     "github.com/QuantumNous/new-api/model"
     "github.com/QuantumNous/new-api/service"
+    "github.com/QuantumNous/new-api/setting/config"
     "github.com/gin-gonic/gin"
 )
 
 // GetAnomalyDetections retrieves anomaly detections with optional filters
 func GetAnomalyDetections(c *gin.Context) {
     userId := c.GetInt("id")
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:read")
     
     // Parse query parameters
     page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -39,7 +40,7 @@ func GetAnomalyDetections(c *gin.Context) {
     
     // Non-admin users can only view their own anomalies
     targetUserId := userId
-    if userRole >= common.RoleAdminUser && targetUserIdStr != "" {
+    if isAdmin && targetUserIdStr != "" {
         targetUserId, _ = strconv.Atoi(targetUserIdStr)
     }
     
@@ -48,7 +49,7 @@ func GetAnomalyDetections(c *gin.Context) {
     var total int64
     var err error
     
-    if userRole >= common.RoleAdminUser && targetUserIdStr == "" {
+    if isAdmin && targetUserIdStr == "" {
         // Admin viewing all anomalies
         anomalies, total, err = model.SearchAnomalies(0, anomalyType, minRiskScore, status, startIdx, pageSize)
     } else {
@@ -78,7 +79,7 @@ func GetAnomalyDetections(c *gin.Context) {
 // GetAnomalyDetectionById retrieves a specific anomaly detection
 func GetAnomalyDetectionById(c *gin.Context) {
     userId := c.GetInt("id")
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:read")
     
     anomalyId, err := strconv.Atoi(c.Param("id"))
     if err != nil {
@@ -99,7 +100,7 @@ func GetAnomalyDetectionById(c *gin.Context) {
     }
     
     // Check permissions
-    if userRole < common.RoleAdminUser && anomaly.UserId != userId {
+    if !isAdmin && anomaly.UserId != userId {
         c.JSON(http.StatusForbidden, gin.H{
             "success": false,
             "message": "Access denied",
@@ -116,10 +117,10 @@ func GetAnomalyDetectionById(c *gin.Context) {
 // UpdateAnomalyDetectionStatus updates the status of an anomaly detection
 func UpdateAnomalyDetectionStatus(c *gin.Context) {
     userId := c.GetInt("id")
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:write")
     
     // Only admins can update status
-    if userRole < common.RoleAdminUser {
+    if !isAdmin {
         c.JSON(http.StatusForbidden, gin.H{
             "success": false,
             "message": "Admin access required",
@@ -181,10 +182,10 @@ func UpdateAnomalyDetectionStatus(c *gin.Context) {
 
 // UpdateAnomalyDetectionAction updates the action for an anomaly detection
 func UpdateAnomalyDetectionAction(c *gin.Context) {
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:write")
     
     // Only admins can update actions
-    if userRole < common.RoleAdminUser {
+    if !isAdmin {
         c.JSON(http.StatusForbidden, gin.H{
             "success": false,
             "message": "Admin access required",
@@ -247,7 +248,7 @@ func UpdateAnomalyDetectionAction(c *gin.Context) {
 // GetAnomalyStatistics retrieves anomaly statistics for the user
 func GetAnomalyStatistics(c *gin.Context) {
     userId := c.GetInt("id")
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:read")
     
     // Parse time range
     startTimeStr := c.DefaultQuery("start_time", "0")
@@ -265,7 +266,7 @@ func GetAnomalyStatistics(c *gin.Context) {
     
     // Non-admin users can only view their own stats
     targetUserId := userId
-    if userRole >= common.RoleAdminUser && targetUserIdStr != "" {
+    if isAdmin && targetUserIdStr != "" {
         targetUserId, _ = strconv.Atoi(targetUserIdStr)
     }
     
@@ -286,10 +287,10 @@ func GetAnomalyStatistics(c *gin.Context) {
 
 // GetDeviceAggregation retrieves all records for a specific device fingerprint
 func GetDeviceAggregation(c *gin.Context) {
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:read")
     
     // Only admins can view device aggregation
-    if userRole < common.RoleAdminUser {
+    if !isAdmin {
         c.JSON(http.StatusForbidden, gin.H{
             "success": false,
             "message": "Admin access required",
@@ -353,10 +354,10 @@ func GetDeviceAggregation(c *gin.Context) {
 
 // GetIPAggregation retrieves all records for a specific IP address
 func GetIPAggregation(c *gin.Context) {
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:read")
     
     // Only admins can view IP aggregation
-    if userRole < common.RoleAdminUser {
+    if !isAdmin {
         c.JSON(http.StatusForbidden, gin.H{
             "success": false,
             "message": "Admin access required",
@@ -420,10 +421,10 @@ func GetIPAggregation(c *gin.Context) {
 
 // TriggerAnomalyDetection manually triggers anomaly detection for a user
 func TriggerAnomalyDetection(c *gin.Context) {
-    userRole := c.GetInt("role")
+    isAdmin := hasAdminPermission(c, "security:write")
     
     // Only admins can trigger anomaly detection
-    if userRole < common.RoleAdminUser {
+    if !isAdmin {
         c.JSON(http.StatusForbidden, gin.H{
             "success": false,
             "message": "Admin access required",
@@ -457,3 +458,17 @@ func TriggerAnomalyDetection(c *gin.Context) {
         "message": "Anomaly detection completed successfully",
     })
 }
+
+// GetRequestCaptureStats reports the built-in request capture writer state
+func GetRequestCaptureStats(c *gin.Context) {
+    // copy the config so encoding it does not race with an option update
+    captureConfig := *config.GetRequestCaptureConfig()
+    captureConfig.RedactHeaders = append([]string(nil), captureConfig.RedactHeaders...)
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data": gin.H{
+            "config": captureConfig,
+            "stats":  service.GetRequestCaptureStats(),
+        },
+    })
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditExportLimit caps a single CSV export; narrow the time range to get more.
+const auditExportLimit = 10000
+
+func auditLogQueryFromRequest(c *gin.Context) model.AuditLogQuery {
+	actorId, _ := strconv.Atoi(c.Query("actor_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return model.AuditLogQuery{
+		ActorId:        actorId,
+		Action:         c.Query("action"),
+		ResourceType:   c.Query("resource_type"),
+		ResourceId:     c.Query("resource_id"),
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+}
+
+func GetAuditLogs(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	logs, total, err := model.SearchAuditLogs(auditLogQueryFromRequest(c), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// ExportAuditLogs writes matching entries as CSV in chain order, including
+// the hashes so the export can be verified offline.
+func ExportAuditLogs(c *gin.Context) {
+	logs, err := model.GetAuditLogsForExport(auditLogQueryFromRequest(c), auditExportLimit)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit_logs_%s.csv", time.Now().Format("20060102_150405")))
+	c.Header("Cache-Control", "no-cache")
+
+	writer := csv.NewWriter(c.Writer)
+	defer writer.Flush()
+
+	_ = writer.Write([]string{"Id", "CreatedAt", "ActorId", "ActorName", "Ip", "Action", "ResourceType", "ResourceId", "Before", "After", "Diff", "RequestId", "PrevHash", "Hash"})
+	for _, log := range logs {
+		_ = writer.Write([]string{
+			strconv.Itoa(log.Id),
+			strconv.FormatInt(log.CreatedAt, 10),
+			strconv.Itoa(log.ActorId),
+			log.ActorName,
+			log.Ip,
+			log.Action,
+			log.ResourceType,
+			log.ResourceId,
+			log.Before,
+			log.After,
+			log.Diff,
+			log.RequestId,
+			log.PrevHash,
+			log.Hash,
+		})
+	}
+}
+
+// VerifyAuditLogs recomputes the hash chain and reports the first broken entry.
+func VerifyAuditLogs(c *gin.Context) {
+	status, err := model.VerifyAuditLogs()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, status)
+}
@@ -241,6 +241,7 @@ func FixChannelsAbilities(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.fix_abilities", "channel", nil, nil, gin.H{"success": success, "fails": fails})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -410,6 +411,7 @@ func GetChannelKey(c *gin.Context) {
 
 	// 记录操作日志
 	model.RecordLog(userId, model.LogTypeSystem, fmt.Sprintf("查看渠道密钥信息 (渠道ID: %d)", channelId))
+	service.RecordAudit(c, "channel.key_view", "channel", channelId, nil, nil)
 
 	// 返回渠道密钥
 	c.JSON(http.StatusOK, gin.H{
@@ -609,6 +611,9 @@ func AddChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	for i := range channels {
+		service.RecordAudit(c, "channel.create", "channel", channels[i].Id, nil, channels[i])
+	}
 	service.ResetProxyClientCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -619,12 +624,14 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	origin, _ := model.GetChannelById(id, true)
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.delete", "channel", id, origin, nil)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -639,6 +646,7 @@ func DeleteDisabledChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.delete_disabled", "channel", nil, nil, gin.H{"rows": rows})
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -673,6 +681,7 @@ func DisableTagChannels(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.tag_disable", "channel_tag", channelTag.Tag, nil, nil)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -696,6 +705,7 @@ func EnableTagChannels(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.tag_enable", "channel_tag", channelTag.Tag, nil, nil)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -726,6 +736,7 @@ func EditTagChannels(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.tag_edit", "channel_tag", channelTag.Tag, nil, channelTag)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -754,6 +765,7 @@ func DeleteChannelBatch(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.batch_delete", "channel", nil, nil, channelBatch)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -870,6 +882,7 @@ func UpdateChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.update", "channel", channel.Id, originChannel, channel.Channel)
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
 	channel.Key = ""
@@ -978,6 +991,7 @@ func BatchSetChannelTag(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "channel.batch_tag", "channel", nil, nil, channelBatch)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1073,6 +1087,7 @@ func CopyChannel(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
 		return
 	}
+	service.RecordAudit(c, "channel.copy", "channel", clone.Id, nil, gin.H{"from": origin.Id, "name": clone.Name})
 	model.InitChannelCache()
 	// success
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": gin.H{"id": clone.Id}})
@@ -1290,6 +1305,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		service.RecordAudit(c, "channel.multi_key."+request.Action, "channel", channel.Id, nil, request)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1332,6 +1348,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		service.RecordAudit(c, "channel.multi_key."+request.Action, "channel", channel.Id, nil, request)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1356,6 +1373,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		service.RecordAudit(c, "channel.multi_key."+request.Action, "channel", channel.Id, nil, request)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1403,6 +1421,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		service.RecordAudit(c, "channel.multi_key."+request.Action, "channel", channel.Id, nil, request)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1483,6 +1502,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		service.RecordAudit(c, "channel.multi_key."+request.Action, "channel", channel.Id, nil, request)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1551,6 +1571,7 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		service.RecordAudit(c, "channel.multi_key."+request.Action, "channel", channel.Id, nil, request)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
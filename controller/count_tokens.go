@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+type geminiCountTokensRequest struct {
+	Contents               []dto.GeminiChatContent `json:"contents"`
+	GenerateContentRequest *dto.GeminiChatRequest  `json:"generateContentRequest"`
+}
+
+// CountTokensClaude serves POST /v1/messages/count_tokens.
+func CountTokensClaude(c *gin.Context) {
+	request := &dto.ClaudeRequest{}
+	if err := common.UnmarshalBodyReusable(c, request); err != nil {
+		writeClaudeError(c, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest))
+		return
+	}
+	info := relaycommon.GenRelayInfoClaude(c, request)
+	tokens, newAPIError := countTokens(c, info, request, func() (int, error) {
+		return service.CountTokenClaudeRequest(*request, info.OriginModelName)
+	})
+	if newAPIError != nil {
+		writeClaudeError(c, newAPIError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"input_tokens": tokens})
+}
+
+// CountTokensGemini serves POST /v1beta/models/{model}:countTokens.
+func CountTokensGemini(c *gin.Context) {
+	countRequest := &geminiCountTokensRequest{}
+	if err := common.UnmarshalBodyReusable(c, countRequest); err != nil {
+		writeOpenAIError(c, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest))
+		return
+	}
+	request := countRequest.GenerateContentRequest
+	if request == nil {
+		request = &dto.GeminiChatRequest{Contents: countRequest.Contents}
+	}
+	info := relaycommon.GenRelayInfoGemini(c, request)
+	tokens, newAPIError := countTokens(c, info, request, func() (int, error) {
+		return service.CountTokenMeta(c, request.GetTokenCountMeta(), types.RelayFormatGemini, false)
+	})
+	if newAPIError != nil {
+		writeOpenAIError(c, newAPIError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"totalTokens": tokens})
+}
+
+// IsGeminiCountTokensPath reports whether a /models/*path request targets the
+// countTokens action rather than generation.
+func IsGeminiCountTokensPath(c *gin.Context) bool {
+	return extractGeminiAction(c.Param("path")) == "countTokens"
+}
+
+func extractGeminiAction(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		switch path[i] {
+		case ':':
+			return path[i+1:]
+		case '/':
+			return ""
+		}
+	}
+	return ""
+}
+
+func countTokens(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request, local func() (int, error)) (int, *types.NewAPIError) {
+	cfg := config.GetTokenCountConfig()
+	if !cfg.Enabled {
+		return 0, types.NewErrorWithStatusCode(errors.New("token counting is disabled"), types.ErrorCodeInvalidRequest, http.StatusNotFound, types.ErrOptionWithSkipRetry())
+	}
+	info.InitChannelMeta(c)
+	if err := helper.ModelMappedHelper(c, info, request); err != nil {
+		return 0, types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	tokens := -1
+	if cfg.ForwardUpstream {
+		upstreamTokens, err := relay.CountTokensUpstream(c, info)
+		if err == nil {
+			tokens = upstreamTokens
+		} else if !errors.Is(err, relay.ErrCountTokensUnsupported) {
+			logger.LogWarn(c, fmt.Sprintf("upstream token count failed, falling back to local count: %s", err.Error()))
+		}
+	}
+	if tokens < 0 {
+		localTokens, err := local()
+		if err != nil {
+			return 0, types.NewError(err, types.ErrorCodeCountTokenFailed, types.ErrOptionWithSkipRetry())
+		}
+		tokens = localTokens
+	}
+
+	if newAPIError := chargeTokenCount(c, info, tokens, cfg.Price); newAPIError != nil {
+		return 0, newAPIError
+	}
+	return tokens, nil
+}
+
+func chargeTokenCount(c *gin.Context, info *relaycommon.RelayInfo, tokens int, price float64) *types.NewAPIError {
+	if price <= 0 {
+		return nil
+	}
+	groupRatio := helper.HandleGroupRatio(c, info).GroupRatio
+	quota := int(price * common.QuotaPerUnit * groupRatio)
+	if quota <= 0 {
+		return nil
+	}
+	// same checks as a relay request: user and token quota, unlimited
+	// tokens, organizations and spending budgets
+	if newAPIError := service.PreConsumeQuota(c, quota, info); newAPIError != nil {
+		return newAPIError
+	}
+	if delta := quota - info.FinalPreConsumedQuota; delta != 0 {
+		if err := service.PostConsumeQuota(info, delta, info.FinalPreConsumedQuota, false); err != nil {
+			return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+		}
+	}
+	model.UpdateUserUsedQuotaAndRequestCount(info.UserId, quota)
+	model.RecordConsumeLog(c, info.UserId, model.RecordConsumeLogParams{
+		ChannelId:    info.ChannelId,
+		PromptTokens: tokens,
+		ModelName:    info.OriginModelName,
+		TokenName:    c.GetString("token_name"),
+		Quota:        quota,
+		Content:      fmt.Sprintf("计数请求，单价 %.4f，分组倍率 %.2f", price, groupRatio),
+		TokenId:      info.TokenId,
+		Group:        info.UsingGroup,
+	})
+	return nil
+}
+
+func writeClaudeError(c *gin.Context, newAPIError *types.NewAPIError) {
+	newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+	c.JSON(newAPIError.StatusCode, gin.H{
+		"type":  "error",
+		"error": newAPIError.ToClaudeError(),
+	})
+}
+
+func writeOpenAIError(c *gin.Context, newAPIError *types.NewAPIError) {
+	newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+	c.JSON(newAPIError.StatusCode, gin.H{
+		"error": newAPIError.ToOpenAIError(),
+	})
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestExtractGeminiAction(t *testing.T) {
+	cases := map[string]string{
+		"/gemini-2.0-flash:countTokens":           "countTokens",
+		"/gemini-2.0-flash:streamGenerateContent": "streamGenerateContent",
+		"/tunedModels/foo:generateContent":        "generateContent",
+		"/gemini-2.0-flash":                       "",
+		"/foo:bar/baz":                            "",
+	}
+	for path, want := range cases {
+		if got := extractGeminiAction(path); got != want {
+			t.Fatalf("extractGeminiAction(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func setupCountTokensChargeTest(t *testing.T, quota int) *model.User {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Token{}, &model.Log{}, &model.SpendingBudget{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB, oldLogDB, oldBilling := model.DB, model.LOG_DB, common.BillingFeatureEnabled
+	model.DB, model.LOG_DB = db, db
+	common.RedisEnabled = false
+	common.BillingFeatureEnabled = false
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB, common.BillingFeatureEnabled = oldDB, oldLogDB, oldBilling
+	})
+	user := &model.User{Username: "counter", Password: "password", Quota: quota, Status: common.UserStatusEnabled, AffCode: t.Name()}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func TestChargeTokenCountChecksQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	price := 0.01
+	charge := int(price * common.QuotaPerUnit)
+	rich := 20 * int(common.QuotaPerUnit)
+	cases := []struct {
+		name      string
+		userQuota int
+		unlimited bool
+		allowed   bool
+	}{
+		{"balance below the price", charge / 2, true, false},
+		{"unlimited token of a trusted user", rich, true, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := setupCountTokensChargeTest(t, tc.userQuota)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", nil)
+			info := &relaycommon.RelayInfo{UserId: user.Id, TokenUnlimited: tc.unlimited, IsPlayground: true,
+				OriginModelName: "claude-3-5-sonnet", UsingGroup: "default", StartTime: time.Now(), ChannelMeta: &relaycommon.ChannelMeta{}}
+			newAPIError := chargeTokenCount(c, info, 42, price)
+			if (newAPIError == nil) != tc.allowed {
+				t.Fatalf("allowed = %v, want %v (err %v)", newAPIError == nil, tc.allowed, newAPIError)
+			}
+			want := tc.userQuota
+			if tc.allowed {
+				want -= charge
+			} else if newAPIError.GetErrorCode() != types.ErrorCodeInsufficientUserQuota {
+				t.Fatalf("error code = %s", newAPIError.GetErrorCode())
+			}
+			stored, err := model.GetUserQuota(user.Id, true)
+			if err != nil || stored != want {
+				t.Fatalf("user quota = %d (err %v), want %d", stored, err, want)
+			}
+		})
+	}
+}
@@ -1,12 +1,15 @@
 package controller
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
 	"github.com/gin-gonic/gin"
 )
 
@@ -172,12 +175,23 @@ func GetEncryptionStatus(c *gin.Context) {
 		})
 		return
 	}
+
+	activeKeys, err := model.GetActiveUserEncryptionKeys(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get encryption keys",
+		})
+		return
+	}
+	activeKeyCount := len(activeKeys)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
 			"encryption_enabled": user.EncryptionEnabled,
 			"has_encryption_key": user.EncryptionKeyHash != "",
+			"active_key_count":   activeKeyCount,
 		},
 	})
 }
@@ -231,12 +245,37 @@ func CreateConversationLog(c *gin.Context) {
 		return
 	}
 	
-	// Get encryption key from request header
+	// Without a legacy symmetric key, seal the log to the user's registered
+	// public keys so the raw key never has to leave the client.
 	encryptionKey := c.GetHeader("X-Encryption-Key")
 	if encryptionKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"message": "Encryption key is required",
+		log := &model.ConversationLog{
+			UserId:           userId,
+			TokenId:          req.TokenId,
+			Model:            req.Model,
+			Source:           model.ConversationLogSourceClient,
+			RequestId:        req.RequestId,
+			MessageCount:     req.MessageCount,
+			PromptTokens:     req.PromptTokens,
+			CompletionTokens: req.CompletionTokens,
+		}
+		if err := service.SaveSealedConversationLog(log, []byte(req.ConversationData)); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, service.ErrNoEncryptionKeys) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{
+				"success": false,
+				"message": "Failed to save conversation log: " + err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Conversation log saved successfully",
+			"data": gin.H{
+				"id": log.Id,
+			},
 		})
 		return
 	}
@@ -375,6 +414,11 @@ func GetConversationLogs(c *gin.Context) {
 	// Decrypt logs
 	decryptedLogs := make([]ConversationLogResponse, 0, len(logs))
 	for _, log := range logs {
+		// Sealed logs can only be opened client-side with a private key; they
+		// are served by GetSealedConversationLogs and the export endpoint.
+		if log.KeyScheme == model.ConversationLogSchemeSealed {
+			continue
+		}
 		decryptedData, err := common.DecryptData(log.EncryptedData, log.Nonce, encryptionKey)
 		if err != nil {
 			// Log error but continue with other logs
@@ -406,6 +450,38 @@ func GetConversationLogs(c *gin.Context) {
 	})
 }
 
+// ExportConversationLogs downloads the user's conversation logs as an encrypted
+// archive. Records stay sealed; decryption happens on the client.
+func ExportConversationLogs(c *gin.Context) {
+	userId := c.GetInt("id")
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+
+	archive, err := service.ExportConversationLogs(userId, tokenId, startTimestamp, endTimestamp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to export conversation logs",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=conversation-logs-%d-%d.json", userId, archive.ExportedAt))
+	c.JSON(http.StatusOK, archive)
+}
+
+// GetConversationLoggingStats reports the automatic conversation capture writer state
+func GetConversationLoggingStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"config": config.GetConversationLoggingConfig(),
+			"stats":  service.GetConversationLoggingStats(),
+		},
+	})
+}
+
 // DeleteConversationLog deletes a conversation log
 func DeleteConversationLog(c *gin.Context) {
 	userId := c.GetInt("id")
@@ -0,0 +1,448 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type EncryptionKeyRegisterRequest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+type RecoveryKeyRegisterRequest struct {
+	PublicKey    string `json:"public_key" binding:"required"`
+	RecoverySalt string `json:"recovery_salt" binding:"required"`
+}
+
+type RewrappedKeyEntry struct {
+	LogId      int    `json:"log_id" binding:"required"`
+	WrappedKey string `json:"wrapped_key" binding:"required"`
+}
+
+type RewrapRequest struct {
+	Entries []RewrappedKeyEntry `json:"entries" binding:"required"`
+}
+
+type SealedConversationLogResponse struct {
+	Id               int    `json:"id"`
+	TokenId          int    `json:"token_id"`
+	Model            string `json:"model"`
+	Source           string `json:"source"`
+	EncryptedData    string `json:"encrypted_data"`
+	Nonce            string `json:"nonce"`
+	WrappedKey       string `json:"wrapped_key"`
+	Timestamp        int64  `json:"timestamp"`
+	RequestId        string `json:"request_id"`
+	MessageCount     int    `json:"message_count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+const maxRewrapBatch = 500
+
+// ListEncryptionKeys returns the user's registered device and recovery keys
+func ListEncryptionKeys(c *gin.Context) {
+	keys, err := model.GetUserEncryptionKeys(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get encryption keys",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    keys,
+	})
+}
+
+// RegisterEncryptionKey registers a device's X25519 public key. New logs are
+// sealed to it immediately; existing logs become readable once another device
+// re-wraps their data keys for it.
+func RegisterEncryptionKey(c *gin.Context) {
+	userId := c.GetInt("id")
+
+	var req EncryptionKeyRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	publicKey := strings.TrimSpace(req.PublicKey)
+	fingerprint, err := common.PublicKeyFingerprint(publicKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	key := &model.UserEncryptionKey{
+		UserId:      userId,
+		Name:        name,
+		PublicKey:   publicKey,
+		Fingerprint: fingerprint,
+		Purpose:     model.EncryptionKeyPurposeDevice,
+	}
+	if err := model.CreateUserEncryptionKey(key); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to register encryption key: " + err.Error(),
+		})
+		return
+	}
+
+	pending, _ := model.CountConversationLogsMissingKey(userId, key.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Encryption key registered successfully",
+		"data": gin.H{
+			"key":            key,
+			"pending_rewrap": pending,
+		},
+	})
+}
+
+// RevokeEncryptionKey revokes a key and destroys every data key wrapped for
+// it. Logs only this key can open are reported and the key is kept, unless
+// force=true accepts that they become unreadable.
+func RevokeEncryptionKey(c *gin.Context) {
+	keyId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid key ID",
+		})
+		return
+	}
+	force := c.Query("force") == "true"
+
+	orphaned, err := model.RevokeUserEncryptionKey(keyId, c.GetInt("id"), force)
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to revoke encryption key"
+		if errors.Is(err, model.ErrLastEncryptionKey) {
+			status = http.StatusBadRequest
+			message = err.Error()
+		} else if errors.Is(err, model.ErrEncryptionKeyOrphansLogs) {
+			status = http.StatusConflict
+			message = err.Error()
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+			message = "Encryption key not found"
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": message,
+			"data": gin.H{
+				"orphaned_logs": orphaned,
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Encryption key revoked successfully",
+		"data": gin.H{
+			"orphaned_logs": orphaned,
+		},
+	})
+}
+
+// GetRewrapQueue pages through data keys wrapped for source_key_id on logs the
+// target key (:id) cannot open yet. The client unwraps each with the source
+// private key and wraps it again for the target public key.
+func GetRewrapQueue(c *gin.Context) {
+	userId := c.GetInt("id")
+	targetKey, ok := getActiveEncryptionKeyParam(c, userId)
+	if !ok {
+		return
+	}
+	sourceKeyId, err := strconv.Atoi(c.Query("source_key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "source_key_id is required",
+		})
+		return
+	}
+	afterLogId, _ := strconv.Atoi(c.DefaultQuery("after_log_id", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > maxRewrapBatch {
+		limit = 100
+	}
+
+	entries, err := model.GetConversationLogKeysForRewrap(userId, sourceKeyId, targetKey.Id, afterLogId, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get wrapped keys",
+		})
+		return
+	}
+	pending, _ := model.CountConversationLogsMissingKey(userId, targetKey.Id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"entries": entries,
+			"pending": pending,
+		},
+	})
+}
+
+// SubmitRewrappedKeys stores data keys the client re-wrapped for key :id
+func SubmitRewrappedKeys(c *gin.Context) {
+	userId := c.GetInt("id")
+	targetKey, ok := getActiveEncryptionKeyParam(c, userId)
+	if !ok {
+		return
+	}
+
+	var req RewrapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	if len(req.Entries) > maxRewrapBatch {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Too many entries in one batch",
+		})
+		return
+	}
+
+	entries := make(map[int]string, len(req.Entries))
+	for _, entry := range req.Entries {
+		entries[entry.LogId] = entry.WrappedKey
+	}
+	saved, err := model.SaveRewrappedConversationLogKeys(userId, targetKey.Id, entries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to save wrapped keys",
+		})
+		return
+	}
+	pending, _ := model.CountConversationLogsMissingKey(userId, targetKey.Id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"saved":   saved,
+			"pending": pending,
+		},
+	})
+}
+
+// RegisterRecoveryKey registers the public key a client derived from a
+// recovery code it generated. The code and private key never leave the
+// client; the salt is stored so the key can be derived again. The previous
+// recovery key is revoked unless it still holds logs no other key can open.
+func RegisterRecoveryKey(c *gin.Context) {
+	userId := c.GetInt("id")
+
+	var req RecoveryKeyRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	publicKey := strings.TrimSpace(req.PublicKey)
+	fingerprint, err := common.PublicKeyFingerprint(publicKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	salt := strings.TrimSpace(req.RecoverySalt)
+	if len(salt) < 16 || len(salt) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "recovery_salt must be 16 to 64 characters",
+		})
+		return
+	}
+
+	activeKeys, err := model.GetActiveUserEncryptionKeys(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get encryption keys",
+		})
+		return
+	}
+
+	key := &model.UserEncryptionKey{
+		UserId:       userId,
+		Name:         "recovery",
+		PublicKey:    publicKey,
+		Fingerprint:  fingerprint,
+		Purpose:      model.EncryptionKeyPurposeRecovery,
+		RecoverySalt: salt,
+	}
+	if err := model.CreateUserEncryptionKey(key); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to register recovery key: " + err.Error(),
+		})
+		return
+	}
+	keptKeyIds := make([]int, 0)
+	for _, previous := range activeKeys {
+		if previous.Purpose != model.EncryptionKeyPurposeRecovery {
+			continue
+		}
+		if _, err := model.RevokeUserEncryptionKey(previous.Id, userId, false); err != nil {
+			keptKeyIds = append(keptKeyIds, previous.Id)
+			if !errors.Is(err, model.ErrEncryptionKeyOrphansLogs) {
+				common.SysLog("failed to revoke previous recovery key " + strconv.Itoa(previous.Id) + ": " + err.Error())
+			}
+		}
+	}
+
+	pending, _ := model.CountConversationLogsMissingKey(userId, key.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Recovery key registered successfully",
+		"data": gin.H{
+			"key":            key,
+			"pending_rewrap": pending,
+			// previous recovery keys kept because some logs only they can open
+			"kept_key_ids": keptKeyIds,
+		},
+	})
+}
+
+// GetSealedConversationLogs returns sealed logs with the data key wrapped for
+// key_id. Decryption happens on the client; no key material is sent here.
+func GetSealedConversationLogs(c *gin.Context) {
+	userId := c.GetInt("id")
+	keyId, err := strconv.Atoi(c.Query("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "key_id is required",
+		})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	startIdx := (page - 1) * pageSize
+
+	var logs []*model.ConversationLog
+	var total int64
+	if tokenId > 0 {
+		logs, total, err = model.GetConversationLogsByTokenId(userId, tokenId, startIdx, pageSize)
+	} else {
+		logs, total, err = model.GetConversationLogsByUserId(userId, startIdx, pageSize)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to retrieve conversation logs",
+		})
+		return
+	}
+
+	logIds := make([]int, 0, len(logs))
+	for _, log := range logs {
+		logIds = append(logIds, log.Id)
+	}
+	logKeys, err := model.GetConversationLogKeysByLogIds(userId, logIds)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to retrieve wrapped keys",
+		})
+		return
+	}
+
+	items := make([]SealedConversationLogResponse, 0, len(logs))
+	for _, log := range logs {
+		if log.KeyScheme != model.ConversationLogSchemeSealed {
+			continue
+		}
+		item := SealedConversationLogResponse{
+			Id:               log.Id,
+			TokenId:          log.TokenId,
+			Model:            log.Model,
+			Source:           log.Source,
+			EncryptedData:    log.EncryptedData,
+			Nonce:            log.Nonce,
+			Timestamp:        log.Timestamp,
+			RequestId:        log.RequestId,
+			MessageCount:     log.MessageCount,
+			PromptTokens:     log.PromptTokens,
+			CompletionTokens: log.CompletionTokens,
+		}
+		for _, key := range logKeys[log.Id] {
+			if key.KeyId == keyId {
+				item.WrappedKey = key.WrappedKey
+				break
+			}
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"logs":      items,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func getActiveEncryptionKeyParam(c *gin.Context, userId int) (*model.UserEncryptionKey, bool) {
+	keyId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid key ID",
+		})
+		return nil, false
+	}
+	key, err := model.GetUserEncryptionKey(keyId, userId)
+	if err != nil || key.Status != model.EncryptionKeyStatusActive {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Encryption key not found",
+		})
+		return nil, false
+	}
+	return key, true
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	governanceSvc "github.com/QuantumNous/new-api/service/governance"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+type governancePolicyUpdateRequest struct {
+	Policies []config.GovernancePolicy `json:"policies"`
+	DryRun   *bool                     `json:"dry_run"`
+}
+
+type governanceEvaluateRequest struct {
+	// Policies are evaluated instead of the active ones when provided, so a
+	// draft can be tested before it is saved.
+	Policies     []config.GovernancePolicy `json:"policies"`
+	SubjectType  string                    `json:"subject_type"`
+	SubjectId    int                       `json:"subject_id"`
+	UserId       int                       `json:"user_id"`
+	TokenId      int                       `json:"token_id"`
+	Model        string                    `json:"model"`
+	Group        string                    `json:"group"`
+	Path         string                    `json:"path"`
+	Prompt       string                    `json:"prompt"`
+	PromptTokens int                       `json:"prompt_tokens"`
+	RPM          int                       `json:"rpm"`
+}
+
+// GetGovernancePolicies returns the configured policies and the built-in defaults.
+func GetGovernancePolicies(c *gin.Context) {
+	cfg := config.GetGovernanceConfig()
+	common.ApiSuccess(c, gin.H{
+		"enabled":          cfg.Enabled,
+		"dry_run":          cfg.DryRun,
+		"policies":         cfg.Policies,
+		"default_policies": governanceSvc.DefaultPolicies(),
+		"using_defaults":   len(cfg.Policies) == 0,
+	})
+}
+
+// UpdateGovernancePolicies replaces the policy list. An empty list restores
+// the built-in defaults. Changes take effect on the next request.
+func UpdateGovernancePolicies(c *gin.Context) {
+	var req governancePolicyUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request payload",
+		})
+		return
+	}
+	for i := range req.Policies {
+		req.Policies[i].Name = strings.TrimSpace(req.Policies[i].Name)
+	}
+	if err := governanceSvc.ValidatePolicies(req.Policies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	cfg := config.GetGovernanceConfig()
+	if req.Policies == nil {
+		req.Policies = []config.GovernancePolicy{}
+	}
+	cfg.Policies = req.Policies
+	if req.DryRun != nil {
+		cfg.DryRun = *req.DryRun
+	}
+	if err := persistFeatureConfig("governance", cfg); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.SysLog(fmt.Sprintf("governance policies updated: %d policies, dry_run=%t", len(cfg.Policies), cfg.DryRun))
+	GetGovernancePolicies(c)
+}
+
+// GetGovernancePolicyStats returns per-policy hit counters since process start.
+func GetGovernancePolicyStats(c *gin.Context) {
+	common.ApiSuccess(c, governanceSvc.GetPolicyHitStats())
+}
+
+// ResetGovernancePolicyStats clears the per-policy hit counters.
+func ResetGovernancePolicyStats(c *gin.Context) {
+	governanceSvc.ResetPolicyHitStats()
+	common.ApiSuccess(c, nil)
+}
+
+// EvaluateGovernancePolicies runs the policies against a sample request
+// without recording hits, consuming throttle budgets or enforcing anything.
+func EvaluateGovernancePolicies(c *gin.Context) {
+	var req governanceEvaluateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request payload",
+		})
+		return
+	}
+	policies := req.Policies
+	if len(policies) == 0 {
+		policies = governanceSvc.ActivePolicies()
+	} else if err := governanceSvc.ValidatePolicies(policies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	subjectType := req.SubjectType
+	if subjectType == "" {
+		subjectType = common.AssignmentSubjectTypeUser
+	}
+	subjectKey := subjectType
+	if req.SubjectId > 0 {
+		subjectKey = fmt.Sprintf("%s:%d", subjectType, req.SubjectId)
+	}
+
+	// The RPM detector is synthesised from the supplied rate so the sample
+	// does not count against the subject's real request window.
+	detectors := map[string]governanceSvc.DetectorResult{}
+	rpmResult := governanceSvc.DetectorResult{Metadata: map[string]string{"count": fmt.Sprint(req.RPM)}}
+	if threshold := governanceSvc.AbuseRPMThreshold(); threshold > 0 && req.RPM > threshold {
+		rpmResult.Triggered = true
+		rpmResult.Severity = governanceSvc.SeverityMalicious
+		rpmResult.Reasons = []string{"high_rpm"}
+	}
+	detectors["high_rpm"] = rpmResult
+	if prompt := strings.TrimSpace(req.Prompt); prompt != "" {
+		detectors["prompt_sanity"] = governanceSvc.DetectPromptSanity(prompt)
+		detectors["keyword_policy"] = governanceSvc.DetectKeywordPolicy(prompt)
+	}
+
+	facts := &governanceSvc.PolicyFacts{
+		SubjectType:  subjectType,
+		SubjectId:    req.SubjectId,
+		SubjectKey:   subjectKey,
+		UserId:       req.UserId,
+		TokenId:      req.TokenId,
+		Model:        req.Model,
+		Group:        req.Group,
+		Path:         req.Path,
+		Prompt:       req.Prompt,
+		PromptTokens: req.PromptTokens,
+		Detectors:    detectors,
+	}
+	decision := governanceSvc.SimulatePolicies(policies, facts)
+	common.ApiSuccess(c, gin.H{
+		"matches":      decision.Matches,
+		"enforced":     decision.Enforced(),
+		"detectors":    detectors,
+		"evaluated_at": time.Now().Unix(),
+	})
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/invoice"
+
+	"github.com/gin-gonic/gin"
+)
+
+type topUpInvoiceRequest struct {
+	TradeNo string `json:"trade_no"`
+}
+
+type statementRequest struct {
+	// Month is the statement month as 2006-01.
+	Month string `json:"month"`
+}
+
+// GetSelfInvoices lists the user's invoices and statements; ?kind=topup or
+// statement filters them.
+func GetSelfInvoices(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	invoices, total, err := model.GetUserInvoices(c.GetInt("id"), c.Query("kind"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(invoices)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// CreateSelfTopUpInvoice issues the invoice of one of the user's paid orders.
+func CreateSelfTopUpInvoice(c *gin.Context) {
+	var req topUpInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	topUp := model.GetTopUpByTradeNo(req.TradeNo)
+	if topUp == nil || topUp.UserId != c.GetInt("id") {
+		common.ApiErrorMsg(c, "充值订单不存在")
+		return
+	}
+	issued, err := service.GenerateTopUpInvoice(topUp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, issued)
+}
+
+// CreateSelfStatement issues the user's statement of a past month.
+func CreateSelfStatement(c *gin.Context) {
+	var req statementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	month, err := time.Parse("2006-01", req.Month)
+	if err != nil {
+		common.ApiErrorMsg(c, "月份格式应为 YYYY-MM")
+		return
+	}
+	issued, err := service.GenerateMonthlyStatement(c.GetInt("id"), month)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, issued)
+}
+
+func getSelfInvoice(c *gin.Context) (*model.Invoice, bool) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	issued, err := model.GetUserInvoiceById(c.GetInt("id"), id)
+	if err != nil {
+		common.ApiErrorMsg(c, "发票不存在")
+		return nil, false
+	}
+	return issued, true
+}
+
+// DownloadSelfInvoice renders a document as ?format=pdf (default) or csv.
+func DownloadSelfInvoice(c *gin.Context) {
+	issued, ok := getSelfInvoice(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", invoice.FormatPDF)
+	data, err := invoice.Render(issued, format)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", invoice.FileName(issued, format)))
+	c.Data(200, invoice.ContentType(format), data)
+}
+
+// EmailSelfInvoice mails a document to the user again.
+func EmailSelfInvoice(c *gin.Context) {
+	issued, ok := getSelfInvoice(c)
+	if !ok {
+		return
+	}
+	user, err := model.GetUserById(c.GetInt("id"), false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := service.EmailInvoice(issued, user); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
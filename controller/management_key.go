@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxManagementKeysPerUser bounds the keys a user can hold.
+const maxManagementKeysPerUser = 50
+
+type managementKeyRequest struct {
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	AllowIps    string   `json:"allow_ips"`
+	ExpiredTime int64    `json:"expired_time"`
+}
+
+// apply validates the request and copies it onto key.
+func (req *managementKeyRequest) apply(key *model.ManagementKey) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		return errors.New("管理密钥名称不能为空且不能超过 64 个字符")
+	}
+	scopes, err := model.NormalizeManagementKeyScopes(req.Scopes)
+	if err != nil {
+		return err
+	}
+	allowIps, err := model.NormalizeManagementKeyIps(req.AllowIps)
+	if err != nil {
+		return err
+	}
+	if req.ExpiredTime == 0 {
+		req.ExpiredTime = -1
+	}
+	if req.ExpiredTime != -1 && req.ExpiredTime <= common.GetTimestamp() {
+		return errors.New("过期时间必须晚于当前时间，或设置为永不过期")
+	}
+	key.Name = req.Name
+	key.SetScopes(scopes)
+	key.AllowIps = allowIps
+	key.ExpiredTime = req.ExpiredTime
+	return nil
+}
+
+func GetManagementKeyScopes(c *gin.Context) {
+	common.ApiSuccess(c, gin.H{
+		"resources": model.ManagementKeyResources,
+		"actions":   []string{model.AdminActionRead, model.AdminActionWrite},
+	})
+}
+
+func GetManagementKeys(c *gin.Context) {
+	keys, err := model.GetUserManagementKeys(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, keys)
+}
+
+// CreateManagementKey returns the new key once; only its hash is stored.
+func CreateManagementKey(c *gin.Context) {
+	req := managementKeyRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userId := c.GetInt("id")
+	keys, err := model.GetUserManagementKeys(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if len(keys) >= maxManagementKeysPerUser {
+		common.ApiErrorMsg(c, "管理密钥数量已达上限 "+strconv.Itoa(maxManagementKeysPerUser))
+		return
+	}
+	key := &model.ManagementKey{UserId: userId}
+	if err := req.apply(key); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plainKey, err := model.GenerateManagementKey()
+	if err != nil {
+		common.SysLog("failed to generate management key: " + err.Error())
+		common.ApiErrorMsg(c, "生成失败")
+		return
+	}
+	if err := key.Insert(plainKey); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"key":            plainKey,
+		"management_key": key,
+	})
+}
+
+func UpdateManagementKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	key, err := model.GetUserManagementKeyById(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	req := managementKeyRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := req.apply(key); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := key.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, key)
+}
+
+func DeleteManagementKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	key, err := model.GetUserManagementKeyById(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := key.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
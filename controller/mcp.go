@@ -0,0 +1,308 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/mcp"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mcpCodeForbidden is returned for calls the token is not granted or cannot
+// pay for.
+const mcpCodeForbidden = -32001
+
+var mcpSupportedVersions = []string{mcp.ProtocolVersion, "2025-03-26", "2024-11-05"}
+
+// McpGateway serves the unified catalog as a stateless streamable HTTP MCP
+// server: each POST carries one message or a batch and gets a JSON reply.
+func McpGateway(c *gin.Context) {
+	if !config.GetMcpConfig().Enabled {
+		c.JSON(http.StatusNotFound, mcp.NewError(nil, mcp.CodeInvalidRequest, "mcp gateway is disabled"))
+		return
+	}
+	if c.Request.Method != http.MethodPost {
+		c.Header("Allow", http.MethodPost)
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, mcp.NewError(nil, mcp.CodeParseError, err.Error()))
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var requests []mcp.Request
+		if err := json.Unmarshal(body, &requests); err != nil {
+			c.JSON(http.StatusBadRequest, mcp.NewError(nil, mcp.CodeParseError, err.Error()))
+			return
+		}
+		responses := make([]*mcp.Response, 0, len(requests))
+		for i := range requests {
+			if response := handleMcpRequest(c, &requests[i]); response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) == 0 {
+			c.Status(http.StatusAccepted)
+			return
+		}
+		c.JSON(http.StatusOK, responses)
+		return
+	}
+	var request mcp.Request
+	if err := json.Unmarshal(body, &request); err != nil {
+		c.JSON(http.StatusBadRequest, mcp.NewError(nil, mcp.CodeParseError, err.Error()))
+		return
+	}
+	response := handleMcpRequest(c, &request)
+	if response == nil {
+		c.Status(http.StatusAccepted)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func handleMcpRequest(c *gin.Context, request *mcp.Request) *mcp.Response {
+	if request.IsNotification() {
+		return nil
+	}
+	switch request.Method {
+	case "initialize":
+		var params mcp.InitializeParams
+		_ = json.Unmarshal(request.Params, &params)
+		version := mcp.ProtocolVersion
+		for _, supported := range mcpSupportedVersions {
+			if params.ProtocolVersion == supported {
+				version = supported
+			}
+		}
+		return mcp.NewResult(request.ID, mcp.InitializeResult{
+			ProtocolVersion: version,
+			Capabilities:    map[string]any{"tools": map[string]any{"listChanged": false}},
+			ServerInfo:      mcp.Implementation{Name: "new-api", Version: common.Version},
+			Instructions:    service.McpInstructions(c),
+		})
+	case "ping":
+		return mcp.NewResult(request.ID, gin.H{})
+	case "tools/list":
+		tools, _ := service.McpCatalog(c)
+		return mcp.NewResult(request.ID, mcp.ListToolsResult{Tools: tools})
+	case "tools/call":
+		var params mcp.CallToolParams
+		if err := json.Unmarshal(request.Params, &params); err != nil || params.Name == "" {
+			return mcp.NewError(request.ID, mcp.CodeInvalidParams, "tool name is required")
+		}
+		result, err := service.CallMcpTool(c, params.Name, params.Arguments)
+		if err != nil {
+			return mcpCallError(request.ID, err)
+		}
+		return &mcp.Response{JSONRPC: "2.0", ID: request.ID, Result: result}
+	case "resources/list":
+		return mcp.NewResult(request.ID, gin.H{"resources": []any{}})
+	case "resources/templates/list":
+		return mcp.NewResult(request.ID, gin.H{"resourceTemplates": []any{}})
+	case "prompts/list":
+		return mcp.NewResult(request.ID, gin.H{"prompts": []any{}})
+	default:
+		return mcp.NewError(request.ID, mcp.CodeMethodNotFound, "method not found: "+request.Method)
+	}
+}
+
+// mcpCallError maps gateway refusals to JSON-RPC errors and upstream failures
+// to an isError tool result the model can read.
+func mcpCallError(id json.RawMessage, err error) *mcp.Response {
+	var rpcError *mcp.Error
+	switch {
+	case errors.Is(err, service.ErrMcpToolNotFound):
+		return mcp.NewError(id, mcp.CodeInvalidParams, err.Error())
+	case errors.Is(err, service.ErrMcpToolForbidden), errors.Is(err, service.ErrMcpInsufficientQuota),
+		errors.Is(err, service.ErrMcpSpendingBudgetExceeded), errors.Is(err, service.ErrMcpStdioDisabled):
+		return mcp.NewError(id, mcpCodeForbidden, err.Error())
+	case errors.As(err, &rpcError):
+		return &mcp.Response{JSONRPC: "2.0", ID: id, Error: rpcError}
+	default:
+		return mcp.NewResult(id, gin.H{
+			"content": []gin.H{{"type": "text", "text": "tool call failed: " + err.Error()}},
+			"isError": true,
+		})
+	}
+}
+
+// mcpRestDisabled answers the REST endpoints with 404 while the gateway is off.
+func mcpRestDisabled(c *gin.Context) bool {
+	if config.GetMcpConfig().Enabled {
+		return false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": gin.H{"message": "mcp gateway is disabled", "type": "invalid_request_error"}})
+	return true
+}
+
+// GetMcpTools lists the token's catalog for clients that do not speak MCP.
+func GetMcpTools(c *gin.Context) {
+	if mcpRestDisabled(c) {
+		return
+	}
+	tools, errs := service.McpCatalog(c)
+	c.JSON(http.StatusOK, gin.H{
+		"tools":  tools,
+		"errors": errs,
+	})
+}
+
+func CallMcpToolRest(c *gin.Context) {
+	if mcpRestDisabled(c) {
+		return
+	}
+	var params mcp.CallToolParams
+	if err := common.UnmarshalBodyReusable(c, &params); err != nil || params.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "tool name is required", "type": "invalid_request_error"}})
+		return
+	}
+	result, err := service.CallMcpTool(c, params.Name, params.Arguments)
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, service.ErrMcpToolNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, service.ErrMcpToolForbidden), errors.Is(err, service.ErrMcpInsufficientQuota),
+			errors.Is(err, service.ErrMcpSpendingBudgetExceeded), errors.Is(err, service.ErrMcpStdioDisabled):
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": gin.H{"message": err.Error(), "type": "mcp_error"}})
+		return
+	}
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func GetMcpServers(c *gin.Context) {
+	servers, err := model.GetAllMcpServers()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    servers,
+	})
+}
+
+func CreateMcpServer(c *gin.Context) {
+	server := model.McpServer{}
+	if err := c.ShouldBindJSON(&server); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	server.Id = 0
+	server.Name = strings.TrimSpace(server.Name)
+	if server.Transport == "" {
+		server.Transport = model.McpTransportHTTP
+	}
+	if server.Status == 0 {
+		server.Status = model.McpServerStatusEnabled
+	}
+	if err := server.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := server.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    server,
+	})
+}
+
+func UpdateMcpServer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	server, err := model.GetMcpServerById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := c.ShouldBindJSON(server); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	server.Id = id
+	server.Name = strings.TrimSpace(server.Name)
+	if err := server.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := server.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.ForgetMcpServer(id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    server,
+	})
+}
+
+func DeleteMcpServer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	server, err := model.GetMcpServerById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := server.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.ForgetMcpServer(id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetMcpServerTools lists a server's upstream tools; ?refresh=true bypasses
+// the catalog cache.
+func GetMcpServerTools(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	server, err := model.GetMcpServerById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	tools, err := service.ListMcpServerTools(c.Request.Context(), server, c.Query("refresh") == "true")
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tools,
+	})
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMcpRestEndpointsFollowGatewaySwitch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.GetMcpConfig()
+	old := cfg.Enabled
+	cfg.Enabled = false
+	defer func() { cfg.Enabled = old }()
+
+	router := gin.New()
+	router.GET("/v1/mcp/tools", GetMcpTools)
+	router.POST("/v1/mcp/tools/call", CallMcpToolRest)
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/v1/mcp/tools", nil),
+		httptest.NewRequest(http.MethodPost, "/v1/mcp/tools/call", strings.NewReader(`{"name":"files__read","arguments":{}}`)),
+	}
+	for _, req := range requests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusNotFound || !strings.Contains(recorder.Body.String(), "mcp gateway is disabled") {
+			t.Fatalf("%s %s while disabled: %d %s", req.Method, req.URL.Path, recorder.Code, recorder.Body.String())
+		}
+	}
+}
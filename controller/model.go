@@ -204,6 +204,20 @@ func ListModels(c *gin.Context, modelType int) {
 			"models":        userGeminiModels,
 			"nextPageToken": nil,
 		})
+	case constant.ChannelTypeOllama:
+		userOllamaModels := make([]dto.OllamaModel, len(userOpenAiModels))
+		for i, model := range userOpenAiModels {
+			userOllamaModels[i] = dto.OllamaModel{
+				Name:       model.Id,
+				Model:      model.Id,
+				ModifiedAt: time.Unix(int64(model.Created), 0).UTC().Format(time.RFC3339),
+				Details: dto.OllamaModelDetails{
+					Format:   "gguf",
+					Families: []string{},
+				},
+			}
+		}
+		c.JSON(200, dto.OllamaTagsResponse{Models: userOllamaModels})
 	default:
 		c.JSON(200, gin.H{
 			"success": true,
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ollamaCompatibleVersion is reported by /api/version; clients use it to gate
+// features such as tool calling and structured outputs.
+const ollamaCompatibleVersion = "0.9.0"
+
+func OllamaVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"version": ollamaCompatibleVersion,
+	})
+}
+
+// OllamaShow answers /api/show with the little metadata a gateway knows;
+// model details live behind the channels.
+func OllamaShow(c *gin.Context) {
+	var request struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	body, err := common.GetRequestBody(c)
+	if err == nil {
+		err = common.Unmarshal(body, &request)
+	}
+	modelName := common.GetStringIfEmpty(request.Model, request.Name)
+	if err != nil || modelName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "model is required",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"modelfile":  "",
+		"parameters": "",
+		"template":   "",
+		"details": dto.OllamaModelDetails{
+			Format:   "gguf",
+			Families: []string{},
+		},
+		"model_info":   gin.H{},
+		"capabilities": []string{"completion", "tools"},
+	})
+}
+
+// OllamaPs reports no locally loaded models.
+func OllamaPs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"models": []dto.OllamaModel{},
+	})
+}
+
+func LlamaCppHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
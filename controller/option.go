@@ -9,6 +9,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/config"
 	"github.com/QuantumNous/new-api/setting/console_setting"
@@ -121,6 +122,8 @@ func buildFeatureConfigResponse(sectionSet map[string]struct{}) dto.FeatureConfi
 			AbuseRPMThreshold: cfg.AbuseRPMThreshold,
 			RerouteModelAlias: cfg.RerouteModelAlias,
 			FlagTTLHours:      cfg.FlagTTLHours,
+			DryRun:            cfg.DryRun,
+			PolicyCount:       len(cfg.Policies),
 		}
 	}
 
@@ -221,6 +224,9 @@ func UpdateFeatureOptions(c *gin.Context) {
 		if req.Governance.FlagTTLHours != nil {
 			cfg.FlagTTLHours = *req.Governance.FlagTTLHours
 		}
+		if req.Governance.DryRun != nil {
+			cfg.DryRun = *req.Governance.DryRun
+		}
 
 		if err := persistFeatureConfig("governance", cfg); err != nil {
 			common.ApiError(c, err)
@@ -256,6 +262,7 @@ func UpdateFeatureOptions(c *gin.Context) {
 		updatedSections["public_logs"] = struct{}{}
 	}
 
+	service.RecordAudit(c, "option.feature_update", "option", "features", nil, req)
 	response := buildFeatureConfigResponse(updatedSections)
 	common.ApiSuccess(c, response)
 }
@@ -264,7 +271,7 @@ func GetOptions(c *gin.Context) {
 	var options []*model.Option
 	common.OptionMapRWMutex.Lock()
 	for k, v := range common.OptionMap {
-		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Key") {
+		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Key") || k == "saml.sp_private_key" {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -323,6 +330,15 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "saml.enabled":
+		samlSettings := system_setting.GetSAMLSettings()
+		if option.Value == "true" && samlSettings.IdpMetadataUrl == "" && samlSettings.IdpMetadataXml == "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无法启用 SAML 登录，请先填入 SAML IdP 元数据地址或元数据 XML！",
+			})
+			return
+		}
 	case "LinuxDOOAuthEnabled":
 		if option.Value == "true" && common.LinuxDOClientId == "" {
 			c.JSON(http.StatusOK, gin.H{
@@ -446,11 +462,19 @@ func UpdateOption(c *gin.Context) {
 			return
 		}
 	}
+	common.OptionMapRWMutex.RLock()
+	previous, existed := common.OptionMap[option.Key]
+	common.OptionMapRWMutex.RUnlock()
 	err = model.UpdateOption(option.Key, option.Value.(string))
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	var before any
+	if existed {
+		before = map[string]any{option.Key: previous}
+	}
+	service.RecordAudit(c, "option.update", "option", option.Key, before, map[string]any{option.Key: option.Value})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,495 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// organizationMember loads the current user's membership of the organization
+// in the :id path parameter and checks that their role grants permission;
+// an empty permission only requires membership.
+func organizationMember(c *gin.Context, permission string) (*model.OrganizationMember, bool) {
+	orgId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	member, err := model.GetOrganizationMember(orgId, c.GetInt("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "不是该组织的成员")
+		return nil, false
+	}
+	if permission != "" && !model.OrganizationRoleCan(member.Role, permission) {
+		common.ApiErrorMsg(c, "无权进行此操作")
+		return nil, false
+	}
+	return member, true
+}
+
+func GetUserOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, orgs)
+}
+
+func CreateOrganization(c *gin.Context) {
+	org := model.Organization{}
+	if err := c.ShouldBindJSON(&org); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := org.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	cleanOrg := model.Organization{Name: org.Name, Description: org.Description}
+	if err := cleanOrg.Insert(c.GetInt("id")); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, cleanOrg)
+}
+
+func GetOrganization(c *gin.Context) {
+	member, ok := organizationMember(c, "")
+	if !ok {
+		return
+	}
+	org, err := model.GetOrganizationById(member.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, model.UserOrganization{Organization: *org, Role: member.Role})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionManage)
+	if !ok {
+		return
+	}
+	req := model.Organization{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	org, err := model.GetOrganizationById(member.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	org.Name = req.Name
+	org.Description = req.Description
+	if req.Status == model.OrganizationStatusEnabled || req.Status == model.OrganizationStatusDisabled {
+		org.Status = req.Status
+	}
+	if err := org.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := org.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func DeleteOrganization(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionManage)
+	if !ok {
+		return
+	}
+	org := model.Organization{Id: member.OrganizationId}
+	if err := org.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	member, ok := organizationMember(c, "")
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(member.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, members)
+}
+
+type updateOrganizationMemberRequest struct {
+	Role           *string `json:"role"`
+	SpendingCap    *int    `json:"spending_cap"`
+	ResetUsedQuota bool    `json:"reset_used_quota"`
+}
+
+// UpdateOrganizationMember changes a member's role (members permission) or
+// spending cap and usage (billing permission). Only owners grant or revoke
+// the owner role.
+func UpdateOrganizationMember(c *gin.Context) {
+	current, ok := organizationMember(c, "")
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	req := updateOrganizationMemberRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	target, err := model.GetOrganizationMember(current.OrganizationId, userId)
+	if err != nil {
+		common.ApiErrorMsg(c, "成员不存在")
+		return
+	}
+	if req.Role != nil && *req.Role != target.Role {
+		if err := checkOrganizationRoleChange(current, target, *req.Role); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		target.Role = *req.Role
+	}
+	if req.SpendingCap != nil || req.ResetUsedQuota {
+		if !model.OrganizationRoleCan(current.Role, model.OrganizationPermissionBilling) {
+			common.ApiErrorMsg(c, "无权进行此操作")
+			return
+		}
+		if req.SpendingCap != nil {
+			if *req.SpendingCap < 0 {
+				common.ApiErrorMsg(c, "消费上限不能为负数")
+				return
+			}
+			target.SpendingCap = *req.SpendingCap
+		}
+		if req.ResetUsedQuota {
+			target.UsedQuota = 0
+		}
+	}
+	if err := target.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, target)
+}
+
+func checkOrganizationRoleChange(current *model.OrganizationMember, target *model.OrganizationMember, role string) error {
+	if !model.OrganizationRoleCan(current.Role, model.OrganizationPermissionMembers) {
+		return errors.New("无权进行此操作")
+	}
+	if !model.IsValidOrganizationRole(role) {
+		return errors.New("无效的组织角色")
+	}
+	if (role == model.OrganizationRoleOwner || target.Role == model.OrganizationRoleOwner) && current.Role != model.OrganizationRoleOwner {
+		return errors.New("只有所有者可以变更所有者角色")
+	}
+	if target.Role == model.OrganizationRoleOwner {
+		owners, err := model.CountOrganizationOwners(target.OrganizationId)
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return errors.New("不能降级组织的最后一个所有者")
+		}
+	}
+	return nil
+}
+
+// RemoveOrganizationMember removes a member; any member may leave.
+func RemoveOrganizationMember(c *gin.Context) {
+	current, ok := organizationMember(c, "")
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	target, err := model.GetOrganizationMember(current.OrganizationId, userId)
+	if err != nil {
+		common.ApiErrorMsg(c, "成员不存在")
+		return
+	}
+	if target.UserId != current.UserId {
+		if !model.OrganizationRoleCan(current.Role, model.OrganizationPermissionMembers) {
+			common.ApiErrorMsg(c, "无权进行此操作")
+			return
+		}
+		if target.Role == model.OrganizationRoleOwner && current.Role != model.OrganizationRoleOwner {
+			common.ApiErrorMsg(c, "只有所有者可以移除所有者")
+			return
+		}
+	}
+	if err := target.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationInvitations(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionMembers)
+	if !ok {
+		return
+	}
+	invitations, err := model.GetOrganizationInvitations(member.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, invitations)
+}
+
+func CreateOrganizationInvitation(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionMembers)
+	if !ok {
+		return
+	}
+	req := model.OrganizationInvitation{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !model.IsValidOrganizationRole(req.Role) {
+		common.ApiErrorMsg(c, "无效的组织角色")
+		return
+	}
+	if req.Role == model.OrganizationRoleOwner && member.Role != model.OrganizationRoleOwner {
+		common.ApiErrorMsg(c, "只有所有者可以邀请所有者")
+		return
+	}
+	invitation := model.OrganizationInvitation{
+		OrganizationId: member.OrganizationId,
+		Email:          req.Email,
+		Role:           req.Role,
+		InvitedBy:      member.UserId,
+		ExpiresAt:      req.ExpiresAt,
+	}
+	if err := invitation.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, invitation)
+}
+
+func RevokeOrganizationInvitation(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionMembers)
+	if !ok {
+		return
+	}
+	invitationId, _ := strconv.Atoi(c.Param("invitation_id"))
+	if err := model.RevokeOrganizationInvitation(member.OrganizationId, invitationId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func AcceptOrganizationInvitation(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		common.ApiErrorMsg(c, "邀请码不能为空")
+		return
+	}
+	userId := c.GetInt("id")
+	email, err := model.GetUserEmail(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	member, err := model.AcceptOrganizationInvitation(req.Code, userId, email)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, member)
+}
+
+// TransferOrganizationQuota funds the pool from the current user's quota,
+// or withdraws to it with a negative amount.
+func TransferOrganizationQuota(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionBilling)
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount int `json:"amount"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.TransferOrganizationQuota(member.OrganizationId, member.UserId, req.Amount); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(member.UserId, model.LogTypeManage, fmt.Sprintf("组织 %d 额度划转 %s", member.OrganizationId, logger.LogQuota(req.Amount)))
+	common.ApiSuccess(c, nil)
+}
+
+// GetOrganizationTokens lists organization tokens; developers only see
+// their own.
+func GetOrganizationTokens(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionTokens)
+	if !ok {
+		return
+	}
+	userId := 0
+	if member.Role == model.OrganizationRoleDeveloper {
+		userId = member.UserId
+	}
+	pageInfo := common.GetPageQuery(c)
+	tokens, total, err := model.GetOrganizationTokens(member.OrganizationId, userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for _, token := range tokens {
+		if token.UserId != member.UserId {
+			token.Key = ""
+		}
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(tokens)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// AddOrganizationToken creates a token owned by the current user that is
+// charged to the organization pool. It is managed afterwards through the
+// regular token endpoints.
+func AddOrganizationToken(c *gin.Context) {
+	member, ok := organizationMember(c, model.OrganizationPermissionTokens)
+	if !ok {
+		return
+	}
+	token := model.Token{}
+	if err := c.ShouldBindJSON(&token); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if len(token.Name) > 30 {
+		common.ApiErrorMsg(c, "令牌名称过长")
+		return
+	}
+	key, err := common.GenerateKey()
+	if err != nil {
+		common.ApiErrorMsg(c, "生成令牌失败")
+		common.SysLog("failed to generate token key: " + err.Error())
+		return
+	}
+	cleanToken := model.Token{
+		UserId:             member.UserId,
+		OrganizationId:     member.OrganizationId,
+		Name:               token.Name,
+		Key:                key,
+		CreatedTime:        common.GetTimestamp(),
+		AccessedTime:       common.GetTimestamp(),
+		ExpiredTime:        token.ExpiredTime,
+		RemainQuota:        token.RemainQuota,
+		UnlimitedQuota:     token.UnlimitedQuota,
+		ModelLimitsEnabled: token.ModelLimitsEnabled,
+		ModelLimits:        token.ModelLimits,
+		AllowIps:           token.AllowIps,
+		Group:              token.Group,
+	}
+	if err := cleanToken.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// organizationUsageScope returns the member whose usage the current user
+// may see: everyone with the usage permission, otherwise only themselves.
+func organizationUsageScope(member *model.OrganizationMember, requested int) int {
+	if model.OrganizationRoleCan(member.Role, model.OrganizationPermissionUsage) {
+		return requested
+	}
+	return member.UserId
+}
+
+func GetOrganizationUsage(c *gin.Context) {
+	member, ok := organizationMember(c, "")
+	if !ok {
+		return
+	}
+	requested, _ := strconv.Atoi(c.Query("user_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	usages, err := model.GetOrganizationUsage(member.OrganizationId, organizationUsageScope(member, requested), c.Query("group_by"), startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, usages)
+}
+
+func GetOrganizationLogs(c *gin.Context) {
+	member, ok := organizationMember(c, "")
+	if !ok {
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	requested, _ := strconv.Atoi(c.Query("user_id"))
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	modelName := c.Query("model_name")
+	logs, total, err := model.GetOrganizationLogs(member.OrganizationId, organizationUsageScope(member, requested), logType, startTimestamp, endTimestamp, modelName, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	orgs, total, err := model.GetAllOrganizations(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(orgs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// AdjustOrganizationQuota lets administrators add to or take from a pool.
+func AdjustOrganizationQuota(c *gin.Context) {
+	orgId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req struct {
+		Delta int `json:"delta"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetOrganizationById(orgId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.AdjustOrganizationQuota(orgId, req.Delta); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("管理员调整组织 %d 额度 %s", orgId, logger.LogQuota(req.Delta)))
+	common.ApiSuccess(c, nil)
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupOrganizationTest creates an organization with one member per role;
+// the returned map holds their user ids.
+func setupOrganizationTest(t *testing.T) (*model.Organization, map[string]int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	tables := []interface{}{&model.User{}, &model.Token{}, &model.Organization{}, &model.OrganizationMember{}}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	for _, table := range tables {
+		db.Unscoped().Where("1 = 1").Delete(table)
+	}
+	oldDB := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = oldDB })
+
+	org := &model.Organization{Name: "Acme", Status: model.OrganizationStatusEnabled}
+	if err := db.Create(org).Error; err != nil {
+		t.Fatal(err)
+	}
+	users := make(map[string]int)
+	for i, role := range []string{model.OrganizationRoleOwner, model.OrganizationRoleAdmin, model.OrganizationRoleDeveloper, model.OrganizationRoleBilling, model.OrganizationRoleViewer} {
+		userId := i + 1
+		if err := db.Create(&model.OrganizationMember{OrganizationId: org.Id, UserId: userId, Role: role}).Error; err != nil {
+			t.Fatal(err)
+		}
+		users[role] = userId
+	}
+	return org, users
+}
+
+// callOrganizationMember runs handler as userId against the member targetId
+// and reports whether it succeeded.
+func callOrganizationMember(t *testing.T, handler gin.HandlerFunc, method string, orgId int, userId int, targetId int, body string) bool {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(orgId)}, {Key: "user_id", Value: strconv.Itoa(targetId)}}
+	c.Set("id", userId)
+	handler(c)
+	var response struct {
+		Success bool `json:"success"`
+	}
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode %s: %v", recorder.Body.String(), err)
+	}
+	return response.Success
+}
+
+func TestCheckOrganizationRoleChange(t *testing.T) {
+	org, users := setupOrganizationTest(t)
+	member := func(role string) *model.OrganizationMember {
+		return &model.OrganizationMember{OrganizationId: org.Id, UserId: users[role], Role: role}
+	}
+	cases := []struct {
+		name    string
+		current string
+		target  string
+		role    string
+		allowed bool
+	}{
+		{"admin promotes developer", model.OrganizationRoleAdmin, model.OrganizationRoleDeveloper, model.OrganizationRoleBilling, true},
+		{"admin grants owner", model.OrganizationRoleAdmin, model.OrganizationRoleDeveloper, model.OrganizationRoleOwner, false},
+		{"admin demotes owner", model.OrganizationRoleAdmin, model.OrganizationRoleOwner, model.OrganizationRoleViewer, false},
+		{"billing changes roles", model.OrganizationRoleBilling, model.OrganizationRoleViewer, model.OrganizationRoleDeveloper, false},
+		{"unknown role", model.OrganizationRoleOwner, model.OrganizationRoleViewer, "guest", false},
+		{"owner grants owner", model.OrganizationRoleOwner, model.OrganizationRoleAdmin, model.OrganizationRoleOwner, true},
+		{"last owner steps down", model.OrganizationRoleOwner, model.OrganizationRoleOwner, model.OrganizationRoleAdmin, false},
+	}
+	for _, tc := range cases {
+		err := checkOrganizationRoleChange(member(tc.current), member(tc.target), tc.role)
+		if (err == nil) != tc.allowed {
+			t.Errorf("%s: err = %v, want allowed %v", tc.name, err, tc.allowed)
+		}
+	}
+}
+
+func TestUpdateOrganizationMember(t *testing.T) {
+	org, users := setupOrganizationTest(t)
+	developer := users[model.OrganizationRoleDeveloper]
+
+	// spending caps need the billing permission, roles the members permission
+	if callOrganizationMember(t, UpdateOrganizationMember, http.MethodPut, org.Id, users[model.OrganizationRoleAdmin], developer, `{"spending_cap": 500}`) {
+		t.Error("admin set a spending cap")
+	}
+	if !callOrganizationMember(t, UpdateOrganizationMember, http.MethodPut, org.Id, users[model.OrganizationRoleBilling], developer, `{"spending_cap": 500}`) {
+		t.Error("billing could not set a spending cap")
+	}
+	if callOrganizationMember(t, UpdateOrganizationMember, http.MethodPut, org.Id, users[model.OrganizationRoleBilling], developer, `{"spending_cap": -1}`) {
+		t.Error("accepted a negative spending cap")
+	}
+	if callOrganizationMember(t, UpdateOrganizationMember, http.MethodPut, org.Id, users[model.OrganizationRoleBilling], developer, `{"role": "admin"}`) {
+		t.Error("billing changed a role")
+	}
+	if !callOrganizationMember(t, UpdateOrganizationMember, http.MethodPut, org.Id, users[model.OrganizationRoleAdmin], developer, `{"role": "viewer"}`) {
+		t.Error("admin could not change a role")
+	}
+	member, err := model.GetOrganizationMember(org.Id, developer)
+	if err != nil || member.Role != model.OrganizationRoleViewer || member.SpendingCap != 500 {
+		t.Fatalf("member = %+v, %v", member, err)
+	}
+	if callOrganizationMember(t, UpdateOrganizationMember, http.MethodPut, org.Id, 99, developer, `{"role": "admin"}`) {
+		t.Error("a non-member changed a role")
+	}
+}
+
+func TestRemoveOrganizationMember(t *testing.T) {
+	org, users := setupOrganizationTest(t)
+	owner := users[model.OrganizationRoleOwner]
+	admin := users[model.OrganizationRoleAdmin]
+	viewer := users[model.OrganizationRoleViewer]
+
+	if callOrganizationMember(t, RemoveOrganizationMember, http.MethodDelete, org.Id, viewer, admin, "") {
+		t.Error("viewer removed another member")
+	}
+	if callOrganizationMember(t, RemoveOrganizationMember, http.MethodDelete, org.Id, admin, owner, "") {
+		t.Error("admin removed the owner")
+	}
+	if callOrganizationMember(t, RemoveOrganizationMember, http.MethodDelete, org.Id, owner, owner, "") {
+		t.Error("the last owner left")
+	}
+	if !callOrganizationMember(t, RemoveOrganizationMember, http.MethodDelete, org.Id, viewer, viewer, "") {
+		t.Error("viewer could not leave")
+	}
+	if !callOrganizationMember(t, RemoveOrganizationMember, http.MethodDelete, org.Id, admin, users[model.OrganizationRoleDeveloper], "") {
+		t.Error("admin could not remove a developer")
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil || len(members) != 3 {
+		t.Errorf("members left = %d, %v", len(members), err)
+	}
+}
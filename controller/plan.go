@@ -4,11 +4,13 @@ import (
     "encoding/json"
     "net/http"
     "strconv"
+    "strings"
     "time"
 
     "github.com/QuantumNous/new-api/common"
     "github.com/QuantumNous/new-api/dto"
     "github.com/QuantumNous/new-api/model"
+    "github.com/QuantumNous/new-api/service"
 
     "github.com/gin-gonic/gin"
 )
@@ -66,7 +68,8 @@ func CreatePlan(c *gin.Context) {
         TokenLimit:        req.TokenLimit,
         ValidityDays:      req.ValidityDays,
         IsActive:          true,
-        IsPublic:          false,
+        IsPublic:          req.IsPublic,
+        StripePriceId:     strings.TrimSpace(req.StripePriceId),
     }
 
     if req.RolloverPolicy != "" {
@@ -93,6 +96,7 @@ func CreatePlan(c *gin.Context) {
         common.ApiError(c, err)
         return
     }
+    service.RecordAudit(c, "plan.create", "plan", plan.Id, nil, plan)
 
     common.ApiSuccess(c, plan)
 }
@@ -124,6 +128,7 @@ func UpdatePlan(c *gin.Context) {
         return
     }
 
+    before := plan
     updates := make(map[string]interface{})
     if req.Name != nil {
         updates["name"] = *req.Name
@@ -166,6 +171,12 @@ func UpdatePlan(c *gin.Context) {
     if req.ValidityDays != nil {
         updates["validity_days"] = *req.ValidityDays
     }
+    if req.IsPublic != nil {
+        updates["is_public"] = *req.IsPublic
+    }
+    if req.StripePriceId != nil {
+        updates["stripe_price_id"] = strings.TrimSpace(*req.StripePriceId)
+    }
 
     err = model.DB.Model(&plan).Updates(updates).Error
     if err != nil {
@@ -178,6 +189,7 @@ func UpdatePlan(c *gin.Context) {
         common.ApiError(c, err)
         return
     }
+    service.RecordAudit(c, "plan.update", "plan", plan.Id, before, plan)
 
     common.ApiSuccess(c, plan)
 }
@@ -205,6 +217,7 @@ func DeletePlan(c *gin.Context) {
         common.ApiError(c, err)
         return
     }
+    service.RecordAudit(c, "plan.delete", "plan", plan.Id, plan, nil)
 
     c.JSON(http.StatusOK, gin.H{
         "success": true,
@@ -256,7 +269,7 @@ func AssignPlan(c *gin.Context) {
 
     assignments := make([]*model.PlanAssignment, 0, len(req.Targets))
     for _, target := range req.Targets {
-        if target.SubjectType != common.AssignmentSubjectTypeUser && target.SubjectType != common.AssignmentSubjectTypeToken {
+        if target.SubjectType != common.AssignmentSubjectTypeUser && target.SubjectType != common.AssignmentSubjectTypeToken && target.SubjectType != common.AssignmentSubjectTypeOrganization {
             continue
         }
         if target.SubjectID <= 0 {
@@ -278,6 +291,7 @@ func AssignPlan(c *gin.Context) {
             continue
         }
         assignments = append(assignments, assignment)
+        service.RecordAudit(c, "plan.assign", "plan_assignment", assignment.Id, nil, assignment)
     }
 
     c.JSON(http.StatusOK, gin.H{
@@ -308,6 +322,7 @@ func DetachPlanAssignment(c *gin.Context) {
         return
     }
 
+    before := assignment
     now := time.Now().UTC()
     assignment.DeactivatedAt = &now
 
@@ -316,6 +331,7 @@ func DetachPlanAssignment(c *gin.Context) {
         common.ApiError(c, err)
         return
     }
+    service.RecordAudit(c, "plan.detach", "plan_assignment", assignment.Id, before, assignment)
 
     c.JSON(http.StatusOK, gin.H{
         "success": true,
@@ -54,7 +54,7 @@ func Playground(c *gin.Context) {
 	if newAPIError != nil {
 		return
 	}
-	//middleware.SetupContextForSelectedChannel(c, channel, playgroundRequest.Model)
+	//service.SetupContextForSelectedChannel(c, channel, playgroundRequest.Model)
 	common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
 
 	Relay(c, types.RelayFormatOpenAI)
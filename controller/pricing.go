@@ -2,6 +2,7 @@ package controller
 
 import (
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
@@ -67,6 +68,7 @@ func ResetModelRatio(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "option.model_ratio_reset", "option", "ModelRatio", nil, nil)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "重置模型倍率成功",
@@ -6,13 +6,13 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
-	"github.com/QuantumNous/new-api/middleware"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/relay"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
@@ -43,6 +43,8 @@ func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIErro
 		err = relay.RerankHelper(c, info)
 	case relayconstant.RelayModeEmbeddings:
 		err = relay.EmbeddingHelper(c, info)
+	case relayconstant.RelayModeModerations:
+		err = relay.ModerationHelper(c, info)
 	case relayconstant.RelayModeResponses:
 		err = relay.ResponsesHelper(c, info)
 	default:
@@ -93,6 +95,16 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 					"type":  "error",
 					"error": newAPIError.ToClaudeError(),
 				})
+			case types.RelayFormatOllama:
+				if helper.OllamaEndpoint(c.Request.URL.Path) == helper.OllamaEndpointLlamaCompletion {
+					c.JSON(newAPIError.StatusCode, gin.H{
+						"error": newAPIError.ToOpenAIError(),
+					})
+				} else {
+					c.JSON(newAPIError.StatusCode, gin.H{
+						"error": newAPIError.Error(),
+					})
+				}
 			default:
 				c.JSON(newAPIError.StatusCode, gin.H{
 					"error": newAPIError.ToOpenAIError(),
@@ -124,6 +136,22 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}
 
+	if counts, blocked := service.CheckPIIBlocked(c, meta.CombineText); blocked {
+		entities := make([]string, 0, len(counts))
+		for entity := range counts {
+			entities = append(entities, entity)
+		}
+		sort.Strings(entities)
+		newAPIError = types.NewErrorWithStatusCode(fmt.Errorf("request contains personal data: %s", strings.Join(entities, ", ")), types.ErrorCodePIIDetected, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		return
+	}
+
+	// 脱敏和令牌化只改写对话补全的消息，其他格式无法脱敏时直接拒绝，避免原文发往上游
+	if service.PIIRedactionRequired(c) && !relay.PIIRedactionSupported(relayInfo) {
+		newAPIError = types.NewErrorWithStatusCode(fmt.Errorf("personal data redaction is only available for chat completions"), types.ErrorCodePIIRedactionUnsupported, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		return
+	}
+
 	tokens, err := service.CountRequestToken(c, meta, relayInfo)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -156,6 +184,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
+	// 输出审核：在返回客户端前按客户端格式扫描模型输出，流式输出按滑动窗口检查
+	defer relay.OutputModeration(c, relayInfo)()
+
 	for i := 0; i <= common.RetryTimes; i++ {
 		channel, err := getChannel(c, group, originalModel, i)
 		if err != nil {
@@ -175,6 +206,8 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 			newAPIError = relay.ClaudeHelper(c, relayInfo)
 		case types.RelayFormatGemini:
 			newAPIError = geminiRelayHandler(c, relayInfo)
+		case types.RelayFormatOllama:
+			newAPIError = relay.OllamaHelper(c, relayInfo)
 		default:
 			newAPIError = relayHandler(c, relayInfo)
 		}
@@ -231,7 +264,7 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 	if channel == nil {
 		return nil, types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在（retry）", selectGroup, originalModel), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
-	newAPIError := middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+	newAPIError := service.SetupContextForSelectedChannel(c, channel, originalModel)
 	if newAPIError != nil {
 		return nil, newAPIError
 	}
@@ -414,7 +447,7 @@ func RelayTask(c *gin.Context) {
 		useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
 		c.Set("use_channel", useChannel)
 		logger.LogInfo(c, fmt.Sprintf("using channel #%d to retry (remain times %d)", channel.Id, i))
-		//middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+		//service.SetupContextForSelectedChannel(c, channel, originalModel)
 
 		requestBody, _ := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
@@ -0,0 +1,560 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/crewjam/saml"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gorm.io/gorm"
+)
+
+// The assertion consumer service receives a cross-site POST, which does not
+// carry the strict session cookie. It therefore validates the assertion and
+// hands the result to /api/oauth/saml as a short-lived signed ticket; that
+// request does carry the session and logs the user in or binds the account.
+//
+// Binding is only done from an SP-initiated login that a logged-in session
+// started: anyone holding a valid IdP account can send an IdP-initiated
+// response to another user's browser, so those tickets only log in.
+
+const samlTicketTTL = 2 * time.Minute
+
+const samlMetadataRefreshInterval = time.Hour
+
+type samlTicket struct {
+	Issuer      string   `json:"i"`
+	NameId      string   `json:"n"`
+	Username    string   `json:"u,omitempty"`
+	Email       string   `json:"e,omitempty"`
+	DisplayName string   `json:"d,omitempty"`
+	Groups      []string `json:"g,omitempty"`
+	State       string   `json:"s"`
+	// IdpInitiated is set when the response did not answer our request.
+	IdpInitiated bool  `json:"p,omitempty"`
+	ExpiresAt    int64 `json:"x"`
+}
+
+var samlIdpMetadataCache struct {
+	sync.Mutex
+	source    string
+	entity    *saml.EntityDescriptor
+	fetchedAt time.Time
+}
+
+// samlRequestId derives the AuthnRequest ID from the login state, so the
+// response can be matched to the request without server-side storage.
+func samlRequestId(state string) string {
+	return "id-" + common.HmacSha256("saml-request:"+state, common.SessionSecret)[:40]
+}
+
+func signSamlTicket(payload string) string {
+	return base64.RawURLEncoding.EncodeToString(common.HmacSha256Raw([]byte("saml-ticket:"+payload), []byte(common.SessionSecret)))
+}
+
+func encodeSamlTicket(ticket *samlTicket) (string, error) {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signSamlTicket(payload), nil
+}
+
+func decodeSamlTicket(code string) (*samlTicket, error) {
+	invalid := errors.New("SAML 登录凭证无效或已过期")
+	payload, signature, ok := strings.Cut(code, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signSamlTicket(payload))) {
+		return nil, invalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, invalid
+	}
+	ticket := &samlTicket{}
+	if err := json.Unmarshal(data, ticket); err != nil {
+		return nil, invalid
+	}
+	if ticket.ExpiresAt < time.Now().Unix() {
+		return nil, invalid
+	}
+	return ticket, nil
+}
+
+func parseSamlIdpMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	entity := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, entity); err == nil {
+		return entity, nil
+	}
+	entities := &saml.EntitiesDescriptor{}
+	if err := xml.Unmarshal(data, entities); err != nil {
+		return nil, fmt.Errorf("无法解析 SAML IdP 元数据: %w", err)
+	}
+	for i := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("SAML IdP 元数据中没有 IdP 描述")
+}
+
+func fetchSamlIdpMetadata(metadataUrl string) ([]byte, error) {
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+	res, err := client.Get(metadataUrl)
+	if err != nil {
+		common.SysLog(err.Error())
+		return nil, errors.New("无法获取 SAML IdP 元数据，请稍后重试！")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取 SAML IdP 元数据失败，状态码 %d", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// getSamlIdpMetadata returns the configured IdP metadata. Metadata fetched
+// from a URL is cached and refreshed hourly.
+func getSamlIdpMetadata() (*saml.EntityDescriptor, error) {
+	settings := system_setting.GetSAMLSettings()
+	source := settings.IdpMetadataXml
+	if source == "" {
+		source = settings.IdpMetadataUrl
+	}
+	if source == "" {
+		return nil, errors.New("未配置 SAML IdP 元数据")
+	}
+	cache := &samlIdpMetadataCache
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.entity != nil && cache.source == source &&
+		(settings.IdpMetadataXml != "" || time.Since(cache.fetchedAt) < samlMetadataRefreshInterval) {
+		return cache.entity, nil
+	}
+	data := []byte(settings.IdpMetadataXml)
+	if settings.IdpMetadataXml == "" {
+		var err error
+		if data, err = fetchSamlIdpMetadata(settings.IdpMetadataUrl); err != nil {
+			return nil, err
+		}
+	}
+	entity, err := parseSamlIdpMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	cache.source = source
+	cache.entity = entity
+	cache.fetchedAt = time.Now()
+	return entity, nil
+}
+
+// newSamlServiceProvider builds the service provider from the settings. The
+// IdP metadata is only loaded when withIdp is set, so the SP metadata can be
+// served before the IdP is configured.
+func newSamlServiceProvider(withIdp bool) (*saml.ServiceProvider, error) {
+	settings := system_setting.GetSAMLSettings()
+	metadataUrl, err := url.Parse(settings.MetadataURL())
+	if err != nil {
+		return nil, err
+	}
+	acsUrl, err := url.Parse(settings.AcsURL())
+	if err != nil {
+		return nil, err
+	}
+	sp := &saml.ServiceProvider{
+		EntityID:          settings.EntityId,
+		MetadataURL:       *metadataUrl,
+		AcsURL:            *acsUrl,
+		AllowIDPInitiated: settings.AllowIdpInitiated,
+	}
+	if sp.EntityID == "" {
+		sp.EntityID = metadataUrl.String()
+	}
+	if settings.SpCertificate != "" || settings.SpPrivateKey != "" {
+		keyPair, err := tls.X509KeyPair([]byte(settings.SpCertificate), []byte(settings.SpPrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("SAML SP 证书或私钥无效: %w", err)
+		}
+		key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("SAML SP 私钥必须是 RSA 私钥")
+		}
+		certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		sp.Key = key
+		sp.Certificate = certificate
+		sp.SignatureMethod = dsig.RSASHA256SignatureMethod
+	}
+	if withIdp {
+		if sp.IDPMetadata, err = getSamlIdpMetadata(); err != nil {
+			return nil, err
+		}
+	}
+	return sp, nil
+}
+
+// samlAttribute returns the values of the attribute whose Name or
+// FriendlyName is name.
+func samlAttribute(assertion *saml.Assertion, name string) []string {
+	if name == "" {
+		return nil
+	}
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			for _, value := range attribute.Values {
+				if value.Value != "" {
+					values = append(values, value.Value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func firstSamlAttribute(assertion *saml.Assertion, name string) string {
+	if values := samlAttribute(assertion, name); len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
+func newSamlTicket(assertion *saml.Assertion) *samlTicket {
+	settings := system_setting.GetSAMLSettings()
+	ticket := &samlTicket{
+		Issuer:      assertion.Issuer.Value,
+		Email:       firstSamlAttribute(assertion, settings.EmailAttribute),
+		DisplayName: firstSamlAttribute(assertion, settings.DisplayNameAttribute),
+		Groups:      samlAttribute(assertion, settings.GroupAttribute),
+	}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		ticket.NameId = strings.TrimSpace(assertion.Subject.NameID.Value)
+	}
+	ticket.Username = firstSamlAttribute(assertion, settings.UsernameAttribute)
+	if settings.UsernameAttribute == "" {
+		ticket.Username = ticket.NameId
+	}
+	return ticket
+}
+
+// samlInResponseTo reports whether the assertion answers requestId.
+func samlInResponseTo(assertion *saml.Assertion, requestId string) bool {
+	if assertion.Subject == nil {
+		return false
+	}
+	for _, confirmation := range assertion.Subject.SubjectConfirmations {
+		if confirmation.SubjectConfirmationData != nil && confirmation.SubjectConfirmationData.InResponseTo == requestId {
+			return true
+		}
+	}
+	return false
+}
+
+func SamlMetadata(c *gin.Context) {
+	sp, err := newSamlServiceProvider(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", data)
+}
+
+// SamlLogin starts an SP-initiated login. The state comes from
+// /api/oauth/state and is sent to the IdP as the RelayState.
+func SamlLogin(c *gin.Context) {
+	session := sessions.Default(c)
+	state := c.Query("state")
+	if state == "" || session.Get("oauth_state") == nil || state != session.Get("oauth_state").(string) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "state is empty or not same",
+		})
+		return
+	}
+	if !system_setting.GetSAMLSettings().Enabled {
+		common.ApiErrorMsg(c, "管理员未开启通过 SAML 登录以及注册")
+		return
+	}
+	sp, err := newSamlServiceProvider(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	location := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if location == "" {
+		common.ApiErrorMsg(c, "SAML IdP 不支持 HTTP-Redirect 绑定")
+		return
+	}
+	request, err := sp.MakeAuthenticationRequest(location, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	request.ID = samlRequestId(state)
+	redirectUrl, err := request.Redirect(state, sp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if session.Get("username") != nil {
+		// remember that this login was started to bind the signed-in account
+		session.Set("saml_bind_state", state)
+		if err := session.Save(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	c.Redirect(http.StatusFound, redirectUrl.String())
+}
+
+// SamlAcs is the assertion consumer service. It validates the signed
+// response and redirects the browser to the frontend with a ticket.
+func SamlAcs(c *gin.Context) {
+	if !system_setting.GetSAMLSettings().Enabled {
+		common.ApiErrorMsg(c, "管理员未开启通过 SAML 登录以及注册")
+		return
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	sp, err := newSamlServiceProvider(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	relayState := c.Request.PostForm.Get("RelayState")
+	var requestIds []string
+	if relayState != "" {
+		requestIds = []string{samlRequestId(relayState)}
+	}
+	assertion, err := sp.ParseResponse(c.Request, requestIds)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) && invalid.PrivateErr != nil {
+			common.SysLog("SAML 断言校验失败: " + invalid.PrivateErr.Error())
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "SAML 断言校验失败",
+		})
+		return
+	}
+	ticket := newSamlTicket(assertion)
+	if ticket.NameId == "" {
+		common.ApiErrorMsg(c, "SAML 断言中缺少 NameID")
+		return
+	}
+	state := relayState
+	if len(requestIds) == 0 || !samlInResponseTo(assertion, requestIds[0]) {
+		// IdP-initiated, only accepted when AllowIDPInitiated is set. Start a
+		// fresh session for the browser to redeem the ticket with.
+		state = common.GetRandomString(12)
+		session := sessions.Default(c)
+		session.Set("oauth_state", state)
+		if err := session.Save(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		ticket.IdpInitiated = true
+	}
+	ticket.State = state
+	ticket.ExpiresAt = time.Now().Add(samlTicketTTL).Unix()
+	code, err := encodeSamlTicket(ticket)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, "/oauth/saml?code="+url.QueryEscape(code)+"&state="+url.QueryEscape(state))
+}
+
+// SamlAuth redeems a ticket issued by SamlAcs. Logged-in users bind the SAML
+// account; everyone else is logged in, or registered on first login.
+func SamlAuth(c *gin.Context) {
+	session := sessions.Default(c)
+	state := c.Query("state")
+	if state == "" || session.Get("oauth_state") == nil || state != session.Get("oauth_state").(string) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "state is empty or not same",
+		})
+		return
+	}
+	if !system_setting.GetSAMLSettings().Enabled {
+		common.ApiErrorMsg(c, "管理员未开启通过 SAML 登录以及注册")
+		return
+	}
+	ticket, err := decodeSamlTicket(c.Query("code"))
+	if err == nil && ticket.State != state {
+		err = errors.New("SAML 登录凭证无效或已过期")
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	// a ticket can be redeemed once per state
+	session.Delete("oauth_state")
+	bindState, _ := session.Get("saml_bind_state").(string)
+	session.Delete("saml_bind_state")
+	if session.Get("username") != nil {
+		if ticket.IdpInitiated || bindState != state {
+			common.ApiErrorMsg(c, "请在个人设置中发起 SAML 账户绑定")
+			return
+		}
+		samlBind(c, ticket)
+		return
+	}
+	user, err := findOrCreateSamlUser(c, ticket)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	setupLogin(user, c)
+}
+
+func samlBind(c *gin.Context, ticket *samlTicket) {
+	session := sessions.Default(c)
+	userId, _ := session.Get("id").(int)
+	if err := model.BindSamlIdentity(userId, ticket.Issuer, ticket.NameId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := session.Save(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "bind",
+	})
+}
+
+func findOrCreateSamlUser(c *gin.Context, ticket *samlTicket) (*model.User, error) {
+	identity, err := model.GetSamlIdentity(ticket.Issuer, ticket.NameId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return createSamlUser(c, ticket)
+	}
+	if err != nil {
+		return nil, err
+	}
+	user := &model.User{Id: identity.UserId}
+	if err := user.FillUserById(); err != nil {
+		return nil, err
+	}
+	if err := identity.TouchLogin(); err != nil {
+		common.SysLog("failed to update saml login time: " + err.Error())
+	}
+	group := system_setting.GetSAMLSettings().MapGroup(ticket.Groups)
+	if group != "" && group != user.Group {
+		if err := model.SetUserGroup(user.Id, group); err != nil {
+			return nil, err
+		}
+		user.Group = group
+	}
+	return user, nil
+}
+
+// createSamlUser registers the user behind a ticket under the same rules
+// as Register.
+func createSamlUser(c *gin.Context, ticket *samlTicket) (*model.User, error) {
+	if !common.RegisterEnabled {
+		return nil, errors.New("管理员关闭了新用户注册")
+	}
+	email := ticket.Email
+	if utf8.RuneCountInString(email) > 50 {
+		email = ""
+	}
+	if common.EmailDomainRestrictionEnabled {
+		_, domain, _ := strings.Cut(email, "@")
+		if email == "" || !isEmailDomainAllowed(domain) {
+			return nil, errors.New("管理员启用了邮箱域名白名单，您的邮箱地址不在白名单中")
+		}
+	}
+	username := ticket.Username
+	if username == "" || utf8.RuneCountInString(username) > 20 {
+		username = "saml_" + strconv.Itoa(model.GetMaxUserId()+1)
+	}
+	exist, err := model.CheckUserExistOrDeleted(username, email)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("CheckUserExistOrDeleted error: %v", err))
+		return nil, errors.New("数据库错误，请稍后重试")
+	}
+	if exist {
+		return nil, errors.New("用户名或邮箱已存在，或已注销，请登录后绑定 SAML 账户")
+	}
+	displayName := ticket.DisplayName
+	if displayName == "" {
+		displayName = "SAML User"
+	}
+	if utf8.RuneCountInString(displayName) > 20 {
+		displayName = string([]rune(displayName)[:20])
+	}
+	inviterId := 0
+	session := sessions.Default(c)
+	if affCode, ok := session.Get("aff").(string); ok {
+		inviterId, _ = model.GetUserIdByAffCode(affCode)
+	}
+	user := &model.User{
+		Username:    username,
+		DisplayName: displayName,
+		Email:       email,
+		InviterId:   inviterId,
+		Role:        common.RoleCommonUser,
+		Group:       system_setting.GetSAMLSettings().MapGroup(ticket.Groups),
+	}
+	if err := user.Insert(inviterId); err != nil {
+		return nil, err
+	}
+	if err := user.FillUserById(); err != nil {
+		return nil, err
+	}
+	if err := model.BindSamlIdentity(user.Id, ticket.Issuer, ticket.NameId); err != nil {
+		return nil, err
+	}
+	if constant.GenerateDefaultToken {
+		if err := createDefaultToken(user.Id, user.Username); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
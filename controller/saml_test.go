@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/crewjam/saml"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+type testSamlIdp struct {
+	idp *saml.IdentityProvider
+	sp  *saml.EntityDescriptor
+}
+
+func newTestSamlKeyPair(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, certificate
+}
+
+// setupTestSaml configures SAML against a fresh in-process IdP.
+func setupTestSaml(t *testing.T, allowIdpInitiated bool) *testSamlIdp {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	common.SessionSecret = "saml-test-secret"
+	oldAddress := system_setting.ServerAddress
+	oldSettings := *system_setting.GetSAMLSettings()
+	t.Cleanup(func() {
+		system_setting.ServerAddress = oldAddress
+		*system_setting.GetSAMLSettings() = oldSettings
+		samlIdpMetadataCache.entity = nil
+	})
+	system_setting.ServerAddress = "https://gw.example.com"
+
+	key, certificate := newTestSamlKeyPair(t)
+	metadataUrl, _ := url.Parse("https://idp.example.com/metadata")
+	ssoUrl, _ := url.Parse("https://idp.example.com/sso")
+	idp := &saml.IdentityProvider{
+		Key:         key,
+		Certificate: certificate,
+		MetadataURL: *metadataUrl,
+		SSOURL:      *ssoUrl,
+	}
+	idpMetadata, err := xml.Marshal(idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := system_setting.GetSAMLSettings()
+	settings.Enabled = true
+	settings.AllowIdpInitiated = allowIdpInitiated
+	settings.IdpMetadataXml = string(idpMetadata)
+	settings.IdpMetadataUrl = ""
+	samlIdpMetadataCache.entity = nil
+
+	sp, err := newSamlServiceProvider(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSamlIdp{idp: idp, sp: sp.Metadata()}
+}
+
+// response returns a base64 SAMLResponse for nameId answering requestId;
+// an empty requestId makes an IdP-initiated response.
+func (p *testSamlIdp) response(t *testing.T, nameId string, requestId string) string {
+	t.Helper()
+	req := &saml.IdpAuthnRequest{
+		IDP:                     p.idp,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, "https://idp.example.com/sso", nil),
+		Now:                     saml.TimeNow(),
+		ServiceProviderMetadata: p.sp,
+		SPSSODescriptor:         &p.sp.SPSSODescriptors[0],
+	}
+	req.Request.ID = requestId
+	for i, endpoint := range p.sp.SPSSODescriptors[0].AssertionConsumerServices {
+		if endpoint.Binding == saml.HTTPPostBinding {
+			req.ACSEndpoint = &p.sp.SPSSODescriptors[0].AssertionConsumerServices[i]
+			break
+		}
+	}
+	session := &saml.Session{ID: "s", NameID: nameId, UserEmail: nameId + "@example.com"}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatal(err)
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form.SAMLResponse
+}
+
+func newTestSamlRouter(values map[string]interface{}) *gin.Engine {
+	router := gin.New()
+	router.Use(sessions.Sessions("session", cookie.NewStore([]byte(common.SessionSecret))))
+	router.Use(func(c *gin.Context) {
+		session := sessions.Default(c)
+		for k, v := range values {
+			session.Set(k, v)
+		}
+	})
+	router.POST("/api/saml/acs", SamlAcs)
+	router.GET("/api/oauth/saml", SamlAuth)
+	return router
+}
+
+// postAcs posts a response to the ACS and returns the issued ticket, or nil
+// with the status code when none was issued.
+func postAcs(t *testing.T, samlResponse string, relayState string) (*samlTicket, int) {
+	t.Helper()
+	form := url.Values{"SAMLResponse": {samlResponse}}
+	if relayState != "" {
+		form.Set("RelayState", relayState)
+	}
+	req := httptest.NewRequest(http.MethodPost, "https://gw.example.com/api/saml/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	newTestSamlRouter(nil).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusFound {
+		return nil, recorder.Code
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := decodeSamlTicket(location.Query().Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.State != location.Query().Get("state") {
+		t.Fatalf("ticket state %q does not match redirect state %q", ticket.State, location.Query().Get("state"))
+	}
+	return ticket, recorder.Code
+}
+
+func TestSamlAcsAcceptsSpInitiatedResponse(t *testing.T) {
+	p := setupTestSaml(t, false)
+	ticket, code := postAcs(t, p.response(t, "alice", samlRequestId("state-1")), "state-1")
+	if ticket == nil {
+		t.Fatalf("valid response rejected with status %d", code)
+	}
+	if ticket.NameId != "alice" || ticket.State != "state-1" || ticket.IdpInitiated {
+		t.Fatalf("unexpected ticket %+v", ticket)
+	}
+	if ticket.Issuer != p.idp.MetadataURL.String() {
+		t.Fatalf("issuer = %q", ticket.Issuer)
+	}
+}
+
+func TestSamlAcsRejectsTamperedSignature(t *testing.T) {
+	p := setupTestSaml(t, false)
+	data, err := base64.StdEncoding.DecodeString(p.response(t, "alice", samlRequestId("state-1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.ReplaceAll(string(data), ">alice<", ">admin<")
+	if tampered == string(data) {
+		t.Fatal("NameID not found in response")
+	}
+	if ticket, code := postAcs(t, base64.StdEncoding.EncodeToString([]byte(tampered)), "state-1"); ticket != nil || code != http.StatusForbidden {
+		t.Fatalf("tampered response accepted: status %d, ticket %+v", code, ticket)
+	}
+}
+
+func TestSamlAcsRejectsUntrustedSigner(t *testing.T) {
+	p := setupTestSaml(t, false)
+	p.idp.Key, p.idp.Certificate = newTestSamlKeyPair(t)
+	if ticket, code := postAcs(t, p.response(t, "alice", samlRequestId("state-1")), "state-1"); ticket != nil || code != http.StatusForbidden {
+		t.Fatalf("response from untrusted key accepted: status %d", code)
+	}
+}
+
+func TestSamlAcsRejectsWrongInResponseTo(t *testing.T) {
+	p := setupTestSaml(t, false)
+	cases := map[string]string{
+		"other state":   samlRequestId("state-2"),
+		"unsolicited":   "",
+		"forged id":     "id-forged",
+		"no relaystate": samlRequestId("state-1"),
+	}
+	for name, requestId := range cases {
+		relayState := "state-1"
+		if name == "no relaystate" {
+			relayState = ""
+		}
+		if ticket, code := postAcs(t, p.response(t, "alice", requestId), relayState); ticket != nil || code != http.StatusForbidden {
+			t.Fatalf("%s: response accepted with status %d", name, code)
+		}
+	}
+}
+
+func TestSamlAcsMarksIdpInitiatedTicket(t *testing.T) {
+	p := setupTestSaml(t, true)
+	ticket, code := postAcs(t, p.response(t, "alice", ""), "")
+	if ticket == nil {
+		t.Fatalf("IdP-initiated response rejected with status %d", code)
+	}
+	if !ticket.IdpInitiated {
+		t.Fatal("IdP-initiated ticket not marked")
+	}
+	// a relay state naming someone else's login does not make it SP-initiated
+	ticket, _ = postAcs(t, p.response(t, "alice", "id-forged"), "victim-state")
+	if ticket == nil || !ticket.IdpInitiated || ticket.State == "victim-state" {
+		t.Fatalf("unsolicited response took over the relay state: %+v", ticket)
+	}
+}
+
+func redeemSamlTicket(t *testing.T, ticket *samlTicket, values map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	ticket.ExpiresAt = time.Now().Add(samlTicketTTL).Unix()
+	code, err := encodeSamlTicket(ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/oauth/saml?code="+url.QueryEscape(code)+"&state="+url.QueryEscape(ticket.State), nil)
+	recorder := httptest.NewRecorder()
+	newTestSamlRouter(values).ServeHTTP(recorder, req)
+	var body map[string]interface{}
+	if err := common.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response %q: %v", recorder.Body.String(), err)
+	}
+	return body
+}
+
+func TestSamlAuthRefusesBindWithoutBindState(t *testing.T) {
+	setupTestSaml(t, true)
+	loggedIn := map[string]interface{}{"username": "victim", "id": 42}
+
+	cases := map[string]struct {
+		ticket  samlTicket
+		session map[string]interface{}
+	}{
+		"idp-initiated": {
+			ticket:  samlTicket{Issuer: "idp", NameId: "attacker", State: "s1", IdpInitiated: true},
+			session: map[string]interface{}{"oauth_state": "s1", "saml_bind_state": "s1"},
+		},
+		"login not started for binding": {
+			ticket:  samlTicket{Issuer: "idp", NameId: "attacker", State: "s1"},
+			session: map[string]interface{}{"oauth_state": "s1"},
+		},
+		"bind state of another login": {
+			ticket:  samlTicket{Issuer: "idp", NameId: "attacker", State: "s1"},
+			session: map[string]interface{}{"oauth_state": "s1", "saml_bind_state": "s0"},
+		},
+	}
+	for name, tc := range cases {
+		for k, v := range loggedIn {
+			tc.session[k] = v
+		}
+		body := redeemSamlTicket(t, &tc.ticket, tc.session)
+		if body["success"] == true || body["message"] != "请在个人设置中发起 SAML 账户绑定" {
+			t.Fatalf("%s: bind was not refused: %v", name, body)
+		}
+	}
+}
@@ -0,0 +1,858 @@
+package controller
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/scim"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// scimMaxResults caps the page size of list responses.
+const scimMaxResults = 200
+
+func scimRespond(c *gin.Context, status int, v any) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, v)
+}
+
+func scimFail(c *gin.Context, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			scimErr = scim.NewError(http.StatusNotFound, "", "resource not found")
+		} else {
+			scimErr = scim.NewError(http.StatusInternalServerError, "", err.Error())
+		}
+	}
+	scimRespond(c, scimErr.StatusCode(), scimErr)
+}
+
+func scimLocation(resourceType string, id int) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%d", strings.TrimSuffix(system_setting.ServerAddress, "/"), resourceType, id)
+}
+
+func scimTime(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
+
+// scimPage reads the 1-based startIndex and count query parameters.
+func scimPage(c *gin.Context) (startIndex int, count int) {
+	startIndex, _ = strconv.Atoi(c.Query("startIndex"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil || count > scimMaxResults {
+		count = scimMaxResults
+	}
+	if count < 0 {
+		count = 0
+	}
+	return startIndex, count
+}
+
+func scimResourceId(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, scim.NewError(http.StatusNotFound, "", "resource not found")
+	}
+	return id, nil
+}
+
+// scimAttributePath lowercases a filter path and drops the core schema prefix.
+func scimAttributePath(path string, schema string) string {
+	path = strings.ToLower(path)
+	return strings.TrimPrefix(path, strings.ToLower(schema)+":")
+}
+
+// ---- Users ----
+
+// scimUserQuery translates a user filter. Only eq comparisons on userName,
+// externalId, emails, id and active are supported. matchable is false when
+// the filter cannot match any user.
+func scimUserQuery(filter string) (query model.ScimUserQuery, matchable bool, err error) {
+	if filter == "" {
+		return query, true, nil
+	}
+	comparisons, err := scim.ParseFilter(filter)
+	if err != nil {
+		return query, false, err
+	}
+	matchable = true
+	for _, comparison := range comparisons {
+		if comparison.Op != "eq" {
+			return query, false, scim.BadRequest(scim.ErrInvalidFilter, "operator %s is not supported for users", comparison.Op)
+		}
+		path := scimAttributePath(comparison.Path, scim.SchemaUser)
+		if path == "active" {
+			active, ok := comparison.Value.(bool)
+			if !ok {
+				return query, false, scim.BadRequest(scim.ErrInvalidFilter, "active must be compared with a boolean")
+			}
+			query.Status = common.UserStatusDisabled
+			if active {
+				query.Status = common.UserStatusEnabled
+			}
+			continue
+		}
+		value, ok := comparison.Value.(string)
+		if !ok {
+			return query, false, scim.BadRequest(scim.ErrInvalidFilter, "%s must be compared with a string", comparison.Path)
+		}
+		switch path {
+		case "username":
+			query.UserName = value
+		case "externalid":
+			query.ExternalId = value
+		case "emails", "emails.value":
+			query.Email = value
+		case "id":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				matchable = false
+			}
+			query.Id = id
+		default:
+			return query, false, scim.BadRequest(scim.ErrInvalidFilter, "filtering users by %s is not supported", comparison.Path)
+		}
+	}
+	return query, matchable, nil
+}
+
+func scimUserResource(user *model.User, scimUser *model.ScimUser) (*scim.User, error) {
+	groups, err := model.GetUserScimGroups(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	active := scim.Bool(user.Status == common.UserStatusEnabled)
+	resource := &scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		Id:          strconv.Itoa(user.Id),
+		ExternalId:  scimUser.ExternalId,
+		UserName:    user.Username,
+		DisplayName: user.DisplayName,
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      scimTime(scimUser.CreatedTime),
+			LastModified: scimTime(scimUser.UpdatedTime),
+			Location:     scimLocation("Users", user.Id),
+		},
+	}
+	if user.DisplayName != "" || scimUser.GivenName != "" || scimUser.FamilyName != "" {
+		resource.Name = &scim.Name{
+			Formatted:  user.DisplayName,
+			GivenName:  scimUser.GivenName,
+			FamilyName: scimUser.FamilyName,
+		}
+	}
+	if user.Email != "" {
+		resource.Emails = []scim.MultiValue{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, scim.Reference{
+			Value:   strconv.Itoa(group.Id),
+			Display: group.DisplayName,
+			Ref:     scimLocation("Groups", group.Id),
+		})
+	}
+	return resource, nil
+}
+
+// applyScimUser copies a SCIM user onto the gateway user and its SCIM
+// attributes, checking that userName and the OIDC subject are unique.
+func applyScimUser(resource *scim.User, user *model.User, scimUser *model.ScimUser) error {
+	userName := strings.TrimSpace(resource.UserName)
+	if userName == "" {
+		return scim.BadRequest(scim.ErrInvalidValue, "userName is required")
+	}
+	taken, err := model.IsUsernameTakenByOther(userName, user.Id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName is already taken")
+	}
+	displayName := strings.TrimSpace(resource.DisplayName)
+	scimUser.GivenName, scimUser.FamilyName = "", ""
+	if resource.Name != nil {
+		scimUser.GivenName = resource.Name.GivenName
+		scimUser.FamilyName = resource.Name.FamilyName
+		if displayName == "" {
+			displayName = strings.TrimSpace(resource.Name.Formatted)
+		}
+		if displayName == "" {
+			displayName = strings.TrimSpace(resource.Name.GivenName + " " + resource.Name.FamilyName)
+		}
+	}
+	if displayName == "" {
+		displayName = userName
+	}
+
+	subject := ""
+	switch config.GetScimConfig().OidcSubject {
+	case "externalId":
+		subject = resource.ExternalId
+	case "userName":
+		subject = userName
+	}
+	if subject != "" && subject != user.OidcId {
+		if model.IsOidcIdAlreadyTaken(subject) {
+			return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "the OIDC subject is linked to another user")
+		}
+		user.OidcId = subject
+	}
+
+	user.Username = userName
+	user.DisplayName = displayName
+	user.Email = resource.PrimaryEmail()
+	user.Status = common.UserStatusDisabled
+	if resource.IsActive() {
+		user.Status = common.UserStatusEnabled
+	}
+	scimUser.ExternalId = resource.ExternalId
+	return nil
+}
+
+func loadScimUser(c *gin.Context) (*model.User, *model.ScimUser, error) {
+	id, err := scimResourceId(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	scimUser, err := model.GetScimUser(user.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, scimUser, nil
+}
+
+// checkScimManageable keeps the root user out of reach of provisioning.
+func checkScimManageable(user *model.User) error {
+	if user.Role >= common.RoleRootUser {
+		return scim.NewError(http.StatusForbidden, "", "the root user cannot be managed through SCIM")
+	}
+	return nil
+}
+
+func respondScimUser(c *gin.Context, status int, user *model.User, scimUser *model.ScimUser) {
+	resource, err := scimUserResource(user, scimUser)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	if status == http.StatusCreated {
+		c.Header("Location", resource.Meta.Location)
+	}
+	scimRespond(c, status, resource)
+}
+
+func GetScimUsers(c *gin.Context) {
+	query, matchable, err := scimUserQuery(c.Query("filter"))
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	startIndex, count := scimPage(c)
+	resources := make([]any, 0)
+	var total int64
+	if matchable {
+		var users []*model.User
+		users, total, err = model.SearchScimUsers(query, startIndex-1, count)
+		if err != nil {
+			scimFail(c, err)
+			return
+		}
+		userIds := make([]int, 0, len(users))
+		for _, user := range users {
+			userIds = append(userIds, user.Id)
+		}
+		scimUsers, err := model.GetScimUsersByIds(userIds)
+		if err != nil {
+			scimFail(c, err)
+			return
+		}
+		for _, user := range users {
+			scimUser, ok := scimUsers[user.Id]
+			if !ok {
+				scimUser = &model.ScimUser{UserId: user.Id}
+			}
+			resource, err := scimUserResource(user, scimUser)
+			if err != nil {
+				scimFail(c, err)
+				return
+			}
+			resources = append(resources, resource)
+		}
+	}
+	scimRespond(c, http.StatusOK, scim.NewListResponse(resources, int(total), startIndex))
+}
+
+func GetScimUser(c *gin.Context) {
+	user, scimUser, err := loadScimUser(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	respondScimUser(c, http.StatusOK, user, scimUser)
+}
+
+func CreateScimUser(c *gin.Context) {
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimFail(c, scim.BadRequest(scim.ErrInvalidSyntax, "%s", err.Error()))
+		return
+	}
+	user := &model.User{Role: common.RoleCommonUser}
+	scimUser := &model.ScimUser{}
+	if err := applyScimUser(&resource, user, scimUser); err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := model.CreateScimUser(user, scimUser); err != nil {
+		scimFail(c, err)
+		return
+	}
+	service.RecordAudit(c, "scim.user_create", "user", user.Id, nil, resource)
+	respondScimUser(c, http.StatusCreated, user, scimUser)
+}
+
+// saveScimUser writes resource over the user; before is the user's SCIM
+// representation prior to the change.
+func saveScimUser(c *gin.Context, user *model.User, scimUser *model.ScimUser, before *scim.User, resource *scim.User) {
+	if err := checkScimManageable(user); err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := applyScimUser(resource, user, scimUser); err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := model.UpdateScimUser(user, scimUser); err != nil {
+		scimFail(c, err)
+		return
+	}
+	service.RecordAudit(c, "scim.user_update", "user", user.Id, before, resource)
+	respondScimUser(c, http.StatusOK, user, scimUser)
+}
+
+func ReplaceScimUser(c *gin.Context) {
+	user, scimUser, err := loadScimUser(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimFail(c, scim.BadRequest(scim.ErrInvalidSyntax, "%s", err.Error()))
+		return
+	}
+	before, err := scimUserResource(user, scimUser)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	saveScimUser(c, user, scimUser, before, &resource)
+}
+
+func PatchScimUser(c *gin.Context) {
+	user, scimUser, err := loadScimUser(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	var request scim.PatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		scimFail(c, scim.BadRequest(scim.ErrInvalidSyntax, "%s", err.Error()))
+		return
+	}
+	before, err := scimUserResource(user, scimUser)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	generic, err := scim.ToMap(before)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := scim.ApplyPatch(generic, request.Operations); err != nil {
+		scimFail(c, err)
+		return
+	}
+	var resource scim.User
+	if err := scim.FromMap(generic, &resource); err != nil {
+		scimFail(c, err)
+		return
+	}
+	saveScimUser(c, user, scimUser, before, &resource)
+}
+
+func DeleteScimUser(c *gin.Context) {
+	user, scimUser, err := loadScimUser(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := checkScimManageable(user); err != nil {
+		scimFail(c, err)
+		return
+	}
+	before, err := scimUserResource(user, scimUser)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	// leave the organizations SCIM put the user in before deleting them
+	if err := model.SyncScimOrganizationMemberships(user.Id, nil, config.GetScimConfig().MappedOrganizations()); err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := model.DeleteScimUser(user.Id); err != nil {
+		scimFail(c, err)
+		return
+	}
+	service.RecordAudit(c, "scim.user_delete", "user", user.Id, before, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// ---- Groups ----
+
+func scimGroupResource(group *model.ScimGroup, members []*model.ScimGroupMember) *scim.Group {
+	resource := &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		Id:          strconv.Itoa(group.Id),
+		ExternalId:  group.ExternalId,
+		DisplayName: group.DisplayName,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      scimTime(group.CreatedTime),
+			LastModified: scimTime(group.UpdatedTime),
+			Location:     scimLocation("Groups", group.Id),
+		},
+	}
+	for _, member := range members {
+		resource.Members = append(resource.Members, scim.Reference{
+			Value:   strconv.Itoa(member.UserId),
+			Display: member.Username,
+			Ref:     scimLocation("Users", member.UserId),
+		})
+	}
+	return resource
+}
+
+func loadScimGroup(c *gin.Context) (*model.ScimGroup, *scim.Group, error) {
+	id, err := scimResourceId(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	group, err := model.GetScimGroupById(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := model.GetScimGroupMembers([]int{group.Id})
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, scimGroupResource(group, members[group.Id]), nil
+}
+
+// scimMemberIds resolves member references to existing user ids.
+func scimMemberIds(members []scim.Reference) ([]int, error) {
+	userIds := make([]int, 0, len(members))
+	seen := make(map[int]bool, len(members))
+	for _, member := range members {
+		userId, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return nil, scim.BadRequest(scim.ErrInvalidValue, "invalid member %q", member.Value)
+		}
+		if seen[userId] {
+			continue
+		}
+		if _, err := model.GetUserById(userId, false); err != nil {
+			return nil, scim.BadRequest(scim.ErrInvalidValue, "member %q does not exist", member.Value)
+		}
+		seen[userId] = true
+		userIds = append(userIds, userId)
+	}
+	return userIds, nil
+}
+
+// applyScimMappings re-applies the group mappings of users whose groups
+// changed. Failures are logged; the group change itself stands.
+func applyScimMappings(c *gin.Context, userIds []int) {
+	for _, userId := range userIds {
+		if err := service.ApplyScimGroupMappings(userId); err != nil {
+			logger.LogError(c, fmt.Sprintf("应用 SCIM 组映射失败（用户 %d）：%s", userId, err.Error()))
+		}
+	}
+}
+
+func excludesScimMembers(c *gin.Context) bool {
+	for _, attribute := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(scimAttributePath(strings.TrimSpace(attribute), scim.SchemaGroup), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+func GetScimGroups(c *gin.Context) {
+	var comparisons []scim.Comparison
+	if filter := c.Query("filter"); filter != "" {
+		var err error
+		if comparisons, err = scim.ParseFilter(filter); err != nil {
+			scimFail(c, err)
+			return
+		}
+	}
+	groups, err := model.GetAllScimGroups()
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	groupIds := make([]int, 0, len(groups))
+	for _, group := range groups {
+		groupIds = append(groupIds, group.Id)
+	}
+	members, err := model.GetScimGroupMembers(groupIds)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	excludeMembers := excludesScimMembers(c)
+	var matched []*scim.Group
+	for _, group := range groups {
+		resource := scimGroupResource(group, members[group.Id])
+		if len(comparisons) > 0 {
+			generic, err := scim.ToMap(resource)
+			if err != nil {
+				scimFail(c, err)
+				return
+			}
+			if !scim.Match(generic, comparisons) {
+				continue
+			}
+		}
+		if excludeMembers {
+			resource.Members = nil
+		}
+		matched = append(matched, resource)
+	}
+	startIndex, count := scimPage(c)
+	resources := make([]any, 0)
+	for i := startIndex - 1; i < len(matched) && len(resources) < count; i++ {
+		resources = append(resources, matched[i])
+	}
+	scimRespond(c, http.StatusOK, scim.NewListResponse(resources, len(matched), startIndex))
+}
+
+func GetScimGroup(c *gin.Context) {
+	_, resource, err := loadScimGroup(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	if excludesScimMembers(c) {
+		resource.Members = nil
+	}
+	scimRespond(c, http.StatusOK, resource)
+}
+
+func validateScimGroup(resource *scim.Group, groupId int) error {
+	resource.DisplayName = strings.TrimSpace(resource.DisplayName)
+	if resource.DisplayName == "" {
+		return scim.BadRequest(scim.ErrInvalidValue, "displayName is required")
+	}
+	taken, err := model.IsScimGroupNameTaken(resource.DisplayName, groupId)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "displayName is already taken")
+	}
+	return nil
+}
+
+func CreateScimGroup(c *gin.Context) {
+	var resource scim.Group
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimFail(c, scim.BadRequest(scim.ErrInvalidSyntax, "%s", err.Error()))
+		return
+	}
+	if err := validateScimGroup(&resource, 0); err != nil {
+		scimFail(c, err)
+		return
+	}
+	memberIds, err := scimMemberIds(resource.Members)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	group := &model.ScimGroup{DisplayName: resource.DisplayName, ExternalId: resource.ExternalId}
+	if err := group.Insert(); err != nil {
+		scimFail(c, err)
+		return
+	}
+	changed, err := model.SetScimGroupMembers(group.Id, memberIds)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	applyScimMappings(c, changed)
+	service.RecordAudit(c, "scim.group_create", "scim_group", group.Id, nil, resource)
+
+	members, err := model.GetScimGroupMembers([]int{group.Id})
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	created := scimGroupResource(group, members[group.Id])
+	c.Header("Location", created.Meta.Location)
+	scimRespond(c, http.StatusCreated, created)
+}
+
+// saveScimGroup writes resource over the group and re-applies mappings for
+// the affected users: everyone when the group is renamed, otherwise those
+// who joined or left.
+func saveScimGroup(c *gin.Context, group *model.ScimGroup, before *scim.Group, resource *scim.Group) {
+	if err := validateScimGroup(resource, group.Id); err != nil {
+		scimFail(c, err)
+		return
+	}
+	memberIds, err := scimMemberIds(resource.Members)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	renamed := !strings.EqualFold(group.DisplayName, resource.DisplayName)
+	group.DisplayName = resource.DisplayName
+	group.ExternalId = resource.ExternalId
+	if err := group.Update(); err != nil {
+		scimFail(c, err)
+		return
+	}
+	changed, err := model.SetScimGroupMembers(group.Id, memberIds)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	if renamed {
+		changed = append(changed, memberIds...)
+	}
+	applyScimMappings(c, changed)
+	service.RecordAudit(c, "scim.group_update", "scim_group", group.Id, before, resource)
+
+	members, err := model.GetScimGroupMembers([]int{group.Id})
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	updated := scimGroupResource(group, members[group.Id])
+	if excludesScimMembers(c) {
+		updated.Members = nil
+	}
+	scimRespond(c, http.StatusOK, updated)
+}
+
+func ReplaceScimGroup(c *gin.Context) {
+	group, before, err := loadScimGroup(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	var resource scim.Group
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimFail(c, scim.BadRequest(scim.ErrInvalidSyntax, "%s", err.Error()))
+		return
+	}
+	saveScimGroup(c, group, before, &resource)
+}
+
+func PatchScimGroup(c *gin.Context) {
+	group, before, err := loadScimGroup(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	var request scim.PatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		scimFail(c, scim.BadRequest(scim.ErrInvalidSyntax, "%s", err.Error()))
+		return
+	}
+	generic, err := scim.ToMap(before)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := scim.ApplyPatch(generic, request.Operations); err != nil {
+		scimFail(c, err)
+		return
+	}
+	var resource scim.Group
+	if err := scim.FromMap(generic, &resource); err != nil {
+		scimFail(c, err)
+		return
+	}
+	saveScimGroup(c, group, before, &resource)
+}
+
+func DeleteScimGroup(c *gin.Context) {
+	group, before, err := loadScimGroup(c)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	memberIds, err := model.GetScimGroupMemberIds(group.Id)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	if err := group.Delete(); err != nil {
+		scimFail(c, err)
+		return
+	}
+	applyScimMappings(c, memberIds)
+	service.RecordAudit(c, "scim.group_delete", "scim_group", group.Id, before, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// ---- Discovery ----
+
+func GetScimServiceProviderConfig(c *gin.Context) {
+	scimRespond(c, http.StatusOK, gin.H{
+		"schemas":        []string{scim.SchemaServiceProviderConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "The SCIM provisioning token generated by an administrator",
+			"primary":     true,
+		}},
+		"meta": gin.H{"resourceType": "ServiceProviderConfig"},
+	})
+}
+
+func GetScimResourceTypes(c *gin.Context) {
+	resourceType := func(name string, endpoint string, schema string) any {
+		return gin.H{
+			"schemas":     []string{scim.SchemaResourceType},
+			"id":          name,
+			"name":        name,
+			"endpoint":    endpoint,
+			"description": name,
+			"schema":      schema,
+			"meta":        gin.H{"resourceType": "ResourceType"},
+		}
+	}
+	resources := []any{
+		resourceType("User", "/Users", scim.SchemaUser),
+		resourceType("Group", "/Groups", scim.SchemaGroup),
+	}
+	scimRespond(c, http.StatusOK, scim.NewListResponse(resources, len(resources), 1))
+}
+
+func scimAttribute(name string, attributeType string, multiValued bool, required bool, uniqueness string, subAttributes ...gin.H) gin.H {
+	attribute := gin.H{
+		"name":        name,
+		"type":        attributeType,
+		"multiValued": multiValued,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  "readWrite",
+		"returned":    "default",
+		"uniqueness":  uniqueness,
+	}
+	if len(subAttributes) > 0 {
+		attribute["subAttributes"] = subAttributes
+	}
+	return attribute
+}
+
+func GetScimSchemas(c *gin.Context) {
+	userGroups := scimAttribute("groups", "complex", true, false, "none",
+		scimAttribute("value", "string", false, false, "none"),
+		scimAttribute("display", "string", false, false, "none"),
+	)
+	userGroups["mutability"] = "readOnly"
+	resources := []any{
+		gin.H{
+			"schemas": []string{scim.SchemaSchema},
+			"id":      scim.SchemaUser,
+			"name":    "User",
+			"attributes": []gin.H{
+				scimAttribute("userName", "string", false, true, "server"),
+				scimAttribute("name", "complex", false, false, "none",
+					scimAttribute("formatted", "string", false, false, "none"),
+					scimAttribute("givenName", "string", false, false, "none"),
+					scimAttribute("familyName", "string", false, false, "none"),
+				),
+				scimAttribute("displayName", "string", false, false, "none"),
+				scimAttribute("emails", "complex", true, false, "none",
+					scimAttribute("value", "string", false, false, "none"),
+					scimAttribute("type", "string", false, false, "none"),
+					scimAttribute("primary", "boolean", false, false, "none"),
+				),
+				scimAttribute("active", "boolean", false, false, "none"),
+				userGroups,
+			},
+			"meta": gin.H{"resourceType": "Schema"},
+		},
+		gin.H{
+			"schemas": []string{scim.SchemaSchema},
+			"id":      scim.SchemaGroup,
+			"name":    "Group",
+			"attributes": []gin.H{
+				scimAttribute("displayName", "string", false, true, "server"),
+				scimAttribute("members", "complex", true, false, "none",
+					scimAttribute("value", "string", false, false, "none"),
+					scimAttribute("display", "string", false, false, "none"),
+				),
+			},
+			"meta": gin.H{"resourceType": "Schema"},
+		},
+	}
+	scimRespond(c, http.StatusOK, scim.NewListResponse(resources, len(resources), 1))
+}
+
+// GenerateScimToken issues a new SCIM provisioning token, replacing the
+// previous one. Only its hash is stored, so it is shown this once.
+func GenerateScimToken(c *gin.Context) {
+	key, err := common.GenerateKey()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	token := "scim-" + key
+	if err := model.UpdateOption("scim.token_hash", hex.EncodeToString(common.Sha256Raw([]byte(token)))); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "scim.token_rotate", "option", "scim.token_hash", nil, nil)
+	common.ApiSuccess(c, gin.H{"token": token})
+}
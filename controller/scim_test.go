@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service/scim"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupScimTest(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Token{}, &model.Log{}, &model.AuditLog{},
+		&model.ScimUser{}, &model.ScimGroup{}, &model.ScimGroupMember{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB, oldLogDB, oldRedis, oldAddress := model.DB, model.LOG_DB, common.RedisEnabled, system_setting.ServerAddress
+	model.DB, model.LOG_DB = db, db
+	common.RedisEnabled = false
+	system_setting.ServerAddress = "https://gw.example.com"
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB = oldDB, oldLogDB
+		common.RedisEnabled = oldRedis
+		system_setting.ServerAddress = oldAddress
+	})
+
+	router := gin.New()
+	scimRouter := router.Group("/scim/v2")
+	scimRouter.GET("/Users", GetScimUsers)
+	scimRouter.POST("/Users", CreateScimUser)
+	scimRouter.GET("/Users/:id", GetScimUser)
+	scimRouter.PUT("/Users/:id", ReplaceScimUser)
+	scimRouter.PATCH("/Users/:id", PatchScimUser)
+	scimRouter.DELETE("/Users/:id", DeleteScimUser)
+	scimRouter.GET("/Groups", GetScimGroups)
+	scimRouter.POST("/Groups", CreateScimGroup)
+	scimRouter.PATCH("/Groups/:id", PatchScimGroup)
+	return router
+}
+
+// scimCall sends body as application/scim+json and decodes the response
+// into out when both are set.
+func scimCall(t *testing.T, router *gin.Engine, method string, path string, body string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if body != "" {
+		req.Header.Set("Content-Type", scim.ContentType)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNoContent {
+		if contentType := recorder.Header().Get("Content-Type"); contentType != scim.ContentType {
+			t.Errorf("%s %s: Content-Type = %q, want %q", method, path, contentType, scim.ContentType)
+		}
+	}
+	if out != nil && recorder.Body.Len() > 0 {
+		if err := common.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decode %s: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder
+}
+
+// expectScimError checks an RFC 7644 section 3.12 error response.
+func expectScimError(t *testing.T, recorder *httptest.ResponseRecorder, status int, scimType string) {
+	t.Helper()
+	var scimErr scim.Error
+	if err := common.Unmarshal(recorder.Body.Bytes(), &scimErr); err != nil {
+		t.Fatalf("decode error %s: %v", recorder.Body.String(), err)
+	}
+	if recorder.Code != status || scimErr.Status != strconv.Itoa(status) || scimErr.ScimType != scimType ||
+		len(scimErr.Schemas) != 1 || scimErr.Schemas[0] != scim.SchemaError {
+		t.Fatalf("error = %d %+v, want %d with scimType %q", recorder.Code, scimErr, status, scimType)
+	}
+}
+
+func filterQuery(filter string) string {
+	return "?filter=" + url.QueryEscape(filter)
+}
+
+func TestScimUserConformance(t *testing.T) {
+	router := setupScimTest(t)
+
+	// RFC 7644 section 3.3: 201 with the resource and its Location
+	var created scim.User
+	recorder := scimCall(t, router, http.MethodPost, "/scim/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "alice@example.com",
+		"externalId": "00u1",
+		"name": {"givenName": "Alice", "familyName": "Liddell"},
+		"emails": [{"value": "alice@example.com", "type": "work", "primary": true}],
+		"active": true
+	}`, &created)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if created.Id == "" || created.Schemas[0] != scim.SchemaUser || created.ExternalId != "00u1" || !created.IsActive() ||
+		created.DisplayName != "Alice Liddell" || created.PrimaryEmail() != "alice@example.com" {
+		t.Fatalf("unexpected user: %+v", created)
+	}
+	if created.Meta == nil || created.Meta.ResourceType != "User" || created.Meta.Created == "" ||
+		created.Meta.Location != "https://gw.example.com/scim/v2/Users/"+created.Id ||
+		recorder.Header().Get("Location") != created.Meta.Location {
+		t.Fatalf("unexpected meta %+v, Location %q", created.Meta, recorder.Header().Get("Location"))
+	}
+	userPath := "/scim/v2/Users/" + created.Id
+
+	// userName is unique
+	recorder = scimCall(t, router, http.MethodPost, "/scim/v2/Users", `{"userName": "alice@example.com"}`, nil)
+	expectScimError(t, recorder, http.StatusConflict, scim.ErrUniqueness)
+
+	// section 3.4.2.2: filters, with a ListResponse even when nothing matches
+	cases := []struct {
+		filter string
+		total  int
+	}{
+		{`userName eq "ALICE@example.com"`, 1},
+		{`externalId eq "00u1"`, 1},
+		{`emails.value eq "alice@example.com" and active eq true`, 1},
+		{`id eq "` + created.Id + `"`, 1},
+		{`userName eq "bob@example.com"`, 0},
+		{`active eq false`, 0},
+	}
+	for _, tc := range cases {
+		var list scim.ListResponse
+		recorder = scimCall(t, router, http.MethodGet, "/scim/v2/Users"+filterQuery(tc.filter), "", &list)
+		if recorder.Code != http.StatusOK || list.Schemas[0] != scim.SchemaListResponse || list.TotalResults != tc.total ||
+			list.ItemsPerPage != tc.total || len(list.Resources) != tc.total || list.StartIndex != 1 {
+			t.Errorf("filter %s: %d %+v", tc.filter, recorder.Code, list)
+		}
+	}
+	recorder = scimCall(t, router, http.MethodGet, "/scim/v2/Users"+filterQuery(`userName sw "alice"`), "", nil)
+	expectScimError(t, recorder, http.StatusBadRequest, scim.ErrInvalidFilter)
+	recorder = scimCall(t, router, http.MethodGet, "/scim/v2/Users"+filterQuery(`userName eq`), "", nil)
+	expectScimError(t, recorder, http.StatusBadRequest, scim.ErrInvalidFilter)
+
+	// section 3.5.2: PATCH, with the string booleans some providers send
+	var patched scim.User
+	recorder = scimCall(t, router, http.MethodPatch, userPath, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "replace", "path": "active", "value": "False"},
+			{"op": "replace", "value": {"name.familyName": "Pleasance"}},
+			{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "alice@new.example.com"}
+		]
+	}`, &patched)
+	if recorder.Code != http.StatusOK || patched.IsActive() || patched.Name.FamilyName != "Pleasance" ||
+		patched.Name.GivenName != "Alice" || patched.PrimaryEmail() != "alice@new.example.com" || patched.ExternalId != "00u1" {
+		t.Fatalf("patch: %d %s", recorder.Code, recorder.Body.String())
+	}
+	userId, _ := strconv.Atoi(created.Id)
+	stored, err := model.GetUserById(userId, false)
+	if err != nil || stored.Status != common.UserStatusDisabled || stored.Email != "alice@new.example.com" {
+		t.Fatalf("patch not stored: %+v, %v", stored, err)
+	}
+	var list scim.ListResponse
+	scimCall(t, router, http.MethodGet, "/scim/v2/Users"+filterQuery(`active eq false`), "", &list)
+	if list.TotalResults != 1 {
+		t.Fatalf("deactivated user not found by filter: %+v", list)
+	}
+	recorder = scimCall(t, router, http.MethodPatch, userPath, `{"Operations": [{"op": "move", "path": "active"}]}`, nil)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("unknown patch op: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// section 3.6: DELETE answers 204 and the resource is gone
+	if recorder = scimCall(t, router, http.MethodDelete, userPath, "", nil); recorder.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", recorder.Code, recorder.Body.String())
+	}
+	expectScimError(t, scimCall(t, router, http.MethodGet, userPath, "", nil), http.StatusNotFound, "")
+	expectScimError(t, scimCall(t, router, http.MethodGet, "/scim/v2/Users/not-a-number", "", nil), http.StatusNotFound, "")
+}
+
+func TestScimGroupConformance(t *testing.T) {
+	router := setupScimTest(t)
+	var userIds []string
+	for _, name := range []string{"carol", "dave"} {
+		var user scim.User
+		if recorder := scimCall(t, router, http.MethodPost, "/scim/v2/Users", `{"userName": "`+name+`"}`, &user); recorder.Code != http.StatusCreated {
+			t.Fatalf("create user: %d %s", recorder.Code, recorder.Body.String())
+		}
+		userIds = append(userIds, user.Id)
+	}
+
+	var group scim.Group
+	recorder := scimCall(t, router, http.MethodPost, "/scim/v2/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "Engineering",
+		"members": [{"value": "`+userIds[0]+`"}]
+	}`, &group)
+	if recorder.Code != http.StatusCreated || group.Id == "" || group.Meta.Location != recorder.Header().Get("Location") ||
+		len(group.Members) != 1 || group.Members[0].Value != userIds[0] || group.Members[0].Display != "carol" {
+		t.Fatalf("create group: %d %s", recorder.Code, recorder.Body.String())
+	}
+	expectScimError(t, scimCall(t, router, http.MethodPost, "/scim/v2/Groups", `{"displayName": "Engineering"}`, nil),
+		http.StatusConflict, scim.ErrUniqueness)
+	expectScimError(t, scimCall(t, router, http.MethodPost, "/scim/v2/Groups", `{"displayName": "Ops", "members": [{"value": "9999"}]}`, nil),
+		http.StatusBadRequest, scim.ErrInvalidValue)
+
+	// members are added and removed by value filter
+	var patched scim.Group
+	recorder = scimCall(t, router, http.MethodPatch, "/scim/v2/Groups/"+group.Id, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "add", "path": "members", "value": [{"value": "`+userIds[1]+`"}]},
+			{"op": "remove", "path": "members[value eq \"`+userIds[0]+`\"]"}
+		]
+	}`, &patched)
+	if recorder.Code != http.StatusOK || len(patched.Members) != 1 || patched.Members[0].Value != userIds[1] {
+		t.Fatalf("patch group: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	var user scim.User
+	scimCall(t, router, http.MethodGet, "/scim/v2/Users/"+userIds[1], "", &user)
+	if len(user.Groups) != 1 || user.Groups[0].Value != group.Id || user.Groups[0].Display != "Engineering" {
+		t.Fatalf("user groups = %+v", user.Groups)
+	}
+
+	var list scim.ListResponse
+	scimCall(t, router, http.MethodGet, "/scim/v2/Groups"+filterQuery(`displayName eq "engineering"`)+"&excludedAttributes=members", "", &list)
+	if list.TotalResults != 1 || len(list.Resources) != 1 {
+		t.Fatalf("group filter: %+v", list)
+	}
+	if members, _ := list.Resources[0].(map[string]any)["members"]; members != nil {
+		t.Fatalf("excludedAttributes=members returned members: %v", members)
+	}
+	scimCall(t, router, http.MethodGet, "/scim/v2/Groups"+filterQuery(`displayName eq "Sales"`), "", &list)
+	if list.TotalResults != 0 || len(list.Resources) != 0 {
+		t.Fatalf("unexpected groups: %+v", list)
+	}
+}
@@ -125,6 +125,7 @@ func DeleteSecurityViolation(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "security.violation_delete", "security_violation", id, nil, nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -198,6 +199,7 @@ func BanUser(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "security.ban", "user", userId, nil, gin.H{"is_banned": true})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -224,6 +226,7 @@ func UnbanUser(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "security.unban", "user", userId, nil, gin.H{"is_banned": false})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -262,6 +265,7 @@ func SetUserRedirect(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "security.redirect_set", "user", userId, nil, gin.H{"redirect_model": req.Model})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -288,6 +292,7 @@ func ClearUserRedirect(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "security.redirect_clear", "user", userId, nil, gin.H{"redirect_model": ""})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -317,6 +322,7 @@ func UpdateSecuritySettings(c *gin.Context) {
 		return
 	}
 
+	before := service.GetSecuritySettings()
 	err := service.UpdateSecuritySettings(settings)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -325,6 +331,7 @@ func UpdateSecuritySettings(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "security.settings_update", "security", "settings", before, service.GetSecuritySettings())
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetServerTools(c *gin.Context) {
+	tools, err := model.GetAllServerTools()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tools,
+	})
+}
+
+func CreateServerTool(c *gin.Context) {
+	tool := model.ServerTool{}
+	if err := c.ShouldBindJSON(&tool); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	tool.Id = 0
+	tool.Name = strings.TrimSpace(tool.Name)
+	if tool.Status == 0 {
+		tool.Status = model.ServerToolStatusEnabled
+	}
+	if err := tool.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := tool.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.InvalidateServerTools(tool.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tool,
+	})
+}
+
+func UpdateServerTool(c *gin.Context) {
+	tool, ok := serverToolFromParam(c)
+	if !ok {
+		return
+	}
+	id := tool.Id
+	if err := c.ShouldBindJSON(tool); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	tool.Id = id
+	tool.Name = strings.TrimSpace(tool.Name)
+	if err := tool.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := tool.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.InvalidateServerTools(id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tool,
+	})
+}
+
+func DeleteServerTool(c *gin.Context) {
+	tool, ok := serverToolFromParam(c)
+	if !ok {
+		return
+	}
+	if err := tool.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.InvalidateServerTools(tool.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetServerToolDocuments(c *gin.Context) {
+	tool, ok := serverToolFromParam(c)
+	if !ok {
+		return
+	}
+	documents, err := model.GetServerToolDocuments(tool.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    documents,
+	})
+}
+
+// AddServerToolDocuments indexes documents for a retrieval tool; the body is
+// one {"title", "content"} object or an array of them.
+func AddServerToolDocuments(c *gin.Context) {
+	tool, ok := serverToolFromParam(c)
+	if !ok {
+		return
+	}
+	if tool.Type != model.ServerToolTypeRetrieval {
+		common.ApiErrorMsg(c, "documents can only be added to retrieval tools")
+		return
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var documents []*model.ServerToolDocument
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(body, &documents)
+	} else {
+		document := &model.ServerToolDocument{}
+		err = json.Unmarshal(body, document)
+		documents = append(documents, document)
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for _, document := range documents {
+		if strings.TrimSpace(document.Content) == "" {
+			common.ApiErrorMsg(c, "document content is required")
+			return
+		}
+	}
+	if err := model.AddServerToolDocuments(tool.Id, documents); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.InvalidateServerTools(tool.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    documents,
+	})
+}
+
+func DeleteServerToolDocument(c *gin.Context) {
+	tool, ok := serverToolFromParam(c)
+	if !ok {
+		return
+	}
+	documentId, err := strconv.Atoi(c.Param("document_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteServerToolDocument(tool.Id, documentId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.InvalidateServerTools(tool.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// TestServerTool runs a tool with {"arguments": {...}} without billing.
+func TestServerTool(c *gin.Context) {
+	tool, ok := serverToolFromParam(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	output, err := service.ExecuteServerTool(c.Request.Context(), tool, string(request.Arguments))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    output,
+	})
+}
+
+func serverToolFromParam(c *gin.Context) (*model.ServerTool, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	tool, err := model.GetServerToolById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	return tool, true
+}
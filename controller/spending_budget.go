@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/budget"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSpendingBudgetsPerUser bounds the budgets of a user and their tokens.
+const maxSpendingBudgetsPerUser = 20
+
+type spendingBudgetRequest struct {
+	TokenId        int    `json:"token_id"`
+	Window         string `json:"window"`
+	Timezone       string `json:"timezone"`
+	LimitQuota     int64  `json:"limit_quota"`
+	SoftThresholds []int  `json:"soft_thresholds"`
+	Enabled        *bool  `json:"enabled"`
+}
+
+// apply validates the request and copies it onto b. The token must belong
+// to the budget's user.
+func (req *spendingBudgetRequest) apply(b *model.SpendingBudget) error {
+	if !budget.ValidWindow(req.Window) {
+		return errors.New("预算周期必须是 day、week 或 month")
+	}
+	if _, err := budget.LoadLocation(req.Timezone); err != nil {
+		return errors.New("无效的时区 " + req.Timezone)
+	}
+	if req.LimitQuota <= 0 {
+		return errors.New("预算额度必须大于 0")
+	}
+	thresholds, err := budget.NormalizeThresholds(req.SoftThresholds)
+	if err != nil {
+		return err
+	}
+	if req.TokenId != 0 {
+		if _, err := model.GetTokenByIds(req.TokenId, b.UserId); err != nil {
+			return errors.New("令牌不存在")
+		}
+	}
+	b.TokenId = req.TokenId
+	b.Window = req.Window
+	b.Timezone = req.Timezone
+	b.LimitQuota = req.LimitQuota
+	b.SetSoftThresholds(thresholds)
+	if req.Enabled != nil {
+		b.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+type spendingBudgetItem struct {
+	*model.SpendingBudget
+	SpentQuota  int64 `json:"spent_quota"`
+	WindowStart int64 `json:"window_start"`
+	WindowEnd   int64 `json:"window_end"`
+}
+
+// listSpendingBudgets returns the budgets of a user with their spending in
+// the current window.
+func listSpendingBudgets(c *gin.Context, userId int) {
+	budgets, err := model.GetUserSpendingBudgets(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	now := time.Now()
+	items := make([]spendingBudgetItem, 0, len(budgets))
+	for _, b := range budgets {
+		start, end := service.SpendingBudgetWindow(b, now)
+		usage, err := model.GetSpendingBudgetUsage(b.Id, start.Unix())
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		items = append(items, spendingBudgetItem{
+			SpendingBudget: b,
+			SpentQuota:     usage.SpentQuota,
+			WindowStart:    start.Unix(),
+			WindowEnd:      end.Unix(),
+		})
+	}
+	common.ApiSuccess(c, items)
+}
+
+func createSpendingBudget(c *gin.Context, userId int, adminManaged bool) (*model.SpendingBudget, bool) {
+	req := spendingBudgetRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	budgets, err := model.GetUserSpendingBudgets(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	if len(budgets) >= maxSpendingBudgetsPerUser {
+		common.ApiErrorMsg(c, "消费预算数量已达上限 "+strconv.Itoa(maxSpendingBudgetsPerUser))
+		return nil, false
+	}
+	b := &model.SpendingBudget{UserId: userId, AdminManaged: adminManaged, Enabled: true}
+	if err := req.apply(b); err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	if err := b.Insert(); err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	service.InvalidateSpendingBudgetCache(userId)
+	return b, true
+}
+
+func updateSpendingBudget(c *gin.Context, b *model.SpendingBudget) bool {
+	req := spendingBudgetRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return false
+	}
+	if err := req.apply(b); err != nil {
+		common.ApiError(c, err)
+		return false
+	}
+	if err := b.Update(); err != nil {
+		common.ApiError(c, err)
+		return false
+	}
+	service.InvalidateSpendingBudgetCache(b.UserId)
+	return true
+}
+
+// getOwnSpendingBudget loads a budget of the current user that they may
+// change.
+func getOwnSpendingBudget(c *gin.Context) (*model.SpendingBudget, bool) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	b, err := model.GetSpendingBudgetById(id)
+	if err != nil || b.UserId != c.GetInt("id") {
+		common.ApiErrorMsg(c, "消费预算不存在")
+		return nil, false
+	}
+	if b.AdminManaged {
+		common.ApiErrorMsg(c, "该消费预算由管理员设置，无法修改")
+		return nil, false
+	}
+	return b, true
+}
+
+func GetSpendingBudgets(c *gin.Context) {
+	listSpendingBudgets(c, c.GetInt("id"))
+}
+
+func CreateSpendingBudget(c *gin.Context) {
+	if b, ok := createSpendingBudget(c, c.GetInt("id"), false); ok {
+		common.ApiSuccess(c, b)
+	}
+}
+
+func UpdateSpendingBudget(c *gin.Context) {
+	b, ok := getOwnSpendingBudget(c)
+	if !ok {
+		return
+	}
+	if updateSpendingBudget(c, b) {
+		common.ApiSuccess(c, b)
+	}
+}
+
+func DeleteSpendingBudget(c *gin.Context) {
+	b, ok := getOwnSpendingBudget(c)
+	if !ok {
+		return
+	}
+	if err := b.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.InvalidateSpendingBudgetCache(b.UserId)
+	common.ApiSuccess(c, nil)
+}
+
+func AdminGetSpendingBudgets(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	if userId == 0 {
+		common.ApiErrorMsg(c, "缺少 user_id")
+		return
+	}
+	listSpendingBudgets(c, userId)
+}
+
+// AdminCreateSpendingBudget sets a budget the user cannot change or remove.
+func AdminCreateSpendingBudget(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	if _, err := model.GetUserById(userId, false); err != nil {
+		common.ApiErrorMsg(c, "用户不存在")
+		return
+	}
+	b, ok := createSpendingBudget(c, userId, true)
+	if !ok {
+		return
+	}
+	service.RecordAudit(c, "budget.create", "spending_budget", b.Id, nil, b)
+	common.ApiSuccess(c, b)
+}
+
+func AdminUpdateSpendingBudget(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	b, err := model.GetSpendingBudgetById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	before := *b
+	if !updateSpendingBudget(c, b) {
+		return
+	}
+	service.RecordAudit(c, "budget.update", "spending_budget", b.Id, before, b)
+	common.ApiSuccess(c, b)
+}
+
+func AdminDeleteSpendingBudget(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	b, err := model.GetSpendingBudgetById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := b.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.InvalidateSpendingBudgetCache(b.UserId)
+	service.RecordAudit(c, "budget.delete", "spending_budget", b.Id, b, nil)
+	common.ApiSuccess(c, nil)
+}
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/payment"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/subscription"
+)
+
+type subscriptionPlanRequest struct {
+	PlanId int `json:"plan_id"`
+}
+
+// getSubscribablePlan returns a public, active plan with a Stripe price.
+func getSubscribablePlan(planId int) (*model.Plan, error) {
+	plan, err := model.GetPlanById(planId)
+	if err != nil || plan == nil || !plan.IsActive || !plan.IsPublic || plan.StripePriceId == "" {
+		return nil, errors.New("套餐不存在或不可订阅")
+	}
+	return plan, nil
+}
+
+// GetSubscriptionPlans lists the plans users can subscribe to.
+func GetSubscriptionPlans(c *gin.Context) {
+	var plans []model.Plan
+	err := model.DB.Where("is_public = ? AND is_active = ? AND stripe_price_id <> ? AND deleted_at IS NULL", true, true, "").Order("id asc").Find(&plans).Error
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+func GetSelfSubscriptions(c *gin.Context) {
+	subs, err := model.GetUserPlanSubscriptions(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, subs)
+}
+
+// SubscribePlan starts a Stripe Checkout session in subscription mode. The
+// plan is assigned once the first invoice is paid.
+func SubscribePlan(c *gin.Context) {
+	req := subscriptionPlanRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	plan, err := getSubscribablePlan(req.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userId := c.GetInt("id")
+	subs, err := model.GetUserPlanSubscriptions(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for _, sub := range subs {
+		if sub.IsLive() {
+			common.ApiErrorMsg(c, "已有生效中的订阅，请更换套餐而不是重复订阅")
+			return
+		}
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	payLink, err := genStripeSubscriptionLink(user, plan)
+	if err != nil {
+		log.Println("获取Stripe订阅支付链接失败", err)
+		common.ApiErrorMsg(c, "拉起支付失败")
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"pay_link": payLink,
+	})
+}
+
+// CancelSubscription cancels at the end of the paid period; the plan stays
+// until then.
+func CancelSubscription(c *gin.Context) {
+	sub, ok := getLiveSubscription(c)
+	if !ok {
+		return
+	}
+	if err := payment.SetStripeKey(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	_, err := subscription.Update(sub.StripeSubscriptionId, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	})
+	if err != nil {
+		log.Println("取消Stripe订阅失败", err)
+		common.ApiErrorMsg(c, "取消订阅失败")
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// ChangeSubscriptionPlan moves the subscription to another plan. The
+// prorated difference is invoiced at once and the new plan applies when it
+// is paid.
+func ChangeSubscriptionPlan(c *gin.Context) {
+	sub, ok := getLiveSubscription(c)
+	if !ok {
+		return
+	}
+	req := subscriptionPlanRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	if req.PlanId == sub.PlanId {
+		common.ApiErrorMsg(c, "已订阅该套餐")
+		return
+	}
+	plan, err := getSubscribablePlan(req.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := payment.SetStripeKey(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	current, err := subscription.Get(sub.StripeSubscriptionId, nil)
+	if err != nil || current.Items == nil || len(current.Items.Data) == 0 {
+		log.Println("获取Stripe订阅失败", err)
+		common.ApiErrorMsg(c, "更换套餐失败")
+		return
+	}
+	_, err = subscription.Update(sub.StripeSubscriptionId, &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:    stripe.String(current.Items.Data[0].ID),
+				Price: stripe.String(plan.StripePriceId),
+			},
+		},
+		ProrationBehavior: stripe.String("always_invoice"),
+		PaymentBehavior:   stripe.String("pending_if_incomplete"),
+		CancelAtPeriodEnd: stripe.Bool(false),
+	})
+	if err != nil {
+		log.Println("更换Stripe订阅套餐失败", err)
+		common.ApiErrorMsg(c, "更换套餐失败")
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func getLiveSubscription(c *gin.Context) (*model.PlanSubscription, bool) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	sub, err := model.GetUserPlanSubscriptionById(id, c.GetInt("id"))
+	if err != nil || !sub.IsLive() {
+		common.ApiErrorMsg(c, "订阅不存在或已结束")
+		return nil, false
+	}
+	return sub, true
+}
+
+// handleStripeSubscriptionEvent answers 500 on failure so Stripe retries,
+// which also covers events arriving before the ones they depend on.
+func handleStripeSubscriptionEvent(c *gin.Context, event stripe.Event) bool {
+	if err := service.HandleStripeSubscriptionEvent(event); err != nil {
+		log.Printf("处理Stripe订阅事件失败: %s, %v\n", event.Type, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func genStripeSubscriptionLink(user *model.User, plan *model.Plan) (string, error) {
+	if err := payment.SetStripeKey(); err != nil {
+		return "", err
+	}
+	metadata := map[string]string{
+		service.SubscriptionMetadataUserId: strconv.Itoa(user.Id),
+		service.SubscriptionMetadataPlanId: strconv.Itoa(plan.Id),
+	}
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(fmt.Sprintf("sub-%d-%d", user.Id, plan.Id)),
+		SuccessURL:        stripe.String(system_setting.ServerAddress + "/console/topup"),
+		CancelURL:         stripe.String(system_setting.ServerAddress + "/console/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(plan.StripePriceId),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode:     stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		Metadata: metadata,
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: metadata,
+		},
+		AllowPromotionCodes: stripe.Bool(setting.StripePromotionCodesEnabled),
+	}
+	if user.StripeCustomer != "" {
+		params.Customer = stripe.String(user.StripeCustomer)
+	} else if user.Email != "" {
+		params.CustomerEmail = stripe.String(user.Email)
+	}
+	result, err := session.New(params)
+	if err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
@@ -4,7 +4,6 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -77,8 +76,7 @@ func GetTicketList(c *gin.Context) {
 	}
 	
 	userIdInt := userId.(int)
-	role := session.Get("role")
-	isAdmin := role != nil && (role.(int) >= common.RoleAdminUser)
+	isAdmin := hasAdminPermission(c, "ticket:read")
 	
 	// Get pagination parameters
 	pageNum, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -133,8 +131,7 @@ func GetTicketDetail(c *gin.Context) {
 	}
 	
 	userIdInt := userId.(int)
-	role := session.Get("role")
-	isAdmin := role != nil && (role.(int) >= common.RoleAdminUser)
+	isAdmin := hasAdminPermission(c, "ticket:read")
 	
 	ticketId, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -221,8 +218,7 @@ func UpdateTicketStatus(c *gin.Context) {
 	}
 	
 	userIdInt := userId.(int)
-	role := session.Get("role")
-	isAdmin := role != nil && (role.(int) >= common.RoleAdminUser)
+	isAdmin := hasAdminPermission(c, "ticket:write")
 	
 	ticketId, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -270,8 +266,7 @@ func DeleteTicket(c *gin.Context) {
 	}
 	
 	userIdInt := userId.(int)
-	role := session.Get("role")
-	isAdmin := role != nil && (role.(int) >= common.RoleAdminUser)
+	isAdmin := hasAdminPermission(c, "ticket:write")
 	
 	ticketId, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -153,6 +153,13 @@ func AddToken(c *gin.Context) {
         })
         return
     }
+    if _, err := common.ParseFundingWaterfall(token.FundingWaterfall); err != nil {
+        c.JSON(http.StatusOK, gin.H{
+            "success": false,
+            "message": "无效的扣费来源顺序: " + err.Error(),
+        })
+        return
+    }
     key, err := common.GenerateKey()
     if err != nil {
         c.JSON(http.StatusOK, gin.H{
@@ -173,8 +180,12 @@ func AddToken(c *gin.Context) {
         UnlimitedQuota:     token.UnlimitedQuota,
         ModelLimitsEnabled: token.ModelLimitsEnabled,
         ModelLimits:        token.ModelLimits,
+        McpLimitsEnabled:   token.McpLimitsEnabled,
+        McpLimits:          token.McpLimits,
         AllowIps:           token.AllowIps,
         Group:              token.Group,
+        ConversationLoggingEnabled: token.ConversationLoggingEnabled,
+        FundingWaterfall:   token.FundingWaterfall,
     }
     err = cleanToken.Insert()
     if err != nil {
@@ -231,6 +242,13 @@ func UpdateToken(c *gin.Context) {
         })
         return
     }
+    if _, err := common.ParseFundingWaterfall(token.FundingWaterfall); err != nil {
+        c.JSON(http.StatusOK, gin.H{
+            "success": false,
+            "message": "无效的扣费来源顺序: " + err.Error(),
+        })
+        return
+    }
     cleanToken, err := model.GetTokenByIds(token.Id, userId)
     if err != nil {
         common.ApiError(c, err)
@@ -350,8 +368,12 @@ func UpdateToken(c *gin.Context) {
         cleanToken.UnlimitedQuota = token.UnlimitedQuota
         cleanToken.ModelLimitsEnabled = token.ModelLimitsEnabled
         cleanToken.ModelLimits = token.ModelLimits
+        cleanToken.McpLimitsEnabled = token.McpLimitsEnabled
+        cleanToken.McpLimits = token.McpLimits
         cleanToken.AllowIps = token.AllowIps
         cleanToken.Group = token.Group
+        cleanToken.ConversationLoggingEnabled = token.ConversationLoggingEnabled
+        cleanToken.FundingWaterfall = token.FundingWaterfall
         if modeProvided || requestedMode != cleanToken.BillingMode {
             cleanToken.BillingMode = requestedMode
         }
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/tokenizers"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+type tokenizerCountRequest struct {
+	Model string `json:"model"`
+	Text  string `json:"text"`
+}
+
+// GetTokenizers returns the configured rules, the tokenizers actually loaded
+// and the current calibration factors.
+func GetTokenizers(c *gin.Context) {
+	cfg := config.GetTokenizerConfig()
+	common.ApiSuccess(c, gin.H{
+		"dir":          cfg.Dir,
+		"rules":        cfg.Rules,
+		"loaded":       tokenizers.Loaded(),
+		"calibrations": tokenizers.Calibrations(),
+	})
+}
+
+// ReloadTokenizers reloads the tokenizer files after the directory or rules change.
+func ReloadTokenizers(c *gin.Context) {
+	infos, err := service.ReloadTokenizers()
+	if err != nil {
+		common.ApiErrorMsg(c, "部分分词器加载失败: "+err.Error())
+		return
+	}
+	common.ApiSuccess(c, infos)
+}
+
+// CalibrateTokenizers recomputes calibration factors from the consume logs now.
+func CalibrateTokenizers(c *gin.Context) {
+	factors, err := service.CalibrateTokenizers()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, factors)
+}
+
+// CountTokenizerTokens counts text with the tokenizer resolved for a model,
+// to check a tokenizer file against upstream counts.
+func CountTokenizerTokens(c *gin.Context) {
+	var req tokenizerCountRequest
+	if err := common.DecodeJson(c.Request.Body, &req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	tokens := service.CountTextToken(req.Text, req.Model)
+	common.ApiSuccess(c, gin.H{
+		"tokens":            tokens,
+		"calibrated_tokens": tokenizers.Calibrate(req.Model, tokens),
+		"factor":            tokenizers.CalibrationFactor(req.Model),
+		"dedicated":         tokenizers.Resolve(req.Model) != nil,
+	})
+}
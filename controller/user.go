@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,6 +14,7 @@ import (
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
 
 	"github.com/QuantumNous/new-api/constant"
@@ -232,34 +234,10 @@ func Register(c *gin.Context) {
 	}
 	// 生成默认令牌
 	if constant.GenerateDefaultToken {
-		key, err := common.GenerateKey()
-		if err != nil {
+		if err := createDefaultToken(insertedUser.Id, cleanUser.Username); err != nil {
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
-				"message": "生成默认令牌失败",
-			})
-			common.SysLog("failed to generate token key: " + err.Error())
-			return
-		}
-		// 生成默认令牌
-		token := model.Token{
-			UserId:             insertedUser.Id, // 使用插入后的用户ID
-			Name:               cleanUser.Username + "的初始令牌",
-			Key:                key,
-			CreatedTime:        common.GetTimestamp(),
-			AccessedTime:       common.GetTimestamp(),
-			ExpiredTime:        -1,     // 永不过期
-			RemainQuota:        500000, // 示例额度
-			UnlimitedQuota:     true,
-			ModelLimitsEnabled: false,
-		}
-		if setting.DefaultUseAutoGroup {
-			token.Group = "auto"
-		}
-		if err := token.Insert(); err != nil {
-			c.JSON(http.StatusOK, gin.H{
-				"success": false,
-				"message": "创建默认令牌失败",
+				"message": err.Error(),
 			})
 			return
 		}
@@ -272,6 +250,33 @@ func Register(c *gin.Context) {
 	return
 }
 
+// createDefaultToken 为新注册的用户生成默认令牌
+func createDefaultToken(userId int, username string) error {
+	key, err := common.GenerateKey()
+	if err != nil {
+		common.SysLog("failed to generate token key: " + err.Error())
+		return errors.New("生成默认令牌失败")
+	}
+	token := model.Token{
+		UserId:             userId,
+		Name:               username + "的初始令牌",
+		Key:                key,
+		CreatedTime:        common.GetTimestamp(),
+		AccessedTime:       common.GetTimestamp(),
+		ExpiredTime:        -1,     // 永不过期
+		RemainQuota:        500000, // 示例额度
+		UnlimitedQuota:     true,
+		ModelLimitsEnabled: false,
+	}
+	if setting.DefaultUseAutoGroup {
+		token.Group = "auto"
+	}
+	if err := token.Insert(); err != nil {
+		return errors.New("创建默认令牌失败")
+	}
+	return nil
+}
+
 func GetAllUsers(c *gin.Context) {
 	pageInfo := common.GetPageQuery(c)
 	users, total, err := model.GetAllUsers(pageInfo)
@@ -314,12 +319,8 @@ func GetUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "无权获取同级或更高等级用户的信息",
-		})
+	if err := checkUserManageable(c, user); err != nil {
+		common.ApiError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -438,7 +439,7 @@ func GetSelf(c *gin.Context) {
 	user.Remark = ""
 
 	// 计算用户权限信息
-	permissions := calculateUserPermissions(userRole)
+	permissions := calculateUserPermissions(id, userRole)
 
 	// 获取用户设置并提取sidebar_modules
 	userSetting := user.GetSetting()
@@ -480,9 +481,16 @@ func GetSelf(c *gin.Context) {
 }
 
 // 计算用户权限的辅助函数
-func calculateUserPermissions(userRole int) map[string]interface{} {
+func calculateUserPermissions(userId int, userRole int) map[string]interface{} {
 	permissions := map[string]interface{}{}
 
+	// 管理权限：内置角色与分配的自定义角色
+	adminPermissions, err := model.GetUserAdminPermissions(userId, userRole)
+	if err != nil {
+		common.SysLog("failed to load admin permissions: " + err.Error())
+	}
+	permissions["admin"] = adminPermissions
+
 	// 根据用户角色计算权限
 	if userRole == common.RoleRootUser {
 		// 超级管理员不需要边栏设置功能
@@ -496,6 +504,21 @@ func calculateUserPermissions(userRole int) map[string]interface{} {
 				"setting": false, // 管理员不能访问系统设置
 			},
 		}
+	} else if len(adminPermissions) > 0 {
+		// 拥有自定义管理角色的用户只显示有读权限的管理模块
+		can := func(resource string) bool {
+			return model.AdminPermissionGranted(adminPermissions, resource+":"+model.AdminActionRead)
+		}
+		permissions["sidebar_settings"] = true
+		permissions["sidebar_modules"] = map[string]interface{}{
+			"admin": map[string]interface{}{
+				"channel":    can("channel"),
+				"models":     can("model"),
+				"redemption": can("redemption"),
+				"user":       can("user"),
+				"setting":    can("setting"),
+			},
+		}
 	} else {
 		// 普通用户只能设置个人功能，不包含管理员区域
 		permissions["sidebar_settings"] = true
@@ -621,15 +644,11 @@ func UpdateUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= originUser.Role && myRole != common.RoleRootUser {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "无权更新同权限等级或更高权限等级的用户信息",
-		})
+	if err := checkUserManageable(c, originUser); err != nil {
+		common.ApiError(c, err)
 		return
 	}
-	if myRole <= updatedUser.Role && myRole != common.RoleRootUser {
+	if updatedUser.Role != originUser.Role && !assignableUserRole(c, updatedUser.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权将其他用户权限等级提升到大于等于自己的权限等级",
@@ -647,6 +666,9 @@ func UpdateUser(c *gin.Context) {
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", logger.LogQuota(originUser.Quota), logger.LogQuota(updatedUser.Quota)))
 	}
+	if currentUser, err := model.GetUserById(updatedUser.Id, false); err == nil {
+		service.RecordAudit(c, "user.update", "user", updatedUser.Id, originUser, currentUser)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -784,15 +806,21 @@ func DeleteUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= originUser.Role {
+	if originUser.Role == common.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无权删除同权限等级或更高权限等级的用户",
+			"message": "无法删除超级管理员用户",
 		})
 		return
 	}
+	if err := checkUserManageable(c, originUser); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	err = model.HardDeleteUserById(id)
+	if err == nil {
+		service.RecordAudit(c, "user.delete", "user", id, originUser, nil)
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -847,8 +875,7 @@ func CreateUser(c *gin.Context) {
 	if user.DisplayName == "" {
 		user.DisplayName = user.Username
 	}
-	myRole := c.GetInt("role")
-	if user.Role >= myRole {
+	if !assignableUserRole(c, user.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无法创建权限大于等于自己的用户",
@@ -866,6 +893,7 @@ func CreateUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "user.create", "user", cleanUser.Id, nil, gin.H{"username": cleanUser.Username, "display_name": cleanUser.DisplayName, "role": cleanUser.Role})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -903,14 +931,12 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "无权更新同权限等级或更高权限等级的用户信息",
-		})
+	if err := checkUserManageable(c, &user); err != nil {
+		common.ApiError(c, err)
 		return
 	}
+	myRole := c.GetInt("role")
+	before := gin.H{"role": user.Role, "status": user.Status}
 	switch req.Action {
 	case "disable":
 		user.Status = common.UserStatusDisabled
@@ -976,6 +1002,7 @@ func ManageUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, "user."+req.Action, "user", user.Id, before, gin.H{"role": user.Role, "status": user.Status})
 	clearUser := model.User{
 		Role:   user.Role,
 		Status: user.Status,
@@ -1108,6 +1135,7 @@ type UpdateUserSettingRequest struct {
 	GotifyPriority             int     `json:"gotify_priority,omitempty"`
 	AcceptUnsetModelRatioModel bool    `json:"accept_unset_model_ratio_model"`
 	RecordIpLog                bool    `json:"record_ip_log"`
+	FundingWaterfall           string  `json:"funding_waterfall,omitempty"`
 }
 
 func UpdateUserSetting(c *gin.Context) {
@@ -1230,6 +1258,15 @@ func UpdateUserSetting(c *gin.Context) {
 		}
 	}
 
+	// 验证扣费来源顺序
+	if _, err := common.ParseFundingWaterfall(req.FundingWaterfall); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的扣费来源顺序: " + err.Error(),
+		})
+		return
+	}
+
 	userId := c.GetInt("id")
 	user, err := model.GetUserById(userId, true)
 	if err != nil {
@@ -1243,6 +1280,7 @@ func UpdateUserSetting(c *gin.Context) {
 		QuotaWarningThreshold: req.QuotaWarningThreshold,
 		AcceptUnsetRatioModel: req.AcceptUnsetModelRatioModel,
 		RecordIpLog:           req.RecordIpLog,
+		FundingWaterfall:      req.FundingWaterfall,
 	}
 
 	// 如果是webhook类型,添加webhook相关设置
@@ -79,6 +79,7 @@ func GenerateVouchers(c *gin.Context) {
         })
         return
     }
+    service.RecordAudit(c, "voucher.generate", "voucher_batch", nil, nil, gin.H{"request": req, "count": len(codes)})
 
     c.JSON(http.StatusOK, gin.H{
         "success": true,
@@ -254,6 +255,7 @@ func IssueVoucherCode(c *gin.Context) {
         common.ApiError(c, err)
         return
     }
+    service.RecordAudit(c, "voucher.issue", "voucher_code", codeId, nil, updates)
 
     c.JSON(http.StatusOK, gin.H{
         "success": true,
@@ -287,11 +289,13 @@ func RevokeVoucherCode(c *gin.Context) {
         return
     }
 
+    before := voucherCode.Status
     err = model.DB.Model(&voucherCode).Update("status", model.VoucherCodeStatusExpired).Error
     if err != nil {
         common.ApiError(c, err)
         return
     }
+    service.RecordAudit(c, "voucher.revoke", "voucher_code", codeId, gin.H{"status": before}, gin.H{"status": model.VoucherCodeStatusExpired})
 
     c.JSON(http.StatusOK, gin.H{
         "success": true,
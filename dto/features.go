@@ -26,6 +26,8 @@ type GovernanceFeatureConfig struct {
 	AbuseRPMThreshold int    `json:"abuseRpmThreshold,omitempty"`
 	RerouteModelAlias string `json:"rerouteModelAlias,omitempty"`
 	FlagTTLHours      int    `json:"flagTtlHours,omitempty"`
+	DryRun            bool   `json:"dryRun"`
+	PolicyCount       int    `json:"policyCount"`
 }
 
 // GovernanceFeatureUpdate represents governance config partial updates.
@@ -34,6 +36,7 @@ type GovernanceFeatureUpdate struct {
 	AbuseRPMThreshold *int    `json:"abuseRpmThreshold"`
 	RerouteModelAlias *string `json:"rerouteModelAlias"`
 	FlagTTLHours      *int    `json:"flagTtlHours"`
+	DryRun            *bool   `json:"dryRun"`
 }
 
 // PublicLogsFeatureConfig exposes public log feature switches to clients.
@@ -0,0 +1,121 @@
+package dto
+
+// Gemini Live (BidiGenerateContent) WebSocket messages. Only the subset used
+// by the realtime bridge is modelled.
+
+type GeminiLiveClientMessage struct {
+	Setup         *GeminiLiveSetup         `json:"setup,omitempty"`
+	ClientContent *GeminiLiveClientContent `json:"clientContent,omitempty"`
+	RealtimeInput *GeminiLiveRealtimeInput `json:"realtimeInput,omitempty"`
+	ToolResponse  *GeminiLiveToolResponse  `json:"toolResponse,omitempty"`
+}
+
+type GeminiLiveSetup struct {
+	Model                    string                         `json:"model"`
+	GenerationConfig         *GeminiLiveGenerationConfig    `json:"generationConfig,omitempty"`
+	SystemInstruction        *GeminiChatContent             `json:"systemInstruction,omitempty"`
+	Tools                    []GeminiLiveTool               `json:"tools,omitempty"`
+	RealtimeInputConfig      *GeminiLiveRealtimeInputConfig `json:"realtimeInputConfig,omitempty"`
+	InputAudioTranscription  *struct{}                      `json:"inputAudioTranscription,omitempty"`
+	OutputAudioTranscription *struct{}                      `json:"outputAudioTranscription,omitempty"`
+}
+
+type GeminiLiveGenerationConfig struct {
+	ResponseModalities []string                `json:"responseModalities,omitempty"`
+	Temperature        *float64                `json:"temperature,omitempty"`
+	SpeechConfig       *GeminiLiveSpeechConfig `json:"speechConfig,omitempty"`
+}
+
+type GeminiLiveSpeechConfig struct {
+	VoiceConfig GeminiLiveVoiceConfig `json:"voiceConfig"`
+}
+
+type GeminiLiveVoiceConfig struct {
+	PrebuiltVoiceConfig GeminiLivePrebuiltVoice `json:"prebuiltVoiceConfig"`
+}
+
+type GeminiLivePrebuiltVoice struct {
+	VoiceName string `json:"voiceName"`
+}
+
+type GeminiLiveTool struct {
+	FunctionDeclarations []GeminiLiveFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GeminiLiveFunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type GeminiLiveRealtimeInputConfig struct {
+	AutomaticActivityDetection *GeminiLiveActivityDetection `json:"automaticActivityDetection,omitempty"`
+}
+
+type GeminiLiveActivityDetection struct {
+	Disabled bool `json:"disabled"`
+}
+
+type GeminiLiveClientContent struct {
+	Turns        []GeminiChatContent `json:"turns,omitempty"`
+	TurnComplete bool                `json:"turnComplete"`
+}
+
+type GeminiLiveRealtimeInput struct {
+	Audio          *GeminiInlineData `json:"audio,omitempty"`
+	ActivityStart  *struct{}         `json:"activityStart,omitempty"`
+	ActivityEnd    *struct{}         `json:"activityEnd,omitempty"`
+	AudioStreamEnd bool              `json:"audioStreamEnd,omitempty"`
+}
+
+type GeminiLiveToolResponse struct {
+	FunctionResponses []GeminiLiveFunctionResponse `json:"functionResponses"`
+}
+
+type GeminiLiveFunctionResponse struct {
+	Id       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type GeminiLiveServerMessage struct {
+	SetupComplete *struct{}                `json:"setupComplete,omitempty"`
+	ServerContent *GeminiLiveServerContent `json:"serverContent,omitempty"`
+	ToolCall      *GeminiLiveToolCall      `json:"toolCall,omitempty"`
+	UsageMetadata *GeminiLiveUsageMetadata `json:"usageMetadata,omitempty"`
+	GoAway        *GeminiLiveGoAway        `json:"goAway,omitempty"`
+}
+
+type GeminiLiveServerContent struct {
+	ModelTurn           *GeminiChatContent       `json:"modelTurn,omitempty"`
+	TurnComplete        bool                     `json:"turnComplete,omitempty"`
+	Interrupted         bool                     `json:"interrupted,omitempty"`
+	InputTranscription  *GeminiLiveTranscription `json:"inputTranscription,omitempty"`
+	OutputTranscription *GeminiLiveTranscription `json:"outputTranscription,omitempty"`
+}
+
+type GeminiLiveTranscription struct {
+	Text string `json:"text"`
+}
+
+type GeminiLiveToolCall struct {
+	FunctionCalls []GeminiLiveFunctionCall `json:"functionCalls"`
+}
+
+type GeminiLiveFunctionCall struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Args any    `json:"args"`
+}
+
+type GeminiLiveUsageMetadata struct {
+	PromptTokenCount      int                         `json:"promptTokenCount"`
+	ResponseTokenCount    int                         `json:"responseTokenCount"`
+	TotalTokenCount       int                         `json:"totalTokenCount"`
+	PromptTokensDetails   []GeminiPromptTokensDetails `json:"promptTokensDetails"`
+	ResponseTokensDetails []GeminiPromptTokensDetails `json:"responseTokensDetails"`
+}
+
+type GeminiLiveGoAway struct {
+	TimeLeft string `json:"timeLeft"`
+}
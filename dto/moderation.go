@@ -0,0 +1,65 @@
+package dto
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ModerationRequest struct {
+	Model string `json:"model"`
+	Input any    `json:"input"`
+}
+
+func (r *ModerationRequest) GetTokenCountMeta() *types.TokenCountMeta {
+	return &types.TokenCountMeta{
+		CombineText: strings.Join(r.ParseInput(), "\n"),
+	}
+}
+
+func (r *ModerationRequest) IsStream(c *gin.Context) bool {
+	return false
+}
+
+func (r *ModerationRequest) SetModelName(modelName string) {
+	if modelName != "" {
+		r.Model = modelName
+	}
+}
+
+// ParseInput returns the text inputs: a string, a list of strings or a list
+// of multimodal parts, of which only the text parts are kept.
+func (r *ModerationRequest) ParseInput() []string {
+	switch input := r.Input.(type) {
+	case string:
+		return []string{input}
+	case []any:
+		inputs := make([]string, 0, len(input))
+		for _, item := range input {
+			switch v := item.(type) {
+			case string:
+				inputs = append(inputs, v)
+			case map[string]any:
+				if text, ok := v["text"].(string); ok && v["type"] == "text" {
+					inputs = append(inputs, text)
+				}
+			}
+		}
+		return inputs
+	}
+	return nil
+}
+
+type ModerationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type ModerationResponse struct {
+	Id      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []ModerationResult `json:"results"`
+}
@@ -0,0 +1,359 @@
+package dto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Ingress types for clients speaking Ollama's native API (/api/chat,
+// /api/generate, /api/embed) or llama.cpp's /completion. They are converted
+// to the OpenAI-compatible requests the relay works with; the egress types in
+// relay/channel/ollama are kept separate.
+
+type OllamaIngressToolCall struct {
+	Function struct {
+		Index     *int            `json:"index,omitempty"`
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type OllamaIngressMessage struct {
+	Role      string                  `json:"role"`
+	Content   string                  `json:"content"`
+	Thinking  string                  `json:"thinking,omitempty"`
+	Images    []string                `json:"images,omitempty"`
+	ToolCalls []OllamaIngressToolCall `json:"tool_calls,omitempty"`
+	ToolName  string                  `json:"tool_name,omitempty"`
+}
+
+type OllamaOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             float64  `json:"top_p,omitempty"`
+	TopK             int      `json:"top_k,omitempty"`
+	NumPredict       int      `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             float64  `json:"seed,omitempty"`
+	FrequencyPenalty float64  `json:"frequency_penalty,omitempty"`
+	PresencePenalty  float64  `json:"presence_penalty,omitempty"`
+}
+
+type OllamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []OllamaIngressMessage `json:"messages"`
+	Tools    []ToolCallRequest      `json:"tools,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"`
+	Options  *OllamaOptions         `json:"options,omitempty"`
+	Stream   *bool                  `json:"stream,omitempty"`
+	Think    json.RawMessage        `json:"think,omitempty"`
+}
+
+type OllamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	Suffix  string          `json:"suffix,omitempty"`
+	System  string          `json:"system,omitempty"`
+	Images  []string        `json:"images,omitempty"`
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options *OllamaOptions  `json:"options,omitempty"`
+	Stream  *bool           `json:"stream,omitempty"`
+	Think   json.RawMessage `json:"think,omitempty"`
+}
+
+// OllamaEmbedRequest covers both /api/embed (input) and the legacy
+// /api/embeddings (prompt).
+type OllamaEmbedRequest struct {
+	Model      string `json:"model"`
+	Input      any    `json:"input,omitempty"`
+	Prompt     string `json:"prompt,omitempty"`
+	Dimensions int    `json:"dimensions,omitempty"`
+}
+
+type LlamaCppCompletionRequest struct {
+	Prompt           any      `json:"prompt"`
+	Model            string   `json:"model,omitempty"`
+	NPredict         int      `json:"n_predict,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             float64  `json:"top_p,omitempty"`
+	TopK             int      `json:"top_k,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             float64  `json:"seed,omitempty"`
+	FrequencyPenalty float64  `json:"frequency_penalty,omitempty"`
+	PresencePenalty  float64  `json:"presence_penalty,omitempty"`
+	Stream           bool     `json:"stream,omitempty"`
+}
+
+type OllamaChatResponse struct {
+	Model              string                `json:"model"`
+	CreatedAt          string                `json:"created_at"`
+	Message            *OllamaIngressMessage `json:"message,omitempty"`
+	Response           *string               `json:"response,omitempty"`
+	Thinking           string                `json:"thinking,omitempty"`
+	Done               bool                  `json:"done"`
+	DoneReason         string                `json:"done_reason,omitempty"`
+	TotalDuration      int64                 `json:"total_duration,omitempty"`
+	LoadDuration       int64                 `json:"load_duration,omitempty"`
+	PromptEvalCount    int                   `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64                 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int                   `json:"eval_count,omitempty"`
+	EvalDuration       int64                 `json:"eval_duration,omitempty"`
+}
+
+type OllamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+type OllamaLegacyEmbeddingResponse struct {
+	Embedding []float64 `json:"embedding"`
+}
+
+type OllamaModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+type LlamaCppCompletionResponse struct {
+	Content         string `json:"content"`
+	Model           string `json:"model,omitempty"`
+	Stop            bool   `json:"stop"`
+	StopType        string `json:"stop_type,omitempty"`
+	TokensPredicted int    `json:"tokens_predicted,omitempty"`
+	TokensEvaluated int    `json:"tokens_evaluated,omitempty"`
+}
+
+// Ollama streams unless "stream": false is sent explicitly.
+func ollamaStream(stream *bool) bool {
+	return stream == nil || *stream
+}
+
+func (o *OllamaOptions) apply(request *GeneralOpenAIRequest) {
+	if o == nil {
+		return
+	}
+	request.Temperature = o.Temperature
+	request.TopP = o.TopP
+	request.TopK = o.TopK
+	if o.NumPredict > 0 {
+		request.MaxTokens = uint(o.NumPredict)
+	}
+	if len(o.Stop) > 0 {
+		request.Stop = o.Stop
+	}
+	request.Seed = o.Seed
+	request.FrequencyPenalty = o.FrequencyPenalty
+	request.PresencePenalty = o.PresencePenalty
+}
+
+// ollamaResponseFormat maps "format": "json" or a JSON schema object.
+func ollamaResponseFormat(format json.RawMessage) (*ResponseFormat, error) {
+	trimmed := strings.TrimSpace(string(format))
+	if trimmed == "" || trimmed == "null" || trimmed == `""` {
+		return nil, nil
+	}
+	if trimmed == `"json"` {
+		return &ResponseFormat{Type: "json_object"}, nil
+	}
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil, fmt.Errorf("unsupported format %s", trimmed)
+	}
+	schema, err := json.Marshal(FormatJsonSchema{Name: "response", Schema: json.RawMessage(format)})
+	if err != nil {
+		return nil, err
+	}
+	return &ResponseFormat{Type: "json_schema", JsonSchema: schema}, nil
+}
+
+func ollamaUserMessage(text string, images []string) Message {
+	message := Message{Role: "user"}
+	if len(images) == 0 {
+		message.SetStringContent(text)
+		return message
+	}
+	content := make([]MediaContent, 0, len(images)+1)
+	if text != "" {
+		content = append(content, MediaContent{Type: ContentTypeText, Text: text})
+	}
+	for _, image := range images {
+		url := image
+		if !strings.HasPrefix(image, "data:") && !strings.HasPrefix(image, "http") {
+			url = "data:image/png;base64," + image
+		}
+		content = append(content, MediaContent{Type: ContentTypeImageURL, ImageUrl: &MessageImageUrl{Url: url, Detail: "auto"}})
+	}
+	message.SetMediaContent(content)
+	return message
+}
+
+func (r *OllamaChatRequest) ToOpenAIRequest() (*GeneralOpenAIRequest, error) {
+	if r.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	request := &GeneralOpenAIRequest{
+		Model:  r.Model,
+		Stream: ollamaStream(r.Stream),
+		Tools:  r.Tools,
+		Think:  r.Think,
+	}
+	r.Options.apply(request)
+	format, err := ollamaResponseFormat(r.Format)
+	if err != nil {
+		return nil, err
+	}
+	request.ResponseFormat = format
+
+	// Ollama has no tool call ids; tool results name the tool instead, so ids
+	// are generated and matched back by name in order.
+	pending := make(map[string][]string)
+	callSeq := 0
+	for _, m := range r.Messages {
+		switch m.Role {
+		case "user":
+			request.Messages = append(request.Messages, ollamaUserMessage(m.Content, m.Images))
+		case "assistant":
+			message := Message{Role: "assistant"}
+			message.SetStringContent(m.Content)
+			if len(m.ToolCalls) > 0 {
+				calls := make([]ToolCallRequest, 0, len(m.ToolCalls))
+				for _, call := range m.ToolCalls {
+					callSeq++
+					id := fmt.Sprintf("call_%d", callSeq)
+					pending[call.Function.Name] = append(pending[call.Function.Name], id)
+					arguments := string(call.Function.Arguments)
+					if arguments == "" || arguments == "null" {
+						arguments = "{}"
+					}
+					calls = append(calls, ToolCallRequest{
+						ID:       id,
+						Type:     "function",
+						Function: FunctionRequest{Name: call.Function.Name, Arguments: arguments},
+					})
+				}
+				message.SetToolCalls(calls)
+			}
+			request.Messages = append(request.Messages, message)
+		case "tool":
+			message := Message{Role: "tool"}
+			message.SetStringContent(m.Content)
+			if ids := pending[m.ToolName]; len(ids) > 0 {
+				message.ToolCallId = ids[0]
+				pending[m.ToolName] = ids[1:]
+			}
+			if m.ToolName != "" {
+				name := m.ToolName
+				message.Name = &name
+			}
+			request.Messages = append(request.Messages, message)
+		default:
+			message := Message{Role: m.Role}
+			message.SetStringContent(m.Content)
+			request.Messages = append(request.Messages, message)
+		}
+	}
+	if len(request.Messages) == 0 {
+		return nil, errors.New("messages is required")
+	}
+	return request, nil
+}
+
+func (r *OllamaGenerateRequest) ToOpenAIRequest() (*GeneralOpenAIRequest, error) {
+	if r.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if r.Prompt == "" && len(r.Images) == 0 {
+		return nil, errors.New("prompt is required")
+	}
+	request := &GeneralOpenAIRequest{
+		Model:  r.Model,
+		Stream: ollamaStream(r.Stream),
+		Think:  r.Think,
+	}
+	r.Options.apply(request)
+	format, err := ollamaResponseFormat(r.Format)
+	if err != nil {
+		return nil, err
+	}
+	request.ResponseFormat = format
+	if r.System != "" {
+		system := Message{Role: "system"}
+		system.SetStringContent(r.System)
+		request.Messages = append(request.Messages, system)
+	}
+	request.Messages = append(request.Messages, ollamaUserMessage(r.Prompt, r.Images))
+	return request, nil
+}
+
+func (r *OllamaEmbedRequest) ToEmbeddingRequest() (*EmbeddingRequest, error) {
+	if r.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	input := r.Input
+	if input == nil && r.Prompt != "" {
+		input = r.Prompt
+	}
+	if input == nil {
+		return nil, errors.New("input is required")
+	}
+	return &EmbeddingRequest{Model: r.Model, Input: input, Dimensions: r.Dimensions}, nil
+}
+
+func (r *LlamaCppCompletionRequest) ToOpenAIRequest() (*GeneralOpenAIRequest, error) {
+	if r.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	var prompt string
+	switch v := r.Prompt.(type) {
+	case string:
+		prompt = v
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				parts = append(parts, s)
+			}
+		}
+		prompt = strings.Join(parts, "")
+	}
+	if prompt == "" {
+		return nil, errors.New("prompt is required")
+	}
+	request := &GeneralOpenAIRequest{
+		Model:            r.Model,
+		Stream:           r.Stream,
+		Temperature:      r.Temperature,
+		TopP:             r.TopP,
+		TopK:             r.TopK,
+		Seed:             r.Seed,
+		FrequencyPenalty: r.FrequencyPenalty,
+		PresencePenalty:  r.PresencePenalty,
+	}
+	if r.NPredict > 0 {
+		request.MaxTokens = uint(r.NPredict)
+	}
+	if len(r.Stop) > 0 {
+		request.Stop = r.Stop
+	}
+	request.Messages = append(request.Messages, ollamaUserMessage(prompt, nil))
+	return request, nil
+}
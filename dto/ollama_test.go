@@ -0,0 +1,168 @@
+package dto
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOllamaChatRequestToOpenAI(t *testing.T) {
+	var chat OllamaChatRequest
+	body := `{
+		"model": "llama3",
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": "what is in this picture?", "images": ["aGVsbG8="]},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"function": {"name": "weather", "arguments": {"city": "Paris"}}},
+				{"function": {"name": "time"}},
+				{"function": {"name": "weather", "arguments": {"city": "Rome"}}}
+			]},
+			{"role": "tool", "tool_name": "weather", "content": "sunny"},
+			{"role": "tool", "tool_name": "time", "content": "noon"},
+			{"role": "tool", "tool_name": "weather", "content": "rainy"}
+		],
+		"format": "json",
+		"options": {"temperature": 0.2, "num_predict": 64, "stop": ["\n\n"], "top_k": 5}
+	}`
+	if err := json.Unmarshal([]byte(body), &chat); err != nil {
+		t.Fatal(err)
+	}
+	request, err := chat.ToOpenAIRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !request.Stream {
+		t.Error("stream defaults to true when omitted")
+	}
+	if request.MaxTokens != 64 || request.TopK != 5 || request.Temperature == nil || *request.Temperature != 0.2 {
+		t.Errorf("options not applied: max_tokens=%d top_k=%d temperature=%v", request.MaxTokens, request.TopK, request.Temperature)
+	}
+	if stop, ok := request.Stop.([]string); !ok || len(stop) != 1 || stop[0] != "\n\n" {
+		t.Errorf("stop = %#v", request.Stop)
+	}
+	if request.ResponseFormat == nil || request.ResponseFormat.Type != "json_object" {
+		t.Errorf("response_format = %+v", request.ResponseFormat)
+	}
+	if len(request.Messages) != 6 {
+		t.Fatalf("messages = %d, want 6", len(request.Messages))
+	}
+
+	media := request.Messages[1].ParseContent()
+	if len(media) != 2 || media[0].Text != "what is in this picture?" || media[1].GetImageMedia().Url != "data:image/png;base64,aGVsbG8=" {
+		t.Errorf("user content = %+v", media)
+	}
+
+	calls := request.Messages[2].ParseToolCalls()
+	if len(calls) != 3 {
+		t.Fatalf("tool calls = %d, want 3", len(calls))
+	}
+	if calls[0].Function.Arguments != `{"city": "Paris"}` || calls[1].Function.Arguments != "{}" {
+		t.Errorf("arguments = %q, %q", calls[0].Function.Arguments, calls[1].Function.Arguments)
+	}
+	// tool results name the tool, so repeated calls match back in order
+	for i, want := range []string{calls[0].ID, calls[1].ID, calls[2].ID} {
+		message := request.Messages[3+i]
+		if message.ToolCallId != want {
+			t.Errorf("tool result %d: tool_call_id = %q, want %q", i, message.ToolCallId, want)
+		}
+	}
+	if name := request.Messages[4].Name; name == nil || *name != "time" {
+		t.Errorf("tool result name = %v", name)
+	}
+}
+
+func TestOllamaChatRequestFormatAndStream(t *testing.T) {
+	stream := false
+	chat := OllamaChatRequest{
+		Model:    "llama3",
+		Messages: []OllamaIngressMessage{{Role: "user", Content: "hi"}},
+		Format:   json.RawMessage(`{"type": "object", "properties": {"age": {"type": "integer"}}}`),
+		Stream:   &stream,
+	}
+	request, err := chat.ToOpenAIRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Stream {
+		t.Error("explicit stream false was ignored")
+	}
+	if request.ResponseFormat == nil || request.ResponseFormat.Type != "json_schema" {
+		t.Fatalf("response_format = %+v", request.ResponseFormat)
+	}
+	var schema FormatJsonSchema
+	if err := json.Unmarshal(request.ResponseFormat.JsonSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Name != "response" || len(schema.Schema.(map[string]any)) != 2 {
+		t.Errorf("json_schema = %s", request.ResponseFormat.JsonSchema)
+	}
+
+	chat.Format = json.RawMessage(`"yaml"`)
+	if _, err := chat.ToOpenAIRequest(); err == nil {
+		t.Error("expected an unsupported format to be refused")
+	}
+	if _, err := (&OllamaChatRequest{Messages: chat.Messages}).ToOpenAIRequest(); err == nil {
+		t.Error("expected a missing model to be refused")
+	}
+	if _, err := (&OllamaChatRequest{Model: "llama3"}).ToOpenAIRequest(); err == nil {
+		t.Error("expected missing messages to be refused")
+	}
+}
+
+func TestOllamaGenerateRequestToOpenAI(t *testing.T) {
+	generate := OllamaGenerateRequest{Model: "llama3", System: "you are terse", Prompt: "why is the sky blue?"}
+	request, err := generate.ToOpenAIRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(request.Messages) != 2 || request.Messages[0].Role != "system" || request.Messages[0].StringContent() != "you are terse" {
+		t.Fatalf("messages = %+v", request.Messages)
+	}
+	if request.Messages[1].Role != "user" || request.Messages[1].StringContent() != "why is the sky blue?" {
+		t.Errorf("prompt message = %+v", request.Messages[1])
+	}
+	if _, err := (&OllamaGenerateRequest{Model: "llama3"}).ToOpenAIRequest(); err == nil {
+		t.Error("expected an empty prompt to be refused")
+	}
+}
+
+func TestOllamaEmbedRequestToEmbedding(t *testing.T) {
+	embed := OllamaEmbedRequest{Model: "nomic-embed-text", Input: []any{"a", "b"}, Dimensions: 256}
+	request, err := embed.ToEmbeddingRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input, ok := request.Input.([]any); !ok || len(input) != 2 || request.Dimensions != 256 {
+		t.Errorf("request = %+v", request)
+	}
+
+	// the legacy /api/embeddings body sends prompt instead of input
+	legacy := OllamaEmbedRequest{Model: "nomic-embed-text", Prompt: "hello"}
+	request, err = legacy.ToEmbeddingRequest()
+	if err != nil || request.Input != "hello" {
+		t.Errorf("legacy request = %+v, %v", request, err)
+	}
+	if _, err := (&OllamaEmbedRequest{Model: "nomic-embed-text"}).ToEmbeddingRequest(); err == nil {
+		t.Error("expected a missing input to be refused")
+	}
+}
+
+func TestLlamaCppCompletionRequestToOpenAI(t *testing.T) {
+	var completion LlamaCppCompletionRequest
+	if err := json.Unmarshal([]byte(`{"model": "qwen", "prompt": ["Once ", "upon"], "n_predict": 32, "stream": true}`), &completion); err != nil {
+		t.Fatal(err)
+	}
+	request, err := completion.ToOpenAIRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !request.Stream || request.MaxTokens != 32 {
+		t.Errorf("stream=%v max_tokens=%d", request.Stream, request.MaxTokens)
+	}
+	if len(request.Messages) != 1 || request.Messages[0].StringContent() != "Once upon" {
+		t.Errorf("messages = %+v", request.Messages)
+	}
+	if _, err := (&LlamaCppCompletionRequest{Model: "qwen", Prompt: []any{1, 2}}).ToOpenAIRequest(); err == nil {
+		t.Error("expected a prompt without text to be refused")
+	}
+}
@@ -15,6 +15,8 @@ type PlanCreateRequest struct {
     ValidityDays     int      `json:"validity_days,omitempty" validate:"omitempty,min=0"`
     Price            float64  `json:"price,omitempty" validate:"omitempty,min=0"`
     Status           string   `json:"status,omitempty" validate:"omitempty,oneof=draft active archived"`
+    IsPublic         bool     `json:"is_public,omitempty"`
+    StripePriceId    string   `json:"stripe_price_id,omitempty" validate:"omitempty,max=128"`
 }
 
 // PlanUpdateRequest represents the payload to update a billing plan.
@@ -31,6 +33,8 @@ type PlanUpdateRequest struct {
     ValidityDays     *int      `json:"validity_days,omitempty" validate:"omitempty,min=0"`
     Price            *float64  `json:"price,omitempty" validate:"omitempty,min=0"`
     Status           *string   `json:"status,omitempty" validate:"omitempty,oneof=draft active archived"`
+    IsPublic         *bool     `json:"is_public,omitempty"`
+    StripePriceId    *string   `json:"stripe_price_id,omitempty" validate:"omitempty,max=128"`
 }
 
 // PlanView is a lightweight response model for listing and viewing plan data.
@@ -138,6 +138,9 @@ func main() {
         }
     }()
 
+    // 对话日志保留期清理
+    gopool.Go(service.StartConversationLogRetention)
+
     if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
         frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
         if err != nil {
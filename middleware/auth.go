@@ -27,13 +27,16 @@ func validUserInfo(username string, role int) bool {
 	return true
 }
 
-func authHelper(c *gin.Context, minRole int) {
+// authenticate resolves the session or access token user into the context,
+// aborting when they are not allowed in with minRole.
+func authenticate(c *gin.Context, minRole int) bool {
 	session := sessions.Default(c)
 	username := session.Get("username")
 	role := session.Get("role")
 	id := session.Get("id")
 	status := session.Get("status")
 	useAccessToken := false
+	managementKeyId := 0
 	if username == nil {
 		// Check access token
 		accessToken := c.Request.Header.Get("Authorization")
@@ -43,9 +46,18 @@ func authHelper(c *gin.Context, minRole int) {
 				"message": "无权进行此操作，未登录且未提供 access token",
 			})
 			c.Abort()
-			return
+			return false
+		}
+		var user *model.User
+		if strings.HasPrefix(strings.TrimPrefix(accessToken, "Bearer "), model.ManagementKeyPrefix) {
+			var key *model.ManagementKey
+			if key, user = authenticateManagementKey(c, strings.TrimPrefix(accessToken, "Bearer ")); key == nil {
+				return false
+			}
+			managementKeyId = key.Id
+		} else {
+			user = model.ValidateAccessToken(accessToken)
 		}
-		user := model.ValidateAccessToken(accessToken)
 		if user != nil && user.Username != "" {
 			if !validUserInfo(user.Username, user.Role) {
 				c.JSON(http.StatusOK, gin.H{
@@ -53,7 +65,7 @@ func authHelper(c *gin.Context, minRole int) {
 					"message": "无权进行此操作，用户信息无效",
 				})
 				c.Abort()
-				return
+				return false
 			}
 			// Token is valid
 			username = user.Username
@@ -67,36 +79,36 @@ func authHelper(c *gin.Context, minRole int) {
 				"message": "无权进行此操作，access token 无效",
 			})
 			c.Abort()
-			return
+			return false
 		}
 	}
-	// get header New-Api-User
+	// get header New-Api-User, optional for management keys
 	apiUserIdStr := c.Request.Header.Get("New-Api-User")
-	if apiUserIdStr == "" {
+	if apiUserIdStr == "" && managementKeyId == 0 {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
 			"message": "无权进行此操作，未提供 New-Api-User",
 		})
 		c.Abort()
-		return
+		return false
 	}
 	apiUserId, err := strconv.Atoi(apiUserIdStr)
-	if err != nil {
+	if err != nil && managementKeyId == 0 {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
 			"message": "无权进行此操作，New-Api-User 格式错误",
 		})
 		c.Abort()
-		return
+		return false
 
 	}
-	if id != apiUserId {
+	if apiUserIdStr != "" && id != apiUserId {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
 			"message": "无权进行此操作，New-Api-User 与登录用户不匹配",
 		})
 		c.Abort()
-		return
+		return false
 	}
 	if status.(int) == common.UserStatusDisabled {
 		c.JSON(http.StatusOK, gin.H{
@@ -104,7 +116,7 @@ func authHelper(c *gin.Context, minRole int) {
 			"message": "用户已被封禁",
 		})
 		c.Abort()
-		return
+		return false
 	}
 	if role.(int) < minRole {
 		c.JSON(http.StatusOK, gin.H{
@@ -112,7 +124,7 @@ func authHelper(c *gin.Context, minRole int) {
 			"message": "无权进行此操作，权限不足",
 		})
 		c.Abort()
-		return
+		return false
 	}
 	if !validUserInfo(username.(string), role.(int)) {
 		c.JSON(http.StatusOK, gin.H{
@@ -120,7 +132,7 @@ func authHelper(c *gin.Context, minRole int) {
 			"message": "无权进行此操作，用户信息无效",
 		})
 		c.Abort()
-		return
+		return false
 	}
 	c.Set("username", username)
 	c.Set("role", role)
@@ -128,6 +140,7 @@ func authHelper(c *gin.Context, minRole int) {
 	c.Set("group", session.Get("group"))
 	c.Set("user_group", session.Get("group"))
 	c.Set("use_access_token", useAccessToken)
+	common.SetContextKey(c, constant.ContextKeyManagementKeyId, managementKeyId)
 
 	//userCache, err := model.GetUserCache(id.(int))
 	//if err != nil {
@@ -140,7 +153,85 @@ func authHelper(c *gin.Context, minRole int) {
 	//}
 	//userCache.WriteContext(c)
 
-	c.Next()
+	return true
+}
+
+// authenticateManagementKey resolves a management key and its owner. The
+// key must hold the scope the route declared, for the action the route
+// declared or, failing that, the one implied by the request method.
+func authenticateManagementKey(c *gin.Context, rawKey string) (*model.ManagementKey, *model.User) {
+	key, err := model.GetManagementKeyByKey(rawKey)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "无权进行此操作，管理密钥无效",
+		})
+		c.Abort()
+		return nil, nil
+	}
+	if key.IsExpired() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "无权进行此操作，管理密钥已过期",
+		})
+		c.Abort()
+		return nil, nil
+	}
+	clientIp := c.ClientIP()
+	if !key.AllowsIp(clientIp) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "您的 IP 不在管理密钥允许访问的列表中",
+		})
+		c.Abort()
+		return nil, nil
+	}
+	resource := common.GetContextKeyString(c, constant.ContextKeyManagementKeyScope)
+	action := common.GetContextKeyString(c, constant.ContextKeyManagementKeyAction)
+	if action == "" {
+		action = model.AdminActionWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			action = model.AdminActionRead
+		}
+	}
+	if resource == "" || !key.HasScope(resource, action) {
+		message := "无权进行此操作，管理密钥不能访问此接口"
+		if resource != "" {
+			message = "无权进行此操作，管理密钥缺少权限范围 " + resource + ":" + action
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": message,
+		})
+		c.Abort()
+		return nil, nil
+	}
+	user, err := model.GetUserById(key.UserId, false)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "无权进行此操作，管理密钥无效",
+		})
+		c.Abort()
+		return nil, nil
+	}
+	key.RecordUsage(clientIp)
+	return key, user
+}
+
+// ManagementKeyScope lets management keys holding the scope resource use
+// the routes after it. Routes without a scope reject management keys.
+func ManagementKeyScope(resource string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyManagementKeyScope, resource)
+		c.Next()
+	}
+}
+
+func authHelper(c *gin.Context, minRole int) {
+	if authenticate(c, minRole) {
+		c.Next()
+	}
 }
 
 func TryUserAuth() func(c *gin.Context) {
@@ -172,6 +263,39 @@ func RootAuth() func(c *gin.Context) {
 	}
 }
 
+// PermissionAuth requires an admin permission. A bare resource is checked
+// as "resource:read" for GET requests and "resource:write" otherwise.
+func PermissionAuth(permission string) func(c *gin.Context) {
+	resource, action, _ := strings.Cut(permission, ":")
+	scope := model.AdminResourceScope(resource)
+	return func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyManagementKeyScope, scope)
+		// an explicit action, e.g. GET routes that call upstreams with
+		// "channel:write", applies to management keys too
+		common.SetContextKey(c, constant.ContextKeyManagementKeyAction, action)
+		if !authenticate(c, common.RoleCommonUser) {
+			return
+		}
+		required := permission
+		if !strings.Contains(required, ":") {
+			action := model.AdminActionWrite
+			if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+				action = model.AdminActionRead
+			}
+			required = permission + ":" + action
+		}
+		if !model.UserHasAdminPermission(c.GetInt("id"), c.GetInt("role"), required) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，缺少权限 " + required,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 func WssAuth(c *gin.Context) {
 
 }
@@ -308,7 +432,14 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	} else {
 		c.Set("token_model_limit_enabled", false)
 	}
+	c.Set(string(constant.ContextKeyTokenMcpLimitEnabled), token.McpLimitsEnabled)
+	if token.McpLimitsEnabled {
+		c.Set(string(constant.ContextKeyTokenMcpLimit), token.GetMcpLimits())
+	}
 	c.Set("token_group", token.Group)
+	c.Set(string(constant.ContextKeyTokenOrganizationId), token.OrganizationId)
+	c.Set(string(constant.ContextKeyTokenConversationLog), token.ConversationLoggingEnabled)
+	c.Set(string(constant.ContextKeyTokenFundingWaterfall), token.FundingWaterfall)
 	// Billing feature context hydration
 	c.Set(string(constant.ContextKeyBillingFeatureEnabled), common.BillingFeatureEnabled)
 	c.Set(string(constant.ContextKeyBillingMode), token.GetBillingMode())
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupManagementKeyTest(t *testing.T) *model.User {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.ManagementKey{}, &model.AdminRole{}, &model.UserAdminRole{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB, oldRedis := model.DB, common.RedisEnabled
+	model.DB = db
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		model.DB = oldDB
+		common.RedisEnabled = oldRedis
+	})
+	user := &model.User{Username: "automation", Role: common.RoleAdminUser, Status: common.UserStatusEnabled, AffCode: t.Name()}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func insertManagementKey(t *testing.T, key *model.ManagementKey, scopes ...string) string {
+	t.Helper()
+	plain, err := model.GenerateManagementKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.SetScopes(scopes)
+	if key.ExpiredTime == 0 {
+		key.ExpiredTime = -1
+	}
+	if err := key.Insert(plain); err != nil {
+		t.Fatalf("insert key: %v", err)
+	}
+	return plain
+}
+
+func newManagementKeyRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(sessions.Sessions("session", cookie.NewStore([]byte(common.SessionSecret))))
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true, "id": c.GetInt("id")})
+	}
+	tokenRoute := router.Group("/api/token")
+	tokenRoute.Use(ManagementKeyScope("tokens"), UserAuth())
+	tokenRoute.GET("/", ok)
+	tokenRoute.POST("/", ok)
+	router.GET("/api/user/self", UserAuth(), ok)
+	router.GET("/api/log/", PermissionAuth("log"), ok)
+	router.GET("/api/option/", PermissionAuth("setting"), ok)
+	// like the channel routes: GET endpoints that call upstreams require write
+	channelRoute := router.Group("/api/channel")
+	channelRoute.Use(PermissionAuth("channel"))
+	channelRoute.GET("/", ok)
+	channelRoute.GET("/test/:id", PermissionAuth("channel:write"), ok)
+	return router
+}
+
+// callWithKey returns the status and whether the route handler ran.
+func callWithKey(t *testing.T, router *gin.Engine, method string, path string, key string, apiUser string) (int, bool) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = "192.0.2.10:40000"
+	req.Header.Set("Authorization", "Bearer "+key)
+	if apiUser != "" {
+		req.Header.Set("New-Api-User", apiUser)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	var body struct {
+		Success bool `json:"success"`
+	}
+	if err := common.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %s: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, body.Success
+}
+
+func TestManagementKeyScopes(t *testing.T) {
+	user := setupManagementKeyTest(t)
+	router := newManagementKeyRouter()
+	readOnly := insertManagementKey(t, &model.ManagementKey{UserId: user.Id, Name: "read"}, "tokens:read", "logs:read", "channels:read")
+	full := insertManagementKey(t, &model.ManagementKey{UserId: user.Id, Name: "full"}, "tokens:*")
+	channels := insertManagementKey(t, &model.ManagementKey{UserId: user.Id, Name: "channels"}, "channels:*")
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		key     string
+		apiUser string
+		status  int
+		allowed bool
+	}{
+		{"read scope reads", http.MethodGet, "/api/token/", readOnly, "", http.StatusOK, true},
+		{"read scope cannot write", http.MethodPost, "/api/token/", readOnly, "", http.StatusForbidden, false},
+		{"wildcard scope writes", http.MethodPost, "/api/token/", full, "", http.StatusOK, true},
+		{"admin route with scope", http.MethodGet, "/api/log/", readOnly, "", http.StatusOK, true},
+		{"admin route without scope", http.MethodGet, "/api/log/", full, "", http.StatusForbidden, false},
+		{"unscoped route", http.MethodGet, "/api/user/self", full, "", http.StatusForbidden, false},
+		{"admin resource without a key scope", http.MethodGet, "/api/option/", full, "", http.StatusForbidden, false},
+		{"read scope lists channels", http.MethodGet, "/api/channel/", readOnly, "", http.StatusOK, true},
+		{"read scope on an explicit write GET", http.MethodGet, "/api/channel/test/1", readOnly, "", http.StatusForbidden, false},
+		{"write scope on an explicit write GET", http.MethodGet, "/api/channel/test/1", channels, "", http.StatusOK, true},
+		{"matching New-Api-User", http.MethodGet, "/api/token/", readOnly, fmt.Sprint(user.Id), http.StatusOK, true},
+		{"mismatched New-Api-User", http.MethodGet, "/api/token/", readOnly, fmt.Sprint(user.Id + 1), http.StatusUnauthorized, false},
+		{"unknown key", http.MethodGet, "/api/token/", model.ManagementKeyPrefix + "unknown", "", http.StatusUnauthorized, false},
+	}
+	for _, tc := range cases {
+		status, allowed := callWithKey(t, router, tc.method, tc.path, tc.key, tc.apiUser)
+		if status != tc.status || allowed != tc.allowed {
+			t.Errorf("%s: got %d/%v, want %d/%v", tc.name, status, allowed, tc.status, tc.allowed)
+		}
+	}
+}
+
+func TestManagementKeyExpiryAndAllowlist(t *testing.T) {
+	user := setupManagementKeyTest(t)
+	router := newManagementKeyRouter()
+	expired := insertManagementKey(t, &model.ManagementKey{UserId: user.Id, Name: "expired", ExpiredTime: common.GetTimestamp() - 60}, "tokens:read")
+	inRange := insertManagementKey(t, &model.ManagementKey{UserId: user.Id, Name: "office", AllowIps: "10.0.0.1\n192.0.2.0/24"}, "tokens:read")
+	outOfRange := insertManagementKey(t, &model.ManagementKey{UserId: user.Id, Name: "vpn", AllowIps: "10.0.0.0/8"}, "tokens:read")
+
+	if status, allowed := callWithKey(t, router, http.MethodGet, "/api/token/", expired, ""); status != http.StatusUnauthorized || allowed {
+		t.Errorf("expired key: got %d/%v", status, allowed)
+	}
+	if status, allowed := callWithKey(t, router, http.MethodGet, "/api/token/", inRange, ""); status != http.StatusOK || !allowed {
+		t.Errorf("allowed network: got %d/%v", status, allowed)
+	}
+	if status, allowed := callWithKey(t, router, http.MethodGet, "/api/token/", outOfRange, ""); status != http.StatusForbidden || allowed {
+		t.Errorf("other network: got %d/%v", status, allowed)
+	}
+
+	var used model.ManagementKey
+	if err := model.DB.First(&used, "name = ?", "office").Error; err != nil {
+		t.Fatal(err)
+	}
+	if used.LastUsedIp != "192.0.2.10" || used.LastUsedTime == 0 {
+		t.Errorf("usage not recorded: %+v", used)
+	}
+	var refused model.ManagementKey
+	if err := model.DB.First(&refused, "name = ?", "vpn").Error; err != nil {
+		t.Fatal(err)
+	}
+	if refused.LastUsedTime != 0 {
+		t.Errorf("refused request recorded usage: %+v", refused)
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationCaptureWriter tees the relay response into a bounded buffer so
+// the final answer can be reassembled after the handler finishes streaming.
+type conversationCaptureWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *conversationCaptureWriter) capture(data []byte) {
+	remaining := w.limit - w.body.Len()
+	if remaining <= 0 {
+		w.truncated = w.truncated || len(data) > 0
+		return
+	}
+	if len(data) > remaining {
+		data = data[:remaining]
+		w.truncated = true
+	}
+	w.body.Write(data)
+}
+
+func (w *conversationCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *conversationCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// ConversationCapture records relay conversations for tokens that have
+// conversation logging enabled. It must run after TokenAuth; sealing and
+// storage happen asynchronously in service.EnqueueConversationCapture.
+func ConversationCapture() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loggingConfig := config.GetConversationLoggingConfig()
+		if !loggingConfig.Enabled || c.Request.Method != http.MethodPost ||
+			!common.GetContextKeyBool(c, constant.ContextKeyTokenConversationLog) {
+			c.Next()
+			return
+		}
+		limit := loggingConfig.MaxCaptureBytes
+		if limit <= 0 {
+			limit = 1 << 20
+		}
+		writer := &conversationCaptureWriter{ResponseWriter: c.Writer, limit: limit}
+		c.Writer = writer
+		startTime := time.Now()
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.Status() != http.StatusOK || writer.body.Len() == 0 {
+			return
+		}
+		contentType := writer.Header().Get("Content-Type")
+		stream := strings.HasPrefix(contentType, "text/event-stream")
+		if !stream && !strings.HasPrefix(contentType, "application/json") {
+			return
+		}
+		requestBody, err := common.GetRequestBody(c)
+		if err != nil {
+			return
+		}
+		if len(requestBody) > limit {
+			requestBody = requestBody[:limit]
+			writer.truncated = true
+		}
+		modelName := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+		service.EnqueueConversationCapture(&service.ConversationCapture{
+			UserId:       common.GetContextKeyInt(c, constant.ContextKeyUserId),
+			TokenId:      common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+			Model:        modelName,
+			RequestId:    c.GetString(common.RequestIdKey),
+			RequestBody:  append([]byte(nil), requestBody...),
+			ResponseBody: writer.body.Bytes(),
+			Stream:       stream,
+			Truncated:    writer.truncated,
+			Timestamp:    startTime.Unix(),
+		})
+	}
+}
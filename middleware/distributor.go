@@ -15,6 +15,7 @@ import (
     relayconstant "github.com/QuantumNous/new-api/relay/constant"
     "github.com/QuantumNous/new-api/service"
     "github.com/QuantumNous/new-api/setting"
+    "github.com/QuantumNous/new-api/setting/config"
     "github.com/QuantumNous/new-api/setting/ratio_setting"
     "github.com/QuantumNous/new-api/types"
 
@@ -118,7 +119,7 @@ func Distribute() func(c *gin.Context) {
             }
         }
         common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
-        SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+        service.SetupContextForSelectedChannel(c, channel, modelRequest.Model)
         c.Next()
     }
 }
@@ -142,7 +143,7 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
             }
             midjourneyModel, mjErr, success := service.GetMjRequestModel(relayMode, &midjourneyRequest)
             if mjErr != nil {
-                return nil, false, fmt.Errorf(mjErr.Description)
+                return nil, false, errors.New(mjErr.Description)
             }
             if midjourneyModel == "" {
                 if !success {
@@ -235,6 +236,10 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
         if modelRequest.Model == "" {
             modelRequest.Model = "text-moderation-stable"
         }
+        // 本地审核模型由网关直接应答，不需要渠道
+        if config.GetModerationConfig().IsLocalModel(modelRequest.Model) {
+            shouldSelectChannel = false
+        }
     }
     if strings.HasSuffix(c.Request.URL.Path, "embeddings") {
         if modelRequest.Model == "" {
@@ -275,66 +280,6 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
     return &modelRequest, shouldSelectChannel, nil
 }
 
-func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) *types.NewAPIError {
-    c.Set("original_model", modelName) // for retry
-    common.SetContextKey(c, constant.ContextKeyRequestedModel, modelName)
-    if channel == nil {
-        return types.NewError(errors.New("channel is nil"), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
-    }
-    common.SetContextKey(c, constant.ContextKeyChannelId, channel.Id)
-    common.SetContextKey(c, constant.ContextKeyChannelName, channel.Name)
-    common.SetContextKey(c, constant.ContextKeyChannelType, channel.Type)
-    common.SetContextKey(c, constant.ContextKeyChannelCreateTime, channel.CreatedTime)
-    common.SetContextKey(c, constant.ContextKeyChannelSetting, channel.GetSetting())
-    common.SetContextKey(c, constant.ContextKeyChannelOtherSetting, channel.GetOtherSettings())
-    common.SetContextKey(c, constant.ContextKeyChannelParamOverride, channel.GetParamOverride())
-    common.SetContextKey(c, constant.ContextKeyChannelHeaderOverride, channel.GetHeaderOverride())
-    if nil != channel.OpenAIOrganization && *channel.OpenAIOrganization != "" {
-        common.SetContextKey(c, constant.ContextKeyChannelOrganization, *channel.OpenAIOrganization)
-    }
-    common.SetContextKey(c, constant.ContextKeyChannelAutoBan, channel.GetAutoBan())
-    common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
-    common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
-
-    key, index, newAPIError := channel.GetNextEnabledKey()
-    if newAPIError != nil {
-        return newAPIError
-    }
-    if channel.ChannelInfo.IsMultiKey {
-        common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, true)
-        common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, index)
-    } else {
-        // 必须设置为 false，否则在重试到单个 key 的时候会导致日志显示错误
-        common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, false)
-    }
-    // c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
-    common.SetContextKey(c, constant.ContextKeyChannelKey, key)
-    common.SetContextKey(c, constant.ContextKeyChannelBaseUrl, channel.GetBaseURL())
-
-    common.SetContextKey(c, constant.ContextKeySystemPromptOverride, false)
-
-    // TODO: api_version统一
-    switch channel.Type {
-    case constant.ChannelTypeAzure:
-        c.Set("api_version", channel.Other)
-    case constant.ChannelTypeVertexAi:
-        c.Set("region", channel.Other)
-    case constant.ChannelTypeXunfei:
-        c.Set("api_version", channel.Other)
-    case constant.ChannelTypeGemini:
-        c.Set("api_version", channel.Other)
-    case constant.ChannelTypeAli:
-        c.Set("plugin", channel.Other)
-    case constant.ChannelCloudflare:
-        c.Set("api_version", channel.Other)
-    case constant.ChannelTypeMokaAI:
-        c.Set("api_version", channel.Other)
-    case constant.ChannelTypeCoze:
-        c.Set("bot_id", channel.Other)
-    }
-    return nil
-}
-
 // extractModelNameFromGeminiPath 从 Gemini API URL 路径中提取模型名
 // 输入格式: /v1beta/models/gemini-2.0-flash:generateContent
 // 输出: gemini-2.0-flash
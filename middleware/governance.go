@@ -1,4 +1,3 @@
-//go:build governance
 package middleware
 
 import (
@@ -19,54 +18,11 @@ import (
 )
 
 type governanceDecision struct {
-    triggered  bool
     severity   string
     detectors  map[string]map[string]string
     reasons    []string
-    reasonSet  map[string]struct{}
     flagReason string
-}
-
-func newGovernanceDecision() *governanceDecision {
-    return &governanceDecision{
-        detectors: make(map[string]map[string]string),
-        reasonSet: make(map[string]struct{}),
-    }
-}
-
-func (d *governanceDecision) add(detector string, res governanceSvc.DetectorResult) {
-    if !res.Triggered {
-        return
-    }
-    d.triggered = true
-    if res.Metadata != nil {
-        // Copy metadata to avoid later mutation side-effects
-        copied := make(map[string]string, len(res.Metadata))
-        for k, v := range res.Metadata {
-            copied[k] = v
-        }
-        d.detectors[detector] = copied
-    } else {
-        d.detectors[detector] = map[string]string{}
-    }
-    if rank(res.Severity) > rank(d.severity) {
-        d.severity = res.Severity
-    } else if d.severity == "" {
-        d.severity = res.Severity
-    }
-    for _, reason := range res.Reasons {
-        if _, exists := d.reasonSet[reason]; !exists {
-            d.reasonSet[reason] = struct{}{}
-            d.reasons = append(d.reasons, reason)
-        }
-    }
-    if d.flagReason == "" {
-        if len(res.Reasons) > 0 {
-            d.flagReason = res.Reasons[0]
-        } else {
-            d.flagReason = res.Severity
-        }
-    }
+    policies   *governanceSvc.PolicyDecision
 }
 
 func rank(severity string) int {
@@ -80,7 +36,10 @@ func rank(severity string) int {
     }
 }
 
-// Governance injects governance detection into the relay pipeline.
+// Governance injects governance detection into the relay pipeline. Detector
+// outputs and request facts are fed to the configured policies; the matched
+// policies decide whether the request is flagged, rerouted, blocked,
+// throttled or has to present a 2FA code.
 func Governance() gin.HandlerFunc {
     return func(c *gin.Context) {
         if alreadyChecked := c.GetBool("governance_checked"); alreadyChecked {
@@ -106,23 +65,69 @@ func Governance() gin.HandlerFunc {
             subjectKey = fmt.Sprintf("%s:%d", subjectType, subjectID)
         }
 
+        requestedModel := common.GetContextKeyString(c, constant.ContextKeyRequestedModel)
+        if requestedModel == "" {
+            requestedModel = common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+        }
+
         prompt := extractPromptText(c)
-        decision := evaluateGovernance(subjectKey, prompt)
-        if decision == nil || !decision.triggered {
+        now := time.Now()
+        facts := &governanceSvc.PolicyFacts{
+            SubjectType:  subjectType,
+            SubjectId:    subjectID,
+            SubjectKey:   subjectKey,
+            UserId:       common.GetContextKeyInt(c, constant.ContextKeyUserId),
+            TokenId:      common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+            Model:        requestedModel,
+            Group:        common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+            Path:         c.Request.URL.Path,
+            Prompt:       prompt,
+            PromptTokens: common.GetContextKeyInt(c, constant.ContextKeyPromptTokens),
+            Detectors:    governanceSvc.RunDetectors(subjectKey, prompt, now),
+        }
+        decision := evaluateGovernance(facts, now)
+        if decision == nil {
             c.Next()
             return
         }
 
-        requestedModel := common.GetContextKeyString(c, constant.ContextKeyRequestedModel)
-        if requestedModel == "" {
-            requestedModel = common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+        meta := buildGovernanceMetadata(c, decision, subjectType, subjectID, subjectKey, requestedModel)
+        enforced := decision.policies.Enforced()
+        if len(enforced) == 0 {
+            logger.LogInfo(c, fmt.Sprintf("governance dry-run policies=%v severity=%s", meta["policies"], decision.severity))
+            c.Next()
+            return
         }
 
-        meta := buildGovernanceMetadata(c, decision, subjectType, subjectID, subjectKey, requestedModel)
-        alias, applied := applyGovernanceFallback(c, config, requestedModel, decision, meta)
         common.SetContextKey(c, constant.ContextKeyGovernanceMetadata, meta)
         common.SetContextKey(c, constant.ContextKeySkipLeaderboard, true)
 
+        alias := ""
+        for _, match := range enforced {
+            switch match.Action {
+            case cfg.GovernanceActionBlock:
+                meta["action"] = match.Action
+                persistRequestFlag(c, config, subjectType, subjectID, "", decision)
+                abortWithOpenAiMessage(c, http.StatusForbidden, governanceMessage(match, "request blocked by governance policy"), "governance_blocked")
+                return
+            case cfg.GovernanceActionThrottle:
+                meta["action"] = match.Action
+                persistRequestFlag(c, config, subjectType, subjectID, "", decision)
+                abortWithOpenAiMessage(c, http.StatusTooManyRequests, governanceMessage(match, "request throttled by governance policy"), "governance_throttled")
+                return
+            case cfg.GovernanceActionRequire2FA:
+                if ok, message := verifyGovernance2FA(c, facts.UserId); !ok {
+                    meta["action"] = match.Action
+                    abortWithOpenAiMessage(c, http.StatusForbidden, governanceMessage(match, message), "governance_2fa_required")
+                    return
+                }
+            case cfg.GovernanceActionReroute:
+                if alias == "" {
+                    alias, _ = applyGovernanceFallback(c, config, requestedModel, match, meta)
+                }
+            }
+        }
+
         persistRequestFlag(c, config, subjectType, subjectID, alias, decision)
 
         logger.LogWarn(c, fmt.Sprintf("governance flag triggered severity=%s reasons=%v", decision.severity, decision.reasons))
@@ -131,31 +136,77 @@ func Governance() gin.HandlerFunc {
     }
 }
 
-func evaluateGovernance(subjectKey, prompt string) *governanceDecision {
-    decision := newGovernanceDecision()
-    now := time.Now()
-
-    decision.add("high_rpm", governanceSvc.DetectHighRPM(subjectKey, now))
-
-    trimmed := strings.TrimSpace(prompt)
-    if trimmed != "" {
-        decision.add("prompt_sanity", governanceSvc.DetectPromptSanity(trimmed))
-        decision.add("keyword_policy", governanceSvc.DetectKeywordPolicy(trimmed))
-    }
-
-    if !decision.triggered {
+// evaluateGovernance runs the active policies and folds their matches into a
+// decision. It returns nil when no policy matched, enforced or dry-run.
+func evaluateGovernance(facts *governanceSvc.PolicyFacts, now time.Time) *governanceDecision {
+    policies := governanceSvc.EvaluatePolicies(facts, now)
+    if len(policies.Matches) == 0 {
         return nil
     }
-    if decision.flagReason == "" {
-        if decision.severity == governanceSvc.SeverityMalicious {
-            decision.flagReason = common.FlagReasonAbuse
-        } else {
-            decision.flagReason = common.FlagReasonViolation
+    decision := &governanceDecision{
+        detectors: make(map[string]map[string]string),
+        policies:  policies,
+    }
+    for name, res := range facts.Detectors {
+        if !res.Triggered {
+            continue
         }
+        copied := make(map[string]string, len(res.Metadata))
+        for k, v := range res.Metadata {
+            copied[k] = v
+        }
+        decision.detectors[name] = copied
+    }
+    reasonSet := make(map[string]struct{})
+    for _, match := range policies.Matches {
+        if match.DryRun {
+            continue
+        }
+        if rank(match.Severity) > rank(decision.severity) {
+            decision.severity = match.Severity
+        }
+        if _, exists := reasonSet[match.Reason]; !exists {
+            reasonSet[match.Reason] = struct{}{}
+            decision.reasons = append(decision.reasons, match.Reason)
+        }
+    }
+    if decision.severity == governanceSvc.SeverityMalicious {
+        decision.flagReason = common.FlagReasonAbuse
+    } else {
+        decision.flagReason = common.FlagReasonViolation
     }
     return decision
 }
 
+func governanceMessage(match governanceSvc.PolicyMatch, fallback string) string {
+    if match.Message != "" {
+        return match.Message
+    }
+    return fallback
+}
+
+// verifyGovernance2FA checks the TOTP or backup code sent in X-2FA-Code.
+func verifyGovernance2FA(c *gin.Context, userID int) (bool, string) {
+    if userID == 0 || !model.IsTwoFAEnabled(userID) {
+        return false, "two-factor authentication must be enabled for this request"
+    }
+    code := strings.TrimSpace(c.GetHeader("X-2FA-Code"))
+    if code == "" {
+        return false, "two-factor code required in X-2FA-Code header"
+    }
+    twoFA, err := model.GetTwoFAByUserId(userID)
+    if err != nil || twoFA == nil {
+        return false, "two-factor authentication must be enabled for this request"
+    }
+    if ok, err := twoFA.ValidateTOTPAndUpdateUsage(code); err == nil && ok {
+        return true, ""
+    }
+    if ok, err := twoFA.ValidateBackupCodeAndUpdateUsage(code); err == nil && ok {
+        return true, ""
+    }
+    return false, "invalid two-factor code"
+}
+
 func deriveSubject(c *gin.Context) (string, int) {
     userID := common.GetContextKeyInt(c, constant.ContextKeyUserId)
     if userID > 0 {
@@ -210,10 +261,6 @@ func collectPromptText(node any, builder *strings.Builder, depth int) {
         for _, item := range val {
             collectPromptText(item, builder, depth+1)
         }
-    case []interface{}:
-        for _, item := range val {
-            collectPromptText(item, builder, depth+1)
-        }
     case map[string]any:
         collectPromptText(val["prompt"], builder, depth+1)
         collectPromptText(val["input"], builder, depth+1)
@@ -224,16 +271,6 @@ func collectPromptText(node any, builder *strings.Builder, depth int) {
         for _, v := range val {
             collectPromptText(v, builder, depth+1)
         }
-    case map[string]interface{}:
-        collectPromptText(val["prompt"], builder, depth+1)
-        collectPromptText(val["input"], builder, depth+1)
-        collectPromptText(val["inputs"], builder, depth+1)
-        collectPromptText(val["messages"], builder, depth+1)
-        collectPromptText(val["content"], builder, depth+1)
-        collectPromptText(val["data"], builder, depth+1)
-        for _, v := range val {
-            collectPromptText(v, builder, depth+1)
-        }
     }
 }
 
@@ -251,6 +288,19 @@ func buildGovernanceMetadata(c *gin.Context, decision *governanceDecision, subje
         detectors[name] = copied
     }
     metadata["detectors"] = detectors
+    policies := make([]string, 0, len(decision.policies.Matches))
+    dryRun := make([]string, 0)
+    for _, match := range decision.policies.Matches {
+        if match.DryRun {
+            dryRun = append(dryRun, match.Policy)
+        } else {
+            policies = append(policies, match.Policy)
+        }
+    }
+    metadata["policies"] = policies
+    if len(dryRun) > 0 {
+        metadata["dry_run_policies"] = dryRun
+    }
     metadata["subject_type"] = subjectType
     metadata["subject_id"] = subjectID
     metadata["subject_key"] = subjectKey
@@ -261,8 +311,11 @@ func buildGovernanceMetadata(c *gin.Context, decision *governanceDecision, subje
     return metadata
 }
 
-func applyGovernanceFallback(c *gin.Context, config *cfg.GovernanceConfig, requestedModel string, decision *governanceDecision, metadata map[string]interface{}) (string, bool) {
-    alias := selectFallbackAlias(config, decision)
+func applyGovernanceFallback(c *gin.Context, config *cfg.GovernanceConfig, requestedModel string, match governanceSvc.PolicyMatch, metadata map[string]interface{}) (string, bool) {
+    alias := match.RerouteAlias
+    if alias == "" {
+        alias = selectFallbackAlias(config, match.Severity)
+    }
     if alias == "" {
         metadata["fallback_applied"] = false
         return "", false
@@ -299,24 +352,16 @@ func applyGovernanceFallback(c *gin.Context, config *cfg.GovernanceConfig, reque
     return alias, true
 }
 
-func selectFallbackAlias(config *cfg.GovernanceConfig, decision *governanceDecision) string {
-    if decision.severity == governanceSvc.SeverityMalicious {
-        if config.MaliciousFallbackAlias != "" {
-            return config.MaliciousFallbackAlias
-        }
-        if common.GovernanceMaliciousFallbackAlias != "" {
-            return common.GovernanceMaliciousFallbackAlias
-        }
+func selectFallbackAlias(config *cfg.GovernanceConfig, severity string) string {
+    if severity == governanceSvc.SeverityMalicious && config.MaliciousFallbackAlias != "" {
+        return config.MaliciousFallbackAlias
     }
-    if config.ViolationFallbackAlias != "" {
+    if severity != governanceSvc.SeverityMalicious && config.ViolationFallbackAlias != "" {
         return config.ViolationFallbackAlias
     }
     if config.RerouteModelAlias != "" {
         return config.RerouteModelAlias
     }
-    if common.GovernanceViolationFallbackAlias != "" {
-        return common.GovernanceViolationFallbackAlias
-    }
     return common.GovernanceRerouteModelAlias
 }
 
@@ -326,12 +371,11 @@ func persistRequestFlag(c *gin.Context, config *cfg.GovernanceConfig, subjectTyp
     tokenID := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
 
     flag := &model.RequestFlag{
-        RequestId:              requestID,
-        SubjectType:            subjectType,
-        SubjectId:              subjectID,
-        ReroutedModelAlias:     alias,
-        Reason:                 decision.flagReason,
-        ExcludedFromLeaderboard: true,
+        RequestId:          requestID,
+        SubjectType:        subjectType,
+        SubjectId:          subjectID,
+        ReroutedModelAlias: alias,
+        Reason:             decision.flagReason,
     }
     if userID > 0 {
         flag.UserId = &userID
@@ -381,7 +425,7 @@ func recordSecurityViolation(c *gin.Context, userID, tokenID int, decision *gove
     }
 
     // Get IP address
-    ipAddress := common.GetClientIP(c)
+    ipAddress := c.ClientIP()
 
     // Determine action taken
     action := "log"
@@ -407,7 +451,7 @@ func recordSecurityViolation(c *gin.Context, userID, tokenID int, decision *gove
     }()
 }
 
-func recordViolationAsync(userID int, tokenID *int, content string, keywords []string, model, ipAddress, requestID, severity, action string) error {
+func recordViolationAsync(userID int, tokenID *int, content string, keywords []string, modelName, ipAddress, requestID, severity, action string) error {
     // Import the service package at function level to avoid init-time circular dependency
     // We'll call the model layer directly here
     snippet := content
@@ -421,7 +465,7 @@ func recordViolationAsync(userID int, tokenID *int, content string, keywords []s
         ViolatedAt:      time.Now(),
         ContentSnippet:  snippet,
         MatchedKeywords: joinStrings(keywords, ", "),
-        Model:           model,
+        Model:           modelName,
         IpAddress:       ipAddress,
         RequestId:       requestID,
         Severity:        severity,
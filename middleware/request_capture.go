@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestCapture records sampled relay requests into heimdall_logs, giving the
+// anomaly detector the same data the Heimdall sidecar would provide.
+// It must be installed before TokenAuth so latency covers the whole request;
+// user and token identity are read back from the context after c.Next().
+func RequestCapture() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		captureConfig := config.GetRequestCaptureConfig()
+		if !captureConfig.Enabled || !shouldSampleRequest(captureConfig.SampleRate) {
+			c.Next()
+			return
+		}
+
+		startTime := time.Now()
+		c.Next()
+
+		entry := &model.HeimdallLog{
+			UserId:            c.GetInt("id"),
+			TokenKey:          c.GetString("token_key"),
+			RequestPath:       c.Request.URL.Path,
+			RequestMethod:     c.Request.Method,
+			RealIP:            c.ClientIP(),
+			ForwardedFor:      c.Request.Header.Get("X-Forwarded-For"),
+			UserAgent:         c.Request.UserAgent(),
+			DeviceFingerprint: service.CaptureDeviceFingerprint(c.Request.Header),
+			Cookies:           service.RedactCaptureCookies(c.Request.Cookies()),
+			ResponseStatus:    c.Writer.Status(),
+			ResponseTime:      int(time.Since(startTime).Milliseconds()),
+			Timestamp:         startTime.Unix(),
+		}
+		if captureConfig.CaptureHeaders {
+			entry.RequestHeaders = service.RedactCaptureHeaders(c.Request.Header, captureConfig.RedactHeaders)
+		}
+		// Only reuse a body that the relay already buffered; never read it here.
+		if cached, ok := c.Get(common.KeyRequestBody); ok {
+			if body, ok := cached.([]byte); ok {
+				entry.ContentFingerprint = service.CaptureContentFingerprint(body)
+				if captureConfig.CaptureBody {
+					entry.RequestBody = service.RedactCaptureBody(body, captureConfig.MaxBodyBytes)
+				}
+			}
+		}
+		service.EnqueueRequestCapture(entry)
+	}
+}
+
+func shouldSampleRequest(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return rand.Float64() < rate
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service/scim"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScimAuth accepts requests bearing the SCIM provisioning token.
+func ScimAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.GetScimConfig()
+		if !cfg.Enabled {
+			abortScim(c, scim.NewError(http.StatusNotFound, "", "SCIM provisioning is disabled"))
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		hash := hex.EncodeToString(common.Sha256Raw([]byte(strings.TrimSpace(token))))
+		if !ok || cfg.TokenHash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(cfg.TokenHash)) != 1 {
+			abortScim(c, scim.NewError(http.StatusUnauthorized, "", "invalid provisioning token"))
+			return
+		}
+		c.Set("username", "scim")
+		c.Next()
+	}
+}
+
+func abortScim(c *gin.Context, err *scim.Error) {
+	c.Header("Content-Type", scim.ContentType)
+	c.AbortWithStatusJSON(err.StatusCode(), err)
+}
@@ -0,0 +1,306 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"gorm.io/gorm"
+)
+
+// Admin permissions are "resource:action" pairs. A role may grant "*" for
+// everything, or use "*" as the resource or the action.
+const (
+	AdminActionRead  = "read"
+	AdminActionWrite = "write"
+)
+
+// AdminResources lists the resources guarded by admin permissions.
+var AdminResources = []string{
+	"system",       // status checks
+	"user",         // user management
+	"channel",      // channels, groups and prefill groups
+	"model",        // model and vendor metadata
+	"log",          // logs, usage data, leaderboards and tasks
+	"redemption",   // redemption codes and lotteries
+	"plan",         // plans, vouchers and packages
+	"organization", // organization pools
+	"ticket",       // support tickets
+	"security",     // violations, bans and anomalies
+	"setting",      // options, tokenizers and ratio sync
+	"governance",   // governance policies
+	"mcp",          // MCP servers and server tools
+	"rbac",         // admin roles and their assignment
+	"audit",        // audit trail of administrative changes
+}
+
+// Built-in admin roles. The integer user roles map to root and admin.
+const (
+	AdminRoleRoot    = "root"
+	AdminRoleAdmin   = "admin"
+	AdminRoleSupport = "support"
+	AdminRoleAuditor = "auditor"
+)
+
+var builtinAdminRoles = []AdminRole{
+	{Name: AdminRoleRoot, Description: "所有权限", Permissions: `["*"]`},
+	{Name: AdminRoleAdmin, Description: "除系统设置、治理、MCP 与角色管理外的所有权限", Permissions: common.GetJsonString([]string{
+		"system:*", "user:*", "channel:*", "model:*", "log:*", "redemption:*", "plan:*", "organization:*", "ticket:*", "security:*", "audit:read",
+	})},
+	{Name: AdminRoleSupport, Description: "处理工单，查看用户与日志", Permissions: `["ticket:*","user:read","log:read"]`},
+	{Name: AdminRoleAuditor, Description: "只读查看用户、渠道、日志与账务", Permissions: common.GetJsonString([]string{
+		"system:read", "user:read", "channel:read", "model:read", "log:read", "redemption:read", "plan:read", "organization:read", "ticket:read", "security:read", "audit:read",
+	})},
+}
+
+// AdminRole is a named set of admin permissions. Built-in roles are
+// recreated on startup and cannot be changed.
+type AdminRole struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Permissions string `json:"permissions" gorm:"type:text"`
+	BuiltIn     bool   `json:"built_in" gorm:"default:false"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// UserAdminRole assigns an admin role to a user in addition to the
+// built-in role of their integer role.
+type UserAdminRole struct {
+	Id          int   `json:"id"`
+	UserId      int   `json:"user_id" gorm:"uniqueIndex:idx_user_admin_role,priority:1"`
+	RoleId      int   `json:"role_id" gorm:"uniqueIndex:idx_user_admin_role,priority:2;index"`
+	CreatedTime int64 `json:"created_time" gorm:"bigint"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.AdminRBACVersion, func() []interface{} {
+		return []interface{}{
+			&AdminRole{},
+			&UserAdminRole{},
+		}
+	})
+}
+
+func (role *AdminRole) GetPermissions() []string {
+	var permissions []string
+	if role.Permissions != "" {
+		_ = common.Unmarshal([]byte(role.Permissions), &permissions)
+	}
+	return permissions
+}
+
+func (role *AdminRole) SetPermissions(permissions []string) {
+	role.Permissions = common.GetJsonString(permissions)
+}
+
+// IsValidAdminPermission reports whether permission names a known resource
+// and action, allowing wildcards.
+func IsValidAdminPermission(permission string) bool {
+	if permission == "*" {
+		return true
+	}
+	resource, action, ok := strings.Cut(permission, ":")
+	if !ok {
+		return false
+	}
+	if action != "*" && action != AdminActionRead && action != AdminActionWrite {
+		return false
+	}
+	if resource == "*" {
+		return true
+	}
+	for _, r := range AdminResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminPermissionGranted reports whether any of granted covers permission.
+func AdminPermissionGranted(granted []string, permission string) bool {
+	resource, action, _ := strings.Cut(permission, ":")
+	for _, g := range granted {
+		if g == "*" {
+			return true
+		}
+		gResource, gAction, ok := strings.Cut(g, ":")
+		if !ok {
+			continue
+		}
+		if (gResource == "*" || gResource == resource) && (gAction == "*" || gAction == action) {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinPermissionsForRole returns the permissions implied by an integer
+// user role.
+func builtinPermissionsForRole(userRole int) []string {
+	name := ""
+	switch {
+	case userRole >= common.RoleRootUser:
+		name = AdminRoleRoot
+	case userRole >= common.RoleAdminUser:
+		name = AdminRoleAdmin
+	default:
+		return nil
+	}
+	for i := range builtinAdminRoles {
+		if builtinAdminRoles[i].Name == name {
+			return builtinAdminRoles[i].GetPermissions()
+		}
+	}
+	return nil
+}
+
+// GetUserAdminPermissions returns the permissions of the user's integer role
+// together with those of their assigned admin roles.
+func GetUserAdminPermissions(userId int, userRole int) ([]string, error) {
+	permissions := builtinPermissionsForRole(userRole)
+	roles, err := GetUserAdminRoles(userId)
+	if err != nil {
+		return permissions, err
+	}
+	for _, role := range roles {
+		permissions = append(permissions, role.GetPermissions()...)
+	}
+	return permissions, nil
+}
+
+// UserHasAdminPermission reports whether the user holds permission, only
+// querying assigned roles when the integer role does not grant it.
+func UserHasAdminPermission(userId int, userRole int, permission string) bool {
+	if AdminPermissionGranted(builtinPermissionsForRole(userRole), permission) {
+		return true
+	}
+	permissions, err := GetUserAdminPermissions(userId, userRole)
+	if err != nil {
+		common.SysLog("failed to load admin permissions: " + err.Error())
+		return false
+	}
+	return AdminPermissionGranted(permissions, permission)
+}
+
+// SyncBuiltinAdminRoles creates or refreshes the built-in roles.
+func SyncBuiltinAdminRoles() error {
+	now := common.GetTimestamp()
+	for _, builtin := range builtinAdminRoles {
+		role := AdminRole{}
+		err := DB.Where("name = ?", builtin.Name).First(&role).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			role = builtin
+			role.BuiltIn = true
+			role.CreatedTime = now
+			role.UpdatedTime = now
+			if err := DB.Create(&role).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := DB.Model(&role).Updates(map[string]any{
+			"description":  builtin.Description,
+			"permissions":  builtin.Permissions,
+			"built_in":     true,
+			"updated_time": now,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetAllAdminRoles() ([]*AdminRole, error) {
+	var roles []*AdminRole
+	err := DB.Order("built_in desc, id asc").Find(&roles).Error
+	return roles, err
+}
+
+func GetAdminRoleById(id int) (*AdminRole, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	role := &AdminRole{}
+	err := DB.First(role, "id = ?", id).Error
+	return role, err
+}
+
+func (role *AdminRole) Validate() error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" || len([]rune(role.Name)) > 64 {
+		return errors.New("角色名称不能为空且不能超过 64 个字符")
+	}
+	permissions := role.GetPermissions()
+	if len(permissions) == 0 {
+		return errors.New("角色至少需要一个权限")
+	}
+	for _, permission := range permissions {
+		if !IsValidAdminPermission(permission) {
+			return errors.New("无效的权限：" + permission)
+		}
+	}
+	return nil
+}
+
+func (role *AdminRole) Insert() error {
+	role.BuiltIn = false
+	role.CreatedTime = common.GetTimestamp()
+	role.UpdatedTime = role.CreatedTime
+	return DB.Create(role).Error
+}
+
+func (role *AdminRole) Update() error {
+	if role.BuiltIn {
+		return errors.New("内置角色不可修改")
+	}
+	role.UpdatedTime = common.GetTimestamp()
+	return DB.Model(role).Select("name", "description", "permissions", "updated_time").Updates(role).Error
+}
+
+// Delete removes a custom role and unassigns it from every user.
+func (role *AdminRole) Delete() error {
+	if role.BuiltIn {
+		return errors.New("内置角色不可删除")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", role.Id).Delete(&UserAdminRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(role).Error
+	})
+}
+
+// GetUserAdminRoles returns the admin roles assigned to a user.
+func GetUserAdminRoles(userId int) ([]*AdminRole, error) {
+	var roles []*AdminRole
+	err := DB.Table("admin_roles").
+		Joins("JOIN user_admin_roles ON user_admin_roles.role_id = admin_roles.id").
+		Where("user_admin_roles.user_id = ?", userId).
+		Order("admin_roles.id asc").
+		Find(&roles).Error
+	return roles, err
+}
+
+// SetUserAdminRoles replaces the admin roles assigned to a user.
+func SetUserAdminRoles(userId int, roleIds []int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&UserAdminRole{}).Error; err != nil {
+			return err
+		}
+		now := common.GetTimestamp()
+		for _, roleId := range roleIds {
+			if err := tx.Create(&UserAdminRole{UserId: userId, RoleId: roleId, CreatedTime: now}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
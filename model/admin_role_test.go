@@ -0,0 +1,47 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestAdminPermissionGranted(t *testing.T) {
+	cases := []struct {
+		granted    []string
+		permission string
+		want       bool
+	}{
+		{[]string{"*"}, "setting:write", true},
+		{[]string{"ticket:*"}, "ticket:write", true},
+		{[]string{"*:read"}, "channel:read", true},
+		{[]string{"*:read"}, "channel:write", false},
+		{[]string{"log:read"}, "user:read", false},
+		{nil, "log:read", false},
+	}
+	for _, tc := range cases {
+		if got := AdminPermissionGranted(tc.granted, tc.permission); got != tc.want {
+			t.Errorf("AdminPermissionGranted(%v, %q) = %v, want %v", tc.granted, tc.permission, got, tc.want)
+		}
+	}
+}
+
+func TestBuiltinAdminPermissions(t *testing.T) {
+	if !AdminPermissionGranted(builtinPermissionsForRole(common.RoleRootUser), "rbac:write") {
+		t.Error("root must hold every permission")
+	}
+	admin := builtinPermissionsForRole(common.RoleAdminUser)
+	if !AdminPermissionGranted(admin, "channel:write") || AdminPermissionGranted(admin, "setting:read") {
+		t.Errorf("unexpected admin permissions: %v", admin)
+	}
+	if builtinPermissionsForRole(common.RoleCommonUser) != nil {
+		t.Error("common users have no built-in admin permissions")
+	}
+	for _, role := range builtinAdminRoles {
+		for _, permission := range role.GetPermissions() {
+			if !IsValidAdminPermission(permission) {
+				t.Errorf("built-in role %s has invalid permission %q", role.Name, permission)
+			}
+		}
+	}
+}
@@ -4,9 +4,31 @@ import (
 	"errors"
 	"time"
 
+	"github.com/QuantumNous/new-api/model/migrations"
+
 	"gorm.io/gorm"
 )
 
+const (
+	ConversationLogSourceClient = "client"
+	ConversationLogSourceRelay  = "relay"
+
+	// ConversationLogSchemeSealed marks logs whose data key is wrapped per
+	// user encryption key in conversation_log_keys. Legacy rows have an empty
+	// scheme and are encrypted directly with the user's symmetric key.
+	ConversationLogSchemeSealed = "x25519-aes256gcm"
+)
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.ConversationLogSealingVersion, func() []interface{} {
+		return []interface{}{
+			&ConversationLog{},
+			&UserEncryptionKey{},
+			&ConversationLogKey{},
+		}
+	})
+}
+
 // ConversationLog stores encrypted conversation history for users who enable end-to-end encryption
 type ConversationLog struct {
 	Id              int            `json:"id" gorm:"primaryKey"`
@@ -20,6 +42,8 @@ type ConversationLog struct {
 	MessageCount    int            `json:"message_count" gorm:"default:0"`
 	PromptTokens    int            `json:"prompt_tokens" gorm:"default:0"`
 	CompletionTokens int           `json:"completion_tokens" gorm:"default:0"`
+	KeyScheme       string         `json:"key_scheme" gorm:"type:varchar(32);default:''"`
+	Source          string         `json:"source" gorm:"type:varchar(16);default:'client';index"`
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `json:"deleted_at" gorm:"index"`
@@ -31,6 +55,10 @@ func (ConversationLog) TableName() string {
 
 // CreateConversationLog creates a new encrypted conversation log entry
 func CreateConversationLog(log *ConversationLog) error {
+	return createConversationLog(DB, log)
+}
+
+func createConversationLog(tx *gorm.DB, log *ConversationLog) error {
 	if log.UserId == 0 || log.TokenId == 0 {
 		return errors.New("user_id and token_id are required")
 	}
@@ -38,7 +66,7 @@ func CreateConversationLog(log *ConversationLog) error {
 		return errors.New("encrypted_data and nonce are required")
 	}
 	log.Timestamp = time.Now().Unix()
-	return DB.Create(log).Error
+	return tx.Create(log).Error
 }
 
 // GetConversationLogsByUserId retrieves conversation logs for a specific user with pagination
@@ -126,3 +154,39 @@ func SearchConversationLogs(userId int, keyword string, startIdx int, num int) (
 
 	return logs, total, err
 }
+
+// GetConversationLogsForExport returns a user's encrypted logs in ascending
+// order, starting after afterId, optionally filtered by token and time range.
+func GetConversationLogsForExport(userId int, tokenId int, startTimestamp int64, endTimestamp int64, afterId int, limit int) ([]*ConversationLog, error) {
+	var logs []*ConversationLog
+	query := DB.Where("user_id = ? AND id > ?", userId, afterId)
+	if tokenId > 0 {
+		query = query.Where("token_id = ?", tokenId)
+	}
+	if startTimestamp > 0 {
+		query = query.Where("timestamp >= ?", startTimestamp)
+	}
+	if endTimestamp > 0 {
+		query = query.Where("timestamp <= ?", endTimestamp)
+	}
+	err := query.Order("id asc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
+// PurgeConversationLogsBefore permanently removes relay-captured logs older
+// than the cutoff, along with their wrapped keys. Retention is a privacy
+// guarantee, so rows are hard deleted. Logs uploaded by clients are kept.
+func PurgeConversationLogsBefore(cutoff int64) (int64, error) {
+	var count int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&ConversationLog{}).Unscoped().Select("id").
+			Where("source = ? AND timestamp < ?", ConversationLogSourceRelay, cutoff)
+		if err := tx.Where("log_id IN (?)", expired).Delete(&ConversationLogKey{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("source = ? AND timestamp < ?", ConversationLogSourceRelay, cutoff).Delete(&ConversationLog{})
+		count = result.RowsAffected
+		return result.Error
+	})
+	return count, err
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPurgeConversationLogsKeepsClientLogs(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:conversation_log_purge?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&ConversationLog{}, &ConversationLogKey{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = oldDB
+	})
+
+	logs := []*ConversationLog{
+		{UserId: 1, Source: ConversationLogSourceRelay, Timestamp: 100, EncryptedData: "old relay"},
+		{UserId: 1, Source: ConversationLogSourceClient, Timestamp: 100, EncryptedData: "old client"},
+		{UserId: 1, Source: ConversationLogSourceRelay, Timestamp: 300, EncryptedData: "new relay"},
+	}
+	for _, log := range logs {
+		if err := db.Create(log).Error; err != nil {
+			t.Fatalf("create log: %v", err)
+		}
+		if err := db.Create(&ConversationLogKey{LogId: log.Id, KeyId: 1, WrappedKey: "wrapped"}).Error; err != nil {
+			t.Fatalf("create log key: %v", err)
+		}
+	}
+
+	count, err := PurgeConversationLogsBefore(200)
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 purged log, got %d (err %v)", count, err)
+	}
+	var remaining []ConversationLog
+	db.Unscoped().Order("id").Find(&remaining)
+	if len(remaining) != 2 || remaining[0].Id != logs[1].Id || remaining[1].Id != logs[2].Id {
+		t.Fatalf("unexpected remaining logs %+v", remaining)
+	}
+	var keys int64
+	db.Model(&ConversationLogKey{}).Where("log_id = ?", logs[0].Id).Count(&keys)
+	if keys != 0 {
+		t.Fatalf("wrapped keys of the purged log were kept")
+	}
+	db.Model(&ConversationLogKey{}).Count(&keys)
+	if keys != 2 {
+		t.Fatalf("expected 2 wrapped keys left, got %d", keys)
+	}
+}
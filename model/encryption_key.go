@@ -0,0 +1,247 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	EncryptionKeyPurposeDevice   = "device"
+	EncryptionKeyPurposeRecovery = "recovery"
+
+	EncryptionKeyStatusActive  = "active"
+	EncryptionKeyStatusRevoked = "revoked"
+)
+
+var (
+	ErrLastEncryptionKey        = errors.New("cannot revoke the last active encryption key")
+	ErrEncryptionKeyOrphansLogs = errors.New("some conversation logs can only be opened with this key; re-wrap them for another key first")
+)
+
+// UserEncryptionKey is an X25519 public key registered by a user's device or
+// derived from a recovery code. Conversation logs are sealed to every active key.
+type UserEncryptionKey struct {
+	Id           int    `json:"id" gorm:"primaryKey"`
+	UserId       int    `json:"user_id" gorm:"index;not null"`
+	Name         string `json:"name" gorm:"type:varchar(64)"`
+	PublicKey    string `json:"public_key" gorm:"type:varchar(128);not null"`
+	Fingerprint  string `json:"fingerprint" gorm:"type:varchar(64);index"`
+	Purpose      string `json:"purpose" gorm:"type:varchar(16);default:'device'"`
+	RecoverySalt string `json:"recovery_salt,omitempty" gorm:"type:varchar(64)"`
+	Status       string `json:"status" gorm:"type:varchar(16);default:'active';index"`
+	CreatedAt    int64  `json:"created_at" gorm:"bigint"`
+	RevokedAt    int64  `json:"revoked_at" gorm:"bigint;default:0"`
+}
+
+func (UserEncryptionKey) TableName() string {
+	return "user_encryption_keys"
+}
+
+// ConversationLogKey holds a log's data key wrapped for one user encryption key.
+type ConversationLogKey struct {
+	Id         int    `json:"id" gorm:"primaryKey"`
+	LogId      int    `json:"log_id" gorm:"uniqueIndex:idx_conversation_log_key;not null"`
+	KeyId      int    `json:"key_id" gorm:"uniqueIndex:idx_conversation_log_key;index;not null"`
+	UserId     int    `json:"user_id" gorm:"index;not null"`
+	WrappedKey string `json:"wrapped_key" gorm:"type:text;not null"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint"`
+}
+
+func (ConversationLogKey) TableName() string {
+	return "conversation_log_keys"
+}
+
+// CreateUserEncryptionKey registers a key and turns on encryption for the user.
+func CreateUserEncryptionKey(key *UserEncryptionKey) error {
+	if key.UserId == 0 || key.PublicKey == "" {
+		return errors.New("user_id and public_key are required")
+	}
+	if key.Purpose == "" {
+		key.Purpose = EncryptionKeyPurposeDevice
+	}
+	key.Status = EncryptionKeyStatusActive
+	key.CreatedAt = time.Now().Unix()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&UserEncryptionKey{}).
+			Where("user_id = ? AND fingerprint = ? AND status = ?", key.UserId, key.Fingerprint, EncryptionKeyStatusActive).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("this public key is already registered")
+		}
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", key.UserId).Update("encryption_enabled", true).Error
+	})
+}
+
+func GetUserEncryptionKeys(userId int) ([]*UserEncryptionKey, error) {
+	var keys []*UserEncryptionKey
+	err := DB.Where("user_id = ?", userId).Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+func GetActiveUserEncryptionKeys(userId int) ([]*UserEncryptionKey, error) {
+	var keys []*UserEncryptionKey
+	err := DB.Where("user_id = ? AND status = ?", userId, EncryptionKeyStatusActive).Order("id asc").Find(&keys).Error
+	return keys, err
+}
+
+func GetUserEncryptionKey(id int, userId int) (*UserEncryptionKey, error) {
+	var key UserEncryptionKey
+	err := DB.Where("id = ? AND user_id = ?", id, userId).First(&key).Error
+	return &key, err
+}
+
+// RevokeUserEncryptionKey retires a key and deletes every data key wrapped for
+// it, so a lost device can no longer open any log. At least one active key
+// must remain. Logs that no other active key can open yet would become
+// unreadable; unless force is set the key is kept and ErrEncryptionKeyOrphansLogs
+// is returned. The number of such logs is returned either way.
+func RevokeUserEncryptionKey(id int, userId int, force bool) (int64, error) {
+	var orphaned int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var key UserEncryptionKey
+		if err := tx.Where("id = ? AND user_id = ? AND status = ?", id, userId, EncryptionKeyStatusActive).First(&key).Error; err != nil {
+			return err
+		}
+		var remaining int64
+		if err := tx.Model(&UserEncryptionKey{}).
+			Where("user_id = ? AND status = ? AND id <> ?", userId, EncryptionKeyStatusActive, id).
+			Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return ErrLastEncryptionKey
+		}
+		var err error
+		if orphaned, err = countConversationLogsOnlyOpenedBy(tx, userId, id); err != nil {
+			return err
+		}
+		if orphaned > 0 && !force {
+			return ErrEncryptionKeyOrphansLogs
+		}
+		if err := tx.Model(&key).Updates(map[string]interface{}{
+			"status":     EncryptionKeyStatusRevoked,
+			"revoked_at": time.Now().Unix(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("key_id = ?", id).Delete(&ConversationLogKey{}).Error
+	})
+	return orphaned, err
+}
+
+// countConversationLogsOnlyOpenedBy counts logs keyId can open and no other
+// active key of the user can.
+func countConversationLogsOnlyOpenedBy(tx *gorm.DB, userId int, keyId int) (int64, error) {
+	otherKeys := tx.Model(&UserEncryptionKey{}).Select("id").
+		Where("user_id = ? AND status = ? AND id <> ?", userId, EncryptionKeyStatusActive, keyId)
+	var count int64
+	err := tx.Model(&ConversationLogKey{}).
+		Where("user_id = ? AND key_id = ?", userId, keyId).
+		Where("log_id NOT IN (?)", tx.Model(&ConversationLogKey{}).Select("log_id").Where("key_id IN (?)", otherKeys)).
+		Count(&count).Error
+	return count, err
+}
+
+// CreateSealedConversationLog stores a log together with its wrapped data keys.
+func CreateSealedConversationLog(log *ConversationLog, keys []*ConversationLogKey) error {
+	if len(keys) == 0 {
+		return errors.New("at least one wrapped key is required")
+	}
+	log.KeyScheme = ConversationLogSchemeSealed
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := createConversationLog(tx, log); err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		for _, key := range keys {
+			key.LogId = log.Id
+			key.UserId = log.UserId
+			key.CreatedAt = now
+		}
+		return tx.Create(&keys).Error
+	})
+}
+
+// GetConversationLogKeysByLogIds loads the wrapped keys for a set of logs.
+func GetConversationLogKeysByLogIds(userId int, logIds []int) (map[int][]*ConversationLogKey, error) {
+	result := make(map[int][]*ConversationLogKey, len(logIds))
+	if len(logIds) == 0 {
+		return result, nil
+	}
+	var keys []*ConversationLogKey
+	if err := DB.Where("user_id = ? AND log_id IN ?", userId, logIds).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		result[key.LogId] = append(result[key.LogId], key)
+	}
+	return result, nil
+}
+
+// GetConversationLogKeysForRewrap pages through the data keys wrapped for
+// sourceKeyId on logs that have no wrap for targetKeyId yet.
+func GetConversationLogKeysForRewrap(userId int, sourceKeyId int, targetKeyId int, afterLogId int, limit int) ([]*ConversationLogKey, error) {
+	var keys []*ConversationLogKey
+	err := DB.Where("user_id = ? AND key_id = ? AND log_id > ?", userId, sourceKeyId, afterLogId).
+		Where("log_id NOT IN (?)", DB.Model(&ConversationLogKey{}).Select("log_id").Where("key_id = ?", targetKeyId)).
+		Order("log_id asc").
+		Limit(limit).
+		Find(&keys).Error
+	return keys, err
+}
+
+// CountConversationLogsMissingKey counts sealed logs keyId cannot open yet.
+func CountConversationLogsMissingKey(userId int, keyId int) (int64, error) {
+	var count int64
+	err := DB.Model(&ConversationLog{}).
+		Where("user_id = ? AND key_scheme = ?", userId, ConversationLogSchemeSealed).
+		Where("id NOT IN (?)", DB.Model(&ConversationLogKey{}).Select("log_id").Where("key_id = ?", keyId)).
+		Count(&count).Error
+	return count, err
+}
+
+// SaveRewrappedConversationLogKeys stores data keys a client re-wrapped for
+// keyId. Entries for logs the user does not own are ignored.
+func SaveRewrappedConversationLogKeys(userId int, keyId int, entries map[int]string) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	logIds := make([]int, 0, len(entries))
+	for logId := range entries {
+		logIds = append(logIds, logId)
+	}
+	var owned []int
+	if err := DB.Model(&ConversationLog{}).
+		Where("user_id = ? AND key_scheme = ? AND id IN ?", userId, ConversationLogSchemeSealed, logIds).
+		Pluck("id", &owned).Error; err != nil {
+		return 0, err
+	}
+	if len(owned) == 0 {
+		return 0, nil
+	}
+	now := time.Now().Unix()
+	keys := make([]*ConversationLogKey, 0, len(owned))
+	for _, logId := range owned {
+		keys = append(keys, &ConversationLogKey{
+			LogId:      logId,
+			KeyId:      keyId,
+			UserId:     userId,
+			WrappedKey: entries[logId],
+			CreatedAt:  now,
+		})
+	}
+	err := DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "log_id"}, {Name: "key_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"wrapped_key", "created_at"}),
+	}).Create(&keys).Error
+	return len(keys), err
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupEncryptionKeyTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:encryption_keys?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}, &ConversationLog{}, &UserEncryptionKey{}, &ConversationLogKey{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = oldDB
+	})
+	return db
+}
+
+func TestEncryptionKeyRewrapAndRevoke(t *testing.T) {
+	db := setupEncryptionKeyTestDB(t)
+	user := &User{Username: "e2e", Password: "password123"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	laptop := &UserEncryptionKey{UserId: user.Id, Name: "laptop", PublicKey: "pk-laptop", Fingerprint: "laptop"}
+	if err := CreateUserEncryptionKey(laptop); err != nil {
+		t.Fatalf("register laptop key: %v", err)
+	}
+	var reloaded User
+	db.First(&reloaded, user.Id)
+	if !reloaded.EncryptionEnabled {
+		t.Fatalf("expected registering a key to enable encryption")
+	}
+
+	log := &ConversationLog{UserId: user.Id, TokenId: 1, EncryptedData: "data", Nonce: "nonce"}
+	if err := CreateSealedConversationLog(log, []*ConversationLogKey{{KeyId: laptop.Id, WrappedKey: "wrapped-laptop"}}); err != nil {
+		t.Fatalf("create sealed log: %v", err)
+	}
+
+	phone := &UserEncryptionKey{UserId: user.Id, Name: "phone", PublicKey: "pk-phone", Fingerprint: "phone"}
+	if err := CreateUserEncryptionKey(phone); err != nil {
+		t.Fatalf("register phone key: %v", err)
+	}
+	if pending, _ := CountConversationLogsMissingKey(user.Id, phone.Id); pending != 1 {
+		t.Fatalf("expected 1 log pending rewrap, got %d", pending)
+	}
+	queue, err := GetConversationLogKeysForRewrap(user.Id, laptop.Id, phone.Id, 0, 10)
+	if err != nil || len(queue) != 1 || queue[0].LogId != log.Id {
+		t.Fatalf("unexpected rewrap queue %v (err %v)", queue, err)
+	}
+	saved, err := SaveRewrappedConversationLogKeys(user.Id, phone.Id, map[int]string{log.Id: "wrapped-phone", log.Id + 100: "foreign"})
+	if err != nil || saved != 1 {
+		t.Fatalf("expected 1 saved key, got %d (err %v)", saved, err)
+	}
+	if pending, _ := CountConversationLogsMissingKey(user.Id, phone.Id); pending != 0 {
+		t.Fatalf("expected no logs pending rewrap, got %d", pending)
+	}
+
+	if _, err := RevokeUserEncryptionKey(laptop.Id, user.Id, false); err != nil {
+		t.Fatalf("revoke laptop: %v", err)
+	}
+	keys, _ := GetConversationLogKeysByLogIds(user.Id, []int{log.Id})
+	if len(keys[log.Id]) != 1 || keys[log.Id][0].KeyId != phone.Id {
+		t.Fatalf("expected only the phone wrap to remain, got %v", keys[log.Id])
+	}
+	if _, err := RevokeUserEncryptionKey(phone.Id, user.Id, false); !errors.Is(err, ErrLastEncryptionKey) {
+		t.Fatalf("expected revoking the last key to fail, got %v", err)
+	}
+}
+
+func TestRevokeEncryptionKeyRefusesToOrphanLogs(t *testing.T) {
+	db := setupEncryptionKeyTestDB(t)
+	user := &User{Username: "orphans", Password: "password123", AffCode: "orphans"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	laptop := &UserEncryptionKey{UserId: user.Id, Name: "laptop", PublicKey: "pk-o-laptop", Fingerprint: "o-laptop"}
+	phone := &UserEncryptionKey{UserId: user.Id, Name: "phone", PublicKey: "pk-o-phone", Fingerprint: "o-phone"}
+	for _, key := range []*UserEncryptionKey{laptop, phone} {
+		if err := CreateUserEncryptionKey(key); err != nil {
+			t.Fatalf("register key: %v", err)
+		}
+	}
+	shared := &ConversationLog{UserId: user.Id, TokenId: 1, EncryptedData: "shared", Nonce: "n"}
+	if err := CreateSealedConversationLog(shared, []*ConversationLogKey{
+		{KeyId: laptop.Id, WrappedKey: "w-laptop"},
+		{KeyId: phone.Id, WrappedKey: "w-phone"},
+	}); err != nil {
+		t.Fatalf("create shared log: %v", err)
+	}
+	laptopOnly := &ConversationLog{UserId: user.Id, TokenId: 1, EncryptedData: "laptop only", Nonce: "n"}
+	if err := CreateSealedConversationLog(laptopOnly, []*ConversationLogKey{{KeyId: laptop.Id, WrappedKey: "w-laptop"}}); err != nil {
+		t.Fatalf("create laptop-only log: %v", err)
+	}
+
+	orphaned, err := RevokeUserEncryptionKey(laptop.Id, user.Id, false)
+	if !errors.Is(err, ErrEncryptionKeyOrphansLogs) || orphaned != 1 {
+		t.Fatalf("expected revoke to be refused with 1 orphaned log, got %d (err %v)", orphaned, err)
+	}
+	key, _ := GetUserEncryptionKey(laptop.Id, user.Id)
+	if key.Status != EncryptionKeyStatusActive {
+		t.Fatalf("refused revoke must keep the key active")
+	}
+	if keys, _ := GetConversationLogKeysByLogIds(user.Id, []int{laptopOnly.Id}); len(keys[laptopOnly.Id]) != 1 {
+		t.Fatalf("refused revoke must keep the wrapped keys")
+	}
+
+	// a revoked key does not count as another key that can open the log
+	if _, err := RevokeUserEncryptionKey(phone.Id, user.Id, false); err != nil {
+		t.Fatalf("revoke phone: %v", err)
+	}
+	tablet := &UserEncryptionKey{UserId: user.Id, Name: "tablet", PublicKey: "pk-o-tablet", Fingerprint: "o-tablet"}
+	if err := CreateUserEncryptionKey(tablet); err != nil {
+		t.Fatalf("register tablet: %v", err)
+	}
+	if orphaned, err = RevokeUserEncryptionKey(laptop.Id, user.Id, false); !errors.Is(err, ErrEncryptionKeyOrphansLogs) || orphaned != 2 {
+		t.Fatalf("expected 2 orphaned logs after the phone is revoked, got %d (err %v)", orphaned, err)
+	}
+
+	orphaned, err = RevokeUserEncryptionKey(laptop.Id, user.Id, true)
+	if err != nil || orphaned != 2 {
+		t.Fatalf("forced revoke: %d (err %v)", orphaned, err)
+	}
+	key, _ = GetUserEncryptionKey(laptop.Id, user.Id)
+	if key.Status != EncryptionKeyStatusRevoked {
+		t.Fatalf("forced revoke must retire the key")
+	}
+}
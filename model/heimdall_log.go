@@ -38,6 +38,15 @@ func CreateHeimdallLog(log *HeimdallLog) error {
 	return DB.Create(log).Error
 }
 
+// CreateHeimdallLogs inserts a batch of log entries captured in-process.
+// Unlike CreateHeimdallLog, the caller-provided Timestamp is preserved.
+func CreateHeimdallLogs(logs []*HeimdallLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	return DB.CreateInBatches(logs, 100).Error
+}
+
 // GetHeimdallLogsByUserId retrieves Heimdall logs for a specific user
 func GetHeimdallLogsByUserId(userId int, startIdx int, num int) ([]*HeimdallLog, int64, error) {
 	var logs []*HeimdallLog
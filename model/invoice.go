@@ -0,0 +1,248 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"gorm.io/gorm"
+)
+
+const (
+	InvoiceKindTopUp     = "topup"
+	InvoiceKindStatement = "statement"
+)
+
+// Invoice is an invoice for a paid top-up or a monthly usage statement. The
+// document is stored as rendered data in Content, so later changes to the
+// company details or to the user do not alter issued documents.
+type Invoice struct {
+	Id     int    `json:"id"`
+	Number string `json:"number" gorm:"type:varchar(64);index"`
+	UserId int    `json:"user_id" gorm:"uniqueIndex:idx_invoice_ref,priority:1"`
+	Kind   string `json:"kind" gorm:"type:varchar(16);uniqueIndex:idx_invoice_ref,priority:2"`
+	// Reference is the trade number of a top-up, or the month of a
+	// statement as 2006-01.
+	Reference   string  `json:"reference" gorm:"type:varchar(255);uniqueIndex:idx_invoice_ref,priority:3"`
+	PeriodStart int64   `json:"period_start" gorm:"bigint"`
+	PeriodEnd   int64   `json:"period_end" gorm:"bigint"`
+	Currency    string  `json:"currency" gorm:"type:varchar(8)"`
+	Subtotal    float64 `json:"subtotal"`
+	Tax         float64 `json:"tax"`
+	Total       float64 `json:"total"`
+	// Quota is the quota credited by a top-up, or consumed in a statement.
+	Quota       int64  `json:"quota" gorm:"bigint"`
+	Content     string `json:"-" gorm:"type:text"`
+	EmailedTime int64  `json:"emailed_time" gorm:"bigint;default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// InvoiceParty is the issuer or the customer of an invoice.
+type InvoiceParty struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	TaxId   string `json:"tax_id,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+// InvoiceLine is a payment: the top-up of an invoice, or a top-up or refund
+// listed on a statement.
+type InvoiceLine struct {
+	Time        int64   `json:"time"`
+	Description string  `json:"description"`
+	Reference   string  `json:"reference"`
+	Quota       int64   `json:"quota"`
+	Currency    string  `json:"currency"`
+	Amount      float64 `json:"amount"`
+}
+
+// UsageBreakdown is the consumption of one model, token or group.
+type UsageBreakdown struct {
+	Name             string `json:"name"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Quota            int64  `json:"quota"`
+}
+
+// StatementUsage is the consumption of a statement's month.
+type StatementUsage struct {
+	ByModel []UsageBreakdown `json:"by_model"`
+	ByToken []UsageBreakdown `json:"by_token"`
+	ByGroup []UsageBreakdown `json:"by_group"`
+}
+
+type InvoiceContent struct {
+	Issuer   InvoiceParty    `json:"issuer"`
+	Customer InvoiceParty    `json:"customer"`
+	TaxName  string          `json:"tax_name,omitempty"`
+	TaxRate  float64         `json:"tax_rate"`
+	Lines    []InvoiceLine   `json:"lines"`
+	Usage    *StatementUsage `json:"usage,omitempty"`
+	// QuotaPerUnit is the quota of one USD when the document was issued.
+	QuotaPerUnit float64 `json:"quota_per_unit"`
+	Footer       string  `json:"footer,omitempty"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.InvoiceVersion, func() []interface{} {
+		return []interface{}{
+			&Invoice{},
+			&TopUp{},
+		}
+	})
+}
+
+func (invoice *Invoice) GetContent() (*InvoiceContent, error) {
+	content := &InvoiceContent{}
+	if err := common.UnmarshalJsonStr(invoice.Content, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func (invoice *Invoice) SetContent(content *InvoiceContent) error {
+	data, err := common.Marshal(content)
+	if err != nil {
+		return err
+	}
+	invoice.Content = string(data)
+	return nil
+}
+
+// CreateInvoice stores an invoice and numbers it prefix, the month issued and
+// the id. If the user already has the document of this kind and reference,
+// that one is returned instead.
+func CreateInvoice(invoice *Invoice, prefix string) (*Invoice, error) {
+	if existing, err := GetInvoiceByReference(invoice.UserId, invoice.Kind, invoice.Reference); err == nil {
+		return existing, nil
+	}
+	if invoice.CreatedTime == 0 {
+		invoice.CreatedTime = common.GetTimestamp()
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(invoice).Error; err != nil {
+			return err
+		}
+		invoice.Number = fmt.Sprintf("%s%s-%06d", prefix, time.Unix(invoice.CreatedTime, 0).UTC().Format("200601"), invoice.Id)
+		return tx.Model(invoice).Update("number", invoice.Number).Error
+	})
+	if err != nil {
+		// lost a race to create the same document
+		if existing, getErr := GetInvoiceByReference(invoice.UserId, invoice.Kind, invoice.Reference); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return invoice, nil
+}
+
+func GetInvoiceByReference(userId int, kind string, reference string) (*Invoice, error) {
+	invoice := &Invoice{}
+	err := DB.Where("user_id = ? AND kind = ? AND reference = ?", userId, kind, reference).First(invoice).Error
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+func GetUserInvoiceById(userId int, id int) (*Invoice, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	invoice := &Invoice{}
+	err := DB.Where("id = ? AND user_id = ?", id, userId).First(invoice).Error
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// GetUserInvoices lists a user's invoices and statements, newest first. An
+// empty kind lists both.
+func GetUserInvoices(userId int, kind string, pageInfo *common.PageInfo) (invoices []*Invoice, total int64, err error) {
+	tx := DB.Model(&Invoice{}).Where("user_id = ?", userId)
+	if kind != "" {
+		tx = tx.Where("kind = ?", kind)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&invoices).Error
+	return invoices, total, err
+}
+
+func MarkInvoiceEmailed(id int) error {
+	return DB.Model(&Invoice{}).Where("id = ?", id).Update("emailed_time", common.GetTimestamp()).Error
+}
+
+// GetUserConsumeBreakdown sums a user's consume logs in [start, end) by
+// "model", "token" or "group", largest consumption first.
+func GetUserConsumeBreakdown(userId int, start int64, end int64, by string) ([]UsageBreakdown, error) {
+	var col string
+	switch by {
+	case "model":
+		col = "model_name"
+	case "token":
+		col = "token_name"
+	case "group":
+		col = logGroupCol
+	default:
+		return nil, fmt.Errorf("unknown breakdown %s", by)
+	}
+	var breakdown []UsageBreakdown
+	err := LOG_DB.Table("logs").
+		Select(col+" as name, count(*) as requests, sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens, sum(quota) as quota").
+		Where("user_id = ? AND type = ? AND created_at >= ? AND created_at < ?", userId, LogTypeConsume, start, end).
+		Group("name").
+		Order("quota desc").
+		Scan(&breakdown).Error
+	return breakdown, err
+}
+
+// GetStatementUserIds returns the users who consumed or paid in [start, end).
+func GetStatementUserIds(start int64, end int64) ([]int, error) {
+	var consumers []int
+	err := LOG_DB.Table("logs").
+		Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeConsume, start, end).
+		Distinct().Pluck("user_id", &consumers).Error
+	if err != nil {
+		return nil, err
+	}
+	var payers []int
+	err = DB.Model(&TopUp{}).
+		Where("complete_time >= ? AND complete_time < ?", start, end).
+		Distinct().Pluck("user_id", &payers).Error
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool, len(consumers)+len(payers))
+	userIds := make([]int, 0, len(consumers)+len(payers))
+	for _, id := range append(consumers, payers...) {
+		if !seen[id] {
+			seen[id] = true
+			userIds = append(userIds, id)
+		}
+	}
+	return userIds, nil
+}
+
+// GetUserPaidTopUps returns a user's orders paid in [start, end).
+func GetUserPaidTopUps(userId int, start int64, end int64) ([]*TopUp, error) {
+	var topUps []*TopUp
+	err := DB.Where("user_id = ? AND complete_time >= ? AND complete_time < ? AND status IN ?", userId, start, end,
+		[]string{common.TopUpStatusSuccess, common.TopUpStatusPartialRefunded, common.TopUpStatusRefunded}).
+		Order("complete_time asc").Find(&topUps).Error
+	return topUps, err
+}
+
+// GetUserRefunds returns a user's refunds made in [start, end).
+func GetUserRefunds(userId int, start int64, end int64) ([]*PaymentRefund, error) {
+	var refunds []*PaymentRefund
+	err := DB.Where("user_id = ? AND created_time >= ? AND created_time < ?", userId, start, end).
+		Order("created_time asc").Find(&refunds).Error
+	return refunds, err
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupInvoiceTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&Invoice{}, &TopUp{}, &Log{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB, oldLogDB := DB, LOG_DB
+	DB, LOG_DB = db, db
+	t.Cleanup(func() { DB, LOG_DB = oldDB, oldLogDB })
+	common.UsingSQLite = true
+	common.UsingPostgreSQL = false
+	common.UsingMySQL = false
+	initCol()
+	return db
+}
+
+func TestCreateInvoiceNumbersOncePerReference(t *testing.T) {
+	setupInvoiceTestDB(t)
+	first, err := CreateInvoice(&Invoice{UserId: 1, Kind: InvoiceKindStatement, Reference: "2025-07", CreatedTime: 1754006400}, "STM-")
+	if err != nil {
+		t.Fatalf("create invoice: %v", err)
+	}
+	if first.Number != "STM-202508-000001" {
+		t.Fatalf("number = %s", first.Number)
+	}
+	again, err := CreateInvoice(&Invoice{UserId: 1, Kind: InvoiceKindStatement, Reference: "2025-07"}, "STM-")
+	if err != nil || again.Id != first.Id {
+		t.Fatalf("expected the existing statement, got %+v, %v", again, err)
+	}
+	other, err := CreateInvoice(&Invoice{UserId: 2, Kind: InvoiceKindStatement, Reference: "2025-07"}, "STM-")
+	if err != nil || other.Id == first.Id {
+		t.Fatalf("expected a statement for another user, got %+v, %v", other, err)
+	}
+	if _, err := GetUserInvoiceById(2, first.Id); err == nil {
+		t.Fatal("expected another user's invoice to be hidden")
+	}
+}
+
+func TestGetUserConsumeBreakdown(t *testing.T) {
+	db := setupInvoiceTestDB(t)
+	logs := []Log{
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: 100, ModelName: "a", TokenName: "t1", Group: "default", Quota: 10, PromptTokens: 1, CompletionTokens: 2},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: 150, ModelName: "b", TokenName: "t1", Group: "vip", Quota: 30},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: 199, ModelName: "a", TokenName: "t2", Group: "default", Quota: 5},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: 200, ModelName: "a", Quota: 1000},
+		{UserId: 1, Type: LogTypeTopup, CreatedAt: 120, ModelName: "a", Quota: 1000},
+		{UserId: 2, Type: LogTypeConsume, CreatedAt: 120, ModelName: "a", Quota: 1000},
+	}
+	if err := db.Create(&logs).Error; err != nil {
+		t.Fatalf("create logs: %v", err)
+	}
+
+	byModel, err := GetUserConsumeBreakdown(1, 100, 200, "model")
+	if err != nil {
+		t.Fatalf("breakdown by model: %v", err)
+	}
+	if len(byModel) != 2 || byModel[0].Name != "b" || byModel[0].Quota != 30 ||
+		byModel[1].Name != "a" || byModel[1].Quota != 15 || byModel[1].Requests != 2 || byModel[1].CompletionTokens != 2 {
+		t.Fatalf("unexpected breakdown by model %+v", byModel)
+	}
+	byGroup, err := GetUserConsumeBreakdown(1, 100, 200, "group")
+	if err != nil || len(byGroup) != 2 || byGroup[0].Name != "vip" {
+		t.Fatalf("unexpected breakdown by group %+v, %v", byGroup, err)
+	}
+	if _, err := GetUserConsumeBreakdown(1, 100, 200, "channel"); err == nil {
+		t.Fatal("expected an unknown breakdown to fail")
+	}
+
+	userIds, err := GetStatementUserIds(100, 200)
+	if err != nil || len(userIds) != 2 {
+		t.Fatalf("statement users = %v, %v", userIds, err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -37,6 +38,7 @@ type Log struct {
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	Other            string `json:"other"`
+	OrganizationId   int    `json:"organization_id" gorm:"default:0;index"`
 }
 
 // don't use iota, avoid change log type value
@@ -130,7 +132,8 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 			}
 			return ""
 		}(),
-		Other: otherStr,
+		Other:          otherStr,
+		OrganizationId: c.GetInt(string(constant.ContextKeyTokenOrganizationId)),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -189,7 +192,8 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 			return ""
 		}(),
-		Other: otherStr,
+		Other:          otherStr,
+		OrganizationId: c.GetInt(string(constant.ContextKeyTokenOrganizationId)),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -303,6 +307,72 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 	return logs, total, err
 }
 
+// GetOrganizationLogs returns the logs of requests made with an
+// organization's tokens; a non-zero userId narrows them to one member.
+func GetOrganizationLogs(organizationId int, userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, startIdx int, num int) (logs []*Log, total int64, err error) {
+	tx := LOG_DB.Where("logs.organization_id = ?", organizationId)
+	if userId != 0 {
+		tx = tx.Where("logs.user_id = ?", userId)
+	}
+	if logType != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", logType)
+	}
+	if modelName != "" {
+		tx = tx.Where("logs.model_name like ?", modelName)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", endTimestamp)
+	}
+	err = tx.Model(&Log{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("logs.id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	formatUserLogs(logs)
+	return logs, total, err
+}
+
+// OrganizationUsage is the consumption of an organization grouped by member
+// or by model.
+type OrganizationUsage struct {
+	UserId           int    `json:"user_id,omitempty"`
+	Username         string `json:"username,omitempty"`
+	ModelName        string `json:"model_name,omitempty"`
+	Quota            int    `json:"quota"`
+	Count            int    `json:"count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// GetOrganizationUsage sums the consume logs of an organization, grouped by
+// "user" or "model".
+func GetOrganizationUsage(organizationId int, userId int, groupBy string, startTimestamp int64, endTimestamp int64) (usages []*OrganizationUsage, err error) {
+	columns := "user_id, username"
+	if groupBy == "model" {
+		columns = "model_name"
+	}
+	tx := LOG_DB.Table("logs").
+		Select(columns+", sum(quota) quota, count(*) count, sum(prompt_tokens) prompt_tokens, sum(completion_tokens) completion_tokens").
+		Where("organization_id = ? and type = ?", organizationId, LogTypeConsume)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Group(columns).Order("quota desc").Scan(&usages).Error
+	return usages, err
+}
+
 func SearchAllLogs(keyword string) (logs []*Log, err error) {
 	err = LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(common.MaxRecentItems).Find(&logs).Error
 	return logs, err
@@ -314,6 +384,15 @@ func SearchUserLogs(userId int, keyword string) (logs []*Log, err error) {
 	return logs, err
 }
 
+// GetPromptEstimateLogs returns recent consume logs that recorded a local
+// prompt token estimate, for tokenizer calibration.
+func GetPromptEstimateLogs(startTimestamp int64, limit int) (logs []*Log, err error) {
+	err = LOG_DB.Select("model_name", "prompt_tokens", "other").
+		Where("type = ? and created_at >= ? and prompt_tokens > 0 and other LIKE ?", LogTypeConsume, startTimestamp, "%estimated_prompt_tokens%").
+		Order("id desc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
 type Stat struct {
 	Quota int `json:"quota"`
 	Rpm   int `json:"rpm"`
@@ -223,6 +223,9 @@ func InitDB() (err error) {
     if err = modelmigrations.Run(DB); err != nil {
         return err
     }
+    if err = SyncBuiltinAdminRoles(); err != nil {
+        return err
+    }
     return nil
 }
 
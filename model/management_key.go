@@ -0,0 +1,225 @@
+package model
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+)
+
+// ManagementKeyPrefix marks management keys in the Authorization header.
+const ManagementKeyPrefix = "mk-"
+
+// Management key scopes are "resource:action" pairs like admin permissions,
+// with "*" allowed only as the action.
+var ManagementKeyResources = []string{
+	"tokens",        // the owner's tokens
+	"logs",          // the owner's logs and usage data, and all logs with log admin permission
+	"organizations", // the owner's organizations, and the pools with organization admin permission
+	"channels",      // channels, groups and prefill groups
+	"models",        // model and vendor metadata
+	"users",         // user management
+	"redemptions",   // redemption codes and lotteries
+	"plans",         // plans, vouchers and packages
+	"tickets",       // support ticket administration
+	"audit",         // audit log
+	"budgets",       // the owner's spending budgets
+}
+
+// adminResourceScopes maps the admin resources reachable with a management
+// key to their scope. Admin routes of other resources reject management keys.
+var adminResourceScopes = map[string]string{
+	"log":          "logs",
+	"organization": "organizations",
+	"channel":      "channels",
+	"model":        "models",
+	"user":         "users",
+	"redemption":   "redemptions",
+	"plan":         "plans",
+	"ticket":       "tickets",
+	"audit":        "audit",
+}
+
+// ManagementKey is a scoped credential for automation against /api. It acts
+// as its owner, limited to its scopes; only the SHA-256 of the key is stored.
+type ManagementKey struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Name         string `json:"name" gorm:"type:varchar(64)"`
+	KeyHash      string `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+	KeyPrefix    string `json:"key_prefix" gorm:"type:varchar(16)"`
+	Scopes       string `json:"scopes" gorm:"type:text"`
+	AllowIps     string `json:"allow_ips" gorm:"type:text"`
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;default:-1"`
+	LastUsedTime int64  `json:"last_used_time" gorm:"bigint;default:0"`
+	LastUsedIp   string `json:"last_used_ip" gorm:"type:varchar(64);default:''"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.ManagementKeyVersion, func() []interface{} {
+		return []interface{}{
+			&ManagementKey{},
+		}
+	})
+}
+
+// AdminResourceScope returns the management key scope resource of an admin
+// resource, or "" when management keys may not use it.
+func AdminResourceScope(resource string) string {
+	return adminResourceScopes[resource]
+}
+
+func HashManagementKey(key string) string {
+	return hex.EncodeToString(common.Sha256Raw([]byte(key)))
+}
+
+// GenerateManagementKey returns a new key. It is shown to the owner once.
+func GenerateManagementKey() (string, error) {
+	key, err := common.GenerateRandomCharsKey(40)
+	if err != nil {
+		return "", err
+	}
+	return ManagementKeyPrefix + key, nil
+}
+
+// NormalizeManagementKeyScopes validates scopes and drops duplicates.
+func NormalizeManagementKeyScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		resource, action, ok := strings.Cut(scope, ":")
+		if !ok || (action != "*" && action != AdminActionRead && action != AdminActionWrite) || !common.StringsContains(ManagementKeyResources, resource) {
+			return nil, fmt.Errorf("无效的权限范围 %s", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, errors.New("至少需要一个权限范围")
+	}
+	return normalized, nil
+}
+
+// NormalizeManagementKeyIps validates an allowlist of IPs and CIDRs, one per
+// line or comma separated.
+func NormalizeManagementKeyIps(allowIps string) (string, error) {
+	var entries []string
+	for _, entry := range strings.FieldsFunc(allowIps, func(r rune) bool {
+		return r == '\n' || r == ',' || r == ' ' || r == '\r'
+	}) {
+		if !common.IsIP(entry) {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return "", fmt.Errorf("无效的 IP 或网段 %s", entry)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, "\n"), nil
+}
+
+func (key *ManagementKey) GetScopes() []string {
+	var scopes []string
+	if key.Scopes != "" {
+		_ = common.Unmarshal([]byte(key.Scopes), &scopes)
+	}
+	return scopes
+}
+
+func (key *ManagementKey) SetScopes(scopes []string) {
+	key.Scopes = common.GetJsonString(scopes)
+}
+
+// HasScope reports whether the key may perform action on resource.
+func (key *ManagementKey) HasScope(resource string, action string) bool {
+	for _, scope := range key.GetScopes() {
+		r, a, _ := strings.Cut(scope, ":")
+		if r == resource && (a == "*" || a == action) {
+			return true
+		}
+	}
+	return false
+}
+
+func (key *ManagementKey) IsExpired() bool {
+	return key.ExpiredTime != -1 && key.ExpiredTime < common.GetTimestamp()
+}
+
+// AllowsIp reports whether ip is on the allowlist. An empty allowlist
+// allows every address.
+func (key *ManagementKey) AllowsIp(ip string) bool {
+	if strings.TrimSpace(key.AllowIps) == "" {
+		return true
+	}
+	clientIp := net.ParseIP(ip)
+	for _, entry := range strings.Split(key.AllowIps, "\n") {
+		entry = strings.TrimSpace(entry)
+		if entry == ip {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil && clientIp != nil && network.Contains(clientIp) {
+			return true
+		}
+	}
+	return false
+}
+
+func GetManagementKeyByKey(key string) (*ManagementKey, error) {
+	managementKey := &ManagementKey{}
+	err := DB.First(managementKey, "key_hash = ?", HashManagementKey(key)).Error
+	return managementKey, err
+}
+
+func GetUserManagementKeys(userId int) ([]*ManagementKey, error) {
+	var keys []*ManagementKey
+	err := DB.Where("user_id = ?", userId).Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+func GetUserManagementKeyById(id int, userId int) (*ManagementKey, error) {
+	key := &ManagementKey{}
+	err := DB.First(key, "id = ? AND user_id = ?", id, userId).Error
+	return key, err
+}
+
+// Insert stores the key under the hash of plainKey.
+func (key *ManagementKey) Insert(plainKey string) error {
+	key.KeyHash = HashManagementKey(plainKey)
+	key.KeyPrefix = plainKey[:len(ManagementKeyPrefix)+6]
+	key.CreatedTime = common.GetTimestamp()
+	return DB.Create(key).Error
+}
+
+func (key *ManagementKey) Update() error {
+	return DB.Model(key).Select("name", "scopes", "allow_ips", "expired_time").Updates(key).Error
+}
+
+func (key *ManagementKey) Delete() error {
+	return DB.Delete(key).Error
+}
+
+// managementKeyUsageInterval throttles last-used writes.
+const managementKeyUsageInterval = 60
+
+// RecordUsage updates the last use of the key, at most once a minute per
+// address.
+func (key *ManagementKey) RecordUsage(ip string) {
+	now := common.GetTimestamp()
+	if key.LastUsedIp == ip && now-key.LastUsedTime < managementKeyUsageInterval {
+		return
+	}
+	err := DB.Model(&ManagementKey{}).Where("id = ?", key.Id).Updates(map[string]interface{}{
+		"last_used_time": now,
+		"last_used_ip":   ip,
+	}).Error
+	if err != nil {
+		common.SysLog("failed to record management key usage: " + err.Error())
+	}
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestManagementKeyHasScope(t *testing.T) {
+	key := &ManagementKey{}
+	key.SetScopes([]string{"tokens:*", "logs:read"})
+	cases := []struct {
+		resource string
+		action   string
+		want     bool
+	}{
+		{"tokens", AdminActionRead, true},
+		{"tokens", AdminActionWrite, true},
+		{"logs", AdminActionRead, true},
+		{"logs", AdminActionWrite, false},
+		{"users", AdminActionRead, false},
+	}
+	for _, tc := range cases {
+		if got := key.HasScope(tc.resource, tc.action); got != tc.want {
+			t.Errorf("HasScope(%q, %q) = %v, want %v", tc.resource, tc.action, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeManagementKeyScopes(t *testing.T) {
+	scopes, err := NormalizeManagementKeyScopes([]string{" tokens:read", "tokens:read", "users:*"})
+	if err != nil || len(scopes) != 2 || scopes[0] != "tokens:read" || scopes[1] != "users:*" {
+		t.Fatalf("scopes = %v, %v", scopes, err)
+	}
+	for _, invalid := range [][]string{nil, {"*:read"}, {"tokens"}, {"tokens:delete"}, {"settings:read"}} {
+		if _, err := NormalizeManagementKeyScopes(invalid); err == nil {
+			t.Errorf("expected %v to be refused", invalid)
+		}
+	}
+}
+
+func TestManagementKeyAllowsIp(t *testing.T) {
+	allowIps, err := NormalizeManagementKeyIps("203.0.113.7, 10.0.0.0/8\n2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &ManagementKey{AllowIps: allowIps}
+	for ip, want := range map[string]bool{
+		"203.0.113.7": true,
+		"203.0.113.8": false,
+		"10.1.2.3":    true,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+	} {
+		if got := key.AllowsIp(ip); got != want {
+			t.Errorf("AllowsIp(%q) = %v, want %v", ip, got, want)
+		}
+	}
+	if !(&ManagementKey{}).AllowsIp("198.51.100.1") {
+		t.Error("an empty allowlist allows every address")
+	}
+	if _, err := NormalizeManagementKeyIps("10.0.0.0/33"); err == nil {
+		t.Error("expected an invalid network to be refused")
+	}
+}
+
+func TestManagementKeyExpiry(t *testing.T) {
+	now := common.GetTimestamp()
+	if (&ManagementKey{ExpiredTime: -1}).IsExpired() {
+		t.Error("-1 never expires")
+	}
+	if (&ManagementKey{ExpiredTime: now + 60}).IsExpired() {
+		t.Error("key expired early")
+	}
+	if !(&ManagementKey{ExpiredTime: now - 1}).IsExpired() {
+		t.Error("key did not expire")
+	}
+}
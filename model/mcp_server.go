@@ -0,0 +1,165 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+)
+
+const (
+	McpTransportHTTP  = "http"
+	McpTransportSSE   = "sse"
+	McpTransportStdio = "stdio"
+)
+
+const (
+	McpServerStatusEnabled  = 1
+	McpServerStatusDisabled = 2
+)
+
+var mcpServerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// McpServer is an upstream MCP server exposed through the gateway. Its tools
+// are published as "<name>__<tool>" in the unified catalog.
+type McpServer struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Transport   string `json:"transport" gorm:"type:varchar(16);default:'http'"`
+	// Url is the streamable HTTP endpoint, or the SSE endpoint for "sse".
+	Url     string `json:"url" gorm:"type:varchar(512);default:''"`
+	Headers string `json:"headers" gorm:"type:text"` // JSON object sent with every HTTP request
+	// Command, Args (JSON array) and Env (JSON object) start a stdio server.
+	Command string `json:"command" gorm:"type:varchar(512);default:''"`
+	Args    string `json:"args" gorm:"type:text"`
+	Env     string `json:"env" gorm:"type:text"`
+	Status  int    `json:"status" gorm:"default:1"`
+	// Price is charged per tool call in USD, multiplied by the group ratio.
+	// ToolPrices (JSON object tool -> USD) overrides it per tool.
+	Price       float64 `json:"price" gorm:"default:0"`
+	ToolPrices  string  `json:"tool_prices" gorm:"type:text"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64   `json:"updated_time" gorm:"bigint"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.McpGatewayVersion, func() []interface{} {
+		return []interface{}{
+			&McpServer{},
+			&Token{},
+		}
+	})
+}
+
+func (server *McpServer) Validate() error {
+	if !mcpServerNamePattern.MatchString(server.Name) || strings.Contains(server.Name, "__") {
+		return errors.New("name must be 1-64 letters, digits, '-' or '_' and must not contain '__'")
+	}
+	switch server.Transport {
+	case McpTransportHTTP, McpTransportSSE:
+		if !strings.HasPrefix(server.Url, "http://") && !strings.HasPrefix(server.Url, "https://") {
+			return errors.New("url must be an http(s) URL")
+		}
+	case McpTransportStdio:
+		if strings.TrimSpace(server.Command) == "" {
+			return errors.New("command is required for stdio servers")
+		}
+	default:
+		return errors.New("transport must be http, sse or stdio")
+	}
+	for field, value := range map[string]string{"headers": server.Headers, "env": server.Env, "tool_prices": server.ToolPrices} {
+		if value != "" {
+			if _, err := common.StrToMap(value); err != nil {
+				return errors.New(field + " must be a JSON object")
+			}
+		}
+	}
+	if server.Args != "" {
+		var args []string
+		if err := common.Unmarshal([]byte(server.Args), &args); err != nil {
+			return errors.New("args must be a JSON array of strings")
+		}
+	}
+	if server.Price < 0 {
+		return errors.New("price must not be negative")
+	}
+	return nil
+}
+
+func (server *McpServer) HeaderMap() map[string]string {
+	return stringMap(server.Headers)
+}
+
+func (server *McpServer) EnvMap() map[string]string {
+	return stringMap(server.Env)
+}
+
+func (server *McpServer) ArgList() []string {
+	var args []string
+	if server.Args != "" {
+		_ = common.Unmarshal([]byte(server.Args), &args)
+	}
+	return args
+}
+
+// ToolPrice returns the per-call price for tool in USD.
+func (server *McpServer) ToolPrice(tool string) float64 {
+	if server.ToolPrices != "" {
+		var prices map[string]float64
+		if err := common.Unmarshal([]byte(server.ToolPrices), &prices); err == nil {
+			if price, ok := prices[tool]; ok {
+				return price
+			}
+		}
+	}
+	return server.Price
+}
+
+func stringMap(value string) map[string]string {
+	result := make(map[string]string)
+	if value != "" {
+		_ = common.Unmarshal([]byte(value), &result)
+	}
+	return result
+}
+
+func (server *McpServer) Insert() error {
+	now := common.GetTimestamp()
+	server.CreatedTime = now
+	server.UpdatedTime = now
+	return DB.Create(server).Error
+}
+
+func (server *McpServer) Update() error {
+	server.UpdatedTime = common.GetTimestamp()
+	return DB.Model(server).Select("name", "description", "transport", "url", "headers", "command", "args", "env",
+		"status", "price", "tool_prices", "updated_time").Updates(server).Error
+}
+
+func (server *McpServer) Delete() error {
+	return DB.Delete(server).Error
+}
+
+func GetAllMcpServers() ([]*McpServer, error) {
+	var servers []*McpServer
+	err := DB.Order("id asc").Find(&servers).Error
+	return servers, err
+}
+
+func GetEnabledMcpServers() ([]*McpServer, error) {
+	var servers []*McpServer
+	err := DB.Where("status = ?", McpServerStatusEnabled).Order("id asc").Find(&servers).Error
+	return servers, err
+}
+
+func GetMcpServerById(id int) (*McpServer, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	server := &McpServer{}
+	err := DB.First(server, "id = ?", id).Error
+	return server, err
+}
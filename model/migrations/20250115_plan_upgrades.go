@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const PlanUpgradesVersion = "20250115_plan_upgrades"
@@ -32,20 +33,20 @@ func planUpgradesDown(tx *gorm.DB) error {
 	if err := tx.Migrator().DropTable("voucher_codes"); err != nil {
 		return err
 	}
-	if err := tx.Migrator().DropColumn(&struct{ TableName string `gorm:"-"`; TokenLimit int64; AllowedModels interface{}; ValidityDays int }{TableName: "plans"}, "token_limit"); err != nil {
-		return err
-	}
-	if err := tx.Migrator().DropColumn(&struct{ TableName string `gorm:"-"`; TokenLimit int64; AllowedModels interface{}; ValidityDays int }{TableName: "plans"}, "allowed_models"); err != nil {
-		return err
-	}
-	if err := tx.Migrator().DropColumn(&struct{ TableName string `gorm:"-"`; TokenLimit int64; AllowedModels interface{}; ValidityDays int }{TableName: "plans"}, "validity_days"); err != nil {
-		return err
-	}
-	if err := tx.Migrator().DropColumn(&struct{ TableName string `gorm:"-"`; ExpiresAt interface{}; EnforcementMetadata interface{} }{TableName: "plan_assignments"}, "expires_at"); err != nil {
-		return err
-	}
-	if err := tx.Migrator().DropColumn(&struct{ TableName string `gorm:"-"`; ExpiresAt interface{}; EnforcementMetadata interface{} }{TableName: "plan_assignments"}, "enforcement_metadata"); err != nil {
-		return err
+	dropped := map[string][]string{
+		"plans":            {"token_limit", "allowed_models", "validity_days"},
+		"plan_assignments": {"expires_at", "enforcement_metadata"},
+	}
+	for _, table := range []string{"plans", "plan_assignments"} {
+		for _, column := range dropped[table] {
+			if !tx.Migrator().HasColumn(table, column) {
+				continue
+			}
+			// the migrator's DropColumn needs a model; the tables are named here
+			if err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: table}, clause.Column{Name: column}).Error; err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
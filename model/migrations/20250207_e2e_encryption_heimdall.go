@@ -1,13 +1,48 @@
 package migrations
 
 import (
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+const E2EEncryptionHeimdallVersion = "20250207_e2e_encryption_heimdall"
+
 func init() {
-	RegisterSchemaProvider("20250207_e2e_encryption_heimdall", E2EEncryptionHeimdallSchema)
+	RegisterSchemaProvider(E2EEncryptionHeimdallVersion, E2EEncryptionHeimdallSchema)
+	registerMigration(Migration{
+		Version: E2EEncryptionHeimdallVersion,
+		Name:    "E2E conversation encryption and Heimdall request logs",
+		Up:      e2eEncryptionHeimdallUp,
+		Down:    e2eEncryptionHeimdallDown,
+	})
+}
+
+func e2eEncryptionHeimdallUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(E2EEncryptionHeimdallVersion)
+	if !ok {
+		return errors.New("schema provider not registered for e2e encryption migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func e2eEncryptionHeimdallDown(tx *gorm.DB) error {
+	for _, table := range []string{"anomaly_detections", "heimdall_logs", "conversation_logs"} {
+		if err := tx.Migrator().DropTable(table); err != nil {
+			return err
+		}
+	}
+	if err := tx.Migrator().DropColumn(&TokenEncryption{}, "conversation_logging_enabled"); err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropColumn(&UserEncryption{}, "encryption_key_hash"); err != nil {
+		return err
+	}
+	return tx.Migrator().DropColumn(&UserEncryption{}, "encryption_enabled")
 }
 
 func E2EEncryptionHeimdallSchema() []interface{} {
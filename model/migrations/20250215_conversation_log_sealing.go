@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const ConversationLogSealingVersion = "20250215_conversation_log_sealing"
+
+func init() {
+	registerMigration(Migration{
+		Version: ConversationLogSealingVersion,
+		Name:    "Conversation logs sealed to per-device encryption keys",
+		Up:      conversationLogSealingUp,
+		Down:    conversationLogSealingDown,
+	})
+}
+
+func conversationLogSealingUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(ConversationLogSealingVersion)
+	if !ok {
+		return errors.New("schema provider not registered for conversation log sealing migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func conversationLogSealingDown(tx *gorm.DB) error {
+	for _, table := range []string{"conversation_log_keys", "user_encryption_keys"} {
+		if err := tx.Migrator().DropTable(table); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"source", "key_scheme"} {
+		if tx.Migrator().HasColumn(&ConversationLog{}, column) {
+			if err := tx.Migrator().DropColumn(&ConversationLog{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
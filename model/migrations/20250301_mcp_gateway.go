@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const McpGatewayVersion = "20250301_mcp_gateway"
+
+func init() {
+	registerMigration(Migration{
+		Version: McpGatewayVersion,
+		Name:    "MCP server registry and per-token MCP allowlists",
+		Up:      mcpGatewayUp,
+		Down:    mcpGatewayDown,
+	})
+}
+
+func mcpGatewayUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(McpGatewayVersion)
+	if !ok {
+		return errors.New("schema provider not registered for mcp gateway migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+// mcpGatewayDown drops the server table (first schema entry) and the token
+// allowlist columns (second entry).
+func mcpGatewayDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(McpGatewayVersion)
+	if !ok {
+		return errors.New("schema provider not registered for mcp gateway migration")
+	}
+	if len(tables) > 1 {
+		for _, column := range []string{"mcp_limits_enabled", "mcp_limits"} {
+			if tx.Migrator().HasColumn(tables[1], column) {
+				if err := tx.Migrator().DropColumn(tables[1], column); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(tables) > 0 {
+		return tx.Migrator().DropTable(tables[0])
+	}
+	return nil
+}
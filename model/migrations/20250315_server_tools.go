@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const ServerToolsVersion = "20250315_server_tools"
+
+func init() {
+	registerMigration(Migration{
+		Version: ServerToolsVersion,
+		Name:    "Gateway-executed server tools and retrieval documents",
+		Up:      serverToolsUp,
+		Down:    serverToolsDown,
+	})
+}
+
+func serverToolsUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(ServerToolsVersion)
+	if !ok {
+		return errors.New("schema provider not registered for server tools migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func serverToolsDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(ServerToolsVersion)
+	if !ok {
+		return errors.New("schema provider not registered for server tools migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
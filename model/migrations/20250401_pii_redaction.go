@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const PIIRedactionVersion = "20250401_pii_redaction"
+
+func init() {
+	registerMigration(Migration{
+		Version: PIIRedactionVersion,
+		Name:    "Detector details on request flags for PII redaction",
+		Up:      piiRedactionUp,
+		Down:    piiRedactionDown,
+	})
+}
+
+func piiRedactionUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(PIIRedactionVersion)
+	if !ok {
+		return errors.New("schema provider not registered for pii redaction migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func piiRedactionDown(tx *gorm.DB) error {
+	if tx.Migrator().HasColumn("request_flags", "details") {
+		return tx.Migrator().DropColumn("request_flags", "details")
+	}
+	return nil
+}
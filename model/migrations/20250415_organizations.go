@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const OrganizationsVersion = "20250415_organizations"
+
+func init() {
+	registerMigration(Migration{
+		Version: OrganizationsVersion,
+		Name:    "Organizations, members, invitations and organization-owned tokens",
+		Up:      organizationsUp,
+		Down:    organizationsDown,
+	})
+}
+
+func organizationsUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(OrganizationsVersion)
+	if !ok {
+		return errors.New("schema provider not registered for organizations migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+// organizationsDown drops the organization tables (all but the last schema
+// entry) and the token column (last entry).
+func organizationsDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(OrganizationsVersion)
+	if !ok {
+		return errors.New("schema provider not registered for organizations migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	last := tables[len(tables)-1]
+	if tx.Migrator().HasColumn(last, "organization_id") {
+		if err := tx.Migrator().DropColumn(last, "organization_id"); err != nil {
+			return err
+		}
+	}
+	for i := len(tables) - 2; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
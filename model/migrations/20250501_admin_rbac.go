@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const AdminRBACVersion = "20250501_admin_rbac"
+
+func init() {
+	registerMigration(Migration{
+		Version: AdminRBACVersion,
+		Name:    "Admin roles and permissions",
+		Up:      adminRBACUp,
+		Down:    adminRBACDown,
+	})
+}
+
+func adminRBACUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(AdminRBACVersion)
+	if !ok {
+		return errors.New("schema provider not registered for admin RBAC migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func adminRBACDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(AdminRBACVersion)
+	if !ok {
+		return errors.New("schema provider not registered for admin RBAC migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const AuditLogVersion = "20250515_audit_log"
+
+func init() {
+	registerMigration(Migration{
+		Version: AuditLogVersion,
+		Name:    "Hash-chained audit log for administrative actions",
+		Up:      auditLogUp,
+		Down:    auditLogDown,
+	})
+}
+
+func auditLogUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(AuditLogVersion)
+	if !ok {
+		return errors.New("schema provider not registered for audit log migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func auditLogDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(AuditLogVersion)
+	if !ok {
+		return errors.New("schema provider not registered for audit log migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
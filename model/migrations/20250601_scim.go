@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const ScimVersion = "20250601_scim"
+
+func init() {
+	registerMigration(Migration{
+		Version: ScimVersion,
+		Name:    "SCIM provisioned users and groups",
+		Up:      scimUp,
+		Down:    scimDown,
+	})
+}
+
+func scimUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(ScimVersion)
+	if !ok {
+		return errors.New("schema provider not registered for SCIM migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func scimDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(ScimVersion)
+	if !ok {
+		return errors.New("schema provider not registered for SCIM migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const SamlVersion = "20250615_saml"
+
+func init() {
+	registerMigration(Migration{
+		Version: SamlVersion,
+		Name:    "SAML identities",
+		Up:      samlUp,
+		Down:    samlDown,
+	})
+}
+
+func samlUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(SamlVersion)
+	if !ok {
+		return errors.New("schema provider not registered for SAML migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func samlDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(SamlVersion)
+	if !ok {
+		return errors.New("schema provider not registered for SAML migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
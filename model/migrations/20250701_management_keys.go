@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const ManagementKeyVersion = "20250701_management_keys"
+
+func init() {
+	registerMigration(Migration{
+		Version: ManagementKeyVersion,
+		Name:    "Scoped management API keys",
+		Up:      managementKeysUp,
+		Down:    managementKeysDown,
+	})
+}
+
+func managementKeysUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(ManagementKeyVersion)
+	if !ok {
+		return errors.New("schema provider not registered for management key migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func managementKeysDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(ManagementKeyVersion)
+	if !ok {
+		return errors.New("schema provider not registered for management key migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
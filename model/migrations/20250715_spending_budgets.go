@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const SpendingBudgetVersion = "20250715_spending_budgets"
+
+func init() {
+	registerMigration(Migration{
+		Version: SpendingBudgetVersion,
+		Name:    "Spending budgets over time windows",
+		Up:      spendingBudgetsUp,
+		Down:    spendingBudgetsDown,
+	})
+}
+
+func spendingBudgetsUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(SpendingBudgetVersion)
+	if !ok {
+		return errors.New("schema provider not registered for spending budget migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func spendingBudgetsDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(SpendingBudgetVersion)
+	if !ok {
+		return errors.New("schema provider not registered for spending budget migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
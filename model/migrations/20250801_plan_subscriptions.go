@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const PlanSubscriptionVersion = "20250801_plan_subscriptions"
+
+func init() {
+	registerMigration(Migration{
+		Version: PlanSubscriptionVersion,
+		Name:    "Stripe subscriptions to public plans",
+		Up:      planSubscriptionsUp,
+		Down:    planSubscriptionsDown,
+	})
+}
+
+func planSubscriptionsUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(PlanSubscriptionVersion)
+	if !ok {
+		return errors.New("schema provider not registered for plan subscription migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+// planSubscriptionsDown drops the subscription tables (all but the last
+// schema entry) and the plan price column (last entry).
+func planSubscriptionsDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(PlanSubscriptionVersion)
+	if !ok {
+		return errors.New("schema provider not registered for plan subscription migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	last := tables[len(tables)-1]
+	if tx.Migrator().HasColumn(last, "stripe_price_id") {
+		if err := tx.Migrator().DropColumn(last, "stripe_price_id"); err != nil {
+			return err
+		}
+	}
+	for i := len(tables) - 2; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
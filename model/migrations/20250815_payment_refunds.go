@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const PaymentRefundVersion = "20250815_payment_refunds"
+
+func init() {
+	registerMigration(Migration{
+		Version: PaymentRefundVersion,
+		Name:    "Payment provider refunds",
+		Up:      paymentRefundsUp,
+		Down:    paymentRefundsDown,
+	})
+}
+
+func paymentRefundsUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(PaymentRefundVersion)
+	if !ok {
+		return errors.New("schema provider not registered for payment refund migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+func paymentRefundsDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(PaymentRefundVersion)
+	if !ok {
+		return errors.New("schema provider not registered for payment refund migration")
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const InvoiceVersion = "20250901_invoices"
+
+func init() {
+	registerMigration(Migration{
+		Version: InvoiceVersion,
+		Name:    "Invoices and monthly statements",
+		Up:      invoicesUp,
+		Down:    invoicesDown,
+	})
+}
+
+func invoicesUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(InvoiceVersion)
+	if !ok {
+		return errors.New("schema provider not registered for invoice migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+// invoicesDown drops the invoice tables (all but the last schema entry) and
+// the top-up price column (last entry).
+func invoicesDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(InvoiceVersion)
+	if !ok {
+		return errors.New("schema provider not registered for invoice migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	last := tables[len(tables)-1]
+	if tx.Migrator().HasColumn(last, "pay_money") {
+		if err := tx.Migrator().DropColumn(last, "pay_money"); err != nil {
+			return err
+		}
+	}
+	for i := len(tables) - 2; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const FundingWaterfallVersion = "20250915_funding_waterfall"
+
+func init() {
+	registerMigration(Migration{
+		Version: FundingWaterfallVersion,
+		Name:    "Per-token funding waterfall",
+		Up:      fundingWaterfallUp,
+		Down:    fundingWaterfallDown,
+	})
+}
+
+func fundingWaterfallUp(tx *gorm.DB) error {
+	tables, ok := schemaTables(FundingWaterfallVersion)
+	if !ok {
+		return errors.New("schema provider not registered for funding waterfall migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tx.AutoMigrate(tables...)
+}
+
+// fundingWaterfallDown drops the token override column (only schema entry).
+func fundingWaterfallDown(tx *gorm.DB) error {
+	tables, ok := schemaTables(FundingWaterfallVersion)
+	if !ok {
+		return errors.New("schema provider not registered for funding waterfall migration")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	if tx.Migrator().HasColumn(tables[0], "funding_waterfall") {
+		return tx.Migrator().DropColumn(tables[0], "funding_waterfall")
+	}
+	return nil
+}
@@ -8,15 +8,28 @@ import (
 )
 
 type (
-    testPlan              struct{}
-    testPlanAssignment    struct{}
-    testUsageCounter      struct{}
-    testVoucherBatch      struct{}
-    testVoucherCode       struct{}
-    testVoucherRedemption struct{}
-    testRequestFlag       struct{}
-    testRequestLog        struct{}
-    testRequestAggregate  struct{}
+    testPlan              struct{ Id int }
+    testPlanAssignment    struct{ Id int }
+    testUsageCounter      struct{ Id int }
+    testVoucherBatch      struct{ Id int }
+    testVoucherCode       struct{ Id int }
+    testVoucherRedemption struct{ Id int }
+    testRequestFlag       struct{ Id int }
+    testRequestLog        struct{ Id int }
+    testRequestAggregate  struct{ Id int }
+
+    // plans and plan_assignments as extended by the plan upgrades migration
+    testUpgradedPlan struct {
+        Id            int
+        TokenLimit    int64
+        AllowedModels string
+        ValidityDays  int
+    }
+    testUpgradedPlanAssignment struct {
+        Id                  int
+        ExpiresAt           int64
+        EnforcementMetadata string
+    }
 )
 
 func (testPlan) TableName() string              { return "plans" }
@@ -28,6 +41,9 @@ func (testVoucherRedemption) TableName() string { return "voucher_redemptions" }
 func (testRequestFlag) TableName() string       { return "request_flags" }
 func (testRequestLog) TableName() string        { return "request_logs" }
 func (testRequestAggregate) TableName() string  { return "request_aggregates" }
+func (testUpgradedPlan) TableName() string      { return "plans" }
+
+func (testUpgradedPlanAssignment) TableName() string { return "plan_assignments" }
 
 func TestBillingAndGovernanceMigration(t *testing.T) {
     RegisterSchemaProvider(BillingGovernanceVersion, func() []interface{} {
@@ -44,8 +60,8 @@ func TestBillingAndGovernanceMigration(t *testing.T) {
     })
     RegisterSchemaProvider(PlanUpgradesVersion, func() []interface{} {
         return []interface{}{
-            &testPlan{},
-            &testPlanAssignment{},
+            &testUpgradedPlan{},
+            &testUpgradedPlanAssignment{},
             &testUsageCounter{},
             &testVoucherBatch{},
             &testVoucherCode{},
@@ -55,6 +71,12 @@ func TestBillingAndGovernanceMigration(t *testing.T) {
             &testRequestAggregate{},
         }
     })
+    // Later migrations are not under test here; give them empty schemas.
+    for _, m := range sortedMigrations() {
+        if _, ok := schemaTables(m.Version); !ok {
+            RegisterSchemaProvider(m.Version, func() []interface{} { return nil })
+        }
+    }
     db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
     if err != nil {
         t.Fatalf("failed to open sqlite: %v", err)
@@ -81,6 +103,23 @@ func TestBillingAndGovernanceMigration(t *testing.T) {
     if err := Down(db, PlanUpgradesVersion); err != nil {
         t.Fatalf("failed to rollback plan upgrades migration: %v", err)
     }
+    if db.Migrator().HasTable("voucher_codes") {
+        t.Fatal("expected voucher_codes to be dropped by the plan upgrades rollback")
+    }
+    for table, columns := range map[string][]string{
+        "plans":            {"token_limit", "allowed_models", "validity_days"},
+        "plan_assignments": {"expires_at", "enforcement_metadata"},
+    } {
+        for _, column := range columns {
+            if db.Migrator().HasColumn(table, column) {
+                t.Fatalf("expected column %s.%s to be dropped", table, column)
+            }
+        }
+    }
+    // rolling back again skips the columns that are already gone
+    if err := planUpgradesDown(db); err != nil {
+        t.Fatalf("repeated plan upgrades rollback: %v", err)
+    }
     if err := Down(db, BillingGovernanceVersion); err != nil {
         t.Fatalf("failed to rollback billing migration: %v", err)
     }
@@ -186,6 +186,13 @@ func UpdateOption(key string, value string) error {
     return updateOptionMap(key, value)
 }
 
+// GetOptionValue returns the in-memory value of an option, or "" when unset.
+func GetOptionValue(key string) string {
+    common.OptionMapRWMutex.RLock()
+    defer common.OptionMapRWMutex.RUnlock()
+    return common.OptionMap[key]
+}
+
 func updateOptionMap(key string, value string) (err error) {
     common.OptionMapRWMutex.Lock()
     defer common.OptionMapRWMutex.Unlock()
@@ -0,0 +1,440 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+const (
+	OrganizationStatusEnabled  = 1
+	OrganizationStatusDisabled = 2
+)
+
+// Organization roles, from most to least privileged.
+const (
+	OrganizationRoleOwner     = "owner"
+	OrganizationRoleAdmin     = "admin"
+	OrganizationRoleDeveloper = "developer"
+	OrganizationRoleBilling   = "billing"
+	OrganizationRoleViewer    = "viewer"
+)
+
+// Organization permissions granted by roles.
+const (
+	// rename, disable or delete the organization
+	OrganizationPermissionManage = "manage"
+	// invite, remove and change the role of members
+	OrganizationPermissionMembers = "members"
+	// create organization tokens; developers manage only their own
+	OrganizationPermissionTokens = "tokens"
+	// fund the quota pool and set member spending caps
+	OrganizationPermissionBilling = "billing"
+	// see usage and logs of every member
+	OrganizationPermissionUsage = "usage"
+)
+
+var organizationRolePermissions = map[string][]string{
+	OrganizationRoleOwner:     {OrganizationPermissionManage, OrganizationPermissionMembers, OrganizationPermissionTokens, OrganizationPermissionBilling, OrganizationPermissionUsage},
+	OrganizationRoleAdmin:     {OrganizationPermissionMembers, OrganizationPermissionTokens, OrganizationPermissionUsage},
+	OrganizationRoleDeveloper: {OrganizationPermissionTokens},
+	OrganizationRoleBilling:   {OrganizationPermissionBilling, OrganizationPermissionUsage},
+	OrganizationRoleViewer:    {OrganizationPermissionUsage},
+}
+
+const (
+	OrganizationInvitationPending  = 1
+	OrganizationInvitationAccepted = 2
+	OrganizationInvitationRevoked  = 3
+)
+
+// Organization owns a shared quota pool, members and tokens. Requests made
+// with an organization token are charged to the pool instead of the user.
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);index"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	OwnerId     int    `json:"owner_id" gorm:"index"`
+	Status      int    `json:"status" gorm:"default:1"`
+	Quota       int    `json:"quota" gorm:"default:0"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// OrganizationMember links a user to an organization. SpendingCap limits
+// what the member may spend from the pool through organization tokens; 0
+// means no cap.
+type OrganizationMember struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"uniqueIndex:idx_organization_member,priority:1"`
+	UserId         int    `json:"user_id" gorm:"uniqueIndex:idx_organization_member,priority:2;index"`
+	Role           string `json:"role" gorm:"type:varchar(16)"`
+	SpendingCap    int    `json:"spending_cap" gorm:"default:0"`
+	UsedQuota      int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+	Username       string `json:"username" gorm:"->;-:migration"`
+}
+
+// OrganizationInvitation is accepted by the user holding its code. When
+// Email is set only the user with that email can accept it.
+type OrganizationInvitation struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"index"`
+	Code           string `json:"code" gorm:"type:char(32);uniqueIndex"`
+	Email          string `json:"email" gorm:"type:varchar(128);default:''"`
+	Role           string `json:"role" gorm:"type:varchar(16)"`
+	InvitedBy      int    `json:"invited_by"`
+	Status         int    `json:"status" gorm:"default:1"`
+	ExpiresAt      int64  `json:"expires_at" gorm:"bigint"`
+	AcceptedBy     int    `json:"accepted_by" gorm:"default:0"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+}
+
+// UserOrganization is an organization with the role of the current user.
+type UserOrganization struct {
+	Organization
+	Role string `json:"role"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.OrganizationsVersion, func() []interface{} {
+		return []interface{}{
+			&Organization{},
+			&OrganizationMember{},
+			&OrganizationInvitation{},
+			&Token{},
+		}
+	})
+}
+
+func IsValidOrganizationRole(role string) bool {
+	_, ok := organizationRolePermissions[role]
+	return ok
+}
+
+// OrganizationRoleCan reports whether role grants permission.
+func OrganizationRoleCan(role string, permission string) bool {
+	for _, p := range organizationRolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (org *Organization) Validate() error {
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" || len([]rune(org.Name)) > 64 {
+		return errors.New("组织名称不能为空且不能超过 64 个字符")
+	}
+	if len([]rune(org.Description)) > 255 {
+		return errors.New("组织描述不能超过 255 个字符")
+	}
+	return nil
+}
+
+// Insert creates the organization with ownerId as its owner.
+func (org *Organization) Insert(ownerId int) error {
+	now := common.GetTimestamp()
+	org.OwnerId = ownerId
+	org.Status = OrganizationStatusEnabled
+	org.Quota = 0
+	org.UsedQuota = 0
+	org.CreatedTime = now
+	org.UpdatedTime = now
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrganizationId: org.Id,
+			UserId:         ownerId,
+			Role:           OrganizationRoleOwner,
+			CreatedTime:    now,
+		}).Error
+	})
+}
+
+func (org *Organization) Update() error {
+	org.UpdatedTime = common.GetTimestamp()
+	return DB.Model(org).Select("name", "description", "status", "updated_time").Updates(org).Error
+}
+
+// Delete removes an organization with an empty pool, its members and
+// invitations, and disables its tokens.
+func (org *Organization) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND quota <= 0", org.Id).Delete(&Organization{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("组织额度池不为空，请先转出额度")
+		}
+		if err := tx.Where("organization_id = ?", org.Id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", org.Id).Delete(&OrganizationInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Token{}).Where("organization_id = ?", org.Id).
+			Update("status", common.TokenStatusDisabled).Error
+	})
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	org := &Organization{}
+	err := DB.First(org, "id = ?", id).Error
+	return org, err
+}
+
+func GetAllOrganizations(startIdx int, num int) (orgs []*Organization, total int64, err error) {
+	if err = DB.Model(&Organization{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, total, err
+}
+
+func GetUserOrganizations(userId int) ([]*UserOrganization, error) {
+	var orgs []*UserOrganization
+	err := DB.Table("organizations").
+		Select("organizations.*, organization_members.role").
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userId).
+		Order("organizations.id asc").
+		Scan(&orgs).Error
+	return orgs, err
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	member := &OrganizationMember{}
+	err := DB.First(member, "organization_id = ? AND user_id = ?", orgId, userId).Error
+	return member, err
+}
+
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Table("organization_members").
+		Select("organization_members.*, users.username").
+		Joins("LEFT JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", orgId).
+		Order("organization_members.id asc").
+		Scan(&members).Error
+	return members, err
+}
+
+func (member *OrganizationMember) Update() error {
+	return DB.Model(member).Select("role", "spending_cap", "used_quota").Updates(member).Error
+}
+
+// Delete removes the member and disables the organization tokens they
+// created. The last owner cannot be removed.
+func (member *OrganizationMember) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if member.Role == OrganizationRoleOwner {
+			var owners int64
+			if err := tx.Model(&OrganizationMember{}).
+				Where("organization_id = ? AND role = ?", member.OrganizationId, OrganizationRoleOwner).
+				Count(&owners).Error; err != nil {
+				return err
+			}
+			if owners <= 1 {
+				return errors.New("不能移除组织的最后一个所有者")
+			}
+		}
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Token{}).
+			Where("organization_id = ? AND user_id = ?", member.OrganizationId, member.UserId).
+			Update("status", common.TokenStatusDisabled).Error
+	})
+}
+
+// CountOrganizationOwners counts the owners of an organization.
+func CountOrganizationOwners(orgId int) (int64, error) {
+	var owners int64
+	err := DB.Model(&OrganizationMember{}).
+		Where("organization_id = ? AND role = ?", orgId, OrganizationRoleOwner).
+		Count(&owners).Error
+	return owners, err
+}
+
+// GetOrganizationTokens lists the tokens of an organization; a non-zero
+// userId narrows them to one member.
+func GetOrganizationTokens(orgId int, userId int, startIdx int, num int) (tokens []*Token, total int64, err error) {
+	tx := DB.Model(&Token{}).Where("organization_id = ?", orgId)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&tokens).Error
+	return tokens, total, err
+}
+
+func (invitation *OrganizationInvitation) Insert() error {
+	invitation.Code = common.GetUUID()
+	invitation.Status = OrganizationInvitationPending
+	invitation.CreatedTime = common.GetTimestamp()
+	return DB.Create(invitation).Error
+}
+
+func GetOrganizationInvitations(orgId int) ([]*OrganizationInvitation, error) {
+	var invitations []*OrganizationInvitation
+	err := DB.Where("organization_id = ?", orgId).Order("id desc").Find(&invitations).Error
+	return invitations, err
+}
+
+func RevokeOrganizationInvitation(orgId int, id int) error {
+	result := DB.Model(&OrganizationInvitation{}).
+		Where("id = ? AND organization_id = ? AND status = ?", id, orgId, OrganizationInvitationPending).
+		Update("status", OrganizationInvitationRevoked)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请不存在或已失效")
+	}
+	return nil
+}
+
+// AcceptOrganizationInvitation makes the user a member with the invited role.
+func AcceptOrganizationInvitation(code string, userId int, email string) (*OrganizationMember, error) {
+	var member *OrganizationMember
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		invitation := &OrganizationInvitation{}
+		if err := tx.First(invitation, "code = ? AND status = ?", code, OrganizationInvitationPending).Error; err != nil {
+			return errors.New("邀请不存在或已失效")
+		}
+		if invitation.ExpiresAt > 0 && invitation.ExpiresAt < common.GetTimestamp() {
+			return errors.New("邀请已过期")
+		}
+		if invitation.Email != "" && !strings.EqualFold(invitation.Email, email) {
+			return errors.New("该邀请不属于当前用户")
+		}
+		var existing int64
+		if err := tx.Model(&OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ?", invitation.OrganizationId, userId).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errors.New("已经是该组织的成员")
+		}
+		member = &OrganizationMember{
+			OrganizationId: invitation.OrganizationId,
+			UserId:         userId,
+			Role:           invitation.Role,
+			CreatedTime:    common.GetTimestamp(),
+		}
+		if err := tx.Create(member).Error; err != nil {
+			return err
+		}
+		return tx.Model(invitation).Updates(map[string]any{
+			"status":      OrganizationInvitationAccepted,
+			"accepted_by": userId,
+		}).Error
+	})
+	return member, err
+}
+
+// TransferOrganizationQuota moves quota between a user and the pool: a
+// positive amount funds the pool from the user, a negative one withdraws.
+func TransferOrganizationQuota(orgId int, userId int, amount int) error {
+	if amount == 0 {
+		return errors.New("额度不能为 0")
+	}
+	users := func(tx *gorm.DB) *gorm.DB { return tx.Model(&User{}).Where("id = ?", userId) }
+	orgs := func(tx *gorm.DB) *gorm.DB { return tx.Model(&Organization{}).Where("id = ?", orgId) }
+	from, to, quota := users, orgs, amount
+	if amount < 0 {
+		from, to, quota = orgs, users, -amount
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := from(tx).Where("quota >= ?", quota).Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("额度不足")
+		}
+		return to(tx).Update("quota", gorm.Expr("quota + ?", quota)).Error
+	})
+	if err != nil {
+		return err
+	}
+	gopool.Go(func() {
+		if err := cacheDecrUserQuota(userId, int64(amount)); err != nil {
+			common.SysLog("failed to update user quota cache: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// AdjustOrganizationQuota changes the pool by delta, for administrators.
+func AdjustOrganizationQuota(orgId int, delta int) error {
+	return DB.Model(&Organization{}).Where("id = ?", orgId).
+		Update("quota", gorm.Expr("quota + ?", delta)).Error
+}
+
+// GetOrganizationQuota returns the pool of an organization.
+func GetOrganizationQuota(orgId int) (quota int, err error) {
+	err = DB.Model(&Organization{}).Where("id = ?", orgId).Select("quota").Find(&quota).Error
+	return quota, err
+}
+
+// DecreaseOrganizationQuota charges the pool for a member's request; a
+// negative quota refunds it.
+func DecreaseOrganizationQuota(orgId int, userId int, quota int) error {
+	if quota == 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Organization{}).Where("id = ?", orgId).Updates(map[string]any{
+			"quota":      gorm.Expr("quota - ?", quota),
+			"used_quota": gorm.Expr("used_quota + ?", quota),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ?", orgId, userId).
+			Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+	})
+}
+
+// ChargeOrganizationQuotaTx charges the pool inside tx, failing when the
+// pool or the member's spending cap cannot cover quota.
+func ChargeOrganizationQuotaTx(tx *gorm.DB, orgId int, userId int, quota int) error {
+	result := tx.Model(&Organization{}).Where("id = ? AND quota >= ?", orgId, quota).Updates(map[string]any{
+		"quota":      gorm.Expr("quota - ?", quota),
+		"used_quota": gorm.Expr("used_quota + ?", quota),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("组织额度不足")
+	}
+	result = tx.Model(&OrganizationMember{}).
+		Where("organization_id = ? AND user_id = ? AND (spending_cap = 0 OR used_quota + ? <= spending_cap)", orgId, userId, quota).
+		Update("used_quota", gorm.Expr("used_quota + ?", quota))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("已超出组织成员消费上限")
+	}
+	return nil
+}
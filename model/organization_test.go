@@ -0,0 +1,260 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupOrganizationTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	tables := []interface{}{&User{}, &Token{}, &Organization{}, &OrganizationMember{}, &OrganizationInvitation{}}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	for _, table := range tables {
+		db.Unscoped().Where("1 = 1").Delete(table)
+	}
+	oldDB := DB
+	DB = db
+	// Quota cache updates run on a pool after the test returns; keep Redis off.
+	common.RedisEnabled = false
+	t.Cleanup(func() { DB = oldDB })
+	return db
+}
+
+func createOrganizationTestUser(t *testing.T, db *gorm.DB, name string, quota int) *User {
+	t.Helper()
+	user := &User{Username: name, Email: name + "@example.com", Quota: quota, Status: common.UserStatusEnabled, AffCode: name}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func TestOrganizationRoleCan(t *testing.T) {
+	cases := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{OrganizationRoleOwner, OrganizationPermissionManage, true},
+		{OrganizationRoleAdmin, OrganizationPermissionManage, false},
+		{OrganizationRoleAdmin, OrganizationPermissionMembers, true},
+		{OrganizationRoleAdmin, OrganizationPermissionBilling, false},
+		{OrganizationRoleDeveloper, OrganizationPermissionTokens, true},
+		{OrganizationRoleDeveloper, OrganizationPermissionUsage, false},
+		{OrganizationRoleBilling, OrganizationPermissionBilling, true},
+		{OrganizationRoleBilling, OrganizationPermissionTokens, false},
+		{OrganizationRoleViewer, OrganizationPermissionUsage, true},
+		{OrganizationRoleViewer, OrganizationPermissionTokens, false},
+		{"guest", OrganizationPermissionUsage, false},
+	}
+	for _, tc := range cases {
+		if got := OrganizationRoleCan(tc.role, tc.permission); got != tc.want {
+			t.Errorf("OrganizationRoleCan(%q, %q) = %v, want %v", tc.role, tc.permission, got, tc.want)
+		}
+	}
+	if IsValidOrganizationRole("guest") || !IsValidOrganizationRole(OrganizationRoleBilling) {
+		t.Error("unexpected role validity")
+	}
+}
+
+func TestOrganizationMembership(t *testing.T) {
+	db := setupOrganizationTestDB(t)
+	owner := createOrganizationTestUser(t, db, "owner", 0)
+	dev := createOrganizationTestUser(t, db, "dev", 0)
+	org := &Organization{Name: "Acme"}
+	if err := org.Insert(owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	ownerMember, err := GetOrganizationMember(org.Id, owner.Id)
+	if err != nil || ownerMember.Role != OrganizationRoleOwner {
+		t.Fatalf("creator membership = %+v, %v", ownerMember, err)
+	}
+
+	addressed := &OrganizationInvitation{OrganizationId: org.Id, Email: "someone@example.com", Role: OrganizationRoleAdmin, InvitedBy: owner.Id}
+	expired := &OrganizationInvitation{OrganizationId: org.Id, Role: OrganizationRoleAdmin, InvitedBy: owner.Id, ExpiresAt: common.GetTimestamp() - 60}
+	open := &OrganizationInvitation{OrganizationId: org.Id, Email: "DEV@example.com", Role: OrganizationRoleDeveloper, InvitedBy: owner.Id}
+	for _, invitation := range []*OrganizationInvitation{addressed, expired, open} {
+		if err := invitation.Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := AcceptOrganizationInvitation(addressed.Code, dev.Id, dev.Email); err == nil {
+		t.Error("accepted an invitation addressed to another email")
+	}
+	if _, err := AcceptOrganizationInvitation(expired.Code, dev.Id, dev.Email); err == nil {
+		t.Error("accepted an expired invitation")
+	}
+	member, err := AcceptOrganizationInvitation(open.Code, dev.Id, dev.Email)
+	if err != nil || member.Role != OrganizationRoleDeveloper {
+		t.Fatalf("accept = %+v, %v", member, err)
+	}
+	if _, err := AcceptOrganizationInvitation(open.Code, dev.Id, dev.Email); err == nil {
+		t.Error("accepted an invitation twice")
+	}
+	if err := RevokeOrganizationInvitation(org.Id, open.Id); err == nil {
+		t.Error("revoked an accepted invitation")
+	}
+	if err := RevokeOrganizationInvitation(org.Id, addressed.Id); err != nil {
+		t.Errorf("revoke pending invitation: %v", err)
+	}
+
+	orgs, err := GetUserOrganizations(dev.Id)
+	if err != nil || len(orgs) != 1 || orgs[0].Role != OrganizationRoleDeveloper || orgs[0].Name != "Acme" {
+		t.Fatalf("user organizations = %+v, %v", orgs, err)
+	}
+	members, err := GetOrganizationMembers(org.Id)
+	if err != nil || len(members) != 2 || members[1].Username != "dev" {
+		t.Fatalf("members = %+v, %v", members, err)
+	}
+
+	if err := ownerMember.Delete(); err == nil {
+		t.Error("removed the last owner")
+	}
+	// removing a member disables their organization tokens but not their own
+	orgToken := &Token{UserId: dev.Id, OrganizationId: org.Id, Key: "org-dev", Status: common.TokenStatusEnabled}
+	personalToken := &Token{UserId: dev.Id, Key: "personal-dev", Status: common.TokenStatusEnabled}
+	for _, token := range []*Token{orgToken, personalToken} {
+		if err := db.Create(token).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := member.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	var statuses []int
+	db.Model(&Token{}).Order("id").Pluck("status", &statuses)
+	if len(statuses) != 2 || statuses[0] != common.TokenStatusDisabled || statuses[1] != common.TokenStatusEnabled {
+		t.Errorf("token statuses = %v", statuses)
+	}
+}
+
+func TestOrganizationQuotaPool(t *testing.T) {
+	db := setupOrganizationTestDB(t)
+	owner := createOrganizationTestUser(t, db, "owner", 100)
+	org := &Organization{Name: "Acme"}
+	if err := org.Insert(owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	orgToken := &Token{UserId: owner.Id, OrganizationId: org.Id, Key: "org-owner", Status: common.TokenStatusEnabled}
+	if err := db.Create(orgToken).Error; err != nil {
+		t.Fatal(err)
+	}
+	balances := func() (int, int) {
+		t.Helper()
+		userQuota, err := GetUserQuota(owner.Id, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool, err := GetOrganizationQuota(org.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return userQuota, pool
+	}
+
+	if err := TransferOrganizationQuota(org.Id, owner.Id, 150); err == nil {
+		t.Error("funded more than the user holds")
+	}
+	if err := TransferOrganizationQuota(org.Id, owner.Id, 60); err != nil {
+		t.Fatal(err)
+	}
+	if user, pool := balances(); user != 40 || pool != 60 {
+		t.Fatalf("after funding: user %d pool %d, want 40/60", user, pool)
+	}
+	if err := TransferOrganizationQuota(org.Id, owner.Id, -70); err == nil {
+		t.Error("withdrew more than the pool holds")
+	}
+	if err := TransferOrganizationQuota(org.Id, owner.Id, -20); err != nil {
+		t.Fatal(err)
+	}
+	if user, pool := balances(); user != 60 || pool != 40 {
+		t.Fatalf("after withdrawal: user %d pool %d, want 60/40", user, pool)
+	}
+
+	if err := org.Delete(); err == nil {
+		t.Error("deleted an organization with quota left in its pool")
+	}
+	if err := TransferOrganizationQuota(org.Id, owner.Id, -40); err != nil {
+		t.Fatal(err)
+	}
+	if err := org.Delete(); err != nil {
+		t.Fatalf("delete empty organization: %v", err)
+	}
+	var token Token
+	db.First(&token, orgToken.Id)
+	if token.Status != common.TokenStatusDisabled {
+		t.Errorf("organization token status = %d, want disabled", token.Status)
+	}
+	var members int64
+	db.Model(&OrganizationMember{}).Where("organization_id = ?", org.Id).Count(&members)
+	if members != 0 {
+		t.Errorf("%d members left after delete", members)
+	}
+}
+
+func TestChargeOrganizationQuotaTx(t *testing.T) {
+	db := setupOrganizationTestDB(t)
+	owner := createOrganizationTestUser(t, db, "owner", 0)
+	org := &Organization{Name: "Acme"}
+	if err := org.Insert(owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := AdjustOrganizationQuota(org.Id, 100); err != nil {
+		t.Fatal(err)
+	}
+	member, _ := GetOrganizationMember(org.Id, owner.Id)
+	member.SpendingCap = 50
+	if err := member.Update(); err != nil {
+		t.Fatal(err)
+	}
+	charge := func(quota int) error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			return ChargeOrganizationQuotaTx(tx, org.Id, owner.Id, quota)
+		})
+	}
+
+	if err := charge(30); err != nil {
+		t.Fatal(err)
+	}
+	// the cap refuses the charge and the transaction leaves the pool alone
+	if err := charge(30); err == nil {
+		t.Fatal("charged past the member's spending cap")
+	}
+	reloaded, _ := GetOrganizationById(org.Id)
+	member, _ = GetOrganizationMember(org.Id, owner.Id)
+	if reloaded.Quota != 70 || reloaded.UsedQuota != 30 || member.UsedQuota != 30 {
+		t.Fatalf("pool %d used %d member used %d, want 70/30/30", reloaded.Quota, reloaded.UsedQuota, member.UsedQuota)
+	}
+
+	member.SpendingCap = 0
+	if err := member.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := charge(80); err == nil {
+		t.Fatal("charged past the pool")
+	}
+	if err := charge(70); err != nil {
+		t.Fatalf("uncapped member: %v", err)
+	}
+
+	// refunds through DecreaseOrganizationQuota return to the pool and member
+	if err := DecreaseOrganizationQuota(org.Id, owner.Id, -20); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, _ = GetOrganizationById(org.Id)
+	member, _ = GetOrganizationMember(org.Id, owner.Id)
+	if reloaded.Quota != 20 || reloaded.UsedQuota != 80 || member.UsedQuota != 80 {
+		t.Errorf("pool %d used %d member used %d, want 20/80/80", reloaded.Quota, reloaded.UsedQuota, member.UsedQuota)
+	}
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// PaymentRefund records a refund of a top-up order and the quota it took
+// back from the user. Money is the part of the price paid that was refunded.
+type PaymentRefund struct {
+	Id               int     `json:"id"`
+	TopUpId          int     `json:"top_up_id" gorm:"index"`
+	TradeNo          string  `json:"trade_no" gorm:"type:varchar(255);index"`
+	UserId           int     `json:"user_id" gorm:"index"`
+	Money            float64 `json:"money"`
+	Quota            int64   `json:"quota" gorm:"bigint"`
+	Reason           string  `json:"reason" gorm:"type:varchar(255)"`
+	ProviderRefundId string  `json:"provider_refund_id" gorm:"type:varchar(255)"`
+	OperatorId       int     `json:"operator_id"`
+	CreatedTime      int64   `json:"created_time" gorm:"bigint"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.PaymentRefundVersion, func() []interface{} {
+		return []interface{}{
+			&PaymentRefund{},
+		}
+	})
+}
+
+// RefundQuota returns the quota refunding money, in money paid, takes back:
+// its share of the price paid applied to the credited quota, or all that is
+// left on a full refund.
+func (topUp *TopUp) RefundQuota(money float64) int64 {
+	remaining := topUp.CreditQuota() - topUp.RefundedQuota
+	paid := topUp.PaidMoney()
+	if paid <= 0 || money >= topUp.RefundableMoney() {
+		return remaining
+	}
+	quota := decimal.NewFromInt(topUp.CreditQuota()).Mul(decimal.NewFromFloat(money)).Div(decimal.NewFromFloat(paid)).IntPart()
+	if quota > remaining {
+		quota = remaining
+	}
+	return quota
+}
+
+// ApplyTopUpRefund records a refund the provider has made: the order moves
+// to partially or fully refunded and the quota is taken back from the user,
+// whose balance may go negative if it was already spent. paidMoney is the
+// price paid as the provider reports it, stored on orders that lack it; 0
+// leaves the order as it is.
+func ApplyTopUpRefund(tradeNo string, money float64, paidMoney float64, providerRefundId string, operatorId int, reason string) (*PaymentRefund, error) {
+	var refund *PaymentRefund
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		topUp, err := lockTopUpTx(tx, tradeNo)
+		if err != nil {
+			return err
+		}
+		if !topUp.PaidMoneyKnown() {
+			if paidMoney <= 0 {
+				return errors.New("订单未记录支付金额")
+			}
+			topUp.PayMoney = paidMoney
+		}
+		refundable := topUp.RefundableMoney()
+		if money <= 0 || money > refundable {
+			return errors.New("退款金额无效")
+		}
+		quota := topUp.RefundQuota(money)
+		status := common.TopUpStatusPartialRefunded
+		if money >= refundable {
+			status = common.TopUpStatusRefunded
+		}
+		if err := topUp.transition(status); err != nil {
+			return err
+		}
+		if topUp.Quota == 0 {
+			topUp.Quota = topUp.CreditQuota()
+		}
+		topUp.RefundedMoney = decimal.NewFromFloat(topUp.RefundedMoney).Add(decimal.NewFromFloat(money)).InexactFloat64()
+		topUp.RefundedQuota += quota
+		if err := tx.Save(topUp).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota - ?", quota)).Error; err != nil {
+			return err
+		}
+		refund = &PaymentRefund{
+			TopUpId:          topUp.Id,
+			TradeNo:          topUp.TradeNo,
+			UserId:           topUp.UserId,
+			Money:            money,
+			Quota:            quota,
+			Reason:           reason,
+			ProviderRefundId: providerRefundId,
+			OperatorId:       operatorId,
+			CreatedTime:      common.GetTimestamp(),
+		}
+		return tx.Create(refund).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+func GetTopUpRefunds(tradeNo string) ([]*PaymentRefund, error) {
+	var refunds []*PaymentRefund
+	err := DB.Where("trade_no = ?", tradeNo).Order("id asc").Find(&refunds).Error
+	return refunds, err
+}
@@ -33,6 +33,8 @@ type Plan struct {
     IsActive                 bool           `json:"is_active" gorm:"not null;default:true"`
     IsPublic                 bool           `json:"is_public" gorm:"not null;default:false"`
     IsSystem                 bool           `json:"is_system" gorm:"not null;default:false"`
+    // StripePriceId is the recurring Stripe price users subscribe to
+    StripePriceId            string         `json:"stripe_price_id" gorm:"size:128;index"`
     CreatedAt                time.Time      `json:"created_at"`
     UpdatedAt                time.Time      `json:"updated_at"`
     DeletedAt                gorm.DeletedAt `json:"-" gorm:"index"`
@@ -60,6 +62,16 @@ func GetPlanById(id int) (*Plan, error) {
     return &plan, nil
 }
 
+// GetPlanByStripePriceId returns the plan sold through a Stripe price.
+func GetPlanByStripePriceId(priceId string) (*Plan, error) {
+    var plan Plan
+    err := DB.Where("stripe_price_id = ? AND deleted_at IS NULL", priceId).First(&plan).Error
+    if err != nil {
+        return nil, err
+    }
+    return &plan, nil
+}
+
 func (plan *Plan) GetAllowedModels() []string {
     if len(plan.AllowedModels) == 0 {
         return nil
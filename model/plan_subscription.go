@@ -0,0 +1,87 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Stripe subscription statuses the subscription keeps its plan in.
+const (
+	PlanSubscriptionStatusIncomplete = "incomplete"
+	PlanSubscriptionStatusActive     = "active"
+	PlanSubscriptionStatusTrialing   = "trialing"
+	PlanSubscriptionStatusPastDue    = "past_due"
+	PlanSubscriptionStatusCanceled   = "canceled"
+)
+
+// PlanSubscription is a user's Stripe subscription to a public plan. While
+// it is paid, AssignmentId points to the plan assignment it keeps alive.
+type PlanSubscription struct {
+	Id                   int    `json:"id"`
+	UserId               int    `json:"user_id" gorm:"index"`
+	PlanId               int    `json:"plan_id" gorm:"index"`
+	AssignmentId         int    `json:"assignment_id" gorm:"default:0"`
+	StripeSubscriptionId string `json:"stripe_subscription_id" gorm:"type:varchar(128);uniqueIndex"`
+	StripeCustomerId     string `json:"stripe_customer_id" gorm:"type:varchar(64)"`
+	// Status mirrors the Stripe subscription status.
+	Status            string `json:"status" gorm:"type:varchar(32);index"`
+	CurrentPeriodEnd  int64  `json:"current_period_end" gorm:"bigint"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end" gorm:"default:false"`
+	CreatedTime       int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime       int64  `json:"updated_time" gorm:"bigint"`
+}
+
+func init() {
+	// Plan is last so the down migration drops only its price column.
+	migrations.RegisterSchemaProvider(migrations.PlanSubscriptionVersion, func() []interface{} {
+		return []interface{}{
+			&PlanSubscription{},
+			&Plan{},
+		}
+	})
+}
+
+// IsLive reports whether the subscription still holds or may regain its
+// plan, so the user should change it rather than start another.
+func (sub *PlanSubscription) IsLive() bool {
+	switch sub.Status {
+	case PlanSubscriptionStatusIncomplete, PlanSubscriptionStatusActive, PlanSubscriptionStatusTrialing, PlanSubscriptionStatusPastDue:
+		return true
+	}
+	return false
+}
+
+func GetUserPlanSubscriptions(userId int) ([]*PlanSubscription, error) {
+	var subs []*PlanSubscription
+	err := DB.Where("user_id = ?", userId).Order("id desc").Find(&subs).Error
+	return subs, err
+}
+
+func GetUserPlanSubscriptionById(id int, userId int) (*PlanSubscription, error) {
+	sub := &PlanSubscription{}
+	err := DB.First(sub, "id = ? AND user_id = ?", id, userId).Error
+	return sub, err
+}
+
+// GetPlanSubscriptionByStripeIdTx locks the subscription for update. It
+// returns nil when none exists.
+func GetPlanSubscriptionByStripeIdTx(tx *gorm.DB, stripeSubscriptionId string) (*PlanSubscription, error) {
+	var subs []*PlanSubscription
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("stripe_subscription_id = ?", stripeSubscriptionId).Limit(1).Find(&subs).Error
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return subs[0], nil
+}
+
+func (sub *PlanSubscription) SaveTx(tx *gorm.DB) error {
+	now := common.GetTimestamp()
+	if sub.CreatedTime == 0 {
+		sub.CreatedTime = now
+	}
+	sub.UpdatedTime = now
+	return tx.Save(sub).Error
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
 
 	"gorm.io/gorm"
 )
@@ -18,10 +19,17 @@ type RequestFlag struct {
 	Reason             string     `json:"reason" gorm:"size:16;not null"`
 	ReroutedModelAlias string     `json:"rerouted_model_alias" gorm:"size:128"`
 	TtlAt              *time.Time `json:"ttl_at" gorm:"index"`
+	Details            string     `json:"details" gorm:"type:text"`
 	CreatedAt          time.Time  `json:"created_at"`
 	UpdatedAt          time.Time  `json:"updated_at"`
 }
 
+func init() {
+	migrations.RegisterSchemaProvider(migrations.PIIRedactionVersion, func() []interface{} {
+		return []interface{}{&RequestFlag{}}
+	})
+}
+
 func (flag *RequestFlag) BeforeCreate(tx *gorm.DB) error {
 	if flag.SubjectType == "" {
 		flag.SubjectType = common.AssignmentSubjectTypeUser
@@ -31,3 +39,7 @@ func (flag *RequestFlag) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+func CreateRequestFlag(flag *RequestFlag) error {
+	return DB.Create(flag).Error
+}
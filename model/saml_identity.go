@@ -0,0 +1,72 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"gorm.io/gorm"
+)
+
+// SamlIdentity binds a user to the NameID an identity provider asserts for
+// them. A user has at most one SAML identity.
+type SamlIdentity struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"uniqueIndex"`
+	Issuer        string `json:"issuer" gorm:"type:varchar(255);uniqueIndex:idx_saml_identity,priority:1"`
+	NameId        string `json:"name_id" gorm:"type:varchar(255);uniqueIndex:idx_saml_identity,priority:2"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+	LastLoginTime int64  `json:"last_login_time" gorm:"bigint"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.SamlVersion, func() []interface{} {
+		return []interface{}{
+			&SamlIdentity{},
+		}
+	})
+}
+
+// GetSamlIdentity returns the identity issued by issuer for nameId, or
+// gorm.ErrRecordNotFound.
+func GetSamlIdentity(issuer string, nameId string) (*SamlIdentity, error) {
+	identity := &SamlIdentity{}
+	err := DB.First(identity, "issuer = ? AND name_id = ?", issuer, nameId).Error
+	return identity, err
+}
+
+func IsUserSamlBound(userId int) (bool, error) {
+	var count int64
+	err := DB.Model(&SamlIdentity{}).Where("user_id = ?", userId).Count(&count).Error
+	return count > 0, err
+}
+
+// BindSamlIdentity binds the identity to a user.
+func BindSamlIdentity(userId int, issuer string, nameId string) error {
+	if _, err := GetSamlIdentity(issuer, nameId); err == nil {
+		return errors.New("该 SAML 账户已被绑定")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	bound, err := IsUserSamlBound(userId)
+	if err != nil {
+		return err
+	}
+	if bound {
+		return errors.New("该用户已绑定 SAML 账户")
+	}
+	now := common.GetTimestamp()
+	return DB.Create(&SamlIdentity{
+		UserId:        userId,
+		Issuer:        issuer,
+		NameId:        nameId,
+		CreatedTime:   now,
+		LastLoginTime: now,
+	}).Error
+}
+
+func (identity *SamlIdentity) TouchLogin() error {
+	identity.LastLoginTime = common.GetTimestamp()
+	return DB.Model(identity).Update("last_login_time", identity.LastLoginTime).Error
+}
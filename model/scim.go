@@ -0,0 +1,356 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ScimUser holds the SCIM attributes of a user that have no column on
+// users. A row exists once the user has been touched by SCIM provisioning.
+type ScimUser struct {
+	UserId      int    `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	ExternalId  string `json:"external_id" gorm:"type:varchar(255);index"`
+	GivenName   string `json:"given_name" gorm:"type:varchar(128);default:''"`
+	FamilyName  string `json:"family_name" gorm:"type:varchar(128);default:''"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// ScimGroup is a group pushed by the identity provider. Its members are
+// mapped to gateway groups and organizations by the scim group mappings.
+type ScimGroup struct {
+	Id          int    `json:"id"`
+	DisplayName string `json:"display_name" gorm:"type:varchar(255);uniqueIndex"`
+	ExternalId  string `json:"external_id" gorm:"type:varchar(255);index"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+type ScimGroupMember struct {
+	Id       int    `json:"id"`
+	GroupId  int    `json:"group_id" gorm:"uniqueIndex:idx_scim_group_member,priority:1"`
+	UserId   int    `json:"user_id" gorm:"uniqueIndex:idx_scim_group_member,priority:2;index"`
+	Username string `json:"username" gorm:"->;-:migration"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.ScimVersion, func() []interface{} {
+		return []interface{}{
+			&ScimUser{},
+			&ScimGroup{},
+			&ScimGroupMember{},
+		}
+	})
+}
+
+// GetScimUser returns the SCIM attributes of a user, empty if there are none.
+func GetScimUser(userId int) (*ScimUser, error) {
+	scimUser := &ScimUser{}
+	err := DB.First(scimUser, "user_id = ?", userId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &ScimUser{UserId: userId}, nil
+	}
+	return scimUser, err
+}
+
+func GetScimUsersByIds(userIds []int) (map[int]*ScimUser, error) {
+	var rows []*ScimUser
+	result := make(map[int]*ScimUser, len(userIds))
+	if len(userIds) == 0 {
+		return result, nil
+	}
+	if err := DB.Where("user_id IN ?", userIds).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.UserId] = row
+	}
+	return result, nil
+}
+
+func saveScimUserTx(tx *gorm.DB, scimUser *ScimUser) error {
+	now := common.GetTimestamp()
+	if scimUser.CreatedTime == 0 {
+		scimUser.CreatedTime = now
+	}
+	scimUser.UpdatedTime = now
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"external_id", "given_name", "family_name", "updated_time"}),
+	}).Create(scimUser).Error
+}
+
+// ScimUserQuery filters SCIM user searches. Usernames and emails match
+// case-insensitively; zero values match everything.
+type ScimUserQuery struct {
+	Id         int
+	UserName   string
+	ExternalId string
+	Email      string
+	Status     int
+}
+
+func SearchScimUsers(q ScimUserQuery, startIdx int, num int) (users []*User, total int64, err error) {
+	tx := DB.Model(&User{})
+	if q.Id != 0 {
+		tx = tx.Where("id = ?", q.Id)
+	}
+	if q.UserName != "" {
+		tx = tx.Where("LOWER(username) = ?", strings.ToLower(q.UserName))
+	}
+	if q.Email != "" {
+		tx = tx.Where("LOWER(email) = ?", strings.ToLower(q.Email))
+	}
+	if q.Status != 0 {
+		tx = tx.Where("status = ?", q.Status)
+	}
+	if q.ExternalId != "" {
+		tx = tx.Where("id IN (?)", DB.Model(&ScimUser{}).Select("user_id").Where("external_id = ?", q.ExternalId))
+	}
+	if err = tx.Count(&total).Error; err != nil || num <= 0 {
+		return nil, total, err
+	}
+	err = tx.Omit("password").Order("id asc").Limit(num).Offset(startIdx).Find(&users).Error
+	return users, total, err
+}
+
+// IsUsernameTakenByOther reports whether another user, deleted or not,
+// holds username.
+func IsUsernameTakenByOther(username string, userId int) (bool, error) {
+	var count int64
+	err := DB.Unscoped().Model(&User{}).Where("username = ? AND id <> ?", username, userId).Count(&count).Error
+	return count > 0, err
+}
+
+// CreateScimUser inserts a provisioned user and its SCIM attributes.
+func CreateScimUser(user *User, scimUser *ScimUser) error {
+	if err := user.Insert(0); err != nil {
+		return err
+	}
+	scimUser.UserId = user.Id
+	return saveScimUserTx(DB, scimUser)
+}
+
+// UpdateScimUser writes the provisioned attributes of a user. Disabling
+// the user also disables all of their tokens.
+func UpdateScimUser(user *User, scimUser *ScimUser) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", user.Id).Updates(map[string]interface{}{
+			"username":     user.Username,
+			"display_name": user.DisplayName,
+			"email":        user.Email,
+			"status":       user.Status,
+			"oidc_id":      user.OidcId,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return saveScimUserTx(tx, scimUser)
+	})
+	if err != nil {
+		return err
+	}
+	if user.Status != common.UserStatusEnabled {
+		if _, err := DisableUserTokens(user.Id); err != nil {
+			return err
+		}
+	}
+	return invalidateUserCache(user.Id)
+}
+
+// DeleteScimUser disables the user's tokens, drops their SCIM data and
+// deletes the user.
+func DeleteScimUser(userId int) error {
+	if _, err := DisableUserTokens(userId); err != nil {
+		return err
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&ScimGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(&ScimUser{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return DeleteUserById(userId)
+}
+
+// SetUserGroup changes the gateway group of a user.
+func SetUserGroup(userId int, group string) error {
+	if err := DB.Model(&User{}).Where("id = ?", userId).Update("group", group).Error; err != nil {
+		return err
+	}
+	return updateUserGroupCache(userId, group)
+}
+
+func GetScimGroupById(id int) (*ScimGroup, error) {
+	group := &ScimGroup{}
+	err := DB.First(group, "id = ?", id).Error
+	return group, err
+}
+
+func GetAllScimGroups() ([]*ScimGroup, error) {
+	var groups []*ScimGroup
+	err := DB.Order("id asc").Find(&groups).Error
+	return groups, err
+}
+
+// IsScimGroupNameTaken reports whether another group has displayName.
+func IsScimGroupNameTaken(displayName string, groupId int) (bool, error) {
+	var count int64
+	err := DB.Model(&ScimGroup{}).Where("LOWER(display_name) = ? AND id <> ?", strings.ToLower(displayName), groupId).Count(&count).Error
+	return count > 0, err
+}
+
+func (group *ScimGroup) Insert() error {
+	now := common.GetTimestamp()
+	group.CreatedTime = now
+	group.UpdatedTime = now
+	return DB.Create(group).Error
+}
+
+func (group *ScimGroup) Update() error {
+	group.UpdatedTime = common.GetTimestamp()
+	return DB.Model(group).Select("display_name", "external_id", "updated_time").Updates(group).Error
+}
+
+func (group *ScimGroup) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", group.Id).Delete(&ScimGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+}
+
+// GetScimGroupMembers returns the members of each group, with usernames.
+func GetScimGroupMembers(groupIds []int) (map[int][]*ScimGroupMember, error) {
+	result := make(map[int][]*ScimGroupMember, len(groupIds))
+	if len(groupIds) == 0 {
+		return result, nil
+	}
+	var members []*ScimGroupMember
+	err := DB.Table("scim_group_members").
+		Select("scim_group_members.*, users.username").
+		Joins("JOIN users ON users.id = scim_group_members.user_id AND users.deleted_at IS NULL").
+		Where("scim_group_members.group_id IN ?", groupIds).
+		Order("scim_group_members.id asc").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		result[member.GroupId] = append(result[member.GroupId], member)
+	}
+	return result, nil
+}
+
+// SetScimGroupMembers replaces the members of a group and returns the ids
+// of the users who joined or left it.
+func SetScimGroupMembers(groupId int, userIds []int) (changed []int, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var current []int
+		if err := tx.Model(&ScimGroupMember{}).Where("group_id = ?", groupId).Pluck("user_id", &current).Error; err != nil {
+			return err
+		}
+		wanted := make(map[int]bool, len(userIds))
+		for _, userId := range userIds {
+			wanted[userId] = true
+		}
+		existing := make(map[int]bool, len(current))
+		for _, userId := range current {
+			existing[userId] = true
+			if !wanted[userId] {
+				if err := tx.Where("group_id = ? AND user_id = ?", groupId, userId).Delete(&ScimGroupMember{}).Error; err != nil {
+					return err
+				}
+				changed = append(changed, userId)
+			}
+		}
+		for userId := range wanted {
+			if existing[userId] {
+				continue
+			}
+			if err := tx.Create(&ScimGroupMember{GroupId: groupId, UserId: userId}).Error; err != nil {
+				return err
+			}
+			changed = append(changed, userId)
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// GetScimGroupMemberIds returns the ids of the members of a group.
+func GetScimGroupMemberIds(groupId int) ([]int, error) {
+	var userIds []int
+	err := DB.Model(&ScimGroupMember{}).Where("group_id = ?", groupId).Pluck("user_id", &userIds).Error
+	return userIds, err
+}
+
+// GetUserScimGroups returns the groups of a user, oldest first.
+func GetUserScimGroups(userId int) ([]*ScimGroup, error) {
+	var groups []*ScimGroup
+	err := DB.Joins("JOIN scim_group_members ON scim_group_members.group_id = scim_groups.id").
+		Where("scim_group_members.user_id = ?", userId).
+		Order("scim_groups.id asc").
+		Find(&groups).Error
+	return groups, err
+}
+
+// SyncScimOrganizationMemberships makes the user a member of the wanted
+// organizations with the given roles and removes them from the other
+// managed ones. Owners are never demoted or removed.
+func SyncScimOrganizationMemberships(userId int, wanted map[int]string, managed map[int]bool) error {
+	now := common.GetTimestamp()
+	for orgId, role := range wanted {
+		member, err := GetOrganizationMember(orgId, userId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = DB.Create(&OrganizationMember{
+				OrganizationId: orgId,
+				UserId:         userId,
+				Role:           role,
+				CreatedTime:    now,
+			}).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if member.Role != role && member.Role != OrganizationRoleOwner {
+			member.Role = role
+			if err := member.Update(); err != nil {
+				return err
+			}
+		}
+	}
+	for orgId := range managed {
+		if _, ok := wanted[orgId]; ok {
+			continue
+		}
+		member, err := GetOrganizationMember(orgId, userId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if member.Role == OrganizationRoleOwner {
+			continue
+		}
+		if err := member.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
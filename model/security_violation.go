@@ -4,7 +4,7 @@ import (
 	"errors"
 	"time"
 
-	"gorm.io/gorm"
+	"github.com/QuantumNous/new-api/common"
 )
 
 // SecurityViolation records detected policy violations
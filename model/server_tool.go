@@ -0,0 +1,191 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ServerToolTypeHTTP       = "http"
+	ServerToolTypeCalculator = "calculator"
+	ServerToolTypeRetrieval  = "retrieval"
+)
+
+const (
+	ServerToolStatusEnabled  = 1
+	ServerToolStatusDisabled = 2
+)
+
+var serverToolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ServerTool is a function the gateway injects into chat requests and runs
+// itself when the model calls it.
+type ServerTool struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(1024);default:''"`
+	Type        string `json:"type" gorm:"type:varchar(16);default:'http'"`
+	// Parameters is the JSON schema shown to the model. Calculator and
+	// retrieval tools fall back to a built-in schema when it is empty.
+	Parameters string `json:"parameters" gorm:"type:text"`
+	// Url receives the call arguments as a JSON POST body (http tools).
+	Url     string `json:"url" gorm:"type:varchar(512);default:''"`
+	Headers string `json:"headers" gorm:"type:text"`
+	TopK    int    `json:"top_k" gorm:"default:3"` // retrieval results per call
+	// AutoInject adds the tool to every request for a matching model; other
+	// tools are only added when the request names them in server_tools.
+	AutoInject bool   `json:"auto_inject"`
+	Models     string `json:"models" gorm:"type:varchar(1024);default:''"` // comma separated, "prefix*" allowed, empty for all
+	Status     int    `json:"status" gorm:"default:1"`
+	// Price is charged per call in USD, multiplied by the group ratio.
+	Price       float64 `json:"price" gorm:"default:0"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64   `json:"updated_time" gorm:"bigint"`
+}
+
+// ServerToolDocument is one entry of a retrieval tool's index.
+type ServerToolDocument struct {
+	Id          int    `json:"id"`
+	ToolId      int    `json:"tool_id" gorm:"index"`
+	Title       string `json:"title" gorm:"type:varchar(255);default:''"`
+	Content     string `json:"content" gorm:"type:text"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.ServerToolsVersion, func() []interface{} {
+		return []interface{}{
+			&ServerTool{},
+			&ServerToolDocument{},
+		}
+	})
+}
+
+func (tool *ServerTool) Validate() error {
+	if !serverToolNamePattern.MatchString(tool.Name) {
+		return errors.New("name must be 1-64 letters, digits, '-' or '_'")
+	}
+	switch tool.Type {
+	case ServerToolTypeHTTP:
+		if !strings.HasPrefix(tool.Url, "http://") && !strings.HasPrefix(tool.Url, "https://") {
+			return errors.New("url must be an http(s) URL")
+		}
+		if tool.Parameters == "" {
+			return errors.New("parameters schema is required for http tools")
+		}
+	case ServerToolTypeCalculator, ServerToolTypeRetrieval:
+	default:
+		return errors.New("type must be http, calculator or retrieval")
+	}
+	if tool.Parameters != "" {
+		if _, err := common.StrToMap(tool.Parameters); err != nil {
+			return errors.New("parameters must be a JSON object")
+		}
+	}
+	if tool.Headers != "" {
+		if _, err := common.StrToMap(tool.Headers); err != nil {
+			return errors.New("headers must be a JSON object")
+		}
+	}
+	if tool.Price < 0 {
+		return errors.New("price must not be negative")
+	}
+	return nil
+}
+
+func (tool *ServerTool) HeaderMap() map[string]string {
+	return stringMap(tool.Headers)
+}
+
+// MatchesModel reports whether the tool is offered for modelName.
+func (tool *ServerTool) MatchesModel(modelName string) bool {
+	if strings.TrimSpace(tool.Models) == "" {
+		return true
+	}
+	for _, pattern := range strings.Split(tool.Models, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(modelName, prefix) {
+				return true
+			}
+		} else if pattern == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+func (tool *ServerTool) Insert() error {
+	now := common.GetTimestamp()
+	tool.CreatedTime = now
+	tool.UpdatedTime = now
+	return DB.Create(tool).Error
+}
+
+func (tool *ServerTool) Update() error {
+	tool.UpdatedTime = common.GetTimestamp()
+	return DB.Model(tool).Select("name", "description", "type", "parameters", "url", "headers", "top_k",
+		"auto_inject", "models", "status", "price", "updated_time").Updates(tool).Error
+}
+
+func (tool *ServerTool) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tool_id = ?", tool.Id).Delete(&ServerToolDocument{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(tool).Error
+	})
+}
+
+func GetAllServerTools() ([]*ServerTool, error) {
+	var tools []*ServerTool
+	err := DB.Order("id asc").Find(&tools).Error
+	return tools, err
+}
+
+func GetEnabledServerTools() ([]*ServerTool, error) {
+	var tools []*ServerTool
+	err := DB.Where("status = ?", ServerToolStatusEnabled).Order("id asc").Find(&tools).Error
+	return tools, err
+}
+
+func GetServerToolById(id int) (*ServerTool, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	tool := &ServerTool{}
+	err := DB.First(tool, "id = ?", id).Error
+	return tool, err
+}
+
+func GetServerToolDocuments(toolId int) ([]*ServerToolDocument, error) {
+	var documents []*ServerToolDocument
+	err := DB.Where("tool_id = ?", toolId).Order("id asc").Find(&documents).Error
+	return documents, err
+}
+
+func AddServerToolDocuments(toolId int, documents []*ServerToolDocument) error {
+	if len(documents) == 0 {
+		return nil
+	}
+	now := common.GetTimestamp()
+	for _, document := range documents {
+		document.Id = 0
+		document.ToolId = toolId
+		document.CreatedTime = now
+	}
+	return DB.Create(&documents).Error
+}
+
+func DeleteServerToolDocument(toolId int, documentId int) error {
+	return DB.Where("tool_id = ? AND id = ?", toolId, documentId).Delete(&ServerToolDocument{}).Error
+}
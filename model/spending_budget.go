@@ -0,0 +1,137 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model/migrations"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SpendingBudget caps what a token, or all requests of a user when TokenId
+// is 0, may spend per day, week or month. Windows renew automatically.
+type SpendingBudget struct {
+	Id      int    `json:"id"`
+	UserId  int    `json:"user_id" gorm:"index"`
+	TokenId int    `json:"token_id" gorm:"index;default:0"`
+	Window  string `json:"window" gorm:"type:varchar(16)"`
+	// Timezone is a TZ database name, "" for UTC.
+	Timezone   string `json:"timezone" gorm:"type:varchar(64);default:''"`
+	LimitQuota int64  `json:"limit_quota" gorm:"bigint"`
+	// SoftThresholds is a JSON array of percentages of the limit that notify
+	// the user when reached.
+	SoftThresholds string `json:"soft_thresholds" gorm:"type:varchar(255);default:''"`
+	// AdminManaged budgets are set by an administrator and cannot be changed
+	// by the user.
+	AdminManaged bool  `json:"admin_managed" gorm:"default:false"`
+	Enabled      bool  `json:"enabled" gorm:"default:true"`
+	CreatedTime  int64 `json:"created_time" gorm:"bigint"`
+	UpdatedTime  int64 `json:"updated_time" gorm:"bigint"`
+}
+
+// SpendingBudgetUsage is the spending of a budget in one window.
+type SpendingBudgetUsage struct {
+	Id          int   `json:"id"`
+	BudgetId    int   `json:"budget_id" gorm:"uniqueIndex:idx_budget_window,priority:1"`
+	WindowStart int64 `json:"window_start" gorm:"bigint;uniqueIndex:idx_budget_window,priority:2"`
+	WindowEnd   int64 `json:"window_end" gorm:"bigint"`
+	SpentQuota  int64 `json:"spent_quota" gorm:"bigint;default:0"`
+	// NotifiedThreshold is the highest threshold notified in the window.
+	NotifiedThreshold int   `json:"notified_threshold" gorm:"default:0"`
+	UpdatedTime       int64 `json:"updated_time" gorm:"bigint"`
+}
+
+func init() {
+	migrations.RegisterSchemaProvider(migrations.SpendingBudgetVersion, func() []interface{} {
+		return []interface{}{
+			&SpendingBudget{},
+			&SpendingBudgetUsage{},
+		}
+	})
+}
+
+func (budget *SpendingBudget) GetSoftThresholds() []int {
+	var thresholds []int
+	if budget.SoftThresholds != "" {
+		_ = common.Unmarshal([]byte(budget.SoftThresholds), &thresholds)
+	}
+	return thresholds
+}
+
+func (budget *SpendingBudget) SetSoftThresholds(thresholds []int) {
+	budget.SoftThresholds = common.GetJsonString(thresholds)
+}
+
+// GetUserSpendingBudgets returns the budgets of a user and of their tokens.
+func GetUserSpendingBudgets(userId int) ([]*SpendingBudget, error) {
+	var budgets []*SpendingBudget
+	err := DB.Where("user_id = ?", userId).Order("id asc").Find(&budgets).Error
+	return budgets, err
+}
+
+func GetSpendingBudgetById(id int) (*SpendingBudget, error) {
+	budget := &SpendingBudget{}
+	err := DB.First(budget, "id = ?", id).Error
+	return budget, err
+}
+
+func (budget *SpendingBudget) Insert() error {
+	now := common.GetTimestamp()
+	budget.CreatedTime = now
+	budget.UpdatedTime = now
+	return DB.Create(budget).Error
+}
+
+func (budget *SpendingBudget) Update() error {
+	budget.UpdatedTime = common.GetTimestamp()
+	return DB.Model(budget).Select("token_id", "window", "timezone", "limit_quota", "soft_thresholds", "admin_managed", "enabled", "updated_time").Updates(budget).Error
+}
+
+func (budget *SpendingBudget) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("budget_id = ?", budget.Id).Delete(&SpendingBudgetUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(budget).Error
+	})
+}
+
+// GetSpendingBudgetUsage returns the usage of a budget in the window
+// starting at windowStart, empty if nothing was spent.
+func GetSpendingBudgetUsage(budgetId int, windowStart int64) (*SpendingBudgetUsage, error) {
+	var usages []*SpendingBudgetUsage
+	err := DB.Where("budget_id = ? AND window_start = ?", budgetId, windowStart).Limit(1).Find(&usages).Error
+	if err != nil || len(usages) == 0 {
+		return &SpendingBudgetUsage{BudgetId: budgetId, WindowStart: windowStart}, err
+	}
+	return usages[0], nil
+}
+
+// AddSpendingBudgetUsage adds quota, which may be negative for refunds, to
+// the window of a budget.
+func AddSpendingBudgetUsage(budgetId int, windowStart int64, windowEnd int64, quota int64) error {
+	now := common.GetTimestamp()
+	usage := SpendingBudgetUsage{
+		BudgetId:    budgetId,
+		WindowStart: windowStart,
+		WindowEnd:   windowEnd,
+		SpentQuota:  quota,
+		UpdatedTime: now,
+	}
+	return DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "budget_id"}, {Name: "window_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"spent_quota":  gorm.Expr("spent_quota + ?", quota),
+			"updated_time": now,
+		}),
+	}).Create(&usage).Error
+}
+
+// MarkSpendingBudgetNotified records that threshold was notified in the
+// window. It reports false when it, or a higher one, already was.
+func MarkSpendingBudgetNotified(budgetId int, windowStart int64, threshold int) (bool, error) {
+	result := DB.Model(&SpendingBudgetUsage{}).
+		Where("budget_id = ? AND window_start = ? AND notified_threshold < ?", budgetId, windowStart, threshold).
+		Update("notified_threshold", threshold)
+	return result.RowsAffected > 0, result.Error
+}
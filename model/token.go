@@ -6,6 +6,7 @@ import (
     "strings"
 
     "github.com/QuantumNous/new-api/common"
+    "github.com/QuantumNous/new-api/model/migrations"
 
     "github.com/bytedance/gopkg/util/gopool"
     "gorm.io/gorm"
@@ -24,6 +25,9 @@ type Token struct {
     UnlimitedQuota     bool           `json:"unlimited_quota"`
     ModelLimitsEnabled bool           `json:"model_limits_enabled"`
     ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
+    // McpLimits lists the MCP servers ("server") or tools ("server/tool") the token may call
+    McpLimitsEnabled   bool           `json:"mcp_limits_enabled"`
+    McpLimits          string         `json:"mcp_limits" gorm:"type:varchar(1024);default:''"`
     AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
     UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
     Group              string         `json:"group" gorm:"default:''"`
@@ -31,9 +35,21 @@ type Token struct {
     BillingMode                string         `json:"billing_mode" gorm:"size:16;default:'balance'"`
     PlanAssignmentId           *int           `json:"plan_assignment_id" gorm:"index"`
     ConversationLoggingEnabled bool           `json:"conversation_logging_enabled" gorm:"type:boolean;default:false"` // Enable encrypted conversation logging
+    // FundingWaterfall overrides the order funding sources are drawn from, e.g. "package,balance"
+    FundingWaterfall           string         `json:"funding_waterfall" gorm:"type:varchar(64);default:''"`
+    // OrganizationId makes the token charge the organization's quota pool
+    OrganizationId             int            `json:"organization_id" gorm:"index;default:0"`
     DeletedAt                  gorm.DeletedAt `gorm:"index"`
 }
 
+func init() {
+    migrations.RegisterSchemaProvider(migrations.FundingWaterfallVersion, func() []interface{} {
+        return []interface{}{
+            &Token{},
+        }
+    })
+}
+
 func (token *Token) Clean() {
     token.Key = ""
 }
@@ -212,7 +228,7 @@ func (token *Token) Update() (err error) {
         }
     }()
     err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-        "model_limits_enabled", "model_limits", "allow_ips", "group", "billing_mode", "plan_assignment_id", "conversation_logging_enabled").Updates(token).Error
+        "model_limits_enabled", "model_limits", "mcp_limits_enabled", "mcp_limits", "allow_ips", "group", "billing_mode", "plan_assignment_id", "conversation_logging_enabled", "funding_waterfall").Updates(token).Error
     return err
 }
 
@@ -266,6 +282,13 @@ func (token *Token) GetModelLimitsMap() map[string]bool {
     return limitsMap
 }
 
+func (token *Token) GetMcpLimits() []string {
+    if token.McpLimits == "" {
+        return []string{}
+    }
+    return strings.Split(token.McpLimits, ",")
+}
+
 func DisableModelLimits(tokenId int) error {
     token, err := GetTokenById(tokenId)
     if err != nil {
@@ -389,3 +412,26 @@ func BatchDeleteTokens(ids []int, userId int) (int, error) {
 
     return len(tokens), nil
 }
+
+// DisableUserTokens 禁用指定用户的全部令牌，返回被禁用的数量
+func DisableUserTokens(userId int) (int, error) {
+    var tokens []Token
+    if err := DB.Where("user_id = ? AND status = ?", userId, common.TokenStatusEnabled).Find(&tokens).Error; err != nil {
+        return 0, err
+    }
+    if len(tokens) == 0 {
+        return 0, nil
+    }
+    if err := DB.Model(&Token{}).Where("user_id = ? AND status = ?", userId, common.TokenStatusEnabled).
+        Update("status", common.TokenStatusDisabled).Error; err != nil {
+        return 0, err
+    }
+    if common.RedisEnabled {
+        gopool.Go(func() {
+            for _, t := range tokens {
+                _ = cacheDeleteToken(t.Key)
+            }
+        })
+    }
+    return len(tokens), nil
+}
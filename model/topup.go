@@ -18,9 +18,98 @@ type TopUp struct {
 	Money         float64 `json:"money"`
 	TradeNo       string  `json:"trade_no" gorm:"unique;type:varchar(255);index"`
 	PaymentMethod string  `json:"payment_method" gorm:"type:varchar(50)"`
-	CreateTime    int64   `json:"create_time"`
-	CompleteTime  int64   `json:"complete_time"`
-	Status        string  `json:"status"`
+	// Provider is the payment provider handling the order, "" for orders
+	// created before providers; see TopUpProvider.
+	Provider string `json:"provider" gorm:"type:varchar(32);default:''"`
+	// ProviderTradeNo is the provider's id of the payment, used for refunds.
+	ProviderTradeNo string `json:"provider_trade_no" gorm:"type:varchar(255);default:''"`
+	// Quota is credited on payment. 0 on older orders, see CreditQuota.
+	Quota         int64   `json:"quota" gorm:"bigint;default:0"`
+	// RefundedMoney is the part of PaidMoney refunded so far.
+	RefundedMoney float64 `json:"refunded_money" gorm:"default:0"`
+	RefundedQuota int64   `json:"refunded_quota" gorm:"bigint;default:0"`
+	// PayMoney is the price the user was charged. 0 on older orders, see
+	// PaidMoney.
+	PayMoney     float64 `json:"pay_money" gorm:"default:0"`
+	CreateTime   int64   `json:"create_time"`
+	CompleteTime int64   `json:"complete_time"`
+	Status       string  `json:"status"`
+}
+
+// topUpTransitions is the order state machine: the states each state may
+// move to.
+var topUpTransitions = map[string][]string{
+	common.TopUpStatusPending:         {common.TopUpStatusSuccess, common.TopUpStatusExpired, common.TopUpStatusFailed},
+	common.TopUpStatusSuccess:         {common.TopUpStatusPartialRefunded, common.TopUpStatusRefunded},
+	common.TopUpStatusPartialRefunded: {common.TopUpStatusPartialRefunded, common.TopUpStatusRefunded},
+}
+
+// CanTransitionTopUp reports whether an order may move from one status to
+// another.
+func CanTransitionTopUp(from string, to string) bool {
+	for _, status := range topUpTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (topUp *TopUp) transition(to string) error {
+	if !CanTransitionTopUp(topUp.Status, to) {
+		return fmt.Errorf("订单状态 %s 无法变更为 %s", topUp.Status, to)
+	}
+	topUp.Status = to
+	return nil
+}
+
+// TopUpProvider returns the payment provider of an order, inferring it from
+// the payment method for orders created before providers.
+func (topUp *TopUp) TopUpProvider() string {
+	if topUp.Provider != "" {
+		return topUp.Provider
+	}
+	if topUp.PaymentMethod == "stripe" {
+		return "stripe"
+	}
+	return "epay"
+}
+
+// CreditQuota returns the quota the order credits when paid.
+func (topUp *TopUp) CreditQuota() int64 {
+	if topUp.Quota > 0 {
+		return topUp.Quota
+	}
+	// older orders: Stripe orders store the quota in Money, others the
+	// amount in Amount
+	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+	if topUp.PaymentMethod == "stripe" {
+		return decimal.NewFromFloat(topUp.Money).Mul(dQuotaPerUnit).IntPart()
+	}
+	return decimal.NewFromInt(topUp.Amount).Mul(dQuotaPerUnit).IntPart()
+}
+
+// PaidMoney returns the price the user was charged, falling back to Money
+// for orders created before it was stored.
+func (topUp *TopUp) PaidMoney() float64 {
+	if topUp.PayMoney > 0 {
+		return topUp.PayMoney
+	}
+	return topUp.Money
+}
+
+// PaidMoneyKnown reports whether PaidMoney is the price charged. Stripe
+// orders created before PayMoney was stored hold the quota bought in Money.
+func (topUp *TopUp) PaidMoneyKnown() bool {
+	return topUp.PayMoney > 0 || topUp.TopUpProvider() != "stripe"
+}
+
+// RefundableMoney is what is left to refund of a paid order, in money paid.
+func (topUp *TopUp) RefundableMoney() float64 {
+	if topUp.Status != common.TopUpStatusSuccess && topUp.Status != common.TopUpStatusPartialRefunded {
+		return 0
+	}
+	return decimal.NewFromFloat(topUp.PaidMoney()).Sub(decimal.NewFromFloat(topUp.RefundedMoney)).InexactFloat64()
 }
 
 func (topUp *TopUp) Insert() error {
@@ -55,50 +144,94 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	return topUp
 }
 
-func Recharge(referenceId string, customerId string) (err error) {
-	if referenceId == "" {
-		return errors.New("未提供支付单号")
-	}
-
-	var quota float64
-	topUp := &TopUp{}
-
+func lockTopUpTx(tx *gorm.DB, tradeNo string) (*TopUp, error) {
 	refCol := "`trade_no`"
 	if common.UsingPostgreSQL {
 		refCol = `"trade_no"`
 	}
+	topUp := &TopUp{}
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", tradeNo).First(topUp).Error; err != nil {
+		return nil, errors.New("充值订单不存在")
+	}
+	return topUp, nil
+}
 
-	err = DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", referenceId).First(topUp).Error
+// CompleteTopUp marks a pending order paid and credits its quota. It reports
+// false without error when the order was already paid.
+func CompleteTopUp(tradeNo string, providerTradeNo string, stripeCustomer string) (*TopUp, bool, error) {
+	if tradeNo == "" {
+		return nil, false, errors.New("未提供支付单号")
+	}
+	var topUp *TopUp
+	credited := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		topUp, err = lockTopUpTx(tx, tradeNo)
 		if err != nil {
-			return errors.New("充值订单不存在")
+			return err
 		}
-
-		if topUp.Status != common.TopUpStatusPending {
-			return errors.New("充值订单状态错误")
+		if topUp.Status == common.TopUpStatusSuccess {
+			return nil
 		}
-
+		if err := topUp.transition(common.TopUpStatusSuccess); err != nil {
+			return err
+		}
+		quota := topUp.CreditQuota()
+		if quota <= 0 {
+			return errors.New("无效的充值额度")
+		}
+		topUp.Quota = quota
 		topUp.CompleteTime = common.GetTimestamp()
-		topUp.Status = common.TopUpStatusSuccess
-		err = tx.Save(topUp).Error
-		if err != nil {
+		if providerTradeNo != "" {
+			topUp.ProviderTradeNo = providerTradeNo
+		}
+		if err := tx.Save(topUp).Error; err != nil {
 			return err
 		}
+		updates := map[string]interface{}{"quota": gorm.Expr("quota + ?", quota)}
+		if stripeCustomer != "" {
+			updates["stripe_customer"] = stripeCustomer
+		}
+		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(updates).Error; err != nil {
+			return err
+		}
+		credited = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return topUp, credited, nil
+}
 
-		quota = topUp.Money * common.QuotaPerUnit
-		err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(map[string]interface{}{"stripe_customer": customerId, "quota": gorm.Expr("quota + ?", quota)}).Error
+// CloseTopUp moves a pending order to expired or failed. Orders no longer
+// pending are left as is.
+func CloseTopUp(tradeNo string, status string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		topUp, err := lockTopUpTx(tx, tradeNo)
 		if err != nil {
 			return err
 		}
-
-		return nil
+		if topUp.Status != common.TopUpStatusPending {
+			return nil
+		}
+		if err := topUp.transition(status); err != nil {
+			return err
+		}
+		return tx.Save(topUp).Error
 	})
+}
 
+func Recharge(referenceId string, customerId string) (err error) {
+	topUp, credited, err := CompleteTopUp(referenceId, "", customerId)
 	if err != nil {
 		return errors.New("充值失败，" + err.Error())
 	}
+	if !credited {
+		return errors.New("充值失败，充值订单状态错误")
+	}
 
-	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%d", logger.FormatQuota(int(quota)), topUp.Amount))
+	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%d", logger.FormatQuota(int(topUp.Quota)), topUp.Amount))
 
 	return nil
 }
@@ -240,68 +373,15 @@ func ManualCompleteTopUp(tradeNo string) error {
 		return errors.New("未提供订单号")
 	}
 
-	refCol := "`trade_no`"
-	if common.UsingPostgreSQL {
-		refCol = `"trade_no"`
-	}
-
-	var userId int
-	var quotaToAdd int
-	var payMoney float64
-
-	err := DB.Transaction(func(tx *gorm.DB) error {
-		topUp := &TopUp{}
-		// 行级锁，避免并发补单
-		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", tradeNo).First(topUp).Error; err != nil {
-			return errors.New("充值订单不存在")
-		}
-
-		// 幂等处理：已成功直接返回
-		if topUp.Status == common.TopUpStatusSuccess {
-			return nil
-		}
-
-		if topUp.Status != common.TopUpStatusPending {
-			return errors.New("订单状态不是待支付，无法补单")
-		}
-
-		// 计算应充值额度：
-		// - Stripe 订单：Money 代表经分组倍率换算后的美元数量，直接 * QuotaPerUnit
-		// - 其他订单（如易支付）：Amount 为美元数量，* QuotaPerUnit
-		if topUp.PaymentMethod == "stripe" {
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd = int(decimal.NewFromFloat(topUp.Money).Mul(dQuotaPerUnit).IntPart())
-		} else {
-			dAmount := decimal.NewFromInt(topUp.Amount)
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd = int(dAmount.Mul(dQuotaPerUnit).IntPart())
-		}
-		if quotaToAdd <= 0 {
-			return errors.New("无效的充值额度")
-		}
-
-		// 标记完成
-		topUp.CompleteTime = common.GetTimestamp()
-		topUp.Status = common.TopUpStatusSuccess
-		if err := tx.Save(topUp).Error; err != nil {
-			return err
-		}
-
-		// 增加用户额度（立即写库，保持一致性）
-		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", quotaToAdd)).Error; err != nil {
-			return err
-		}
-
-		userId = topUp.UserId
-		payMoney = topUp.Money
-		return nil
-	})
-
+	topUp, credited, err := CompleteTopUp(tradeNo, "", "")
 	if err != nil {
 		return err
 	}
+	// 幂等处理：已成功直接返回
+	if !credited {
+		return nil
+	}
 
-	// 事务外记录日志，避免阻塞
-	RecordLog(userId, LogTypeTopup, fmt.Sprintf("管理员补单成功，充值金额: %v，支付金额：%f", logger.FormatQuota(quotaToAdd), payMoney))
+	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("管理员补单成功，充值金额: %v，支付金额：%f", logger.FormatQuota(int(topUp.Quota)), topUp.Money))
 	return nil
 }
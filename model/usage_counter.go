@@ -87,6 +87,22 @@ func IncrementUsageCounterTx(tx *gorm.DB, assignmentId int, metric string, amoun
     }).Create(&counter).Error
 }
 
+// DecrementUsageCounter returns amount to an existing counter, never taking it below zero.
+func DecrementUsageCounter(assignmentId int, metric string, amount int64, cycleStart time.Time) error {
+    if assignmentId == 0 || metric == "" {
+        return errors.New("assignment id and metric required")
+    }
+    if amount <= 0 {
+        return nil
+    }
+    return DB.Model(&UsageCounter{}).
+        Where("plan_assignment_id = ? AND metric = ? AND cycle_start = ?", assignmentId, metric, cycleStart.UTC()).
+        Updates(map[string]interface{}{
+            "consumed_amount": gorm.Expr("CASE WHEN consumed_amount > ? THEN consumed_amount - ? ELSE 0 END", amount, amount),
+            "updated_at":      gorm.Expr("CURRENT_TIMESTAMP"),
+        }).Error
+}
+
 func ResetUsageCounter(assignmentId int, metric string, cycleStart time.Time) error {
     if assignmentId == 0 || metric == "" {
         return errors.New("assignment id and metric required")
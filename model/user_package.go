@@ -120,6 +120,38 @@ func GetActiveUserPackages(userId int) ([]*UserPackage, error) {
 	return packages, nil
 }
 
+// GetUsablePackageQuota sums the remaining quota of a user's active packages that allow modelName
+func GetUsablePackageQuota(userId int, modelName string) (int64, error) {
+	packages, err := GetActiveUserPackages(userId)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, pkg := range packages {
+		if pkg.IsModelAllowed(modelName) {
+			total += pkg.TokenQuota
+		}
+	}
+	return total, nil
+}
+
+// RefundUserPackageQuota returns quota to a user package, reactivating it if it was exhausted and has not expired
+func RefundUserPackageQuota(id int, quota int64) error {
+	if quota <= 0 {
+		return errors.New("invalid amount")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserPackage{}).Where("id = ?", id).
+			Update("token_quota", gorm.Expr("token_quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&UserPackage{}).
+			Where("id = ? AND status = ? AND token_quota > 0 AND expire_at > ?", id, 2, time.Now()).
+			Update("status", 1).Error
+	})
+}
+
 // Insert creates a new user package
 func (up *UserPackage) Insert() error {
 	return DB.Create(up).Error
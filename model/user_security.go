@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
 )
 
 // UserSecurity tracks user security status
@@ -38,7 +39,7 @@ func GetUserSecurity(userId int) (*UserSecurity, error) {
 	var userSec UserSecurity
 	err := DB.Where("user_id = ?", userId).First(&userSec).Error
 	if err != nil {
-		if errors.Is(err, errors.New("record not found")) {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// Create default security record
 			userSec = UserSecurity{
 				UserId:         userId,
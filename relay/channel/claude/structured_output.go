@@ -0,0 +1,108 @@
+package claude
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const structuredOutputDefaultTool = "structured_output"
+
+// applyStructuredOutput translates response_format, which Claude has no
+// parameter for. A json_schema with an object root is sent as a tool the model
+// is forced to call, and its input becomes the message content; other formats,
+// and requests that already use tools or thinking, get a system instruction.
+func applyStructuredOutput(c *gin.Context, textRequest dto.GeneralOpenAIRequest, claudeRequest *dto.ClaudeRequest) {
+	common.SetContextKey(c, constant.ContextKeyStructuredOutputTool, "")
+	format := textRequest.ResponseFormat
+	if format == nil || (format.Type != "json_schema" && format.Type != "json_object") {
+		return
+	}
+
+	var schema dto.FormatJsonSchema
+	if format.Type == "json_schema" {
+		if err := common.Unmarshal(format.JsonSchema, &schema); err != nil {
+			return
+		}
+		root, _ := schema.Schema.(map[string]any)
+		tools, _ := claudeRequest.Tools.([]any)
+		if config.GetStructuredOutputConfig().ClaudeToolForcing && root != nil && root["type"] == "object" &&
+			len(tools) == 0 && claudeRequest.Thinking == nil {
+			name := schema.Name
+			if name == "" {
+				name = structuredOutputDefaultTool
+			}
+			description := schema.Description
+			if description == "" {
+				description = "Respond by calling this tool; its input is the final answer."
+			}
+			claudeRequest.Tools = append(tools, &dto.Tool{
+				Name:        name,
+				Description: description,
+				InputSchema: root,
+			})
+			claudeRequest.ToolChoice = &dto.ClaudeToolChoice{Type: "tool", Name: name}
+			common.SetContextKey(c, constant.ContextKeyStructuredOutputTool, name)
+			return
+		}
+	}
+
+	instruction := "Respond with a single valid JSON object only, without code fences or any other text."
+	if schema.Schema != nil {
+		if encoded, err := common.Marshal(schema.Schema); err == nil {
+			instruction = "Respond with JSON only, without code fences or any other text. The JSON must match this schema:\n" + string(encoded)
+		}
+	}
+	text := instruction
+	system, _ := claudeRequest.System.([]dto.ClaudeMediaMessage)
+	claudeRequest.System = append(system, dto.ClaudeMediaMessage{Type: "text", Text: &text})
+}
+
+// unwrapStructuredOutputResponse turns the forced tool call back into the
+// message content the client asked for.
+func unwrapStructuredOutputResponse(c *gin.Context, response *dto.OpenAITextResponse) {
+	name := common.GetContextKeyString(c, constant.ContextKeyStructuredOutputTool)
+	if name == "" {
+		return
+	}
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		for _, call := range choice.Message.ParseToolCalls() {
+			if call.Function.Name != name {
+				continue
+			}
+			choice.Message.SetStringContent(call.Function.Arguments)
+			choice.Message.ToolCalls = nil
+			choice.FinishReason = constant.FinishReasonStop
+			break
+		}
+	}
+}
+
+// unwrapStructuredOutputChunk is the streaming counterpart: argument deltas
+// of the forced tool are sent as content deltas.
+func unwrapStructuredOutputChunk(c *gin.Context, response *dto.ChatCompletionsStreamResponse) {
+	if response == nil || common.GetContextKeyString(c, constant.ContextKeyStructuredOutputTool) == "" {
+		return
+	}
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		if len(choice.Delta.ToolCalls) > 0 {
+			var arguments strings.Builder
+			for _, call := range choice.Delta.ToolCalls {
+				arguments.WriteString(call.Function.Arguments)
+			}
+			choice.Delta.ToolCalls = nil
+			choice.Delta.SetContentString(arguments.String())
+		}
+		if choice.FinishReason != nil && *choice.FinishReason == constant.FinishReasonToolCalls {
+			stop := constant.FinishReasonStop
+			choice.FinishReason = &stop
+		}
+	}
+}
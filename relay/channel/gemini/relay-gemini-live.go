@@ -0,0 +1,605 @@
+package gemini
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// OpenAI pcm16 audio is 24kHz mono little-endian, which Gemini Live accepts
+// as input and also produces as output, so audio is passed through untouched.
+const geminiLiveInputMimeType = "audio/pcm;rate=24000"
+
+var geminiLiveVoices = map[string]string{
+	"puck":   "Puck",
+	"charon": "Charon",
+	"kore":   "Kore",
+	"fenrir": "Fenrir",
+	"aoede":  "Aoede",
+	"leda":   "Leda",
+	"orus":   "Orus",
+	"zephyr": "Zephyr",
+}
+
+// geminiLiveBridge translates between the OpenAI Realtime event protocol
+// spoken by the client and the Gemini Live BidiGenerateContent protocol.
+type geminiLiveBridge struct {
+	c      *gin.Context
+	info   *relaycommon.RelayInfo
+	writer *helper.RealtimeEventWriter
+	target *websocket.Conn
+
+	// owned by the client reader
+	session        dto.RealtimeSession
+	setupSent      bool
+	manualActivity bool
+	activityOpen   bool
+	inputItemId    string
+	pendingTurns   []dto.GeminiChatContent
+
+	// owned by the upstream reader
+	responseId      string
+	itemId          string
+	text            strings.Builder
+	transcript      strings.Builder
+	inputTranscript strings.Builder
+	hasAudio        bool
+
+	mu                sync.Mutex
+	callNames         map[string]string
+	turnUsage         *dto.RealtimeUsage
+	localUsage        *dto.RealtimeUsage
+	sumUsage          *dto.RealtimeUsage
+	upstreamUsageSeen bool
+}
+
+// GeminiLiveRealtimeHandler serves an OpenAI Realtime client from a Gemini
+// Live session. Usage reported by Gemini is consumed as it arrives; when the
+// upstream never reports usage, a local estimate is billed at the end.
+func GeminiLiveRealtimeHandler(c *gin.Context, info *relaycommon.RelayInfo) (*types.NewAPIError, *dto.RealtimeUsage) {
+	if info == nil || info.ClientWs == nil || info.TargetWs == nil {
+		return types.NewError(fmt.Errorf("invalid websocket connection"), types.ErrorCodeBadResponse), nil
+	}
+	info.IsStream = true
+	info.InputAudioFormat = "pcm16"
+	info.OutputAudioFormat = "pcm16"
+
+	b := &geminiLiveBridge{
+		c:      c,
+		info:   info,
+		writer: helper.NewRealtimeEventWriter(c, info.ClientWs),
+		target: info.TargetWs,
+		session: dto.RealtimeSession{
+			Modalities:        []string{"text", "audio"},
+			InputAudioFormat:  "pcm16",
+			OutputAudioFormat: "pcm16",
+			TurnDetection:     map[string]any{"type": "server_vad"},
+		},
+		callNames:  make(map[string]string),
+		turnUsage:  &dto.RealtimeUsage{},
+		localUsage: &dto.RealtimeUsage{},
+		sumUsage:   &dto.RealtimeUsage{},
+	}
+
+	// Gemini expects the setup message first, so session.created is answered
+	// locally and setup is deferred until the client's first event.
+	if err := b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionCreated, Session: &b.session}); err != nil {
+		return types.NewError(err, types.ErrorCodeBadResponse), nil
+	}
+
+	clientClosed := make(chan struct{})
+	targetClosed := make(chan struct{})
+	errChan := make(chan error, 2)
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in client reader: %v", r)
+			}
+		}()
+		for {
+			select {
+			case <-c.Done():
+				return
+			default:
+				_, message, err := info.ClientWs.ReadMessage()
+				if err != nil {
+					if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+						errChan <- fmt.Errorf("error reading from client: %v", err)
+					}
+					close(clientClosed)
+					return
+				}
+				event := &dto.RealtimeEvent{}
+				if err := common.Unmarshal(message, event); err != nil {
+					errChan <- fmt.Errorf("error unmarshalling message: %v", err)
+					return
+				}
+				if err := b.handleClientEvent(event, message); err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}
+	})
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in target reader: %v", r)
+			}
+		}()
+		for {
+			select {
+			case <-c.Done():
+				return
+			default:
+				_, message, err := b.target.ReadMessage()
+				if err != nil {
+					if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+						errChan <- fmt.Errorf("error reading from target: %v", err)
+					}
+					close(targetClosed)
+					return
+				}
+				info.SetFirstResponseTime()
+				serverMessage := &dto.GeminiLiveServerMessage{}
+				if err := common.Unmarshal(message, serverMessage); err != nil {
+					errChan <- fmt.Errorf("error unmarshalling message: %v", err)
+					return
+				}
+				if err := b.handleServerMessage(serverMessage); err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}
+	})
+
+	select {
+	case <-clientClosed:
+	case <-targetClosed:
+	case err := <-errChan:
+		logger.LogError(c, "gemini live realtime error: "+err.Error())
+	case <-c.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.upstreamUsageSeen && b.localUsage.TotalTokens != 0 {
+		_ = service.PreWssConsumeQuota(c, info, b.localUsage)
+		b.sumUsage.Add(b.localUsage)
+	}
+	return nil, b.sumUsage
+}
+
+func (b *geminiLiveBridge) sendUpstream(message *dto.GeminiLiveClientMessage) error {
+	if err := helper.WssObject(b.c, b.target, message); err != nil {
+		return fmt.Errorf("error writing to target: %v", err)
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) addLocalUsage(textTokens int, audioTokens int, output bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.localUsage.TotalTokens += textTokens + audioTokens
+	if output {
+		b.localUsage.OutputTokens += textTokens + audioTokens
+		b.localUsage.OutputTokenDetails.TextTokens += textTokens
+		b.localUsage.OutputTokenDetails.AudioTokens += audioTokens
+	} else {
+		b.localUsage.InputTokens += textTokens + audioTokens
+		b.localUsage.InputTokenDetails.TextTokens += textTokens
+		b.localUsage.InputTokenDetails.AudioTokens += audioTokens
+	}
+}
+
+func (b *geminiLiveBridge) handleClientEvent(event *dto.RealtimeEvent, raw []byte) error {
+	if event.Type == dto.RealtimeEventTypeSessionUpdate {
+		if b.setupSent {
+			// Gemini Live cannot reconfigure a running session.
+			logger.LogWarn(b.c, "gemini live: session.update after setup ignored")
+			return b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: &b.session})
+		}
+		if err := b.mergeSession(event.Session, raw); err != nil {
+			_ = b.writer.SendError("invalid_session", err.Error())
+			return err
+		}
+		if err := b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: &b.session}); err != nil {
+			return err
+		}
+		return b.sendSetup()
+	}
+	if !b.setupSent {
+		if err := b.sendSetup(); err != nil {
+			return err
+		}
+	}
+
+	switch event.Type {
+	case dto.RealtimeEventInputAudioBufferAppend:
+		if b.manualActivity && !b.activityOpen {
+			if err := b.sendUpstream(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{ActivityStart: &struct{}{}}}); err != nil {
+				return err
+			}
+			b.activityOpen = true
+		}
+		if audioTokens, err := service.CountAudioTokenInput(event.Audio, b.info.InputAudioFormat); err == nil {
+			b.addLocalUsage(0, audioTokens, false)
+		}
+		return b.sendUpstream(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{
+			Audio: &dto.GeminiInlineData{MimeType: geminiLiveInputMimeType, Data: event.Audio},
+		}})
+	case dto.RealtimeEventInputAudioBufferCommit:
+		b.inputItemId = b.writer.NewId("item")
+		var err error
+		if b.manualActivity {
+			if b.activityOpen {
+				err = b.sendUpstream(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{ActivityEnd: &struct{}{}}})
+				b.activityOpen = false
+			}
+		} else {
+			err = b.sendUpstream(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{AudioStreamEnd: true}})
+		}
+		if err != nil {
+			return err
+		}
+		return b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCommitted, ItemId: b.inputItemId})
+	case dto.RealtimeEventInputAudioBufferClear:
+		// Audio already streamed to Gemini cannot be withdrawn.
+		return b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCleared})
+	case dto.RealtimeEventTypeConversationCreate:
+		return b.handleConversationItem(event.Item)
+	case dto.RealtimeEventTypeResponseCreate:
+		if len(b.pendingTurns) > 0 {
+			turns := b.pendingTurns
+			b.pendingTurns = nil
+			return b.sendUpstream(&dto.GeminiLiveClientMessage{ClientContent: &dto.GeminiLiveClientContent{Turns: turns, TurnComplete: true}})
+		}
+		if b.manualActivity && b.activityOpen {
+			b.activityOpen = false
+			return b.sendUpstream(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{ActivityEnd: &struct{}{}}})
+		}
+	case dto.RealtimeEventTypeResponseCancel:
+		logger.LogInfo(b.c, "gemini live: response.cancel is not supported upstream")
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) handleConversationItem(item *dto.RealtimeItem) error {
+	if item == nil {
+		return nil
+	}
+	if item.Id == "" {
+		item.Id = b.writer.NewId("item")
+	}
+	switch item.Type {
+	case "function_call_output":
+		b.mu.Lock()
+		name := b.callNames[item.CallId]
+		b.mu.Unlock()
+		response := map[string]any{"output": item.Output}
+		var parsed map[string]any
+		if err := common.Unmarshal([]byte(item.Output), &parsed); err == nil {
+			response = parsed
+		}
+		b.addLocalUsage(service.CountTextToken(item.Output, b.info.UpstreamModelName), 0, false)
+		if err := b.sendUpstream(&dto.GeminiLiveClientMessage{ToolResponse: &dto.GeminiLiveToolResponse{
+			FunctionResponses: []dto.GeminiLiveFunctionResponse{{Id: item.CallId, Name: name, Response: response}},
+		}}); err != nil {
+			return err
+		}
+	case "message":
+		parts := make([]dto.GeminiPart, 0, len(item.Content))
+		for _, content := range item.Content {
+			text := content.Text
+			if text == "" {
+				text = content.Transcript
+			}
+			if text == "" {
+				continue
+			}
+			parts = append(parts, dto.GeminiPart{Text: text})
+			b.addLocalUsage(service.CountTextToken(text, b.info.UpstreamModelName), 0, false)
+		}
+		if len(parts) > 0 {
+			role := "user"
+			if item.Role == "assistant" {
+				role = "model"
+			}
+			b.pendingTurns = append(b.pendingTurns, dto.GeminiChatContent{Role: role, Parts: parts})
+		}
+	}
+	return b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventConversationItemCreated, Item: item})
+}
+
+func (b *geminiLiveBridge) mergeSession(session *dto.RealtimeSession, raw []byte) error {
+	if session == nil {
+		return nil
+	}
+	if session.Modalities != nil {
+		b.session.Modalities = session.Modalities
+	}
+	b.session.Instructions = common.GetStringIfEmpty(session.Instructions, b.session.Instructions)
+	b.session.Voice = common.GetStringIfEmpty(session.Voice, b.session.Voice)
+	b.session.InputAudioFormat = common.GetStringIfEmpty(session.InputAudioFormat, b.session.InputAudioFormat)
+	b.session.OutputAudioFormat = common.GetStringIfEmpty(session.OutputAudioFormat, b.session.OutputAudioFormat)
+	if session.Tools != nil {
+		b.session.Tools = session.Tools
+	}
+	b.session.ToolChoice = common.GetStringIfEmpty(session.ToolChoice, b.session.ToolChoice)
+	if session.Temperature > 0 {
+		b.session.Temperature = session.Temperature
+	}
+	// "turn_detection": null switches to client-driven turns; an absent key keeps the default.
+	var probe struct {
+		Session map[string]json.RawMessage `json:"session"`
+	}
+	if err := common.Unmarshal(raw, &probe); err == nil {
+		if _, ok := probe.Session["turn_detection"]; ok {
+			b.session.TurnDetection = session.TurnDetection
+		}
+	}
+	if b.session.InputAudioFormat != "pcm16" || b.session.OutputAudioFormat != "pcm16" {
+		return errors.New("gemini live bridge only supports pcm16 audio")
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) sendSetup() error {
+	b.setupSent = true
+	b.manualActivity = b.session.TurnDetection == nil
+
+	modality := "TEXT"
+	for _, m := range b.session.Modalities {
+		if m == "audio" {
+			modality = "AUDIO"
+		}
+	}
+	setup := &dto.GeminiLiveSetup{
+		Model:            "models/" + b.info.UpstreamModelName,
+		GenerationConfig: &dto.GeminiLiveGenerationConfig{ResponseModalities: []string{modality}},
+	}
+	if b.session.Temperature > 0 {
+		temperature := b.session.Temperature
+		setup.GenerationConfig.Temperature = &temperature
+	}
+	if voice, ok := geminiLiveVoices[strings.ToLower(b.session.Voice)]; ok && modality == "AUDIO" {
+		setup.GenerationConfig.SpeechConfig = &dto.GeminiLiveSpeechConfig{
+			VoiceConfig: dto.GeminiLiveVoiceConfig{PrebuiltVoiceConfig: dto.GeminiLivePrebuiltVoice{VoiceName: voice}},
+		}
+	}
+	if b.session.Instructions != "" {
+		setup.SystemInstruction = &dto.GeminiChatContent{Parts: []dto.GeminiPart{{Text: b.session.Instructions}}}
+		b.addLocalUsage(service.CountTextToken(b.session.Instructions, b.info.UpstreamModelName), 0, false)
+	}
+	if len(b.session.Tools) > 0 {
+		declarations := make([]dto.GeminiLiveFunctionDeclaration, 0, len(b.session.Tools))
+		for _, tool := range b.session.Tools {
+			declarations = append(declarations, dto.GeminiLiveFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			})
+		}
+		setup.Tools = []dto.GeminiLiveTool{{FunctionDeclarations: declarations}}
+		b.info.RealtimeTools = b.session.Tools
+	}
+	if b.manualActivity {
+		setup.RealtimeInputConfig = &dto.GeminiLiveRealtimeInputConfig{
+			AutomaticActivityDetection: &dto.GeminiLiveActivityDetection{Disabled: true},
+		}
+	}
+	setup.InputAudioTranscription = &struct{}{}
+	if modality == "AUDIO" {
+		setup.OutputAudioTranscription = &struct{}{}
+	}
+	return b.sendUpstream(&dto.GeminiLiveClientMessage{Setup: setup})
+}
+
+func (b *geminiLiveBridge) ensureResponse() error {
+	if b.responseId != "" {
+		return nil
+	}
+	b.responseId = b.writer.NewId("resp")
+	b.itemId = b.writer.NewId("item")
+	return b.writer.Send(&dto.RealtimeEvent{
+		Type:     dto.RealtimeEventResponseCreated,
+		Response: &dto.RealtimeResponse{Id: b.responseId, Object: "realtime.response", Status: "in_progress"},
+	})
+}
+
+func (b *geminiLiveBridge) handleServerMessage(message *dto.GeminiLiveServerMessage) error {
+	if message.UsageMetadata != nil {
+		if err := b.consumeUpstreamUsage(message.UsageMetadata); err != nil {
+			_ = b.writer.SendError("insufficient_quota", err.Error())
+			return err
+		}
+	}
+	if message.GoAway != nil {
+		logger.LogWarn(b.c, "gemini live: upstream will close the session in "+message.GoAway.TimeLeft)
+	}
+	if content := message.ServerContent; content != nil {
+		if content.InputTranscription != nil {
+			b.inputTranscript.WriteString(content.InputTranscription.Text)
+		}
+		if content.ModelTurn != nil {
+			for _, part := range content.ModelTurn.Parts {
+				if err := b.handleModelPart(part); err != nil {
+					return err
+				}
+			}
+		}
+		if content.OutputTranscription != nil && content.OutputTranscription.Text != "" {
+			if err := b.ensureResponse(); err != nil {
+				return err
+			}
+			b.transcript.WriteString(content.OutputTranscription.Text)
+			if err := b.writer.Send(&dto.RealtimeEvent{
+				Type:       dto.RealtimeEventResponseAudioTranscriptionDelta,
+				ResponseId: b.responseId,
+				ItemId:     b.itemId,
+				Delta:      content.OutputTranscription.Text,
+			}); err != nil {
+				return err
+			}
+		}
+		if content.Interrupted {
+			return b.finishResponse("cancelled")
+		}
+		if content.TurnComplete {
+			return b.finishResponse("completed")
+		}
+	}
+	if message.ToolCall != nil && len(message.ToolCall.FunctionCalls) > 0 {
+		if err := b.ensureResponse(); err != nil {
+			return err
+		}
+		for _, call := range message.ToolCall.FunctionCalls {
+			arguments, _ := common.Marshal(call.Args)
+			b.mu.Lock()
+			b.callNames[call.Id] = call.Name
+			b.mu.Unlock()
+			b.addLocalUsage(service.CountTextToken(string(arguments), b.info.UpstreamModelName), 0, true)
+			if err := b.writer.Send(&dto.RealtimeEvent{
+				Type:       dto.RealtimeEventResponseFunctionCallArgumentsDone,
+				ResponseId: b.responseId,
+				ItemId:     b.writer.NewId("item"),
+				CallId:     call.Id,
+				Name:       call.Name,
+				Arguments:  string(arguments),
+			}); err != nil {
+				return err
+			}
+		}
+		// Gemini now waits for the tool response, which ends the OpenAI response.
+		return b.finishResponse("completed")
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) handleModelPart(part dto.GeminiPart) error {
+	if part.Thought {
+		return nil
+	}
+	if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "audio/") {
+		if err := b.ensureResponse(); err != nil {
+			return err
+		}
+		b.hasAudio = true
+		if audioTokens, err := service.CountAudioTokenOutput(part.InlineData.Data, b.info.OutputAudioFormat); err == nil {
+			b.addLocalUsage(0, audioTokens, true)
+		}
+		return b.writer.Send(&dto.RealtimeEvent{
+			Type:       dto.RealtimeEventResponseAudioDelta,
+			ResponseId: b.responseId,
+			ItemId:     b.itemId,
+			Delta:      part.InlineData.Data,
+		})
+	}
+	if part.Text == "" {
+		return nil
+	}
+	if err := b.ensureResponse(); err != nil {
+		return err
+	}
+	b.text.WriteString(part.Text)
+	b.addLocalUsage(service.CountTextToken(part.Text, b.info.UpstreamModelName), 0, true)
+	return b.writer.Send(&dto.RealtimeEvent{
+		Type:       dto.RealtimeEventResponseTextDelta,
+		ResponseId: b.responseId,
+		ItemId:     b.itemId,
+		Delta:      part.Text,
+	})
+}
+
+func (b *geminiLiveBridge) consumeUpstreamUsage(metadata *dto.GeminiLiveUsageMetadata) error {
+	usage := &dto.RealtimeUsage{
+		TotalTokens:  metadata.TotalTokenCount,
+		InputTokens:  metadata.PromptTokenCount,
+		OutputTokens: metadata.ResponseTokenCount,
+	}
+	inputAudio, outputAudio := 0, 0
+	for _, detail := range metadata.PromptTokensDetails {
+		if detail.Modality == "AUDIO" {
+			inputAudio += detail.TokenCount
+		}
+	}
+	for _, detail := range metadata.ResponseTokensDetails {
+		if detail.Modality == "AUDIO" {
+			outputAudio += detail.TokenCount
+		}
+	}
+	usage.InputTokenDetails.AudioTokens = inputAudio
+	usage.InputTokenDetails.TextTokens = metadata.PromptTokenCount - inputAudio
+	usage.OutputTokenDetails.AudioTokens = outputAudio
+	usage.OutputTokenDetails.TextTokens = metadata.ResponseTokenCount - outputAudio
+	if usage.TotalTokens == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.upstreamUsageSeen = true
+	b.turnUsage.Add(usage)
+	b.sumUsage.Add(usage)
+	return service.PreWssConsumeQuota(b.c, b.info, usage)
+}
+
+func (b *geminiLiveBridge) finishResponse(status string) error {
+	if b.responseId == "" {
+		return nil
+	}
+	if b.text.Len() > 0 {
+		if err := b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseTextDone, ResponseId: b.responseId, ItemId: b.itemId, Text: b.text.String()}); err != nil {
+			return err
+		}
+	}
+	if b.transcript.Len() > 0 {
+		if err := b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseAudioTranscriptionDone, ResponseId: b.responseId, ItemId: b.itemId, Transcript: b.transcript.String()}); err != nil {
+			return err
+		}
+	}
+	if b.hasAudio {
+		if err := b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseAudioDone, ResponseId: b.responseId, ItemId: b.itemId}); err != nil {
+			return err
+		}
+	}
+	if b.inputTranscript.Len() > 0 {
+		if err := b.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioTranscriptionCompleted, ItemId: b.inputItemId, Transcript: b.inputTranscript.String()}); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	usage := b.turnUsage
+	b.turnUsage = &dto.RealtimeUsage{}
+	b.mu.Unlock()
+	err := b.writer.Send(&dto.RealtimeEvent{
+		Type:     dto.RealtimeEventTypeResponseDone,
+		Response: &dto.RealtimeResponse{Id: b.responseId, Object: "realtime.response", Status: status, Usage: usage},
+	})
+
+	b.responseId = ""
+	b.itemId = ""
+	b.text.Reset()
+	b.transcript.Reset()
+	b.inputTranscript.Reset()
+	b.hasAudio = false
+	return err
+}
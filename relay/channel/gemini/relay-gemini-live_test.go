@@ -0,0 +1,285 @@
+package gemini
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// newWsPair returns both ends of a websocket connection.
+func newWsPair(t *testing.T) (server *websocket.Conn, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server = <-conns
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return server, client
+}
+
+func readWsJSON(t *testing.T, ws *websocket.Conn, v any) {
+	t.Helper()
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := common.Unmarshal(message, v); err != nil {
+		t.Fatalf("decode %s: %v", message, err)
+	}
+}
+
+// newTestBridge wires a bridge between a fake client and a fake Gemini
+// upstream and returns the test's ends of both connections.
+func newTestBridge(t *testing.T) (b *geminiLiveBridge, client *websocket.Conn, upstream *websocket.Conn) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	service.InitTokenEncoders()
+	clientServer, client := newWsPair(t)
+	upstream, target := newWsPair(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(common.RequestIdKey, "test")
+	info := &relaycommon.RelayInfo{
+		ChannelMeta:       &relaycommon.ChannelMeta{UpstreamModelName: "gemini-2.0-flash-live-001"},
+		InputAudioFormat:  "pcm16",
+		OutputAudioFormat: "pcm16",
+	}
+	b = &geminiLiveBridge{
+		c:      c,
+		info:   info,
+		writer: helper.NewRealtimeEventWriter(c, clientServer),
+		target: target,
+		session: dto.RealtimeSession{
+			Modalities:        []string{"text", "audio"},
+			InputAudioFormat:  "pcm16",
+			OutputAudioFormat: "pcm16",
+			TurnDetection:     map[string]any{"type": "server_vad"},
+		},
+		callNames:  make(map[string]string),
+		turnUsage:  &dto.RealtimeUsage{},
+		localUsage: &dto.RealtimeUsage{},
+		sumUsage:   &dto.RealtimeUsage{},
+	}
+	return b, client, upstream
+}
+
+func TestGeminiLiveClientEvents(t *testing.T) {
+	b, client, upstream := newTestBridge(t)
+
+	raw := []byte(`{"type":"session.update","session":{"voice":"kore","instructions":"Be brief.","turn_detection":null,` +
+		`"tools":[{"type":"function","name":"get_weather","description":"Weather","parameters":{"type":"object"}}]}}`)
+	event := &dto.RealtimeEvent{}
+	if err := common.Unmarshal(raw, event); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleClientEvent(event, raw); err != nil {
+		t.Fatalf("session.update: %v", err)
+	}
+	var updated dto.RealtimeEvent
+	readWsJSON(t, client, &updated)
+	if updated.Type != dto.RealtimeEventTypeSessionUpdated || updated.Session.Voice != "kore" {
+		t.Fatalf("unexpected client event: %+v", updated)
+	}
+	var setup dto.GeminiLiveClientMessage
+	readWsJSON(t, upstream, &setup)
+	if setup.Setup == nil {
+		t.Fatalf("expected setup, got %+v", setup)
+	}
+	s := setup.Setup
+	if s.Model != "models/gemini-2.0-flash-live-001" || s.GenerationConfig.ResponseModalities[0] != "AUDIO" ||
+		s.GenerationConfig.SpeechConfig.VoiceConfig.PrebuiltVoiceConfig.VoiceName != "Kore" ||
+		s.SystemInstruction.Parts[0].Text != "Be brief." || s.Tools[0].FunctionDeclarations[0].Name != "get_weather" {
+		t.Fatalf("unexpected setup: %+v", s)
+	}
+	// "turn_detection": null hands turn taking to the client
+	if s.RealtimeInputConfig == nil || !s.RealtimeInputConfig.AutomaticActivityDetection.Disabled {
+		t.Fatalf("expected automatic activity detection to be disabled: %+v", s.RealtimeInputConfig)
+	}
+
+	// with client-driven turns, audio opens an activity and commit closes it
+	if err := b.handleClientEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferAppend, Audio: "AAAA"}, nil); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	var start, audio, end dto.GeminiLiveClientMessage
+	readWsJSON(t, upstream, &start)
+	readWsJSON(t, upstream, &audio)
+	if start.RealtimeInput == nil || start.RealtimeInput.ActivityStart == nil {
+		t.Fatalf("expected activityStart, got %+v", start)
+	}
+	if audio.RealtimeInput == nil || audio.RealtimeInput.Audio.MimeType != geminiLiveInputMimeType || audio.RealtimeInput.Audio.Data != "AAAA" {
+		t.Fatalf("unexpected audio input: %+v", audio)
+	}
+	if err := b.handleClientEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCommit}, nil); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	readWsJSON(t, upstream, &end)
+	if end.RealtimeInput == nil || end.RealtimeInput.ActivityEnd == nil {
+		t.Fatalf("expected activityEnd, got %+v", end)
+	}
+	var committed dto.RealtimeEvent
+	readWsJSON(t, client, &committed)
+	if committed.Type != dto.RealtimeEventInputAudioBufferCommitted || committed.ItemId == "" {
+		t.Fatalf("unexpected client event: %+v", committed)
+	}
+
+	// text items are queued and sent as one turn on response.create
+	if err := b.handleClientEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeConversationCreate, Item: &dto.RealtimeItem{
+		Type: "message", Role: "user", Content: []dto.RealtimeContent{{Type: "input_text", Text: "Hi"}},
+	}}, nil); err != nil {
+		t.Fatalf("conversation.item.create: %v", err)
+	}
+	var created dto.RealtimeEvent
+	readWsJSON(t, client, &created)
+	if created.Type != dto.RealtimeEventConversationItemCreated {
+		t.Fatalf("unexpected client event: %+v", created)
+	}
+	if err := b.handleClientEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseCreate}, nil); err != nil {
+		t.Fatalf("response.create: %v", err)
+	}
+	var content dto.GeminiLiveClientMessage
+	readWsJSON(t, upstream, &content)
+	if content.ClientContent == nil || !content.ClientContent.TurnComplete || len(content.ClientContent.Turns) != 1 ||
+		content.ClientContent.Turns[0].Role != "user" || content.ClientContent.Turns[0].Parts[0].Text != "Hi" {
+		t.Fatalf("unexpected client content: %+v", content)
+	}
+
+	// Gemini Live cannot reconfigure a running session
+	if err := b.handleClientEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdate, Session: &dto.RealtimeSession{Voice: "puck"}}, nil); err != nil {
+		t.Fatalf("late session.update: %v", err)
+	}
+	readWsJSON(t, client, &updated)
+	if updated.Type != dto.RealtimeEventTypeSessionUpdated || updated.Session.Voice != "kore" {
+		t.Fatalf("late session.update changed the session: %+v", updated)
+	}
+}
+
+func TestGeminiLiveRejectsNonPcmAudio(t *testing.T) {
+	b, _, _ := newTestBridge(t)
+	raw := []byte(`{"type":"session.update","session":{"input_audio_format":"g711_ulaw"}}`)
+	event := &dto.RealtimeEvent{}
+	if err := common.Unmarshal(raw, event); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.mergeSession(event.Session, raw); err == nil {
+		t.Fatal("expected g711 audio to be refused")
+	}
+}
+
+func TestGeminiLiveServerMessages(t *testing.T) {
+	b, client, upstream := newTestBridge(t)
+	b.setupSent = true
+
+	readEvents := func(n int) []dto.RealtimeEvent {
+		events := make([]dto.RealtimeEvent, n)
+		for i := range events {
+			readWsJSON(t, client, &events[i])
+		}
+		return events
+	}
+	eventTypes := func(events []dto.RealtimeEvent) string {
+		types := make([]string, len(events))
+		for i, event := range events {
+			types[i] = event.Type
+		}
+		return strings.Join(types, ",")
+	}
+
+	// a text turn, with thoughts dropped
+	if err := b.handleServerMessage(&dto.GeminiLiveServerMessage{ServerContent: &dto.GeminiLiveServerContent{
+		ModelTurn: &dto.GeminiChatContent{Role: "model", Parts: []dto.GeminiPart{{Text: "thinking", Thought: true}, {Text: "Hello"}}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleServerMessage(&dto.GeminiLiveServerMessage{ServerContent: &dto.GeminiLiveServerContent{
+		ModelTurn:    &dto.GeminiChatContent{Role: "model", Parts: []dto.GeminiPart{{Text: " there"}}},
+		TurnComplete: true,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	events := readEvents(5)
+	want := strings.Join([]string{dto.RealtimeEventResponseCreated, dto.RealtimeEventResponseTextDelta, dto.RealtimeEventResponseTextDelta,
+		dto.RealtimeEventResponseTextDone, dto.RealtimeEventTypeResponseDone}, ",")
+	if got := eventTypes(events); got != want {
+		t.Fatalf("events = %s, want %s", got, want)
+	}
+	responseId := events[0].Response.Id
+	if events[1].ResponseId != responseId || events[3].Text != "Hello there" ||
+		events[4].Response.Id != responseId || events[4].Response.Status != "completed" {
+		t.Fatalf("unexpected text turn: %+v", events)
+	}
+	if b.localUsage.OutputTokenDetails.TextTokens == 0 {
+		t.Fatal("expected the text to be counted locally")
+	}
+
+	// a tool call ends the response; the tool output is answered by name
+	if err := b.handleServerMessage(&dto.GeminiLiveServerMessage{ToolCall: &dto.GeminiLiveToolCall{
+		FunctionCalls: []dto.GeminiLiveFunctionCall{{Id: "call-1", Name: "get_weather", Args: map[string]any{"city": "Paris"}}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	events = readEvents(3)
+	if events[1].Type != dto.RealtimeEventResponseFunctionCallArgumentsDone || events[1].CallId != "call-1" ||
+		events[1].Name != "get_weather" || events[1].Arguments != `{"city":"Paris"}` || events[2].Type != dto.RealtimeEventTypeResponseDone {
+		t.Fatalf("unexpected tool call events: %+v", events)
+	}
+	if err := b.handleClientEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeConversationCreate, Item: &dto.RealtimeItem{
+		Type: "function_call_output", CallId: "call-1", Output: `{"temperature":21}`,
+	}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var toolResponse dto.GeminiLiveClientMessage
+	readWsJSON(t, upstream, &toolResponse)
+	if toolResponse.ToolResponse == nil || len(toolResponse.ToolResponse.FunctionResponses) != 1 {
+		t.Fatalf("expected a tool response, got %+v", toolResponse)
+	}
+	response := toolResponse.ToolResponse.FunctionResponses[0]
+	if response.Id != "call-1" || response.Name != "get_weather" || response.Response["temperature"] != float64(21) {
+		t.Fatalf("unexpected tool response: %+v", response)
+	}
+	readEvents(1)
+
+	// audio with transcripts, then an interruption
+	if err := b.handleServerMessage(&dto.GeminiLiveServerMessage{ServerContent: &dto.GeminiLiveServerContent{
+		InputTranscription:  &dto.GeminiLiveTranscription{Text: "what's up"},
+		ModelTurn:           &dto.GeminiChatContent{Role: "model", Parts: []dto.GeminiPart{{InlineData: &dto.GeminiInlineData{MimeType: "audio/pcm;rate=24000", Data: "AAAA"}}}},
+		OutputTranscription: &dto.GeminiLiveTranscription{Text: "not much"},
+		Interrupted:         true,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	events = readEvents(7)
+	want = strings.Join([]string{dto.RealtimeEventResponseCreated, dto.RealtimeEventResponseAudioDelta, dto.RealtimeEventResponseAudioTranscriptionDelta,
+		dto.RealtimeEventResponseAudioTranscriptionDone, dto.RealtimeEventResponseAudioDone, dto.RealtimeEventInputAudioTranscriptionCompleted,
+		dto.RealtimeEventTypeResponseDone}, ",")
+	if got := eventTypes(events); got != want {
+		t.Fatalf("events = %s, want %s", got, want)
+	}
+	if events[1].Delta != "AAAA" || events[3].Transcript != "not much" || events[5].Transcript != "what's up" || events[6].Response.Status != "cancelled" {
+		t.Fatalf("unexpected audio turn: %+v", events)
+	}
+}
@@ -0,0 +1,56 @@
+package common
+
+import "time"
+
+// FundingDraw is the quota a request holds from one funding source. Id is the
+// user package or plan assignment the quota was drawn from; it is 0 for the
+// balance.
+type FundingDraw struct {
+	Source string `json:"source"`
+	Id     int    `json:"id,omitempty"`
+	Quota  int    `json:"quota"`
+
+	// Usage counter of a plan draw, needed to return it.
+	Metric     string    `json:"-"`
+	CycleStart time.Time `json:"-"`
+	CycleEnd   time.Time `json:"-"`
+}
+
+// FundingLedger records, in order, the funding sources a request was charged
+// from, so refunds can be returned to each of them.
+type FundingLedger struct {
+	Waterfall []string
+	Draws     []*FundingDraw
+}
+
+// Add records quota drawn from a source, merging it into an earlier draw from
+// the same source.
+func (l *FundingLedger) Add(draw FundingDraw) {
+	for _, d := range l.Draws {
+		if d.Source == draw.Source && d.Id == draw.Id && d.CycleStart.Equal(draw.CycleStart) {
+			d.Quota += draw.Quota
+			return
+		}
+	}
+	l.Draws = append(l.Draws, &draw)
+}
+
+// Total is the quota currently held from all sources.
+func (l *FundingLedger) Total() int {
+	total := 0
+	for _, d := range l.Draws {
+		total += d.Quota
+	}
+	return total
+}
+
+// Breakdown lists the draws still holding quota, for the consume log.
+func (l *FundingLedger) Breakdown() []FundingDraw {
+	breakdown := make([]FundingDraw, 0, len(l.Draws))
+	for _, d := range l.Draws {
+		if d.Quota > 0 {
+			breakdown = append(breakdown, *d)
+		}
+	}
+	return breakdown
+}
@@ -109,12 +109,21 @@ type RelayInfo struct {
     FinalPreConsumedQuota  int  // 最终预消耗的配额
     IsClaudeBetaQuery      bool // /v1/messages?beta=true
 
+    // OrganizationId is set for organization tokens, whose requests are
+    // charged to the organization's quota pool
+    OrganizationId int
+
     // Billing context snapshot for logging and UI
     BillingFeatureEnabled bool
     BillingMode           string
     PlanAssignmentId      int
     PlanLabel             string
 
+    // TokenFundingWaterfall is the token's funding waterfall override;
+    // Funding records the quota drawn from each funding source
+    TokenFundingWaterfall string
+    Funding               *FundingLedger
+
     PriceData types.PriceData
 
     Request dto.Request
@@ -359,6 +368,31 @@ func GenRelayInfoImage(c *gin.Context, request dto.Request) *RelayInfo {
     return info
 }
 
+// GenRelayInfoOllama builds the info for an Ollama/llama.cpp ingress request
+// that was already converted to OpenAI shape; channels see a plain chat
+// completions or embeddings request and the Ollama response shape is restored
+// by the relay's response writer.
+func GenRelayInfoOllama(c *gin.Context, request dto.Request) *RelayInfo {
+    info := genBaseRelayInfo(c, request)
+    if _, ok := request.(*dto.EmbeddingRequest); ok {
+        info.RelayMode = relayconstant.RelayModeEmbeddings
+        info.RelayFormat = types.RelayFormatEmbedding
+        info.RequestURLPath = "/v1/embeddings"
+    } else {
+        info.RelayMode = relayconstant.RelayModeChatCompletions
+        info.RelayFormat = types.RelayFormatOpenAI
+        info.RequestURLPath = "/v1/chat/completions"
+    }
+    return info
+}
+
+func GenRelayInfoModeration(c *gin.Context, request dto.Request) *RelayInfo {
+    info := genBaseRelayInfo(c, request)
+    info.RelayMode = relayconstant.RelayModeModerations
+    info.RelayFormat = types.RelayFormatModeration
+    return info
+}
+
 func GenRelayInfoOpenAI(c *gin.Context, request dto.Request) *RelayInfo {
     info := genBaseRelayInfo(c, request)
     info.RelayFormat = types.RelayFormatOpenAI
@@ -399,6 +433,9 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
         TokenId:        common.GetContextKeyInt(c, constant.ContextKeyTokenId),
         TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
         TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
+        OrganizationId: common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId),
+
+        TokenFundingWaterfall: common.GetContextKeyString(c, constant.ContextKeyTokenFundingWaterfall),
 
         isFirstResponse: true,
         RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
@@ -464,6 +501,10 @@ func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Req
         return GenRelayInfoGemini(c, request), nil
     case types.RelayFormatEmbedding:
         return GenRelayInfoEmbedding(c, request), nil
+    case types.RelayFormatOllama:
+        return GenRelayInfoOllama(c, request), nil
+    case types.RelayFormatModeration:
+        return GenRelayInfoModeration(c, request), nil
     case types.RelayFormatOpenAIResponses:
         if request, ok := request.(*dto.OpenAIResponsesRequest); ok {
             return GenRelayInfoResponses(c, request), nil
@@ -14,8 +14,10 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
 	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
@@ -33,10 +35,37 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected dto.GeneralOpenAIRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
 	}
 
+	// 服务端工具：由网关执行工具调用并循环，直到模型给出最终回答
+	if info.RelayMode == relayconstant.RelayModeChatCompletions && common.GetContextKeyInt(c, constant.ContextKeyServerToolTurn) == 0 {
+		if tools := service.ServerToolsForRequest(info.OriginModelName, textReq); len(tools) > 0 {
+			return serverToolLoop(c, info, textReq, tools)
+		}
+	}
+
+	// 结构化输出：校验 response_format 的结果，不符合时按配置修复、换渠道或拒绝
+	if info.RelayMode == relayconstant.RelayModeChatCompletions && config.GetStructuredOutputConfig().Enabled &&
+		common.GetContextKeyInt(c, constant.ContextKeyStructuredOutputAttempt) == 0 {
+		if schema, mode := structuredOutputSchema(textReq); schema != nil {
+			return structuredOutputLoop(c, info, textReq, schema, mode)
+		}
+	}
+
 	request, err := common.DeepCopy(textReq)
 	if err != nil {
 		return types.NewError(fmt.Errorf("failed to copy request to GeneralOpenAIRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
 	}
+	request.ServerTools = nil
+
+	// 隐私信息：按分组策略脱敏或令牌化，响应中的令牌在返回客户端前还原
+	if vault := service.RedactPIIMessages(c, request.Messages); vault != nil {
+		original := c.Writer
+		writer := newPIIWriter(original, vault)
+		c.Writer = writer
+		defer func() {
+			writer.finish()
+			c.Writer = original
+		}()
+	}
 
 	if request.WebSearchOptions != nil {
 		c.Set("chat_completion_web_search_context_size", request.WebSearchOptions.SearchContextSize)
@@ -75,6 +104,10 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	var requestBody io.Reader
 
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+		// 透传会把未脱敏的原始请求体发往上游
+		if service.PIIRedactionRequired(c) {
+			return types.NewErrorWithStatusCode(fmt.Errorf("personal data redaction is not available on pass-through channels"), types.ErrorCodePIIRedactionUnsupported, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
@@ -414,6 +447,19 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		other["image_ratio"] = imageRatio
 		other["image_output"] = imageTokens
 	}
+	if turn := common.GetContextKeyInt(ctx, constant.ContextKeyServerToolTurn); turn > 0 {
+		other["request_id"] = ctx.GetString(common.RequestIdKey)
+		other["server_tool_turn"] = turn
+	}
+	if structuredOutput := structuredOutputLogInfo(ctx); structuredOutput != nil {
+		other["structured_output"] = structuredOutput
+	}
+	if piiInfo := service.PIILogInfo(ctx); piiInfo != nil {
+		other["pii"] = piiInfo
+	}
+	if outputModeration := service.OutputModerationLogInfo(ctx); outputModeration != nil {
+		other["output_moderation"] = outputModeration
+	}
 	if cachedCreationTokens != 0 {
 		other["cache_creation_tokens"] = cachedCreationTokens
 		other["cache_creation_ratio"] = cachedCreationRatio
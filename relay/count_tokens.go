@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrCountTokensUnsupported = errors.New("channel does not support token counting")
+
+// CountTokensUpstream forwards a count tokens request to the selected channel.
+// Only Anthropic channels for the Claude format and Gemini channels for the
+// Gemini format are supported; ErrCountTokensUnsupported is returned otherwise.
+func CountTokensUpstream(c *gin.Context, info *relaycommon.RelayInfo) (int, error) {
+	var fullRequestURL string
+	switch {
+	case info.RelayFormat == types.RelayFormatClaude && info.ApiType == constant.APITypeAnthropic:
+		fullRequestURL = fmt.Sprintf("%s/v1/messages/count_tokens", info.ChannelBaseUrl)
+	case info.RelayFormat == types.RelayFormatGemini && info.ApiType == constant.APITypeGemini:
+		version := model_setting.GetGeminiVersionSetting(info.UpstreamModelName)
+		fullRequestURL = fmt.Sprintf("%s/%s/models/%s:countTokens", info.ChannelBaseUrl, version, info.UpstreamModelName)
+	default:
+		return 0, ErrCountTokensUnsupported
+	}
+
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return 0, err
+	}
+	body := map[string]any{}
+	if err := common.Unmarshal(requestBody, &body); err != nil {
+		return 0, err
+	}
+	if info.RelayFormat == types.RelayFormatClaude {
+		body["model"] = info.UpstreamModelName
+	} else if generateContentRequest, ok := body["generateContentRequest"].(map[string]any); ok {
+		generateContentRequest["model"] = "models/" + info.UpstreamModelName
+	}
+	data, err := common.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return 0, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, fullRequestURL, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if err := adaptor.SetupRequestHeader(c, &req.Header, info); err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := service.GetHttpClient()
+	if info.ChannelSetting.Proxy != "" {
+		client, err = service.NewProxyHttpClient(info.ChannelSetting.Proxy)
+		if err != nil {
+			return 0, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, service.RelayErrorHandler(c.Request.Context(), resp, false)
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		InputTokens int `json:"input_tokens"`
+		TotalTokens int `json:"totalTokens"`
+	}
+	if err := common.Unmarshal(responseBody, &result); err != nil {
+		return 0, err
+	}
+	if info.RelayFormat == types.RelayFormatClaude {
+		return result.InputTokens, nil
+	}
+	return result.TotalTokens, nil
+}
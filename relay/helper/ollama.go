@@ -0,0 +1,84 @@
+package helper
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ollama-native and llama.cpp ingress endpoints.
+const (
+	OllamaEndpointChat            = "chat"
+	OllamaEndpointGenerate        = "generate"
+	OllamaEndpointEmbed           = "embed"
+	OllamaEndpointEmbeddings      = "embeddings"
+	OllamaEndpointLlamaCompletion = "completion"
+)
+
+func OllamaEndpoint(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/api/chat"):
+		return OllamaEndpointChat
+	case strings.HasSuffix(path, "/api/generate"):
+		return OllamaEndpointGenerate
+	case strings.HasSuffix(path, "/api/embed"):
+		return OllamaEndpointEmbed
+	case strings.HasSuffix(path, "/api/embeddings"):
+		return OllamaEndpointEmbeddings
+	case strings.HasSuffix(path, "/completion"):
+		return OllamaEndpointLlamaCompletion
+	}
+	return ""
+}
+
+// GetAndValidateOllamaRequest converts an Ollama or llama.cpp request into the
+// equivalent OpenAI request and replaces the cached body with it, so retries
+// and pass-through channels see the converted request.
+func GetAndValidateOllamaRequest(c *gin.Context) (dto.Request, error) {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, err
+	}
+	var request dto.Request
+	switch OllamaEndpoint(c.Request.URL.Path) {
+	case OllamaEndpointChat:
+		var chat dto.OllamaChatRequest
+		if err := common.Unmarshal(body, &chat); err != nil {
+			return nil, err
+		}
+		request, err = chat.ToOpenAIRequest()
+	case OllamaEndpointGenerate:
+		var generate dto.OllamaGenerateRequest
+		if err := common.Unmarshal(body, &generate); err != nil {
+			return nil, err
+		}
+		request, err = generate.ToOpenAIRequest()
+	case OllamaEndpointEmbed, OllamaEndpointEmbeddings:
+		var embed dto.OllamaEmbedRequest
+		if err := common.Unmarshal(body, &embed); err != nil {
+			return nil, err
+		}
+		request, err = embed.ToEmbeddingRequest()
+	case OllamaEndpointLlamaCompletion:
+		var completion dto.LlamaCppCompletionRequest
+		if err := common.Unmarshal(body, &completion); err != nil {
+			return nil, err
+		}
+		request, err = completion.ToOpenAIRequest()
+	default:
+		return nil, errors.New("unsupported ollama endpoint: " + c.Request.URL.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	converted, err := common.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(common.KeyRequestBody, converted)
+	return request, nil
+}
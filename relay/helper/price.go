@@ -4,7 +4,10 @@ import (
 	"fmt"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/config"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
@@ -45,6 +48,14 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 
 func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, meta *types.TokenCountMeta) (types.PriceData, error) {
 	modelPrice, usePrice := ratio_setting.GetModelPrice(info.OriginModelName, false)
+	// 审核接口按输入条数计费
+	if info.RelayMode == relayconstant.RelayModeModerations {
+		if request, ok := info.Request.(*dto.ModerationRequest); ok {
+			if price, ok := config.GetModerationConfig().PriceFor(info.OriginModelName); ok {
+				modelPrice, usePrice = price*float64(len(request.ParseInput())), true
+			}
+		}
+	}
 
 	groupRatioInfo := HandleGroupRatio(c, info)
 
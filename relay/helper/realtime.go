@@ -0,0 +1,53 @@
+package helper
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// RealtimeEventWriter emits OpenAI Realtime server events to the client on
+// behalf of the realtime bridges. Writes are serialised because both the
+// client and upstream readers produce events.
+type RealtimeEventWriter struct {
+	c   *gin.Context
+	ws  *websocket.Conn
+	mu  sync.Mutex
+	seq int64
+}
+
+func NewRealtimeEventWriter(c *gin.Context, ws *websocket.Conn) *RealtimeEventWriter {
+	return &RealtimeEventWriter{c: c, ws: ws}
+}
+
+// NewId returns a request-scoped identifier such as resp_<request id>_3.
+func (w *RealtimeEventWriter) NewId(prefix string) string {
+	return fmt.Sprintf("%s_%s_%d", prefix, w.c.GetString(common.RequestIdKey), atomic.AddInt64(&w.seq, 1))
+}
+
+func (w *RealtimeEventWriter) Send(event *dto.RealtimeEvent) error {
+	if event.EventId == "" {
+		event.EventId = w.NewId("evt")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return WssObject(w.c, w.ws, event)
+}
+
+func (w *RealtimeEventWriter) SendError(code string, message string) error {
+	return w.Send(&dto.RealtimeEvent{
+		Type: dto.RealtimeEventTypeError,
+		Error: &types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
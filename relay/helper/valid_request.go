@@ -42,6 +42,10 @@ func GetAndValidateRequest(c *gin.Context, format types.RelayFormat) (request dt
 		request, err = GetAndValidAudioRequest(c, relayMode)
 	case types.RelayFormatOpenAIRealtime:
 		request = &dto.BaseRequest{}
+	case types.RelayFormatOllama:
+		request, err = GetAndValidateOllamaRequest(c)
+	case types.RelayFormatModeration:
+		request, err = GetAndValidateModerationRequest(c)
 	default:
 		return nil, fmt.Errorf("unsupported relay format: %s", format)
 	}
@@ -117,6 +121,21 @@ func GetAndValidateEmbeddingRequest(c *gin.Context, relayMode int) (*dto.Embeddi
 	return embeddingRequest, nil
 }
 
+func GetAndValidateModerationRequest(c *gin.Context) (*dto.ModerationRequest, error) {
+	request := &dto.ModerationRequest{}
+	err := common.UnmarshalBodyReusable(c, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.ParseInput()) == 0 {
+		return nil, errors.New("field input is required")
+	}
+	if request.Model == "" {
+		request.Model = "omni-moderation-latest"
+	}
+	return request, nil
+}
+
 func GetAndValidateResponsesRequest(c *gin.Context) (*dto.OpenAIResponsesRequest, error) {
 	request := &dto.OpenAIResponsesRequest{}
 	err := common.UnmarshalBodyReusable(c, request)
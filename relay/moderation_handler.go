@@ -0,0 +1,185 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	governanceSvc "github.com/QuantumNous/new-api/service/governance"
+	"github.com/QuantumNous/new-api/service/moderation"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModerationHelper answers /v1/moderations from the gateway's own detectors,
+// from a chat model used as a classifier, or from the channel's moderation
+// endpoint, always in the OpenAI moderation response shape.
+func ModerationHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.NewAPIError) {
+	info.InitChannelMeta(c)
+
+	request, ok := info.Request.(*dto.ModerationRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected dto.ModerationRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	cfg := config.GetModerationConfig()
+	inputs := request.ParseInput()
+
+	switch {
+	case cfg.IsLocalModel(info.OriginModelName):
+		results := make([]moderation.Result, 0, len(inputs))
+		for _, input := range inputs {
+			results = append(results, moderation.NewResult(localModerationScores(input), cfg.Threshold))
+		}
+		usage := &dto.Usage{PromptTokens: info.PromptTokens, TotalTokens: info.PromptTokens}
+		return writeModerationResponse(c, info, request.Model, results, usage, "本地规则")
+	case cfg.IsClassifierModel(info.OriginModelName):
+		results := make([]moderation.Result, 0, len(inputs))
+		usage := &dto.Usage{}
+		for _, input := range inputs {
+			scores, inputUsage, newAPIError := classifyModerationInput(c, info, request.Model, input)
+			if newAPIError != nil {
+				return newAPIError
+			}
+			results = append(results, moderation.NewResult(scores, cfg.Threshold))
+			usage.PromptTokens += inputUsage.PromptTokens
+			usage.CompletionTokens += inputUsage.CompletionTokens
+			usage.TotalTokens += inputUsage.TotalTokens
+		}
+		return writeModerationResponse(c, info, request.Model, results, usage, "分类模型")
+	}
+
+	// 其余模型转发到渠道自身的审核接口
+	info.Request = &dto.GeneralOpenAIRequest{Model: request.Model, Input: request.Input}
+	info.RelayFormat = types.RelayFormatOpenAI
+	defer func() {
+		info.Request = request
+		info.RelayFormat = types.RelayFormatModeration
+	}()
+	return TextHelper(c, info)
+}
+
+// localModerationScores flags an input hitting the sensitive word list or
+// the governance violation keywords under the configured local category.
+func localModerationScores(input string) map[string]float64 {
+	category := config.GetModerationConfig().LocalCategory
+	if !moderation.IsCategory(category) {
+		category = "illicit"
+	}
+	if result := governanceSvc.DetectKeywordPolicy(input); result.Triggered {
+		return map[string]float64{category: 1}
+	}
+	return nil
+}
+
+// classifyModerationInput asks the channel's chat model to score one input.
+func classifyModerationInput(c *gin.Context, info *relaycommon.RelayInfo, modelName string, input string) (map[string]float64, *dto.Usage, *types.NewAPIError) {
+	system := dto.Message{Role: "system"}
+	system.SetStringContent(moderation.ClassifierPrompt())
+	user := dto.Message{Role: "user"}
+	user.SetStringContent(input)
+	temperature := 0.0
+	chatRequest := &dto.GeneralOpenAIRequest{
+		Model:       modelName,
+		Messages:    []dto.Message{system, user},
+		Temperature: &temperature,
+	}
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, "/v1/chat/completions", bytes.NewReader(nil))
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	ctx.Request = req
+	for key, value := range c.Keys {
+		if key != common.KeyRequestBody {
+			ctx.Set(key, value)
+		}
+	}
+
+	chatInfo := relaycommon.GenRelayInfoOpenAI(ctx, chatRequest)
+	chatInfo.InitChannelMeta(ctx)
+	if err := helper.ModelMappedHelper(ctx, chatInfo, chatRequest); err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+	adaptor := GetAdaptor(chatInfo.ApiType)
+	if adaptor == nil {
+		return nil, nil, types.NewError(fmt.Errorf("invalid api type: %d", chatInfo.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
+	}
+	adaptor.Init(chatInfo)
+	converted, err := adaptor.ConvertOpenAIRequest(ctx, chatInfo, chatRequest)
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err := common.Marshal(converted)
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	if len(chatInfo.ParamOverride) > 0 {
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, chatInfo.ParamOverride)
+		if err != nil {
+			return nil, nil, types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+	}
+
+	resp, err := adaptor.DoRequest(ctx, chatInfo, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		if httpResp.StatusCode != http.StatusOK {
+			newAPIError := service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+			return nil, nil, newAPIError
+		}
+	}
+	usage, newAPIError := adaptor.DoResponse(ctx, httpResp, chatInfo)
+	if newAPIError != nil {
+		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+		return nil, nil, newAPIError
+	}
+
+	var completion dto.OpenAITextResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &completion); err != nil || len(completion.Choices) == 0 {
+		return nil, nil, types.NewError(fmt.Errorf("invalid classifier response"), types.ErrorCodeBadResponseBody)
+	}
+	scores, err := moderation.ParseClassifierOutput(completion.Choices[0].Message.StringContent())
+	if err != nil {
+		return nil, nil, types.NewError(fmt.Errorf("invalid classifier response: %w", err), types.ErrorCodeBadResponseBody)
+	}
+	u, _ := usage.(*dto.Usage)
+	if u == nil {
+		u = &dto.Usage{}
+	}
+	return scores, u, nil
+}
+
+func writeModerationResponse(c *gin.Context, info *relaycommon.RelayInfo, modelName string, results []moderation.Result, usage *dto.Usage, backend string) *types.NewAPIError {
+	response := dto.ModerationResponse{
+		Id:      fmt.Sprintf("modr-%s", c.GetString(common.RequestIdKey)),
+		Model:   modelName,
+		Results: make([]dto.ModerationResult, 0, len(results)),
+	}
+	flagged := 0
+	for _, result := range results {
+		response.Results = append(response.Results, dto.ModerationResult(result))
+		if result.Flagged {
+			flagged++
+		}
+	}
+	c.JSON(http.StatusOK, response)
+	postConsumeQuota(c, info, usage, fmt.Sprintf("审核：%s，输入 %d 条，命中 %d 条", backend, len(results), flagged))
+	return nil
+}
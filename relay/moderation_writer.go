@@ -0,0 +1,1045 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/moderation"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	moderationWriterPending = iota
+	moderationWriterStream
+	moderationWriterBody
+)
+
+// outputModerator is what the writer needs from the moderation service.
+type outputModerator interface {
+	Action() string
+	NewStream() *moderation.Stream
+	ChannelEnabled() bool
+	Classify(ctx context.Context, text string) (bool, []string, error)
+	Replacement() string
+	CheckIntervalRunes() int
+	Record(c *gin.Context, content string, hits []string, action string)
+}
+
+// moderationFormat reads and rewrites the text of one response format.
+type moderationFormat interface {
+	// event moderates one stream data payload and returns the events to
+	// send in its place; none drops it.
+	event(w *moderationWriter, name string, payload string) []sseEvent
+	// end returns events for text still held when the stream ends early.
+	end(w *moderationWriter) []sseEvent
+	// body moderates a buffered response; changed is false to send it as is.
+	body(w *moderationWriter, body []byte) (out []byte, changed bool)
+}
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// moderationChoice is the moderation state of one streamed choice.
+type moderationChoice struct {
+	stream    *moderation.Stream
+	sent      strings.Builder
+	unchecked int
+	stopped   bool
+	finished  bool
+}
+
+// moderationWriter scans model output against the sensitive word list, and
+// optionally a moderation channel, before the client sees it. Streams are
+// moderated per choice as events pass through; bodies are buffered and
+// checked once. The format adapts it to chat completions, Claude messages,
+// Gemini and Responses output.
+type moderationWriter struct {
+	gin.ResponseWriter
+	c         *gin.Context
+	moderator outputModerator
+	format    moderationFormat
+	action    string
+
+	status    int
+	mode      int
+	pending   bytes.Buffer
+	eventName string
+	body      bytes.Buffer
+	checked   []byte
+	choices   map[int]*moderationChoice
+
+	// hits holds categories from the moderation channel; word hits are
+	// kept by each choice's stream.
+	hits     []string
+	recorded bool
+}
+
+// OutputModeration routes the response of a relay request through output
+// moderation when it is enabled and the client format is understood. The
+// returned func finishes the response and must run once the request is done.
+func OutputModeration(c *gin.Context, info *relaycommon.RelayInfo) func() {
+	if !config.GetOutputModerationConfig().Enabled {
+		return func() {}
+	}
+	format := moderationFormatFor(c, info)
+	if format == nil {
+		return func() {}
+	}
+	original := c.Writer
+	writer := newModerationWriter(c, original, service.OutputModerator{}, format)
+	c.Writer = writer
+	common.SetContextKey(c, constant.ContextKeyOutputModeration, writer)
+	return func() {
+		writer.finish()
+		c.Writer = original
+		common.SetContextKey(c, constant.ContextKeyOutputModeration, nil)
+	}
+}
+
+func moderationFormatFor(c *gin.Context, info *relaycommon.RelayInfo) moderationFormat {
+	switch info.RelayFormat {
+	case types.RelayFormatOpenAI:
+		if info.RelayMode == relayconstant.RelayModeChatCompletions {
+			return &openAIModeration{}
+		}
+	case types.RelayFormatClaude:
+		return newClaudeModeration()
+	case types.RelayFormatGemini:
+		if !strings.Contains(c.Request.URL.Path, "embed") {
+			return &geminiModeration{}
+		}
+	case types.RelayFormatOpenAIResponses:
+		return &responsesModeration{}
+	}
+	return nil
+}
+
+func newModerationWriter(c *gin.Context, w gin.ResponseWriter, moderator outputModerator, format moderationFormat) *moderationWriter {
+	return &moderationWriter{
+		ResponseWriter: w,
+		c:              c,
+		moderator:      moderator,
+		format:         format,
+		action:         moderator.Action(),
+		status:         http.StatusOK,
+		choices:        make(map[int]*moderationChoice),
+	}
+}
+
+func (w *moderationWriter) WriteHeader(code int) {
+	if code > 0 && w.mode == moderationWriterPending {
+		w.status = code
+	}
+}
+
+func (w *moderationWriter) WriteHeaderNow() {}
+
+func (w *moderationWriter) Status() int {
+	return w.status
+}
+
+func (w *moderationWriter) Written() bool {
+	return w.mode != moderationWriterPending
+}
+
+func (w *moderationWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *moderationWriter) Write(data []byte) (int, error) {
+	if w.mode == moderationWriterPending {
+		w.mode = moderationWriterBody
+		if strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") {
+			w.mode = moderationWriterStream
+			w.ResponseWriter.WriteHeader(w.status)
+			w.ResponseWriter.WriteHeaderNow()
+		}
+	}
+	if w.mode == moderationWriterBody {
+		w.body.Write(data)
+		w.checked = nil
+		return len(data), nil
+	}
+	w.pending.Write(data)
+	for {
+		buffered := w.pending.Bytes()
+		i := bytes.IndexByte(buffered, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(buffered[:i])
+		w.pending.Next(i + 1)
+		if _, err := w.ResponseWriter.Write([]byte(w.moderateLine(line))); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *moderationWriter) Flush() {
+	if w.mode == moderationWriterStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// finish writes the checked body, or what a stream still holds back.
+func (w *moderationWriter) finish() {
+	switch w.mode {
+	case moderationWriterBody:
+		body := w.checkBody()
+		// a replaced body changes the length
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.WriteHeaderNow()
+		_, _ = w.ResponseWriter.Write(body)
+	case moderationWriterStream:
+		var out strings.Builder
+		if w.pending.Len() > 0 {
+			line := w.pending.String()
+			w.pending.Reset()
+			out.WriteString(strings.TrimSuffix(w.moderateLine(line), "\n"))
+		}
+		if events := w.format.end(w); len(events) > 0 {
+			if out.Len() > 0 {
+				out.WriteString("\n\n")
+			}
+			out.WriteString(encodeSSEEvents(events))
+			out.WriteString("\n\n")
+		}
+		if out.Len() > 0 {
+			_, _ = w.ResponseWriter.Write([]byte(out.String()))
+		}
+	}
+	w.record()
+}
+
+// LogInfo is the other.output_moderation entry of the consume log.
+func (w *moderationWriter) LogInfo() map[string]interface{} {
+	if w.mode == moderationWriterBody {
+		w.checkBody()
+	}
+	hits := w.allHits()
+	if len(hits) == 0 {
+		return nil
+	}
+	stopped := false
+	for _, choice := range w.choices {
+		stopped = stopped || choice.stopped
+	}
+	return map[string]interface{}{
+		"action":  w.action,
+		"hits":    service.RemoveDuplicate(hits),
+		"stopped": stopped,
+	}
+}
+
+// moderateLine moderates one stream line and returns what to send for it,
+// newline included. Event names are held until their data arrives so that
+// events the format adds or drops keep their names.
+func (w *moderationWriter) moderateLine(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "event:") {
+		if w.eventName != "" {
+			held := "event: " + w.eventName + "\n"
+			w.eventName = strings.TrimSpace(strings.TrimPrefix(trimmed, "event:"))
+			return held
+		}
+		w.eventName = strings.TrimSpace(strings.TrimPrefix(trimmed, "event:"))
+		return ""
+	}
+	if !strings.HasPrefix(trimmed, "data:") {
+		if w.eventName != "" && trimmed != "" {
+			held := "event: " + w.eventName + "\n"
+			w.eventName = ""
+			return held + line + "\n"
+		}
+		return line + "\n"
+	}
+	name := w.eventName
+	w.eventName = ""
+	payload := strings.TrimSpace(strings.TrimPrefix(trimmed, "data:"))
+	events := w.format.event(w, name, payload)
+	if len(events) == 0 {
+		return ""
+	}
+	return encodeSSEEvents(events) + "\n"
+}
+
+func encodeSSEEvents(events []sseEvent) string {
+	var b strings.Builder
+	for i, event := range events {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		if event.name != "" {
+			b.WriteString("event: " + event.name + "\n")
+		}
+		b.WriteString("data: " + event.data)
+	}
+	return b.String()
+}
+
+func (w *moderationWriter) choice(index int) *moderationChoice {
+	state, ok := w.choices[index]
+	if !ok {
+		state = &moderationChoice{stream: w.moderator.NewStream()}
+		w.choices[index] = state
+	}
+	return state
+}
+
+func (w *moderationWriter) stopped(index int) bool {
+	state, ok := w.choices[index]
+	return ok && state.stopped
+}
+
+// pushText moderates a text delta of a choice and returns the text to send
+// now. stop is true when the choice was truncated; nothing more of it may
+// be sent.
+func (w *moderationWriter) pushText(index int, delta string) (string, bool) {
+	state := w.choice(index)
+	if state.stopped {
+		return "", true
+	}
+	out, stop := state.stream.Push(delta)
+	state.sent.WriteString(out)
+	state.unchecked += len([]rune(out))
+	if !stop {
+		if interval := w.moderator.CheckIntervalRunes(); interval > 0 && state.unchecked >= interval {
+			stop = w.delegate(state)
+		}
+	}
+	if stop {
+		state.stopped = true
+		state.finished = true
+	}
+	return out, stop
+}
+
+// flushText returns the text a choice still holds back, checking what was
+// sent with the moderation channel. stop is true when the channel flagged it.
+func (w *moderationWriter) flushText(index int) (string, bool) {
+	state := w.choice(index)
+	if state.stopped {
+		return "", true
+	}
+	rest := state.stream.Flush()
+	state.sent.WriteString(rest)
+	state.unchecked += len([]rune(rest))
+	if state.unchecked > 0 && w.delegate(state) {
+		state.stopped = true
+		state.finished = true
+		return "", true
+	}
+	return rest, false
+}
+
+// sentText is the text of a choice the client has been sent.
+func (w *moderationWriter) sentText(index int) string {
+	state, ok := w.choices[index]
+	if !ok {
+		return ""
+	}
+	return state.sent.String()
+}
+
+// delegate checks the text sent so far with the moderation channel. Text
+// already sent cannot be replaced, so a hit stops the choice unless the
+// action is flag.
+func (w *moderationWriter) delegate(state *moderationChoice) bool {
+	state.unchecked = 0
+	flagged, categories := w.moderateWithChannel(state.sent.String())
+	if !flagged {
+		return false
+	}
+	w.hits = append(w.hits, categories...)
+	return w.action != moderation.ActionFlag
+}
+
+func (w *moderationWriter) moderateWithChannel(text string) (bool, []string) {
+	if !w.moderator.ChannelEnabled() || strings.TrimSpace(text) == "" {
+		return false, nil
+	}
+	flagged, categories, err := w.moderator.Classify(w.c.Request.Context(), text)
+	if err != nil {
+		logger.LogError(w.c, "output moderation channel failed: "+err.Error())
+		return false, nil
+	}
+	if flagged && len(categories) == 0 {
+		categories = []string{"flagged"}
+	}
+	return flagged, categories
+}
+
+// moderateTexts moderates the text parts of one choice of a buffered
+// response, in order. It returns the parts to send, which may be fewer than
+// given, and whether the choice was stopped.
+func (w *moderationWriter) moderateTexts(index int, parts []string) ([]string, bool) {
+	state := w.choice(index)
+	out := make([]string, 0, len(parts))
+	stop := false
+	for _, part := range parts {
+		text, stopped := state.stream.Push(part)
+		if stopped {
+			out = append(out, text)
+			stop = true
+			break
+		}
+		out = append(out, text+state.stream.Flush())
+	}
+	if flagged, categories := w.moderateWithChannel(strings.Join(parts, "")); flagged {
+		w.hits = append(w.hits, categories...)
+		switch w.action {
+		case moderation.ActionTruncate:
+			out, stop = []string{""}, true
+		case moderation.ActionReplace:
+			out = []string{w.moderator.Replacement()}
+		}
+	}
+	state.finished = true
+	state.stopped = stop
+	return out, stop
+}
+
+// checkBody moderates a buffered response once and returns the body to send.
+func (w *moderationWriter) checkBody() []byte {
+	if w.checked != nil {
+		return w.checked
+	}
+	body := w.body.Bytes()
+	w.checked = body
+	if w.status != http.StatusOK {
+		return body
+	}
+	// more data after an earlier check starts the choices over
+	w.choices = make(map[int]*moderationChoice)
+	w.hits = nil
+	if out, changed := w.format.body(w, body); changed {
+		w.checked = out
+	}
+	return w.checked
+}
+
+func (w *moderationWriter) allHits() []string {
+	hits := append([]string(nil), w.hits...)
+	for _, index := range w.choiceIndexes() {
+		hits = append(hits, w.choices[index].stream.Hits()...)
+	}
+	return hits
+}
+
+func (w *moderationWriter) choiceIndexes() []int {
+	indexes := make([]int, 0, len(w.choices))
+	for index := range w.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// record logs flagged output and records the violation once per request.
+func (w *moderationWriter) record() {
+	hits := w.allHits()
+	if w.recorded || len(hits) == 0 {
+		return
+	}
+	w.recorded = true
+	var content strings.Builder
+	for _, index := range w.choiceIndexes() {
+		content.WriteString(w.choices[index].sent.String())
+	}
+	if content.Len() == 0 {
+		content.Write(w.body.Bytes())
+	}
+	w.moderator.Record(w.c, content.String(), hits, w.action)
+}
+
+// openAIModeration moderates chat completion output.
+type openAIModeration struct {
+	id      string
+	model   string
+	created int64
+}
+
+func (f *openAIModeration) event(w *moderationWriter, name string, payload string) []sseEvent {
+	if payload == "[DONE]" {
+		if rest := f.flushChunk(w); rest != "" {
+			return []sseEvent{{data: rest}, {name: name, data: payload}}
+		}
+		return []sseEvent{{name: name, data: payload}}
+	}
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := common.Unmarshal([]byte(payload), &chunk); err != nil {
+		return []sseEvent{{name: name, data: payload}}
+	}
+	if chunk.Id != "" {
+		f.id, f.model, f.created = chunk.Id, chunk.Model, chunk.Created
+	}
+	if len(chunk.Choices) == 0 {
+		return []sseEvent{{name: name, data: payload}}
+	}
+	choices := chunk.Choices[:0]
+	for _, choice := range chunk.Choices {
+		if w.stopped(choice.Index) {
+			continue
+		}
+		if choice.Delta.Content != nil {
+			out, stop := w.pushText(choice.Index, *choice.Delta.Content)
+			if stop {
+				stopOpenAIChoice(&choice, out)
+				choices = append(choices, choice)
+				continue
+			}
+			choice.Delta.SetContentString(out)
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			content := choice.Delta.GetContentString()
+			rest, stop := w.flushText(choice.Index)
+			w.choice(choice.Index).finished = true
+			if stop {
+				stopOpenAIChoice(&choice, "")
+			} else if rest != "" || choice.Delta.Content != nil {
+				choice.Delta.SetContentString(content + rest)
+			}
+		}
+		choices = append(choices, choice)
+	}
+	if len(choices) == 0 {
+		return nil
+	}
+	chunk.Choices = choices
+	data, err := common.Marshal(chunk)
+	if err != nil {
+		return []sseEvent{{name: name, data: payload}}
+	}
+	return []sseEvent{{name: name, data: string(data)}}
+}
+
+// stopOpenAIChoice ends a choice with the content_filter finish reason.
+func stopOpenAIChoice(choice *dto.ChatCompletionsStreamResponseChoice, content string) {
+	choice.Delta.SetContentString(content)
+	choice.Delta.ToolCalls = nil
+	choice.FinishReason = common.GetPointer(constant.FinishReasonContentFilter)
+}
+
+func (f *openAIModeration) end(w *moderationWriter) []sseEvent {
+	if rest := f.flushChunk(w); rest != "" {
+		return []sseEvent{{data: rest}}
+	}
+	return nil
+}
+
+// flushChunk builds a chunk for text still held when the stream ends
+// without a finish reason.
+func (f *openAIModeration) flushChunk(w *moderationWriter) string {
+	chunk := dto.ChatCompletionsStreamResponse{
+		Id:      f.id,
+		Object:  "chat.completion.chunk",
+		Created: f.created,
+		Model:   f.model,
+	}
+	for _, index := range w.choiceIndexes() {
+		if w.choices[index].finished {
+			continue
+		}
+		choice := dto.ChatCompletionsStreamResponseChoice{Index: index}
+		rest, stop := w.flushText(index)
+		w.choices[index].finished = true
+		if stop {
+			stopOpenAIChoice(&choice, "")
+		} else if rest != "" {
+			choice.Delta.SetContentString(rest)
+		} else {
+			continue
+		}
+		chunk.Choices = append(chunk.Choices, choice)
+	}
+	if len(chunk.Choices) == 0 {
+		return ""
+	}
+	data, err := common.Marshal(chunk)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (f *openAIModeration) body(w *moderationWriter, body []byte) ([]byte, bool) {
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(body, &response); err != nil || len(response.Choices) == 0 {
+		return body, false
+	}
+	changed := false
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		if !choice.Message.IsStringContent() {
+			continue
+		}
+		content := choice.Message.StringContent()
+		parts, stop := w.moderateTexts(choice.Index, []string{content})
+		if stop {
+			choice.FinishReason = constant.FinishReasonContentFilter
+			choice.Message.ToolCalls = nil
+		}
+		if parts[0] != content || stop {
+			choice.Message.SetStringContent(parts[0])
+			changed = true
+		}
+	}
+	if !changed {
+		return body, false
+	}
+	data, err := common.Marshal(response)
+	if err != nil {
+		return body, false
+	}
+	return data, true
+}
+
+// claudeModeration moderates Claude messages output. The text blocks of a
+// message are one choice; after a stop the open block is closed, later
+// blocks are dropped and the message ends with the refusal stop reason.
+type claudeModeration struct {
+	textBlocks map[int]bool
+	dropped    map[int]bool
+}
+
+const claudeStopReasonRefusal = "refusal"
+
+func newClaudeModeration() *claudeModeration {
+	return &claudeModeration{textBlocks: make(map[int]bool), dropped: make(map[int]bool)}
+}
+
+func (f *claudeModeration) event(w *moderationWriter, name string, payload string) []sseEvent {
+	keep := []sseEvent{{name: name, data: payload}}
+	if !gjson.Valid(payload) {
+		return keep
+	}
+	index := int(gjson.Get(payload, "index").Int())
+	switch gjson.Get(payload, "type").String() {
+	case "content_block_start":
+		if w.stopped(0) {
+			f.dropped[index] = true
+			return nil
+		}
+		if gjson.Get(payload, "content_block.type").String() == "text" {
+			f.textBlocks[index] = true
+		}
+	case "content_block_delta":
+		if f.dropped[index] || (w.stopped(0) && gjson.Get(payload, "delta.type").String() != "text_delta") {
+			return nil
+		}
+		if gjson.Get(payload, "delta.type").String() != "text_delta" {
+			return keep
+		}
+		if w.stopped(0) {
+			return nil
+		}
+		out, _ := w.pushText(0, gjson.Get(payload, "delta.text").String())
+		data, err := sjson.Set(payload, "delta.text", out)
+		if err != nil {
+			return keep
+		}
+		return []sseEvent{{name: name, data: data}}
+	case "content_block_stop":
+		if f.dropped[index] {
+			return nil
+		}
+		if !f.textBlocks[index] || w.stopped(0) {
+			return keep
+		}
+		rest, _ := w.flushText(0)
+		if rest == "" {
+			return keep
+		}
+		delta, err := common.Marshal(map[string]any{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]any{"type": "text_delta", "text": rest},
+		})
+		if err != nil {
+			return keep
+		}
+		return []sseEvent{{name: "content_block_delta", data: string(delta)}, keep[0]}
+	case "message_delta":
+		if !w.stopped(0) {
+			return keep
+		}
+		data, err := sjson.Set(payload, "delta.stop_reason", claudeStopReasonRefusal)
+		if err != nil {
+			return keep
+		}
+		return []sseEvent{{name: name, data: data}}
+	}
+	return keep
+}
+
+// end has nothing to add: Claude streams close every text block.
+func (f *claudeModeration) end(w *moderationWriter) []sseEvent {
+	return nil
+}
+
+func (f *claudeModeration) body(w *moderationWriter, body []byte) ([]byte, bool) {
+	var response dto.ClaudeResponse
+	if err := common.Unmarshal(body, &response); err != nil || response.Type != "message" {
+		return body, false
+	}
+	var texts []string
+	for _, block := range response.Content {
+		if block.Type == "text" && block.Text != nil {
+			texts = append(texts, *block.Text)
+		}
+	}
+	if len(texts) == 0 {
+		return body, false
+	}
+	parts, stop := w.moderateTexts(0, texts)
+	changed := stop || len(parts) != len(texts)
+	content := make([]dto.ClaudeMediaMessage, 0, len(response.Content))
+	next := 0
+	for _, block := range response.Content {
+		if block.Type != "text" || block.Text == nil {
+			if !stop || next < len(parts) {
+				content = append(content, block)
+			}
+			continue
+		}
+		if next >= len(parts) {
+			changed = true
+			continue
+		}
+		if parts[next] != *block.Text {
+			block.Text = common.GetPointer(parts[next])
+			changed = true
+		}
+		content = append(content, block)
+		next++
+	}
+	if !changed {
+		return body, false
+	}
+	response.Content = content
+	if stop {
+		response.StopReason = claudeStopReasonRefusal
+	}
+	data, err := common.Marshal(response)
+	if err != nil {
+		return body, false
+	}
+	return data, true
+}
+
+// geminiModeration moderates Gemini generateContent output; each candidate
+// is a choice and its text parts are moderated in order.
+type geminiModeration struct{}
+
+const geminiFinishReasonSafety = "SAFETY"
+
+func (f *geminiModeration) event(w *moderationWriter, name string, payload string) []sseEvent {
+	keep := []sseEvent{{name: name, data: payload}}
+	candidates := gjson.Get(payload, "candidates")
+	if !candidates.IsArray() {
+		return keep
+	}
+	data := payload
+	changed := false
+	kept := 0
+	for i, candidate := range candidates.Array() {
+		index := geminiCandidateIndex(candidate, i)
+		path := "candidates." + strconv.Itoa(kept)
+		if w.stopped(index) {
+			data, _ = sjson.Delete(data, path)
+			changed = true
+			continue
+		}
+		kept++
+		lastText := ""
+		parts := candidate.Get("content.parts").Array()
+		for j := range parts {
+			partPath := path + ".content.parts." + strconv.Itoa(j)
+			text := gjson.Get(data, partPath+".text")
+			if !text.Exists() {
+				continue
+			}
+			if w.stopped(index) {
+				data, _ = sjson.Set(data, partPath+".text", "")
+				changed = true
+				continue
+			}
+			out, stop := w.pushText(index, text.String())
+			if out != text.String() {
+				data, _ = sjson.Set(data, partPath+".text", out)
+				changed = true
+			}
+			lastText = partPath + ".text"
+			if stop {
+				data, _ = sjson.Set(data, path+".finishReason", geminiFinishReasonSafety)
+				changed = true
+			}
+		}
+		if w.stopped(index) || candidate.Get("finishReason").String() == "" {
+			continue
+		}
+		rest, stop := w.flushText(index)
+		w.choice(index).finished = true
+		if stop {
+			data, _ = sjson.Set(data, path+".finishReason", geminiFinishReasonSafety)
+			changed = true
+		} else if rest != "" {
+			if lastText != "" {
+				data, _ = sjson.Set(data, lastText, gjson.Get(data, lastText).String()+rest)
+			} else {
+				data, _ = sjson.Set(data, path+".content.parts.-1", map[string]any{"text": rest})
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return keep
+	}
+	return []sseEvent{{name: name, data: data}}
+}
+
+func (f *geminiModeration) end(w *moderationWriter) []sseEvent {
+	var candidates []map[string]any
+	for _, index := range w.choiceIndexes() {
+		if w.choices[index].finished {
+			continue
+		}
+		rest, stop := w.flushText(index)
+		w.choices[index].finished = true
+		candidate := map[string]any{"index": index}
+		if stop {
+			candidate["finishReason"] = geminiFinishReasonSafety
+		} else if rest != "" {
+			candidate["content"] = map[string]any{"role": "model", "parts": []map[string]any{{"text": rest}}}
+		} else {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	data, err := common.Marshal(map[string]any{"candidates": candidates})
+	if err != nil {
+		return nil
+	}
+	return []sseEvent{{data: string(data)}}
+}
+
+func (f *geminiModeration) body(w *moderationWriter, body []byte) ([]byte, bool) {
+	candidates := gjson.GetBytes(body, "candidates")
+	if !candidates.IsArray() {
+		return body, false
+	}
+	data := body
+	changed := false
+	for i, candidate := range candidates.Array() {
+		path := "candidates." + strconv.Itoa(i)
+		var texts []string
+		var paths []string
+		for j, part := range candidate.Get("content.parts").Array() {
+			if text := part.Get("text"); text.Exists() {
+				texts = append(texts, text.String())
+				paths = append(paths, path+".content.parts."+strconv.Itoa(j))
+			}
+		}
+		if len(texts) == 0 {
+			continue
+		}
+		parts, stop := w.moderateTexts(geminiCandidateIndex(candidate, i), texts)
+		// delete from the end so earlier part paths stay valid
+		for j := len(paths) - 1; j >= 0; j-- {
+			if j >= len(parts) {
+				data, _ = sjson.DeleteBytes(data, paths[j])
+				changed = true
+			} else if parts[j] != texts[j] {
+				data, _ = sjson.SetBytes(data, paths[j]+".text", parts[j])
+				changed = true
+			}
+		}
+		if stop {
+			data, _ = sjson.SetBytes(data, path+".finishReason", geminiFinishReasonSafety)
+			changed = true
+		}
+	}
+	return data, changed
+}
+
+func geminiCandidateIndex(candidate gjson.Result, position int) int {
+	if index := candidate.Get("index"); index.Exists() {
+		return int(index.Int())
+	}
+	return position
+}
+
+// responsesModeration moderates Responses output; each output item is a
+// choice. Text already sent cannot change, so the done events and the final
+// response report what the client was sent.
+type responsesModeration struct{}
+
+func (f *responsesModeration) event(w *moderationWriter, name string, payload string) []sseEvent {
+	keep := []sseEvent{{name: name, data: payload}}
+	if !gjson.Valid(payload) {
+		return keep
+	}
+	index := int(gjson.Get(payload, "output_index").Int())
+	eventType := gjson.Get(payload, "type").String()
+	switch eventType {
+	case "response.output_text.delta":
+		if w.stopped(index) {
+			return nil
+		}
+		out, _ := w.pushText(index, gjson.Get(payload, "delta").String())
+		data, err := sjson.Set(payload, "delta", out)
+		if err != nil {
+			return keep
+		}
+		return []sseEvent{{name: name, data: data}}
+	case "response.output_text.done":
+		if w.choices[index] == nil {
+			return keep
+		}
+		var events []sseEvent
+		if !w.stopped(index) {
+			if rest, _ := w.flushText(index); rest != "" {
+				delta, err := sjson.Set(payload, "type", "response.output_text.delta")
+				if err == nil {
+					delta, _ = sjson.Delete(delta, "text")
+					delta, _ = sjson.Set(delta, "delta", rest)
+					events = append(events, sseEvent{name: "response.output_text.delta", data: delta})
+				}
+			}
+		}
+		data, err := sjson.Set(payload, "text", w.sentText(index))
+		if err != nil {
+			return keep
+		}
+		return append(events, sseEvent{name: name, data: data})
+	case "response.content_part.done":
+		if gjson.Get(payload, "part.type").String() != "output_text" || w.choices[index] == nil {
+			return keep
+		}
+		data, err := sjson.Set(payload, "part.text", w.sentText(index))
+		if err != nil {
+			return keep
+		}
+		return []sseEvent{{name: name, data: data}}
+	case "response.output_item.done":
+		data, changed := f.rewriteItem(w, payload, "item", index)
+		if !changed {
+			return keep
+		}
+		return []sseEvent{{name: name, data: data}}
+	case "response.completed", "response.incomplete":
+		data := payload
+		changed := false
+		for i := range gjson.Get(payload, "response.output").Array() {
+			var itemChanged bool
+			data, itemChanged = f.rewriteItem(w, data, "response.output."+strconv.Itoa(i), i)
+			changed = changed || itemChanged
+		}
+		if f.anyStopped(w) {
+			data, _ = sjson.Set(data, "response.status", "incomplete")
+			data, _ = sjson.Set(data, "response.incomplete_details", map[string]any{"reason": constant.FinishReasonContentFilter})
+			changed = true
+		}
+		if !changed {
+			return keep
+		}
+		return []sseEvent{{name: name, data: data}}
+	}
+	return keep
+}
+
+// rewriteItem sets the first output_text part of an output item to the
+// text the client was sent.
+func (f *responsesModeration) rewriteItem(w *moderationWriter, data string, path string, index int) (string, bool) {
+	if w.choices[index] == nil {
+		return data, false
+	}
+	for j, part := range gjson.Get(data, path+".content").Array() {
+		if part.Get("type").String() != "output_text" {
+			continue
+		}
+		out, err := sjson.Set(data, path+".content."+strconv.Itoa(j)+".text", w.sentText(index))
+		if err != nil {
+			return data, false
+		}
+		return out, true
+	}
+	return data, false
+}
+
+func (f *responsesModeration) anyStopped(w *moderationWriter) bool {
+	for _, state := range w.choices {
+		if state.stopped {
+			return true
+		}
+	}
+	return false
+}
+
+// end has nothing to add: every output_text delta stream ends with a done event.
+func (f *responsesModeration) end(w *moderationWriter) []sseEvent {
+	return nil
+}
+
+func (f *responsesModeration) body(w *moderationWriter, body []byte) ([]byte, bool) {
+	output := gjson.GetBytes(body, "output")
+	if !output.IsArray() {
+		return body, false
+	}
+	data := body
+	changed := false
+	stopped := false
+	for i, item := range output.Array() {
+		var texts []string
+		var paths []string
+		for j, part := range item.Get("content").Array() {
+			if part.Get("type").String() == "output_text" {
+				texts = append(texts, part.Get("text").String())
+				paths = append(paths, "output."+strconv.Itoa(i)+".content."+strconv.Itoa(j))
+			}
+		}
+		if len(texts) == 0 {
+			continue
+		}
+		parts, stop := w.moderateTexts(i, texts)
+		stopped = stopped || stop
+		for j := len(paths) - 1; j >= 0; j-- {
+			if j >= len(parts) {
+				data, _ = sjson.DeleteBytes(data, paths[j])
+				changed = true
+			} else if parts[j] != texts[j] {
+				data, _ = sjson.SetBytes(data, paths[j]+".text", parts[j])
+				changed = true
+			}
+		}
+	}
+	if stopped {
+		data, _ = sjson.SetBytes(data, "status", "incomplete")
+		data, _ = sjson.SetBytes(data, "incomplete_details", map[string]any{"reason": constant.FinishReasonContentFilter})
+		changed = true
+	}
+	return data, changed
+}
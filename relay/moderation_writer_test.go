@@ -0,0 +1,237 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/service/moderation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+type fakeModerator struct {
+	action   string
+	words    []string
+	recorded []string
+}
+
+func (m *fakeModerator) Action() string { return m.action }
+
+func (m *fakeModerator) NewStream() *moderation.Stream {
+	return moderation.NewStream(func(text string) []moderation.Match {
+		var matches []moderation.Match
+		runes := []rune(text)
+		for _, word := range m.words {
+			w := []rune(word)
+			for i := 0; i+len(w) <= len(runes); i++ {
+				if string(runes[i:i+len(w)]) == word {
+					matches = append(matches, moderation.Match{Start: i, End: i + len(w), Word: word})
+				}
+			}
+		}
+		return matches
+	}, m.action, 8, "***")
+}
+
+func (m *fakeModerator) ChannelEnabled() bool { return false }
+
+func (m *fakeModerator) Classify(ctx context.Context, text string) (bool, []string, error) {
+	return false, nil, nil
+}
+
+func (m *fakeModerator) Replacement() string { return "***" }
+
+func (m *fakeModerator) CheckIntervalRunes() int { return 0 }
+
+func (m *fakeModerator) Record(c *gin.Context, content string, hits []string, action string) {
+	m.recorded = append(m.recorded, hits...)
+}
+
+func moderate(t *testing.T, format moderationFormat, moderator *fakeModerator, contentType string, writes ...string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	writer := newModerationWriter(c, c.Writer, moderator, format)
+	writer.Header().Set("Content-Type", contentType)
+	for _, data := range writes {
+		if _, err := writer.Write([]byte(data)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	writer.finish()
+	return recorder.Body.String()
+}
+
+// sseData returns the data payloads of a stream, with their event names.
+func sseData(body string) (names []string, payloads []string) {
+	name := ""
+	for _, line := range strings.Split(body, "\n") {
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			names = append(names, name)
+			payloads = append(payloads, strings.TrimPrefix(line, "data: "))
+			name = ""
+		}
+	}
+	return names, payloads
+}
+
+func TestModerationWriterChatStreamTruncate(t *testing.T) {
+	moderator := &fakeModerator{action: moderation.ActionTruncate, words: []string{"secret"}}
+	body := moderate(t, &openAIModeration{}, moderator, "text/event-stream",
+		`data: {"id":"1","choices":[{"index":0,"delta":{"content":"the sec"}}]}`+"\n\n",
+		`data: {"id":"1","choices":[{"index":0,"delta":{"content":"ret plan"}}]}`+"\n\n",
+		`data: {"id":"1","choices":[{"index":0,"delta":{"content":" more"}}]}`+"\n\n",
+		"data: [DONE]\n\n")
+	_, payloads := sseData(body)
+	var text strings.Builder
+	finish := ""
+	for _, p := range payloads {
+		text.WriteString(gjson.Get(p, "choices.0.delta.content").String())
+		if r := gjson.Get(p, "choices.0.finish_reason").String(); r != "" {
+			finish = r
+		}
+	}
+	if text.String() != "the " || finish != "content_filter" {
+		t.Fatalf("got text %q finish %q from %s", text.String(), finish, body)
+	}
+	if len(moderator.recorded) != 1 || moderator.recorded[0] != "secret" {
+		t.Fatalf("recorded %v", moderator.recorded)
+	}
+}
+
+func TestModerationWriterClaudeStreamTruncate(t *testing.T) {
+	moderator := &fakeModerator{action: moderation.ActionTruncate, words: []string{"secret"}}
+	stream := []string{
+		"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"m\"}}\n\n",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"my secret\"}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\" is out\"}}\n\n",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"t\"}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{}\"}}\n\n",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}\n\n",
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"}}\n\n",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+	}
+	names, payloads := sseData(moderate(t, newClaudeModeration(), moderator, "text/event-stream", stream...))
+	var text strings.Builder
+	stopReason := ""
+	for i, p := range payloads {
+		if typ := gjson.Get(p, "type").String(); typ != names[i] {
+			t.Fatalf("event %q carries %q", names[i], typ)
+		}
+		if gjson.Get(p, "index").Int() == 1 {
+			t.Fatalf("block after the stop was sent: %s", p)
+		}
+		text.WriteString(gjson.Get(p, "delta.text").String())
+		if r := gjson.Get(p, "delta.stop_reason").String(); r != "" {
+			stopReason = r
+		}
+	}
+	if text.String() != "my " || stopReason != claudeStopReasonRefusal {
+		t.Fatalf("got text %q stop reason %q", text.String(), stopReason)
+	}
+}
+
+func TestModerationWriterClaudeStreamReplaceFlushesHeldText(t *testing.T) {
+	moderator := &fakeModerator{action: moderation.ActionReplace, words: []string{"secret"}}
+	stream := []string{
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"a secret\"}}\n\n",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n",
+	}
+	names, payloads := sseData(moderate(t, newClaudeModeration(), moderator, "text/event-stream", stream...))
+	var text strings.Builder
+	for _, p := range payloads {
+		text.WriteString(gjson.Get(p, "delta.text").String())
+	}
+	if text.String() != "a ***" {
+		t.Fatalf("got text %q", text.String())
+	}
+	if names[len(names)-1] != "content_block_stop" || names[len(names)-2] != "content_block_delta" {
+		t.Fatalf("held text must be sent before the block stops: %v", names)
+	}
+}
+
+func TestModerationWriterGeminiStreamReplace(t *testing.T) {
+	moderator := &fakeModerator{action: moderation.ActionReplace, words: []string{"secret"}}
+	body := moderate(t, &geminiModeration{}, moderator, "text/event-stream",
+		`data: {"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"one sec"}]}}]}`+"\n\n",
+		`data: {"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"ret two"}]},"finishReason":"STOP"}],"usageMetadata":{"totalTokenCount":3}}`+"\n\n")
+	_, payloads := sseData(body)
+	var text strings.Builder
+	for _, p := range payloads {
+		text.WriteString(gjson.Get(p, "candidates.0.content.parts.0.text").String())
+	}
+	if text.String() != "one *** two" {
+		t.Fatalf("got text %q from %s", text.String(), body)
+	}
+	if gjson.Get(payloads[len(payloads)-1], "usageMetadata.totalTokenCount").Int() != 3 {
+		t.Fatalf("usage metadata lost: %s", body)
+	}
+}
+
+func TestModerationWriterGeminiBodyTruncate(t *testing.T) {
+	moderator := &fakeModerator{action: moderation.ActionTruncate, words: []string{"secret"}}
+	body := moderate(t, &geminiModeration{}, moderator, "application/json",
+		`{"candidates":[{"content":{"parts":[{"text":"keep this"},{"text":"a secret here"},{"text":"tail"}]},"finishReason":"STOP"}]}`)
+	parts := gjson.Get(body, "candidates.0.content.parts").Array()
+	if len(parts) != 2 || parts[0].Get("text").String() != "keep this" || parts[1].Get("text").String() != "a " {
+		t.Fatalf("got %s", body)
+	}
+	if gjson.Get(body, "candidates.0.finishReason").String() != geminiFinishReasonSafety {
+		t.Fatalf("finish reason not set: %s", body)
+	}
+}
+
+func TestModerationWriterResponsesStreamTruncate(t *testing.T) {
+	moderator := &fakeModerator{action: moderation.ActionTruncate, words: []string{"secret"}}
+	stream := []string{
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"output_index\":0,\"content_index\":0,\"delta\":\"the secret\"}\n\n",
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"output_index\":0,\"content_index\":0,\"delta\":\" plan\"}\n\n",
+		"event: response.output_text.done\ndata: {\"type\":\"response.output_text.done\",\"output_index\":0,\"content_index\":0,\"text\":\"the secret plan\"}\n\n",
+		"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"status\":\"completed\",\"output\":[{\"type\":\"message\",\"content\":[{\"type\":\"output_text\",\"text\":\"the secret plan\"}]}]}}\n\n",
+	}
+	_, payloads := sseData(moderate(t, &responsesModeration{}, moderator, "text/event-stream", stream...))
+	var deltas strings.Builder
+	var done, completed string
+	for _, p := range payloads {
+		switch gjson.Get(p, "type").String() {
+		case "response.output_text.delta":
+			deltas.WriteString(gjson.Get(p, "delta").String())
+		case "response.output_text.done":
+			done = gjson.Get(p, "text").String()
+		case "response.completed":
+			completed = p
+		}
+	}
+	if deltas.String() != "the " || done != "the " {
+		t.Fatalf("got deltas %q done %q", deltas.String(), done)
+	}
+	if gjson.Get(completed, "response.output.0.content.0.text").String() != "the " ||
+		gjson.Get(completed, "response.status").String() != "incomplete" ||
+		gjson.Get(completed, "response.incomplete_details.reason").String() != "content_filter" {
+		t.Fatalf("final response not rewritten: %s", completed)
+	}
+}
+
+func TestModerationWriterResponsesBodyReplace(t *testing.T) {
+	moderator := &fakeModerator{action: moderation.ActionReplace, words: []string{"secret"}}
+	body := moderate(t, &responsesModeration{}, moderator, "application/json",
+		`{"status":"completed","output":[{"type":"reasoning"},{"type":"message","content":[{"type":"output_text","text":"no secret here"}]}]}`)
+	if got := gjson.Get(body, "output.1.content.0.text").String(); got != "no *** here" {
+		t.Fatalf("got %q from %s", got, body)
+	}
+	if gjson.Get(body, "status").String() != "completed" {
+		t.Fatalf("replace must not end the response: %s", body)
+	}
+}
@@ -0,0 +1,387 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OllamaHelper relays an Ollama/llama.cpp ingress request through the regular
+// OpenAI-compatible handlers and rewrites their output into the client's
+// native shape: NDJSON chunks for Ollama streams, SSE with llama.cpp objects
+// for /completion streams, and single JSON objects otherwise.
+func OllamaHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	original := c.Writer
+	writer := newOllamaResponseWriter(original, helper.OllamaEndpoint(c.Request.URL.Path), info.OriginModelName)
+	c.Writer = writer
+	defer func() {
+		c.Writer = original
+	}()
+
+	var newAPIError *types.NewAPIError
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		newAPIError = EmbeddingHelper(c, info)
+	} else {
+		newAPIError = TextHelper(c, info)
+	}
+	writer.finish()
+	return newAPIError
+}
+
+const (
+	ollamaWriterPending = iota
+	ollamaWriterStream
+	ollamaWriterBuffered
+)
+
+type ollamaResponseWriter struct {
+	gin.ResponseWriter
+	endpoint string
+	model    string
+	start    time.Time
+
+	status  int
+	mode    int
+	pending bytes.Buffer
+
+	toolCalls    map[int]*dto.ToolCallResponse
+	finishReason string
+	usage        *dto.Usage
+	done         bool
+}
+
+func newOllamaResponseWriter(w gin.ResponseWriter, endpoint string, model string) *ollamaResponseWriter {
+	return &ollamaResponseWriter{
+		ResponseWriter: w,
+		endpoint:       endpoint,
+		model:          model,
+		start:          time.Now(),
+		status:         http.StatusOK,
+		toolCalls:      make(map[int]*dto.ToolCallResponse),
+	}
+}
+
+func (w *ollamaResponseWriter) WriteHeader(code int) {
+	if code > 0 && w.mode == ollamaWriterPending {
+		w.status = code
+	}
+}
+
+func (w *ollamaResponseWriter) WriteHeaderNow() {}
+
+func (w *ollamaResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *ollamaResponseWriter) Written() bool {
+	return w.mode != ollamaWriterPending
+}
+
+func (w *ollamaResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *ollamaResponseWriter) Write(data []byte) (int, error) {
+	if w.mode == ollamaWriterPending {
+		w.mode = ollamaWriterBuffered
+		if w.status < http.StatusBadRequest && strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") {
+			w.mode = ollamaWriterStream
+			if w.endpoint != helper.OllamaEndpointLlamaCompletion {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+			}
+			w.ResponseWriter.WriteHeader(w.status)
+			w.ResponseWriter.WriteHeaderNow()
+		}
+	}
+	w.pending.Write(data)
+	if w.mode == ollamaWriterStream {
+		w.processLines(false)
+	}
+	return len(data), nil
+}
+
+func (w *ollamaResponseWriter) Flush() {
+	if w.mode == ollamaWriterStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *ollamaResponseWriter) processLines(final bool) {
+	for {
+		buffered := w.pending.Bytes()
+		i := bytes.IndexByte(buffered, '\n')
+		if i < 0 {
+			if final && len(buffered) > 0 {
+				line := string(buffered)
+				w.pending.Reset()
+				w.handleStreamLine(line)
+			}
+			return
+		}
+		line := string(buffered[:i])
+		w.pending.Next(i + 1)
+		w.handleStreamLine(line)
+	}
+}
+
+func (w *ollamaResponseWriter) handleStreamLine(line string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if payload == "[DONE]" {
+		w.finishStream()
+		return
+	}
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := common.Unmarshal([]byte(payload), &chunk); err != nil {
+		return
+	}
+	if chunk.Usage != nil && (chunk.Usage.PromptTokens != 0 || chunk.Usage.CompletionTokens != 0) {
+		w.usage = chunk.Usage
+	}
+	if len(chunk.Choices) == 0 {
+		return
+	}
+	choice := chunk.Choices[0]
+	for _, call := range choice.Delta.ToolCalls {
+		index := len(w.toolCalls)
+		if call.Index != nil {
+			index = *call.Index
+		}
+		existing, ok := w.toolCalls[index]
+		if !ok {
+			copied := call
+			w.toolCalls[index] = &copied
+			continue
+		}
+		if call.Function.Name != "" {
+			existing.Function.Name = call.Function.Name
+		}
+		existing.Function.Arguments += call.Function.Arguments
+	}
+	content := choice.Delta.GetContentString()
+	thinking := choice.Delta.GetReasoningContent()
+	if content != "" || thinking != "" {
+		w.writeChunk(content, thinking, nil)
+	}
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		w.finishReason = *choice.FinishReason
+		w.flushToolCalls()
+	}
+}
+
+func (w *ollamaResponseWriter) flushToolCalls() {
+	if len(w.toolCalls) == 0 {
+		return
+	}
+	indexes := make([]int, 0, len(w.toolCalls))
+	for index := range w.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	calls := make([]dto.ToolCallResponse, 0, len(indexes))
+	for _, index := range indexes {
+		calls = append(calls, *w.toolCalls[index])
+	}
+	w.toolCalls = make(map[int]*dto.ToolCallResponse)
+	w.writeChunk("", "", calls)
+}
+
+func (w *ollamaResponseWriter) finishStream() {
+	if w.done {
+		return
+	}
+	w.flushToolCalls()
+	w.done = true
+	w.writeStreamObject(w.buildResponse("", "", nil, true))
+}
+
+func (w *ollamaResponseWriter) writeChunk(content string, thinking string, calls []dto.ToolCallResponse) {
+	if w.endpoint == helper.OllamaEndpointLlamaCompletion && content == "" {
+		return
+	}
+	w.writeStreamObject(w.buildResponse(content, thinking, calls, false))
+}
+
+func (w *ollamaResponseWriter) writeStreamObject(object any) {
+	data, err := common.Marshal(object)
+	if err != nil {
+		return
+	}
+	if w.endpoint == helper.OllamaEndpointLlamaCompletion {
+		_, _ = w.ResponseWriter.Write([]byte("data: " + string(data) + "\n\n"))
+	} else {
+		_, _ = w.ResponseWriter.Write(append(data, '\n'))
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *ollamaResponseWriter) buildResponse(content string, thinking string, calls []dto.ToolCallResponse, done bool) any {
+	promptTokens, completionTokens := 0, 0
+	if w.usage != nil {
+		promptTokens, completionTokens = w.usage.PromptTokens, w.usage.CompletionTokens
+	}
+	if w.endpoint == helper.OllamaEndpointLlamaCompletion {
+		response := dto.LlamaCppCompletionResponse{Content: content, Stop: done}
+		if done {
+			response.Model = w.model
+			response.StopType = "eos"
+			if w.finishReason == "length" {
+				response.StopType = "limit"
+			}
+			response.TokensPredicted = completionTokens
+			response.TokensEvaluated = promptTokens
+		}
+		return response
+	}
+
+	response := dto.OllamaChatResponse{
+		Model:     w.model,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Done:      done,
+	}
+	if w.endpoint == helper.OllamaEndpointGenerate {
+		response.Response = &content
+		response.Thinking = thinking
+	} else {
+		response.Message = &dto.OllamaIngressMessage{
+			Role:      "assistant",
+			Content:   content,
+			Thinking:  thinking,
+			ToolCalls: ollamaToolCalls(calls),
+		}
+	}
+	if done {
+		response.DoneReason = "stop"
+		if w.finishReason == "length" {
+			response.DoneReason = "length"
+		}
+		response.TotalDuration = time.Since(w.start).Nanoseconds()
+		response.PromptEvalCount = promptTokens
+		response.EvalCount = completionTokens
+	}
+	return response
+}
+
+func ollamaToolCalls(calls []dto.ToolCallResponse) []dto.OllamaIngressToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	converted := make([]dto.OllamaIngressToolCall, 0, len(calls))
+	for i, call := range calls {
+		var toolCall dto.OllamaIngressToolCall
+		index := i
+		toolCall.Function.Index = &index
+		toolCall.Function.Name = call.Function.Name
+		// Ollama sends arguments as an object rather than a JSON string.
+		arguments := strings.TrimSpace(call.Function.Arguments)
+		if arguments == "" {
+			arguments = "{}"
+		}
+		if json.Valid([]byte(arguments)) {
+			toolCall.Function.Arguments = json.RawMessage(arguments)
+		} else {
+			toolCall.Function.Arguments, _ = common.Marshal(arguments)
+		}
+		converted = append(converted, toolCall)
+	}
+	return converted
+}
+
+// finish completes a stream the upstream ended without [DONE], or converts
+// the buffered non-stream body.
+func (w *ollamaResponseWriter) finish() {
+	switch w.mode {
+	case ollamaWriterStream:
+		w.processLines(true)
+		w.finishStream()
+	case ollamaWriterBuffered:
+		body := w.convertBody(w.pending.Bytes())
+		w.pending.Reset()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(body)
+	}
+}
+
+func (w *ollamaResponseWriter) convertBody(body []byte) []byte {
+	if w.status >= http.StatusBadRequest {
+		if w.endpoint == helper.OllamaEndpointLlamaCompletion {
+			return body
+		}
+		var errorResponse dto.GeneralErrorResponse
+		if err := common.Unmarshal(body, &errorResponse); err != nil {
+			return body
+		}
+		converted, _ := common.Marshal(gin.H{"error": errorResponse.ToMessage()})
+		return converted
+	}
+
+	var converted any
+	switch w.endpoint {
+	case helper.OllamaEndpointEmbed, helper.OllamaEndpointEmbeddings:
+		var response dto.OpenAIEmbeddingResponse
+		if err := common.Unmarshal(body, &response); err != nil {
+			return body
+		}
+		embeddings := make([][]float64, 0, len(response.Data))
+		for _, item := range response.Data {
+			embeddings = append(embeddings, item.Embedding)
+		}
+		if w.endpoint == helper.OllamaEndpointEmbeddings {
+			legacy := dto.OllamaLegacyEmbeddingResponse{Embedding: []float64{}}
+			if len(embeddings) > 0 {
+				legacy.Embedding = embeddings[0]
+			}
+			converted = legacy
+		} else {
+			converted = dto.OllamaEmbedResponse{
+				Model:           w.model,
+				Embeddings:      embeddings,
+				TotalDuration:   time.Since(w.start).Nanoseconds(),
+				PromptEvalCount: response.Usage.PromptTokens,
+			}
+		}
+	default:
+		var response dto.OpenAITextResponse
+		if err := common.Unmarshal(body, &response); err != nil {
+			return body
+		}
+		w.usage = &response.Usage
+		content, thinking := "", ""
+		var calls []dto.ToolCallResponse
+		if len(response.Choices) > 0 {
+			choice := response.Choices[0]
+			content = choice.Message.StringContent()
+			thinking = choice.Message.ReasoningContent
+			if thinking == "" {
+				thinking = choice.Message.Reasoning
+			}
+			w.finishReason = choice.FinishReason
+			if len(choice.Message.ToolCalls) > 0 {
+				_ = common.Unmarshal(choice.Message.ToolCalls, &calls)
+			}
+		}
+		converted = w.buildResponse(content, thinking, calls, true)
+	}
+	data, err := common.Marshal(converted)
+	if err != nil {
+		return body
+	}
+	return data
+}
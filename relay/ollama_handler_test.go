@@ -0,0 +1,211 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestOllamaWriter(endpoint string) (*ollamaResponseWriter, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	return newOllamaResponseWriter(c.Writer, endpoint, "llama3"), recorder
+}
+
+func writeSSE(w *ollamaResponseWriter, payloads ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, payload := range payloads {
+		_, _ = w.WriteString("data: " + payload + "\n\n")
+	}
+}
+
+func decodeNDJSON(t *testing.T, body string) []dto.OllamaChatResponse {
+	t.Helper()
+	var lines []dto.OllamaChatResponse
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var line dto.OllamaChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestOllamaWriterChatStream(t *testing.T) {
+	w, recorder := newTestOllamaWriter(helper.OllamaEndpointChat)
+	writeSSE(w,
+		`{"choices":[{"index":0,"delta":{"content":"Hel"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":7,"total_tokens":19}}`,
+		`[DONE]`,
+	)
+	w.finish()
+
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("content type = %q", ct)
+	}
+	lines := decodeNDJSON(t, recorder.Body.String())
+	if len(lines) != 4 {
+		t.Fatalf("lines = %d, want 4: %s", len(lines), recorder.Body.String())
+	}
+	if lines[0].Message.Content != "Hel" || lines[1].Message.Content != "lo" || lines[0].Done {
+		t.Errorf("content chunks = %+v, %+v", lines[0].Message, lines[1].Message)
+	}
+	calls := lines[2].Message.ToolCalls
+	if len(calls) != 1 || calls[0].Function.Name != "weather" || string(calls[0].Function.Arguments) != `{"city":"Paris"}` {
+		t.Errorf("tool calls = %+v", calls)
+	}
+	final := lines[3]
+	if !final.Done || final.DoneReason != "stop" || final.PromptEvalCount != 12 || final.EvalCount != 7 {
+		t.Errorf("final chunk = %+v", final)
+	}
+}
+
+func TestOllamaWriterStreamWithoutDone(t *testing.T) {
+	w, recorder := newTestOllamaWriter(helper.OllamaEndpointGenerate)
+	w.Header().Set("Content-Type", "text/event-stream")
+	// the last line has no trailing newline and the upstream never sends [DONE]
+	_, _ = w.WriteString("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"sky\"}}]}\n\n")
+	_, _ = w.WriteString(`data: {"choices":[{"index":0,"delta":{"content":" blue"},"finish_reason":"length"}]}`)
+	w.finish()
+
+	lines := decodeNDJSON(t, recorder.Body.String())
+	if len(lines) != 3 {
+		t.Fatalf("lines = %d, want 3: %s", len(lines), recorder.Body.String())
+	}
+	if lines[0].Response == nil || *lines[0].Response != "sky" || lines[0].Message != nil {
+		t.Errorf("generate chunk = %+v", lines[0])
+	}
+	if !lines[2].Done || lines[2].DoneReason != "length" {
+		t.Errorf("final chunk = %+v", lines[2])
+	}
+}
+
+func TestOllamaWriterLlamaCppStream(t *testing.T) {
+	w, recorder := newTestOllamaWriter(helper.OllamaEndpointLlamaCompletion)
+	writeSSE(w,
+		`{"choices":[{"index":0,"delta":{"content":"Once"}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"length"}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`,
+		`[DONE]`,
+	)
+	w.finish()
+
+	if ct := recorder.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type = %q", ct)
+	}
+	var events []dto.LlamaCppCompletionResponse
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event dto.LlamaCppCompletionResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 || events[0].Content != "Once" || events[0].Stop {
+		t.Fatalf("events = %+v", events)
+	}
+	last := events[1]
+	if !last.Stop || last.StopType != "limit" || last.TokensPredicted != 1 || last.TokensEvaluated != 3 || last.Model != "llama3" {
+		t.Errorf("final event = %+v", last)
+	}
+}
+
+func TestOllamaWriterBufferedChat(t *testing.T) {
+	w, recorder := newTestOllamaWriter(helper.OllamaEndpointChat)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", "999")
+	_, _ = w.WriteString(`{"choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Rome\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":20,"completion_tokens":9}}`)
+	w.finish()
+
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Error("stale content length was kept")
+	}
+	var response dto.OllamaChatResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode %s: %v", recorder.Body.String(), err)
+	}
+	if !response.Done || response.PromptEvalCount != 20 || response.EvalCount != 9 {
+		t.Errorf("response = %+v", response)
+	}
+	if response.Message == nil || len(response.Message.ToolCalls) != 1 || string(response.Message.ToolCalls[0].Function.Arguments) != `{"city":"Rome"}` {
+		t.Errorf("message = %+v", response.Message)
+	}
+}
+
+func TestOllamaWriterBufferedEmbeddings(t *testing.T) {
+	body := `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]},{"object":"embedding","index":1,"embedding":[0.3,0.4]}],"usage":{"prompt_tokens":4}}`
+
+	w, recorder := newTestOllamaWriter(helper.OllamaEndpointEmbed)
+	_, _ = w.WriteString(body)
+	w.finish()
+	var embed dto.OllamaEmbedResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &embed); err != nil {
+		t.Fatal(err)
+	}
+	if len(embed.Embeddings) != 2 || embed.Embeddings[1][0] != 0.3 || embed.PromptEvalCount != 4 || embed.Model != "llama3" {
+		t.Errorf("embed response = %s", recorder.Body.String())
+	}
+
+	w, recorder = newTestOllamaWriter(helper.OllamaEndpointEmbeddings)
+	_, _ = w.WriteString(body)
+	w.finish()
+	var legacy dto.OllamaLegacyEmbeddingResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy.Embedding) != 2 || legacy.Embedding[0] != 0.1 {
+		t.Errorf("legacy response = %s", recorder.Body.String())
+	}
+}
+
+func TestOllamaWriterError(t *testing.T) {
+	w, recorder := newTestOllamaWriter(helper.OllamaEndpointChat)
+	// an error status keeps an SSE content type from switching to streaming
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.WriteString(`{"error":{"message":"quota exceeded","type":"new_api_error"}}`)
+	w.finish()
+
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d", recorder.Code)
+	}
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Error != "quota exceeded" {
+		t.Errorf("error body = %s", recorder.Body.String())
+	}
+}
+
+func TestOllamaEndpoint(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/chat":            helper.OllamaEndpointChat,
+		"/ollama/api/chat":     helper.OllamaEndpointChat,
+		"/api/generate":        helper.OllamaEndpointGenerate,
+		"/api/embed":           helper.OllamaEndpointEmbed,
+		"/api/embeddings":      helper.OllamaEndpointEmbeddings,
+		"/completion":          helper.OllamaEndpointLlamaCompletion,
+		"/v1/chat/completions": "",
+	} {
+		if got := helper.OllamaEndpoint(path); got != want {
+			t.Errorf("OllamaEndpoint(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
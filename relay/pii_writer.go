@@ -0,0 +1,273 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service/pii"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// piiTokenHint finds a token, or the start of one at the end of a JSON
+// string, in a stream chunk; other chunks pass through untouched.
+var piiTokenHint = regexp.MustCompile(`\[PII_|\[(?:P(?:I(?:I(?:_[A-Z0-9_]*)?)?)?)?"`)
+
+const (
+	piiWriterPending = iota
+	piiWriterStream
+	piiWriterBody
+)
+
+// PIIRedactionSupported reports whether the prompt of the request can be
+// redacted and its response restored. Only chat completion messages are
+// rewritten, and pass-through sends the client's body upstream unchanged.
+func PIIRedactionSupported(info *relaycommon.RelayInfo) bool {
+	return info.RelayFormat == types.RelayFormatOpenAI &&
+		info.RelayMode == relayconstant.RelayModeChatCompletions &&
+		!model_setting.GetGlobalSettings().PassThroughRequestEnabled
+}
+
+// piiWriter restores tokenized PII in the upstream response before the
+// client sees it. Bodies are restored as they pass through; in streams the
+// content and tool argument deltas are restored per choice, holding back a
+// token that is split between chunks.
+type piiWriter struct {
+	gin.ResponseWriter
+	vault *pii.Vault
+
+	status  int
+	mode    int
+	pending bytes.Buffer
+	body    *pii.StreamDetokenizer
+	deltas  map[string]*pii.StreamDetokenizer
+
+	id      string
+	model   string
+	created int64
+}
+
+func newPIIWriter(w gin.ResponseWriter, vault *pii.Vault) *piiWriter {
+	return &piiWriter{
+		ResponseWriter: w,
+		vault:          vault,
+		status:         http.StatusOK,
+		body:           pii.NewStreamDetokenizer(vault, true),
+		deltas:         make(map[string]*pii.StreamDetokenizer),
+	}
+}
+
+func (w *piiWriter) WriteHeader(code int) {
+	if code > 0 && w.mode == piiWriterPending {
+		w.status = code
+	}
+}
+
+func (w *piiWriter) WriteHeaderNow() {}
+
+func (w *piiWriter) Status() int {
+	return w.status
+}
+
+func (w *piiWriter) Written() bool {
+	return w.mode != piiWriterPending
+}
+
+func (w *piiWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *piiWriter) Write(data []byte) (int, error) {
+	if w.mode == piiWriterPending {
+		w.mode = piiWriterBody
+		if strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") {
+			w.mode = piiWriterStream
+		}
+		// restored values change the length
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	if w.mode == piiWriterBody {
+		if _, err := w.ResponseWriter.Write([]byte(w.body.Push(string(data)))); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	w.pending.Write(data)
+	for {
+		buffered := w.pending.Bytes()
+		i := bytes.IndexByte(buffered, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(buffered[:i])
+		w.pending.Next(i + 1)
+		if _, err := w.ResponseWriter.Write([]byte(w.restoreLine(line) + "\n")); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *piiWriter) Flush() {
+	if w.mode != piiWriterPending {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// finish writes what is still held back once the response is complete.
+func (w *piiWriter) finish() {
+	switch w.mode {
+	case piiWriterBody:
+		if rest := w.body.Flush(); rest != "" {
+			_, _ = w.ResponseWriter.Write([]byte(rest))
+		}
+	case piiWriterStream:
+		if w.pending.Len() > 0 {
+			line := w.pending.String()
+			w.pending.Reset()
+			_, _ = w.ResponseWriter.Write([]byte(w.restoreLine(line)))
+		}
+	}
+}
+
+func (w *piiWriter) restoreLine(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "data:") {
+		return line
+	}
+	payload := strings.TrimSpace(strings.TrimPrefix(trimmed, "data:"))
+	if payload == "[DONE]" {
+		if rest := w.flushChunk(); rest != "" {
+			return "data: " + rest + "\n\ndata: [DONE]"
+		}
+		return line
+	}
+	if !piiTokenHint.MatchString(payload) && !w.holding() {
+		return line
+	}
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := common.Unmarshal([]byte(payload), &chunk); err != nil {
+		return "data: " + w.vault.Detokenize(payload, true)
+	}
+	if chunk.Id != "" {
+		w.id, w.model, w.created = chunk.Id, chunk.Model, chunk.Created
+	}
+	for i := range chunk.Choices {
+		choice := &chunk.Choices[i]
+		contentKey := fmt.Sprintf("%d", choice.Index)
+		if choice.Delta.Content != nil {
+			choice.Delta.SetContentString(w.detokenizer(contentKey).Push(*choice.Delta.Content))
+		}
+		for j := range choice.Delta.ToolCalls {
+			call := &choice.Delta.ToolCalls[j]
+			index := j
+			if call.Index != nil {
+				index = *call.Index
+			}
+			key := fmt.Sprintf("%d/%d", choice.Index, index)
+			call.Function.Arguments = w.detokenizer(key).Push(call.Function.Arguments)
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.flushChoice(choice)
+		}
+	}
+	data, err := common.Marshal(chunk)
+	if err != nil {
+		return line
+	}
+	return "data: " + string(data)
+}
+
+func (w *piiWriter) detokenizer(key string) *pii.StreamDetokenizer {
+	d, ok := w.deltas[key]
+	if !ok {
+		d = pii.NewStreamDetokenizer(w.vault, false)
+		w.deltas[key] = d
+	}
+	return d
+}
+
+func (w *piiWriter) holding() bool {
+	for _, d := range w.deltas {
+		if d.Holding() {
+			return true
+		}
+	}
+	return false
+}
+
+// flushChoice moves held text of a finished choice into its last chunk.
+func (w *piiWriter) flushChoice(choice *dto.ChatCompletionsStreamResponseChoice) {
+	prefix := fmt.Sprintf("%d", choice.Index)
+	keys := make([]string, 0, len(w.deltas))
+	for key := range w.deltas {
+		if key == prefix || strings.HasPrefix(key, prefix+"/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rest := w.deltas[key].Flush()
+		delete(w.deltas, key)
+		if rest == "" {
+			continue
+		}
+		if key == prefix {
+			choice.Delta.SetContentString(choice.Delta.GetContentString() + rest)
+			continue
+		}
+		var index int
+		_, _ = fmt.Sscanf(key[len(prefix)+1:], "%d", &index)
+		choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, dto.ToolCallResponse{
+			Index:    common.GetPointer(index),
+			Type:     "function",
+			Function: dto.FunctionResponse{Arguments: rest},
+		})
+	}
+}
+
+// flushChunk builds a chunk for text still held when the stream ends
+// without a finish reason.
+func (w *piiWriter) flushChunk() string {
+	if !w.holding() {
+		return ""
+	}
+	indexes := make(map[int]bool)
+	for key, d := range w.deltas {
+		if !d.Holding() {
+			continue
+		}
+		var index int
+		_, _ = fmt.Sscanf(key, "%d", &index)
+		indexes[index] = true
+	}
+	chunk := dto.ChatCompletionsStreamResponse{
+		Id:      w.id,
+		Object:  "chat.completion.chunk",
+		Created: w.created,
+		Model:   w.model,
+	}
+	for index := range indexes {
+		choice := dto.ChatCompletionsStreamResponseChoice{Index: index}
+		w.flushChoice(&choice)
+		chunk.Choices = append(chunk.Choices, choice)
+	}
+	sort.Slice(chunk.Choices, func(i, j int) bool { return chunk.Choices[i].Index < chunk.Choices[j].Index })
+	data, err := common.Marshal(chunk)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,41 @@
+package relay
+
+import (
+	"testing"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+func TestPIIRedactionSupported(t *testing.T) {
+	cases := []struct {
+		name   string
+		format types.RelayFormat
+		mode   int
+		want   bool
+	}{
+		{"chat completions", types.RelayFormatOpenAI, relayconstant.RelayModeChatCompletions, true},
+		{"legacy completions", types.RelayFormatOpenAI, relayconstant.RelayModeCompletions, false},
+		{"claude", types.RelayFormatClaude, relayconstant.RelayModeUnknown, false},
+		{"gemini", types.RelayFormatGemini, relayconstant.RelayModeUnknown, false},
+		{"responses", types.RelayFormatOpenAIResponses, relayconstant.RelayModeResponses, false},
+		{"embeddings", types.RelayFormatEmbedding, relayconstant.RelayModeEmbeddings, false},
+	}
+	for _, tc := range cases {
+		info := &relaycommon.RelayInfo{RelayFormat: tc.format, RelayMode: tc.mode}
+		if got := PIIRedactionSupported(info); got != tc.want {
+			t.Fatalf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	settings := model_setting.GetGlobalSettings()
+	old := settings.PassThroughRequestEnabled
+	settings.PassThroughRequestEnabled = true
+	defer func() { settings.PassThroughRequestEnabled = old }()
+	info := &relaycommon.RelayInfo{RelayFormat: types.RelayFormatOpenAI, RelayMode: relayconstant.RelayModeChatCompletions}
+	if PIIRedactionSupported(info) {
+		t.Fatal("chat completions must not be redacted under global pass-through")
+	}
+}
@@ -0,0 +1,592 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// OpenAI Realtime pcm16 audio: 24kHz, 16-bit, mono.
+	cascadeSampleRate     = 24000
+	cascadeBytesPerSecond = cascadeSampleRate * 2
+	// audio deltas are sent in half-second chunks
+	cascadeAudioChunkSize = cascadeBytesPerSecond / 2
+)
+
+// cascadeLeg is one hop of the cascade. The chat leg runs on the channel the
+// realtime session was routed to; the speech legs pick their own channel.
+type cascadeLeg struct {
+	name    string
+	model   string
+	path    string
+	format  types.RelayFormat
+	ownsKey bool // selects its own channel for model
+
+	// captured on the first call and reused for billing
+	ctx   *gin.Context
+	info  *relaycommon.RelayInfo
+	usage *dto.RealtimeUsage
+}
+
+// realtimeCascade serves an OpenAI Realtime client from a channel without a
+// realtime API by chaining speech-to-text, chat completion and text-to-speech.
+// It has no voice activity detection: a turn ends when the client commits the
+// audio buffer or asks for a response.
+type realtimeCascade struct {
+	c       *gin.Context
+	info    *relaycommon.RelayInfo
+	cfg     *config.RealtimeBridgeConfig
+	writer  *helper.RealtimeEventWriter
+	session dto.RealtimeSession
+
+	audio    []byte
+	messages []dto.Message
+
+	stt  *cascadeLeg
+	chat *cascadeLeg
+	tts  *cascadeLeg
+}
+
+func realtimeCascadeHelper(c *gin.Context, info *relaycommon.RelayInfo) (*types.NewAPIError, *dto.RealtimeUsage) {
+	cfg := config.GetRealtimeBridgeConfig()
+	info.IsStream = true
+	s := &realtimeCascade{
+		c:      c,
+		info:   info,
+		cfg:    cfg,
+		writer: helper.NewRealtimeEventWriter(c, info.ClientWs),
+		session: dto.RealtimeSession{
+			Modalities:        []string{"text", "audio"},
+			Voice:             cfg.CascadeVoice,
+			InputAudioFormat:  "pcm16",
+			OutputAudioFormat: "pcm16",
+		},
+		stt:  &cascadeLeg{name: "stt", model: cfg.CascadeSTTModel, path: "/v1/audio/transcriptions", format: types.RelayFormatOpenAIAudio, ownsKey: true},
+		chat: &cascadeLeg{name: "chat", model: info.OriginModelName, path: "/v1/chat/completions", format: types.RelayFormatOpenAI},
+		tts:  &cascadeLeg{name: "tts", model: cfg.CascadeTTSModel, path: "/v1/audio/speech", format: types.RelayFormatOpenAIAudio, ownsKey: true},
+	}
+	for _, leg := range []*cascadeLeg{s.stt, s.chat, s.tts} {
+		leg.usage = &dto.RealtimeUsage{}
+	}
+
+	if err := s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionCreated, Session: &s.session}); err != nil {
+		return types.NewError(err, types.ErrorCodeBadResponse), nil
+	}
+
+	for {
+		_, message, err := info.ClientWs.ReadMessage()
+		if err != nil {
+			break
+		}
+		info.SetFirstResponseTime()
+		event := &dto.RealtimeEvent{}
+		if err := common.Unmarshal(message, event); err != nil {
+			_ = s.writer.SendError("invalid_event", err.Error())
+			continue
+		}
+		if err := s.handleEvent(event); err != nil {
+			logger.LogError(c, "realtime cascade error: "+err.Error())
+			_ = s.writer.SendError("cascade_error", err.Error())
+			if errors.Is(err, errCascadeQuota) {
+				break
+			}
+		}
+	}
+
+	// The speech legs are billed against their own models; the chat leg is
+	// returned so that WssHelper settles it like any realtime session.
+	for _, leg := range []*cascadeLeg{s.stt, s.tts} {
+		if leg.info != nil && leg.usage.TotalTokens != 0 {
+			service.PostWssConsumeQuota(leg.ctx, leg.info, leg.model, leg.usage, "realtime cascade "+leg.name+" leg")
+		}
+	}
+	return nil, s.chat.usage
+}
+
+var errCascadeQuota = errors.New("insufficient quota")
+
+func (s *realtimeCascade) handleEvent(event *dto.RealtimeEvent) error {
+	switch event.Type {
+	case dto.RealtimeEventTypeSessionUpdate:
+		if event.Session != nil {
+			s.mergeSession(event.Session)
+		}
+		return s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: &s.session})
+	case dto.RealtimeEventInputAudioBufferAppend:
+		chunk, err := base64.StdEncoding.DecodeString(event.Audio)
+		if err != nil {
+			return fmt.Errorf("invalid audio: %w", err)
+		}
+		limit := s.cfg.CascadeMaxAudioSeconds * cascadeBytesPerSecond
+		if limit > 0 && len(s.audio)+len(chunk) > limit {
+			return fmt.Errorf("input audio buffer exceeds %d seconds", s.cfg.CascadeMaxAudioSeconds)
+		}
+		s.audio = append(s.audio, chunk...)
+	case dto.RealtimeEventInputAudioBufferCommit:
+		if len(s.audio) == 0 {
+			return errors.New("input audio buffer is empty")
+		}
+		if err := s.transcribe(); err != nil {
+			return err
+		}
+		if s.session.TurnDetection != nil {
+			return s.respond()
+		}
+	case dto.RealtimeEventInputAudioBufferClear:
+		s.audio = nil
+		return s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCleared})
+	case dto.RealtimeEventTypeConversationCreate:
+		return s.addItem(event.Item)
+	case dto.RealtimeEventTypeResponseCreate:
+		if len(s.audio) > 0 {
+			if err := s.transcribe(); err != nil {
+				return err
+			}
+		}
+		return s.respond()
+	case dto.RealtimeEventTypeResponseCancel:
+		// turns run to completion before the next event is read
+	}
+	return nil
+}
+
+func (s *realtimeCascade) mergeSession(session *dto.RealtimeSession) {
+	if session.Modalities != nil {
+		s.session.Modalities = session.Modalities
+	}
+	s.session.Instructions = common.GetStringIfEmpty(session.Instructions, s.session.Instructions)
+	s.session.Voice = common.GetStringIfEmpty(session.Voice, s.session.Voice)
+	if session.Tools != nil {
+		s.session.Tools = session.Tools
+		s.info.RealtimeTools = session.Tools
+	}
+	s.session.ToolChoice = common.GetStringIfEmpty(session.ToolChoice, s.session.ToolChoice)
+	if session.Temperature > 0 {
+		s.session.Temperature = session.Temperature
+	}
+	s.session.TurnDetection = session.TurnDetection
+}
+
+func (s *realtimeCascade) addItem(item *dto.RealtimeItem) error {
+	if item == nil {
+		return errors.New("item is required")
+	}
+	if item.Id == "" {
+		item.Id = s.writer.NewId("item")
+	}
+	switch item.Type {
+	case "function_call_output":
+		message := dto.Message{Role: "tool", ToolCallId: item.CallId}
+		message.SetStringContent(item.Output)
+		s.messages = append(s.messages, message)
+	case "message":
+		var text strings.Builder
+		for _, content := range item.Content {
+			text.WriteString(common.GetStringIfEmpty(content.Text, content.Transcript))
+		}
+		role := item.Role
+		if role != "assistant" && role != "system" {
+			role = "user"
+		}
+		message := dto.Message{Role: role}
+		message.SetStringContent(text.String())
+		s.messages = append(s.messages, message)
+	default:
+		return fmt.Errorf("unsupported item type: %s", item.Type)
+	}
+	return s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventConversationItemCreated, Item: item})
+}
+
+func (s *realtimeCascade) transcribe() error {
+	audio := s.audio
+	s.audio = nil
+	itemId := s.writer.NewId("item")
+	if err := s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCommitted, ItemId: itemId}); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("model", s.stt.model)
+	part, err := form.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(pcm16ToWav(audio, cascadeSampleRate)); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	request := &dto.AudioRequest{Model: s.stt.model}
+	out, usage, err := s.runLeg(s.stt, request, body.Bytes(), form.FormDataContentType(),
+		func(ctx *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo) (io.Reader, error) {
+			if err := ctx.Request.ParseMultipartForm(32 << 20); err != nil {
+				return nil, err
+			}
+			return adaptor.ConvertAudioRequest(ctx, info, *request)
+		})
+	if err != nil {
+		return err
+	}
+	var transcription struct {
+		Text string `json:"text"`
+	}
+	if err := common.Unmarshal(out, &transcription); err != nil {
+		return fmt.Errorf("invalid transcription response: %w", err)
+	}
+
+	if usage != nil {
+		if err := s.consume(s.stt, &dto.RealtimeUsage{
+			TotalTokens:        usage.PromptTokens + usage.CompletionTokens,
+			InputTokens:        usage.PromptTokens,
+			OutputTokens:       usage.CompletionTokens,
+			InputTokenDetails:  dto.InputTokenDetails{AudioTokens: usage.PromptTokens},
+			OutputTokenDetails: dto.OutputTokenDetails{TextTokens: usage.CompletionTokens},
+		}); err != nil {
+			return err
+		}
+	}
+
+	message := dto.Message{Role: "user"}
+	message.SetStringContent(transcription.Text)
+	s.messages = append(s.messages, message)
+	if err := s.writer.Send(&dto.RealtimeEvent{
+		Type: dto.RealtimeEventConversationItemCreated,
+		Item: &dto.RealtimeItem{
+			Id:      itemId,
+			Type:    "message",
+			Status:  "completed",
+			Role:    "user",
+			Content: []dto.RealtimeContent{{Type: "input_audio", Transcript: transcription.Text}},
+		},
+	}); err != nil {
+		return err
+	}
+	return s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioTranscriptionCompleted, ItemId: itemId, Transcript: transcription.Text})
+}
+
+func (s *realtimeCascade) respond() error {
+	responseId := s.writer.NewId("resp")
+	itemId := s.writer.NewId("item")
+	turnUsage := &dto.RealtimeUsage{}
+	if err := s.writer.Send(&dto.RealtimeEvent{
+		Type:     dto.RealtimeEventResponseCreated,
+		Response: &dto.RealtimeResponse{Id: responseId, Object: "realtime.response", Status: "in_progress"},
+	}); err != nil {
+		return err
+	}
+
+	request := &dto.GeneralOpenAIRequest{Model: s.chat.model}
+	if s.session.Instructions != "" {
+		system := dto.Message{Role: "system"}
+		system.SetStringContent(s.session.Instructions)
+		request.Messages = append(request.Messages, system)
+	}
+	request.Messages = append(request.Messages, s.messages...)
+	for _, tool := range s.session.Tools {
+		request.Tools = append(request.Tools, dto.ToolCallRequest{
+			Type:     "function",
+			Function: dto.FunctionRequest{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	if s.session.ToolChoice != "" && len(request.Tools) > 0 {
+		request.ToolChoice = s.session.ToolChoice
+	}
+	if s.session.Temperature > 0 {
+		temperature := s.session.Temperature
+		request.Temperature = &temperature
+	}
+	body, err := common.Marshal(request)
+	if err != nil {
+		return err
+	}
+	out, usage, err := s.runLeg(s.chat, request, body, "application/json",
+		func(ctx *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo) (io.Reader, error) {
+			converted, err := adaptor.ConvertOpenAIRequest(ctx, info, request)
+			if err != nil {
+				return nil, err
+			}
+			data, err := common.Marshal(converted)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(data), nil
+		})
+	if err != nil {
+		return err
+	}
+	var completion dto.OpenAITextResponse
+	if err := common.Unmarshal(out, &completion); err != nil || len(completion.Choices) == 0 {
+		return errors.New("invalid chat completion response")
+	}
+	if usage != nil {
+		chatUsage := &dto.RealtimeUsage{
+			TotalTokens:        usage.TotalTokens,
+			InputTokens:        usage.PromptTokens,
+			OutputTokens:       usage.CompletionTokens,
+			InputTokenDetails:  dto.InputTokenDetails{TextTokens: usage.PromptTokens},
+			OutputTokenDetails: dto.OutputTokenDetails{TextTokens: usage.CompletionTokens},
+		}
+		if err := s.consume(s.chat, chatUsage); err != nil {
+			return err
+		}
+		turnUsage.Add(chatUsage)
+	}
+
+	reply := completion.Choices[0].Message
+	s.messages = append(s.messages, reply)
+
+	if toolCalls := reply.ParseToolCalls(); len(toolCalls) > 0 {
+		// the client answers with function_call_output items and a new response.create
+		for _, call := range toolCalls {
+			if err := s.writer.Send(&dto.RealtimeEvent{
+				Type:       dto.RealtimeEventResponseFunctionCallArgumentsDone,
+				ResponseId: responseId,
+				ItemId:     s.writer.NewId("item"),
+				CallId:     call.ID,
+				Name:       call.Function.Name,
+				Arguments:  call.Function.Arguments,
+			}); err != nil {
+				return err
+			}
+		}
+		return s.finish(responseId, turnUsage)
+	}
+
+	text := reply.StringContent()
+	if !s.wantsAudio() {
+		if text != "" {
+			if err := s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseTextDelta, ResponseId: responseId, ItemId: itemId, Delta: text}); err != nil {
+				return err
+			}
+		}
+		if err := s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseTextDone, ResponseId: responseId, ItemId: itemId, Text: text}); err != nil {
+			return err
+		}
+		return s.finish(responseId, turnUsage)
+	}
+
+	if text != "" {
+		speech, err := s.synthesize(text)
+		if err != nil {
+			return err
+		}
+		for start := 0; start < len(speech); start += cascadeAudioChunkSize {
+			end := min(start+cascadeAudioChunkSize, len(speech))
+			if err := s.writer.Send(&dto.RealtimeEvent{
+				Type:       dto.RealtimeEventResponseAudioDelta,
+				ResponseId: responseId,
+				ItemId:     itemId,
+				Delta:      base64.StdEncoding.EncodeToString(speech[start:end]),
+			}); err != nil {
+				return err
+			}
+		}
+		ttsTokens := service.CountTTSToken(text, s.tts.model)
+		ttsUsage := &dto.RealtimeUsage{
+			TotalTokens:       ttsTokens,
+			InputTokens:       ttsTokens,
+			InputTokenDetails: dto.InputTokenDetails{TextTokens: ttsTokens},
+		}
+		if err := s.consume(s.tts, ttsUsage); err != nil {
+			return err
+		}
+		turnUsage.Add(ttsUsage)
+		if err := s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseAudioTranscriptionDelta, ResponseId: responseId, ItemId: itemId, Delta: text}); err != nil {
+			return err
+		}
+	}
+	if err := s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseAudioTranscriptionDone, ResponseId: responseId, ItemId: itemId, Transcript: text}); err != nil {
+		return err
+	}
+	if err := s.writer.Send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseAudioDone, ResponseId: responseId, ItemId: itemId}); err != nil {
+		return err
+	}
+	return s.finish(responseId, turnUsage)
+}
+
+func (s *realtimeCascade) synthesize(text string) ([]byte, error) {
+	request := &dto.AudioRequest{
+		Model:          s.tts.model,
+		Input:          text,
+		Voice:          common.GetStringIfEmpty(s.session.Voice, s.cfg.CascadeVoice),
+		ResponseFormat: "pcm",
+	}
+	body, err := common.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := s.runLeg(s.tts, request, body, "application/json",
+		func(ctx *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo) (io.Reader, error) {
+			return adaptor.ConvertAudioRequest(ctx, info, *request)
+		})
+	return out, err
+}
+
+func (s *realtimeCascade) wantsAudio() bool {
+	for _, modality := range s.session.Modalities {
+		if modality == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *realtimeCascade) finish(responseId string, usage *dto.RealtimeUsage) error {
+	return s.writer.Send(&dto.RealtimeEvent{
+		Type:     dto.RealtimeEventTypeResponseDone,
+		Response: &dto.RealtimeResponse{Id: responseId, Object: "realtime.response", Status: "completed", Usage: usage},
+	})
+}
+
+// consume deducts quota for one turn of a leg as it happens, the same way
+// native realtime sessions are charged per response.
+func (s *realtimeCascade) consume(leg *cascadeLeg, usage *dto.RealtimeUsage) error {
+	if usage.TotalTokens == 0 {
+		return nil
+	}
+	info := leg.info
+	if leg == s.chat {
+		info = s.info
+	}
+	if err := service.PreWssConsumeQuota(s.c, info, usage); err != nil {
+		return fmt.Errorf("%w: %v", errCascadeQuota, err)
+	}
+	leg.usage.Add(usage)
+	return nil
+}
+
+// runLeg replays one HTTP relay request through the regular adaptor path on a
+// detached gin context and returns the client-facing response body.
+func (s *realtimeCascade) runLeg(leg *cascadeLeg, request dto.Request, body []byte, contentType string,
+	convert func(ctx *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo) (io.Reader, error)) ([]byte, *dto.Usage, error) {
+	ctx, recorder, err := s.newLegContext(leg, body, contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := relaycommon.GenRelayInfo(ctx, leg.format, request, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.InitChannelMeta(ctx)
+	if err := helper.ModelMappedHelper(ctx, info, request); err != nil {
+		return nil, nil, err
+	}
+	if _, err := helper.ModelPriceHelper(ctx, info, 0, request.GetTokenCountMeta()); err != nil {
+		return nil, nil, err
+	}
+	if leg.info == nil {
+		leg.ctx = ctx
+		leg.info = info
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, nil, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	requestBody, err := convert(ctx, adaptor, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := adaptor.DoRequest(ctx, info, requestBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		if httpResp.StatusCode != http.StatusOK {
+			return nil, nil, service.RelayErrorHandler(ctx.Request.Context(), httpResp, false)
+		}
+	}
+	usage, newAPIError := adaptor.DoResponse(ctx, httpResp, info)
+	if newAPIError != nil {
+		return nil, nil, newAPIError
+	}
+	if recorder.Code != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s leg failed with status %d", leg.name, recorder.Code)
+	}
+	u, _ := usage.(*dto.Usage)
+	return recorder.Body.Bytes(), u, nil
+}
+
+// newLegContext builds a request context carrying the session's auth and
+// channel keys. The cached request body and the billing preparation of the
+// realtime request are not inherited.
+func (s *realtimeCascade) newLegContext(leg *cascadeLeg, body []byte, contentType string) (*gin.Context, *httptest.ResponseRecorder, error) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	req, err := http.NewRequestWithContext(s.c.Request.Context(), http.MethodPost, leg.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	ctx.Request = req
+	for key, value := range s.c.Keys {
+		if key == common.KeyRequestBody || key == "billing_prepared" {
+			continue
+		}
+		ctx.Set(key, value)
+	}
+	if !leg.ownsKey {
+		return ctx, recorder, nil
+	}
+	selected, _, err := model.CacheGetRandomSatisfiedChannel(ctx, s.info.UsingGroup, leg.model, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if selected == nil {
+		return nil, nil, fmt.Errorf("no available channel for model %s in group %s", leg.model, s.info.UsingGroup)
+	}
+	if newAPIError := service.SetupContextForSelectedChannel(ctx, selected, leg.model); newAPIError != nil {
+		return nil, nil, newAPIError
+	}
+	common.SetContextKey(ctx, constant.ContextKeyOriginalModel, leg.model)
+	return ctx, recorder, nil
+}
+
+// pcm16ToWav wraps raw little-endian mono pcm16 in a WAV container so it can
+// be uploaded to transcription endpoints.
+func pcm16ToWav(pcm []byte, sampleRate int) []byte {
+	var buf bytes.Buffer
+	buf.Grow(44 + len(pcm))
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(1)) // mono
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*2))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(2))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
@@ -0,0 +1,216 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// newWsPair returns both ends of a websocket connection.
+func newWsPair(t *testing.T) (server *websocket.Conn, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server = <-conns
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return server, client
+}
+
+func readRealtimeEvent(t *testing.T, ws *websocket.Conn) *dto.RealtimeEvent {
+	t.Helper()
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	event := &dto.RealtimeEvent{}
+	if err := common.Unmarshal(message, event); err != nil {
+		t.Fatalf("decode event %s: %v", message, err)
+	}
+	return event
+}
+
+func TestRealtimeCascadeTextTurn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ratio_setting.InitRatioSettings()
+	service.InitHttpClient()
+	var upstreamRequest dto.GeneralOpenAIRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = common.Unmarshal(body, &upstreamRequest)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-mini",` +
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"Hello there"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`))
+	}))
+	defer upstream.Close()
+
+	server, client := newWsPair(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/realtime?model=gpt-4o-mini", nil)
+	c.Set(common.RequestIdKey, "test")
+	common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, "gpt-4o-mini")
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyChannelType, constant.ChannelTypeOpenAI)
+	common.SetContextKey(c, constant.ContextKeyChannelBaseUrl, upstream.URL)
+	common.SetContextKey(c, constant.ContextKeyChannelKey, "sk-upstream")
+	info := &relaycommon.RelayInfo{
+		OriginModelName: "gpt-4o-mini",
+		UsingGroup:      "default",
+		ChannelMeta:     &relaycommon.ChannelMeta{},
+		ClientWs:        server,
+	}
+	// priced per call, so per-turn charging skips the quota lookup
+	info.UsePrice = true
+
+	type result struct {
+		usage *dto.RealtimeUsage
+	}
+	done := make(chan result, 1)
+	go func() {
+		newAPIError, usage := realtimeCascadeHelper(c, info)
+		if newAPIError != nil {
+			t.Errorf("cascade: %v", newAPIError)
+		}
+		done <- result{usage}
+	}()
+
+	if event := readRealtimeEvent(t, client); event.Type != dto.RealtimeEventTypeSessionCreated {
+		t.Fatalf("first event = %s, want session.created", event.Type)
+	}
+	send := func(event *dto.RealtimeEvent) {
+		if err := client.WriteJSON(event); err != nil {
+			t.Fatalf("send %s: %v", event.Type, err)
+		}
+	}
+	send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdate, Session: &dto.RealtimeSession{
+		Modalities: []string{"text"}, Instructions: "Be brief.",
+	}})
+	if event := readRealtimeEvent(t, client); event.Type != dto.RealtimeEventTypeSessionUpdated || event.Session.Instructions != "Be brief." {
+		t.Fatalf("unexpected session.updated: %+v", event)
+	}
+	send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeConversationCreate, Item: &dto.RealtimeItem{
+		Type: "message", Role: "user", Content: []dto.RealtimeContent{{Type: "input_text", Text: "Hi"}},
+	}})
+	if event := readRealtimeEvent(t, client); event.Type != dto.RealtimeEventConversationItemCreated || event.Item.Id == "" {
+		t.Fatalf("unexpected item event: %+v", event)
+	}
+	send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseCreate})
+
+	var types []string
+	var text string
+	var responseUsage *dto.RealtimeUsage
+	for {
+		event := readRealtimeEvent(t, client)
+		types = append(types, event.Type)
+		if event.Type == dto.RealtimeEventTypeError {
+			t.Fatalf("cascade error: %+v", event.Error)
+		}
+		if event.Type == dto.RealtimeEventResponseTextDone {
+			text = event.Text
+		}
+		if event.Type == dto.RealtimeEventTypeResponseDone {
+			responseUsage = event.Response.Usage
+			break
+		}
+	}
+	want := []string{dto.RealtimeEventResponseCreated, dto.RealtimeEventResponseTextDelta, dto.RealtimeEventResponseTextDone, dto.RealtimeEventTypeResponseDone}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	if text != "Hello there" || responseUsage == nil || responseUsage.TotalTokens != 15 {
+		t.Fatalf("unexpected response: text %q, usage %+v", text, responseUsage)
+	}
+	if len(upstreamRequest.Messages) != 2 || upstreamRequest.Messages[0].Role != "system" ||
+		upstreamRequest.Messages[0].StringContent() != "Be brief." || upstreamRequest.Messages[1].StringContent() != "Hi" {
+		t.Fatalf("unexpected upstream messages: %+v", upstreamRequest.Messages)
+	}
+
+	_ = client.Close()
+	select {
+	case r := <-done:
+		if r.usage.TotalTokens != 15 || r.usage.InputTokenDetails.TextTokens != 12 || r.usage.OutputTokenDetails.TextTokens != 3 {
+			t.Fatalf("session usage = %+v", r.usage)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cascade did not return after the client closed")
+	}
+}
+
+func TestRealtimeCascadeInputBuffer(t *testing.T) {
+	server, client := newWsPair(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	s := &realtimeCascade{
+		c:      c,
+		info:   &relaycommon.RelayInfo{},
+		cfg:    &config.RealtimeBridgeConfig{CascadeMaxAudioSeconds: 1},
+		writer: helper.NewRealtimeEventWriter(c, server),
+	}
+
+	second := strings.Repeat("A", cascadeBytesPerSecond/3*4)
+	if err := s.handleEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferAppend, Audio: second}); err != nil {
+		t.Fatalf("append within the limit: %v", err)
+	}
+	if err := s.handleEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferAppend, Audio: "AAAA"}); err == nil {
+		t.Fatal("expected the buffer limit to be enforced")
+	}
+	if err := s.handleEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferClear}); err != nil || len(s.audio) != 0 {
+		t.Fatalf("clear: %v, %d bytes left", err, len(s.audio))
+	}
+	if event := readRealtimeEvent(t, client); event.Type != dto.RealtimeEventInputAudioBufferCleared {
+		t.Fatalf("event = %s, want input_audio_buffer.cleared", event.Type)
+	}
+	if err := s.handleEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCommit}); err == nil {
+		t.Fatal("expected committing an empty buffer to fail")
+	}
+	if err := s.handleEvent(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeConversationCreate, Item: &dto.RealtimeItem{Type: "image"}}); err == nil {
+		t.Fatal("expected unsupported items to be refused")
+	}
+}
+
+func TestPcm16ToWav(t *testing.T) {
+	pcm := []byte{1, 2, 3, 4}
+	wav := pcm16ToWav(pcm, cascadeSampleRate)
+	if len(wav) != 44+len(pcm) || string(wav[0:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " || string(wav[36:40]) != "data" {
+		t.Fatalf("unexpected header % x", wav[:44])
+	}
+	if rate := binary.LittleEndian.Uint32(wav[24:28]); rate != cascadeSampleRate {
+		t.Fatalf("sample rate = %d", rate)
+	}
+	if size := binary.LittleEndian.Uint32(wav[40:44]); size != uint32(len(pcm)) || !bytes.Equal(wav[44:], pcm) {
+		t.Fatalf("unexpected data chunk")
+	}
+}
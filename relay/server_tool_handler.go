@@ -0,0 +1,433 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+type serverToolRecord struct {
+	Turn      int    `json:"turn"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	IsError   bool   `json:"is_error"`
+}
+
+// serverToolLoop runs a chat completion with gateway-executed tools. Each
+// model turn goes through TextHelper, so it is billed and logged on its own;
+// while the model only calls server tools the gateway runs them, appends the
+// results and asks again. Streams carry the model's text as it arrives plus
+// "server_tool" status chunks, and end with the final turn's finish and usage.
+func serverToolLoop(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest, tools []*model.ServerTool) *types.NewAPIError {
+	byName := make(map[string]*model.ServerTool, len(tools))
+	definitions := make([]dto.ToolCallRequest, 0, len(tools))
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+		definitions = append(definitions, service.ServerToolDefinition(tool))
+	}
+	maxIterations := config.GetServerToolConfig().MaxIterations
+	if maxIterations < 1 {
+		maxIterations = 1
+	}
+
+	original := c.Writer
+	writer := newServerToolWriter(original, func(name string) bool {
+		return byName[name] != nil
+	})
+	c.Writer = writer
+	originalBody, _ := common.GetRequestBody(c)
+	defer func() {
+		// a retry on another channel starts again from the client's request
+		c.Writer = original
+		info.Request = request
+		c.Set(common.KeyRequestBody, originalBody)
+		common.SetContextKey(c, constant.ContextKeyServerToolTurn, 0)
+	}()
+
+	messages := append([]dto.Message(nil), request.Messages...)
+	records := make([]serverToolRecord, 0)
+	for turn := 1; ; turn++ {
+		turnRequest := *request
+		turnRequest.ServerTools = nil
+		turnRequest.Messages = messages
+		turnRequest.Tools = append(append([]dto.ToolCallRequest(nil), request.Tools...), definitions...)
+		if turn > 1 && !isAutoToolChoice(request.ToolChoice) {
+			// a forced choice would call the same tool forever
+			turnRequest.ToolChoice = nil
+		}
+		lastTurn := turn >= maxIterations
+		if lastTurn {
+			turnRequest.ToolChoice = "none"
+		}
+		body, err := common.Marshal(&turnRequest)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+		}
+		c.Set(common.KeyRequestBody, body)
+		info.Request = &turnRequest
+		if turn > 1 {
+			// each later turn must fit the quota and spending budgets again;
+			// the previous turn's pre-consumption was settled by TextHelper
+			info.FinalPreConsumedQuota = 0
+			if !info.PriceData.FreeModel {
+				if newAPIError := service.PreConsumeQuota(c, info.PriceData.QuotaToPreConsume, info); newAPIError != nil {
+					types.ErrOptionWithSkipRetry()(newAPIError)
+					return newAPIError
+				}
+			}
+		}
+		common.SetContextKey(c, constant.ContextKeyServerToolTurn, turn)
+
+		writer.beginTurn()
+		if newAPIError := TextHelper(c, info); newAPIError != nil {
+			if turn > 1 {
+				// earlier turns were already delivered and billed
+				types.ErrOptionWithSkipRetry()(newAPIError)
+			}
+			return newAPIError
+		}
+		calls, content := writer.endTurn(lastTurn)
+		if calls == nil {
+			writer.finish(records)
+			return nil
+		}
+
+		for i := range calls {
+			if calls[i].ID == "" {
+				calls[i].ID = fmt.Sprintf("call_%d_%d", turn, i)
+			}
+			calls[i].Type = "function"
+		}
+		assistant := dto.Message{Role: "assistant"}
+		if content != "" {
+			assistant.Content = content
+		}
+		assistant.SetToolCalls(calls)
+		messages = append(messages, assistant)
+		for _, call := range calls {
+			tool := byName[call.Function.Name]
+			writer.writeStatus(turn, call, "running")
+			output, err := service.RunServerTool(c, tool, call.Function.Arguments)
+			status := "completed"
+			if err != nil {
+				status = "failed"
+			}
+			writer.writeStatus(turn, call, status)
+			records = append(records, serverToolRecord{
+				Turn:      turn,
+				Name:      tool.Name,
+				Arguments: call.Function.Arguments,
+				IsError:   err != nil,
+			})
+			messages = append(messages, dto.Message{Role: "tool", ToolCallId: call.ID, Content: output})
+		}
+	}
+}
+
+func isAutoToolChoice(choice any) bool {
+	if choice == nil {
+		return true
+	}
+	value, ok := choice.(string)
+	return ok && (value == "auto" || value == "none")
+}
+
+const (
+	serverToolWriterPending = iota
+	serverToolWriterStream
+	serverToolWriterBuffered
+)
+
+// serverToolWriter sits between the adaptor and the client for one loop. In
+// streams it forwards text deltas immediately and holds back tool call,
+// finish and usage chunks until it knows whether the turn ends the loop;
+// JSON responses are buffered per turn.
+type serverToolWriter struct {
+	gin.ResponseWriter
+	isServerTool func(string) bool
+
+	headerSent bool
+	status     int
+	mode       int
+	pending    bytes.Buffer
+
+	held      []string
+	toolCalls map[int]*dto.ToolCallResponse
+	content   strings.Builder
+
+	id      string
+	model   string
+	created int64
+}
+
+func newServerToolWriter(w gin.ResponseWriter, isServerTool func(string) bool) *serverToolWriter {
+	return &serverToolWriter{
+		ResponseWriter: w,
+		isServerTool:   isServerTool,
+		created:        time.Now().Unix(),
+	}
+}
+
+func (w *serverToolWriter) beginTurn() {
+	w.status = http.StatusOK
+	w.mode = serverToolWriterPending
+	w.pending.Reset()
+	w.held = nil
+	w.toolCalls = make(map[int]*dto.ToolCallResponse)
+	w.content.Reset()
+}
+
+func (w *serverToolWriter) WriteHeader(code int) {
+	if code > 0 && w.mode == serverToolWriterPending {
+		w.status = code
+	}
+}
+
+func (w *serverToolWriter) WriteHeaderNow() {}
+
+func (w *serverToolWriter) Status() int {
+	return w.status
+}
+
+func (w *serverToolWriter) Written() bool {
+	return w.mode != serverToolWriterPending
+}
+
+func (w *serverToolWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *serverToolWriter) Write(data []byte) (int, error) {
+	if w.mode == serverToolWriterPending {
+		w.mode = serverToolWriterBuffered
+		if w.status < http.StatusBadRequest && strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") {
+			w.mode = serverToolWriterStream
+			w.sendHeader(w.status)
+		}
+	}
+	w.pending.Write(data)
+	if w.mode == serverToolWriterStream {
+		w.processLines(false)
+	}
+	return len(data), nil
+}
+
+func (w *serverToolWriter) Flush() {
+	if w.mode == serverToolWriterStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *serverToolWriter) sendHeader(status int) {
+	if w.headerSent {
+		return
+	}
+	w.headerSent = true
+	w.ResponseWriter.WriteHeader(status)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *serverToolWriter) processLines(final bool) {
+	for {
+		buffered := w.pending.Bytes()
+		i := bytes.IndexByte(buffered, '\n')
+		if i < 0 {
+			if final && len(buffered) > 0 {
+				line := string(buffered)
+				w.pending.Reset()
+				w.handleStreamLine(line)
+			}
+			return
+		}
+		line := string(buffered[:i])
+		w.pending.Next(i + 1)
+		w.handleStreamLine(line)
+	}
+}
+
+func (w *serverToolWriter) handleStreamLine(line string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if payload == "[DONE]" {
+		return
+	}
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := common.Unmarshal([]byte(payload), &chunk); err != nil {
+		w.forward(payload)
+		return
+	}
+	if chunk.Id != "" {
+		w.id, w.model, w.created = chunk.Id, chunk.Model, chunk.Created
+	}
+	if len(chunk.Choices) == 0 {
+		if chunk.Usage != nil {
+			w.held = append(w.held, payload)
+		} else {
+			w.forward(payload)
+		}
+		return
+	}
+	delta := &chunk.Choices[0].Delta
+	w.content.WriteString(delta.GetContentString())
+	finished := chunk.Choices[0].FinishReason != nil && *chunk.Choices[0].FinishReason != ""
+	if len(delta.ToolCalls) == 0 && !finished {
+		w.forward(payload)
+		return
+	}
+	for _, call := range delta.ToolCalls {
+		index := len(w.toolCalls)
+		if call.Index != nil {
+			index = *call.Index
+		}
+		existing, ok := w.toolCalls[index]
+		if !ok {
+			copied := call
+			w.toolCalls[index] = &copied
+			continue
+		}
+		if call.ID != "" {
+			existing.ID = call.ID
+		}
+		if call.Function.Name != "" {
+			existing.Function.Name = call.Function.Name
+		}
+		existing.Function.Arguments += call.Function.Arguments
+	}
+	// text in the same chunk goes out now, the rest waits for the outcome
+	if delta.Content != nil || delta.ReasoningContent != nil || delta.Reasoning != nil {
+		text := chunk
+		text.Choices = []dto.ChatCompletionsStreamResponseChoice{{Index: chunk.Choices[0].Index}}
+		text.Choices[0].Delta = dto.ChatCompletionsStreamResponseChoiceDelta{
+			Role:             delta.Role,
+			Content:          delta.Content,
+			ReasoningContent: delta.ReasoningContent,
+			Reasoning:        delta.Reasoning,
+		}
+		text.Usage = nil
+		if data, err := common.Marshal(text); err == nil {
+			w.forward(string(data))
+		}
+		delta.Content, delta.ReasoningContent, delta.Reasoning = nil, nil, nil
+		if data, err := common.Marshal(chunk); err == nil {
+			payload = string(data)
+		}
+	}
+	w.held = append(w.held, payload)
+}
+
+func (w *serverToolWriter) forward(payload string) {
+	_, _ = w.ResponseWriter.Write([]byte("data: " + payload + "\n\n"))
+	w.ResponseWriter.Flush()
+}
+
+// endTurn returns the server tool calls to run, or nil when the turn is the
+// final answer. Calls to client tools, or any call on the last turn, end the
+// loop and are delivered as-is.
+func (w *serverToolWriter) endTurn(lastTurn bool) ([]dto.ToolCallRequest, string) {
+	var calls []dto.ToolCallRequest
+	content := ""
+	switch w.mode {
+	case serverToolWriterStream:
+		w.processLines(true)
+		indexes := make([]int, 0, len(w.toolCalls))
+		for index := range w.toolCalls {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		for _, index := range indexes {
+			call := w.toolCalls[index]
+			calls = append(calls, dto.ToolCallRequest{
+				ID:       call.ID,
+				Function: dto.FunctionRequest{Name: call.Function.Name, Arguments: call.Function.Arguments},
+			})
+		}
+		content = w.content.String()
+	case serverToolWriterBuffered:
+		if w.status >= http.StatusBadRequest {
+			return nil, ""
+		}
+		var response dto.OpenAITextResponse
+		if err := common.Unmarshal(w.pending.Bytes(), &response); err != nil || len(response.Choices) == 0 {
+			return nil, ""
+		}
+		calls = response.Choices[0].Message.ParseToolCalls()
+		content = response.Choices[0].Message.StringContent()
+	}
+	if len(calls) == 0 || lastTurn {
+		return nil, ""
+	}
+	for _, call := range calls {
+		if !w.isServerTool(call.Function.Name) {
+			return nil, ""
+		}
+	}
+	return calls, content
+}
+
+func (w *serverToolWriter) writeStatus(turn int, call dto.ToolCallRequest, status string) {
+	if w.mode != serverToolWriterStream {
+		return
+	}
+	data, err := common.Marshal(map[string]any{
+		"id":      w.id,
+		"object":  "chat.completion.chunk",
+		"created": w.created,
+		"model":   w.model,
+		"choices": []any{},
+		"server_tool": map[string]any{
+			"turn":    turn,
+			"call_id": call.ID,
+			"name":    call.Function.Name,
+			"status":  status,
+		},
+	})
+	if err == nil {
+		w.forward(string(data))
+	}
+}
+
+// finish delivers the final turn: the held stream chunks and [DONE], or the
+// buffered body annotated with the server tool calls that led to it.
+func (w *serverToolWriter) finish(records []serverToolRecord) {
+	switch w.mode {
+	case serverToolWriterStream:
+		for _, payload := range w.held {
+			w.forward(payload)
+		}
+		w.forward("[DONE]")
+	case serverToolWriterBuffered:
+		body := w.pending.Bytes()
+		if len(records) > 0 && w.status < http.StatusBadRequest {
+			var fields map[string]json.RawMessage
+			if err := common.Unmarshal(body, &fields); err == nil {
+				if encoded, err := common.Marshal(records); err == nil {
+					fields["server_tool_calls"] = encoded
+					if annotated, err := common.Marshal(fields); err == nil {
+						body = annotated
+					}
+				}
+			}
+		}
+		w.Header().Del("Content-Length")
+		w.sendHeader(w.status)
+		_, _ = w.ResponseWriter.Write(body)
+	}
+}
@@ -0,0 +1,350 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service/jsonschema"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// structuredOutputLoggedErrors bounds the validation errors kept in the log.
+const structuredOutputLoggedErrors = 5
+
+// structuredOutputWriterKey holds the loop's writer, which may be wrapped by
+// writers installed further down.
+const structuredOutputWriterKey = "structured_output_writer"
+
+// structuredOutputSchema returns the schema a chat completion must follow
+// and the response_format type, or nil when no structured output is asked for.
+func structuredOutputSchema(request *dto.GeneralOpenAIRequest) (json.RawMessage, string) {
+	if request.ResponseFormat == nil {
+		return nil, ""
+	}
+	switch request.ResponseFormat.Type {
+	case "json_schema":
+		var format dto.FormatJsonSchema
+		if err := common.Unmarshal(request.ResponseFormat.JsonSchema, &format); err != nil || format.Schema == nil {
+			return nil, ""
+		}
+		schema, err := common.Marshal(format.Schema)
+		if err != nil {
+			return nil, ""
+		}
+		return schema, "json_schema"
+	case "json_object":
+		return json.RawMessage(`{"type":"object"}`), "json_object"
+	}
+	return nil, ""
+}
+
+// structuredOutputLoop validates the answer of a response_format request.
+// An invalid non-stream answer is sent back to the same channel with the
+// validation errors as many times as configured; after that it is either
+// moved to another channel, rejected or delivered as-is. Streams are
+// forwarded unchanged and only validated for the log.
+func structuredOutputLoop(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest, schema json.RawMessage, mode string) *types.NewAPIError {
+	cfg := config.GetStructuredOutputConfig()
+	maxAttempts := 1 + cfg.RepairAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	original := c.Writer
+	writer := &structuredOutputWriter{ResponseWriter: original, schema: schema, format: mode}
+	c.Writer = writer
+	c.Set(structuredOutputWriterKey, writer)
+	originalBody, _ := common.GetRequestBody(c)
+	defer func() {
+		// a retry on another channel starts again from the client's request
+		c.Writer = original
+		c.Set(structuredOutputWriterKey, nil)
+		info.Request = request
+		c.Set(common.KeyRequestBody, originalBody)
+		common.SetContextKey(c, constant.ContextKeyStructuredOutputAttempt, 0)
+	}()
+
+	messages := append([]dto.Message(nil), request.Messages...)
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			repairRequest := *request
+			repairRequest.Messages = messages
+			body, err := common.Marshal(&repairRequest)
+			if err != nil {
+				return types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+			}
+			c.Set(common.KeyRequestBody, body)
+			info.Request = &repairRequest
+		}
+		common.SetContextKey(c, constant.ContextKeyStructuredOutputAttempt, attempt)
+
+		writer.beginAttempt(attempt)
+		if newAPIError := TextHelper(c, info); newAPIError != nil {
+			if attempt > 1 {
+				// the earlier attempts were already billed
+				types.ErrOptionWithSkipRetry()(newAPIError)
+			}
+			return newAPIError
+		}
+		// the pre-consumed quota is settled; repairs and fallbacks are billed in full
+		info.FinalPreConsumedQuota = 0
+
+		result := writer.validate()
+		if result == nil || result.valid || writer.mode == structuredOutputWriterStream {
+			writer.deliver()
+			return nil
+		}
+		if attempt < maxAttempts {
+			messages = append(messages,
+				dto.Message{Role: "assistant", Content: result.output},
+				dto.Message{Role: "user", Content: structuredOutputRepairPrompt(result.errors)},
+			)
+			continue
+		}
+		err := fmt.Errorf("output does not match the response_format schema: %s", result.summary())
+		if cfg.FallbackChannel && structuredOutputCanFallback(c) {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeStructuredOutputInvalid, http.StatusBadGateway)
+		}
+		if cfg.RejectInvalid {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeStructuredOutputInvalid, http.StatusUnprocessableEntity, types.ErrOptionWithSkipRetry())
+		}
+		writer.deliver()
+		return nil
+	}
+}
+
+// structuredOutputCanFallback reports whether the controller still has a
+// channel retry left for this request.
+func structuredOutputCanFallback(c *gin.Context) bool {
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	return common.RetryTimes-(len(c.GetStringSlice("use_channel"))-1) > 0
+}
+
+func structuredOutputRepairPrompt(errors []jsonschema.ValidationError) string {
+	var b strings.Builder
+	b.WriteString("Your previous answer does not match the required JSON schema:\n")
+	for _, e := range errors {
+		b.WriteString("- " + e.String() + "\n")
+	}
+	b.WriteString("Reply again with only the corrected JSON, without code fences or any other text.")
+	return b.String()
+}
+
+// structuredOutputLogInfo describes the validation of the current attempt
+// for the consume log, or returns nil outside a structured output loop.
+func structuredOutputLogInfo(c *gin.Context) map[string]interface{} {
+	value, _ := c.Get(structuredOutputWriterKey)
+	writer, ok := value.(*structuredOutputWriter)
+	if !ok || writer == nil {
+		return nil
+	}
+	result := writer.validate()
+	if result == nil {
+		return nil
+	}
+	info := map[string]interface{}{
+		"mode":    writer.format,
+		"attempt": writer.attempt,
+		"valid":   result.valid,
+	}
+	if !result.valid {
+		errors := make([]string, 0, structuredOutputLoggedErrors)
+		for i := 0; i < len(result.errors) && i < structuredOutputLoggedErrors; i++ {
+			errors = append(errors, result.errors[i].String())
+		}
+		info["errors"] = errors
+	}
+	return info
+}
+
+type structuredOutputResult struct {
+	valid  bool
+	output string
+	errors []jsonschema.ValidationError
+}
+
+func (r *structuredOutputResult) summary() string {
+	parts := make([]string, 0, structuredOutputLoggedErrors)
+	for i := 0; i < len(r.errors) && i < structuredOutputLoggedErrors; i++ {
+		parts = append(parts, r.errors[i].String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+const (
+	structuredOutputWriterPending = iota
+	structuredOutputWriterStream
+	structuredOutputWriterBuffered
+)
+
+// structuredOutputWriter holds back JSON responses until they are validated
+// and passes streams through while collecting their text.
+type structuredOutputWriter struct {
+	gin.ResponseWriter
+	schema json.RawMessage
+	format string
+
+	attempt   int
+	status    int
+	mode      int
+	body      bytes.Buffer
+	pending   bytes.Buffer
+	content   strings.Builder
+	toolCalls bool
+	checked   bool
+	result    *structuredOutputResult
+}
+
+func (w *structuredOutputWriter) beginAttempt(attempt int) {
+	w.attempt = attempt
+	w.status = http.StatusOK
+	w.mode = structuredOutputWriterPending
+	w.body.Reset()
+	w.pending.Reset()
+	w.content.Reset()
+	w.toolCalls = false
+	w.checked = false
+	w.result = nil
+}
+
+func (w *structuredOutputWriter) WriteHeader(code int) {
+	if code > 0 && w.mode == structuredOutputWriterPending {
+		w.status = code
+	}
+}
+
+func (w *structuredOutputWriter) WriteHeaderNow() {
+	if w.mode == structuredOutputWriterStream {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *structuredOutputWriter) Status() int {
+	return w.status
+}
+
+func (w *structuredOutputWriter) Written() bool {
+	return w.mode != structuredOutputWriterPending
+}
+
+func (w *structuredOutputWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *structuredOutputWriter) Write(data []byte) (int, error) {
+	if w.mode == structuredOutputWriterPending {
+		w.mode = structuredOutputWriterBuffered
+		if w.status < http.StatusBadRequest && strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") {
+			w.mode = structuredOutputWriterStream
+			w.ResponseWriter.WriteHeader(w.status)
+			w.ResponseWriter.WriteHeaderNow()
+		}
+	}
+	if w.mode == structuredOutputWriterBuffered {
+		return w.body.Write(data)
+	}
+	w.pending.Write(data)
+	w.collectLines(false)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *structuredOutputWriter) Flush() {
+	if w.mode == structuredOutputWriterStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *structuredOutputWriter) collectLines(final bool) {
+	for {
+		buffered := w.pending.Bytes()
+		i := bytes.IndexByte(buffered, '\n')
+		if i < 0 {
+			if final && len(buffered) > 0 {
+				line := string(buffered)
+				w.pending.Reset()
+				w.collectLine(line)
+			}
+			return
+		}
+		line := string(buffered[:i])
+		w.pending.Next(i + 1)
+		w.collectLine(line)
+	}
+}
+
+func (w *structuredOutputWriter) collectLine(line string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	var chunk dto.ChatCompletionsStreamResponse
+	if payload == "[DONE]" || common.Unmarshal([]byte(payload), &chunk) != nil || len(chunk.Choices) == 0 {
+		return
+	}
+	w.content.WriteString(chunk.Choices[0].Delta.GetContentString())
+	if len(chunk.Choices[0].Delta.ToolCalls) > 0 {
+		w.toolCalls = true
+	}
+}
+
+// validate checks the attempt's answer once. It returns nil when there is
+// nothing to check: an error response, or a turn that only calls tools.
+func (w *structuredOutputWriter) validate() *structuredOutputResult {
+	if w.checked {
+		return w.result
+	}
+	w.checked = true
+	output := ""
+	switch w.mode {
+	case structuredOutputWriterStream:
+		w.collectLines(true)
+		output = w.content.String()
+		if w.toolCalls && strings.TrimSpace(output) == "" {
+			return nil
+		}
+	case structuredOutputWriterBuffered:
+		if w.status >= http.StatusBadRequest {
+			return nil
+		}
+		var response dto.OpenAITextResponse
+		if err := common.Unmarshal(w.body.Bytes(), &response); err != nil || len(response.Choices) == 0 {
+			return nil
+		}
+		message := response.Choices[0].Message
+		output = message.StringContent()
+		if len(message.ParseToolCalls()) > 0 && strings.TrimSpace(output) == "" {
+			return nil
+		}
+	default:
+		return nil
+	}
+	errors, err := jsonschema.ValidateJSON(w.schema, output)
+	if err != nil {
+		common.SysLog("structured output schema error: " + err.Error())
+		return nil
+	}
+	w.result = &structuredOutputResult{valid: len(errors) == 0, output: output, errors: errors}
+	return w.result
+}
+
+// deliver sends a buffered answer to the client.
+func (w *structuredOutputWriter) deliver() {
+	if w.mode != structuredOutputWriterBuffered {
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+}
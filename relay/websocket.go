@@ -3,9 +3,11 @@ package relay
 import (
 	"fmt"
 
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/config"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -20,6 +22,24 @@ func WssHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.
 		return types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
 	}
 	adaptor.Init(info)
+
+	// Only OpenAI-compatible channels speak the realtime protocol natively;
+	// other channels are bridged when enabled.
+	bridgeConfig := config.GetRealtimeBridgeConfig()
+	nativeRealtime := info.ApiType == constant.APITypeOpenAI ||
+		(info.ApiType == constant.APITypeGemini && bridgeConfig.GeminiLiveEnabled)
+	if !nativeRealtime {
+		if !bridgeConfig.CascadeEnabled {
+			return types.NewError(fmt.Errorf("realtime is not supported by this channel"), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
+		}
+		newAPIError, usage := realtimeCascadeHelper(c, info)
+		if newAPIError != nil {
+			return newAPIError
+		}
+		service.PostWssConsumeQuota(c, info, info.UpstreamModelName, usage, "realtime cascade")
+		return nil
+	}
+
 	//var requestBody io.Reader
 	//firstWssRequest, _ := c.Get("first_wss_request")
 	//requestBody = bytes.NewBuffer(firstWssRequest.([]byte))
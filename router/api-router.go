@@ -18,7 +18,7 @@ func SetApiRouter(router *gin.Engine) {
         apiRouter.GET("/status", controller.GetStatus)
         apiRouter.GET("/uptime/status", controller.GetUptimeKumaStatus)
         apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
-        apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+        apiRouter.GET("/status/test", middleware.PermissionAuth("system"), controller.TestStatus)
         apiRouter.GET("/notice", controller.GetNotice)
         apiRouter.GET("/user-agreement", controller.GetUserAgreement)
         apiRouter.GET("/privacy-policy", controller.GetPrivacyPolicy)
@@ -31,6 +31,7 @@ func SetApiRouter(router *gin.Engine) {
         apiRouter.POST("/user/reset", middleware.CriticalRateLimit(), controller.ResetPassword)
         apiRouter.GET("/oauth/github", middleware.CriticalRateLimit(), controller.GitHubOAuth)
         apiRouter.GET("/oauth/oidc", middleware.CriticalRateLimit(), controller.OidcAuth)
+        apiRouter.GET("/oauth/saml", middleware.CriticalRateLimit(), controller.SamlAuth)
         apiRouter.GET("/oauth/linuxdo", middleware.CriticalRateLimit(), controller.LinuxdoOAuth)
         apiRouter.GET("/oauth/state", middleware.CriticalRateLimit(), controller.GenerateOAuthCode)
         apiRouter.GET("/oauth/wechat", middleware.CriticalRateLimit(), controller.WeChatAuth)
@@ -40,10 +41,17 @@ func SetApiRouter(router *gin.Engine) {
         apiRouter.GET("/oauth/telegram/bind", middleware.CriticalRateLimit(), controller.TelegramBind)
         apiRouter.GET("/ratio_config", middleware.CriticalRateLimit(), controller.GetRatioConfig)
 
+        samlRoute := apiRouter.Group("/saml")
+        {
+            samlRoute.GET("/metadata", controller.SamlMetadata)
+            samlRoute.GET("/login", middleware.CriticalRateLimit(), controller.SamlLogin)
+            samlRoute.POST("/acs", middleware.CriticalRateLimit(), controller.SamlAcs)
+        }
+
         apiRouter.POST("/stripe/webhook", controller.StripeWebhook)
 
         apiRouter.GET("/option/features", controller.GetFeatureOptions)
-        apiRouter.PUT("/option/features", middleware.RootAuth(), controller.UpdateFeatureOptions)
+        apiRouter.PUT("/option/features", middleware.PermissionAuth("setting"), controller.UpdateFeatureOptions)
 
         apiRouter.GET("/public/logs", controller.GetPublicLogs)
         apiRouter.GET("/public/logs/models", controller.GetPublicLogModels)
@@ -89,6 +97,11 @@ func SetApiRouter(router *gin.Engine) {
                 selfRoute.POST("/amount", controller.RequestAmount)
                 selfRoute.POST("/stripe/pay", middleware.CriticalRateLimit(), controller.RequestStripePay)
                 selfRoute.POST("/stripe/amount", controller.RequestStripeAmount)
+                selfRoute.GET("/self/invoices", controller.GetSelfInvoices)
+                selfRoute.POST("/self/invoices", controller.CreateSelfTopUpInvoice)
+                selfRoute.POST("/self/statements", controller.CreateSelfStatement)
+                selfRoute.GET("/self/invoices/:id/download", controller.DownloadSelfInvoice)
+                selfRoute.POST("/self/invoices/:id/email", middleware.CriticalRateLimit(), controller.EmailSelfInvoice)
                 selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
                 selfRoute.PUT("/setting", controller.UpdateUserSetting)
                 selfRoute.POST("/redeem", controller.RedeemPackageCode)
@@ -116,11 +129,14 @@ func SetApiRouter(router *gin.Engine) {
                 }
 
             adminRoute := userRoute.Group("/")
-            adminRoute.Use(middleware.AdminAuth())
+            adminRoute.Use(middleware.PermissionAuth("user"))
             {
                 adminRoute.GET("/", controller.GetAllUsers)
                 adminRoute.GET("/topup", controller.GetAllTopUps)
                 adminRoute.POST("/topup/complete", controller.AdminCompleteTopUp)
+                adminRoute.POST("/topup/refund", controller.AdminRefundTopUp)
+                adminRoute.POST("/topup/sync", controller.AdminSyncTopUp)
+                adminRoute.GET("/topup/:trade_no/refunds", controller.GetTopUpRefunds)
                 adminRoute.GET("/search", controller.SearchUsers)
                 adminRoute.GET("/:id", controller.GetUser)
                 adminRoute.POST("/", controller.CreateUser)
@@ -135,21 +151,60 @@ func SetApiRouter(router *gin.Engine) {
             }
         }
         optionRoute := apiRouter.Group("/option")
-        optionRoute.Use(middleware.RootAuth())
+        optionRoute.Use(middleware.PermissionAuth("setting"))
         {
             optionRoute.GET("/", controller.GetOptions)
             optionRoute.PUT("/", controller.UpdateOption)
             optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
             optionRoute.POST("/migrate_console_setting", controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
+            optionRoute.POST("/scim_token", controller.GenerateScimToken)
+        }
+        governanceRoute := apiRouter.Group("/governance")
+        governanceRoute.Use(middleware.PermissionAuth("governance"))
+        {
+            governanceRoute.GET("/policies", controller.GetGovernancePolicies)
+            governanceRoute.PUT("/policies", controller.UpdateGovernancePolicies)
+            governanceRoute.POST("/policies/evaluate", controller.EvaluateGovernancePolicies)
+            governanceRoute.GET("/policies/stats", controller.GetGovernancePolicyStats)
+            governanceRoute.DELETE("/policies/stats", controller.ResetGovernancePolicyStats)
+        }
+        tokenizerRoute := apiRouter.Group("/tokenizer")
+        tokenizerRoute.Use(middleware.PermissionAuth("setting"))
+        {
+            tokenizerRoute.GET("/", controller.GetTokenizers)
+            tokenizerRoute.POST("/reload", controller.ReloadTokenizers)
+            tokenizerRoute.POST("/calibrate", controller.CalibrateTokenizers)
+            tokenizerRoute.POST("/count", controller.CountTokenizerTokens)
+        }
+        mcpRoute := apiRouter.Group("/mcp")
+        mcpRoute.Use(middleware.PermissionAuth("mcp"))
+        {
+            mcpRoute.GET("/", controller.GetMcpServers)
+            mcpRoute.POST("/", controller.CreateMcpServer)
+            mcpRoute.PUT("/:id", controller.UpdateMcpServer)
+            mcpRoute.DELETE("/:id", controller.DeleteMcpServer)
+            mcpRoute.GET("/:id/tools", controller.GetMcpServerTools)
+        }
+        serverToolRoute := apiRouter.Group("/server_tool")
+        serverToolRoute.Use(middleware.PermissionAuth("mcp"))
+        {
+            serverToolRoute.GET("/", controller.GetServerTools)
+            serverToolRoute.POST("/", controller.CreateServerTool)
+            serverToolRoute.PUT("/:id", controller.UpdateServerTool)
+            serverToolRoute.DELETE("/:id", controller.DeleteServerTool)
+            serverToolRoute.POST("/:id/test", controller.TestServerTool)
+            serverToolRoute.GET("/:id/documents", controller.GetServerToolDocuments)
+            serverToolRoute.POST("/:id/documents", controller.AddServerToolDocuments)
+            serverToolRoute.DELETE("/:id/documents/:document_id", controller.DeleteServerToolDocument)
         }
         ratioSyncRoute := apiRouter.Group("/ratio_sync")
-        ratioSyncRoute.Use(middleware.RootAuth())
+        ratioSyncRoute.Use(middleware.PermissionAuth("setting"))
         {
             ratioSyncRoute.GET("/channels", controller.GetSyncableChannels)
             ratioSyncRoute.POST("/fetch", controller.FetchUpstreamRatios)
         }
         channelRoute := apiRouter.Group("/channel")
-        channelRoute.Use(middleware.AdminAuth())
+        channelRoute.Use(middleware.PermissionAuth("channel"))
         {
             channelRoute.GET("/", controller.GetAllChannels)
             channelRoute.GET("/search", controller.SearchChannels)
@@ -157,10 +212,10 @@ func SetApiRouter(router *gin.Engine) {
             channelRoute.GET("/models_enabled", controller.EnabledListModels)
             channelRoute.GET("/:id", controller.GetChannel)
             channelRoute.POST("/:id/key", middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
-            channelRoute.GET("/test", controller.TestAllChannels)
-            channelRoute.GET("/test/:id", controller.TestChannel)
-            channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
-            channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
+            channelRoute.GET("/test", middleware.PermissionAuth("channel:write"), controller.TestAllChannels)
+            channelRoute.GET("/test/:id", middleware.PermissionAuth("channel:write"), controller.TestChannel)
+            channelRoute.GET("/update_balance", middleware.PermissionAuth("channel:write"), controller.UpdateAllChannelsBalance)
+            channelRoute.GET("/update_balance/:id", middleware.PermissionAuth("channel:write"), controller.UpdateChannelBalance)
             channelRoute.POST("/", controller.AddChannel)
             channelRoute.PUT("/", controller.UpdateChannel)
             channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
@@ -178,7 +233,7 @@ func SetApiRouter(router *gin.Engine) {
             channelRoute.POST("/multi_key/manage", controller.ManageMultiKeys)
         }
         tokenRoute := apiRouter.Group("/token")
-        tokenRoute.Use(middleware.UserAuth())
+        tokenRoute.Use(middleware.ManagementKeyScope("tokens"), middleware.UserAuth())
         {
             tokenRoute.GET("/", controller.GetAllTokens)
             tokenRoute.GET("/search", controller.SearchTokens)
@@ -189,8 +244,85 @@ func SetApiRouter(router *gin.Engine) {
             tokenRoute.POST("/batch", controller.DeleteTokenBatch)
         }
 
+        organizationRoute := apiRouter.Group("/organization")
+        organizationRoute.Use(middleware.ManagementKeyScope("organizations"), middleware.UserAuth())
+        {
+            organizationRoute.GET("/", controller.GetUserOrganizations)
+            organizationRoute.POST("/", controller.CreateOrganization)
+            organizationRoute.POST("/invitation/accept", controller.AcceptOrganizationInvitation)
+            organizationRoute.GET("/:id", controller.GetOrganization)
+            organizationRoute.PUT("/:id", controller.UpdateOrganization)
+            organizationRoute.DELETE("/:id", controller.DeleteOrganization)
+            organizationRoute.GET("/:id/members", controller.GetOrganizationMembers)
+            organizationRoute.PUT("/:id/members/:user_id", controller.UpdateOrganizationMember)
+            organizationRoute.DELETE("/:id/members/:user_id", controller.RemoveOrganizationMember)
+            organizationRoute.GET("/:id/invitations", controller.GetOrganizationInvitations)
+            organizationRoute.POST("/:id/invitations", controller.CreateOrganizationInvitation)
+            organizationRoute.DELETE("/:id/invitations/:invitation_id", controller.RevokeOrganizationInvitation)
+            organizationRoute.POST("/:id/quota", controller.TransferOrganizationQuota)
+            organizationRoute.GET("/:id/tokens", controller.GetOrganizationTokens)
+            organizationRoute.POST("/:id/tokens", controller.AddOrganizationToken)
+            organizationRoute.GET("/:id/usage", controller.GetOrganizationUsage)
+            organizationRoute.GET("/:id/logs", controller.GetOrganizationLogs)
+        }
+
+        budgetRoute := apiRouter.Group("/budget")
+        budgetRoute.Use(middleware.ManagementKeyScope("budgets"), middleware.UserAuth())
+        {
+            budgetRoute.GET("/", controller.GetSpendingBudgets)
+            budgetRoute.POST("/", controller.CreateSpendingBudget)
+            budgetRoute.PUT("/:id", controller.UpdateSpendingBudget)
+            budgetRoute.DELETE("/:id", controller.DeleteSpendingBudget)
+        }
+
+        budgetAdminRoute := apiRouter.Group("/admin/budgets")
+        budgetAdminRoute.Use(middleware.PermissionAuth("user"))
+        {
+            budgetAdminRoute.GET("/", controller.AdminGetSpendingBudgets)
+            budgetAdminRoute.POST("/", controller.AdminCreateSpendingBudget)
+            budgetAdminRoute.PUT("/:id", controller.AdminUpdateSpendingBudget)
+            budgetAdminRoute.DELETE("/:id", controller.AdminDeleteSpendingBudget)
+        }
+
+        managementKeyRoute := apiRouter.Group("/management_key")
+        managementKeyRoute.Use(middleware.UserAuth())
+        {
+            managementKeyRoute.GET("/", controller.GetManagementKeys)
+            managementKeyRoute.GET("/scopes", controller.GetManagementKeyScopes)
+            managementKeyRoute.POST("/", controller.CreateManagementKey)
+            managementKeyRoute.PUT("/:id", controller.UpdateManagementKey)
+            managementKeyRoute.DELETE("/:id", controller.DeleteManagementKey)
+        }
+
+        organizationAdminRoute := apiRouter.Group("/admin/organizations")
+        organizationAdminRoute.Use(middleware.PermissionAuth("organization"))
+        {
+            organizationAdminRoute.GET("/", controller.GetAllOrganizations)
+            organizationAdminRoute.POST("/:id/quota", controller.AdjustOrganizationQuota)
+        }
+
+        adminRoleRoute := apiRouter.Group("/admin/roles")
+        adminRoleRoute.Use(middleware.PermissionAuth("rbac"))
+        {
+            adminRoleRoute.GET("/", controller.GetAllAdminRoles)
+            adminRoleRoute.GET("/permissions", controller.GetAdminPermissionCatalog)
+            adminRoleRoute.POST("/", controller.CreateAdminRole)
+            adminRoleRoute.PUT("/", controller.UpdateAdminRole)
+            adminRoleRoute.DELETE("/:id", controller.DeleteAdminRole)
+            adminRoleRoute.GET("/users/:user_id", controller.GetUserAdminRoles)
+            adminRoleRoute.PUT("/users/:user_id", controller.UpdateUserAdminRoles)
+        }
+
+        auditRoute := apiRouter.Group("/audit")
+        auditRoute.Use(middleware.PermissionAuth("audit"))
+        {
+            auditRoute.GET("/", controller.GetAuditLogs)
+            auditRoute.GET("/export", controller.ExportAuditLogs)
+            auditRoute.GET("/verify", controller.VerifyAuditLogs)
+        }
+
         planAdminRoute := apiRouter.Group("/plan")
-        planAdminRoute.Use(middleware.AdminAuth())
+        planAdminRoute.Use(middleware.PermissionAuth("plan"))
         {
             planAdminRoute.GET("/", controller.GetAllPlans)
             planAdminRoute.GET("/:id", controller.GetPlan)
@@ -208,10 +340,20 @@ func SetApiRouter(router *gin.Engine) {
             planSelfRoute.GET("/", controller.GetUserPlans)
         }
 
+        subscriptionRoute := apiRouter.Group("/subscription")
+        subscriptionRoute.Use(middleware.UserAuth())
+        {
+            subscriptionRoute.GET("/plans", controller.GetSubscriptionPlans)
+            subscriptionRoute.GET("/self", controller.GetSelfSubscriptions)
+            subscriptionRoute.POST("/checkout", middleware.CriticalRateLimit(), controller.SubscribePlan)
+            subscriptionRoute.POST("/:id/cancel", controller.CancelSubscription)
+            subscriptionRoute.POST("/:id/change", middleware.CriticalRateLimit(), controller.ChangeSubscriptionPlan)
+        }
+
         voucherRoute := apiRouter.Group("/voucher")
         {
             voucherAdminRoute := voucherRoute.Group("/")
-            voucherAdminRoute.Use(middleware.AdminAuth())
+            voucherAdminRoute.Use(middleware.PermissionAuth("plan"))
             {
                 voucherAdminRoute.POST("/batch", controller.GenerateVouchers)
                 voucherAdminRoute.GET("/batch", controller.GetVoucherBatches)
@@ -229,7 +371,7 @@ func SetApiRouter(router *gin.Engine) {
         }
 
         leaderboardAdmin := apiRouter.Group("/leaderboard")
-        leaderboardAdmin.Use(middleware.AdminAuth())
+        leaderboardAdmin.Use(middleware.PermissionAuth("log"))
         {
             leaderboardAdmin.GET("/", controller.GetAdminLeaderboard)
             leaderboardAdmin.GET("/export", controller.ExportAdminLeaderboard)
@@ -237,14 +379,14 @@ func SetApiRouter(router *gin.Engine) {
         }
 
         logAdmin := apiRouter.Group("/log")
-        logAdmin.Use(middleware.AdminAuth())
+        logAdmin.Use(middleware.PermissionAuth("log"))
         {
             logAdmin.GET("/ip-usage/token/:id", controller.GetTokenIPUsage)
             logAdmin.GET("/ip-usage/user/:id", controller.GetUserIPUsage)
         }
 
         lotteryRoute := apiRouter.Group("/lottery")
-        lotteryRoute.Use(middleware.AdminAuth())
+        lotteryRoute.Use(middleware.PermissionAuth("redemption"))
         {
             lotteryRoute.GET("/configs", controller.GetLotteryConfigs)
             lotteryRoute.POST("/configs", controller.CreateLotteryConfig)
@@ -264,7 +406,7 @@ func SetApiRouter(router *gin.Engine) {
         }
 
         redemptionRoute := apiRouter.Group("/redemption")
-        redemptionRoute.Use(middleware.AdminAuth())
+        redemptionRoute.Use(middleware.PermissionAuth("redemption"))
         {
             redemptionRoute.GET("/", controller.GetAllRedemptions)
             redemptionRoute.GET("/search", controller.SearchRedemptions)
@@ -275,30 +417,30 @@ func SetApiRouter(router *gin.Engine) {
             redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
         }
         logRoute := apiRouter.Group("/log")
-        logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-        logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
-        logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
-        logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-        logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
-        logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
-        logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+        logRoute.GET("/", middleware.PermissionAuth("log"), controller.GetAllLogs)
+        logRoute.DELETE("/", middleware.PermissionAuth("log"), controller.DeleteHistoryLogs)
+        logRoute.GET("/stat", middleware.PermissionAuth("log"), controller.GetLogsStat)
+        logRoute.GET("/self/stat", middleware.ManagementKeyScope("logs"), middleware.UserAuth(), controller.GetLogsSelfStat)
+        logRoute.GET("/search", middleware.PermissionAuth("log"), controller.SearchAllLogs)
+        logRoute.GET("/self", middleware.ManagementKeyScope("logs"), middleware.UserAuth(), controller.GetUserLogs)
+        logRoute.GET("/self/search", middleware.ManagementKeyScope("logs"), middleware.UserAuth(), controller.SearchUserLogs)
 
         dataRoute := apiRouter.Group("/data")
-        dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
-        dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
+        dataRoute.GET("/", middleware.PermissionAuth("log"), controller.GetAllQuotaDates)
+        dataRoute.GET("/self", middleware.ManagementKeyScope("logs"), middleware.UserAuth(), controller.GetUserQuotaDates)
 
         logRoute.Use(middleware.CORS())
         {
             logRoute.GET("/token", controller.GetLogByKey)
         }
         groupRoute := apiRouter.Group("/group")
-        groupRoute.Use(middleware.AdminAuth())
+        groupRoute.Use(middleware.PermissionAuth("channel"))
         {
             groupRoute.GET("/", controller.GetGroups)
         }
 
         prefillGroupRoute := apiRouter.Group("/prefill_group")
-        prefillGroupRoute.Use(middleware.AdminAuth())
+        prefillGroupRoute.Use(middleware.PermissionAuth("channel"))
         {
             prefillGroupRoute.GET("/", controller.GetPrefillGroups)
             prefillGroupRoute.POST("/", controller.CreatePrefillGroup)
@@ -308,16 +450,16 @@ func SetApiRouter(router *gin.Engine) {
 
         mjRoute := apiRouter.Group("/mj")
         mjRoute.GET("/self", middleware.UserAuth(), controller.GetUserMidjourney)
-        mjRoute.GET("/", middleware.AdminAuth(), controller.GetAllMidjourney)
+        mjRoute.GET("/", middleware.PermissionAuth("log"), controller.GetAllMidjourney)
 
         taskRoute := apiRouter.Group("/task")
         {
             taskRoute.GET("/self", middleware.UserAuth(), controller.GetUserTask)
-            taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
+            taskRoute.GET("/", middleware.PermissionAuth("log"), controller.GetAllTask)
         }
 
         vendorRoute := apiRouter.Group("/vendors")
-        vendorRoute.Use(middleware.AdminAuth())
+        vendorRoute.Use(middleware.PermissionAuth("model"))
         {
             vendorRoute.GET("/", controller.GetAllVendors)
             vendorRoute.GET("/search", controller.SearchVendors)
@@ -328,7 +470,7 @@ func SetApiRouter(router *gin.Engine) {
         }
 
         modelsRoute := apiRouter.Group("/models")
-        modelsRoute.Use(middleware.AdminAuth())
+        modelsRoute.Use(middleware.PermissionAuth("model"))
         {
             modelsRoute.GET("/sync_upstream/preview", controller.SyncUpstreamPreview)
             modelsRoute.POST("/sync_upstream", controller.SyncUpstreamModels)
@@ -342,7 +484,7 @@ func SetApiRouter(router *gin.Engine) {
         }
 
         packageRoute := apiRouter.Group("/admin/packages")
-        packageRoute.Use(middleware.AdminAuth())
+        packageRoute.Use(middleware.PermissionAuth("plan"))
         {
             packageRoute.GET("/", controller.GetAllPackages)
             packageRoute.GET("/:id", controller.GetPackage)
@@ -353,7 +495,7 @@ func SetApiRouter(router *gin.Engine) {
         }
 
         redemptionCodeRoute := apiRouter.Group("/admin/redemption-codes")
-        redemptionCodeRoute.Use(middleware.AdminAuth())
+        redemptionCodeRoute.Use(middleware.PermissionAuth("redemption"))
         {
             redemptionCodeRoute.POST("/", controller.GenerateRedemptionCodes)
             redemptionCodeRoute.GET("/", controller.GetRedemptionCodes)
@@ -362,7 +504,7 @@ func SetApiRouter(router *gin.Engine) {
         }
 
         securityRoute := apiRouter.Group("/security")
-        securityRoute.Use(middleware.AdminAuth())
+        securityRoute.Use(middleware.PermissionAuth("security"))
         {
             securityRoute.GET("/dashboard", controller.GetSecurityDashboard)
             securityRoute.GET("/violations", controller.GetSecurityViolations)
@@ -387,7 +529,7 @@ func SetApiRouter(router *gin.Engine) {
 
             // Admin-only routes
             ticketAdminRoute := ticketRoute.Group("/")
-            ticketAdminRoute.Use(middleware.AdminAuth())
+            ticketAdminRoute.Use(middleware.PermissionAuth("ticket"))
             {
                 ticketAdminRoute.PUT("/:id/reply", controller.ReplyTicket)
             }
@@ -403,6 +545,20 @@ func SetApiRouter(router *gin.Engine) {
             encryptionRoute.POST("/conversation-log", controller.CreateConversationLog)
             encryptionRoute.GET("/conversation-logs", controller.GetConversationLogs)
             encryptionRoute.DELETE("/conversation-log/:id", controller.DeleteConversationLog)
+            encryptionRoute.GET("/conversation-logs/sealed", controller.GetSealedConversationLogs)
+            encryptionRoute.GET("/conversation-logs/export", controller.ExportConversationLogs)
+            encryptionRoute.GET("/keys", controller.ListEncryptionKeys)
+            encryptionRoute.POST("/keys", controller.RegisterEncryptionKey)
+            encryptionRoute.DELETE("/keys/:id", controller.RevokeEncryptionKey)
+            encryptionRoute.GET("/keys/:id/rewrap", controller.GetRewrapQueue)
+            encryptionRoute.POST("/keys/:id/rewrap", controller.SubmitRewrappedKeys)
+            encryptionRoute.POST("/recovery-key", controller.RegisterRecoveryKey)
+
+            encryptionAdminRoute := encryptionRoute.Group("/")
+            encryptionAdminRoute.Use(middleware.PermissionAuth("log"))
+            {
+                encryptionAdminRoute.GET("/logging-stats", controller.GetConversationLoggingStats)
+            }
         }
 
         // Heimdall Gateway Log Receiver
@@ -418,13 +574,14 @@ func SetApiRouter(router *gin.Engine) {
             
             // Admin-only routes
             anomalyAdminRoute := anomalyRoute.Group("/")
-            anomalyAdminRoute.Use(middleware.AdminAuth())
+            anomalyAdminRoute.Use(middleware.PermissionAuth("security"))
             {
                 anomalyAdminRoute.PUT("/detections/:id/status", controller.UpdateAnomalyDetectionStatus)
                 anomalyAdminRoute.PUT("/detections/:id/action", controller.UpdateAnomalyDetectionAction)
                 anomalyAdminRoute.GET("/device-aggregation", controller.GetDeviceAggregation)
                 anomalyAdminRoute.GET("/ip-aggregation", controller.GetIPAggregation)
                 anomalyAdminRoute.POST("/trigger/:user_id", controller.TriggerAnomalyDetection)
+                anomalyAdminRoute.GET("/capture-stats", controller.GetRequestCaptureStats)
             }
         }
     }
@@ -56,12 +56,14 @@ func SetRelayRouter(router *gin.Engine) {
     }
 
     playgroundRouter := router.Group("/pg")
-    playgroundRouter.Use(middleware.UserAuth(), middleware.Distribute(), middleware.Governance())
+    playgroundRouter.Use(middleware.RequestCapture(), middleware.UserAuth(), middleware.Distribute(), middleware.Governance())
     {
         playgroundRouter.POST("/chat/completions", controller.Playground)
     }
     relayV1Router := router.Group("/v1")
+    relayV1Router.Use(middleware.RequestCapture())
     relayV1Router.Use(middleware.TokenAuth())
+    relayV1Router.Use(middleware.ConversationCapture())
     relayV1Router.Use(middleware.ModelRequestRateLimit())
     {
         // WebSocket 路由（统一到 Relay）
@@ -77,6 +79,7 @@ func SetRelayRouter(router *gin.Engine) {
         httpRouter.Use(middleware.Distribute(), middleware.Governance())
 
         // claude related routes
+        httpRouter.POST("/messages/count_tokens", controller.CountTokensClaude)
         httpRouter.POST("/messages", func(c *gin.Context) {
             controller.Relay(c, types.RelayFormatClaude)
         })
@@ -131,12 +134,16 @@ func SetRelayRouter(router *gin.Engine) {
             controller.Relay(c, types.RelayFormatGemini)
         })
         httpRouter.POST("/models/*path", func(c *gin.Context) {
+            if controller.IsGeminiCountTokensPath(c) {
+                controller.CountTokensGemini(c)
+                return
+            }
             controller.Relay(c, types.RelayFormatGemini)
         })
 
         // other relay routes
         httpRouter.POST("/moderations", func(c *gin.Context) {
-            controller.Relay(c, types.RelayFormatOpenAI)
+            controller.Relay(c, types.RelayFormatModeration)
         })
 
         // not implemented
@@ -154,6 +161,43 @@ func SetRelayRouter(router *gin.Engine) {
         httpRouter.DELETE("/models/:model", controller.RelayNotImplemented)
     }
 
+    // Ollama-native and llama.cpp-compatible ingress
+    router.GET("/api/version", controller.OllamaVersion)
+    router.GET("/health", controller.LlamaCppHealth)
+    ollamaRouter := router.Group("")
+    ollamaRouter.Use(middleware.RequestCapture())
+    ollamaRouter.Use(middleware.TokenAuth())
+    {
+        ollamaRouter.GET("/api/tags", func(c *gin.Context) {
+            controller.ListModels(c, constant.ChannelTypeOllama)
+        })
+        ollamaRouter.GET("/api/ps", controller.OllamaPs)
+        ollamaRouter.POST("/api/show", controller.OllamaShow)
+    }
+    {
+        ollamaRelayRouter := ollamaRouter.Group("")
+        ollamaRelayRouter.Use(middleware.ConversationCapture())
+        ollamaRelayRouter.Use(middleware.ModelRequestRateLimit())
+        ollamaRelayRouter.Use(middleware.Distribute(), middleware.Governance())
+        for _, path := range []string{"/api/chat", "/api/generate", "/api/embed", "/api/embeddings", "/completion"} {
+            ollamaRelayRouter.POST(path, func(c *gin.Context) {
+                controller.Relay(c, types.RelayFormatOllama)
+            })
+        }
+    }
+
+    // MCP gateway: unified tool catalog over the token's granted servers
+    mcpRouter := router.Group("")
+    mcpRouter.Use(middleware.RequestCapture())
+    mcpRouter.Use(middleware.TokenAuth())
+    {
+        mcpRouter.POST("/mcp", controller.McpGateway)
+        mcpRouter.GET("/mcp", controller.McpGateway)
+        mcpRouter.DELETE("/mcp", controller.McpGateway)
+        mcpRouter.GET("/v1/mcp/tools", controller.GetMcpTools)
+        mcpRouter.POST("/v1/mcp/tools/call", controller.CallMcpToolRest)
+    }
+
     relayMjRouter := router.Group("/mj")
     registerMjRouterGroup(relayMjRouter)
 
@@ -162,7 +206,7 @@ func SetRelayRouter(router *gin.Engine) {
     //relayMjRouter.Use()
 
     relaySunoRouter := router.Group("/suno")
-    relaySunoRouter.Use(middleware.TokenAuth(), middleware.Distribute(), middleware.Governance())
+    relaySunoRouter.Use(middleware.RequestCapture(), middleware.TokenAuth(), middleware.Distribute(), middleware.Governance())
     {
         relaySunoRouter.POST("/submit/:action", controller.RelayTask)
         relaySunoRouter.POST("/fetch", controller.RelayTask)
@@ -170,12 +214,18 @@ func SetRelayRouter(router *gin.Engine) {
     }
 
     relayGeminiRouter := router.Group("/v1beta")
+    relayGeminiRouter.Use(middleware.RequestCapture())
     relayGeminiRouter.Use(middleware.TokenAuth())
+    relayGeminiRouter.Use(middleware.ConversationCapture())
     relayGeminiRouter.Use(middleware.ModelRequestRateLimit())
     relayGeminiRouter.Use(middleware.Distribute(), middleware.Governance())
     {
         // Gemini API 路径格式: /v1beta/models/{model_name}:{action}
         relayGeminiRouter.POST("/models/*path", func(c *gin.Context) {
+            if controller.IsGeminiCountTokensPath(c) {
+                controller.CountTokensGemini(c)
+                return
+            }
             controller.Relay(c, types.RelayFormatGemini)
         })
     }
@@ -183,7 +233,7 @@ func SetRelayRouter(router *gin.Engine) {
 
 func registerMjRouterGroup(relayMjRouter *gin.RouterGroup) {
     relayMjRouter.GET("/image/:id", relay.RelayMidjourneyImage)
-    relayMjRouter.Use(middleware.TokenAuth(), middleware.Distribute(), middleware.Governance())
+    relayMjRouter.Use(middleware.RequestCapture(), middleware.TokenAuth(), middleware.Distribute(), middleware.Governance())
     {
         relayMjRouter.POST("/submit/action", controller.RelayMidjourney)
         relayMjRouter.POST("/submit/shorten", controller.RelayMidjourney)
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"github.com/QuantumNous/new-api/controller"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func SetScimRouter(router *gin.Engine) {
+	scimRouter := router.Group("/scim/v2")
+	scimRouter.Use(middleware.GlobalAPIRateLimit())
+	scimRouter.Use(middleware.ScimAuth())
+	{
+		scimRouter.GET("/ServiceProviderConfig", controller.GetScimServiceProviderConfig)
+		scimRouter.GET("/ResourceTypes", controller.GetScimResourceTypes)
+		scimRouter.GET("/Schemas", controller.GetScimSchemas)
+
+		scimRouter.GET("/Users", controller.GetScimUsers)
+		scimRouter.POST("/Users", controller.CreateScimUser)
+		scimRouter.GET("/Users/:id", controller.GetScimUser)
+		scimRouter.PUT("/Users/:id", controller.ReplaceScimUser)
+		scimRouter.PATCH("/Users/:id", controller.PatchScimUser)
+		scimRouter.DELETE("/Users/:id", controller.DeleteScimUser)
+
+		scimRouter.GET("/Groups", controller.GetScimGroups)
+		scimRouter.POST("/Groups", controller.CreateScimGroup)
+		scimRouter.GET("/Groups/:id", controller.GetScimGroup)
+		scimRouter.PUT("/Groups/:id", controller.ReplaceScimGroup)
+		scimRouter.PATCH("/Groups/:id", controller.PatchScimGroup)
+		scimRouter.DELETE("/Groups/:id", controller.DeleteScimGroup)
+	}
+}
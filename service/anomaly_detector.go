@@ -326,9 +326,6 @@ func (ads *AnomalyDetectorService) RunPeriodicAnalysis() {
 	common.SysLog("Starting periodic anomaly detection analysis")
 
 	// Get all active users (simplified: get recent users from logs)
-	endTime := time.Now().Unix()
-	startTime := endTime - ads.TimeWindowSeconds
-
 	// This is a simplified approach - in production, you'd want to optimize this
 	// by maintaining a list of active users or using a more efficient query
 	pageSize := 100
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/audit"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecordAudit appends an administrative change to the audit log. before and
+// after are snapshotted with secrets masked; either may be nil for creations
+// and deletions. Failures are logged and never fail the request.
+func RecordAudit(c *gin.Context, action string, resourceType string, resourceId any, before any, after any) {
+	beforeSnapshot := audit.Snapshot(before)
+	afterSnapshot := audit.Snapshot(after)
+	entry := &model.AuditLog{
+		ActorId:      c.GetInt("id"),
+		ActorName:    c.GetString("username"),
+		Ip:           c.ClientIP(),
+		Action:       action,
+		ResourceType: resourceType,
+		RequestId:    c.GetString(common.RequestIdKey),
+	}
+	if resourceId != nil {
+		entry.ResourceId = fmt.Sprint(resourceId)
+	}
+	if beforeSnapshot != nil {
+		entry.Before = common.GetJsonString(beforeSnapshot)
+	}
+	if afterSnapshot != nil {
+		entry.After = common.GetJsonString(afterSnapshot)
+	}
+	if changes := audit.Diff(beforeSnapshot, afterSnapshot); len(changes) > 0 {
+		entry.Diff = common.GetJsonString(changes)
+	}
+	if err := entry.Insert(); err != nil {
+		logger.LogError(c, fmt.Sprintf("记录审计日志失败（%s）：%s", action, err.Error()))
+	}
+}
@@ -61,7 +61,8 @@ type CommitParams struct {
     RelayInfo        *relaycommon.RelayInfo
 }
 
-// PrepareCharge resolves active plan assignment and allowance. subjectKey format: "user:123" or "token:456".
+// PrepareCharge resolves active plan assignment and allowance. subjectKey format: "user:123", "token:456" or "organization:7";
+// when empty, organization tokens resolve to their organization and other tokens to their user.
 func (be *BillingEngine) PrepareCharge(ctx context.Context, subjectKey string, relayInfo *relaycommon.RelayInfo) (*PreparedCharge, error) {
     subjectType, subjectId, err := parseSubjectKey(subjectKey, relayInfo)
     if err != nil {
@@ -205,6 +206,9 @@ func (be *BillingEngine) CommitCharge(ctx context.Context, in *CommitParams) (*m
         }
     }
     var resultLog *model.RequestLog
+    // committed is set once the charge is applied, not replayed, so it counts against spending budgets
+    // whichever of the plan allowance or the balance paid it
+    committed := false
     err := be.DB.Transaction(func(tx *gorm.DB) error {
         // Idempotency barrier: try to create RequestLog first.
         log := BuildRequestLog(in.RequestId, pc.SubjectType, pc.SubjectId, in.ModelAlias, in.Upstream,
@@ -303,8 +307,25 @@ func (be *BillingEngine) CommitCharge(ctx context.Context, in *CommitParams) (*m
                     }
                 }
             }
+            if mode != common.BillingModeBalance {
+                break
+            }
+            // Allowance exhausted with fallback enabled: charge the balance instead
+            fallthrough
 
         case common.BillingModeBalance:
+            if pc.SubjectType == common.AssignmentSubjectTypeOrganization {
+                // Deduct from the organization pool and the member's spending instead of the user
+                if err := model.ChargeOrganizationQuotaTx(tx, pc.SubjectId, in.RelayInfo.UserId, int(in.Amount)); err != nil {
+                    return &ErrBalanceInsufficient{UserId: in.RelayInfo.UserId, TokenId: in.RelayInfo.TokenId, Needed: in.Amount}
+                }
+                if !in.RelayInfo.IsPlayground {
+                    if err := chargeTokenQuotaTx(tx, in.RelayInfo.UserId, in.RelayInfo.TokenId, in.Amount); err != nil {
+                        return err
+                    }
+                }
+                break
+            }
             // Deduct from user (and token if not unlimited) atomically with conditions
             // Lock user row
             var user model.User
@@ -322,35 +343,45 @@ func (be *BillingEngine) CommitCharge(ctx context.Context, in *CommitParams) (*m
             }
             // Token deduction if not unlimited and not playground
             if in.RelayInfo != nil && !in.RelayInfo.IsPlayground {
-                var token model.Token
-                if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&token, "id = ?", in.RelayInfo.TokenId).Error; err != nil {
+                if err := chargeTokenQuotaTx(tx, user.Id, in.RelayInfo.TokenId, in.Amount); err != nil {
                     return err
                 }
-                if !token.UnlimitedQuota {
-                    if token.RemainQuota < int(in.Amount) {
-                        return &ErrBalanceInsufficient{UserId: user.Id, TokenId: token.Id, Remaining: token.RemainQuota, Needed: in.Amount}
-                    }
-                    if err := tx.Model(&model.Token{}).Where("id = ? AND remain_quota >= ?", token.Id, int(in.Amount)).Updates(map[string]any{
-                        "remain_quota": gorm.Expr("remain_quota - ?", int(in.Amount)),
-                        "used_quota":   gorm.Expr("used_quota + ?", int(in.Amount)),
-                        "accessed_time": common.GetTimestamp(),
-                    }).Error; err != nil {
-                        return err
-                    }
-                }
             }
         default:
             return fmt.Errorf("unsupported billing mode: %s", mode)
         }
         resultLog = log
+        committed = true
         return nil
     })
     if err != nil {
         return nil, err
     }
+    if committed && in.RelayInfo != nil {
+        recordSpendingBudgets(in.RelayInfo, int(in.Amount))
+    }
     return resultLog, nil
 }
 
+// chargeTokenQuotaTx deducts amount from a limited token inside tx.
+func chargeTokenQuotaTx(tx *gorm.DB, userId int, tokenId int, amount int64) error {
+    var token model.Token
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&token, "id = ?", tokenId).Error; err != nil {
+        return err
+    }
+    if token.UnlimitedQuota {
+        return nil
+    }
+    if token.RemainQuota < int(amount) {
+        return &ErrBalanceInsufficient{UserId: userId, TokenId: token.Id, Remaining: token.RemainQuota, Needed: amount}
+    }
+    return tx.Model(&model.Token{}).Where("id = ? AND remain_quota >= ?", token.Id, int(amount)).Updates(map[string]any{
+        "remain_quota": gorm.Expr("remain_quota - ?", int(amount)),
+        "used_quota":   gorm.Expr("used_quota + ?", int(amount)),
+        "accessed_time": common.GetTimestamp(),
+    }).Error
+}
+
 // RollbackCharge is a best-effort reversal based on request id. It is idempotent and safe to call multiple times.
 func (be *BillingEngine) RollbackCharge(ctx context.Context, requestId string) error {
     if requestId == "" {
@@ -385,6 +416,10 @@ func parseSubjectKey(subjectKey string, relayInfo *relaycommon.RelayInfo) (strin
         }
         return typ, id64, nil
     }
+    // Organization tokens bill the organization
+    if relayInfo != nil && relayInfo.OrganizationId != 0 {
+        return common.AssignmentSubjectTypeOrganization, relayInfo.OrganizationId, nil
+    }
     // Default to user
     if relayInfo != nil && relayInfo.UserId != 0 {
         return common.AssignmentSubjectTypeUser, relayInfo.UserId, nil
@@ -2,6 +2,8 @@ package service
 
 import (
 	"fmt"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -9,14 +11,17 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service/budget"
+	"github.com/QuantumNous/new-api/types"
 
+	"github.com/gin-gonic/gin"
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 )
 
 func setupServiceTestDB(t *testing.T) *gorm.DB {
 	t.Helper()
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
 	if err != nil {
 		t.Fatalf("failed to open sqlite: %v", err)
 	}
@@ -30,12 +35,15 @@ func setupServiceTestDB(t *testing.T) *gorm.DB {
 		&model.PlanAssignment{},
 		&model.UsageCounter{},
 		&model.RequestLog{},
+		&model.SpendingBudget{},
 	}
 	if err := db.AutoMigrate(tables...); err != nil {
 		t.Fatalf("failed to migrate tables: %v", err)
 	}
 	oldDB := model.DB
 	model.DB = db
+	// Quota cache updates run on a pool after the test returns; keep Redis off.
+	common.RedisEnabled = false
 	t.Cleanup(func() { model.DB = oldDB })
 	common.UsingSQLite = true
 	common.UsingPostgreSQL = false
@@ -228,3 +236,94 @@ func TestBillingEngine_ConcurrencyGuard(t *testing.T) {
 		t.Fatalf("expected single deduction to 70, got %d", u.Quota)
 	}
 }
+
+func TestBillingEngine_FallbackChargesLimitedToken(t *testing.T) {
+	db := setupServiceTestDB(t)
+	user, token := createUserAndToken(t, db, 100, false)
+	createPlanAndAssignment(t, db, user.Id, 5, common.BillingModePlan, true)
+	engine := NewBillingEngine(db)
+	info := newRelayInfo(user.Id, token.Id, false)
+	pc, err := engine.PrepareCharge(nil, "", info)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	log, err := engine.CommitCharge(nil, &CommitParams{Prepared: pc, Amount: 10, RequestId: "req-fallback-token", RelayInfo: info})
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	var u model.User
+	var tk model.Token
+	db.First(&u, user.Id)
+	db.First(&tk, token.Id)
+	if u.Quota != 90 || tk.RemainQuota != 90 {
+		t.Fatalf("expected user and token at 90 after fallback, got %d/%d", u.Quota, tk.RemainQuota)
+	}
+	if log == nil || !strings.Contains(string(log.Metadata), `"mode":"balance"`) {
+		t.Fatalf("expected the request log to record the balance charge, got %+v", log)
+	}
+}
+
+func TestBillingEngine_NoFallbackRefusesCharge(t *testing.T) {
+	db := setupServiceTestDB(t)
+	user, token := createUserAndToken(t, db, 100, true)
+	createPlanAndAssignment(t, db, user.Id, 5, common.BillingModePlan, false)
+	engine := NewBillingEngine(db)
+	info := newRelayInfo(user.Id, token.Id, true)
+	pc, err := engine.PrepareCharge(nil, "", info)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	_, err = engine.CommitCharge(nil, &CommitParams{Prepared: pc, Amount: 10, RequestId: "req-no-fallback", RelayInfo: info})
+	if _, ok := err.(*ErrPlanExhausted); !ok {
+		t.Fatalf("expected plan exhaustion, got %v", err)
+	}
+	var u model.User
+	db.First(&u, user.Id)
+	if u.Quota != 100 {
+		t.Fatalf("expected balance untouched without fallback, got %d", u.Quota)
+	}
+}
+
+func TestBillingEngine_PlanChargeCountsAgainstBudget(t *testing.T) {
+	db := setupServiceTestDB(t)
+	if err := db.AutoMigrate(&model.SpendingBudgetUsage{}); err != nil {
+		t.Fatalf("failed to migrate budget usage: %v", err)
+	}
+	user, token := createUserAndToken(t, db, 100, true)
+	createPlanAndAssignment(t, db, user.Id, 50, common.BillingModePlan, false)
+	spendingBudget := &model.SpendingBudget{UserId: user.Id, Window: budget.WindowDay, LimitQuota: 15, Enabled: true}
+	if err := db.Create(spendingBudget).Error; err != nil {
+		t.Fatalf("create budget: %v", err)
+	}
+	InvalidateSpendingBudgetCache(user.Id)
+	// later tests reuse the user id with no budget
+	t.Cleanup(func() { InvalidateSpendingBudgetCache(user.Id) })
+
+	engine := NewBillingEngine(db)
+	info := newRelayInfo(user.Id, token.Id, true)
+	pc, err := engine.PrepareCharge(nil, "", info)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		// the retry replays the logged charge and must not count twice
+		if _, err := engine.CommitCharge(nil, &CommitParams{Prepared: pc, Amount: 10, RequestId: "req-plan-budget", RelayInfo: info}); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+	start, _ := SpendingBudgetWindow(spendingBudget, time.Now())
+	usage, err := model.GetSpendingBudgetUsage(spendingBudget.Id, start.Unix())
+	if err != nil || usage.SpentQuota != 10 {
+		t.Fatalf("expected the plan-funded charge to count once against the budget, got %+v (err %v)", usage, err)
+	}
+	var u model.User
+	db.First(&u, user.Id)
+	if u.Quota != 100 {
+		t.Fatalf("expected the plan to pay, got balance %d", u.Quota)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if apiErr := checkSpendingBudgets(c, 10, info); apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeSpendingBudgetExceeded {
+		t.Fatalf("expected the next request to exceed the budget, got %v", apiErr)
+	}
+}
@@ -6,7 +6,6 @@ import (
     "time"
 
     "github.com/QuantumNous/new-api/common"
-    "github.com/QuantumNous/new-api/model"
     relaycommon "github.com/QuantumNous/new-api/relay/common"
     "github.com/gin-gonic/gin"
 )
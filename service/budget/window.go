@@ -0,0 +1,82 @@
+// Package budget computes spending budget windows and soft thresholds.
+package budget
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	WindowDay   = "day"
+	WindowWeek  = "week"
+	WindowMonth = "month"
+)
+
+func ValidWindow(window string) bool {
+	return window == WindowDay || window == WindowWeek || window == WindowMonth
+}
+
+// LoadLocation resolves a TZ database name. An empty name is UTC.
+func LoadLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(timezone)
+}
+
+// Bounds returns the window containing now, in loc. Days start at
+// midnight, weeks on Monday and months on the first.
+func Bounds(window string, loc *time.Location, now time.Time) (start time.Time, end time.Time) {
+	now = now.In(loc)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch window {
+	case WindowWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		start = day.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	case WindowMonth:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// NormalizeThresholds validates soft thresholds, percentages between 1 and
+// 99, and returns them sorted without duplicates.
+func NormalizeThresholds(thresholds []int) ([]int, error) {
+	seen := make(map[int]bool, len(thresholds))
+	normalized := make([]int, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		if threshold < 1 || threshold > 99 {
+			return nil, fmt.Errorf("invalid threshold %d, must be between 1 and 99", threshold)
+		}
+		if !seen[threshold] {
+			seen[threshold] = true
+			normalized = append(normalized, threshold)
+		}
+	}
+	sort.Ints(normalized)
+	return normalized, nil
+}
+
+// Crossed returns the highest threshold, or 100 for the limit itself, that
+// spending reaches at after but had not reached at before. It returns 0
+// when nothing was crossed.
+func Crossed(thresholds []int, limit int64, before int64, after int64) int {
+	if limit <= 0 || after <= before {
+		return 0
+	}
+	if before < limit && after >= limit {
+		return 100
+	}
+	crossed := 0
+	for _, threshold := range thresholds {
+		mark := limit * int64(threshold) / 100
+		if before < mark && after >= mark {
+			crossed = threshold
+		}
+	}
+	return crossed
+}
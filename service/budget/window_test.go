@@ -0,0 +1,68 @@
+package budget
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBounds(t *testing.T) {
+	loc, err := LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	// 2025-03-09 is the spring DST change in New York, a Sunday
+	now := time.Date(2025, 3, 9, 15, 0, 0, 0, loc)
+
+	start, end := Bounds(WindowDay, loc, now)
+	if !start.Equal(time.Date(2025, 3, 9, 0, 0, 0, 0, loc)) || end.Sub(start) != 23*time.Hour {
+		t.Errorf("day = %v - %v", start, end)
+	}
+	start, end = Bounds(WindowWeek, loc, now)
+	if !start.Equal(time.Date(2025, 3, 3, 0, 0, 0, 0, loc)) || !end.Equal(time.Date(2025, 3, 10, 0, 0, 0, 0, loc)) {
+		t.Errorf("week = %v - %v", start, end)
+	}
+	start, end = Bounds(WindowMonth, loc, now)
+	if !start.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, loc)) || !end.Equal(time.Date(2025, 4, 1, 0, 0, 0, 0, loc)) {
+		t.Errorf("month = %v - %v", start, end)
+	}
+
+	// 02:00 UTC on a Monday is still Sunday in New York
+	start, _ = Bounds(WindowWeek, loc, time.Date(2025, 3, 10, 2, 0, 0, 0, time.UTC))
+	if !start.Equal(time.Date(2025, 3, 3, 0, 0, 0, 0, loc)) {
+		t.Errorf("week start = %v", start)
+	}
+	start, _ = Bounds(WindowWeek, time.UTC, time.Date(2025, 3, 10, 2, 0, 0, 0, time.UTC))
+	if !start.Equal(time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("utc week start = %v", start)
+	}
+}
+
+func TestNormalizeThresholds(t *testing.T) {
+	thresholds, err := NormalizeThresholds([]int{90, 50, 90, 75})
+	if err != nil || len(thresholds) != 3 || thresholds[0] != 50 || thresholds[2] != 90 {
+		t.Errorf("thresholds = %v, %v", thresholds, err)
+	}
+	if _, err := NormalizeThresholds([]int{100}); err == nil {
+		t.Error("expected an error for 100")
+	}
+}
+
+func TestCrossed(t *testing.T) {
+	thresholds := []int{50, 80}
+	cases := []struct {
+		before, after int64
+		want          int
+	}{
+		{0, 40, 0},
+		{40, 50, 50},
+		{40, 85, 80},
+		{85, 120, 100},
+		{120, 130, 0},
+		{60, 55, 0},
+	}
+	for _, tc := range cases {
+		if got := Crossed(thresholds, 100, tc.before, tc.after); got != tc.want {
+			t.Errorf("Crossed(%d, %d) = %d, want %d", tc.before, tc.after, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// ConversationCapture is a relay exchange waiting to be sealed and stored.
+type ConversationCapture struct {
+	UserId       int
+	TokenId      int
+	Model        string
+	RequestId    string
+	RequestBody  []byte
+	ResponseBody []byte
+	Stream       bool
+	Truncated    bool
+	Timestamp    int64
+}
+
+// conversationRequestFields are the request keys that carry the conversation
+// across the OpenAI, Claude, Responses and Gemini formats.
+var conversationRequestFields = []string{"system", "messages", "input", "instructions", "prompt", "contents", "systemInstruction", "system_instruction"}
+
+var (
+	conversationLogOnce    sync.Once
+	conversationLogQueue   chan *ConversationCapture
+	conversationLogWritten uint64
+	conversationLogSkipped uint64
+	conversationLogDropped uint64
+)
+
+// ConversationLoggingStats reports the state of the async conversation writer.
+type ConversationLoggingStats struct {
+	Queued  int    `json:"queued"`
+	Written uint64 `json:"written"`
+	Skipped uint64 `json:"skipped"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// EnqueueConversationCapture hands a captured exchange to the async writer.
+// Sealing and storage never block the relay; a full buffer drops the capture.
+func EnqueueConversationCapture(capture *ConversationCapture) bool {
+	if capture == nil || capture.UserId == 0 || capture.TokenId == 0 {
+		return false
+	}
+	conversationLogOnce.Do(startConversationLogWriter)
+	select {
+	case conversationLogQueue <- capture:
+		return true
+	default:
+		atomic.AddUint64(&conversationLogDropped, 1)
+		return false
+	}
+}
+
+func GetConversationLoggingStats() ConversationLoggingStats {
+	stats := ConversationLoggingStats{
+		Written: atomic.LoadUint64(&conversationLogWritten),
+		Skipped: atomic.LoadUint64(&conversationLogSkipped),
+		Dropped: atomic.LoadUint64(&conversationLogDropped),
+	}
+	if conversationLogQueue != nil {
+		stats.Queued = len(conversationLogQueue)
+	}
+	return stats
+}
+
+func startConversationLogWriter() {
+	bufferSize := config.GetConversationLoggingConfig().BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 1024
+	}
+	conversationLogQueue = make(chan *ConversationCapture, bufferSize)
+	gopool.Go(func() {
+		for capture := range conversationLogQueue {
+			if err := storeConversationCapture(capture); err != nil {
+				common.SysError(fmt.Sprintf("failed to store conversation log for user %d: %v", capture.UserId, err))
+			}
+		}
+	})
+}
+
+func storeConversationCapture(capture *ConversationCapture) error {
+	record := BuildConversationRecord(capture)
+	plaintext, err := common.Marshal(record)
+	if err != nil {
+		return err
+	}
+	log := &model.ConversationLog{
+		UserId:           capture.UserId,
+		TokenId:          capture.TokenId,
+		Model:            capture.Model,
+		Source:           model.ConversationLogSourceRelay,
+		RequestId:        capture.RequestId,
+		MessageCount:     record.MessageCount,
+		PromptTokens:     record.PromptTokens,
+		CompletionTokens: record.CompletionTokens,
+	}
+	if err := SaveSealedConversationLog(log, plaintext); err != nil {
+		if errors.Is(err, ErrNoEncryptionKeys) {
+			atomic.AddUint64(&conversationLogSkipped, 1)
+			return nil
+		}
+		return err
+	}
+	atomic.AddUint64(&conversationLogWritten, 1)
+	return nil
+}
+
+// ErrNoEncryptionKeys means the user has no active key to seal logs to.
+var ErrNoEncryptionKeys = errors.New("no active encryption keys registered")
+
+// SaveSealedConversationLog seals plaintext to every active encryption key of
+// log.UserId and stores the log with one wrapped data key per encryption key.
+// Without a key the server has nothing it may seal to, and storing plaintext
+// would break the end-to-end guarantee, so ErrNoEncryptionKeys is returned.
+func SaveSealedConversationLog(log *model.ConversationLog, plaintext []byte) error {
+	user, err := model.GetUserById(log.UserId, false)
+	if err != nil {
+		return err
+	}
+	if !user.EncryptionEnabled {
+		return ErrNoEncryptionKeys
+	}
+	keys, err := model.GetActiveUserEncryptionKeys(log.UserId)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrNoEncryptionKeys
+	}
+	publicKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		publicKeys = append(publicKeys, key.PublicKey)
+	}
+	sealed, err := common.SealToPublicKeys(plaintext, publicKeys)
+	if err != nil {
+		return err
+	}
+	log.EncryptedData = sealed.Ciphertext
+	log.Nonce = sealed.Nonce
+	logKeys := make([]*model.ConversationLogKey, 0, len(keys))
+	for i, key := range keys {
+		logKeys = append(logKeys, &model.ConversationLogKey{
+			KeyId:      key.Id,
+			WrappedKey: sealed.WrappedKeys[i],
+		})
+	}
+	return model.CreateSealedConversationLog(log, logKeys)
+}
+
+// ConversationRecord is the plaintext document sealed into a conversation log.
+type ConversationRecord struct {
+	Model            string                 `json:"model"`
+	RequestId        string                 `json:"request_id,omitempty"`
+	Timestamp        int64                  `json:"timestamp"`
+	Request          map[string]interface{} `json:"request"`
+	Response         string                 `json:"response"`
+	FinishReason     string                 `json:"finish_reason,omitempty"`
+	Truncated        bool                   `json:"truncated,omitempty"`
+	MessageCount     int                    `json:"-"`
+	PromptTokens     int                    `json:"-"`
+	CompletionTokens int                    `json:"-"`
+}
+
+// BuildConversationRecord extracts the conversation messages from the request
+// and the final assistant output from the response, reassembling streamed chunks.
+func BuildConversationRecord(capture *ConversationCapture) *ConversationRecord {
+	record := &ConversationRecord{
+		Model:     capture.Model,
+		RequestId: capture.RequestId,
+		Timestamp: capture.Timestamp,
+		Request:   map[string]interface{}{},
+		Truncated: capture.Truncated,
+	}
+	var request map[string]interface{}
+	if err := common.Unmarshal(capture.RequestBody, &request); err == nil {
+		for _, field := range conversationRequestFields {
+			if value, ok := request[field]; ok {
+				record.Request[field] = value
+			}
+		}
+		record.MessageCount = countConversationMessages(request)
+	}
+	assembler := &conversationAssembler{}
+	if capture.Stream {
+		scanner := bufio.NewScanner(bytes.NewReader(capture.ResponseBody))
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "" || data == "[DONE]" {
+				continue
+			}
+			var chunk map[string]interface{}
+			if err := common.UnmarshalJsonStr(data, &chunk); err == nil {
+				assembler.add(chunk, true)
+			}
+		}
+	} else {
+		var response map[string]interface{}
+		if err := common.Unmarshal(capture.ResponseBody, &response); err == nil {
+			assembler.add(response, false)
+		} else {
+			// Gemini non-SSE streaming returns a JSON array of chunks.
+			var chunks []map[string]interface{}
+			if err := common.Unmarshal(capture.ResponseBody, &chunks); err == nil {
+				for _, chunk := range chunks {
+					assembler.add(chunk, true)
+				}
+			}
+		}
+	}
+	record.Response = assembler.text.String()
+	record.FinishReason = assembler.finishReason
+	record.PromptTokens = assembler.promptTokens
+	record.CompletionTokens = assembler.completionTokens
+	if record.Response != "" {
+		record.MessageCount++
+	}
+	return record
+}
+
+func countConversationMessages(request map[string]interface{}) int {
+	for _, field := range []string{"messages", "contents", "input"} {
+		switch value := request[field].(type) {
+		case []interface{}:
+			return len(value)
+		case string:
+			if value != "" {
+				return 1
+			}
+		}
+	}
+	if prompt, ok := request["prompt"]; ok && prompt != nil {
+		return 1
+	}
+	return 0
+}
+
+// conversationAssembler accumulates assistant text from full responses or
+// stream chunks in any of the supported upstream formats.
+type conversationAssembler struct {
+	text             strings.Builder
+	finishReason     string
+	promptTokens     int
+	completionTokens int
+}
+
+func (a *conversationAssembler) add(chunk map[string]interface{}, stream bool) {
+	a.readUsage(chunk)
+	// OpenAI chat / completions
+	if choices, ok := chunk["choices"].([]interface{}); ok {
+		for _, item := range choices {
+			choice, _ := item.(map[string]interface{})
+			if choice == nil {
+				continue
+			}
+			if stream {
+				if delta, ok := choice["delta"].(map[string]interface{}); ok {
+					a.appendString(delta["content"])
+				}
+			} else if message, ok := choice["message"].(map[string]interface{}); ok {
+				a.appendString(message["content"])
+			}
+			a.appendString(choice["text"])
+			if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+				a.finishReason = reason
+			}
+		}
+		return
+	}
+	// Gemini
+	if candidates, ok := chunk["candidates"].([]interface{}); ok {
+		for _, item := range candidates {
+			candidate, _ := item.(map[string]interface{})
+			if candidate == nil {
+				continue
+			}
+			if content, ok := candidate["content"].(map[string]interface{}); ok {
+				a.appendParts(content["parts"])
+			}
+			if reason, ok := candidate["finishReason"].(string); ok && reason != "" {
+				a.finishReason = reason
+			}
+		}
+		return
+	}
+	switch chunkType, _ := chunk["type"].(string); chunkType {
+	case "content_block_delta":
+		// Claude stream
+		if delta, ok := chunk["delta"].(map[string]interface{}); ok {
+			a.appendString(delta["text"])
+		}
+	case "message_delta":
+		if delta, ok := chunk["delta"].(map[string]interface{}); ok {
+			if reason, ok := delta["stop_reason"].(string); ok && reason != "" {
+				a.finishReason = reason
+			}
+		}
+	case "response.output_text.delta":
+		// Responses API stream
+		a.appendString(chunk["delta"])
+	case "response.completed":
+		if response, ok := chunk["response"].(map[string]interface{}); ok {
+			a.readUsage(response)
+			if status, ok := response["status"].(string); ok {
+				a.finishReason = status
+			}
+		}
+	case "message":
+		// Claude non-stream
+		a.appendParts(chunk["content"])
+		if reason, ok := chunk["stop_reason"].(string); ok && reason != "" {
+			a.finishReason = reason
+		}
+	case "response":
+		// Responses API non-stream
+		if output, ok := chunk["output"].([]interface{}); ok {
+			for _, item := range output {
+				if message, ok := item.(map[string]interface{}); ok {
+					a.appendParts(message["content"])
+				}
+			}
+		}
+		if status, ok := chunk["status"].(string); ok {
+			a.finishReason = status
+		}
+	}
+}
+
+func (a *conversationAssembler) appendString(value interface{}) {
+	if text, ok := value.(string); ok {
+		a.text.WriteString(text)
+	}
+}
+
+func (a *conversationAssembler) appendParts(value interface{}) {
+	parts, ok := value.([]interface{})
+	if !ok {
+		a.appendString(value)
+		return
+	}
+	for _, item := range parts {
+		if part, ok := item.(map[string]interface{}); ok {
+			a.appendString(part["text"])
+		}
+	}
+}
+
+func (a *conversationAssembler) readUsage(chunk map[string]interface{}) {
+	usage, ok := chunk["usage"].(map[string]interface{})
+	if !ok {
+		usage, ok = chunk["usageMetadata"].(map[string]interface{})
+	}
+	if !ok {
+		if message, isMap := chunk["message"].(map[string]interface{}); isMap {
+			usage, ok = message["usage"].(map[string]interface{})
+		}
+	}
+	if !ok {
+		return
+	}
+	for _, key := range []string{"prompt_tokens", "input_tokens", "promptTokenCount"} {
+		if value, ok := usage[key].(float64); ok && value > 0 {
+			a.promptTokens = int(value)
+		}
+	}
+	for _, key := range []string{"completion_tokens", "output_tokens", "candidatesTokenCount"} {
+		if value, ok := usage[key].(float64); ok && value > 0 {
+			a.completionTokens = int(value)
+		}
+	}
+}
+
+// StartConversationLogRetention purges conversation logs past the configured
+// retention window. It runs for the lifetime of the process on the master node.
+func StartConversationLogRetention() {
+	for {
+		loggingConfig := config.GetConversationLoggingConfig()
+		interval := time.Duration(loggingConfig.RetentionCheckHours) * time.Hour
+		if interval <= 0 {
+			interval = 6 * time.Hour
+		}
+		if common.IsMasterNode && loggingConfig.RetentionDays > 0 {
+			cutoff := time.Now().AddDate(0, 0, -loggingConfig.RetentionDays).Unix()
+			count, err := model.PurgeConversationLogsBefore(cutoff)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to purge expired conversation logs: %v", err))
+			} else if count > 0 {
+				common.SysLog(fmt.Sprintf("purged %d conversation logs older than %d days", count, loggingConfig.RetentionDays))
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// ConversationExportRecord is one sealed log in an exported archive. Nothing in
+// it can be decrypted without the user's private key.
+type ConversationExportRecord struct {
+	Id               int    `json:"id"`
+	TokenId          int    `json:"token_id"`
+	Model            string `json:"model"`
+	Timestamp        int64  `json:"timestamp"`
+	RequestId        string `json:"request_id"`
+	Source           string `json:"source"`
+	MessageCount     int    `json:"message_count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	KeyScheme        string `json:"key_scheme"`
+	EncryptedData    string `json:"encrypted_data"`
+	Nonce            string `json:"nonce"`
+	// WrappedKeys maps encryption key id to the data key wrapped for it.
+	WrappedKeys map[int]string `json:"wrapped_keys,omitempty"`
+}
+
+// ConversationExportArchive is the downloadable encrypted archive.
+type ConversationExportArchive struct {
+	Version    int                        `json:"version"`
+	Scheme     string                     `json:"scheme"`
+	UserId     int                        `json:"user_id"`
+	ExportedAt int64                      `json:"exported_at"`
+	Truncated  bool                       `json:"truncated"`
+	Keys       []*model.UserEncryptionKey `json:"keys"`
+	Records    []ConversationExportRecord `json:"records"`
+}
+
+// ExportConversationLogs collects a user's sealed logs into an archive, capped
+// at the configured maximum record count.
+func ExportConversationLogs(userId int, tokenId int, startTimestamp int64, endTimestamp int64) (*ConversationExportArchive, error) {
+	if userId == 0 {
+		return nil, errors.New("user id is required")
+	}
+	maxRecords := config.GetConversationLoggingConfig().ExportMaxRecords
+	if maxRecords <= 0 {
+		maxRecords = 10000
+	}
+	archive := &ConversationExportArchive{
+		Version:    1,
+		Scheme:     common.ConversationSealInfo,
+		UserId:     userId,
+		ExportedAt: time.Now().Unix(),
+		Records:    make([]ConversationExportRecord, 0),
+	}
+	keys, err := model.GetUserEncryptionKeys(userId)
+	if err != nil {
+		return nil, err
+	}
+	archive.Keys = keys
+	const pageSize = 500
+	afterId := 0
+	for !archive.Truncated {
+		logs, err := model.GetConversationLogsForExport(userId, tokenId, startTimestamp, endTimestamp, afterId, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		logIds := make([]int, 0, len(logs))
+		for _, log := range logs {
+			logIds = append(logIds, log.Id)
+		}
+		logKeys, err := model.GetConversationLogKeysByLogIds(userId, logIds)
+		if err != nil {
+			return nil, err
+		}
+		for _, log := range logs {
+			if len(archive.Records) >= maxRecords {
+				archive.Truncated = true
+				break
+			}
+			var wrappedKeys map[int]string
+			if keys := logKeys[log.Id]; len(keys) > 0 {
+				wrappedKeys = make(map[int]string, len(keys))
+				for _, key := range keys {
+					wrappedKeys[key.KeyId] = key.WrappedKey
+				}
+			}
+			archive.Records = append(archive.Records, ConversationExportRecord{
+				Id:               log.Id,
+				TokenId:          log.TokenId,
+				Model:            log.Model,
+				Timestamp:        log.Timestamp,
+				RequestId:        log.RequestId,
+				Source:           log.Source,
+				MessageCount:     log.MessageCount,
+				PromptTokens:     log.PromptTokens,
+				CompletionTokens: log.CompletionTokens,
+				KeyScheme:        log.KeyScheme,
+				EncryptedData:    log.EncryptedData,
+				Nonce:            log.Nonce,
+				WrappedKeys:      wrappedKeys,
+			})
+			afterId = log.Id
+		}
+		if len(logs) < pageSize {
+			break
+		}
+	}
+	return archive, nil
+}
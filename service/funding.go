@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"gorm.io/gorm"
+)
+
+// fundingWaterfallApplies reports whether a request is charged through the
+// funding waterfall. Organization tokens charge their organization's pool,
+// and tokens set to plan or auto billing keep the billing engine's charging.
+func fundingWaterfallApplies(relayInfo *relaycommon.RelayInfo) bool {
+	if !config.GetFundingConfig().Enabled || relayInfo.OrganizationId != 0 {
+		return false
+	}
+	if common.BillingFeatureEnabled {
+		switch strings.ToLower(relayInfo.BillingMode) {
+		case common.BillingModePlan, common.BillingModeAuto:
+			return false
+		}
+	}
+	return true
+}
+
+// resolveFundingWaterfall returns the funding sources of a request in order:
+// the token's override, then the user's, then the configured default.
+func resolveFundingWaterfall(relayInfo *relaycommon.RelayInfo) []string {
+	for _, waterfall := range []string{relayInfo.TokenFundingWaterfall, relayInfo.UserSetting.FundingWaterfall} {
+		if sources, err := common.ParseFundingWaterfall(waterfall); err == nil && len(sources) > 0 {
+			return sources
+		}
+	}
+	sources, err := common.ParseFundingWaterfall(config.GetFundingConfig().Waterfall)
+	if err != nil || len(sources) == 0 {
+		return []string{common.FundingSourceBalance}
+	}
+	return sources
+}
+
+// fundingLedgerFor returns the request's funding ledger, starting one if the
+// request is charged through the funding waterfall; otherwise nil.
+func fundingLedgerFor(relayInfo *relaycommon.RelayInfo) *relaycommon.FundingLedger {
+	if relayInfo.Funding != nil {
+		return relayInfo.Funding
+	}
+	if !fundingWaterfallApplies(relayInfo) {
+		return nil
+	}
+	relayInfo.Funding = &relaycommon.FundingLedger{Waterfall: resolveFundingWaterfall(relayInfo)}
+	return relayInfo.Funding
+}
+
+func fundingUsesBalance(ledger *relaycommon.FundingLedger) bool {
+	return ledger == nil || common.StringsContains(ledger.Waterfall, common.FundingSourceBalance)
+}
+
+// prepareFundingPlan returns the user's plan when its allowance can fund the
+// request: plans are a billing feature, must allow the model, and must count
+// quota rather than tokens.
+func prepareFundingPlan(relayInfo *relaycommon.RelayInfo) *PreparedCharge {
+	if !common.BillingFeatureEnabled {
+		return nil
+	}
+	prepared, err := NewBillingEngine(nil).PrepareCharge(context.Background(), "", relayInfo)
+	if err != nil {
+		var restricted *ErrModelRestricted
+		if !errors.As(err, &restricted) {
+			common.SysError(fmt.Sprintf("failed to prepare plan funding for user %d: %s", relayInfo.UserId, err.Error()))
+		}
+		return nil
+	}
+	if prepared.Assignment == nil || prepared.Plan == nil || prepared.ModelRestricted ||
+		prepared.Plan.QuotaMetric == common.PlanQuotaMetricTokens {
+		return nil
+	}
+	return prepared
+}
+
+// fundingAvailable is the quota the request's funding sources can pay.
+func fundingAvailable(relayInfo *relaycommon.RelayInfo, ledger *relaycommon.FundingLedger, userQuota int) (int, error) {
+	available := 0
+	for _, source := range ledger.Waterfall {
+		switch source {
+		case common.FundingSourcePackage:
+			quota, err := model.GetUsablePackageQuota(relayInfo.UserId, relayInfo.OriginModelName)
+			if err != nil {
+				return 0, err
+			}
+			available += int(quota)
+		case common.FundingSourcePlan:
+			if prepared := prepareFundingPlan(relayInfo); prepared != nil {
+				available += int(prepared.Allowance)
+			}
+		case common.FundingSourceBalance:
+			if userQuota > 0 {
+				available += userQuota
+			}
+		}
+	}
+	return available, nil
+}
+
+// drawPlanAllowance takes up to amount from the plan's allowance of the
+// current cycle and returns what it took.
+func drawPlanAllowance(prepared *PreparedCharge, amount int) (int, error) {
+	drawn := 0
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		counter, err := model.GetUsageCounterTx(tx, prepared.Assignment.Id, prepared.Plan.QuotaMetric, prepared.CycleStart)
+		if err != nil {
+			return err
+		}
+		remaining := prepared.Plan.QuotaAmount + prepared.Assignment.RolloverAmount
+		if counter != nil {
+			remaining -= counter.ConsumedAmount
+		}
+		drawn = int(min(int64(amount), max(remaining, 0)))
+		return model.IncrementUsageCounterTx(tx, prepared.Assignment.Id, prepared.Plan.QuotaMetric, int64(drawn), prepared.CycleStart, prepared.CycleEnd)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return drawn, nil
+}
+
+// drawFunding charges quota to the funding sources in waterfall order. A
+// balance that is not the last source only pays what it holds. Whatever the
+// sources cannot pay is charged to the balance, as without the waterfall.
+func drawFunding(relayInfo *relaycommon.RelayInfo, ledger *relaycommon.FundingLedger, quota int) error {
+	remaining := quota
+	chargeBalance := func(amount int) error {
+		if err := model.DecreaseUserQuota(relayInfo.UserId, amount); err != nil {
+			return err
+		}
+		ledger.Add(relaycommon.FundingDraw{Source: common.FundingSourceBalance, Quota: amount})
+		remaining -= amount
+		return nil
+	}
+	for i, source := range ledger.Waterfall {
+		if remaining <= 0 {
+			break
+		}
+		switch source {
+		case common.FundingSourcePackage:
+			packages, err := DrawPackageQuota(relayInfo.UserId, relayInfo.OriginModelName, int64(remaining))
+			if err != nil {
+				return err
+			}
+			for _, pkg := range packages {
+				ledger.Add(relaycommon.FundingDraw{Source: common.FundingSourcePackage, Id: pkg.Id, Quota: int(pkg.TokenQuota)})
+				remaining -= int(pkg.TokenQuota)
+			}
+		case common.FundingSourcePlan:
+			prepared := prepareFundingPlan(relayInfo)
+			if prepared == nil {
+				continue
+			}
+			drawn, err := drawPlanAllowance(prepared, remaining)
+			if err != nil {
+				return err
+			}
+			if drawn > 0 {
+				ledger.Add(relaycommon.FundingDraw{Source: common.FundingSourcePlan, Id: prepared.Assignment.Id, Quota: drawn,
+					Metric: prepared.Plan.QuotaMetric, CycleStart: prepared.CycleStart, CycleEnd: prepared.CycleEnd})
+				remaining -= drawn
+			}
+		case common.FundingSourceBalance:
+			amount := remaining
+			if i < len(ledger.Waterfall)-1 {
+				userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+				if err != nil {
+					return err
+				}
+				amount = min(remaining, max(userQuota, 0))
+			}
+			if amount > 0 {
+				if err := chargeBalance(amount); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if remaining > 0 {
+		return chargeBalance(remaining)
+	}
+	return nil
+}
+
+// refundFunding returns quota to the funding sources, the last drawn first,
+// so the earlier sources in the waterfall keep paying. Quota beyond what the
+// sources hold goes to the balance.
+func refundFunding(relayInfo *relaycommon.RelayInfo, ledger *relaycommon.FundingLedger, quota int) error {
+	remaining := quota
+	for i := len(ledger.Draws) - 1; i >= 0 && remaining > 0; i-- {
+		draw := ledger.Draws[i]
+		amount := min(remaining, draw.Quota)
+		if amount <= 0 {
+			continue
+		}
+		var err error
+		switch draw.Source {
+		case common.FundingSourcePackage:
+			err = model.RefundUserPackageQuota(draw.Id, int64(amount))
+		case common.FundingSourcePlan:
+			err = model.DecrementUsageCounter(draw.Id, draw.Metric, int64(amount), draw.CycleStart)
+		default:
+			err = model.IncreaseUserQuota(relayInfo.UserId, amount, false)
+		}
+		if err != nil {
+			return err
+		}
+		draw.Quota -= amount
+		remaining -= amount
+	}
+	if remaining > 0 {
+		return model.IncreaseUserQuota(relayInfo.UserId, remaining, false)
+	}
+	return nil
+}
+
+// fundingSummary formats the quota held from each source for logs.
+func fundingSummary(ledger *relaycommon.FundingLedger) string {
+	var parts []string
+	for _, draw := range ledger.Breakdown() {
+		name := draw.Source
+		if draw.Id != 0 {
+			name = fmt.Sprintf("%s#%d", draw.Source, draw.Id)
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", name, logger.FormatQuota(draw.Quota)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// AppendFundingInfo adds the quota drawn from each funding source to a
+// consume log's other info.
+func AppendFundingInfo(relayInfo *relaycommon.RelayInfo, other map[string]interface{}) {
+	if relayInfo.Funding == nil {
+		return
+	}
+	if breakdown := relayInfo.Funding.Breakdown(); len(breakdown) > 0 {
+		other["funding"] = breakdown
+	}
+}
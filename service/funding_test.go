@@ -0,0 +1,294 @@
+package service
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+type fundingFixture struct {
+	db    *gorm.DB
+	user  *model.User
+	token *model.Token
+}
+
+func setupFundingTest(t *testing.T, balance int) *fundingFixture {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Token{}, &model.Package{}, &model.UserPackage{},
+		&model.Plan{}, &model.PlanAssignment{}, &model.UsageCounter{}, &model.SpendingBudget{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB, oldBilling := model.DB, common.BillingFeatureEnabled
+	oldFunding := *config.GetFundingConfig()
+	model.DB = db
+	common.RedisEnabled = false
+	common.BillingFeatureEnabled = true
+	*config.GetFundingConfig() = config.FundingConfig{Enabled: true, Waterfall: "package,plan,balance"}
+	t.Cleanup(func() {
+		model.DB, common.BillingFeatureEnabled = oldDB, oldBilling
+		*config.GetFundingConfig() = oldFunding
+	})
+	common.UsingSQLite = true
+	common.UsingPostgreSQL = false
+	common.UsingMySQL = false
+
+	user := &model.User{Username: "u", Password: "password", Quota: balance, Status: common.UserStatusEnabled}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	token := &model.Token{UserId: user.Id, Key: "funding", Name: "t", RemainQuota: 1000, Status: common.TokenStatusEnabled}
+	if err := db.Create(token).Error; err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	return &fundingFixture{db: db, user: user, token: token}
+}
+
+func (f *fundingFixture) addPackage(t *testing.T, quota int64, expireIn time.Duration, models string) *model.UserPackage {
+	t.Helper()
+	pkg := &model.UserPackage{UserId: f.user.Id, PackageId: 1, TokenQuota: quota, InitialQuota: quota, ModelScope: models,
+		ExpireAt: time.Now().Add(expireIn), Status: common.UserPackageStatusActive}
+	if err := f.db.Create(pkg).Error; err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	return pkg
+}
+
+func (f *fundingFixture) relayInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{UserId: f.user.Id, TokenId: f.token.Id, TokenKey: f.token.Key, OriginModelName: "gpt-4o",
+		BillingMode: common.BillingModeBalance, StartTime: time.Now()}
+}
+
+func (f *fundingFixture) userQuota(t *testing.T) int {
+	t.Helper()
+	var user model.User
+	if err := f.db.First(&user, f.user.Id).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	return user.Quota
+}
+
+func (f *fundingFixture) packageQuota(t *testing.T, id int) (int64, int) {
+	t.Helper()
+	var pkg model.UserPackage
+	if err := f.db.First(&pkg, id).Error; err != nil {
+		t.Fatalf("load package: %v", err)
+	}
+	return pkg.TokenQuota, pkg.Status
+}
+
+func TestFundingWaterfallDrawsAndRefundsInOrder(t *testing.T) {
+	f := setupFundingTest(t, 100)
+	later := f.addPackage(t, 40, 48*time.Hour, "")
+	sooner := f.addPackage(t, 30, 24*time.Hour, "")
+	f.addPackage(t, 50, time.Hour, `["claude-3"]`)
+	plan := &model.Plan{Code: "basic", Name: "Basic", CycleType: common.PlanCycleMonthly, QuotaMetric: common.PlanQuotaMetricRequests, QuotaAmount: 20, IsActive: true}
+	if err := f.db.Create(plan).Error; err != nil {
+		t.Fatalf("create plan: %v", err)
+	}
+	assignment := &model.PlanAssignment{SubjectType: common.AssignmentSubjectTypeUser, SubjectId: f.user.Id, PlanId: plan.Id,
+		BillingMode: common.BillingModePlan, ActivatedAt: time.Now().Add(-time.Hour)}
+	if err := f.db.Create(assignment).Error; err != nil {
+		t.Fatalf("create assignment: %v", err)
+	}
+
+	info := f.relayInfo()
+	if err := PostConsumeQuota(info, 100, 0, false); err != nil {
+		t.Fatalf("consume: %v", err)
+	}
+	breakdown := info.Funding.Breakdown()
+	if fmt.Sprint(breakdown) != fmt.Sprint([]relaycommon.FundingDraw{
+		{Source: common.FundingSourcePackage, Id: sooner.Id, Quota: 30},
+		{Source: common.FundingSourcePackage, Id: later.Id, Quota: 40},
+		{Source: common.FundingSourcePlan, Id: assignment.Id, Quota: 20, Metric: common.PlanQuotaMetricRequests, CycleStart: breakdown[2].CycleStart, CycleEnd: breakdown[2].CycleEnd},
+		{Source: common.FundingSourceBalance, Quota: 10},
+	}) {
+		t.Fatalf("unexpected breakdown %+v", breakdown)
+	}
+	if quota := f.userQuota(t); quota != 90 {
+		t.Fatalf("balance = %d, want 90", quota)
+	}
+	if quota, status := f.packageQuota(t, sooner.Id); quota != 0 || status != common.UserPackageStatusExhausted {
+		t.Fatalf("sooner package = %d (status %d), want exhausted", quota, status)
+	}
+
+	// a refund returns the last drawn sources first
+	if err := PostConsumeQuota(info, -35, 0, false); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if quota := f.userQuota(t); quota != 100 {
+		t.Fatalf("balance = %d, want 100", quota)
+	}
+	var counter model.UsageCounter
+	if err := f.db.Where("plan_assignment_id = ?", assignment.Id).First(&counter).Error; err != nil || counter.ConsumedAmount != 0 {
+		t.Fatalf("plan usage = %+v, %v, want 0", counter, err)
+	}
+	if quota, _ := f.packageQuota(t, later.Id); quota != 5 {
+		t.Fatalf("later package = %d, want 5", quota)
+	}
+	if err := PostConsumeQuota(info, -65, 0, false); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if quota, status := f.packageQuota(t, sooner.Id); quota != 30 || status != common.UserPackageStatusActive {
+		t.Fatalf("sooner package = %d (status %d), want it reactivated", quota, status)
+	}
+	if total := info.Funding.Total(); total != 0 {
+		t.Fatalf("ledger still holds %d", total)
+	}
+}
+
+func TestPreConsumeQuotaFundedByPackages(t *testing.T) {
+	f := setupFundingTest(t, 0)
+	pkg := f.addPackage(t, 50, time.Hour, "")
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	if err := PreConsumeQuota(c, 60, f.relayInfo()); err == nil || err.StatusCode != 403 {
+		t.Fatalf("expected pre-consuming more than the package holds to fail, got %v", err)
+	}
+
+	info := f.relayInfo()
+	// playground requests leave the token quota alone
+	info.IsPlayground = true
+	if err := PreConsumeQuota(c, 20, info); err != nil {
+		t.Fatalf("pre-consume: %v", err)
+	}
+	if info.FinalPreConsumedQuota != 20 {
+		t.Fatalf("pre-consumed %d, want 20", info.FinalPreConsumedQuota)
+	}
+	if quota, _ := f.packageQuota(t, pkg.Id); quota != 30 {
+		t.Fatalf("package = %d, want 30", quota)
+	}
+	// the request failed: everything returns to the package
+	if err := PostConsumeQuota(info, -info.FinalPreConsumedQuota, 0, false); err != nil {
+		t.Fatalf("return pre-consumed: %v", err)
+	}
+	if quota, _ := f.packageQuota(t, pkg.Id); quota != 50 {
+		t.Fatalf("package = %d, want 50", quota)
+	}
+	if quota := f.userQuota(t); quota != 0 {
+		t.Fatalf("balance = %d, want 0", quota)
+	}
+}
+
+func TestFundingWaterfallTokenOverride(t *testing.T) {
+	f := setupFundingTest(t, 100)
+	pkg := f.addPackage(t, 50, time.Hour, "")
+
+	info := f.relayInfo()
+	info.TokenFundingWaterfall = "balance,package"
+	if err := PostConsumeQuota(info, 120, 0, false); err != nil {
+		t.Fatalf("consume: %v", err)
+	}
+	if quota := f.userQuota(t); quota != 0 {
+		t.Fatalf("balance = %d, want 0", quota)
+	}
+	if quota, _ := f.packageQuota(t, pkg.Id); quota != 30 {
+		t.Fatalf("package = %d, want 30", quota)
+	}
+
+	// tokens billed by plan keep the billing engine
+	info = f.relayInfo()
+	info.BillingMode = common.BillingModePlan
+	if fundingLedgerFor(info) != nil {
+		t.Fatal("expected plan-billed tokens to keep the billing engine")
+	}
+}
+
+// addPlan gives the user a plan allowing allowance requests per cycle.
+func (f *fundingFixture) addPlan(t *testing.T, allowance int64) *model.PlanAssignment {
+	t.Helper()
+	plan := &model.Plan{Code: "basic", Name: "Basic", CycleType: common.PlanCycleMonthly, QuotaMetric: common.PlanQuotaMetricRequests, QuotaAmount: allowance, IsActive: true}
+	if err := f.db.Create(plan).Error; err != nil {
+		t.Fatalf("create plan: %v", err)
+	}
+	assignment := &model.PlanAssignment{SubjectType: common.AssignmentSubjectTypeUser, SubjectId: f.user.Id, PlanId: plan.Id,
+		BillingMode: common.BillingModePlan, ActivatedAt: time.Now().Add(-time.Hour)}
+	if err := f.db.Create(assignment).Error; err != nil {
+		t.Fatalf("create assignment: %v", err)
+	}
+	return assignment
+}
+
+// expectFundingRestored checks every source holds what it held before the
+// request: package, plan allowance and balance.
+func (f *fundingFixture) expectFundingRestored(t *testing.T, pkg *model.UserPackage, assignment *model.PlanAssignment, balance int) {
+	t.Helper()
+	if quota, status := f.packageQuota(t, pkg.Id); quota != pkg.InitialQuota || status != common.UserPackageStatusActive {
+		t.Fatalf("package = %d (status %d), want %d", quota, status, pkg.InitialQuota)
+	}
+	var used int64
+	f.db.Model(&model.UsageCounter{}).Where("plan_assignment_id = ?", assignment.Id).Select("COALESCE(SUM(consumed_amount), 0)").Scan(&used)
+	if used != 0 {
+		t.Fatalf("plan usage = %d, want 0", used)
+	}
+	if quota := f.userQuota(t); quota != balance {
+		t.Fatalf("balance = %d, want %d", quota, balance)
+	}
+}
+
+func TestFailedRequestRefundsEachFundingSource(t *testing.T) {
+	f := setupFundingTest(t, 10)
+	pkg := f.addPackage(t, 30, time.Hour, "")
+	assignment := f.addPlan(t, 20)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	info := f.relayInfo()
+	info.IsPlayground = true
+	if err := PreConsumeQuota(c, 55, info); err != nil {
+		t.Fatalf("pre-consume: %v", err)
+	}
+	breakdown := info.Funding.Breakdown()
+	if len(breakdown) != 3 || breakdown[0].Quota != 30 || breakdown[1].Quota != 20 || breakdown[2].Quota != 5 {
+		t.Fatalf("unexpected breakdown %+v", breakdown)
+	}
+	if quota := f.userQuota(t); quota != 5 {
+		t.Fatalf("balance = %d, want 5", quota)
+	}
+
+	// the request failed: what was pre-consumed goes back where it came from
+	if err := PostConsumeQuota(info, -info.FinalPreConsumedQuota, 0, false); err != nil {
+		t.Fatalf("return pre-consumed: %v", err)
+	}
+	f.expectFundingRestored(t, pkg, assignment, 10)
+	if total := info.Funding.Total(); total != 0 {
+		t.Fatalf("ledger still holds %d", total)
+	}
+}
+
+func TestPreConsumeDrawFailureRefundsEarlierSources(t *testing.T) {
+	f := setupFundingTest(t, 10)
+	pkg := f.addPackage(t, 30, time.Hour, "")
+	assignment := f.addPlan(t, 20)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	// charging the balance, the last source drawn, fails
+	if err := f.db.Exec("CREATE TRIGGER fail_balance BEFORE UPDATE OF quota ON users BEGIN SELECT RAISE(ABORT, 'balance unavailable'); END").Error; err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+
+	info := f.relayInfo()
+	info.IsPlayground = true
+	if err := PreConsumeQuota(c, 55, info); err == nil || !strings.Contains(err.Error(), "balance unavailable") {
+		t.Fatalf("expected the failed balance charge to fail pre-consume, got %v", err)
+	}
+	if info.FinalPreConsumedQuota != 0 {
+		t.Fatalf("pre-consumed %d after a failure", info.FinalPreConsumedQuota)
+	}
+	f.expectFundingRestored(t, pkg, assignment, 10)
+}
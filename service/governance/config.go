@@ -42,9 +42,8 @@ func AbuseRPMThreshold() int {
 
 // PromptMaxLength returns the maximum allowed prompt length before flagging.
 func PromptMaxLength() int {
-    if gc := cfg.GetGovernanceConfig(); gc != nil {
-        // Support optional dynamic field via reflection-less approach: we rely on
-        // environment fallback if not wired in config object.
+    if gc := cfg.GetGovernanceConfig(); gc != nil && gc.PromptMaxLength > 0 {
+        return gc.PromptMaxLength
     }
     if s := os.Getenv("GOVERNANCE_PROMPT_MAX_LENGTH"); s != "" {
         if n, err := strconv.Atoi(s); err == nil && n > 0 {
@@ -57,6 +56,9 @@ func PromptMaxLength() int {
 // PromptMinEntropyBits returns the minimum acceptable Shannon entropy (bits per char)
 // for a prompt; lower values are considered suspicious.
 func PromptMinEntropyBits() float64 {
+    if gc := cfg.GetGovernanceConfig(); gc != nil && gc.PromptMinEntropy > 0 {
+        return gc.PromptMinEntropy
+    }
     if s := os.Getenv("GOVERNANCE_PROMPT_MIN_ENTROPY"); s != "" {
         if f, err := strconv.ParseFloat(s, 64); err == nil && f > 0 {
             return f
@@ -68,6 +70,9 @@ func PromptMinEntropyBits() float64 {
 // PromptMaxRepetitionRatio returns the maximum allowed ratio for the most frequent
 // rune within a prompt. Values above this threshold are flagged.
 func PromptMaxRepetitionRatio() float64 {
+    if gc := cfg.GetGovernanceConfig(); gc != nil && gc.PromptMaxRepetition > 0 && gc.PromptMaxRepetition <= 1.0 {
+        return gc.PromptMaxRepetition
+    }
     if s := os.Getenv("GOVERNANCE_PROMPT_MAX_REPETITION"); s != "" {
         if f, err := strconv.ParseFloat(s, 64); err == nil && f > 0 && f <= 1.0 {
             return f
@@ -80,10 +85,17 @@ func PromptMaxRepetitionRatio() float64 {
 // combined with any environment overrides. Entries are normalized to lower-case and
 // trimmed. It is intended to be merged with the base sensitive word list.
 func ViolationKeywords() []string {
-    // Prefer config manager if such a key exists later; for now, read from env.
+    out := make([]string, 0)
+    if gc := cfg.GetGovernanceConfig(); gc != nil {
+        for _, w := range gc.ViolationKeywords {
+            if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+                out = append(out, w)
+            }
+        }
+    }
     raw := os.Getenv("GOVERNANCE_VIOLATION_KEYWORDS")
     if raw == "" {
-        return nil
+        return out
     }
     // Support comma or newline separated values
     sep := ","
@@ -91,7 +103,6 @@ func ViolationKeywords() []string {
         sep = "\n"
     }
     parts := strings.Split(raw, sep)
-    out := make([]string, 0, len(parts))
     for _, p := range parts {
         w := strings.ToLower(strings.TrimSpace(p))
         if w != "" {
@@ -9,8 +9,6 @@ import (
     "sync"
     "sync/atomic"
     "time"
-
-    "github.com/QuantumNous/new-api/service"
 )
 
 // Severity indicates the impact level of a detector result.
@@ -33,6 +31,28 @@ type DetectorResult struct {
     Metadata  map[string]string `json:"metadata,omitempty"`
 }
 
+// SensitiveWordMatcher reports whether text contains a configured sensitive word.
+// The service package installs its matcher at init so this package does not
+// import it back.
+type SensitiveWordMatcher func(text string) (bool, []string)
+
+var sensitiveWordMatcher atomic.Value
+
+// SetSensitiveWordMatcher installs the matcher used by DetectKeywordPolicy.
+func SetSensitiveWordMatcher(m SensitiveWordMatcher) {
+    if m != nil {
+        sensitiveWordMatcher.Store(m)
+    }
+}
+
+func matchSensitiveWords(text string) (bool, []string) {
+    m, _ := sensitiveWordMatcher.Load().(SensitiveWordMatcher)
+    if m == nil {
+        return false, nil
+    }
+    return m(text)
+}
+
 // metrics skeleton (wired in M7)
 var (
     detectionsFlagged uint64
@@ -113,7 +133,8 @@ func DetectHighRPM(subjectKey string, now time.Time) DetectorResult {
         }
     }
     atomic.AddUint64(&detectionsPassed, 1)
-    return DetectorResult{Triggered: false}
+    // Keep the observed count so policies can match on rpm below the threshold.
+    return DetectorResult{Triggered: false, Metadata: map[string]string{"count": intToString(count)}}
 }
 
 // DetectPromptSanity runs heuristic checks on prompt content: length, entropy, repetition.
@@ -130,7 +151,7 @@ func DetectPromptSanity(prompt string) DetectorResult {
     }
 
     entropy := shannonEntropy(normalized)
-    if entropy > 0 && entropy < PromptMinEntropyBits() {
+    if len(normalized) > 0 && entropy < PromptMinEntropyBits() {
         reasons = append(reasons, "low_entropy")
     }
 
@@ -161,7 +182,7 @@ func DetectPromptSanity(prompt string) DetectorResult {
 func DetectKeywordPolicy(prompt string) DetectorResult {
     text := strings.ToLower(prompt)
     // First, reuse existing sensitive word detector
-    if ok, words := service.SensitiveWordContains(text); ok {
+    if ok, words := matchSensitiveWords(text); ok {
         hash := ""
         if len(words) > 0 {
             hash = hashSnippet(words[0])
@@ -1,12 +1,26 @@
 package governance
 
 import (
+    "strings"
     "testing"
     "time"
 
+    "github.com/QuantumNous/new-api/setting"
     cfg "github.com/QuantumNous/new-api/setting/config"
 )
 
+func init() {
+    // The service package installs the real matcher; mirror it over the default word list.
+    SetSensitiveWordMatcher(func(text string) (bool, []string) {
+        for _, w := range setting.SensitiveWords {
+            if w != "" && strings.Contains(text, strings.ToLower(w)) {
+                return true, []string{w}
+            }
+        }
+        return false, nil
+    })
+}
+
 func TestDetectorHighRPMTrigger(t *testing.T) {
     // Override threshold for test
     gc := cfg.GetGovernanceConfig()
@@ -0,0 +1,508 @@
+package governance
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cfg "github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	policyMatchAll = "all"
+	policyMatchAny = "any"
+)
+
+// PolicyFacts is everything a governance policy condition can look at.
+type PolicyFacts struct {
+	SubjectType  string
+	SubjectId    int
+	SubjectKey   string
+	UserId       int
+	TokenId      int
+	Model        string
+	Group        string
+	Path         string
+	Prompt       string
+	PromptTokens int
+	Detectors    map[string]DetectorResult
+
+	entropy         float64
+	repetitionRatio float64
+	rpm             int
+	analyzed        bool
+}
+
+func (f *PolicyFacts) analyze() {
+	if f.analyzed {
+		return
+	}
+	f.analyzed = true
+	f.entropy = shannonEntropy(f.Prompt)
+	f.repetitionRatio = maxRuneRatio(f.Prompt)
+	if result, ok := f.Detectors["high_rpm"]; ok && result.Metadata != nil {
+		f.rpm, _ = strconv.Atoi(result.Metadata["count"])
+	}
+}
+
+// PolicyMatch is a policy whose conditions matched the request.
+type PolicyMatch struct {
+	Policy       string `json:"policy"`
+	Action       string `json:"action"`
+	Severity     string `json:"severity"`
+	Reason       string `json:"reason"`
+	RerouteAlias string `json:"reroute_alias,omitempty"`
+	Message      string `json:"message,omitempty"`
+	// DryRun is true when the match was only recorded, not enforced.
+	DryRun bool `json:"dry_run"`
+	// Throttled is set for throttle actions whose budget is exhausted.
+	Throttled bool `json:"throttled,omitempty"`
+}
+
+// PolicyDecision is the outcome of evaluating every policy against a request.
+type PolicyDecision struct {
+	Matches []PolicyMatch `json:"matches"`
+}
+
+// Enforced returns the matches whose action must be applied.
+func (d *PolicyDecision) Enforced() []PolicyMatch {
+	if d == nil {
+		return nil
+	}
+	enforced := make([]PolicyMatch, 0, len(d.Matches))
+	for _, match := range d.Matches {
+		if !match.DryRun {
+			enforced = append(enforced, match)
+		}
+	}
+	return enforced
+}
+
+// PolicyHitStats counts how often a policy matched since process start.
+type PolicyHitStats struct {
+	Policy      string `json:"policy"`
+	Hits        uint64 `json:"hits"`
+	DryRunHits  uint64 `json:"dry_run_hits"`
+	LastHitUnix int64  `json:"last_hit_unix"`
+}
+
+type policyCounter struct {
+	hits       uint64
+	dryRunHits uint64
+	lastHit    int64
+}
+
+var (
+	policyCounters   sync.Map // policy name -> *policyCounter
+	policyRegexCache sync.Map // pattern -> *regexp.Regexp (nil when invalid)
+	throttleOnce     sync.Once
+	throttleMonitor  *RPMMonitor
+)
+
+// DefaultPolicies reproduce the historical hardcoded detector behaviour and are
+// used whenever no policies are configured.
+func DefaultPolicies() []cfg.GovernancePolicy {
+	return []cfg.GovernancePolicy{
+		{
+			Name:       "high_rpm",
+			Enabled:    true,
+			Priority:   100,
+			Conditions: []cfg.GovernanceCondition{{Field: "detector.high_rpm", Operator: "eq", Value: true}},
+			Action:     cfg.GovernanceAction{Type: cfg.GovernanceActionReroute, Severity: SeverityMalicious, Reason: "abuse"},
+		},
+		{
+			Name:       "prompt_sanity",
+			Enabled:    true,
+			Priority:   90,
+			Conditions: []cfg.GovernanceCondition{{Field: "detector.prompt_sanity", Operator: "eq", Value: true}},
+			Action:     cfg.GovernanceAction{Type: cfg.GovernanceActionReroute, Severity: SeverityViolation, Reason: "violation"},
+		},
+		{
+			Name:       "keyword_policy",
+			Enabled:    true,
+			Priority:   90,
+			Conditions: []cfg.GovernanceCondition{{Field: "detector.keyword_policy", Operator: "eq", Value: true}},
+			Action:     cfg.GovernanceAction{Type: cfg.GovernanceActionReroute, Severity: SeverityViolation, Reason: "violation"},
+		},
+	}
+}
+
+// ActivePolicies returns the configured policies, or the defaults when none are
+// configured, sorted by descending priority. Config changes apply immediately.
+func ActivePolicies() []cfg.GovernancePolicy {
+	gc := cfg.GetGovernanceConfig()
+	var policies []cfg.GovernancePolicy
+	if gc != nil && len(gc.Policies) > 0 {
+		policies = append(policies, gc.Policies...)
+	} else {
+		policies = DefaultPolicies()
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		return policies[i].Priority > policies[j].Priority
+	})
+	return policies
+}
+
+// RunDetectors evaluates the built-in detectors whose results policies can
+// reference as detector.<name>. The RPM detector always records the request.
+func RunDetectors(subjectKey string, prompt string, now time.Time) map[string]DetectorResult {
+	detectors := map[string]DetectorResult{
+		"high_rpm": DetectHighRPM(subjectKey, now),
+	}
+	if trimmed := strings.TrimSpace(prompt); trimmed != "" {
+		detectors["prompt_sanity"] = DetectPromptSanity(trimmed)
+		detectors["keyword_policy"] = DetectKeywordPolicy(trimmed)
+	}
+	return detectors
+}
+
+// EvaluatePolicies runs every active policy against the facts and records hit
+// counters. Throttle budgets are consumed even in dry-run mode so the reported
+// hits match what enforcement would do.
+func EvaluatePolicies(facts *PolicyFacts, now time.Time) *PolicyDecision {
+	return evaluatePolicies(ActivePolicies(), facts, now, true)
+}
+
+// SimulatePolicies evaluates the given policies without touching hit counters
+// or throttle budgets. Throttle policies are reported whenever their
+// conditions match.
+func SimulatePolicies(policies []cfg.GovernancePolicy, facts *PolicyFacts) *PolicyDecision {
+	sorted := append([]cfg.GovernancePolicy(nil), policies...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+	return evaluatePolicies(sorted, facts, time.Now(), false)
+}
+
+func evaluatePolicies(policies []cfg.GovernancePolicy, facts *PolicyFacts, now time.Time, record bool) *PolicyDecision {
+	decision := &PolicyDecision{}
+	if facts == nil {
+		return decision
+	}
+	globalDryRun := false
+	if gc := cfg.GetGovernanceConfig(); gc != nil {
+		globalDryRun = gc.DryRun
+	}
+	for _, policy := range policies {
+		if !policy.Enabled || !policyMatches(policy, facts) {
+			continue
+		}
+		match := PolicyMatch{
+			Policy:       policy.Name,
+			Action:       policy.Action.Type,
+			Severity:     policy.Action.Severity,
+			Reason:       policy.Action.Reason,
+			RerouteAlias: policy.Action.RerouteAlias,
+			Message:      policy.Action.Message,
+			DryRun:       globalDryRun || policy.DryRun,
+		}
+		if match.Severity == "" {
+			match.Severity = SeverityViolation
+		}
+		if match.Reason == "" {
+			match.Reason = policy.Name
+		}
+		if match.Action == cfg.GovernanceActionThrottle && record {
+			limit := policy.Action.ThrottleRPM
+			count := getThrottleMonitor().Record(policy.Name+"|"+facts.SubjectKey, now)
+			if limit <= 0 || count <= limit {
+				continue
+			}
+			match.Throttled = true
+		}
+		if record {
+			recordPolicyHit(policy.Name, match.DryRun, now)
+		}
+		decision.Matches = append(decision.Matches, match)
+		if policy.Stop {
+			break
+		}
+	}
+	return decision
+}
+
+// ValidatePolicies reports the first configuration error in a policy list.
+func ValidatePolicies(policies []cfg.GovernancePolicy) error {
+	seen := make(map[string]struct{}, len(policies))
+	for i, policy := range policies {
+		name := strings.TrimSpace(policy.Name)
+		if name == "" {
+			return fmt.Errorf("policy %d: name is required", i)
+		}
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("policy %s: duplicate name", name)
+		}
+		seen[name] = struct{}{}
+		if policy.Match != "" && policy.Match != policyMatchAll && policy.Match != policyMatchAny {
+			return fmt.Errorf("policy %s: match must be all or any", name)
+		}
+		switch policy.Action.Type {
+		case cfg.GovernanceActionFlag, cfg.GovernanceActionReroute, cfg.GovernanceActionBlock, cfg.GovernanceActionRequire2FA:
+		case cfg.GovernanceActionThrottle:
+			if policy.Action.ThrottleRPM <= 0 {
+				return fmt.Errorf("policy %s: throttle_rpm must be positive", name)
+			}
+		default:
+			return fmt.Errorf("policy %s: unknown action %q", name, policy.Action.Type)
+		}
+		switch policy.Action.Severity {
+		case "", SeverityViolation, SeverityMalicious:
+		default:
+			return fmt.Errorf("policy %s: unknown severity %q", name, policy.Action.Severity)
+		}
+		for _, condition := range policy.Conditions {
+			if strings.TrimSpace(condition.Field) == "" {
+				return fmt.Errorf("policy %s: condition field is required", name)
+			}
+			if !isKnownOperator(condition.Operator) {
+				return fmt.Errorf("policy %s: unknown operator %q", name, condition.Operator)
+			}
+			if condition.Operator == "regex" {
+				pattern, _ := condition.Value.(string)
+				if _, err := regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("policy %s: invalid regex: %v", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetPolicyHitStats returns hit counters for every policy that has matched.
+func GetPolicyHitStats() []PolicyHitStats {
+	stats := make([]PolicyHitStats, 0)
+	policyCounters.Range(func(key, value any) bool {
+		counter := value.(*policyCounter)
+		stats = append(stats, PolicyHitStats{
+			Policy:      key.(string),
+			Hits:        atomic.LoadUint64(&counter.hits),
+			DryRunHits:  atomic.LoadUint64(&counter.dryRunHits),
+			LastHitUnix: atomic.LoadInt64(&counter.lastHit),
+		})
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Policy < stats[j].Policy })
+	return stats
+}
+
+// ResetPolicyHitStats clears all hit counters.
+func ResetPolicyHitStats() {
+	policyCounters.Range(func(key, _ any) bool {
+		policyCounters.Delete(key)
+		return true
+	})
+}
+
+func recordPolicyHit(name string, dryRun bool, now time.Time) {
+	value, _ := policyCounters.LoadOrStore(name, &policyCounter{})
+	counter := value.(*policyCounter)
+	if dryRun {
+		atomic.AddUint64(&counter.dryRunHits, 1)
+	} else {
+		atomic.AddUint64(&counter.hits, 1)
+	}
+	atomic.StoreInt64(&counter.lastHit, now.Unix())
+}
+
+func getThrottleMonitor() *RPMMonitor {
+	throttleOnce.Do(func() {
+		throttleMonitor = NewRPMMonitor(time.Minute)
+	})
+	return throttleMonitor
+}
+
+func policyMatches(policy cfg.GovernancePolicy, facts *PolicyFacts) bool {
+	if len(policy.Conditions) == 0 {
+		return true
+	}
+	matchAny := policy.Match == policyMatchAny
+	for _, condition := range policy.Conditions {
+		ok := conditionMatches(condition, facts)
+		if matchAny && ok {
+			return true
+		}
+		if !matchAny && !ok {
+			return false
+		}
+	}
+	return !matchAny
+}
+
+// factValue resolves a condition field to a string, number or bool.
+func factValue(field string, facts *PolicyFacts) (interface{}, bool) {
+	switch field {
+	case "subject_type":
+		return facts.SubjectType, true
+	case "subject_id":
+		return float64(facts.SubjectId), true
+	case "user_id":
+		return float64(facts.UserId), true
+	case "token_id":
+		return float64(facts.TokenId), true
+	case "model":
+		return facts.Model, true
+	case "group":
+		return facts.Group, true
+	case "path":
+		return facts.Path, true
+	case "prompt":
+		return facts.Prompt, true
+	case "prompt_length":
+		return float64(len(facts.Prompt)), true
+	case "prompt_tokens":
+		return float64(facts.PromptTokens), true
+	case "entropy":
+		facts.analyze()
+		return facts.entropy, true
+	case "repetition_ratio":
+		facts.analyze()
+		return facts.repetitionRatio, true
+	case "rpm":
+		facts.analyze()
+		return float64(facts.rpm), true
+	}
+	if strings.HasPrefix(field, "detector.") {
+		parts := strings.SplitN(strings.TrimPrefix(field, "detector."), ".", 2)
+		result, ok := facts.Detectors[parts[0]]
+		if len(parts) == 1 {
+			return ok && result.Triggered, true
+		}
+		if !ok || !result.Triggered {
+			return "", false
+		}
+		switch parts[1] {
+		case "severity":
+			return result.Severity, true
+		case "reason":
+			return strings.Join(result.Reasons, ","), true
+		default:
+			value, ok := result.Metadata[parts[1]]
+			return value, ok
+		}
+	}
+	return nil, false
+}
+
+func isKnownOperator(operator string) bool {
+	switch operator {
+	case "eq", "ne", "in", "not_in", "gt", "gte", "lt", "lte", "contains", "prefix", "regex", "exists":
+		return true
+	}
+	return false
+}
+
+func conditionMatches(condition cfg.GovernanceCondition, facts *PolicyFacts) bool {
+	actual, ok := factValue(condition.Field, facts)
+	if condition.Operator == "exists" {
+		expected, _ := condition.Value.(bool)
+		return ok == expected || (condition.Value == nil && ok)
+	}
+	if !ok {
+		return false
+	}
+	switch condition.Operator {
+	case "eq":
+		return valuesEqual(actual, condition.Value)
+	case "ne":
+		return !valuesEqual(actual, condition.Value)
+	case "in", "not_in":
+		found := false
+		if list, isList := condition.Value.([]interface{}); isList {
+			for _, item := range list {
+				if valuesEqual(actual, item) {
+					found = true
+					break
+				}
+			}
+		}
+		return found == (condition.Operator == "in")
+	case "gt", "gte", "lt", "lte":
+		left, okLeft := toFloat(actual)
+		right, okRight := toFloat(condition.Value)
+		if !okLeft || !okRight {
+			return false
+		}
+		switch condition.Operator {
+		case "gt":
+			return left > right
+		case "gte":
+			return left >= right
+		case "lt":
+			return left < right
+		default:
+			return left <= right
+		}
+	case "contains":
+		return strings.Contains(strings.ToLower(toString(actual)), strings.ToLower(toString(condition.Value)))
+	case "prefix":
+		return strings.HasPrefix(toString(actual), toString(condition.Value))
+	case "regex":
+		re := compilePolicyRegex(toString(condition.Value))
+		return re != nil && re.MatchString(toString(actual))
+	}
+	return false
+}
+
+func compilePolicyRegex(pattern string) *regexp.Regexp {
+	if cached, ok := policyRegexCache.Load(pattern); ok {
+		re, _ := cached.(*regexp.Regexp)
+		return re
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		policyRegexCache.Store(pattern, (*regexp.Regexp)(nil))
+		return nil
+	}
+	policyRegexCache.Store(pattern, re)
+	return re
+}
+
+func valuesEqual(actual interface{}, expected interface{}) bool {
+	if left, ok := actual.(bool); ok {
+		right, ok := expected.(bool)
+		return ok && left == right
+	}
+	if left, ok := toFloat(actual); ok {
+		if _, isString := actual.(string); !isString {
+			right, ok := toFloat(expected)
+			return ok && left == right
+		}
+	}
+	return toString(actual) == toString(expected)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,125 @@
+package governance
+
+import (
+	"testing"
+	"time"
+
+	cfg "github.com/QuantumNous/new-api/setting/config"
+)
+
+func withPolicies(t *testing.T, policies []cfg.GovernancePolicy, dryRun bool) {
+	gc := cfg.GetGovernanceConfig()
+	oldPolicies, oldDryRun := gc.Policies, gc.DryRun
+	gc.Policies, gc.DryRun = policies, dryRun
+	ResetPolicyHitStats()
+	t.Cleanup(func() {
+		gc.Policies, gc.DryRun = oldPolicies, oldDryRun
+		ResetPolicyHitStats()
+	})
+}
+
+func TestPolicyConditionsAndPriority(t *testing.T) {
+	withPolicies(t, []cfg.GovernancePolicy{
+		{
+			Name:     "block_gpt4_free",
+			Enabled:  true,
+			Priority: 10,
+			Conditions: []cfg.GovernanceCondition{
+				{Field: "model", Operator: "prefix", Value: "gpt-4"},
+				{Field: "group", Operator: "in", Value: []interface{}{"free", "trial"}},
+			},
+			Action: cfg.GovernanceAction{Type: cfg.GovernanceActionBlock},
+			Stop:   true,
+		},
+		{
+			Name:       "long_prompt",
+			Enabled:    true,
+			Priority:   5,
+			Conditions: []cfg.GovernanceCondition{{Field: "prompt_tokens", Operator: "gt", Value: 1000.0}},
+			Action:     cfg.GovernanceAction{Type: cfg.GovernanceActionFlag},
+		},
+	}, false)
+
+	facts := &PolicyFacts{SubjectKey: "user:1", Model: "gpt-4o", Group: "free", PromptTokens: 5000}
+	decision := EvaluatePolicies(facts, time.Now())
+	if len(decision.Matches) != 1 || decision.Matches[0].Policy != "block_gpt4_free" {
+		t.Fatalf("expected only the stopping block policy to match, got: %+v", decision.Matches)
+	}
+
+	facts = &PolicyFacts{SubjectKey: "user:1", Model: "gpt-4o", Group: "default", PromptTokens: 5000}
+	decision = EvaluatePolicies(facts, time.Now())
+	if len(decision.Matches) != 1 || decision.Matches[0].Policy != "long_prompt" {
+		t.Fatalf("expected long_prompt to match, got: %+v", decision.Matches)
+	}
+
+	stats := GetPolicyHitStats()
+	if len(stats) != 2 || stats[0].Hits != 1 || stats[1].Hits != 1 {
+		t.Fatalf("unexpected hit stats: %+v", stats)
+	}
+}
+
+func TestPolicyDryRunAndThrottle(t *testing.T) {
+	withPolicies(t, []cfg.GovernancePolicy{
+		{
+			Name:    "throttle_all",
+			Enabled: true,
+			Action:  cfg.GovernanceAction{Type: cfg.GovernanceActionThrottle, ThrottleRPM: 2},
+		},
+	}, true)
+
+	now := time.Now()
+	facts := &PolicyFacts{SubjectKey: "token:7"}
+	for i := 0; i < 2; i++ {
+		if decision := EvaluatePolicies(facts, now); len(decision.Matches) != 0 {
+			t.Fatalf("expected no match within throttle budget, got: %+v", decision.Matches)
+		}
+	}
+	decision := EvaluatePolicies(facts, now)
+	if len(decision.Matches) != 1 || !decision.Matches[0].Throttled || !decision.Matches[0].DryRun {
+		t.Fatalf("expected a dry-run throttle match, got: %+v", decision.Matches)
+	}
+	if len(decision.Enforced()) != 0 {
+		t.Fatalf("dry-run matches must not be enforced")
+	}
+	stats := GetPolicyHitStats()
+	if len(stats) != 1 || stats[0].DryRunHits != 1 || stats[0].Hits != 0 {
+		t.Fatalf("unexpected hit stats: %+v", stats)
+	}
+}
+
+func TestDefaultPoliciesFollowDetectors(t *testing.T) {
+	withPolicies(t, nil, false)
+
+	facts := &PolicyFacts{
+		SubjectKey: "user:3",
+		Detectors: map[string]DetectorResult{
+			"high_rpm": {Triggered: true, Severity: SeverityMalicious, Metadata: map[string]string{"count": "5000"}},
+		},
+	}
+	decision := SimulatePolicies(ActivePolicies(), facts)
+	if len(decision.Matches) != 1 || decision.Matches[0].Severity != SeverityMalicious {
+		t.Fatalf("expected the default high_rpm policy to match, got: %+v", decision.Matches)
+	}
+	if len(GetPolicyHitStats()) != 0 {
+		t.Fatalf("simulation must not record hits")
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	bad := []cfg.GovernancePolicy{
+		{Name: "x", Action: cfg.GovernanceAction{Type: cfg.GovernanceActionThrottle}},
+	}
+	if err := ValidatePolicies(bad); err == nil {
+		t.Fatalf("expected throttle without throttle_rpm to be rejected")
+	}
+	bad = []cfg.GovernancePolicy{
+		{Name: "x", Action: cfg.GovernanceAction{Type: cfg.GovernanceActionFlag},
+			Conditions: []cfg.GovernanceCondition{{Field: "prompt", Operator: "regex", Value: "("}}},
+	}
+	if err := ValidatePolicies(bad); err == nil {
+		t.Fatalf("expected invalid regex to be rejected")
+	}
+	if err := ValidatePolicies(DefaultPolicies()); err != nil {
+		t.Fatalf("default policies must validate: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	cfg "github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/shopspring/decimal"
+)
+
+// StatementMonth returns the bounds [start, end) of the UTC month of t and
+// its reference, as 2006-01.
+func StatementMonth(t time.Time) (int64, int64, string) {
+	start := time.Date(t.UTC().Year(), t.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Unix(), start.AddDate(0, 1, 0).Unix(), start.Format("2006-01")
+}
+
+func invoiceIssuer(conf *cfg.InvoiceConfig) model.InvoiceParty {
+	name := conf.CompanyName
+	if name == "" {
+		name = common.SystemName
+	}
+	return model.InvoiceParty{
+		Name:    name,
+		Address: conf.CompanyAddress,
+		TaxId:   conf.CompanyTaxId,
+		Email:   conf.CompanyEmail,
+	}
+}
+
+func invoiceCustomer(user *model.User) model.InvoiceParty {
+	name := user.DisplayName
+	if name == "" {
+		name = user.Username
+	}
+	return model.InvoiceParty{Name: name, Email: user.Email}
+}
+
+// invoicePriceUnknown marks statement lines of orders whose price paid was
+// not stored; their amount is left at 0.
+const invoicePriceUnknown = " - price not recorded"
+
+// GenerateTopUpInvoice issues the invoice of a paid order, or returns the
+// one already issued. The price paid includes tax at the configured rate.
+func GenerateTopUpInvoice(topUp *model.TopUp) (*model.Invoice, error) {
+	conf := cfg.GetInvoiceConfig()
+	if !conf.Enabled {
+		return nil, errors.New("发票功能未启用")
+	}
+	if topUp.CompleteTime == 0 || topUp.Status == common.TopUpStatusPending ||
+		topUp.Status == common.TopUpStatusExpired || topUp.Status == common.TopUpStatusFailed {
+		return nil, errors.New("订单未支付，无法开具发票")
+	}
+	if existing, err := model.GetInvoiceByReference(topUp.UserId, model.InvoiceKindTopUp, topUp.TradeNo); err == nil {
+		return existing, nil
+	}
+	if !topUp.PaidMoneyKnown() {
+		return nil, errors.New("订单未记录支付金额，无法开具发票")
+	}
+	user, err := model.GetUserById(topUp.UserId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := conf.CurrencyOf(topUp.TopUpProvider())
+	total := decimal.NewFromFloat(topUp.PaidMoney()).Round(2)
+	tax := decimal.Zero
+	if conf.TaxRate > 0 {
+		rate := decimal.NewFromFloat(conf.TaxRate)
+		tax = total.Mul(rate).Div(rate.Add(decimal.NewFromInt(100))).Round(2)
+	}
+	content := &model.InvoiceContent{
+		Issuer:   invoiceIssuer(conf),
+		Customer: invoiceCustomer(user),
+		TaxName:  conf.TaxName,
+		TaxRate:  conf.TaxRate,
+		Lines: []model.InvoiceLine{{
+			Time:        topUp.CompleteTime,
+			Description: fmt.Sprintf("Account top-up (%s)", topUp.TopUpProvider()),
+			Reference:   topUp.TradeNo,
+			Quota:       topUp.CreditQuota(),
+			Currency:    currency,
+			Amount:      total.InexactFloat64(),
+		}},
+		QuotaPerUnit: common.QuotaPerUnit,
+		Footer:       conf.Footer,
+	}
+	invoice := &model.Invoice{
+		UserId:      topUp.UserId,
+		Kind:        model.InvoiceKindTopUp,
+		Reference:   topUp.TradeNo,
+		PeriodStart: topUp.CompleteTime,
+		PeriodEnd:   topUp.CompleteTime,
+		Currency:    currency,
+		Subtotal:    total.Sub(tax).InexactFloat64(),
+		Tax:         tax.InexactFloat64(),
+		Total:       total.InexactFloat64(),
+		Quota:       topUp.CreditQuota(),
+	}
+	if err := invoice.SetContent(content); err != nil {
+		return nil, err
+	}
+	return createInvoice(invoice, conf.InvoicePrefix, user)
+}
+
+// GenerateMonthlyStatement issues a user's statement of the UTC month of
+// month, or returns the one already issued. The month must be over.
+func GenerateMonthlyStatement(userId int, month time.Time) (*model.Invoice, error) {
+	conf := cfg.GetInvoiceConfig()
+	if !conf.Enabled {
+		return nil, errors.New("发票功能未启用")
+	}
+	start, end, reference := StatementMonth(month)
+	if end > common.GetTimestamp() {
+		return nil, errors.New("只能为已结束的月份生成账单")
+	}
+	if existing, err := model.GetInvoiceByReference(userId, model.InvoiceKindStatement, reference); err == nil {
+		return existing, nil
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &model.StatementUsage{}
+	for by, target := range map[string]*[]model.UsageBreakdown{"model": &usage.ByModel, "token": &usage.ByToken, "group": &usage.ByGroup} {
+		breakdown, err := model.GetUserConsumeBreakdown(userId, start, end, by)
+		if err != nil {
+			return nil, err
+		}
+		*target = breakdown
+	}
+	var consumed int64
+	for _, line := range usage.ByModel {
+		consumed += line.Quota
+	}
+
+	lines, err := statementPaymentLines(conf, userId, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	total := decimal.NewFromInt(consumed).Div(decimal.NewFromFloat(common.QuotaPerUnit)).Round(2).InexactFloat64()
+	content := &model.InvoiceContent{
+		Issuer:       invoiceIssuer(conf),
+		Customer:     invoiceCustomer(user),
+		Lines:        lines,
+		Usage:        usage,
+		QuotaPerUnit: common.QuotaPerUnit,
+		Footer:       conf.Footer,
+	}
+	invoice := &model.Invoice{
+		UserId:      userId,
+		Kind:        model.InvoiceKindStatement,
+		Reference:   reference,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Currency:    "USD",
+		Subtotal:    total,
+		Total:       total,
+		Quota:       consumed,
+	}
+	if err := invoice.SetContent(content); err != nil {
+		return nil, err
+	}
+	return createInvoice(invoice, conf.StatementPrefix, user)
+}
+
+// statementPaymentLines lists the payments and refunds of a user made in
+// [start, end), in money paid.
+func statementPaymentLines(conf *cfg.InvoiceConfig, userId int, start int64, end int64) ([]model.InvoiceLine, error) {
+	var lines []model.InvoiceLine
+	topUps, err := model.GetUserPaidTopUps(userId, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, topUp := range topUps {
+		line := model.InvoiceLine{
+			Time:        topUp.CompleteTime,
+			Description: fmt.Sprintf("Top-up (%s)", topUp.TopUpProvider()),
+			Reference:   topUp.TradeNo,
+			Quota:       topUp.CreditQuota(),
+			Currency:    conf.CurrencyOf(topUp.TopUpProvider()),
+		}
+		if topUp.PaidMoneyKnown() {
+			line.Amount = decimal.NewFromFloat(topUp.PaidMoney()).Round(2).InexactFloat64()
+		} else {
+			line.Description += invoicePriceUnknown
+		}
+		lines = append(lines, line)
+	}
+	refunds, err := model.GetUserRefunds(userId, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, refund := range refunds {
+		provider := ""
+		currency := conf.Currency
+		known := true
+		if topUp := model.GetTopUpByTradeNo(refund.TradeNo); topUp != nil {
+			provider = topUp.TopUpProvider()
+			currency = conf.CurrencyOf(provider)
+			known = topUp.PaidMoneyKnown()
+		}
+		line := model.InvoiceLine{
+			Time:        refund.CreatedTime,
+			Description: fmt.Sprintf("Refund (%s)", provider),
+			Reference:   refund.TradeNo,
+			Quota:       -refund.Quota,
+			Currency:    currency,
+		}
+		// refunds are recorded in money paid, like the top-up lines
+		if known {
+			line.Amount = -decimal.NewFromFloat(refund.Money).Round(2).InexactFloat64()
+		} else {
+			line.Description += invoicePriceUnknown
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func createInvoice(invoice *model.Invoice, prefix string, user *model.User) (*model.Invoice, error) {
+	created, err := model.CreateInvoice(invoice, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if created == invoice && cfg.GetInvoiceConfig().EmailDelivery {
+		if err := EmailInvoice(created, user); err != nil {
+			common.SysLog(fmt.Sprintf("failed to email invoice %s: %s", created.Number, err.Error()))
+		}
+	}
+	return created, nil
+}
+
+// EmailInvoice mails the user a summary of a document and where to
+// download it, to the notification email if set.
+func EmailInvoice(invoice *model.Invoice, user *model.User) error {
+	email := user.GetSetting().NotificationEmail
+	if email == "" {
+		email = user.Email
+	}
+	if email == "" {
+		return errors.New("用户未设置邮箱")
+	}
+	title := "发票"
+	summary := fmt.Sprintf("支付金额：%.2f %s", invoice.Total, invoice.Currency)
+	if invoice.Kind == model.InvoiceKindStatement {
+		title = "月度账单"
+		summary = fmt.Sprintf("账单月份：%s，消费额度：%d（约 %.2f USD）", invoice.Reference, invoice.Quota, invoice.Total)
+	}
+	link := fmt.Sprintf("%s/api/user/self/invoices/%d/download?format=pdf", system_setting.ServerAddress, invoice.Id)
+	subject := fmt.Sprintf("%s %s %s", common.SystemName, title, invoice.Number)
+	content := fmt.Sprintf("<p>您好，%s %s 已生成。</p><p>%s</p><p>登录后可在此下载 PDF：<a href=\"%s\">%s</a>，或在控制台下载 CSV。</p>",
+		title, html.EscapeString(invoice.Number), html.EscapeString(summary), link, link)
+	if err := common.SendEmail(subject, email, content); err != nil {
+		return err
+	}
+	return model.MarkInvoiceEmailed(invoice.Id)
+}
+
+// statementsIssuedMonth is the last month all statements were issued for.
+var statementsIssuedMonth string
+
+// GenerateMonthlyStatements issues the statements of the month before now
+// for every user who consumed or paid in it, and returns how many it issued.
+// Once all are issued, the month is not queried again.
+func GenerateMonthlyStatements(now time.Time) (int, error) {
+	if !cfg.GetInvoiceConfig().Enabled {
+		return 0, nil
+	}
+	currentStart, _, _ := StatementMonth(now)
+	start, end, reference := StatementMonth(time.Unix(currentStart-1, 0))
+	if statementsIssuedMonth == reference {
+		return 0, nil
+	}
+	userIds, err := model.GetStatementUserIds(start, end)
+	if err != nil {
+		return 0, err
+	}
+	issued := 0
+	failed := false
+	for _, userId := range userIds {
+		if _, err := model.GetInvoiceByReference(userId, model.InvoiceKindStatement, reference); err == nil {
+			continue
+		}
+		if _, err := GenerateMonthlyStatement(userId, time.Unix(start, 0)); err != nil {
+			common.SysLog(fmt.Sprintf("failed to generate statement %s for user %d: %s", reference, userId, err.Error()))
+			failed = true
+			continue
+		}
+		issued++
+	}
+	if !failed {
+		statementsIssuedMonth = reference
+	}
+	return issued, nil
+}
@@ -0,0 +1,308 @@
+// Package invoice renders invoices and monthly statements to PDF and CSV.
+package invoice
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+)
+
+const (
+	FormatPDF = "pdf"
+	FormatCSV = "csv"
+)
+
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 50.0
+	lineHeight = 14.0
+)
+
+// FileName is the download name of a document.
+func FileName(invoice *model.Invoice, format string) string {
+	return invoice.Number + "." + format
+}
+
+// ContentType is the MIME type of a format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/pdf"
+}
+
+// Render renders a document in format, pdf or csv.
+func Render(invoice *model.Invoice, format string) ([]byte, error) {
+	content, err := invoice.GetContent()
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatPDF:
+		return renderPDF(invoice, content), nil
+	case FormatCSV:
+		return renderCSV(invoice, content)
+	}
+	return nil, fmt.Errorf("unsupported format %s", format)
+}
+
+func formatDate(timestamp int64) string {
+	return time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+}
+
+func formatMoney(amount float64, currency string) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64) + " " + currency
+}
+
+// usageUSD converts quota to USD at the rate of the document.
+func usageUSD(quota int64, content *model.InvoiceContent) float64 {
+	if content.QuotaPerUnit <= 0 {
+		return 0
+	}
+	return float64(quota) / content.QuotaPerUnit
+}
+
+// truncate shortens s to n characters so it fits its column.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// pdfLayout prints rows top to bottom, starting new pages as needed.
+type pdfLayout struct {
+	doc *pdfDocument
+	y   float64
+}
+
+func (l *pdfLayout) need(height float64) {
+	if l.y-height < margin {
+		l.doc.addPage()
+		l.y = pageHeight - margin
+	}
+}
+
+func (l *pdfLayout) skip(height float64) {
+	l.y -= height
+}
+
+// pdfCell is a cell of a row; right aligned cells end at x.
+type pdfCell struct {
+	x     float64
+	text  string
+	right bool
+}
+
+func (l *pdfLayout) row(size float64, bold bool, cells ...pdfCell) {
+	l.need(lineHeight)
+	l.y -= lineHeight
+	for _, cell := range cells {
+		if cell.right {
+			l.doc.textRight(cell.x, l.y, size, bold, cell.text)
+		} else {
+			l.doc.text(cell.x, l.y, size, bold, cell.text)
+		}
+	}
+}
+
+func (l *pdfLayout) rule() {
+	l.need(6)
+	l.y -= 4
+	l.doc.line(margin, l.y, pageWidth-margin, l.y)
+	l.y -= 2
+}
+
+func (l *pdfLayout) party(title string, party model.InvoiceParty) {
+	l.row(9, true, pdfCell{x: margin, text: title})
+	l.row(10, false, pdfCell{x: margin, text: party.Name})
+	for _, line := range strings.Split(party.Address, "\n") {
+		if line != "" {
+			l.row(10, false, pdfCell{x: margin, text: line})
+		}
+	}
+	if party.TaxId != "" {
+		l.row(10, false, pdfCell{x: margin, text: "Tax ID: " + party.TaxId})
+	}
+	if party.Email != "" {
+		l.row(10, false, pdfCell{x: margin, text: party.Email})
+	}
+}
+
+func renderPDF(invoice *model.Invoice, content *model.InvoiceContent) []byte {
+	doc := newPDFDocument(pageWidth, pageHeight)
+	l := &pdfLayout{doc: doc}
+	l.need(pageHeight)
+	right := pageWidth - margin
+
+	title := "INVOICE"
+	if invoice.Kind == model.InvoiceKindStatement {
+		title = "STATEMENT"
+	}
+	l.y -= 10
+	doc.text(margin, l.y, 20, true, title)
+	doc.textRight(right, l.y, 10, true, invoice.Number)
+	l.row(10, false, pdfCell{x: right, text: "Issued " + formatDate(invoice.CreatedTime), right: true})
+	if invoice.Kind == model.InvoiceKindStatement {
+		l.row(10, false, pdfCell{x: right, text: "Period " + formatDate(invoice.PeriodStart) + " - " + formatDate(invoice.PeriodEnd-1), right: true})
+	}
+	l.skip(lineHeight)
+	l.party("FROM", content.Issuer)
+	l.skip(lineHeight / 2)
+	l.party("BILL TO", content.Customer)
+	l.skip(lineHeight)
+
+	if invoice.Kind == model.InvoiceKindTopUp {
+		renderTopUpPDF(l, invoice, content)
+	} else {
+		renderStatementPDF(l, invoice, content)
+	}
+
+	if content.Footer != "" {
+		l.skip(lineHeight)
+		for _, line := range strings.Split(content.Footer, "\n") {
+			l.row(8, false, pdfCell{x: margin, text: line})
+		}
+	}
+	return doc.bytes()
+}
+
+func renderTopUpPDF(l *pdfLayout, invoice *model.Invoice, content *model.InvoiceContent) {
+	right := pageWidth - margin
+	l.row(9, true,
+		pdfCell{x: margin, text: "Description"},
+		pdfCell{x: 250, text: "Reference"},
+		pdfCell{x: 440, text: "Quota", right: true},
+		pdfCell{x: right, text: "Amount", right: true})
+	l.rule()
+	for _, line := range content.Lines {
+		l.row(10, false,
+			pdfCell{x: margin, text: truncate(line.Description, 36)},
+			pdfCell{x: 250, text: truncate(line.Reference, 28)},
+			pdfCell{x: 440, text: strconv.FormatInt(line.Quota, 10), right: true},
+			pdfCell{x: right, text: formatMoney(line.Amount, line.Currency), right: true})
+	}
+	l.rule()
+	if content.TaxRate > 0 {
+		l.row(10, false, pdfCell{x: 440, text: "Subtotal", right: true}, pdfCell{x: right, text: formatMoney(invoice.Subtotal, invoice.Currency), right: true})
+		taxLabel := fmt.Sprintf("%s %s%%", content.TaxName, strconv.FormatFloat(content.TaxRate, 'f', -1, 64))
+		l.row(10, false, pdfCell{x: 440, text: taxLabel, right: true}, pdfCell{x: right, text: formatMoney(invoice.Tax, invoice.Currency), right: true})
+	}
+	l.row(11, true, pdfCell{x: 440, text: "Total paid", right: true}, pdfCell{x: right, text: formatMoney(invoice.Total, invoice.Currency), right: true})
+}
+
+func renderStatementPDF(l *pdfLayout, invoice *model.Invoice, content *model.InvoiceContent) {
+	right := pageWidth - margin
+	l.row(12, true, pdfCell{x: margin, text: "Payments"})
+	if len(content.Lines) == 0 {
+		l.row(10, false, pdfCell{x: margin, text: "No payments in this period."})
+	} else {
+		l.row(9, true,
+			pdfCell{x: margin, text: "Date"},
+			pdfCell{x: 120, text: "Description"},
+			pdfCell{x: 280, text: "Reference"},
+			pdfCell{x: right, text: "Amount", right: true})
+		l.rule()
+		for _, line := range content.Lines {
+			l.row(10, false,
+				pdfCell{x: margin, text: formatDate(line.Time)},
+				pdfCell{x: 120, text: truncate(line.Description, 28)},
+				pdfCell{x: 280, text: truncate(line.Reference, 28)},
+				pdfCell{x: right, text: formatMoney(line.Amount, line.Currency), right: true})
+		}
+	}
+	l.skip(lineHeight)
+
+	usage := content.Usage
+	if usage == nil {
+		usage = &model.StatementUsage{}
+	}
+	for _, section := range []struct {
+		title string
+		lines []model.UsageBreakdown
+	}{
+		{"Usage by model", usage.ByModel},
+		{"Usage by token", usage.ByToken},
+		{"Usage by group", usage.ByGroup},
+	} {
+		l.need(4 * lineHeight)
+		l.row(12, true, pdfCell{x: margin, text: section.title})
+		l.row(9, true,
+			pdfCell{x: margin, text: "Name"},
+			pdfCell{x: 300, text: "Requests", right: true},
+			pdfCell{x: 380, text: "Tokens", right: true},
+			pdfCell{x: 460, text: "Quota", right: true},
+			pdfCell{x: right, text: "USD", right: true})
+		l.rule()
+		for _, line := range section.lines {
+			name := line.Name
+			if name == "" {
+				name = "(none)"
+			}
+			l.row(10, false,
+				pdfCell{x: margin, text: truncate(name, 34)},
+				pdfCell{x: 300, text: strconv.FormatInt(line.Requests, 10), right: true},
+				pdfCell{x: 380, text: strconv.FormatInt(line.PromptTokens+line.CompletionTokens, 10), right: true},
+				pdfCell{x: 460, text: strconv.FormatInt(line.Quota, 10), right: true},
+				pdfCell{x: right, text: strconv.FormatFloat(usageUSD(line.Quota, content), 'f', 4, 64), right: true})
+		}
+		l.skip(lineHeight / 2)
+	}
+	l.rule()
+	l.row(11, true,
+		pdfCell{x: 380, text: "Total consumed", right: true},
+		pdfCell{x: 460, text: strconv.FormatInt(invoice.Quota, 10), right: true},
+		pdfCell{x: right, text: formatMoney(invoice.Total, invoice.Currency), right: true})
+}
+
+// renderCSV writes one table: a row per payment and per usage breakdown,
+// then the totals.
+func renderCSV(invoice *model.Invoice, content *model.InvoiceContent) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{
+		{"number", "section", "date", "description", "reference", "requests", "prompt_tokens", "completion_tokens", "quota", "currency", "amount"},
+	}
+	for _, line := range content.Lines {
+		section := "payment"
+		if line.Amount < 0 {
+			section = "refund"
+		}
+		rows = append(rows, []string{invoice.Number, section, formatDate(line.Time), line.Description, line.Reference, "", "", "",
+			strconv.FormatInt(line.Quota, 10), line.Currency, strconv.FormatFloat(line.Amount, 'f', 2, 64)})
+	}
+	if content.Usage != nil {
+		for _, section := range []struct {
+			name  string
+			lines []model.UsageBreakdown
+		}{
+			{"model", content.Usage.ByModel},
+			{"token", content.Usage.ByToken},
+			{"group", content.Usage.ByGroup},
+		} {
+			for _, line := range section.lines {
+				rows = append(rows, []string{invoice.Number, section.name, "", line.Name, "",
+					strconv.FormatInt(line.Requests, 10), strconv.FormatInt(line.PromptTokens, 10), strconv.FormatInt(line.CompletionTokens, 10),
+					strconv.FormatInt(line.Quota, 10), "USD", strconv.FormatFloat(usageUSD(line.Quota, content), 'f', 4, 64)})
+			}
+		}
+	}
+	if content.TaxRate > 0 {
+		rows = append(rows,
+			[]string{invoice.Number, "subtotal", formatDate(invoice.CreatedTime), "", "", "", "", "", "", invoice.Currency, strconv.FormatFloat(invoice.Subtotal, 'f', 2, 64)},
+			[]string{invoice.Number, "tax", formatDate(invoice.CreatedTime), content.TaxName, strconv.FormatFloat(content.TaxRate, 'f', -1, 64) + "%", "", "", "", "", invoice.Currency, strconv.FormatFloat(invoice.Tax, 'f', 2, 64)})
+	}
+	rows = append(rows, []string{invoice.Number, "total", formatDate(invoice.CreatedTime), "", "", "", "", "",
+		strconv.FormatInt(invoice.Quota, 10), invoice.Currency, strconv.FormatFloat(invoice.Total, 'f', 2, 64)})
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,148 @@
+package invoice
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+)
+
+func newTestInvoice(t *testing.T, kind string, content *model.InvoiceContent) *model.Invoice {
+	t.Helper()
+	invoice := &model.Invoice{
+		Id:          7,
+		Number:      "INV-202508-000007",
+		UserId:      1,
+		Kind:        kind,
+		Reference:   "USR1NOabc",
+		PeriodStart: 1754006400,
+		PeriodEnd:   1756684800,
+		Currency:    "EUR",
+		Subtotal:    10,
+		Tax:         2.1,
+		Total:       12.1,
+		Quota:       5000000,
+		CreatedTime: 1754006400,
+	}
+	if err := invoice.SetContent(content); err != nil {
+		t.Fatalf("set content: %v", err)
+	}
+	return invoice
+}
+
+// checkPDF verifies the header, trailer and that every xref offset points
+// at its object.
+func checkPDF(t *testing.T, data []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		want := fmt.Sprintf("%d 0 obj\n", i+1)
+		if !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Fatalf("xref entry %d points at %q", i+1, data[offset:offset+10])
+		}
+	}
+	pages := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(data)
+	count, _ := strconv.Atoi(string(pages[1]))
+	return count
+}
+
+func TestRenderTopUpPDF(t *testing.T) {
+	invoice := newTestInvoice(t, model.InvoiceKindTopUp, &model.InvoiceContent{
+		Issuer:   model.InvoiceParty{Name: "Acme (Europe) GmbH", Address: "Hauptstraße 1\nBerlin", TaxId: "DE123"},
+		Customer: model.InvoiceParty{Name: "张三", Email: "a@example.com"},
+		TaxName:  "VAT",
+		TaxRate:  21,
+		Lines:    []model.InvoiceLine{{Time: 1754006400, Description: "Account top-up (stripe)", Reference: "USR1NOabc", Quota: 5000000, Currency: "EUR", Amount: 12.1}},
+	})
+	data, err := Render(invoice, FormatPDF)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if pages := checkPDF(t, data); pages != 1 {
+		t.Fatalf("pages = %d, want 1", pages)
+	}
+	for _, want := range []string{`(Acme \(Europe\) GmbH)`, "Hauptstra\xdfe 1", "(??)", "(VAT 21%)", "(12.10 EUR)"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("PDF does not contain %q", want)
+		}
+	}
+}
+
+func TestRenderStatementPDFPages(t *testing.T) {
+	usage := &model.StatementUsage{}
+	for i := 0; i < 80; i++ {
+		usage.ByModel = append(usage.ByModel, model.UsageBreakdown{Name: fmt.Sprintf("model-%d", i), Requests: 1, Quota: 500000})
+	}
+	invoice := newTestInvoice(t, model.InvoiceKindStatement, &model.InvoiceContent{
+		Issuer:       model.InvoiceParty{Name: "Acme"},
+		Customer:     model.InvoiceParty{Name: "user"},
+		Usage:        usage,
+		QuotaPerUnit: 500000,
+	})
+	data, err := Render(invoice, FormatPDF)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if pages := checkPDF(t, data); pages < 2 {
+		t.Fatalf("pages = %d, want the usage to span pages", pages)
+	}
+	if !bytes.Contains(data, []byte("(model-79)")) || !bytes.Contains(data, []byte("(1.0000)")) {
+		t.Fatal("PDF is missing usage rows")
+	}
+}
+
+func TestRenderStatementCSV(t *testing.T) {
+	invoice := newTestInvoice(t, model.InvoiceKindStatement, &model.InvoiceContent{
+		Lines: []model.InvoiceLine{
+			{Time: 1754006400, Description: "Top-up (epay)", Reference: "T1", Quota: 1000000, Currency: "CNY", Amount: 14.4},
+			{Time: 1754092800, Description: "Refund (epay)", Reference: "T1", Quota: -500000, Currency: "CNY", Amount: -7.2},
+		},
+		Usage: &model.StatementUsage{
+			ByModel: []model.UsageBreakdown{{Name: "gpt-4o", Requests: 3, PromptTokens: 10, CompletionTokens: 20, Quota: 250000}},
+			ByToken: []model.UsageBreakdown{{Name: "default", Requests: 3, Quota: 250000}},
+			ByGroup: []model.UsageBreakdown{{Name: "vip", Requests: 3, Quota: 250000}},
+		},
+		QuotaPerUnit: 500000,
+	})
+	data, err := Render(invoice, FormatCSV)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	var sections []string
+	for _, row := range rows[1:] {
+		sections = append(sections, row[1])
+	}
+	if fmt.Sprint(sections) != "[payment refund model token group total]" {
+		t.Fatalf("sections = %v", sections)
+	}
+	if rows[3][3] != "gpt-4o" || rows[3][10] != "0.5000" || rows[2][10] != "-7.20" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	invoice := newTestInvoice(t, model.InvoiceKindTopUp, &model.InvoiceContent{})
+	if _, err := Render(invoice, "xlsx"); err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+}
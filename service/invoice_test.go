@@ -0,0 +1,81 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	cfg "github.com/QuantumNous/new-api/setting/config"
+)
+
+func setupInvoiceTest(t *testing.T) *model.User {
+	t.Helper()
+	db := setupServiceTestDB(t)
+	if err := db.AutoMigrate(&model.TopUp{}, &model.PaymentRefund{}, &model.Invoice{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	conf := cfg.GetInvoiceConfig()
+	oldConf := *conf
+	conf.Enabled = true
+	conf.EmailDelivery = false
+	t.Cleanup(func() {
+		*conf = oldConf
+	})
+	user := &model.User{Username: "invoice", AffCode: "invoice"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func TestStatementLinesInMoneyPaid(t *testing.T) {
+	user := setupInvoiceTest(t)
+	month := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	paidAt := month.Add(24 * time.Hour).Unix()
+	// Stripe stores the quota bought in Money and the price in PayMoney
+	discounted := &model.TopUp{UserId: user.Id, TradeNo: "T1", Provider: "stripe", PaymentMethod: "stripe", Money: 10, PayMoney: 7,
+		Status: common.TopUpStatusPartialRefunded, CompleteTime: paidAt}
+	legacy := &model.TopUp{UserId: user.Id, TradeNo: "T2", PaymentMethod: "stripe", Money: 20,
+		Status: common.TopUpStatusSuccess, CompleteTime: paidAt}
+	for _, topUp := range []*model.TopUp{discounted, legacy} {
+		if err := model.DB.Create(topUp).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := model.DB.Create(&model.PaymentRefund{TopUpId: discounted.Id, TradeNo: "T1", UserId: user.Id, Money: 3.5,
+		Quota: int64(5 * common.QuotaPerUnit), CreatedTime: paidAt + 60}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	start, end, _ := StatementMonth(month)
+	lines, err := statementPaymentLines(cfg.GetInvoiceConfig(), user.Id, start, end)
+	if err != nil {
+		t.Fatalf("statement lines: %v", err)
+	}
+	amounts := make(map[string]float64)
+	for _, line := range lines {
+		amounts[line.Description+" "+line.Reference] = line.Amount
+	}
+	want := map[string]float64{
+		"Top-up (stripe) T1": 7,
+		"Refund (stripe) T1": -3.5,
+		"Top-up (stripe)" + invoicePriceUnknown + " T2": 0,
+	}
+	if len(amounts) != len(want) {
+		t.Fatalf("statement lines = %v, want %v", amounts, want)
+	}
+	for key, amount := range want {
+		if got, ok := amounts[key]; !ok || got != amount {
+			t.Fatalf("statement lines = %v, want %v", amounts, want)
+		}
+	}
+
+	if _, err := GenerateTopUpInvoice(legacy); err == nil {
+		t.Fatal("expected no invoice for a Stripe order without a stored price")
+	}
+	invoice, err := GenerateTopUpInvoice(discounted)
+	if err != nil || invoice.Total != 7 {
+		t.Fatalf("invoice = %+v (err %v), want total 7", invoice, err)
+	}
+}
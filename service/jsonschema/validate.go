@@ -0,0 +1,382 @@
+// Package jsonschema validates model output against the JSON Schema subset
+// used by OpenAI structured outputs: types, enum/const, object and array
+// keywords, string and number bounds, combinators and local $ref.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrors bounds the report for badly broken output.
+const maxErrors = 20
+
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) String() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidateJSON parses data and validates it against schema. A malformed
+// schema is returned as err; problems with data are validation errors.
+func ValidateJSON(schema json.RawMessage, data string) ([]ValidationError, error) {
+	var root any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	trimmed := strings.TrimSpace(data)
+	if strings.HasPrefix(trimmed, "```") {
+		return []ValidationError{{Path: "$", Message: "output is wrapped in a code fence instead of being raw JSON"}}, nil
+	}
+	var instance any
+	if err := json.Unmarshal([]byte(trimmed), &instance); err != nil {
+		return []ValidationError{{Path: "$", Message: "output is not valid JSON: " + err.Error()}}, nil
+	}
+	return Validate(root, instance), nil
+}
+
+// Validate checks a decoded instance against a decoded schema.
+func Validate(schema any, instance any) []ValidationError {
+	v := &validator{root: schema}
+	v.validate(schema, instance, "$", 0)
+	return v.errors
+}
+
+type validator struct {
+	root   any
+	errors []ValidationError
+}
+
+func (v *validator) fail(path string, format string, args ...any) {
+	if len(v.errors) < maxErrors {
+		v.errors = append(v.errors, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// check runs a subschema on a scratch validator and reports whether it passed.
+func (v *validator) check(schema any, instance any, path string, depth int) bool {
+	sub := &validator{root: v.root}
+	sub.validate(schema, instance, path, depth)
+	return len(sub.errors) == 0
+}
+
+func (v *validator) validate(schema any, instance any, path string, depth int) {
+	if depth > 64 {
+		v.fail(path, "schema nesting is too deep")
+		return
+	}
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	case map[string]any:
+		v.validateObjectSchema(s, instance, path, depth)
+	}
+}
+
+func (v *validator) validateObjectSchema(s map[string]any, instance any, path string, depth int) {
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%s", err.Error())
+			return
+		}
+		v.validate(target, instance, path, depth+1)
+	}
+
+	if instance == nil {
+		if nullable, _ := s["nullable"].(bool); nullable {
+			return
+		}
+	}
+	if t, ok := s["type"]; ok && !matchesType(t, instance) {
+		v.fail(path, "expected %s, got %s", describeType(t), typeOf(instance))
+		return
+	}
+	if values, ok := s["enum"].([]any); ok {
+		found := false
+		for _, value := range values {
+			if equal(value, instance) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the allowed enum values")
+		}
+	}
+	if value, ok := s["const"]; ok && !equal(value, instance) {
+		v.fail(path, "value does not match const")
+	}
+
+	switch value := instance.(type) {
+	case map[string]any:
+		v.validateObject(s, value, path, depth)
+	case []any:
+		v.validateArray(s, value, path, depth)
+	case string:
+		v.validateString(s, value, path)
+	case float64:
+		v.validateNumber(s, value, path)
+	}
+
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			v.validate(sub, instance, path, depth+1)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.check(sub, instance, path, depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "value does not match any schema in anyOf")
+		}
+	}
+	if one, ok := s["oneOf"].([]any); ok {
+		matches := 0
+		for _, sub := range one {
+			if v.check(sub, instance, path, depth+1) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.fail(path, "value matches %d schemas in oneOf, expected exactly 1", matches)
+		}
+	}
+	if not, ok := s["not"]; ok && v.check(not, instance, path, depth+1) {
+		v.fail(path, "value must not match the \"not\" schema")
+	}
+}
+
+func (v *validator) validateObject(s map[string]any, object map[string]any, path string, depth int) {
+	if required, ok := s["required"].([]any); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := object[key]; !present {
+					v.fail(path, "missing required property %q", key)
+				}
+			}
+		}
+	}
+	if n, ok := number(s["minProperties"]); ok && float64(len(object)) < n {
+		v.fail(path, "expected at least %v properties", n)
+	}
+	if n, ok := number(s["maxProperties"]); ok && float64(len(object)) > n {
+		v.fail(path, "expected at most %v properties", n)
+	}
+	properties, _ := s["properties"].(map[string]any)
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child := path + "." + key
+		if sub, ok := properties[key]; ok {
+			v.validate(sub, object[key], child, depth+1)
+			continue
+		}
+		if additional, ok := s["additionalProperties"]; ok {
+			if allowed, isBool := additional.(bool); isBool {
+				if !allowed {
+					v.fail(child, "additional property is not allowed")
+				}
+				continue
+			}
+			v.validate(additional, object[key], child, depth+1)
+		}
+	}
+}
+
+func (v *validator) validateArray(s map[string]any, array []any, path string, depth int) {
+	if n, ok := number(s["minItems"]); ok && float64(len(array)) < n {
+		v.fail(path, "expected at least %v items, got %d", n, len(array))
+	}
+	if n, ok := number(s["maxItems"]); ok && float64(len(array)) > n {
+		v.fail(path, "expected at most %v items, got %d", n, len(array))
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if equal(array[i], array[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	start := 0
+	if prefix, ok := s["prefixItems"].([]any); ok {
+		for i := 0; i < len(prefix) && i < len(array); i++ {
+			v.validate(prefix[i], array[i], fmt.Sprintf("%s[%d]", path, i), depth+1)
+		}
+		start = len(prefix)
+	}
+	switch items := s["items"].(type) {
+	case []any: // draft-07 tuple form
+		for i := 0; i < len(items) && i < len(array); i++ {
+			v.validate(items[i], array[i], fmt.Sprintf("%s[%d]", path, i), depth+1)
+		}
+	case nil:
+	default:
+		for i := start; i < len(array); i++ {
+			v.validate(items, array[i], fmt.Sprintf("%s[%d]", path, i), depth+1)
+		}
+	}
+}
+
+func (v *validator) validateString(s map[string]any, value string, path string) {
+	length := float64(utf8.RuneCountInString(value))
+	if n, ok := number(s["minLength"]); ok && length < n {
+		v.fail(path, "expected at least %v characters", n)
+	}
+	if n, ok := number(s["maxLength"]); ok && length > n {
+		v.fail(path, "expected at most %v characters", n)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(value) {
+			v.fail(path, "value does not match pattern %q", pattern)
+		}
+	}
+}
+
+func (v *validator) validateNumber(s map[string]any, value float64, path string) {
+	if n, ok := number(s["minimum"]); ok && value < n {
+		v.fail(path, "value %v is less than minimum %v", value, n)
+	}
+	if n, ok := number(s["maximum"]); ok && value > n {
+		v.fail(path, "value %v is greater than maximum %v", value, n)
+	}
+	if n, ok := number(s["exclusiveMinimum"]); ok && value <= n {
+		v.fail(path, "value %v must be greater than %v", value, n)
+	}
+	if n, ok := number(s["exclusiveMaximum"]); ok && value >= n {
+		v.fail(path, "value %v must be less than %v", value, n)
+	}
+	if n, ok := number(s["multipleOf"]); ok && n > 0 {
+		quotient := value / n
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.fail(path, "value %v is not a multiple of %v", value, n)
+		}
+	}
+}
+
+// resolve follows a local JSON pointer such as "#/$defs/item".
+func (v *validator) resolve(ref string) (any, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	current := v.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if current, ok = object[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	return current, nil
+}
+
+func matchesType(t any, instance any) bool {
+	switch value := t.(type) {
+	case string:
+		return isType(value, instance)
+	case []any:
+		for _, name := range value {
+			if s, ok := name.(string); ok && isType(s, instance) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, instance any) bool {
+	switch name {
+	case "object":
+		_, ok := instance.(map[string]any)
+		return ok
+	case "array":
+		_, ok := instance.([]any)
+		return ok
+	case "string":
+		_, ok := instance.(string)
+		return ok
+	case "number":
+		_, ok := instance.(float64)
+		return ok
+	case "integer":
+		value, ok := instance.(float64)
+		return ok && value == math.Trunc(value)
+	case "boolean":
+		_, ok := instance.(bool)
+		return ok
+	case "null":
+		return instance == nil
+	}
+	return true
+}
+
+func describeType(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func typeOf(instance any) string {
+	switch value := instance.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", instance)
+}
+
+func number(value any) (float64, bool) {
+	n, ok := value.(float64)
+	return n, ok
+}
+
+func equal(a any, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,66 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+		"email": {"type": ["string", "null"], "pattern": "^[^@]+@[^@]+$"}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {"tag": {"type": "string", "maxLength": 5}}
+}`
+
+func TestValidateJSON(t *testing.T) {
+	cases := []struct {
+		data   string
+		errors []string
+	}{
+		{`{"name":"Ann","age":30,"role":"admin","tags":["a"],"email":null}`, nil},
+		{`{"name":"Ann"}`, []string{`$: missing required property "age"`}},
+		{`{"name":"Ann","age":1.5}`, []string{"$.age: expected integer, got number"}},
+		{`{"name":"Ann","age":3,"extra":1}`, []string{"$.extra: additional property is not allowed"}},
+		{`{"name":"Ann","age":3,"role":"root"}`, []string{"$.role: value is not one of the allowed enum values"}},
+		{`{"name":"Ann","age":3,"tags":["toolong"]}`, []string{"$.tags[0]: expected at most 5 characters"}},
+		{`{"name":"Ann","age":3,"email":"nope"}`, []string{`$.email: value does not match pattern "^[^@]+@[^@]+$"`}},
+		{"```json\n{}\n```", []string{"$: output is wrapped in a code fence instead of being raw JSON"}},
+	}
+	for _, tc := range cases {
+		errs, err := ValidateJSON(json.RawMessage(personSchema), tc.data)
+		if err != nil {
+			t.Fatalf("ValidateJSON(%s): %v", tc.data, err)
+		}
+		got := make([]string, 0, len(errs))
+		for _, e := range errs {
+			got = append(got, e.String())
+		}
+		if strings.Join(got, "|") != strings.Join(tc.errors, "|") {
+			t.Errorf("ValidateJSON(%s) = %v, want %v", tc.data, got, tc.errors)
+		}
+	}
+	if errs, _ := ValidateJSON(json.RawMessage(personSchema), "not json"); len(errs) != 1 {
+		t.Errorf("invalid JSON should give one error, got %v", errs)
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	schema := `{"anyOf":[{"type":"string"},{"type":"number","minimum":10}],"not":{"const":"x"}}`
+	for data, valid := range map[string]bool{`"a"`: true, `12`: true, `5`: false, `"x"`: false, `true`: false} {
+		errs, err := ValidateJSON(json.RawMessage(schema), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(errs) == 0) != valid {
+			t.Errorf("ValidateJSON(%s) = %v, want valid=%v", data, errs, valid)
+		}
+	}
+}
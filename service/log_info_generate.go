@@ -49,6 +49,9 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if estimated := common.GetContextKeyInt(ctx, constant.ContextKeyEstimatedPromptTokens); estimated > 0 {
+		other["estimated_prompt_tokens"] = estimated
+	}
 
 	isSystemPromptOverwritten := common.GetContextKeyBool(ctx, constant.ContextKeySystemPromptOverride)
 	if isSystemPromptOverwritten {
@@ -64,6 +67,7 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	}
 	other["admin_info"] = adminInfo
 	appendRequestPath(ctx, relayInfo, other)
+	AppendFundingInfo(relayInfo, other)
 	return other
 }
 
@@ -108,5 +112,6 @@ func GenerateMjOtherInfo(relayInfo *relaycommon.RelayInfo, priceData types.PerCa
 		other["user_group_ratio"] = priceData.GroupRatioInfo.GroupSpecialRatio
 	}
 	appendRequestPath(nil, relayInfo, other)
+	AppendFundingInfo(relayInfo, other)
 	return other
 }
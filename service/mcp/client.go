@@ -0,0 +1,239 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSessionExpired is returned by a transport when the server dropped the
+// session; the client re-initializes once and retries.
+var ErrSessionExpired = errors.New("mcp session expired")
+
+type transport interface {
+	// call sends a request and waits for the response with the same id.
+	call(ctx context.Context, request *Request) (*Response, error)
+	notify(ctx context.Context, request *Request) error
+	// setProtocolVersion is called after initialization.
+	setProtocolVersion(version string)
+	close() error
+}
+
+// Client is one logical connection to an MCP server. It initializes lazily
+// and is safe for concurrent use.
+type Client struct {
+	transport  transport
+	clientInfo Implementation
+
+	nextID atomic.Int64
+
+	mu          sync.Mutex
+	initialized bool
+	serverInfo  InitializeResult
+}
+
+func newClient(t transport, clientInfo Implementation) *Client {
+	return &Client{transport: t, clientInfo: clientInfo}
+}
+
+func (c *Client) ensureInitialized(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.initialized {
+		return nil
+	}
+	params, _ := json.Marshal(InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{},
+		ClientInfo:      c.clientInfo,
+	})
+	response, err := c.transport.call(ctx, c.newRequest("initialize", params))
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	var result InitializeResult
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return err
+	}
+	c.transport.setProtocolVersion(result.ProtocolVersion)
+	if err := c.transport.notify(ctx, &Request{JSONRPC: jsonRPCVersion, Method: "notifications/initialized"}); err != nil {
+		return err
+	}
+	c.serverInfo = result
+	c.initialized = true
+	return nil
+}
+
+func (c *Client) newRequest(method string, params json.RawMessage) *Request {
+	id := strconv.FormatInt(c.nextID.Add(1), 10)
+	return &Request{JSONRPC: jsonRPCVersion, ID: json.RawMessage(id), Method: method, Params: params}
+}
+
+// Call sends method with params and returns the raw result.
+func (c *Client) Call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	var raw json.RawMessage
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		raw = data
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.ensureInitialized(ctx); err != nil {
+			return nil, err
+		}
+		response, err := c.transport.call(ctx, c.newRequest(method, raw))
+		if errors.Is(err, ErrSessionExpired) && attempt == 0 {
+			c.mu.Lock()
+			c.initialized = false
+			c.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if response.Error != nil {
+			return nil, response.Error
+		}
+		return response.Result, nil
+	}
+}
+
+// ListTools returns every tool, following pagination cursors.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for page := 0; page < 100; page++ {
+		var params any
+		if cursor != "" {
+			params = map[string]string{"cursor": cursor}
+		}
+		raw, err := c.Call(ctx, "tools/list", params)
+		if err != nil {
+			return nil, err
+		}
+		var result ListToolsResult
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return nil, err
+		}
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" || result.NextCursor == cursor {
+			break
+		}
+		cursor = result.NextCursor
+	}
+	return tools, nil
+}
+
+// CallTool invokes tool and returns the upstream CallToolResult verbatim.
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (json.RawMessage, error) {
+	return c.Call(ctx, "tools/call", CallToolParams{Name: name, Arguments: arguments})
+}
+
+func (c *Client) ServerInfo() InitializeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serverInfo
+}
+
+func (c *Client) Close() error {
+	return c.transport.close()
+}
+
+// pendingCalls routes responses that arrive on a shared stream (SSE, stdio)
+// back to the waiting caller.
+type pendingCalls struct {
+	mu      sync.Mutex
+	waiters map[string]chan *Response
+	err     error
+}
+
+func newPendingCalls() *pendingCalls {
+	return &pendingCalls{waiters: make(map[string]chan *Response)}
+}
+
+func (p *pendingCalls) add(id json.RawMessage) (chan *Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	ch := make(chan *Response, 1)
+	p.waiters[string(id)] = ch
+	return ch, nil
+}
+
+func (p *pendingCalls) remove(id json.RawMessage) {
+	p.mu.Lock()
+	delete(p.waiters, string(id))
+	p.mu.Unlock()
+}
+
+func (p *pendingCalls) deliver(response *Response) {
+	p.mu.Lock()
+	ch, ok := p.waiters[string(response.ID)]
+	delete(p.waiters, string(response.ID))
+	p.mu.Unlock()
+	if ok {
+		ch <- response
+	}
+}
+
+// fail closes every waiter; later calls return err immediately.
+func (p *pendingCalls) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	for id, ch := range p.waiters {
+		close(ch)
+		delete(p.waiters, id)
+	}
+}
+
+func (p *pendingCalls) failure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *pendingCalls) wait(ctx context.Context, id json.RawMessage, ch chan *Response) (*Response, error) {
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			if err := p.failure(); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("mcp connection closed")
+		}
+		return response, nil
+	case <-ctx.Done():
+		p.remove(id)
+		return nil, ctx.Err()
+	}
+}
+
+// decodeMessage parses one JSON-RPC message and returns it when it is a
+// response; server requests and notifications are ignored.
+func decodeMessage(data []byte) (*Response, bool) {
+	var message struct {
+		Response
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, false
+	}
+	if message.Method != "" || len(message.ID) == 0 {
+		return nil, false
+	}
+	response := message.Response
+	return &response, true
+}
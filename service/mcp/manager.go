@@ -0,0 +1,151 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerConfig describes how to reach one upstream server. Version changes
+// whenever the configuration changes so stale connections are replaced.
+type ServerConfig struct {
+	Id        int
+	Name      string
+	Transport string // http, sse or stdio
+	Url       string
+	Headers   map[string]string
+	Command   string
+	Args      []string
+	Env       map[string]string
+	Version   int64
+}
+
+type managedClient struct {
+	client  *Client
+	version int64
+}
+
+type cachedTools struct {
+	tools     []Tool
+	version   int64
+	fetchedAt time.Time
+}
+
+// Manager keeps one client per server and caches tool lists.
+type Manager struct {
+	ClientInfo Implementation
+	HTTPClient *http.Client
+
+	mu      sync.Mutex
+	clients map[int]*managedClient
+	tools   map[int]*cachedTools
+}
+
+func NewManager(clientInfo Implementation) *Manager {
+	return &Manager{
+		ClientInfo: clientInfo,
+		HTTPClient: &http.Client{},
+		clients:    make(map[int]*managedClient),
+		tools:      make(map[int]*cachedTools),
+	}
+}
+
+func (m *Manager) client(server ServerConfig) (*Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if managed, ok := m.clients[server.Id]; ok {
+		if managed.version == server.Version {
+			return managed.client, nil
+		}
+		_ = managed.client.Close()
+		delete(m.clients, server.Id)
+	}
+	var t transport
+	switch strings.ToLower(server.Transport) {
+	case "http", "":
+		t = newStreamableHTTP(server.Url, server.Headers, m.HTTPClient)
+	case "sse":
+		t = newLegacySSE(server.Url, server.Headers, m.HTTPClient)
+	case "stdio":
+		t = newStdio(server.Command, server.Args, server.Env)
+	default:
+		return nil, fmt.Errorf("unsupported mcp transport %q", server.Transport)
+	}
+	client := newClient(t, m.ClientInfo)
+	m.clients[server.Id] = &managedClient{client: client, version: server.Version}
+	return client, nil
+}
+
+// drop closes the server's client after a transport failure so the next call
+// reconnects.
+func (m *Manager) drop(server ServerConfig, client *Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if managed, ok := m.clients[server.Id]; ok && managed.client == client {
+		_ = client.Close()
+		delete(m.clients, server.Id)
+	}
+}
+
+// Tools returns the server's tools, cached for ttl unless refresh is set.
+func (m *Manager) Tools(ctx context.Context, server ServerConfig, ttl time.Duration, refresh bool) ([]Tool, error) {
+	if !refresh {
+		m.mu.Lock()
+		cached, ok := m.tools[server.Id]
+		m.mu.Unlock()
+		if ok && cached.version == server.Version && time.Since(cached.fetchedAt) < ttl {
+			return cached.tools, nil
+		}
+	}
+	client, err := m.client(server)
+	if err != nil {
+		return nil, err
+	}
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		m.dropOnTransportError(server, client, err)
+		return nil, err
+	}
+	m.mu.Lock()
+	m.tools[server.Id] = &cachedTools{tools: tools, version: server.Version, fetchedAt: time.Now()}
+	m.mu.Unlock()
+	return tools, nil
+}
+
+// CallTool invokes a tool. Protocol-level errors leave the connection open;
+// transport errors reset it.
+func (m *Manager) CallTool(ctx context.Context, server ServerConfig, tool string, arguments []byte) ([]byte, error) {
+	client, err := m.client(server)
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.CallTool(ctx, tool, arguments)
+	if err != nil {
+		m.dropOnTransportError(server, client, err)
+		return nil, err
+	}
+	return result, nil
+}
+
+func (m *Manager) dropOnTransportError(server ServerConfig, client *Client, err error) {
+	var rpcError *Error
+	if errors.As(err, &rpcError) {
+		return
+	}
+	m.drop(server, client)
+}
+
+// Forget closes the server's connection and clears its cached tools.
+func (m *Manager) Forget(serverId int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if managed, ok := m.clients[serverId]; ok {
+		_ = managed.client.Close()
+		delete(m.clients, serverId)
+	}
+	delete(m.tools, serverId)
+}
@@ -0,0 +1,152 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAllowed(t *testing.T) {
+	limits := []string{"github", "fs/read_file", "search/*"}
+	cases := []struct {
+		server, tool string
+		want         bool
+	}{
+		{"github", "create_issue", true},
+		{"fs", "read_file", true},
+		{"fs", "write_file", false},
+		{"search", "web", true},
+		{"other", "x", false},
+	}
+	for _, tc := range cases {
+		if got := Allowed(limits, tc.server, tc.tool); got != tc.want {
+			t.Errorf("Allowed(%s, %s) = %v, want %v", tc.server, tc.tool, got, tc.want)
+		}
+	}
+	if !ServerAllowed(limits, "fs") || ServerAllowed(limits, "other") {
+		t.Error("ServerAllowed mismatch")
+	}
+	if server, tool, ok := SplitToolName("fs__read__file"); !ok || server != "fs" || tool != "read__file" {
+		t.Errorf("SplitToolName = %q %q %v", server, tool, ok)
+	}
+}
+
+// fakeServer answers initialize, tools/list and tools/call.
+func fakeServer(request Request) *Response {
+	switch request.Method {
+	case "initialize":
+		return NewResult(request.ID, InitializeResult{ProtocolVersion: ProtocolVersion, ServerInfo: Implementation{Name: "fake", Version: "1"}})
+	case "tools/list":
+		return NewResult(request.ID, ListToolsResult{Tools: []Tool{{Name: "echo", InputSchema: json.RawMessage(`{"type":"object"}`)}}})
+	case "tools/call":
+		var params CallToolParams
+		_ = json.Unmarshal(request.Params, &params)
+		return NewResult(request.ID, map[string]any{"content": []map[string]any{{"type": "text", "text": string(params.Arguments)}}})
+	}
+	return NewError(request.ID, CodeMethodNotFound, "method not found")
+}
+
+func TestStreamableHTTP(t *testing.T) {
+	var mu sync.Mutex
+	initialized := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request Request
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if request.IsNotification() {
+			mu.Lock()
+			initialized = request.Method == "notifications/initialized"
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if request.Method == "initialize" {
+			w.Header().Set("Mcp-Session-Id", "s1")
+		} else if r.Header.Get("Mcp-Session-Id") != "s1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := json.Marshal(fakeServer(request))
+		if request.Method == "tools/call" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	manager := NewManager(Implementation{Name: "test", Version: "1"})
+	config := ServerConfig{Id: 1, Name: "fake", Transport: "http", Url: server.URL}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tools, err := manager.Tools(ctx, config, time.Minute, false)
+	if err != nil || len(tools) != 1 || tools[0].Name != "echo" {
+		t.Fatalf("Tools = %v, %v", tools, err)
+	}
+	mu.Lock()
+	if !initialized {
+		t.Error("initialized notification not sent")
+	}
+	mu.Unlock()
+	result, err := manager.CallTool(ctx, config, "echo", json.RawMessage(`{"x":1}`))
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	if want := `{"content":[{"text":"{\"x\":1}","type":"text"}]}`; string(result) != want {
+		t.Errorf("result = %s, want %s", result, want)
+	}
+}
+
+func TestLegacySSE(t *testing.T) {
+	messages := make(chan []byte, 8)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: endpoint\ndata: /messages?session=1\n\n")
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case data := <-messages:
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var request Request
+		_ = json.Unmarshal(body, &request)
+		w.WriteHeader(http.StatusAccepted)
+		if !request.IsNotification() {
+			data, _ := json.Marshal(fakeServer(request))
+			messages <- data
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	manager := NewManager(Implementation{Name: "test", Version: "1"})
+	config := ServerConfig{Id: 2, Name: "legacy", Transport: "sse", Url: server.URL + "/sse"}
+	defer manager.Forget(config.Id)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tools, err := manager.Tools(ctx, config, time.Minute, false)
+	if err != nil || len(tools) != 1 {
+		t.Fatalf("Tools = %v, %v", tools, err)
+	}
+	if _, err := manager.CallTool(ctx, config, "missing", nil); err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package mcp
+
+import "strings"
+
+// ToolSeparator joins server and tool names in the unified catalog.
+const ToolSeparator = "__"
+
+func ToolName(server string, tool string) string {
+	return server + ToolSeparator + tool
+}
+
+// SplitToolName splits a catalog name at the first separator; server names
+// never contain it.
+func SplitToolName(name string) (server string, tool string, ok bool) {
+	server, tool, ok = strings.Cut(name, ToolSeparator)
+	if !ok || server == "" || tool == "" {
+		return "", "", false
+	}
+	return server, tool, true
+}
+
+// Allowed reports whether an allowlist grants tool on server. Entries are
+// "server" (every tool), "server/*" or "server/tool"; "*" grants everything.
+func Allowed(limits []string, server string, tool string) bool {
+	for _, limit := range limits {
+		limit = strings.TrimSpace(limit)
+		switch limit {
+		case "":
+			continue
+		case "*", server, server + "/*":
+			return true
+		}
+		if tool != "" && limit == server+"/"+tool {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerAllowed reports whether any tool of server is granted.
+func ServerAllowed(limits []string, server string) bool {
+	for _, limit := range limits {
+		limit = strings.TrimSpace(limit)
+		if limit == "*" || limit == server || strings.HasPrefix(limit, server+"/") {
+			return true
+		}
+	}
+	return false
+}
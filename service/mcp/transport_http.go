@@ -0,0 +1,202 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const maxMessageSize = 16 << 20
+
+// streamableHTTP implements the streamable HTTP transport: every message is
+// POSTed and the reply is either a JSON body or an SSE stream that carries
+// the response.
+type streamableHTTP struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+func newStreamableHTTP(url string, headers map[string]string, client *http.Client) *streamableHTTP {
+	return &streamableHTTP{url: url, headers: headers, client: client}
+}
+
+func (t *streamableHTTP) setProtocolVersion(version string) {
+	t.mu.Lock()
+	t.protocolVersion = version
+	t.mu.Unlock()
+}
+
+func (t *streamableHTTP) post(ctx context.Context, request *Request) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range t.headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "application/json, text/event-stream")
+	t.mu.Lock()
+	if t.sessionID != "" {
+		httpRequest.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		httpRequest.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+	}
+	sessionID := t.sessionID
+	t.mu.Unlock()
+
+	response, err := t.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotFound && sessionID != "" {
+		response.Body.Close()
+		t.mu.Lock()
+		t.sessionID = ""
+		t.mu.Unlock()
+		return nil, ErrSessionExpired
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		data, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		response.Body.Close()
+		return nil, fmt.Errorf("mcp server returned %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if id := response.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	return response, nil
+}
+
+func (t *streamableHTTP) call(ctx context.Context, request *Request) (*Response, error) {
+	response, err := t.post(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if strings.HasPrefix(response.Header.Get("Content-Type"), "text/event-stream") {
+		var result *Response
+		err := readSSE(response.Body, func(event string, data []byte) bool {
+			if message, ok := decodeMessage(data); ok && string(message.ID) == string(request.ID) {
+				result = message
+				return false
+			}
+			return true
+		})
+		if result != nil {
+			return result, nil
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("mcp stream ended without a response: %w", err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	message, ok := decodeMessage(data)
+	if !ok {
+		return nil, fmt.Errorf("invalid mcp response: %s", truncate(data, 200))
+	}
+	return message, nil
+}
+
+func (t *streamableHTTP) notify(ctx context.Context, request *Request) error {
+	response, err := t.post(ctx, request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	return response.Body.Close()
+}
+
+// close ends the session; servers that do not support DELETE answer 405.
+func (t *streamableHTTP) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.sessionID = ""
+	t.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+	httpRequest, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range t.headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpRequest.Header.Set("Mcp-Session-Id", sessionID)
+	response, err := t.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// readSSE calls onEvent for every event until it returns false or the stream
+// ends.
+func readSSE(body io.Reader, onEvent func(event string, data []byte) bool) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	event := ""
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			if data.Len() > 0 {
+				if !onEvent(event, data.Bytes()) {
+					return nil
+				}
+			}
+			event = ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	if data.Len() > 0 {
+		onEvent(event, data.Bytes())
+	}
+	return scanner.Err()
+}
+
+func truncate(data []byte, n int) string {
+	if len(data) > n {
+		return string(data[:n]) + "..."
+	}
+	return string(data)
+}
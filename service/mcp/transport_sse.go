@@ -0,0 +1,168 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// legacySSE implements the 2024-11-05 HTTP+SSE transport: a long-lived GET
+// stream announces a POST endpoint with an "endpoint" event and then carries
+// every response as a "message" event.
+type legacySSE struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	pending *pendingCalls
+
+	connectOnce sync.Once
+	connectErr  error
+	endpoint    string
+	cancel      context.CancelFunc
+}
+
+func newLegacySSE(url string, headers map[string]string, client *http.Client) *legacySSE {
+	return &legacySSE{url: url, headers: headers, client: client, pending: newPendingCalls()}
+}
+
+func (t *legacySSE) setProtocolVersion(string) {}
+
+func (t *legacySSE) connect(ctx context.Context) error {
+	t.connectOnce.Do(func() {
+		streamCtx, cancel := context.WithCancel(context.Background())
+		t.cancel = cancel
+		httpRequest, err := http.NewRequestWithContext(streamCtx, http.MethodGet, t.url, nil)
+		if err != nil {
+			t.connectErr = err
+			return
+		}
+		for key, value := range t.headers {
+			httpRequest.Header.Set(key, value)
+		}
+		httpRequest.Header.Set("Accept", "text/event-stream")
+		response, err := t.client.Do(httpRequest)
+		if err != nil {
+			t.connectErr = err
+			return
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			t.connectErr = fmt.Errorf("mcp sse stream returned %d", response.StatusCode)
+			return
+		}
+
+		endpoint := make(chan string, 1)
+		go func() {
+			defer response.Body.Close()
+			err := readSSE(response.Body, func(event string, data []byte) bool {
+				if event == "endpoint" {
+					select {
+					case endpoint <- strings.TrimSpace(string(data)):
+					default:
+					}
+					return true
+				}
+				if message, ok := decodeMessage(data); ok {
+					t.pending.deliver(message)
+				}
+				return true
+			})
+			if err == nil {
+				err = errors.New("mcp sse stream closed")
+			}
+			t.pending.fail(err)
+			close(endpoint)
+		}()
+
+		select {
+		case value, ok := <-endpoint:
+			if !ok || value == "" {
+				t.connectErr = errors.New("mcp sse stream closed before announcing an endpoint")
+				return
+			}
+			resolved, err := resolveEndpoint(t.url, value)
+			if err != nil {
+				t.connectErr = err
+				return
+			}
+			t.endpoint = resolved
+		case <-ctx.Done():
+			t.connectErr = ctx.Err()
+		}
+	})
+	return t.connectErr
+}
+
+func resolveEndpoint(base string, endpoint string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(ref).String(), nil
+}
+
+func (t *legacySSE) post(ctx context.Context, request *Request) error {
+	if err := t.connect(ctx); err != nil {
+		return err
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range t.headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := t.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		data, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("mcp server returned %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+func (t *legacySSE) call(ctx context.Context, request *Request) (*Response, error) {
+	if err := t.connect(ctx); err != nil {
+		return nil, err
+	}
+	ch, err := t.pending.add(request.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.post(ctx, request); err != nil {
+		t.pending.remove(request.ID)
+		return nil, err
+	}
+	return t.pending.wait(ctx, request.ID, ch)
+}
+
+func (t *legacySSE) notify(ctx context.Context, request *Request) error {
+	return t.post(ctx, request)
+}
+
+func (t *legacySSE) close() error {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.pending.fail(errors.New("mcp client closed"))
+	return nil
+}
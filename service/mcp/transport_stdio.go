@@ -0,0 +1,148 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// stdio runs the server as a child process speaking newline-delimited
+// JSON-RPC on stdin/stdout. The process is started on first use and lives
+// until the client is closed.
+type stdio struct {
+	command string
+	args    []string
+	env     map[string]string
+	pending *pendingCalls
+
+	startOnce sync.Once
+	startErr  error
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	writeMu   sync.Mutex
+}
+
+func newStdio(command string, args []string, env map[string]string) *stdio {
+	return &stdio{command: command, args: args, env: env, pending: newPendingCalls()}
+}
+
+func (t *stdio) setProtocolVersion(string) {}
+
+func (t *stdio) start() error {
+	t.startOnce.Do(func() {
+		cmd := exec.Command(t.command, t.args...)
+		cmd.Env = os.Environ()
+		for key, value := range t.env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			t.startErr = err
+			return
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.startErr = err
+			return
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &limitedBuffer{buffer: &stderr, limit: 4096}
+		if err := cmd.Start(); err != nil {
+			t.startErr = fmt.Errorf("start mcp server: %w", err)
+			return
+		}
+		t.cmd = cmd
+		t.stdin = stdin
+
+		go func() {
+			scanner := bufio.NewScanner(stdout)
+			scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+			for scanner.Scan() {
+				if message, ok := decodeMessage(scanner.Bytes()); ok {
+					t.pending.deliver(message)
+				}
+			}
+			waitErr := cmd.Wait()
+			err := fmt.Errorf("mcp server exited: %v", waitErr)
+			if stderr.Len() > 0 {
+				err = fmt.Errorf("%w: %s", err, truncate(stderr.Bytes(), 500))
+			}
+			t.pending.fail(err)
+		}()
+	})
+	return t.startErr
+}
+
+func (t *stdio) write(request *Request) error {
+	if err := t.start(); err != nil {
+		return err
+	}
+	if err := t.pending.failure(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (t *stdio) call(ctx context.Context, request *Request) (*Response, error) {
+	if err := t.start(); err != nil {
+		return nil, err
+	}
+	ch, err := t.pending.add(request.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.write(request); err != nil {
+		t.pending.remove(request.ID)
+		return nil, err
+	}
+	return t.pending.wait(ctx, request.ID, ch)
+}
+
+func (t *stdio) notify(ctx context.Context, request *Request) error {
+	return t.write(request)
+}
+
+func (t *stdio) close() error {
+	t.pending.fail(errors.New("mcp client closed"))
+	if t.stdin != nil {
+		_ = t.stdin.Close()
+	}
+	if t.cmd != nil && t.cmd.Process != nil {
+		return t.cmd.Process.Kill()
+	}
+	return nil
+}
+
+// limitedBuffer keeps the first limit bytes of stderr for error messages.
+type limitedBuffer struct {
+	mu     sync.Mutex
+	buffer *bytes.Buffer
+	limit  int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buffer.Len(); room > 0 {
+		if len(p) > room {
+			b.buffer.Write(p[:room])
+		} else {
+			b.buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
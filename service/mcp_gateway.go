@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service/mcp"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrMcpToolNotFound      = errors.New("mcp tool not found")
+	ErrMcpToolForbidden     = errors.New("token is not allowed to call this mcp tool")
+	ErrMcpInsufficientQuota = errors.New("user quota is not enough")
+	ErrMcpStdioDisabled     = errors.New("stdio mcp servers are disabled")
+
+	ErrMcpSpendingBudgetExceeded = errors.New("spending budget exceeded")
+)
+
+var mcpManager = mcp.NewManager(mcp.Implementation{Name: "new-api", Version: common.Version})
+
+func McpServerConfig(server *model.McpServer) mcp.ServerConfig {
+	return mcp.ServerConfig{
+		Id:        server.Id,
+		Name:      server.Name,
+		Transport: server.Transport,
+		Url:       server.Url,
+		Headers:   server.HeaderMap(),
+		Command:   server.Command,
+		Args:      server.ArgList(),
+		Env:       server.EnvMap(),
+		Version:   server.UpdatedTime,
+	}
+}
+
+// ForgetMcpServer drops the cached connection and tools after the server was
+// changed or deleted.
+func ForgetMcpServer(serverId int) {
+	mcpManager.Forget(serverId)
+}
+
+func mcpContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(config.GetMcpConfig().CallTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func mcpServerUsable(server *model.McpServer) error {
+	if server.Transport == model.McpTransportStdio && !config.GetMcpConfig().StdioEnabled {
+		return ErrMcpStdioDisabled
+	}
+	return nil
+}
+
+// ListMcpServerTools fetches a server's tools for the admin UI.
+func ListMcpServerTools(ctx context.Context, server *model.McpServer, refresh bool) ([]mcp.Tool, error) {
+	if err := mcpServerUsable(server); err != nil {
+		return nil, err
+	}
+	ctx, cancel := mcpContext(ctx)
+	defer cancel()
+	ttl := time.Duration(config.GetMcpConfig().CatalogTTLSeconds) * time.Second
+	return mcpManager.Tools(ctx, McpServerConfig(server), ttl, refresh)
+}
+
+// mcpTokenLimits returns the token's MCP allowlist; nil means every server
+// is allowed.
+func mcpTokenLimits(c *gin.Context) []string {
+	if common.GetContextKeyBool(c, constant.ContextKeyTokenMcpLimitEnabled) {
+		limits, _ := common.GetContextKeyType[[]string](c, constant.ContextKeyTokenMcpLimit)
+		if limits == nil {
+			limits = []string{}
+		}
+		return limits
+	}
+	if config.GetMcpConfig().RequireGrant {
+		return []string{}
+	}
+	return nil
+}
+
+func mcpAllowed(c *gin.Context, server string, tool string) bool {
+	limits := mcpTokenLimits(c)
+	if limits == nil {
+		return true
+	}
+	if tool == "" {
+		return mcp.ServerAllowed(limits, server)
+	}
+	return mcp.Allowed(limits, server, tool)
+}
+
+// McpCatalog lists the tools the token may call across all enabled servers,
+// named "<server>__<tool>". Servers that fail to answer are skipped and
+// reported in errs.
+func McpCatalog(c *gin.Context) (tools []mcp.Tool, errs map[string]string) {
+	servers, err := model.GetEnabledMcpServers()
+	if err != nil {
+		return nil, map[string]string{"*": err.Error()}
+	}
+	errs = make(map[string]string)
+	tools = make([]mcp.Tool, 0)
+	for _, server := range servers {
+		if !mcpAllowed(c, server.Name, "") {
+			continue
+		}
+		serverTools, err := ListMcpServerTools(c.Request.Context(), server, false)
+		if err != nil {
+			errs[server.Name] = err.Error()
+			logger.LogWarn(c, fmt.Sprintf("mcp server %s tools/list failed: %s", server.Name, err.Error()))
+			continue
+		}
+		for _, tool := range serverTools {
+			if !mcpAllowed(c, server.Name, tool.Name) {
+				continue
+			}
+			tool.Name = mcp.ToolName(server.Name, tool.Name)
+			if server.Description != "" && tool.Description == "" {
+				tool.Description = server.Description
+			}
+			tools = append(tools, tool)
+		}
+	}
+	return tools, errs
+}
+
+func findEnabledMcpServer(name string) (*model.McpServer, error) {
+	servers, err := model.GetEnabledMcpServers()
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		if server.Name == name {
+			return server, nil
+		}
+	}
+	return nil, ErrMcpToolNotFound
+}
+
+// CallMcpTool checks the token's grant and quota, forwards the call, then
+// bills and logs it. The upstream result is returned verbatim.
+func CallMcpTool(c *gin.Context, name string, arguments json.RawMessage) (json.RawMessage, error) {
+	serverName, toolName, ok := mcp.SplitToolName(name)
+	if !ok {
+		return nil, ErrMcpToolNotFound
+	}
+	server, err := findEnabledMcpServer(serverName)
+	if err != nil {
+		return nil, err
+	}
+	if !mcpAllowed(c, serverName, toolName) {
+		return nil, ErrMcpToolForbidden
+	}
+	if err := mcpServerUsable(server); err != nil {
+		return nil, err
+	}
+
+	userId := c.GetInt("id")
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	price := server.ToolPrice(toolName)
+	groupRatio := ratio_setting.GetGroupRatio(group)
+	relayInfo := toolCallRelayInfo(c)
+	quota := 0
+	if price > 0 {
+		quota = int(price * common.QuotaPerUnit * groupRatio)
+		if !toolCallQuotaAvailable(c, quota) {
+			return nil, ErrMcpInsufficientQuota
+		}
+		if err := checkToolCallBudgets(c, relayInfo, quota); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	ctx, cancel := mcpContext(c.Request.Context())
+	defer cancel()
+	result, err := mcpManager.CallTool(ctx, McpServerConfig(server), toolName, arguments)
+	useTime := int(time.Since(start).Seconds())
+	logModel := "mcp/" + serverName + "/" + toolName
+	other := map[string]interface{}{
+		"mcp_server":  serverName,
+		"mcp_tool":    toolName,
+		"use_time_ms": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		model.RecordErrorLog(c, userId, 0, logModel, c.GetString("token_name"), "MCP 工具调用失败："+err.Error(),
+			c.GetInt("token_id"), useTime, false, group, other)
+		return nil, err
+	}
+
+	var callResult mcp.CallToolResult
+	_ = common.Unmarshal(result, &callResult)
+	other["is_error"] = callResult.IsError
+	if quota > 0 {
+		if err := chargeToolCallQuota(c, relayInfo, quota); err != nil {
+			logger.LogError(c, "mcp call charge failed: "+err.Error())
+		}
+	}
+	model.RecordConsumeLog(c, userId, model.RecordConsumeLogParams{
+		ModelName:      logModel,
+		TokenName:      c.GetString("token_name"),
+		Quota:          quota,
+		Content:        fmt.Sprintf("MCP 工具调用，单价 %.4f，分组倍率 %.2f", price, groupRatio),
+		TokenId:        c.GetInt("token_id"),
+		UseTimeSeconds: useTime,
+		Group:          group,
+		Other:          other,
+	})
+	return result, nil
+}
+
+// toolCallQuotaAvailable reports whether both the payer (the user or the
+// token's organization) and the token can pay for a per-call tool charge.
+func toolCallQuotaAvailable(c *gin.Context, quota int) bool {
+	if orgId := common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId); orgId != 0 {
+		if orgQuota, err := model.GetOrganizationQuota(orgId); err != nil || orgQuota < quota {
+			return false
+		}
+	} else if common.GetContextKeyInt(c, constant.ContextKeyUserQuota) < quota {
+		return false
+	}
+	return c.GetBool("token_unlimited_quota") || c.GetInt("token_quota") >= quota
+}
+
+// toolCallRelayInfo carries the caller's identity for the spending budget
+// checks; a tool call has no relay request of its own.
+func toolCallRelayInfo(c *gin.Context) *relaycommon.RelayInfo {
+	info := &relaycommon.RelayInfo{
+		UserId:    c.GetInt("id"),
+		UserEmail: common.GetContextKeyString(c, constant.ContextKeyUserEmail),
+		TokenId:   c.GetInt("token_id"),
+	}
+	info.UserSetting, _ = common.GetContextKeyType[dto.UserSetting](c, constant.ContextKeyUserSetting)
+	return info
+}
+
+// checkToolCallBudgets applies the same spending budgets as PreConsumeQuota.
+func checkToolCallBudgets(c *gin.Context, relayInfo *relaycommon.RelayInfo, quota int) error {
+	apiErr := checkSpendingBudgets(c, quota, relayInfo)
+	if apiErr == nil {
+		return nil
+	}
+	if apiErr.GetErrorCode() == types.ErrorCodeSpendingBudgetExceeded {
+		return fmt.Errorf("%w: %s", ErrMcpSpendingBudgetExceeded, apiErr.Error())
+	}
+	return apiErr
+}
+
+func chargeToolCallQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, quota int) error {
+	userId := relayInfo.UserId
+	if orgId := common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId); orgId != 0 {
+		if err := model.DecreaseOrganizationQuota(orgId, userId, quota); err != nil {
+			return err
+		}
+	} else if err := model.DecreaseUserQuota(userId, quota); err != nil {
+		return err
+	}
+	if err := model.DecreaseTokenQuota(c.GetInt("token_id"), c.GetString("token_key"), quota); err != nil {
+		return err
+	}
+	model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
+	recordSpendingBudgets(relayInfo, quota)
+	return nil
+}
+
+// McpServerNames lists enabled server names visible to the token.
+func McpServerNames(c *gin.Context) []string {
+	servers, err := model.GetEnabledMcpServers()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if mcpAllowed(c, server.Name, "") {
+			names = append(names, server.Name)
+		}
+	}
+	return names
+}
+
+// McpInstructions summarises the available servers for the initialize reply.
+func McpInstructions(c *gin.Context) string {
+	names := McpServerNames(c)
+	if len(names) == 0 {
+		return "No MCP servers are available to this token."
+	}
+	return "Tools are named <server>__<tool>. Servers: " + strings.Join(names, ", ")
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service/budget"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestToolCallChargesFollowSpendingBudgets(t *testing.T) {
+	db := setupServiceTestDB(t)
+	if err := db.AutoMigrate(&model.SpendingBudgetUsage{}); err != nil {
+		t.Fatalf("failed to migrate budget usage: %v", err)
+	}
+	user, token := createUserAndToken(t, db, 1000, false)
+	spendingBudget := &model.SpendingBudget{UserId: user.Id, Window: budget.WindowDay, LimitQuota: 100, Enabled: true}
+	if err := db.Create(spendingBudget).Error; err != nil {
+		t.Fatalf("create budget: %v", err)
+	}
+	InvalidateSpendingBudgetCache(user.Id)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/mcp/tools/call", nil)
+	c.Set("id", user.Id)
+	c.Set("token_id", token.Id)
+	c.Set("token_key", token.Key)
+	relayInfo := toolCallRelayInfo(c)
+
+	if err := checkToolCallBudgets(c, relayInfo, 60); err != nil {
+		t.Fatalf("first call within budget rejected: %v", err)
+	}
+	if err := chargeToolCallQuota(c, relayInfo, 60); err != nil {
+		t.Fatalf("charge: %v", err)
+	}
+	start, _ := SpendingBudgetWindow(spendingBudget, time.Now())
+	usage, err := model.GetSpendingBudgetUsage(spendingBudget.Id, start.Unix())
+	if err != nil || usage.SpentQuota != 60 {
+		t.Fatalf("expected the charge to count against the budget, got %+v (err %v)", usage, err)
+	}
+	if err := checkToolCallBudgets(c, relayInfo, 60); !errors.Is(err, ErrMcpSpendingBudgetExceeded) {
+		t.Fatalf("expected the second call to exceed the budget, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package moderation
+
+import "testing"
+
+func TestParseClassifierOutput(t *testing.T) {
+	reply := "Sure:\n```json\n{\"violence\": 0.92, \"Hate\": true, \"spam\": 1, \"sexual\": 1.4}\n```"
+	scores, err := ParseClassifierOutput(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scores["violence"] != 0.92 || scores["hate"] != 1 || scores["sexual"] != 1 {
+		t.Errorf("scores = %v", scores)
+	}
+	if _, ok := scores["spam"]; ok {
+		t.Errorf("unknown category kept: %v", scores)
+	}
+	if _, err := ParseClassifierOutput("no json here"); err == nil {
+		t.Error("expected an error for a reply without JSON")
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	result := NewResult(map[string]float64{"violence": 0.7, "hate": 0.3}, 0.5)
+	if !result.Flagged || !result.Categories["violence"] || result.Categories["hate"] {
+		t.Errorf("result = %+v", result)
+	}
+	if len(result.Categories) != len(Categories) || result.CategoryScores["hate"] != 0.3 {
+		t.Errorf("every category must be present: %+v", result)
+	}
+	if clean := NewResult(nil, 0.5); clean.Flagged {
+		t.Errorf("empty scores flagged: %+v", clean)
+	}
+}
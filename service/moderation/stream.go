@@ -0,0 +1,145 @@
+// Package moderation scans model output as it is produced. A Stream keeps a
+// sliding window of recent text so that a flagged word split across chunks
+// is still found, and holds back the window when the output may need to be
+// changed before it is sent.
+package moderation
+
+// Actions taken when output is flagged.
+const (
+	// ActionTruncate ends the output before the first hit.
+	ActionTruncate = "truncate"
+	// ActionReplace replaces each hit and keeps going.
+	ActionReplace = "replace"
+	// ActionFlag only records hits; output is sent unchanged and unheld.
+	ActionFlag = "flag"
+)
+
+// Match is a flagged span, in runes, of the text given to a Matcher.
+type Match struct {
+	Start int
+	End   int
+	Word  string
+}
+
+// Matcher finds flagged spans in text, ordered by Start.
+type Matcher func(text string) []Match
+
+// Stream moderates one sequence of text deltas, such as one choice of a
+// streamed completion.
+type Stream struct {
+	matcher     Matcher
+	action      string
+	window      int
+	replacement string
+
+	// held is text not yet sent (truncate, replace) or the already sent
+	// tail kept for overlap (flag).
+	held    []rune
+	stopped bool
+	hits    []string
+}
+
+// NewStream creates a stream. window must be at least the longest flagged
+// word minus one rune for hits across chunk boundaries to be found.
+func NewStream(matcher Matcher, action string, window int, replacement string) *Stream {
+	if window < 0 {
+		window = 0
+	}
+	return &Stream{matcher: matcher, action: action, window: window, replacement: replacement}
+}
+
+// Hits returns the flagged words seen so far.
+func (s *Stream) Hits() []string {
+	return s.hits
+}
+
+// Stopped reports whether the output was truncated.
+func (s *Stream) Stopped() bool {
+	return s.stopped
+}
+
+// Push scans a delta and returns the text that can be sent now. stop is true
+// when the output was truncated; nothing should be sent after out.
+func (s *Stream) Push(delta string) (out string, stop bool) {
+	if s.stopped {
+		return "", true
+	}
+	previous := len(s.held)
+	text := append(s.held, []rune(delta)...)
+	matches := s.matcher(string(text))
+
+	switch s.action {
+	case ActionFlag:
+		for _, m := range matches {
+			if m.End > previous {
+				s.hits = append(s.hits, m.Word)
+			}
+		}
+		s.held = tail(text, s.window)
+		return delta, false
+	case ActionTruncate:
+		if len(matches) > 0 {
+			s.hits = append(s.hits, matches[0].Word)
+			s.stopped = true
+			s.held = nil
+			return string(text[:matches[0].Start]), true
+		}
+		cut := len(text) - s.window
+		if cut < 0 {
+			cut = 0
+		}
+		s.held = append([]rune(nil), text[cut:]...)
+		return string(text[:cut]), false
+	default:
+		cut := len(text) - s.window
+		if cut < 0 {
+			cut = 0
+		}
+		// a hit across the cut is complete, so it can be replaced now
+		for _, m := range matches {
+			if m.Start < cut && m.End > cut {
+				cut = m.End
+			}
+		}
+		out := s.replace(text[:cut], matches)
+		s.held = append([]rune(nil), text[cut:]...)
+		return out, false
+	}
+}
+
+// Flush returns the held text at the end of the output.
+func (s *Stream) Flush() string {
+	if s.stopped || s.action == ActionFlag {
+		s.held = nil
+		return ""
+	}
+	text := s.held
+	s.held = nil
+	if s.action == ActionTruncate {
+		return string(text)
+	}
+	return s.replace(text, s.matcher(string(text)))
+}
+
+func (s *Stream) replace(text []rune, matches []Match) string {
+	out := make([]rune, 0, len(text))
+	last := 0
+	for _, m := range matches {
+		if m.Start < last || m.End > len(text) {
+			continue
+		}
+		out = append(out, text[last:m.Start]...)
+		out = append(out, []rune(s.replacement)...)
+		last = m.End
+		s.hits = append(s.hits, m.Word)
+	}
+	out = append(out, text[last:]...)
+	return string(out)
+}
+
+func tail(text []rune, n int) []rune {
+	if len(text) <= n {
+		return append([]rune(nil), text...)
+	}
+	return append([]rune(nil), text[len(text)-n:]...)
+}
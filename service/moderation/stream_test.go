@@ -0,0 +1,74 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+)
+
+func wordMatcher(words ...string) Matcher {
+	return func(text string) []Match {
+		runes := []rune(text)
+		var matches []Match
+		for i := range runes {
+			for _, w := range words {
+				wr := []rune(w)
+				if i+len(wr) <= len(runes) && string(runes[i:i+len(wr)]) == w {
+					matches = append(matches, Match{Start: i, End: i + len(wr), Word: w})
+				}
+			}
+		}
+		return matches
+	}
+}
+
+func run(s *Stream, deltas ...string) (string, bool) {
+	var out strings.Builder
+	for _, d := range deltas {
+		text, stop := s.Push(d)
+		out.WriteString(text)
+		if stop {
+			return out.String(), true
+		}
+	}
+	out.WriteString(s.Flush())
+	return out.String(), false
+}
+
+func TestTruncateAcrossChunks(t *testing.T) {
+	s := NewStream(wordMatcher("secret"), ActionTruncate, 5, "")
+	out, stopped := run(s, "the sec", "ret plan", " continues")
+	if out != "the " || !stopped {
+		t.Errorf("got %q stopped=%v", out, stopped)
+	}
+	if len(s.Hits()) != 1 || s.Hits()[0] != "secret" {
+		t.Errorf("hits = %v", s.Hits())
+	}
+
+	clean := NewStream(wordMatcher("secret"), ActionTruncate, 5, "")
+	if out, stopped := run(clean, "nothing ", "to see", " here"); out != "nothing to see here" || stopped {
+		t.Errorf("clean got %q stopped=%v", out, stopped)
+	}
+}
+
+func TestReplaceAcrossChunks(t *testing.T) {
+	s := NewStream(wordMatcher("坏词"), ActionReplace, 1, "**")
+	out, _ := run(s, "这是坏", "词，还有坏词", "结束")
+	if out != "这是**，还有**结束" {
+		t.Errorf("got %q", out)
+	}
+	if len(s.Hits()) != 2 {
+		t.Errorf("hits = %v", s.Hits())
+	}
+}
+
+func TestFlagOnly(t *testing.T) {
+	s := NewStream(wordMatcher("bad"), ActionFlag, 2, "")
+	first, _ := s.Push("a b")
+	second, _ := s.Push("ad day, bad")
+	if first+second != "a bad day, bad" {
+		t.Errorf("flag must not change output, got %q", first+second)
+	}
+	if len(s.Hits()) != 2 {
+		t.Errorf("hits = %v", s.Hits())
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkOrganizationAccess verifies that the organization of the token is
+// enabled and that its user is still a member allowed to use tokens.
+func checkOrganizationAccess(relayInfo *relaycommon.RelayInfo) (*model.OrganizationMember, *types.NewAPIError) {
+	org, err := model.GetOrganizationById(relayInfo.OrganizationId)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if org.Status != model.OrganizationStatusEnabled {
+		return nil, types.NewErrorWithStatusCode(errors.New("组织已被禁用"), types.ErrorCodeAccessDenied, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	member, err := model.GetOrganizationMember(org.Id, relayInfo.UserId)
+	if err != nil || !model.OrganizationRoleCan(member.Role, model.OrganizationPermissionTokens) {
+		return nil, types.NewErrorWithStatusCode(errors.New("用户不是该组织的开发成员，无法使用组织令牌"), types.ErrorCodeAccessDenied, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	return member, nil
+}
+
+// preConsumeOrganizationQuota is the legacy pre-consume path for
+// organization tokens: the pool and the member's spending cap are checked
+// and the estimate is always pre-consumed from the pool.
+func preConsumeOrganizationQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo, member *model.OrganizationMember) *types.NewAPIError {
+	orgQuota, err := model.GetOrganizationQuota(relayInfo.OrganizationId)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if orgQuota <= 0 || orgQuota-preConsumedQuota < 0 {
+		return types.NewErrorWithStatusCode(fmt.Errorf("组织额度不足, 剩余额度: %s, 需要预扣费额度: %s", logger.FormatQuota(orgQuota), logger.FormatQuota(preConsumedQuota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if member.SpendingCap > 0 && member.UsedQuota+preConsumedQuota > member.SpendingCap {
+		return types.NewErrorWithStatusCode(fmt.Errorf("已超出组织成员消费上限, 已用额度: %s, 上限: %s", logger.FormatQuota(member.UsedQuota), logger.FormatQuota(member.SpendingCap)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+
+	relayInfo.UserQuota = orgQuota
+	if preConsumedQuota > 0 {
+		// the pool goes first: a failed token charge can hand it back
+		if err := model.DecreaseOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, preConsumedQuota); err != nil {
+			return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+		}
+		if err := PreConsumeTokenQuota(relayInfo, preConsumedQuota); err != nil {
+			if refundErr := model.DecreaseOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, -preConsumedQuota); refundErr != nil {
+				common.SysLog("error refunding organization quota: " + refundErr.Error())
+			}
+			return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+		logger.LogInfo(c, fmt.Sprintf("用户 %d 从组织 %d 预扣费 %s, 预扣费后组织剩余额度: %s", relayInfo.UserId, relayInfo.OrganizationId, logger.FormatQuota(preConsumedQuota), logger.FormatQuota(orgQuota-preConsumedQuota)))
+	}
+	recordSpendingBudgets(relayInfo, preConsumedQuota)
+	relayInfo.FinalPreConsumedQuota = preConsumedQuota
+	return nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type organizationFixture struct {
+	db     *gorm.DB
+	user   *model.User
+	token  *model.Token
+	org    *model.Organization
+	member *model.OrganizationMember
+}
+
+// setupOrganizationTest creates a developer with a personal balance of 100
+// in an organization whose pool holds 100.
+func setupOrganizationTest(t *testing.T, billingFeature bool) *organizationFixture {
+	t.Helper()
+	db := setupServiceTestDB(t)
+	if err := db.AutoMigrate(&model.Organization{}, &model.OrganizationMember{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	for _, table := range []interface{}{&model.RequestLog{}, &model.OrganizationMember{}, &model.Organization{}, &model.Token{}, &model.User{}} {
+		db.Unscoped().Where("1 = 1").Delete(table)
+	}
+	oldBilling := common.BillingFeatureEnabled
+	common.BillingFeatureEnabled = billingFeature
+	t.Cleanup(func() { common.BillingFeatureEnabled = oldBilling })
+
+	user, token := createUserAndToken(t, db, 100, true)
+	// budgets cached for the same user id by other tests do not apply here
+	InvalidateSpendingBudgetCache(user.Id)
+	org := &model.Organization{Name: "Acme", Status: model.OrganizationStatusEnabled, Quota: 100}
+	if err := db.Create(org).Error; err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	member := &model.OrganizationMember{OrganizationId: org.Id, UserId: user.Id, Role: model.OrganizationRoleDeveloper}
+	if err := db.Create(member).Error; err != nil {
+		t.Fatalf("create member: %v", err)
+	}
+	return &organizationFixture{db: db, user: user, token: token, org: org, member: member}
+}
+
+func (f *organizationFixture) relayInfo() *relaycommon.RelayInfo {
+	info := newRelayInfo(f.user.Id, f.token.Id, true)
+	info.OrganizationId = f.org.Id
+	// playground requests leave the token quota alone
+	info.IsPlayground = true
+	return info
+}
+
+// balances returns the user's balance, the pool and the member's spending.
+func (f *organizationFixture) balances(t *testing.T) (int, int, int) {
+	t.Helper()
+	var user model.User
+	var org model.Organization
+	var member model.OrganizationMember
+	if err := f.db.First(&user, f.user.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := f.db.First(&org, f.org.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := f.db.First(&member, f.member.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.Quota, org.Quota, member.UsedQuota
+}
+
+func (f *organizationFixture) update(t *testing.T, value any, column string, v any) {
+	t.Helper()
+	if err := f.db.Model(value).Update(column, v).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrganizationTokenAccess(t *testing.T) {
+	f := setupOrganizationTest(t, false)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	f.update(t, f.member, "role", model.OrganizationRoleViewer)
+	if err := PreConsumeQuota(c, 10, f.relayInfo()); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("viewer used an organization token: %v", err)
+	}
+	f.update(t, f.member, "role", model.OrganizationRoleDeveloper)
+	f.update(t, f.org, "status", model.OrganizationStatusDisabled)
+	if err := PreConsumeQuota(c, 10, f.relayInfo()); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("disabled organization accepted a request: %v", err)
+	}
+	f.update(t, f.org, "status", model.OrganizationStatusEnabled)
+	f.db.Delete(f.member)
+	if err := PreConsumeQuota(c, 10, f.relayInfo()); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("removed member used an organization token: %v", err)
+	}
+}
+
+func TestPreConsumeOrganizationQuota(t *testing.T) {
+	f := setupOrganizationTest(t, false)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	f.update(t, f.member, "spending_cap", 50)
+
+	if err := PreConsumeQuota(c, 120, f.relayInfo()); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("pre-consumed more than the pool holds: %v", err)
+	}
+	if err := PreConsumeQuota(c, 60, f.relayInfo()); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("pre-consumed past the spending cap: %v", err)
+	}
+
+	info := f.relayInfo()
+	if err := PreConsumeQuota(c, 30, info); err != nil {
+		t.Fatalf("pre-consume: %v", err)
+	}
+	if info.FinalPreConsumedQuota != 30 {
+		t.Fatalf("pre-consumed %d, want 30", info.FinalPreConsumedQuota)
+	}
+	if user, pool, used := f.balances(t); user != 100 || pool != 70 || used != 30 {
+		t.Fatalf("after pre-consume: user %d pool %d member %d, want 100/70/30", user, pool, used)
+	}
+	// the request cost 40: the difference is charged to the pool
+	if err := PostConsumeQuota(info, 10, info.FinalPreConsumedQuota, true); err != nil {
+		t.Fatal(err)
+	}
+	if user, pool, used := f.balances(t); user != 100 || pool != 60 || used != 40 {
+		t.Fatalf("after settle: user %d pool %d member %d, want 100/60/40", user, pool, used)
+	}
+
+	// a failed request returns the whole pre-consumed quota
+	info = f.relayInfo()
+	if err := PreConsumeQuota(c, 10, info); err != nil {
+		t.Fatalf("pre-consume: %v", err)
+	}
+	if err := PostConsumeQuota(info, -info.FinalPreConsumedQuota, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if user, pool, used := f.balances(t); user != 100 || pool != 60 || used != 40 {
+		t.Fatalf("after refund: user %d pool %d member %d, want 100/60/40", user, pool, used)
+	}
+}
+
+func TestPreConsumeOrganizationQuotaReturnsPoolOnTokenFailure(t *testing.T) {
+	f := setupOrganizationTest(t, false)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	info := f.relayInfo()
+	info.IsPlayground = false
+	info.TokenKey = "missing"
+
+	if err := PreConsumeQuota(c, 30, info); err == nil || err.GetErrorCode() != types.ErrorCodePreConsumeTokenQuotaFailed {
+		t.Fatalf("expected the token charge to fail pre-consume, got %v", err)
+	}
+	if user, pool, used := f.balances(t); user != 100 || pool != 100 || used != 0 {
+		t.Fatalf("after the failed pre-consume: user %d pool %d member %d, want 100/100/0", user, pool, used)
+	}
+	if info.FinalPreConsumedQuota != 0 {
+		t.Fatalf("pre-consumed %d after a failure", info.FinalPreConsumedQuota)
+	}
+}
+
+func TestBillingEngineChargesOrganizationPool(t *testing.T) {
+	f := setupOrganizationTest(t, true)
+	f.update(t, f.member, "spending_cap", 50)
+	engine := NewBillingEngine(f.db)
+
+	info := f.relayInfo()
+	info.IsPlayground = false
+	pc, err := engine.PrepareCharge(nil, "", info)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if pc.SubjectType != common.AssignmentSubjectTypeOrganization || pc.SubjectId != f.org.Id || pc.Mode != common.BillingModeBalance {
+		t.Fatalf("prepared %s:%d in %s mode, want the organization in balance mode", pc.SubjectType, pc.SubjectId, pc.Mode)
+	}
+	if _, err := engine.CommitCharge(nil, &CommitParams{Prepared: pc, Amount: 20, RequestId: "req-org-1", RelayInfo: info}); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if user, pool, used := f.balances(t); user != 100 || pool != 80 || used != 20 {
+		t.Fatalf("after commit: user %d pool %d member %d, want 100/80/20", user, pool, used)
+	}
+
+	_, err = engine.CommitCharge(nil, &CommitParams{Prepared: pc, Amount: 40, RequestId: "req-org-2", RelayInfo: info})
+	if _, ok := err.(*ErrBalanceInsufficient); !ok {
+		t.Fatalf("expected the spending cap to refuse the charge, got %v", err)
+	}
+	if user, pool, used := f.balances(t); user != 100 || pool != 80 || used != 20 {
+		t.Fatalf("refused charge moved quota: user %d pool %d member %d", user, pool, used)
+	}
+}
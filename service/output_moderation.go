@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service/moderation"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const outputModerationTimeout = 10 * time.Second
+
+// outputViolationSeverity is the severity output hits are recorded with.
+const outputViolationSeverity = "violation"
+
+// OutputModerator moderates model output with the configured word list and
+// moderation channel. The relay response writers depend on it through a
+// small interface so they can be tested without a database.
+type OutputModerator struct{}
+
+func (OutputModerator) Action() string {
+	return OutputModerationAction()
+}
+
+func (OutputModerator) NewStream() *moderation.Stream {
+	return NewOutputModerationStream()
+}
+
+func (OutputModerator) Classify(ctx context.Context, text string) (bool, []string, error) {
+	return ModerateWithChannel(ctx, text)
+}
+
+func (OutputModerator) ChannelEnabled() bool {
+	return config.GetOutputModerationConfig().ChannelId != 0
+}
+
+func (OutputModerator) Replacement() string {
+	return config.GetOutputModerationConfig().Replacement
+}
+
+func (OutputModerator) CheckIntervalRunes() int {
+	return config.GetOutputModerationConfig().CheckIntervalRunes
+}
+
+func (OutputModerator) Record(c *gin.Context, content string, hits []string, action string) {
+	RecordOutputViolation(c, content, hits, action)
+}
+
+// OutputModerationLogInfo is the other.output_moderation entry of the
+// consume log, reported by the writer moderating the response.
+func OutputModerationLogInfo(c *gin.Context) map[string]interface{} {
+	value, ok := common.GetContextKey(c, constant.ContextKeyOutputModeration)
+	if !ok {
+		return nil
+	}
+	reporter, ok := value.(interface{ LogInfo() map[string]interface{} })
+	if !ok || reporter == nil {
+		return nil
+	}
+	return reporter.LogInfo()
+}
+
+// OutputModerationAction is the configured action, defaulting to the
+// StopOnSensitiveEnabled switch.
+func OutputModerationAction() string {
+	switch action := config.GetOutputModerationConfig().Action; action {
+	case moderation.ActionTruncate, moderation.ActionReplace, moderation.ActionFlag:
+		return action
+	}
+	if setting.StopOnSensitiveEnabled {
+		return moderation.ActionTruncate
+	}
+	return moderation.ActionReplace
+}
+
+// NewOutputModerationStream scans output against the sensitive word list.
+func NewOutputModerationStream() *moderation.Stream {
+	cfg := config.GetOutputModerationConfig()
+	window := cfg.WindowRunes
+	for _, word := range setting.SensitiveWords {
+		if n := len([]rune(word)) - 1; n > window {
+			window = n
+		}
+	}
+	return moderation.NewStream(sensitiveWordMatches, OutputModerationAction(), window, cfg.Replacement)
+}
+
+// sensitiveWordMatches finds sensitive words case-insensitively, in runes.
+func sensitiveWordMatches(text string) []moderation.Match {
+	if len(setting.SensitiveWords) == 0 || text == "" {
+		return nil
+	}
+	m := getOrBuildAC(setting.SensitiveWords)
+	if m == nil {
+		return nil
+	}
+	hits := m.MultiPatternSearch([]rune(strings.ToLower(text)), false)
+	matches := make([]moderation.Match, 0, len(hits))
+	for _, hit := range hits {
+		matches = append(matches, moderation.Match{Start: hit.Pos, End: hit.Pos + len(hit.Word), Word: string(hit.Word)})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Start < matches[j].Start })
+	return matches
+}
+
+// ModerateWithChannel sends text to the /v1/moderations endpoint of the
+// configured moderation channel and returns the flagged categories.
+func ModerateWithChannel(ctx context.Context, text string) (bool, []string, error) {
+	cfg := config.GetOutputModerationConfig()
+	if cfg.ChannelId == 0 {
+		return false, nil, nil
+	}
+	channel, err := model.GetChannelById(cfg.ChannelId, true)
+	if err != nil {
+		return false, nil, err
+	}
+	key, _, keyErr := channel.GetNextEnabledKey()
+	if keyErr != nil {
+		return false, nil, keyErr
+	}
+	payload, err := common.Marshal(map[string]any{"model": cfg.Model, "input": text})
+	if err != nil {
+		return false, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, outputModerationTimeout)
+	defer cancel()
+	url := strings.TrimSuffix(channel.GetBaseURL(), "/") + "/v1/moderations"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return false, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	defer CloseResponseBodyGracefully(resp)
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, fmt.Errorf("moderation channel returned status %d", resp.StatusCode)
+	}
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := common.DecodeJson(resp.Body, &result); err != nil {
+		return false, nil, err
+	}
+	if len(result.Results) == 0 {
+		return false, nil, errors.New("moderation channel returned no results")
+	}
+	flagged := false
+	var categories []string
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		flagged = true
+		for category, hit := range r.Categories {
+			if hit {
+				categories = append(categories, category)
+			}
+		}
+	}
+	sort.Strings(categories)
+	return flagged, categories, nil
+}
+
+// RecordOutputViolation logs flagged output and, when configured, records a
+// security violation for the user.
+func RecordOutputViolation(c *gin.Context, content string, hits []string, action string) {
+	hits = RemoveDuplicate(hits)
+	logger.LogWarn(c, fmt.Sprintf("模型输出命中审核规则（%s）：%s", action, strings.Join(hits, ", ")))
+	if !config.GetOutputModerationConfig().RecordViolations {
+		return
+	}
+	userId := common.GetContextKeyInt(c, constant.ContextKeyUserId)
+	if userId == 0 {
+		return
+	}
+	var tokenId *int
+	if id := common.GetContextKeyInt(c, constant.ContextKeyTokenId); id > 0 {
+		tokenId = &id
+	}
+	modelName := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+	if err := RecordViolation(userId, tokenId, content, hits, modelName, c.ClientIP(), c.GetString(common.RequestIdKey), outputViolationSeverity, "output_"+action); err != nil {
+		logger.LogError(c, "failed to record output violation: "+err.Error())
+	}
+}
@@ -0,0 +1,217 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/Calcium-Ion/go-epay/epay"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+)
+
+// epayApiPath is the merchant API of Epay gateways, used to query and
+// refund orders.
+const epayApiPath = "/api.php"
+
+// EpayProvider pays through an Epay (易支付) gateway.
+type EpayProvider struct{}
+
+func (*EpayProvider) Name() string {
+	return "epay"
+}
+
+func (*EpayProvider) Enabled() bool {
+	return operation_setting.PayAddress != "" && operation_setting.EpayId != "" && operation_setting.EpayKey != ""
+}
+
+func GetEpayClient() *epay.Client {
+	if operation_setting.PayAddress == "" || operation_setting.EpayId == "" || operation_setting.EpayKey == "" {
+		return nil
+	}
+	withUrl, err := epay.NewClient(&epay.Config{
+		PartnerID: operation_setting.EpayId,
+		Key:       operation_setting.EpayKey,
+	}, operation_setting.PayAddress)
+	if err != nil {
+		return nil
+	}
+	return withUrl
+}
+
+// EpayPayMoney prices a top-up amount for a group.
+func EpayPayMoney(amount int64, group string) float64 {
+	dAmount := decimal.NewFromInt(amount)
+	// 充值金额以“展示类型”为准：
+	// - USD/CNY: 前端传 amount 为金额单位；TOKENS: 前端传 tokens，需要换成 USD 金额
+	if operation_setting.GetQuotaDisplayType() == operation_setting.QuotaDisplayTypeTokens {
+		dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+		dAmount = dAmount.Div(dQuotaPerUnit)
+	}
+
+	topupGroupRatio := common.GetTopupGroupRatio(group)
+	if topupGroupRatio == 0 {
+		topupGroupRatio = 1
+	}
+
+	dTopupGroupRatio := decimal.NewFromFloat(topupGroupRatio)
+	dPrice := decimal.NewFromFloat(operation_setting.Price)
+	// apply optional preset discount by the original request amount (if configured), default 1.0
+	discount := 1.0
+	if ds, ok := operation_setting.GetPaymentSetting().AmountDiscount[int(amount)]; ok {
+		if ds > 0 {
+			discount = ds
+		}
+	}
+	dDiscount := decimal.NewFromFloat(discount)
+
+	payMoney := dAmount.Mul(dPrice).Mul(dTopupGroupRatio).Mul(dDiscount)
+
+	return payMoney.InexactFloat64()
+}
+
+// EpayMinTopUp is the smallest amount, in the display unit.
+func EpayMinTopUp() int64 {
+	minTopup := operation_setting.MinTopUp
+	if operation_setting.GetQuotaDisplayType() == operation_setting.QuotaDisplayTypeTokens {
+		dMinTopup := decimal.NewFromInt(int64(minTopup))
+		dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+		minTopup = int(dMinTopup.Mul(dQuotaPerUnit).IntPart())
+	}
+	return int64(minTopup)
+}
+
+func (*EpayProvider) Quote(amount int64, user *model.User) (*Quote, error) {
+	if amount < EpayMinTopUp() {
+		return nil, fmt.Errorf("充值数量不能小于 %d", EpayMinTopUp())
+	}
+	payMoney := EpayPayMoney(amount, user.Group)
+	if payMoney < 0.01 {
+		return nil, errors.New("充值金额过低")
+	}
+	// the order stores the amount in USD units
+	if operation_setting.GetQuotaDisplayType() == operation_setting.QuotaDisplayTypeTokens {
+		amount = decimal.NewFromInt(amount).Div(decimal.NewFromFloat(common.QuotaPerUnit)).IntPart()
+	}
+	return &Quote{
+		Amount:   amount,
+		Money:    payMoney,
+		PayMoney: payMoney,
+		Quota:    decimal.NewFromInt(amount).Mul(decimal.NewFromFloat(common.QuotaPerUnit)).IntPart(),
+	}, nil
+}
+
+func (*EpayProvider) CreateOrder(order *model.TopUp, req *OrderRequest) (*OrderResult, error) {
+	if !operation_setting.ContainsPayMethod(req.Method) {
+		return nil, errors.New("支付方式不存在")
+	}
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("当前管理员未配置支付信息")
+	}
+	returnUrl, _ := url.Parse(system_setting.ServerAddress + "/console/log")
+	notifyUrl, _ := url.Parse(service.GetCallbackAddress() + "/api/user/epay/notify")
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           req.Method,
+		ServiceTradeNo: order.TradeNo,
+		Name:           fmt.Sprintf("TUC%d", order.Amount),
+		Money:          strconv.FormatFloat(order.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, errors.New("拉起支付失败")
+	}
+	return &OrderResult{PayLink: uri, Params: params}, nil
+}
+
+func (*EpayProvider) VerifyCallback(r *http.Request) (*Callback, error) {
+	query := r.URL.Query()
+	params := lo.Reduce(lo.Keys(query), func(m map[string]string, key string, _ int) map[string]string {
+		m[key] = query.Get(key)
+		return m
+	}, map[string]string{})
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("易支付回调失败 未找到配置信息")
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil || !verifyInfo.VerifyStatus {
+		return nil, errors.New("易支付回调签名验证失败")
+	}
+	callback := &Callback{
+		TradeNo:         verifyInfo.ServiceTradeNo,
+		ProviderTradeNo: verifyInfo.TradeNo,
+		Ack:             []byte("success"),
+		Raw:             verifyInfo,
+	}
+	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
+		callback.Status = common.TopUpStatusSuccess
+	}
+	return callback, nil
+}
+
+// epayApi calls the merchant API of the gateway.
+func epayApi(params url.Values) (map[string]any, error) {
+	u, err := url.Parse(operation_setting.PayAddress)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, epayApiPath)
+	params.Set("pid", operation_setting.EpayId)
+	params.Set("key", operation_setting.EpayKey)
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.PostForm(u.String(), params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]any
+	if err := common.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("易支付接口返回无法解析: %s", string(body))
+	}
+	if fmt.Sprint(result["code"]) != "1" {
+		return nil, fmt.Errorf("易支付接口返回错误: %v", result["msg"])
+	}
+	return result, nil
+}
+
+func (*EpayProvider) QueryStatus(order *model.TopUp) (string, error) {
+	result, err := epayApi(url.Values{"act": {"order"}, "out_trade_no": {order.TradeNo}})
+	if err != nil {
+		return "", err
+	}
+	if fmt.Sprint(result["status"]) == "1" {
+		return common.TopUpStatusSuccess, nil
+	}
+	return common.TopUpStatusPending, nil
+}
+
+func (*EpayProvider) Refund(order *model.TopUp, money float64) (*RefundResult, error) {
+	_, err := epayApi(url.Values{
+		"act":          {"refund"},
+		"out_trade_no": {order.TradeNo},
+		"money":        {strconv.FormatFloat(money, 'f', 2, 64)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the merchant API has no refund id
+	return &RefundResult{Id: order.ProviderTradeNo, Money: money}, nil
+}
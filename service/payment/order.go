@@ -0,0 +1,184 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	cfg "github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// tradeNo lock
+var orderLocks sync.Map
+var createLock sync.Mutex
+
+// LockOrder 尝试对给定订单号加锁
+func LockOrder(tradeNo string) {
+	lock, ok := orderLocks.Load(tradeNo)
+	if !ok {
+		createLock.Lock()
+		defer createLock.Unlock()
+		lock, ok = orderLocks.Load(tradeNo)
+		if !ok {
+			lock = new(sync.Mutex)
+			orderLocks.Store(tradeNo, lock)
+		}
+	}
+	lock.(*sync.Mutex).Lock()
+}
+
+// UnlockOrder 释放给定订单号的锁
+func UnlockOrder(tradeNo string) {
+	lock, ok := orderLocks.Load(tradeNo)
+	if ok {
+		lock.(*sync.Mutex).Unlock()
+	}
+}
+
+// NewTradeNo returns a unique order number for a user.
+func NewTradeNo(userId int) string {
+	return fmt.Sprintf("USR%dNO%s%d", userId, common.GetRandomString(6), time.Now().Unix())
+}
+
+// CreateOrder prices amount, starts the payment with the provider and
+// stores the pending order.
+func CreateOrder(provider Provider, user *model.User, amount int64, method string, tradeNo string) (*model.TopUp, *OrderResult, error) {
+	quote, err := provider.Quote(amount, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	order := &model.TopUp{
+		UserId:        user.Id,
+		Amount:        quote.Amount,
+		Money:         quote.Money,
+		Quota:         quote.Quota,
+		PayMoney:      quote.PayMoney,
+		TradeNo:       tradeNo,
+		PaymentMethod: method,
+		Provider:      provider.Name(),
+		CreateTime:    time.Now().Unix(),
+		Status:        common.TopUpStatusPending,
+	}
+	result, err := provider.CreateOrder(order, &OrderRequest{User: user, Method: method})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := order.Insert(); err != nil {
+		return nil, nil, errors.New("创建订单失败")
+	}
+	return order, result, nil
+}
+
+// ApplyCallback moves the order of a verified callback to the status it
+// reports. Callbacks are idempotent.
+func ApplyCallback(callback *Callback) error {
+	if callback.TradeNo == "" {
+		return errors.New("未提供支付单号")
+	}
+	LockOrder(callback.TradeNo)
+	defer UnlockOrder(callback.TradeNo)
+	switch callback.Status {
+	case common.TopUpStatusSuccess:
+		order, credited, err := model.CompleteTopUp(callback.TradeNo, callback.ProviderTradeNo, callback.StripeCustomer)
+		if err != nil {
+			return err
+		}
+		if credited {
+			model.RecordLog(order.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", logger.LogQuota(int(order.Quota)), order.Money))
+			if cfg.GetInvoiceConfig().Enabled {
+				gopool.Go(func() {
+					if _, err := service.GenerateTopUpInvoice(order); err != nil {
+						common.SysLog(fmt.Sprintf("failed to generate invoice of order %s: %s", order.TradeNo, err.Error()))
+					}
+				})
+			}
+		}
+		return nil
+	case common.TopUpStatusExpired, common.TopUpStatusFailed:
+		return model.CloseTopUp(callback.TradeNo, callback.Status)
+	}
+	return nil
+}
+
+// SyncOrder asks the provider for the status of a pending order and applies
+// it, for payments whose callback was lost.
+func SyncOrder(tradeNo string) (*model.TopUp, error) {
+	order := model.GetTopUpByTradeNo(tradeNo)
+	if order == nil {
+		return nil, errors.New("充值订单不存在")
+	}
+	if order.Status != common.TopUpStatusPending {
+		return order, nil
+	}
+	provider, err := Get(order.TopUpProvider())
+	if err != nil {
+		return nil, err
+	}
+	status, err := provider.QueryStatus(order)
+	if err != nil {
+		return nil, err
+	}
+	if status != common.TopUpStatusPending {
+		if err := ApplyCallback(&Callback{TradeNo: tradeNo, Status: status}); err != nil {
+			return nil, err
+		}
+	}
+	return model.GetTopUpByTradeNo(tradeNo), nil
+}
+
+// RefundOrder refunds money, in money paid, of a paid order through its
+// provider, or what is left of it when money is 0, and takes the matching
+// share of the credited quota back. Orders that did not store the price paid
+// need money given; the provider reports the price.
+func RefundOrder(tradeNo string, money float64, operatorId int, reason string) (*model.PaymentRefund, error) {
+	LockOrder(tradeNo)
+	defer UnlockOrder(tradeNo)
+	order := model.GetTopUpByTradeNo(tradeNo)
+	if order == nil {
+		return nil, errors.New("充值订单不存在")
+	}
+	refundable := order.RefundableMoney()
+	if refundable <= 0 {
+		return nil, fmt.Errorf("订单状态为 %s，无可退款金额", order.Status)
+	}
+	if !order.PaidMoneyKnown() {
+		// Money holds the quota bought, not the price, so it bounds nothing
+		if money <= 0 {
+			return nil, errors.New("订单未记录支付金额，请指定退款金额")
+		}
+	} else {
+		if money == 0 {
+			money = refundable
+		}
+		if money < 0 || money > refundable {
+			return nil, fmt.Errorf("退款金额无效，可退款金额: %.2f", refundable)
+		}
+	}
+	provider, err := Get(order.TopUpProvider())
+	if err != nil {
+		return nil, err
+	}
+	result, err := provider.Refund(order, money)
+	if err != nil {
+		return nil, fmt.Errorf("支付渠道退款失败: %w", err)
+	}
+	refund, err := model.ApplyTopUpRefund(tradeNo, result.Money, result.PaidMoney, result.Id, operatorId, reason)
+	if err != nil {
+		// the money is back with the user, only the record failed
+		common.SysError(fmt.Sprintf("refund %s of order %s succeeded at %s but was not recorded: %s", result.Id, tradeNo, provider.Name(), err.Error()))
+		return nil, err
+	}
+	paid := order.PaidMoney()
+	if !order.PaidMoneyKnown() {
+		paid = result.PaidMoney
+	}
+	model.RecordLog(order.UserId, model.LogTypeRefund, fmt.Sprintf("充值订单 %s 退款成功，退款金额：%.2f（支付金额 %.2f），扣除额度: %v", tradeNo, refund.Money, paid, logger.FormatQuota(int(refund.Quota))))
+	return refund, nil
+}
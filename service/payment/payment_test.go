@@ -0,0 +1,300 @@
+package payment
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupPaymentTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.TopUp{}, &model.PaymentRefund{}, &model.Log{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	oldDB, oldLogDB := model.DB, model.LOG_DB
+	model.DB, model.LOG_DB = db, db
+	t.Cleanup(func() { model.DB, model.LOG_DB = oldDB, oldLogDB })
+	common.UsingSQLite = true
+	common.UsingPostgreSQL = false
+	common.UsingMySQL = false
+	common.RedisEnabled = false
+	return db
+}
+
+func createPaymentTestUser(t *testing.T, db *gorm.DB) *model.User {
+	t.Helper()
+	user := &model.User{Username: "payer", Password: "password", Status: common.UserStatusEnabled, Group: "default"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func userQuota(t *testing.T, db *gorm.DB, userId int) int {
+	t.Helper()
+	var user model.User
+	if err := db.First(&user, userId).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	return user.Quota
+}
+
+func newStubOrder(t *testing.T, db *gorm.DB, provider *StubProvider, amount int64) (*model.User, *model.TopUp) {
+	t.Helper()
+	Register(provider)
+	user := createPaymentTestUser(t, db)
+	order, result, err := CreateOrder(provider, user, amount, "stub", NewTradeNo(user.Id))
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	if result.PayLink == "" || order.Status != common.TopUpStatusPending || order.Provider != "stub" {
+		t.Fatalf("unexpected order %+v, result %+v", order, result)
+	}
+	return user, order
+}
+
+func TestApplyCallbackCreditsOnce(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	user, order := newStubOrder(t, db, &StubProvider{}, 10)
+
+	callback := &Callback{TradeNo: order.TradeNo, ProviderTradeNo: "p1", Status: common.TopUpStatusSuccess}
+	for i := 0; i < 2; i++ {
+		if err := ApplyCallback(callback); err != nil {
+			t.Fatalf("apply callback %d: %v", i, err)
+		}
+	}
+
+	want := int(10 * common.QuotaPerUnit)
+	if got := userQuota(t, db, user.Id); got != want {
+		t.Fatalf("quota = %d, want %d", got, want)
+	}
+	stored := model.GetTopUpByTradeNo(order.TradeNo)
+	if stored.Status != common.TopUpStatusSuccess || stored.ProviderTradeNo != "p1" || stored.Quota != int64(want) {
+		t.Fatalf("unexpected stored order %+v", stored)
+	}
+
+	// a late expiry leaves the paid order alone
+	if err := ApplyCallback(&Callback{TradeNo: order.TradeNo, Status: common.TopUpStatusExpired}); err != nil {
+		t.Fatalf("apply expiry: %v", err)
+	}
+	if stored := model.GetTopUpByTradeNo(order.TradeNo); stored.Status != common.TopUpStatusSuccess {
+		t.Fatalf("status = %s, want success", stored.Status)
+	}
+}
+
+func TestExpiredOrderCannotBePaid(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	user, order := newStubOrder(t, db, &StubProvider{}, 5)
+
+	if err := ApplyCallback(&Callback{TradeNo: order.TradeNo, Status: common.TopUpStatusExpired}); err != nil {
+		t.Fatalf("apply expiry: %v", err)
+	}
+	if err := ApplyCallback(&Callback{TradeNo: order.TradeNo, Status: common.TopUpStatusSuccess}); err == nil {
+		t.Fatal("expected paying an expired order to fail")
+	}
+	if got := userQuota(t, db, user.Id); got != 0 {
+		t.Fatalf("quota = %d, want 0", got)
+	}
+}
+
+func TestSyncOrder(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	provider := &StubProvider{}
+	user, order := newStubOrder(t, db, provider, 3)
+
+	synced, err := SyncOrder(order.TradeNo)
+	if err != nil {
+		t.Fatalf("sync pending: %v", err)
+	}
+	if synced.Status != common.TopUpStatusPending {
+		t.Fatalf("status = %s, want pending", synced.Status)
+	}
+
+	provider.Status = common.TopUpStatusSuccess
+	synced, err = SyncOrder(order.TradeNo)
+	if err != nil {
+		t.Fatalf("sync paid: %v", err)
+	}
+	if synced.Status != common.TopUpStatusSuccess {
+		t.Fatalf("status = %s, want success", synced.Status)
+	}
+	if got, want := userQuota(t, db, user.Id), int(3*common.QuotaPerUnit); got != want {
+		t.Fatalf("quota = %d, want %d", got, want)
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	provider := &StubProvider{}
+	user, order := newStubOrder(t, db, provider, 10)
+	if err := ApplyCallback(&Callback{TradeNo: order.TradeNo, Status: common.TopUpStatusSuccess}); err != nil {
+		t.Fatalf("apply callback: %v", err)
+	}
+	credited := int(10 * common.QuotaPerUnit)
+
+	refund, err := RefundOrder(order.TradeNo, 4, 1, "partial")
+	if err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if refund.Quota != int64(4*common.QuotaPerUnit) || refund.ProviderRefundId == "" {
+		t.Fatalf("unexpected refund %+v", refund)
+	}
+	stored := model.GetTopUpByTradeNo(order.TradeNo)
+	if stored.Status != common.TopUpStatusPartialRefunded || stored.RefundableMoney() != 6 {
+		t.Fatalf("unexpected order after partial refund %+v", stored)
+	}
+
+	if _, err := RefundOrder(order.TradeNo, 7, 1, "too much"); err == nil {
+		t.Fatal("expected refunding more than is left to fail")
+	}
+
+	// 0 refunds what is left
+	refund, err = RefundOrder(order.TradeNo, 0, 1, "rest")
+	if err != nil {
+		t.Fatalf("full refund: %v", err)
+	}
+	if refund.Money != 6 {
+		t.Fatalf("refund money = %v, want 6", refund.Money)
+	}
+	stored = model.GetTopUpByTradeNo(order.TradeNo)
+	if stored.Status != common.TopUpStatusRefunded || stored.RefundedQuota != int64(credited) {
+		t.Fatalf("unexpected order after full refund %+v", stored)
+	}
+	if got := userQuota(t, db, user.Id); got != 0 {
+		t.Fatalf("quota = %d, want 0", got)
+	}
+	if _, err := RefundOrder(order.TradeNo, 0, 1, "again"); err == nil {
+		t.Fatal("expected refunding a refunded order to fail")
+	}
+	if got := provider.Refunds(); len(got) != 2 {
+		t.Fatalf("provider refunds = %v, want 2", got)
+	}
+
+	refunds, err := model.GetTopUpRefunds(order.TradeNo)
+	if err != nil || len(refunds) != 2 {
+		t.Fatalf("refund records = %d, %v", len(refunds), err)
+	}
+	var logs int64
+	db.Model(&model.Log{}).Where("user_id = ? AND type = ?", user.Id, model.LogTypeRefund).Count(&logs)
+	if logs != 2 {
+		t.Fatalf("refund logs = %d, want 2", logs)
+	}
+}
+
+func TestRefundOrderProviderFailure(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	provider := &StubProvider{RefundErr: errors.New("declined")}
+	user, order := newStubOrder(t, db, provider, 2)
+	if err := ApplyCallback(&Callback{TradeNo: order.TradeNo, Status: common.TopUpStatusSuccess}); err != nil {
+		t.Fatalf("apply callback: %v", err)
+	}
+
+	if _, err := RefundOrder(order.TradeNo, 0, 1, ""); err == nil {
+		t.Fatal("expected provider failure to fail the refund")
+	}
+	if stored := model.GetTopUpByTradeNo(order.TradeNo); stored.Status != common.TopUpStatusSuccess {
+		t.Fatalf("status = %s, want success", stored.Status)
+	}
+	if got, want := userQuota(t, db, user.Id), int(2*common.QuotaPerUnit); got != want {
+		t.Fatalf("quota = %d, want %d", got, want)
+	}
+}
+
+func TestRefundOrderInMoneyPaid(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	provider := &StubProvider{}
+	user, order := newStubOrder(t, db, provider, 10)
+	// a discounted order: 10 units of quota paid with 7
+	if err := db.Model(&model.TopUp{}).Where("id = ?", order.Id).Update("pay_money", 7).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyCallback(&Callback{TradeNo: order.TradeNo, Status: common.TopUpStatusSuccess}); err != nil {
+		t.Fatalf("apply callback: %v", err)
+	}
+	if refundable := model.GetTopUpByTradeNo(order.TradeNo).RefundableMoney(); refundable != 7 {
+		t.Fatalf("refundable = %v, want the 7 paid", refundable)
+	}
+	if _, err := RefundOrder(order.TradeNo, 8, 1, "more than paid"); err == nil {
+		t.Fatal("expected refunding more than was paid to fail")
+	}
+
+	refund, err := RefundOrder(order.TradeNo, 3.5, 1, "half")
+	if err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if refund.Money != 3.5 || refund.Quota != int64(5*common.QuotaPerUnit) {
+		t.Fatalf("half the price paid should take back half the quota: %+v", refund)
+	}
+	if got := provider.Refunds(); len(got) != 1 || got[0] != 3.5 {
+		t.Fatalf("provider refunds = %v, want [3.5]", got)
+	}
+	stored := model.GetTopUpByTradeNo(order.TradeNo)
+	if stored.RefundedMoney != 3.5 || stored.RefundableMoney() != 3.5 {
+		t.Fatalf("unexpected order after partial refund %+v", stored)
+	}
+	if got, want := userQuota(t, db, user.Id), int(5*common.QuotaPerUnit); got != want {
+		t.Fatalf("quota = %d, want %d", got, want)
+	}
+}
+
+func TestRefundOrderWithoutPaidMoney(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	// an older Stripe order: money holds the 10 USD of quota bought, the 7
+	// paid is only known to Stripe
+	provider := &StubProvider{Named: "stripe", PaidMoney: 7}
+	t.Cleanup(func() { Register(&StripeProvider{}) })
+	user, order := newStubOrder(t, db, &StubProvider{}, 10)
+	Register(provider)
+	if err := ApplyCallback(&Callback{TradeNo: order.TradeNo, Status: common.TopUpStatusSuccess}); err != nil {
+		t.Fatalf("apply callback: %v", err)
+	}
+	if err := db.Model(&model.TopUp{}).Where("id = ?", order.Id).Updates(map[string]interface{}{
+		"provider": "", "payment_method": "stripe", "pay_money": 0, "quota": 0,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefundOrder(order.TradeNo, 0, 1, "all"); err == nil {
+		t.Fatal("expected a refund without an amount to fail when the price paid is unknown")
+	}
+	if got := provider.Refunds(); len(got) != 0 {
+		t.Fatalf("provider refunds = %v, want none", got)
+	}
+
+	refund, err := RefundOrder(order.TradeNo, 3.5, 1, "half")
+	if err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if refund.Money != 3.5 || refund.Quota != int64(5*common.QuotaPerUnit) {
+		t.Fatalf("half the price paid should take back half the quota: %+v", refund)
+	}
+	stored := model.GetTopUpByTradeNo(order.TradeNo)
+	if stored.PayMoney != 7 || stored.RefundedMoney != 3.5 || stored.Status != common.TopUpStatusPartialRefunded {
+		t.Fatalf("unexpected order after partial refund %+v", stored)
+	}
+	if got, want := userQuota(t, db, user.Id), int(5*common.QuotaPerUnit); got != want {
+		t.Fatalf("quota = %d, want %d", got, want)
+	}
+
+	// the price is stored now, so 0 refunds what is left
+	if refund, err = RefundOrder(order.TradeNo, 0, 1, "rest"); err != nil || refund.Money != 3.5 {
+		t.Fatalf("full refund = %+v, %v", refund, err)
+	}
+	var log model.Log
+	if err := db.Where("user_id = ? AND type = ?", user.Id, model.LogTypeRefund).Order("id desc").First(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.Content, "退款金额：3.50（支付金额 7.00）") {
+		t.Fatalf("refund log = %q", log.Content)
+	}
+}
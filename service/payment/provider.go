@@ -0,0 +1,104 @@
+// Package payment puts top-up payment gateways behind one Provider
+// interface and drives the order state machine stored in model.TopUp.
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/QuantumNous/new-api/model"
+)
+
+var ErrProviderNotFound = errors.New("支付渠道不存在或未启用")
+
+// Quote is what an order for a requested amount stores and charges.
+type Quote struct {
+	// Amount is stored on the order, in the unit the provider uses.
+	Amount int64
+	// Money is stored on the order and is the base for refunds.
+	Money float64
+	// PayMoney is the price shown to the user.
+	PayMoney float64
+	// Quota is credited when the order is paid.
+	Quota int64
+}
+
+// OrderRequest carries what a provider needs to start a payment.
+type OrderRequest struct {
+	User *model.User
+	// Method is the payment method within the provider, e.g. alipay for Epay.
+	Method string
+}
+
+// OrderResult tells the client how to pay: a link, or a form to post.
+type OrderResult struct {
+	PayLink string
+	Params  map[string]string
+}
+
+// Callback is a verified notification from a provider.
+type Callback struct {
+	TradeNo         string
+	ProviderTradeNo string
+	// Status is the order status the payment reached: success, expired or
+	// failed. An empty Status is a notification not about an order.
+	Status string
+	// StripeCustomer is the customer to remember for later Stripe payments.
+	StripeCustomer string
+	// Ack is written back to the provider, if it expects a body.
+	Ack []byte
+	// Raw is the provider's own event, for notifications not about orders.
+	Raw any
+}
+
+// Provider is a payment gateway for top-up orders.
+type Provider interface {
+	Name() string
+	// Enabled reports whether the provider is configured.
+	Enabled() bool
+	// Quote validates a requested amount and prices it for a user.
+	Quote(amount int64, user *model.User) (*Quote, error)
+	// CreateOrder starts the payment of an order not yet stored.
+	CreateOrder(order *model.TopUp, req *OrderRequest) (*OrderResult, error)
+	// VerifyCallback authenticates and parses a provider notification.
+	VerifyCallback(r *http.Request) (*Callback, error)
+	// QueryStatus asks the provider for the status of an order.
+	QueryStatus(order *model.TopUp) (string, error)
+	// Refund refunds money, a part of the price the user paid (see
+	// model.TopUp.PaidMoney), and reports what the provider refunded.
+	Refund(order *model.TopUp, money float64) (*RefundResult, error)
+}
+
+// RefundResult is a refund the provider has made.
+type RefundResult struct {
+	// Id is the provider's refund id.
+	Id string
+	// Money is the amount refunded, in money paid.
+	Money float64
+	// PaidMoney is the price paid for the order as the provider reports it,
+	// or 0 when it does not. Orders that did not store the price take it.
+	PaidMoney float64
+}
+
+var providers sync.Map
+
+// Register makes a provider available by its name, replacing any provider
+// of the same name.
+func Register(provider Provider) {
+	providers.Store(provider.Name(), provider)
+}
+
+// Get returns an enabled provider.
+func Get(name string) (Provider, error) {
+	value, ok := providers.Load(name)
+	if !ok || !value.(Provider).Enabled() {
+		return nil, ErrProviderNotFound
+	}
+	return value.(Provider), nil
+}
+
+func init() {
+	Register(&EpayProvider{})
+	Register(&StripeProvider{})
+}
@@ -0,0 +1,264 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/shopspring/decimal"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/refund"
+	"github.com/stripe/stripe-go/v81/webhook"
+	"github.com/thanhpk/randstr"
+)
+
+const PaymentMethodStripe = "stripe"
+
+// stripeMaxTopUp bounds the quantity of a Stripe top-up.
+const stripeMaxTopUp = 10000
+
+// StripeProvider pays through Stripe Checkout. The order's provider trade
+// number is the Checkout session id.
+type StripeProvider struct{}
+
+func (*StripeProvider) Name() string {
+	return PaymentMethodStripe
+}
+
+func (*StripeProvider) Enabled() bool {
+	return setting.StripeApiSecret != "" && setting.StripeWebhookSecret != "" && setting.StripePriceId != ""
+}
+
+// SetStripeKey configures the Stripe client with the API secret.
+func SetStripeKey() error {
+	if !strings.HasPrefix(setting.StripeApiSecret, "sk_") && !strings.HasPrefix(setting.StripeApiSecret, "rk_") {
+		return fmt.Errorf("无效的Stripe API密钥")
+	}
+	stripe.Key = setting.StripeApiSecret
+	return nil
+}
+
+// NewStripeTradeNo returns the client reference of a Stripe order.
+func NewStripeTradeNo(userId int) string {
+	reference := fmt.Sprintf("new-api-ref-%d-%d-%s", userId, time.Now().UnixMilli(), randstr.String(4))
+	return "ref_" + common.Sha1([]byte(reference))
+}
+
+// StripePayMoney prices a top-up amount for a group.
+func StripePayMoney(amount float64, group string) float64 {
+	originalAmount := amount
+	if operation_setting.GetQuotaDisplayType() == operation_setting.QuotaDisplayTypeTokens {
+		amount = amount / common.QuotaPerUnit
+	}
+	// Using float64 for monetary calculations is acceptable here due to the small amounts involved
+	topupGroupRatio := common.GetTopupGroupRatio(group)
+	if topupGroupRatio == 0 {
+		topupGroupRatio = 1
+	}
+	// apply optional preset discount by the original request amount (if configured), default 1.0
+	discount := 1.0
+	if ds, ok := operation_setting.GetPaymentSetting().AmountDiscount[int(originalAmount)]; ok {
+		if ds > 0 {
+			discount = ds
+		}
+	}
+	payMoney := amount * setting.StripeUnitPrice * topupGroupRatio * discount
+	return payMoney
+}
+
+// StripeMinTopUp is the smallest amount, in the display unit.
+func StripeMinTopUp() int64 {
+	minTopup := setting.StripeMinTopUp
+	if operation_setting.GetQuotaDisplayType() == operation_setting.QuotaDisplayTypeTokens {
+		minTopup = minTopup * int(common.QuotaPerUnit)
+	}
+	return int64(minTopup)
+}
+
+// stripeChargedAmount is the quota, in USD units, a Stripe order credits.
+func stripeChargedAmount(count float64, group string) float64 {
+	topUpGroupRatio := common.GetTopupGroupRatio(group)
+	if topUpGroupRatio == 0 {
+		topUpGroupRatio = 1
+	}
+
+	return count * topUpGroupRatio
+}
+
+func (*StripeProvider) Quote(amount int64, user *model.User) (*Quote, error) {
+	if amount < StripeMinTopUp() {
+		return nil, fmt.Errorf("充值数量不能小于 %d", StripeMinTopUp())
+	}
+	if amount > stripeMaxTopUp {
+		return nil, fmt.Errorf("充值数量不能大于 %d", stripeMaxTopUp)
+	}
+	payMoney := StripePayMoney(float64(amount), user.Group)
+	if payMoney <= 0.01 {
+		return nil, errors.New("充值金额过低")
+	}
+	// Stripe orders store the credited USD units in Money
+	chargedMoney := stripeChargedAmount(float64(amount), user.Group)
+	return &Quote{
+		Amount:   amount,
+		Money:    chargedMoney,
+		PayMoney: payMoney,
+		Quota:    decimal.NewFromFloat(chargedMoney).Mul(decimal.NewFromFloat(common.QuotaPerUnit)).IntPart(),
+	}, nil
+}
+
+func (*StripeProvider) CreateOrder(order *model.TopUp, req *OrderRequest) (*OrderResult, error) {
+	if err := SetStripeKey(); err != nil {
+		return nil, err
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(order.TradeNo),
+		SuccessURL:        stripe.String(system_setting.ServerAddress + "/console/log"),
+		CancelURL:         stripe.String(system_setting.ServerAddress + "/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(setting.StripePriceId),
+				Quantity: stripe.Int64(order.Amount),
+			},
+		},
+		Mode:                stripe.String(string(stripe.CheckoutSessionModePayment)),
+		AllowPromotionCodes: stripe.Bool(setting.StripePromotionCodesEnabled),
+	}
+
+	if "" == req.User.StripeCustomer {
+		if "" != req.User.Email {
+			params.CustomerEmail = stripe.String(req.User.Email)
+		}
+
+		params.CustomerCreation = stripe.String(string(stripe.CheckoutSessionCustomerCreationAlways))
+	} else {
+		params.Customer = stripe.String(req.User.StripeCustomer)
+	}
+
+	result, err := session.New(params)
+	if err != nil {
+		return nil, err
+	}
+	order.ProviderTradeNo = result.ID
+	return &OrderResult{PayLink: result.URL}, nil
+}
+
+// VerifyCallback verifies a webhook. Events not about top-up orders, such as
+// subscription events, come back with an empty Status and the event in Raw.
+func (*StripeProvider) VerifyCallback(r *http.Request) (*Callback, error) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("解析Stripe Webhook参数失败: %w", err)
+	}
+	event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), setting.StripeWebhookSecret, webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Stripe Webhook验签失败: %w", err)
+	}
+	callback := &Callback{Raw: event}
+	if event.GetObjectValue("mode") != string(stripe.CheckoutSessionModePayment) {
+		return callback, nil
+	}
+	status := event.GetObjectValue("status")
+	switch event.Type {
+	case stripe.EventTypeCheckoutSessionCompleted:
+		if status != "complete" {
+			return nil, fmt.Errorf("错误的Stripe Checkout完成状态: %s", status)
+		}
+		callback.Status = common.TopUpStatusSuccess
+	case stripe.EventTypeCheckoutSessionExpired:
+		if status != "expired" {
+			return nil, fmt.Errorf("错误的Stripe Checkout过期状态: %s", status)
+		}
+		callback.Status = common.TopUpStatusExpired
+	default:
+		return callback, nil
+	}
+	callback.TradeNo = event.GetObjectValue("client_reference_id")
+	callback.ProviderTradeNo = event.GetObjectValue("id")
+	callback.StripeCustomer = event.GetObjectValue("customer")
+	return callback, nil
+}
+
+func (*StripeProvider) QueryStatus(order *model.TopUp) (string, error) {
+	if order.ProviderTradeNo == "" {
+		return "", errors.New("订单缺少 Stripe 会话，无法查询")
+	}
+	if err := SetStripeKey(); err != nil {
+		return "", err
+	}
+	s, err := session.Get(order.ProviderTradeNo, nil)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case s.Status == stripe.CheckoutSessionStatusComplete && s.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid:
+		return common.TopUpStatusSuccess, nil
+	case s.Status == stripe.CheckoutSessionStatusExpired:
+		return common.TopUpStatusExpired, nil
+	}
+	return common.TopUpStatusPending, nil
+}
+
+// Refund refunds the share money is of the price paid from the payment
+// received, so currency conversion and rounding carry over. Orders that did
+// not store the price paid take it from the payment received.
+func (*StripeProvider) Refund(order *model.TopUp, money float64) (*RefundResult, error) {
+	if order.ProviderTradeNo == "" {
+		return nil, errors.New("订单缺少 Stripe 会话，请在 Stripe 后台退款")
+	}
+	if err := SetStripeKey(); err != nil {
+		return nil, err
+	}
+	params := &stripe.CheckoutSessionParams{}
+	params.AddExpand("payment_intent")
+	s, err := session.Get(order.ProviderTradeNo, params)
+	if err != nil {
+		return nil, err
+	}
+	if s.PaymentIntent == nil || s.PaymentIntent.AmountReceived <= 0 {
+		return nil, errors.New("Stripe 会话没有付款")
+	}
+	received := decimal.NewFromInt(s.PaymentIntent.AmountReceived)
+	paid := decimal.NewFromFloat(order.PayMoney)
+	if !order.PaidMoneyKnown() {
+		paid = received.Shift(-2)
+	}
+	refundable := paid.Sub(decimal.NewFromFloat(order.RefundedMoney))
+	refundParams := &stripe.RefundParams{
+		PaymentIntent: stripe.String(s.PaymentIntent.ID),
+		Metadata:      map[string]string{"trade_no": order.TradeNo},
+	}
+	if decimal.NewFromFloat(money).LessThan(refundable) {
+		cents := received.Mul(decimal.NewFromFloat(money)).Div(paid).IntPart()
+		if cents <= 0 {
+			return nil, errors.New("退款金额过低")
+		}
+		refundParams.Amount = stripe.Int64(cents)
+	}
+	result, err := refund.New(refundParams)
+	if err != nil {
+		return nil, err
+	}
+	refunded := refundable
+	if refundParams.Amount != nil {
+		// record what Stripe refunded, back in money paid
+		refunded = decimal.Min(decimal.NewFromInt(result.Amount).Mul(paid).Div(received).Round(2), refundable)
+	}
+	return &RefundResult{
+		Id:        result.ID,
+		Money:     refunded.InexactFloat64(),
+		PaidMoney: paid.InexactFloat64(),
+	}, nil
+}
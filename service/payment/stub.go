@@ -0,0 +1,100 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/shopspring/decimal"
+)
+
+// StubProvider is an in-memory provider for tests. Orders are priced one
+// unit of money per USD, callbacks are read from query parameters and
+// refunds always succeed unless RefundErr is set.
+type StubProvider struct {
+	// Named, when set, replaces the name "stub" to stand in for a real
+	// provider.
+	Named string
+	// Status is what QueryStatus reports.
+	Status    string
+	RefundErr error
+	// PaidMoney is the price paid Refund reports.
+	PaidMoney float64
+
+	mu      sync.Mutex
+	refunds []float64
+}
+
+func (p *StubProvider) Name() string {
+	if p.Named != "" {
+		return p.Named
+	}
+	return "stub"
+}
+
+func (*StubProvider) Enabled() bool {
+	return true
+}
+
+func (*StubProvider) Quote(amount int64, user *model.User) (*Quote, error) {
+	if amount <= 0 {
+		return nil, errors.New("充值数量必须大于 0")
+	}
+	return &Quote{
+		Amount:   amount,
+		Money:    float64(amount),
+		PayMoney: float64(amount),
+		Quota:    decimal.NewFromInt(amount).Mul(decimal.NewFromFloat(common.QuotaPerUnit)).IntPart(),
+	}, nil
+}
+
+func (*StubProvider) CreateOrder(order *model.TopUp, req *OrderRequest) (*OrderResult, error) {
+	order.ProviderTradeNo = "stub_" + order.TradeNo
+	return &OrderResult{PayLink: "https://stub.invalid/pay/" + order.TradeNo}, nil
+}
+
+// VerifyCallback reads trade_no and status from the query string.
+func (*StubProvider) VerifyCallback(r *http.Request) (*Callback, error) {
+	query := r.URL.Query()
+	if query.Get("trade_no") == "" {
+		return nil, errors.New("未提供支付单号")
+	}
+	return &Callback{
+		TradeNo:         query.Get("trade_no"),
+		ProviderTradeNo: "stub_" + query.Get("trade_no"),
+		Status:          query.Get("status"),
+		Ack:             []byte("success"),
+	}, nil
+}
+
+func (p *StubProvider) QueryStatus(order *model.TopUp) (string, error) {
+	if p.Status == "" {
+		return common.TopUpStatusPending, nil
+	}
+	return p.Status, nil
+}
+
+func (p *StubProvider) Refund(order *model.TopUp, money float64) (*RefundResult, error) {
+	if p.RefundErr != nil {
+		return nil, p.RefundErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refunds = append(p.refunds, money)
+	return &RefundResult{
+		Id:        fmt.Sprintf("stub_refund_%s_%d", order.TradeNo, len(p.refunds)),
+		Money:     money,
+		PaidMoney: p.PaidMoney,
+	}, nil
+}
+
+// Refunds returns the amounts refunded so far.
+func (p *StubProvider) Refunds() []float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]float64(nil), p.refunds...)
+}
@@ -0,0 +1,220 @@
+// Package pii finds personal data and secrets in text and replaces them with
+// masks or reversible tokens.
+package pii
+
+import (
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entity types.
+const (
+	TypeEmail      = "email"
+	TypePhone      = "phone"
+	TypeCreditCard = "credit_card"
+	TypeNationalID = "national_id"
+	TypeIBAN       = "iban"
+	TypeSecret     = "secret"
+)
+
+// AllTypes lists the detectors in priority order: when matches overlap the
+// earlier type wins.
+var AllTypes = []string{TypeSecret, TypeEmail, TypeIBAN, TypeCreditCard, TypeNationalID, TypePhone}
+
+type Entity struct {
+	Type  string
+	Start int
+	End   int
+	Value string
+}
+
+type detector struct {
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}
+
+var detectors = map[string][]detector{
+	TypeSecret: {
+		{pattern: regexp.MustCompile(`\bsk-(?:proj-|ant-)?[A-Za-z0-9_-]{20,}`)},
+		{pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+		{pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+		{pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+		{pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+		{pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	},
+	TypeEmail: {
+		{pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	},
+	TypeIBAN: {
+		{pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), valid: validIBAN},
+	},
+	TypeCreditCard: {
+		{pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: validCard},
+	},
+	TypeNationalID: {
+		// mainland China resident identity card
+		{pattern: regexp.MustCompile(`\b\d{17}[\dXx]\b`), valid: validChinaID},
+		// US social security number
+		{pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), valid: validSSN},
+	},
+	TypePhone: {
+		{pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,3}`), valid: validPhone},
+	},
+}
+
+// Detect returns the entities of the given types (all when empty) in text,
+// ordered by position and without overlaps.
+func Detect(text string, types []string) []Entity {
+	if text == "" {
+		return nil
+	}
+	enabled := make(map[string]bool, len(types))
+	for _, t := range types {
+		enabled[t] = true
+	}
+	var found []Entity
+	taken := make([]bool, len(text))
+	for _, t := range AllTypes {
+		if len(types) > 0 && !enabled[t] {
+			continue
+		}
+		for _, d := range detectors[t] {
+			for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+				start, end := loc[0], loc[1]
+				if !isolated(text, start, end) || overlaps(taken, start, end) {
+					continue
+				}
+				value := text[start:end]
+				if d.valid != nil && !d.valid(value) {
+					continue
+				}
+				for i := start; i < end; i++ {
+					taken[i] = true
+				}
+				found = append(found, Entity{Type: t, Start: start, End: end, Value: value})
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Start < found[j].Start })
+	return found
+}
+
+// Counts tallies entities by type.
+func Counts(entities []Entity) map[string]int {
+	counts := make(map[string]int)
+	for _, e := range entities {
+		counts[e.Type]++
+	}
+	return counts
+}
+
+// isolated rejects numeric matches that are part of a longer number or word.
+func isolated(text string, start, end int) bool {
+	if start > 0 && isWordByte(text[start-1]) {
+		return false
+	}
+	if end < len(text) && isWordByte(text[end]) {
+		return false
+	}
+	return true
+}
+
+func isWordByte(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_'
+}
+
+func overlaps(taken []bool, start, end int) bool {
+	for i := start; i < end; i++ {
+		if taken[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func digits(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func validCard(value string) bool {
+	number := digits(value)
+	if len(number) < 13 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func validIBAN(value string) bool {
+	iban := strings.ReplaceAll(value, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	rearranged := iban[4:] + iban[:4]
+	var b strings.Builder
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			b.WriteString(strconv.Itoa(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(b.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func validChinaID(value string) bool {
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(value[i]-'0') * weights[i]
+	}
+	check := "10X98765432"[sum%11]
+	last := value[17]
+	if last == 'x' {
+		last = 'X'
+	}
+	return last == check
+}
+
+func validSSN(value string) bool {
+	area, group, serial := value[0:3], value[4:6], value[7:11]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+func validPhone(value string) bool {
+	n := len(digits(value))
+	if n < 10 || n > 15 {
+		return false
+	}
+	// plain digit runs are only phones in international or mainland mobile form
+	if digits(value) == value {
+		return n == 11 && value[0] == '1' && value[1] >= '3'
+	}
+	return true
+}
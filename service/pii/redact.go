@@ -0,0 +1,166 @@
+package pii
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Actions a policy applies to detected entities.
+const (
+	ActionOff      = "off"
+	ActionBlock    = "block"
+	ActionMask     = "mask"
+	ActionTokenize = "tokenize"
+)
+
+const tokenPrefix = "[PII_"
+
+// maxTokenLength bounds how much trailing text a stream has to hold back.
+const maxTokenLength = 32
+
+var tokenPattern = regexp.MustCompile(`\[PII_[A-Z_]+_\d+\]`)
+
+// Mask returns the placeholder that replaces an entity in mask mode.
+func Mask(entityType string) string {
+	return "[REDACTED_" + strings.ToUpper(entityType) + "]"
+}
+
+// Vault maps reversible tokens to the values they replace. The same value
+// always gets the same token, so repeated requests in one conversation stay
+// consistent.
+type Vault struct {
+	mu       sync.Mutex
+	values   map[string]string
+	tokens   map[string]string
+	counters map[string]int
+}
+
+func NewVault() *Vault {
+	return &Vault{
+		values:   make(map[string]string),
+		tokens:   make(map[string]string),
+		counters: make(map[string]int),
+	}
+}
+
+// Token returns the token for a value, creating it on first use.
+func (v *Vault) Token(entityType string, value string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key := entityType + "\x00" + value
+	if token, ok := v.tokens[key]; ok {
+		return token
+	}
+	v.counters[entityType]++
+	token := fmt.Sprintf("%s%s_%d]", tokenPrefix, strings.ToUpper(entityType), v.counters[entityType])
+	v.tokens[key] = token
+	v.values[token] = value
+	return token
+}
+
+// Len is the number of tokens issued.
+func (v *Vault) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.values)
+}
+
+// Detokenize puts the original values back. With escapeJSON the values are
+// escaped for use inside a JSON string.
+func (v *Vault) Detokenize(text string, escapeJSON bool) string {
+	if !strings.Contains(text, tokenPrefix) {
+		return text
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return tokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		value, ok := v.values[token]
+		if !ok {
+			return token
+		}
+		if escapeJSON {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return token
+			}
+			return string(encoded[1 : len(encoded)-1])
+		}
+		return value
+	})
+}
+
+// Redact replaces the entities found in text according to action. Tokenize
+// needs a vault; other actions leave text unchanged.
+func Redact(text string, entities []Entity, action string, vault *Vault) string {
+	if len(entities) == 0 || (action != ActionMask && action != ActionTokenize) {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	last := 0
+	for _, e := range entities {
+		b.WriteString(text[last:e.Start])
+		if action == ActionTokenize && vault != nil {
+			b.WriteString(vault.Token(e.Type, e.Value))
+		} else {
+			b.WriteString(Mask(e.Type))
+		}
+		last = e.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// StreamDetokenizer restores tokens in text that arrives in pieces. A piece
+// ending in what may be the start of a token is held back until the next one.
+type StreamDetokenizer struct {
+	vault      *Vault
+	escapeJSON bool
+	held       string
+}
+
+func NewStreamDetokenizer(vault *Vault, escapeJSON bool) *StreamDetokenizer {
+	return &StreamDetokenizer{vault: vault, escapeJSON: escapeJSON}
+}
+
+// Push returns the text that can be sent now.
+func (d *StreamDetokenizer) Push(text string) string {
+	text = d.held + text
+	d.held = ""
+	if i := strings.LastIndexByte(text, '['); i >= 0 && len(text)-i < maxTokenLength && isTokenPrefix(text[i:]) {
+		d.held = text[i:]
+		text = text[:i]
+	}
+	return d.vault.Detokenize(text, d.escapeJSON)
+}
+
+// Holding reports whether text is held back for the next piece.
+func (d *StreamDetokenizer) Holding() bool {
+	return d.held != ""
+}
+
+// Flush returns whatever is still held back.
+func (d *StreamDetokenizer) Flush() string {
+	text := d.held
+	d.held = ""
+	return d.vault.Detokenize(text, d.escapeJSON)
+}
+
+// isTokenPrefix reports whether s could still grow into a complete token.
+func isTokenPrefix(s string) bool {
+	if len(s) <= len(tokenPrefix) {
+		return strings.HasPrefix(tokenPrefix, s)
+	}
+	if !strings.HasPrefix(s, tokenPrefix) {
+		return false
+	}
+	for _, r := range s[len(tokenPrefix):] {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
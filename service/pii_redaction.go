@@ -0,0 +1,162 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service/pii"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// piiPolicy returns the policy of the request's group, or false when PII
+// handling is disabled for it.
+func piiPolicy(c *gin.Context) (config.PIIPolicy, bool) {
+	cfg := config.GetPIIConfig()
+	if !cfg.Enabled {
+		return config.PIIPolicy{}, false
+	}
+	policy := cfg.PolicyForGroup(common.GetContextKeyString(c, constant.ContextKeyUsingGroup))
+	if policy.Action == "" || policy.Action == pii.ActionOff {
+		return policy, false
+	}
+	return policy, true
+}
+
+// CheckPIIBlocked reports whether the prompt has to be rejected under a block
+// policy, with the entity counts that caused it.
+func CheckPIIBlocked(c *gin.Context, text string) (map[string]int, bool) {
+	policy, ok := piiPolicy(c)
+	if !ok || policy.Action != pii.ActionBlock {
+		return nil, false
+	}
+	entities := pii.Detect(text, policy.Entities)
+	if len(entities) == 0 {
+		return nil, false
+	}
+	counts := pii.Counts(entities)
+	recordPII(c, policy.Action, counts)
+	return counts, true
+}
+
+// PIIRedactionRequired reports whether a mask or tokenize policy applies to
+// the request, so its prompt has to be rewritten before it is sent upstream.
+func PIIRedactionRequired(c *gin.Context) bool {
+	policy, ok := piiPolicy(c)
+	return ok && (policy.Action == pii.ActionMask || policy.Action == pii.ActionTokenize)
+}
+
+// RedactPIIMessages masks or tokenizes the text of the messages in place. It
+// returns the request's vault when the response has tokens to restore.
+func RedactPIIMessages(c *gin.Context, messages []dto.Message) *pii.Vault {
+	policy, ok := piiPolicy(c)
+	if !ok || (policy.Action != pii.ActionMask && policy.Action != pii.ActionTokenize) {
+		return nil
+	}
+	var vault *pii.Vault
+	if policy.Action == pii.ActionTokenize {
+		// one vault per request, so repeated turns reuse the same tokens
+		if existing, ok := common.GetContextKeyType[*pii.Vault](c, constant.ContextKeyPIIVault); ok {
+			vault = existing
+		} else {
+			vault = pii.NewVault()
+			common.SetContextKey(c, constant.ContextKeyPIIVault, vault)
+		}
+	}
+	counts := make(map[string]int)
+	redact := func(text string) string {
+		entities := pii.Detect(text, policy.Entities)
+		for t, n := range pii.Counts(entities) {
+			counts[t] += n
+		}
+		return pii.Redact(text, entities, policy.Action, vault)
+	}
+	for i := range messages {
+		message := &messages[i]
+		if message.Content == nil {
+			continue
+		}
+		if message.IsStringContent() {
+			message.SetStringContent(redact(message.StringContent()))
+			continue
+		}
+		parts := message.ParseContent()
+		changed := false
+		for j := range parts {
+			if parts[j].Type == dto.ContentTypeText && parts[j].Text != "" {
+				if redacted := redact(parts[j].Text); redacted != parts[j].Text {
+					parts[j].Text = redacted
+					changed = true
+				}
+			}
+		}
+		if changed {
+			message.SetMediaContent(parts)
+		}
+	}
+	if len(counts) > 0 {
+		recordPII(c, policy.Action, counts)
+	}
+	if vault == nil || vault.Len() == 0 {
+		return nil
+	}
+	return vault
+}
+
+// PIILogInfo describes the redactions of the request for the consume log.
+func PIILogInfo(c *gin.Context) map[string]interface{} {
+	info, ok := common.GetContextKeyType[map[string]interface{}](c, constant.ContextKeyPIIRedactions)
+	if !ok {
+		return nil
+	}
+	return info
+}
+
+func recordPII(c *gin.Context, action string, counts map[string]int) {
+	common.SetContextKey(c, constant.ContextKeyPIIRedactions, map[string]interface{}{
+		"action": action,
+		"counts": counts,
+	})
+	names := make([]string, 0, len(counts))
+	for t, n := range counts {
+		names = append(names, fmt.Sprintf("%s×%d", t, n))
+	}
+	sort.Strings(names)
+	logger.LogWarn(c, fmt.Sprintf("检测到隐私信息（%s）：%s", action, strings.Join(names, ", ")))
+
+	// a request is flagged once, however many model turns it takes
+	if !config.GetPIIConfig().FlagRequests || common.GetContextKeyBool(c, constant.ContextKeyPIIFlagged) {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyPIIFlagged, true)
+	details, _ := json.Marshal(map[string]interface{}{"pii_action": action, "pii_counts": counts})
+	flag := &model.RequestFlag{
+		RequestId:   c.GetString(common.RequestIdKey),
+		SubjectType: common.AssignmentSubjectTypeUser,
+		Reason:      common.FlagReasonPII,
+		Details:     string(details),
+	}
+	if userId := common.GetContextKeyInt(c, constant.ContextKeyUserId); userId > 0 {
+		flag.SubjectId = userId
+		flag.UserId = &userId
+	}
+	if tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId); tokenId > 0 {
+		flag.TokenId = &tokenId
+	}
+	if hours := config.GetGovernanceConfig().FlagTTLHours; hours > 0 {
+		ttl := time.Now().UTC().Add(time.Duration(hours) * time.Hour)
+		flag.TtlAt = &ttl
+	}
+	if err := model.CreateRequestFlag(flag); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to persist pii flag: %v", err))
+	}
+}
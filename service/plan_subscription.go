@@ -0,0 +1,301 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/stripe/stripe-go/v81"
+	"gorm.io/gorm"
+)
+
+// Subscription metadata keys set on the Checkout session and subscription.
+const (
+	SubscriptionMetadataUserId = "new_api_user_id"
+	SubscriptionMetadataPlanId = "new_api_plan_id"
+)
+
+// subscriptionGracePeriod keeps a plan after its paid period ends, while
+// Stripe retries the renewal payment.
+const subscriptionGracePeriod = 3 * 24 * time.Hour
+
+// HandleStripeSubscriptionEvent applies a Stripe subscription lifecycle
+// event to the plan subscription and its plan assignment. Events are
+// idempotent and may arrive in any order.
+func HandleStripeSubscriptionEvent(event stripe.Event) error {
+	if event.Data == nil {
+		return errors.New("empty event data")
+	}
+	switch event.Type {
+	case stripe.EventTypeCheckoutSessionCompleted:
+		var session stripe.CheckoutSession
+		if err := common.Unmarshal(event.Data.Raw, &session); err != nil {
+			return err
+		}
+		return subscriptionCheckoutCompleted(&session)
+	case stripe.EventTypeInvoicePaid, stripe.EventTypeInvoicePaymentFailed:
+		var invoice stripe.Invoice
+		if err := common.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			return err
+		}
+		return subscriptionInvoice(&invoice, event.Type == stripe.EventTypeInvoicePaid)
+	case stripe.EventTypeCustomerSubscriptionUpdated, stripe.EventTypeCustomerSubscriptionDeleted:
+		var subscription stripe.Subscription
+		if err := common.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			return err
+		}
+		return subscriptionChanged(&subscription)
+	}
+	return nil
+}
+
+func subscriptionCheckoutCompleted(session *stripe.CheckoutSession) error {
+	if session.Mode != stripe.CheckoutSessionModeSubscription || session.Subscription == nil {
+		return nil
+	}
+	customerId := ""
+	if session.Customer != nil {
+		customerId = session.Customer.ID
+	}
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		sub, err := findOrCreatePlanSubscription(tx, session.Subscription.ID, customerId, session.Metadata)
+		if err != nil || sub == nil {
+			return err
+		}
+		if customerId != "" {
+			// later top-ups and subscriptions reuse the Stripe customer
+			return tx.Model(&model.User{}).Where("id = ? AND stripe_customer = ?", sub.UserId, "").Update("stripe_customer", customerId).Error
+		}
+		return nil
+	})
+}
+
+// subscriptionInvoice extends the plan to the end of a paid period, or marks
+// the subscription past due when a payment failed.
+func subscriptionInvoice(invoice *stripe.Invoice, paid bool) error {
+	if invoice.Subscription == nil {
+		return nil
+	}
+	var metadata map[string]string
+	if invoice.SubscriptionDetails != nil {
+		metadata = invoice.SubscriptionDetails.Metadata
+	}
+	customerId := ""
+	if invoice.Customer != nil {
+		customerId = invoice.Customer.ID
+	}
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		sub, err := findOrCreatePlanSubscription(tx, invoice.Subscription.ID, customerId, metadata)
+		if err != nil || sub == nil {
+			return err
+		}
+		if !paid {
+			if sub.Status != model.PlanSubscriptionStatusCanceled {
+				sub.Status = model.PlanSubscriptionStatusPastDue
+			}
+			common.SysLog(fmt.Sprintf("plan subscription payment failed: id=%d, user=%d", sub.Id, sub.UserId))
+			return sub.SaveTx(tx)
+		}
+		if sub.Status == model.PlanSubscriptionStatusCanceled {
+			return nil
+		}
+		periodEnd := invoicePeriodEnd(invoice)
+		if periodEnd > sub.CurrentPeriodEnd {
+			sub.CurrentPeriodEnd = periodEnd
+		}
+		sub.Status = model.PlanSubscriptionStatusActive
+		if err := ensureSubscriptionAssignment(tx, sub); err != nil {
+			return err
+		}
+		return sub.SaveTx(tx)
+	})
+}
+
+// subscriptionChanged follows status, cancellation and plan changes of a
+// subscription.
+func subscriptionChanged(subscription *stripe.Subscription) error {
+	customerId := ""
+	if subscription.Customer != nil {
+		customerId = subscription.Customer.ID
+	}
+	plan := subscriptionPlan(subscription)
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		sub, err := findOrCreatePlanSubscription(tx, subscription.ID, customerId, subscription.Metadata)
+		if err != nil || sub == nil {
+			return err
+		}
+		sub.CancelAtPeriodEnd = subscription.CancelAtPeriodEnd
+		switch subscription.Status {
+		case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusUnpaid, stripe.SubscriptionStatusIncompleteExpired:
+			sub.Status = model.PlanSubscriptionStatusCanceled
+			if err := detachSubscriptionAssignment(tx, sub); err != nil {
+				return err
+			}
+			return sub.SaveTx(tx)
+		case stripe.SubscriptionStatusPastDue:
+			sub.Status = model.PlanSubscriptionStatusPastDue
+		case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+			if sub.Status != model.PlanSubscriptionStatusCanceled {
+				sub.Status = string(subscription.Status)
+			}
+		}
+		if sub.Status == model.PlanSubscriptionStatusCanceled {
+			return sub.SaveTx(tx)
+		}
+
+		if plan != nil && plan.Id != sub.PlanId {
+			// the price changed: Stripe prorates, the new plan applies at once
+			common.SysLog(fmt.Sprintf("plan subscription changed plan: id=%d, plan %d -> %d", sub.Id, sub.PlanId, plan.Id))
+			sub.PlanId = plan.Id
+			if err := switchSubscriptionAssignmentPlan(tx, sub); err != nil {
+				return err
+			}
+			// the new plan runs for what is already paid; the proration
+			// invoice extends it once paid
+			if sub.CurrentPeriodEnd > 0 {
+				if err := ensureSubscriptionAssignment(tx, sub); err != nil {
+					return err
+				}
+			}
+		}
+		return sub.SaveTx(tx)
+	})
+}
+
+// findOrCreatePlanSubscription loads the subscription, creating it from the
+// metadata set at checkout. It returns nil for subscriptions not created
+// through plan checkout.
+func findOrCreatePlanSubscription(tx *gorm.DB, stripeSubscriptionId string, customerId string, metadata map[string]string) (*model.PlanSubscription, error) {
+	sub, err := model.GetPlanSubscriptionByStripeIdTx(tx, stripeSubscriptionId)
+	if err != nil || sub != nil {
+		if sub != nil && sub.StripeCustomerId == "" {
+			sub.StripeCustomerId = customerId
+		}
+		return sub, err
+	}
+	userId, _ := strconv.Atoi(metadata[SubscriptionMetadataUserId])
+	planId, _ := strconv.Atoi(metadata[SubscriptionMetadataPlanId])
+	if userId == 0 || planId == 0 {
+		return nil, nil
+	}
+	sub = &model.PlanSubscription{
+		UserId:               userId,
+		PlanId:               planId,
+		StripeSubscriptionId: stripeSubscriptionId,
+		StripeCustomerId:     customerId,
+		Status:               model.PlanSubscriptionStatusIncomplete,
+	}
+	if err := sub.SaveTx(tx); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// ensureSubscriptionAssignment makes the subscription's plan assignment run
+// until the end of the paid period plus the grace period.
+func ensureSubscriptionAssignment(tx *gorm.DB, sub *model.PlanSubscription) error {
+	expiresAt := time.Unix(sub.CurrentPeriodEnd, 0).UTC().Add(subscriptionGracePeriod)
+	if sub.AssignmentId != 0 {
+		var assignment model.PlanAssignment
+		err := tx.Where("id = ?", sub.AssignmentId).First(&assignment).Error
+		if err == nil && assignment.DeactivatedAt == nil && assignment.PlanId == sub.PlanId {
+			if assignment.ExpiresAt != nil && !assignment.ExpiresAt.Before(expiresAt) {
+				return nil
+			}
+			return tx.Model(&assignment).Update("expires_at", expiresAt).Error
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	metadata := model.JSONValue(common.GetJsonString(map[string]any{
+		"source":          "stripe_subscription",
+		"subscription_id": sub.Id,
+	}))
+	assignment := &model.PlanAssignment{
+		SubjectType:    common.AssignmentSubjectTypeUser,
+		SubjectId:      sub.UserId,
+		PlanId:         sub.PlanId,
+		BillingMode:    common.BillingModePlan,
+		ExpiresAt:      &expiresAt,
+		RolloverPolicy: common.RolloverPolicyNone,
+		Metadata:       metadata,
+	}
+	if err := tx.Create(assignment).Error; err != nil {
+		return err
+	}
+	sub.AssignmentId = assignment.Id
+	common.SysLog(fmt.Sprintf("plan subscription assigned: id=%d, user=%d, plan=%d, assignment=%d", sub.Id, sub.UserId, sub.PlanId, assignment.Id))
+	return nil
+}
+
+// switchSubscriptionAssignmentPlan moves the subscription's active
+// assignment to its new plan. The assignment, and with it the usage counted
+// in the current cycle, is kept, so changing plans does not reset the
+// allowance already consumed.
+func switchSubscriptionAssignmentPlan(tx *gorm.DB, sub *model.PlanSubscription) error {
+	if sub.AssignmentId == 0 {
+		return nil
+	}
+	result := tx.Model(&model.PlanAssignment{}).
+		Where("id = ? AND deactivated_at IS NULL", sub.AssignmentId).
+		Update("plan_id", sub.PlanId)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		sub.AssignmentId = 0
+	}
+	return nil
+}
+
+func detachSubscriptionAssignment(tx *gorm.DB, sub *model.PlanSubscription) error {
+	if sub.AssignmentId == 0 {
+		return nil
+	}
+	err := tx.Model(&model.PlanAssignment{}).
+		Where("id = ? AND deactivated_at IS NULL", sub.AssignmentId).
+		Update("deactivated_at", time.Now().UTC()).Error
+	if err != nil {
+		return err
+	}
+	sub.AssignmentId = 0
+	return nil
+}
+
+// invoicePeriodEnd returns the end of the subscription period an invoice
+// pays for, taken from its subscription line.
+func invoicePeriodEnd(invoice *stripe.Invoice) int64 {
+	var end int64
+	if invoice.Lines != nil {
+		for _, line := range invoice.Lines.Data {
+			if line.Period != nil && line.Period.End > end {
+				end = line.Period.End
+			}
+		}
+	}
+	if end == 0 {
+		end = invoice.PeriodEnd
+	}
+	return end
+}
+
+// subscriptionPlan returns the plan sold through the subscription's price.
+func subscriptionPlan(subscription *stripe.Subscription) *model.Plan {
+	if subscription.Items == nil {
+		return nil
+	}
+	for _, item := range subscription.Items.Data {
+		if item.Price == nil {
+			continue
+		}
+		if plan, err := model.GetPlanByStripePriceId(item.Price.ID); err == nil {
+			return plan
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/stripe/stripe-go/v81"
+	"gorm.io/gorm"
+)
+
+// replayStripeEvent feeds a recorded webhook payload from testdata/stripe.
+func replayStripeEvent(t *testing.T, name string) {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join("testdata", "stripe", name))
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	var event stripe.Event
+	if err := common.Unmarshal(payload, &event); err != nil {
+		t.Fatalf("decode %s: %v", name, err)
+	}
+	if err := HandleStripeSubscriptionEvent(event); err != nil {
+		t.Fatalf("handle %s: %v", name, err)
+	}
+}
+
+func loadPlanSubscription(t *testing.T, db *gorm.DB) *model.PlanSubscription {
+	t.Helper()
+	var sub model.PlanSubscription
+	if err := db.Where("stripe_subscription_id = ?", "sub_1PqR8oLkdIwHu7ixS9zt4Hk1").First(&sub).Error; err != nil {
+		t.Fatalf("load subscription: %v", err)
+	}
+	return &sub
+}
+
+func loadSubscriptionAssignments(t *testing.T, db *gorm.DB) []model.PlanAssignment {
+	t.Helper()
+	var assignments []model.PlanAssignment
+	if err := db.Where("subject_type = ? AND subject_id = ?", common.AssignmentSubjectTypeUser, 4242).Order("id asc").Find(&assignments).Error; err != nil {
+		t.Fatalf("load assignments: %v", err)
+	}
+	return assignments
+}
+
+func TestStripeSubscriptionLifecycle(t *testing.T) {
+	db := setupServiceTestDB(t)
+	if err := db.AutoMigrate(&model.PlanSubscription{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	user := &model.User{Id: 4242, Username: "subscriber", Password: "password", Status: common.UserStatusEnabled}
+	basic := &model.Plan{Id: 4201, Code: "sub-basic", Name: "Basic", QuotaAmount: 1000, IsActive: true, IsPublic: true, StripePriceId: "price_1PqQzaLkdIwHu7ixBasic001"}
+	pro := &model.Plan{Id: 4202, Code: "sub-pro", Name: "Pro", QuotaAmount: 5000, IsActive: true, IsPublic: true, StripePriceId: "price_1PqR0bLkdIwHu7ixPro00002"}
+	for _, v := range []interface{}{user, basic, pro} {
+		if err := db.Create(v).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	grace := func(end int64) time.Time { return time.Unix(end, 0).Add(subscriptionGracePeriod) }
+
+	// Stripe does not order events: the first invoice arrives before the
+	// checkout session and carries the metadata itself.
+	replayStripeEvent(t, "invoice_paid_create.json")
+	replayStripeEvent(t, "checkout_session_completed.json")
+	replayStripeEvent(t, "invoice_paid_create.json")
+	sub := loadPlanSubscription(t, db)
+	if sub.UserId != 4242 || sub.PlanId != 4201 || sub.Status != model.PlanSubscriptionStatusActive || sub.CurrentPeriodEnd != 1756684800 {
+		t.Fatalf("unexpected subscription after first invoice: %+v", sub)
+	}
+	assignments := loadSubscriptionAssignments(t, db)
+	if len(assignments) != 1 || assignments[0].Id != sub.AssignmentId || assignments[0].PlanId != 4201 {
+		t.Fatalf("expected one basic assignment, got %+v", assignments)
+	}
+	if assignments[0].ExpiresAt == nil || !assignments[0].ExpiresAt.Equal(grace(1756684800)) {
+		t.Fatalf("unexpected expiry %v", assignments[0].ExpiresAt)
+	}
+	var stored model.User
+	db.First(&stored, "id = ?", 4242)
+	if stored.StripeCustomer != "cus_QhTq7mVb3yKp1N" {
+		t.Fatalf("stripe customer not stored: %q", stored.StripeCustomer)
+	}
+
+	// renewal extends the same assignment
+	replayStripeEvent(t, "invoice_paid_cycle.json")
+	assignments = loadSubscriptionAssignments(t, db)
+	if len(assignments) != 1 || !assignments[0].ExpiresAt.Equal(grace(1759276800)) {
+		t.Fatalf("renewal did not extend the assignment: %+v", assignments)
+	}
+
+	// a failed renewal keeps the plan until the paid period and grace end
+	replayStripeEvent(t, "invoice_payment_failed.json")
+	sub = loadPlanSubscription(t, db)
+	if sub.Status != model.PlanSubscriptionStatusPastDue {
+		t.Fatalf("expected past_due, got %s", sub.Status)
+	}
+	assignments = loadSubscriptionAssignments(t, db)
+	if assignments[0].DeactivatedAt != nil || !assignments[0].ExpiresAt.Equal(grace(1759276800)) {
+		t.Fatalf("failed payment changed the assignment: %+v", assignments[0])
+	}
+
+	// a plan change moves the assignment to the new plan and keeps the
+	// usage of the current cycle
+	cycleStart, cycleEnd := getCycleWindow(common.PlanCycleMonthly, time.Now())
+	if err := model.IncrementUsageCounter(sub.AssignmentId, common.PlanQuotaMetricRequests, 700, cycleStart, cycleEnd); err != nil {
+		t.Fatalf("record usage: %v", err)
+	}
+	replayStripeEvent(t, "customer_subscription_updated_plan.json")
+	sub = loadPlanSubscription(t, db)
+	assignments = loadSubscriptionAssignments(t, db)
+	if sub.PlanId != 4202 || sub.Status != model.PlanSubscriptionStatusActive || len(assignments) != 1 {
+		t.Fatalf("unexpected state after plan change: %+v, %+v", sub, assignments)
+	}
+	if assignments[0].DeactivatedAt != nil || assignments[0].PlanId != 4202 || assignments[0].Id != sub.AssignmentId || !assignments[0].ExpiresAt.Equal(grace(1759276800)) {
+		t.Fatalf("plan change did not move the assignment: %+v", assignments)
+	}
+	counter, err := model.GetUsageCounterTx(db, sub.AssignmentId, common.PlanQuotaMetricRequests, cycleStart)
+	if err != nil || counter.ConsumedAmount != 700 {
+		t.Fatalf("plan change reset the cycle's usage: %+v, %v", counter, err)
+	}
+
+	// cancellation detaches the plan
+	replayStripeEvent(t, "customer_subscription_deleted.json")
+	sub = loadPlanSubscription(t, db)
+	assignments = loadSubscriptionAssignments(t, db)
+	if sub.Status != model.PlanSubscriptionStatusCanceled || sub.AssignmentId != 0 || assignments[0].DeactivatedAt == nil {
+		t.Fatalf("cancellation did not detach: %+v, %+v", sub, assignments[0])
+	}
+
+	// late events for a canceled subscription change nothing
+	replayStripeEvent(t, "invoice_paid_cycle.json")
+	if got := loadSubscriptionAssignments(t, db); len(got) != 1 {
+		t.Fatalf("late invoice created an assignment: %+v", got)
+	}
+}
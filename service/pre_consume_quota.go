@@ -31,8 +31,19 @@ func ReturnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo) {
 // PreConsumeQuota checks if the user has enough quota to pre-consume.
 // It returns the pre-consumed quota if successful, or an error if not.
 func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
-    // New billing path (feature gated)
-    if common.BillingFeatureEnabled {
+    var member *model.OrganizationMember
+    if relayInfo.OrganizationId != 0 {
+        var apiErr *types.NewAPIError
+        member, apiErr = checkOrganizationAccess(relayInfo)
+        if apiErr != nil {
+            return apiErr
+        }
+    }
+    if apiErr := checkSpendingBudgets(c, preConsumedQuota, relayInfo); apiErr != nil {
+        return apiErr
+    }
+    // New billing path (feature gated); requests funded by the waterfall skip it
+    if common.BillingFeatureEnabled && !fundingWaterfallApplies(relayInfo) {
         prepared, err := Prepare(c, relayInfo)
         if err != nil {
             return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
@@ -52,7 +63,8 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
             }
         }
         // If plan is the chosen mode and allowance is insufficient and fallback not configured, reject early
-        if prepared.Mode == common.BillingModePlan && int64(preConsumedQuota) > prepared.Allowance {
+        fallbackConfigured := prepared.Assignment != nil && prepared.Assignment.AutoFallbackEnabled
+        if prepared.Mode == common.BillingModePlan && !fallbackConfigured && int64(preConsumedQuota) > prepared.Allowance {
             return types.NewErrorWithStatusCode(fmt.Errorf("plan allowance exhausted, remaining: %s, need: %s", logger.FormatQuota(int(prepared.Allowance)), logger.FormatQuota(preConsumedQuota)), types.ErrorCodeInsufficientUserQuota, http.StatusPaymentRequired, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
         }
         // Do not pre-consume on billing path; commit will happen after usage known
@@ -61,21 +73,33 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
     }
 
     // Legacy quota path
+    if member != nil {
+        return preConsumeOrganizationQuota(c, preConsumedQuota, relayInfo, member)
+    }
     userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
     if err != nil {
         return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
     }
-    if userQuota <= 0 {
-        return types.NewErrorWithStatusCode(fmt.Errorf("用户额度不足, 剩余额度: %s", logger.FormatQuota(userQuota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+    // With the funding waterfall, credit packages and plan allowance count towards what the user can spend
+    availableQuota := userQuota
+    ledger := fundingLedgerFor(relayInfo)
+    if ledger != nil {
+        availableQuota, err = fundingAvailable(relayInfo, ledger, userQuota)
+        if err != nil {
+            return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+        }
+    }
+    if availableQuota <= 0 {
+        return types.NewErrorWithStatusCode(fmt.Errorf("用户额度不足, 剩余额度: %s", logger.FormatQuota(availableQuota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
     }
-    if userQuota-preConsumedQuota < 0 {
-        return types.NewErrorWithStatusCode(fmt.Errorf("预扣费额度失败, 用户剩余额度: %s, 需要预扣费额度: %s", logger.FormatQuota(userQuota), logger.FormatQuota(preConsumedQuota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+    if availableQuota-preConsumedQuota < 0 {
+        return types.NewErrorWithStatusCode(fmt.Errorf("预扣费额度失败, 用户剩余额度: %s, 需要预扣费额度: %s", logger.FormatQuota(availableQuota), logger.FormatQuota(preConsumedQuota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
     }
 
     trustQuota := common.GetTrustQuota()
 
     relayInfo.UserQuota = userQuota
-    if userQuota > trustQuota {
+    if userQuota > trustQuota && fundingUsesBalance(ledger) {
         // 用户额度充足，判断令牌额度是否充足
         if !relayInfo.TokenUnlimited {
             // 非无限令牌，判断令牌额度是否充足
@@ -98,12 +122,30 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
         if err != nil {
             return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
         }
-        err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
-        if err != nil {
-            return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+        if ledger != nil {
+            err = drawFunding(relayInfo, ledger, preConsumedQuota)
+            if err != nil {
+                // return what the sources before the failing one paid
+                if refundErr := refundFunding(relayInfo, ledger, ledger.Total()); refundErr != nil {
+                    common.SysLog("error refunding funding sources: " + refundErr.Error())
+                }
+                if !relayInfo.IsPlayground {
+                    if refundErr := model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, preConsumedQuota); refundErr != nil {
+                        common.SysLog("error refunding token quota: " + refundErr.Error())
+                    }
+                }
+                return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+            }
+            logger.LogInfo(c, fmt.Sprintf("用户 %d 预扣费 %s, 扣费来源: %s", relayInfo.UserId, logger.FormatQuota(preConsumedQuota), fundingSummary(ledger)))
+        } else {
+            err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+            if err != nil {
+                return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+            }
+            logger.LogInfo(c, fmt.Sprintf("用户 %d 预扣费 %s, 预扣费后剩余额度: %s", relayInfo.UserId, logger.FormatQuota(preConsumedQuota), logger.FormatQuota(userQuota-preConsumedQuota)))
         }
-        logger.LogInfo(c, fmt.Sprintf("用户 %d 预扣费 %s, 预扣费后剩余额度: %s", relayInfo.UserId, logger.FormatQuota(preConsumedQuota), logger.FormatQuota(userQuota-preConsumedQuota)))
     }
+    recordSpendingBudgets(relayInfo, preConsumedQuota)
     relayInfo.FinalPreConsumedQuota = preConsumedQuota
     return nil
 }
@@ -364,7 +364,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 
     quotaDelta := quota - relayInfo.FinalPreConsumedQuota
 
-    if !common.BillingFeatureEnabled {
+    if !common.BillingFeatureEnabled || relayInfo.Funding != nil {
         if quotaDelta > 0 {
             logger.LogInfo(ctx, fmt.Sprintf("预扣费后补扣费：%s（实际消耗：%s，预扣费：%s）",
                 logger.FormatQuota(quotaDelta),
@@ -389,6 +389,9 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 
     other := GenerateClaudeOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio,
         cacheTokens, cacheRatio, cacheCreationTokens, cacheCreationRatio, modelPrice, relayInfo.PriceData.GroupRatioInfo.GroupSpecialRatio)
+    if outputModeration := OutputModerationLogInfo(ctx); outputModeration != nil {
+        other["output_moderation"] = outputModeration
+    }
     // annotate billing metadata for legacy log
     if common.BillingFeatureEnabled {
         other["billing_mode"] = relayInfo.BillingMode
@@ -524,13 +527,14 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 
     quotaDelta := quota - relayInfo.FinalPreConsumedQuota
 
-    if quotaDelta > 0 && !common.BillingFeatureEnabled {
+    settleQuota := !common.BillingFeatureEnabled || relayInfo.Funding != nil
+    if quotaDelta > 0 && settleQuota {
         logger.LogInfo(ctx, fmt.Sprintf("预扣费后补扣费：%s（实际消耗：%s，预扣费：%s）",
             logger.FormatQuota(quotaDelta),
             logger.FormatQuota(quota),
             logger.FormatQuota(relayInfo.FinalPreConsumedQuota),
         ))
-    } else if quotaDelta < 0 && !common.BillingFeatureEnabled {
+    } else if quotaDelta < 0 && settleQuota {
         logger.LogInfo(ctx, fmt.Sprintf("预扣费后返还扣费：%s（实际消耗：%s，预扣费：%s）",
             logger.FormatQuota(-quotaDelta),
             logger.FormatQuota(quota),
@@ -538,7 +542,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
         ))
     }
 
-    if quotaDelta != 0 && !common.BillingFeatureEnabled {
+    if quotaDelta != 0 && settleQuota {
         err := PostConsumeQuota(relayInfo, quotaDelta, relayInfo.FinalPreConsumedQuota, true)
         if err != nil {
             logger.LogError(ctx, "error consuming token remain quota: "+err.Error())
@@ -603,7 +607,18 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
-    if quota > 0 {
+    if relayInfo.OrganizationId != 0 {
+        // 组织令牌从组织额度池扣费，不发送个人额度提醒
+        err = model.DecreaseOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, quota)
+        sendEmail = false
+    } else if ledger := fundingLedgerFor(relayInfo); ledger != nil {
+        // 按扣费来源顺序扣费，退款按扣费的逆序返还到各来源
+        if quota > 0 {
+            err = drawFunding(relayInfo, ledger, quota)
+        } else {
+            err = refundFunding(relayInfo, ledger, -quota)
+        }
+    } else if quota > 0 {
         err = model.DecreaseUserQuota(relayInfo.UserId, quota)
     } else {
         err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
@@ -623,6 +638,8 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
         }
     }
 
+    recordSpendingBudgets(relayInfo, quota)
+
     if sendEmail {
         if (quota + preConsumedQuota) != 0 {
             checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/config"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const captureRedacted = "[REDACTED]"
+
+// captureSensitiveHeaders are always redacted from captured requests.
+var captureSensitiveHeaders = map[string]struct{}{
+	"authorization":          {},
+	"proxy-authorization":    {},
+	"x-api-key":              {},
+	"x-goog-api-key":         {},
+	"mj-api-secret":          {},
+	"cookie":                 {},
+	"sec-websocket-protocol": {},
+	"new-api-user":           {},
+}
+
+var (
+	captureSecretPattern    = regexp.MustCompile(`\b(sk|pk|rk)-[A-Za-z0-9_\-]{16,}`)
+	captureSecretKeyPattern = regexp.MustCompile(`(?i)("(?:api_key|apikey|access_token|secret|password)"\s*:\s*)"[^"]*"`)
+)
+
+var (
+	requestCaptureOnce    sync.Once
+	requestCaptureQueue   chan *model.HeimdallLog
+	requestCaptureDropped uint64
+	requestCaptureWritten uint64
+)
+
+// RequestCaptureStats reports the state of the in-process capture writer.
+type RequestCaptureStats struct {
+	Queued  int    `json:"queued"`
+	Written uint64 `json:"written"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// EnqueueRequestCapture hands a captured request to the async writer. It never
+// blocks the relay path: when the buffer is full the entry is dropped and counted.
+func EnqueueRequestCapture(entry *model.HeimdallLog) bool {
+	if entry == nil {
+		return false
+	}
+	requestCaptureOnce.Do(startRequestCaptureWriter)
+	select {
+	case requestCaptureQueue <- entry:
+		return true
+	default:
+		atomic.AddUint64(&requestCaptureDropped, 1)
+		return false
+	}
+}
+
+func GetRequestCaptureStats() RequestCaptureStats {
+	stats := RequestCaptureStats{
+		Written: atomic.LoadUint64(&requestCaptureWritten),
+		Dropped: atomic.LoadUint64(&requestCaptureDropped),
+	}
+	if requestCaptureQueue != nil {
+		stats.Queued = len(requestCaptureQueue)
+	}
+	return stats
+}
+
+func startRequestCaptureWriter() {
+	bufferSize := config.GetRequestCaptureConfig().BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 4096
+	}
+	requestCaptureQueue = make(chan *model.HeimdallLog, bufferSize)
+	gopool.Go(func() {
+		runRequestCaptureWriter(requestCaptureQueue, nil)
+	})
+}
+
+// requestCaptureWriterSettings returns the current batch size and flush
+// interval. The writer reads them on every cycle so that config changes
+// apply without a restart; the buffer size is fixed once the queue exists.
+func requestCaptureWriterSettings() (batchSize int, interval time.Duration) {
+	captureConfig := config.GetRequestCaptureConfig()
+	batchSize = captureConfig.BatchSize
+	if batchSize <= 0 {
+		batchSize = 200
+	}
+	interval = time.Duration(captureConfig.FlushIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return batchSize, interval
+}
+
+// runRequestCaptureWriter writes queued entries in batches until stop is
+// closed, flushing whatever is left before returning.
+func runRequestCaptureWriter(queue <-chan *model.HeimdallLog, stop <-chan struct{}) {
+	batchSize, interval := requestCaptureWriterSettings()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	batch := make([]*model.HeimdallLog, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := model.CreateHeimdallLogs(batch); err != nil {
+			atomic.AddUint64(&requestCaptureDropped, uint64(len(batch)))
+			common.SysError(fmt.Sprintf("failed to write %d captured requests: %v", len(batch), err))
+		} else {
+			atomic.AddUint64(&requestCaptureWritten, uint64(len(batch)))
+		}
+		batch = make([]*model.HeimdallLog, 0, batchSize)
+	}
+	for {
+		select {
+		case entry := <-queue:
+			batch = append(batch, entry)
+			batchSize, _ = requestCaptureWriterSettings()
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+			_, interval = requestCaptureWriterSettings()
+			timer.Reset(interval)
+		case <-stop:
+			flush()
+			return
+		}
+	}
+}
+
+// CaptureDeviceFingerprint hashes the client-identifying headers the same way
+// the Heimdall sidecar does, so rows from both sources can be correlated.
+func CaptureDeviceFingerprint(header http.Header) string {
+	components := []string{
+		header.Get("User-Agent"),
+		header.Get("Accept"),
+		header.Get("Accept-Language"),
+		header.Get("Accept-Encoding"),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(components, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// CaptureContentFingerprint returns the md5 of the raw request body, matching Heimdall.
+func CaptureContentFingerprint(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	sum := md5.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// RedactCaptureHeaders serializes request headers with credentials masked.
+func RedactCaptureHeaders(header http.Header, extra []string) string {
+	redact := make(map[string]struct{}, len(captureSensitiveHeaders)+len(extra))
+	for name := range captureSensitiveHeaders {
+		redact[name] = struct{}{}
+	}
+	for _, name := range extra {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			redact[name] = struct{}{}
+		}
+	}
+	result := make(map[string]string, len(header))
+	for name, values := range header {
+		if _, ok := redact[strings.ToLower(name)]; ok {
+			result[name] = captureRedacted
+			continue
+		}
+		result[name] = strings.Join(values, ", ")
+	}
+	data, err := common.Marshal(result)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// RedactCaptureCookies keeps cookie names only; values are never stored.
+func RedactCaptureCookies(cookies []*http.Cookie) string {
+	if len(cookies) == 0 {
+		return ""
+	}
+	result := make(map[string]string, len(cookies))
+	for _, cookie := range cookies {
+		result[cookie.Name] = captureRedacted
+	}
+	data, err := common.Marshal(result)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// RedactCaptureBody truncates the body and masks anything that looks like a credential.
+func RedactCaptureBody(body []byte, maxBytes int) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if maxBytes > 0 && len(body) > maxBytes {
+		body = body[:maxBytes]
+	}
+	text := strings.ToValidUTF8(string(body), "")
+	text = captureSecretPattern.ReplaceAllString(text, captureRedacted)
+	text = captureSecretKeyPattern.ReplaceAllString(text, `${1}"`+captureRedacted+`"`)
+	return text
+}
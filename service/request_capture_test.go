@@ -0,0 +1,129 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// runTestCaptureWriter starts a writer on its own queue and stops it, with
+// a final flush, when the test ends.
+func runTestCaptureWriter(t *testing.T, batchSize int, flushSeconds int) chan<- *model.HeimdallLog {
+	t.Helper()
+	db := setupServiceTestDB(t)
+	if err := db.AutoMigrate(&model.HeimdallLog{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	db.Where("1 = 1").Delete(&model.HeimdallLog{})
+	captureConfig := config.GetRequestCaptureConfig()
+	oldConfig := *captureConfig
+	captureConfig.BatchSize = batchSize
+	captureConfig.FlushIntervalSeconds = flushSeconds
+
+	queue := make(chan *model.HeimdallLog, 16)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runRequestCaptureWriter(queue, stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+		*captureConfig = oldConfig
+	})
+	return queue
+}
+
+func countCapturedRequests(t *testing.T) int64 {
+	t.Helper()
+	var count int64
+	if err := model.DB.Model(&model.HeimdallLog{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// waitCapturedRequests polls until want rows are written or the timeout ends.
+func waitCapturedRequests(t *testing.T, want int64, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for countCapturedRequests(t) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("captured requests = %d, want %d", countCapturedRequests(t), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func enqueueTestCaptures(queue chan<- *model.HeimdallLog, n int) {
+	for i := 0; i < n; i++ {
+		queue <- &model.HeimdallLog{RequestPath: "/v1/chat/completions", RequestMethod: "POST", Timestamp: time.Now().Unix()}
+	}
+}
+
+func TestRequestCaptureWriterBatches(t *testing.T) {
+	queue := runTestCaptureWriter(t, 3, 3600)
+
+	enqueueTestCaptures(queue, 2)
+	time.Sleep(50 * time.Millisecond)
+	if got := countCapturedRequests(t); got != 0 {
+		t.Fatalf("partial batch written early: %d rows", got)
+	}
+	enqueueTestCaptures(queue, 1)
+	waitCapturedRequests(t, 3, time.Second)
+
+	// a smaller batch size applies to the next entries without a restart
+	config.GetRequestCaptureConfig().BatchSize = 2
+	enqueueTestCaptures(queue, 1)
+	time.Sleep(50 * time.Millisecond)
+	if got := countCapturedRequests(t); got != 3 {
+		t.Fatalf("partial batch written early: %d rows", got)
+	}
+	enqueueTestCaptures(queue, 1)
+	waitCapturedRequests(t, 5, time.Second)
+}
+
+func TestRequestCaptureWriterFlushes(t *testing.T) {
+	queue := runTestCaptureWriter(t, 100, 1)
+	written := GetRequestCaptureStats().Written
+
+	enqueueTestCaptures(queue, 2)
+	waitCapturedRequests(t, 2, 3*time.Second)
+	if got := GetRequestCaptureStats().Written - written; got != 2 {
+		t.Fatalf("written counter advanced by %d, want 2", got)
+	}
+}
+
+func TestRequestCaptureWriterFlushesOnStop(t *testing.T) {
+	db := setupServiceTestDB(t)
+	if err := db.AutoMigrate(&model.HeimdallLog{}); err != nil {
+		t.Fatalf("failed to migrate tables: %v", err)
+	}
+	db.Where("1 = 1").Delete(&model.HeimdallLog{})
+	captureConfig := config.GetRequestCaptureConfig()
+	oldConfig := *captureConfig
+	defer func() { *captureConfig = oldConfig }()
+	captureConfig.BatchSize = 100
+	captureConfig.FlushIntervalSeconds = 3600
+
+	queue := make(chan *model.HeimdallLog, 16)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runRequestCaptureWriter(queue, stop)
+		close(done)
+	}()
+	enqueueTestCaptures(queue, 4)
+	// let the writer take the entries off the queue before stopping it
+	for len(queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if got := countCapturedRequests(t); got != 4 {
+		t.Fatalf("captured requests = %d, want 4", got)
+	}
+}
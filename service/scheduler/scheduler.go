@@ -39,6 +39,14 @@ func Start() context.CancelFunc {
     // Run every hour to analyze user behavior patterns
     go startTicker(ctx, time.Hour, func() { _ = RunAnomalyDetectionOnce() })
 
+    // Tokenizer calibration job
+    // Learns per-model estimate correction factors from recent consume logs
+    go startTicker(ctx, time.Hour, func() { _ = RunTokenizerCalibrationOnce() })
+
+    // Monthly statements job
+    // Issues last month's statements once invoices are enabled
+    go startTicker(ctx, time.Hour, func() { _ = RunMonthlyStatementsOnce() })
+
     return cancel
 }
 
@@ -366,3 +374,25 @@ func RunAnomalyDetectionOnce() error {
     common.SysLog("Completed scheduled anomaly detection analysis")
     return nil
 }
+
+// RunTokenizerCalibrationOnce refreshes the per-model tokenizer calibration factors
+func RunTokenizerCalibrationOnce() error {
+    _, err := service.CalibrateTokenizers()
+    if err != nil {
+        common.SysLog("tokenizer calibration failed: " + err.Error())
+    }
+    return err
+}
+
+// RunMonthlyStatementsOnce issues the statements of the last month.
+func RunMonthlyStatementsOnce() error {
+    issued, err := service.GenerateMonthlyStatements(time.Now())
+    if err != nil {
+        common.SysLog("monthly statements failed: " + err.Error())
+        return err
+    }
+    if issued > 0 {
+        common.SysLog(fmt.Sprintf("monthly statements issued: %d", issued))
+    }
+    return nil
+}
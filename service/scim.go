@@ -0,0 +1,49 @@
+package service
+
+import (
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ApplyScimGroupMappings updates a user's gateway group and organization
+// memberships from the SCIM groups they belong to. The first mapped group,
+// by group age, decides the gateway group; users without one keep theirs.
+func ApplyScimGroupMappings(userId int) error {
+	cfg := config.GetScimConfig()
+	groups, err := model.GetUserScimGroups(userId)
+	if err != nil {
+		return err
+	}
+	userGroup := ""
+	wanted := make(map[int]string)
+	for _, group := range groups {
+		for _, mapping := range cfg.MappingsFor(group.DisplayName) {
+			if userGroup == "" && mapping.Group != "" {
+				userGroup = mapping.Group
+			}
+			if mapping.OrganizationId <= 0 {
+				continue
+			}
+			if _, ok := wanted[mapping.OrganizationId]; ok {
+				continue
+			}
+			role := mapping.OrganizationRole
+			if !model.IsValidOrganizationRole(role) {
+				role = model.OrganizationRoleDeveloper
+			}
+			wanted[mapping.OrganizationId] = role
+		}
+	}
+	if userGroup != "" {
+		current, err := model.GetUserGroup(userId, true)
+		if err != nil {
+			return err
+		}
+		if current != userGroup {
+			if err := model.SetUserGroup(userId, userGroup); err != nil {
+				return err
+			}
+		}
+	}
+	return model.SyncScimOrganizationMemberships(userId, wanted, cfg.MappedOrganizations())
+}
@@ -0,0 +1,280 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Comparison is one attribute comparison of a filter, e.g. userName eq "bob".
+// Value is nil for the pr operator.
+type Comparison struct {
+	Path  string
+	Op    string
+	Value any
+}
+
+var filterOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// ParseFilter parses a filter made of comparisons joined with "and". The
+// "or" and "not" operators and grouping are not supported.
+func ParseFilter(filter string) ([]Comparison, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, BadRequest(ErrInvalidFilter, "empty filter")
+	}
+	var comparisons []Comparison
+	for i := 0; i < len(tokens); {
+		if len(comparisons) > 0 {
+			if tokens[i].quoted || !strings.EqualFold(tokens[i].text, "and") {
+				return nil, BadRequest(ErrInvalidFilter, "unsupported filter near %q", tokens[i].text)
+			}
+			i++
+		}
+		if i+1 >= len(tokens) || tokens[i].quoted {
+			return nil, BadRequest(ErrInvalidFilter, "incomplete filter")
+		}
+		comparison := Comparison{Path: tokens[i].text, Op: strings.ToLower(tokens[i+1].text)}
+		if !filterOperators[comparison.Op] || tokens[i+1].quoted {
+			return nil, BadRequest(ErrInvalidFilter, "unsupported operator %q", tokens[i+1].text)
+		}
+		i += 2
+		if comparison.Op != "pr" {
+			if i >= len(tokens) {
+				return nil, BadRequest(ErrInvalidFilter, "missing value for %s", comparison.Path)
+			}
+			comparison.Value = tokens[i].value()
+			i++
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func (t filterToken) value() any {
+	if t.quoted {
+		return t.text
+	}
+	var v any
+	if err := json.Unmarshal([]byte(t.text), &v); err == nil {
+		return v
+	}
+	return t.text
+}
+
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(filter); {
+		switch ch := filter[i]; {
+		case ch == ' ' || ch == '\t':
+			i++
+		case ch == '(' || ch == ')' || ch == '[' || ch == ']':
+			return nil, BadRequest(ErrInvalidFilter, "grouping is not supported")
+		case ch == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, BadRequest(ErrInvalidFilter, "unterminated string")
+			}
+			var text string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &text); err != nil {
+				return nil, BadRequest(ErrInvalidFilter, "invalid string %s", filter[i:end+1])
+			}
+			tokens = append(tokens, filterToken{text: text, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{text: filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// Match reports whether the generic form of a resource satisfies every
+// comparison.
+func Match(resource map[string]any, comparisons []Comparison) bool {
+	for _, comparison := range comparisons {
+		if !matchComparison(resource, comparison) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchComparison(resource map[string]any, comparison Comparison) bool {
+	root, attribute := resolveSchema(resource, comparison.Path, false)
+	if root == nil {
+		return comparison.Op == "ne"
+	}
+	values := lookupValues(root, attribute)
+	caseExact := isCaseExact(attribute)
+	switch comparison.Op {
+	case "pr":
+		for _, v := range values {
+			if v != nil && v != "" {
+				return true
+			}
+		}
+		return false
+	case "ne":
+		for _, v := range values {
+			if compareValues(v, comparison.Value, "eq", caseExact) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if compareValues(v, comparison.Value, comparison.Op, caseExact) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCaseExact(attribute string) bool {
+	last := attribute[strings.LastIndex(attribute, ".")+1:]
+	return strings.EqualFold(last, "id") || strings.EqualFold(last, "externalId")
+}
+
+// lookupValues returns every value at a dotted path, flattening arrays. A
+// complex multi-valued attribute without a sub-attribute yields its values.
+func lookupValues(resource map[string]any, path string) []any {
+	current := []any{resource}
+	for _, part := range strings.Split(path, ".") {
+		var next []any
+		for _, v := range current {
+			m, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			key, ok := findKey(m, part)
+			if !ok {
+				continue
+			}
+			if list, ok := m[key].([]any); ok {
+				next = append(next, list...)
+			} else {
+				next = append(next, m[key])
+			}
+		}
+		current = next
+	}
+	for i, v := range current {
+		if m, ok := v.(map[string]any); ok {
+			if key, ok := findKey(m, "value"); ok {
+				current[i] = m[key]
+			}
+		}
+	}
+	return current
+}
+
+func compareValues(actual any, expected any, op string, caseExact bool) bool {
+	switch a := actual.(type) {
+	case string:
+		e, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		if !caseExact {
+			a, e = strings.ToLower(a), strings.ToLower(e)
+		}
+		switch op {
+		case "eq":
+			return a == e
+		case "co":
+			return strings.Contains(a, e)
+		case "sw":
+			return strings.HasPrefix(a, e)
+		case "ew":
+			return strings.HasSuffix(a, e)
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case bool:
+		e, ok := expected.(bool)
+		return ok && op == "eq" && a == e
+	case float64:
+		e, ok := expected.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return a == e
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	}
+	return false
+}
+
+// findKey looks up an attribute name case-insensitively, as SCIM requires.
+func findKey(m map[string]any, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// resolveSchema splits a schema-qualified path. Core attributes live at the
+// top level; extension attributes live under their schema URN, which is
+// created when create is true.
+func resolveSchema(resource map[string]any, path string, create bool) (map[string]any, string) {
+	if !strings.HasPrefix(strings.ToLower(path), "urn:") {
+		return resource, path
+	}
+	idx := strings.LastIndex(path, ":")
+	schema, attribute := path[:idx], path[idx+1:]
+	if strings.EqualFold(schema, SchemaUser) || strings.EqualFold(schema, SchemaGroup) {
+		return resource, attribute
+	}
+	if key, ok := findKey(resource, schema); ok {
+		if m, ok := resource[key].(map[string]any); ok {
+			return m, attribute
+		}
+	}
+	if !create {
+		return nil, attribute
+	}
+	m := make(map[string]any)
+	resource[schema] = m
+	return m, attribute
+}
@@ -0,0 +1,252 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// ApplyPatch applies PATCH operations to the generic form of a resource.
+// Paths may be schema-qualified, dotted ("name.givenName") and may select
+// multi-valued entries with a filter ("members[value eq \"2\"]").
+func ApplyPatch(resource map[string]any, operations []PatchOperation) error {
+	for _, operation := range operations {
+		if err := applyOperation(resource, operation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOperation(resource map[string]any, operation PatchOperation) error {
+	kind := strings.ToLower(operation.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return BadRequest(ErrInvalidSyntax, "unsupported patch op %q", operation.Op)
+	}
+	if operation.Path != "" {
+		return applyPath(resource, kind, operation.Path, operation.Value)
+	}
+	if kind == "remove" {
+		return BadRequest(ErrNoTarget, "remove requires a path")
+	}
+	values, ok := operation.Value.(map[string]any)
+	if !ok {
+		return BadRequest(ErrInvalidValue, "value must be an object when path is omitted")
+	}
+	for key, value := range values {
+		if extension, ok := value.(map[string]any); ok && strings.HasPrefix(strings.ToLower(key), "urn:") {
+			for attribute, v := range extension {
+				if err := applyPath(resource, kind, key+":"+attribute, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := applyPath(resource, kind, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyPath(resource map[string]any, kind string, path string, value any) error {
+	root, rest := resolveSchema(resource, path, kind != "remove")
+	if root == nil {
+		return nil
+	}
+	attribute, filter, sub, err := splitPath(rest)
+	if err != nil {
+		return err
+	}
+	if filter == nil {
+		if sub == "" {
+			applyAttribute(root, kind, attribute, value)
+			return nil
+		}
+		key, ok := findKey(root, attribute)
+		if !ok {
+			key = attribute
+		}
+		child, _ := root[key].(map[string]any)
+		if child == nil {
+			if kind == "remove" {
+				return nil
+			}
+			child = make(map[string]any)
+			root[key] = child
+		}
+		return applyPath(child, kind, sub, value)
+	}
+
+	key, ok := findKey(root, attribute)
+	if !ok {
+		key = attribute
+	}
+	list, _ := root[key].([]any)
+	kept := make([]any, 0, len(list))
+	matched := false
+	for _, item := range list {
+		entry, ok := item.(map[string]any)
+		if !ok || !Match(entry, filter) {
+			kept = append(kept, item)
+			continue
+		}
+		matched = true
+		if kind == "remove" && sub == "" {
+			continue
+		}
+		if sub == "" {
+			mergeInto(entry, value)
+		} else {
+			applyAttribute(entry, kind, sub, value)
+		}
+		kept = append(kept, entry)
+	}
+	if !matched && kind != "remove" {
+		// like most providers, create the entry the filter describes
+		entry := make(map[string]any)
+		for _, comparison := range filter {
+			if comparison.Op == "eq" {
+				entry[comparison.Path] = comparison.Value
+			}
+		}
+		if sub == "" {
+			mergeInto(entry, value)
+		} else {
+			entry[sub] = value
+		}
+		kept = append(kept, entry)
+	}
+	root[key] = kept
+	return nil
+}
+
+// splitPath splits attr[filter].sub or attr.sub.
+func splitPath(path string) (attribute string, filter []Comparison, sub string, err error) {
+	open := strings.Index(path, "[")
+	if open < 0 {
+		attribute, sub, _ = strings.Cut(path, ".")
+		if attribute == "" {
+			return "", nil, "", BadRequest(ErrInvalidPath, "invalid path %q", path)
+		}
+		return attribute, nil, sub, nil
+	}
+	end := strings.LastIndex(path, "]")
+	if end < open {
+		return "", nil, "", BadRequest(ErrInvalidPath, "invalid path %q", path)
+	}
+	attribute = path[:open]
+	filter, err = ParseFilter(path[open+1 : end])
+	if err != nil {
+		return "", nil, "", BadRequest(ErrInvalidPath, "invalid path %q", path)
+	}
+	rest := path[end+1:]
+	if rest != "" && !strings.HasPrefix(rest, ".") {
+		return "", nil, "", BadRequest(ErrInvalidPath, "invalid path %q", path)
+	}
+	return attribute, filter, strings.TrimPrefix(rest, "."), nil
+}
+
+func applyAttribute(m map[string]any, kind string, attribute string, value any) {
+	key, exists := findKey(m, attribute)
+	if !exists {
+		key = attribute
+	}
+	switch kind {
+	case "remove":
+		if list, ok := m[key].([]any); ok && value != nil {
+			m[key] = removeValues(list, value)
+			return
+		}
+		delete(m, key)
+	case "add":
+		if list, ok := m[key].([]any); ok {
+			m[key] = appendValues(list, value)
+			return
+		}
+		if existing, ok := m[key].(map[string]any); ok {
+			if mergeInto(existing, value) {
+				return
+			}
+		}
+		m[key] = value
+	case "replace":
+		if existing, ok := m[key].(map[string]any); ok {
+			if mergeInto(existing, value) {
+				return
+			}
+		}
+		m[key] = value
+	}
+}
+
+// mergeInto copies the attributes of value into m when value is an object.
+func mergeInto(m map[string]any, value any) bool {
+	values, ok := value.(map[string]any)
+	if !ok {
+		return false
+	}
+	for k, v := range values {
+		key, ok := findKey(m, k)
+		if !ok {
+			key = k
+		}
+		m[key] = v
+	}
+	return true
+}
+
+func asList(value any) []any {
+	if list, ok := value.([]any); ok {
+		return list
+	}
+	return []any{value}
+}
+
+// entryValue identifies a multi-valued entry by its value sub-attribute.
+func entryValue(item any) string {
+	if m, ok := item.(map[string]any); ok {
+		if key, ok := findKey(m, "value"); ok {
+			return fmt.Sprint(m[key])
+		}
+	}
+	return fmt.Sprint(item)
+}
+
+func appendValues(list []any, value any) []any {
+	seen := make(map[string]bool, len(list))
+	for _, item := range list {
+		seen[entryValue(item)] = true
+	}
+	for _, item := range asList(value) {
+		if v := entryValue(item); !seen[v] {
+			seen[v] = true
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func removeValues(list []any, value any) []any {
+	removed := make(map[string]bool)
+	for _, item := range asList(value) {
+		removed[entryValue(item)] = true
+	}
+	kept := make([]any, 0, len(list))
+	for _, item := range list {
+		if !removed[entryValue(item)] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,139 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	comparisons, err := ParseFilter(`userName Eq "Bob\"s" and active eq true and title pr`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comparisons) != 3 {
+		t.Fatalf("comparisons = %+v", comparisons)
+	}
+	if comparisons[0].Path != "userName" || comparisons[0].Op != "eq" || comparisons[0].Value != `Bob"s` {
+		t.Errorf("first = %+v", comparisons[0])
+	}
+	if comparisons[1].Value != true || comparisons[2].Op != "pr" || comparisons[2].Value != nil {
+		t.Errorf("comparisons = %+v", comparisons)
+	}
+	for _, filter := range []string{`userName eq "a" or userName eq "b"`, `(userName eq "a")`, `userName eq`, `userName foo "a"`, ``} {
+		if _, err := ParseFilter(filter); err == nil {
+			t.Errorf("expected an error for %q", filter)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	user, _ := ToMap(User{
+		Schemas:    []string{SchemaUser},
+		Id:         "7",
+		ExternalId: "ABC",
+		UserName:   "Alice@example.com",
+		Emails:     []MultiValue{{Value: "alice@example.com", Type: "work"}},
+	})
+	cases := map[string]bool{
+		`username eq "alice@example.com"`:           true,
+		`externalId eq "abc"`:                       false,
+		`externalId eq "ABC"`:                       true,
+		`emails.value sw "alice"`:                   true,
+		`emails eq "alice@example.com"`:             true,
+		`displayName pr`:                            false,
+		`userName ne "bob" and id eq "7"`:           true,
+		SchemaUser + `:userName ew "example.com"`:   true,
+		SchemaEnterpriseUser + `:department eq "x"`: false,
+	}
+	for filter, want := range cases {
+		comparisons, err := ParseFilter(filter)
+		if err != nil {
+			t.Fatalf("%s: %v", filter, err)
+		}
+		if got := Match(user, comparisons); got != want {
+			t.Errorf("%s = %v, want %v", filter, got, want)
+		}
+	}
+}
+
+func decodeOperations(t *testing.T, data string) []PatchOperation {
+	t.Helper()
+	var request PatchRequest
+	if err := json.Unmarshal([]byte(data), &request); err != nil {
+		t.Fatal(err)
+	}
+	return request.Operations
+}
+
+func TestApplyPatchUser(t *testing.T) {
+	resource, _ := ToMap(User{
+		Schemas:  []string{SchemaUser},
+		UserName: "bob",
+		Name:     &Name{GivenName: "Bob"},
+		Emails:   []MultiValue{{Value: "bob@old.example", Type: "work", Primary: true}},
+	})
+	operations := decodeOperations(t, `{"Operations": [
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "value": {"name.familyName": "Smith", "displayName": "Bob Smith"}},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "bob@new.example"},
+		{"op": "add", "path": "emails[type eq \"home\"].value", "value": "bob@home.example"},
+		{"op": "add", "value": {"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"department": "R&D"}}}
+	]}`)
+	if err := ApplyPatch(resource, operations); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := FromMap(resource, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.IsActive() || user.DisplayName != "Bob Smith" || user.Name.GivenName != "Bob" || user.Name.FamilyName != "Smith" {
+		t.Errorf("user = %+v, name = %+v", user, user.Name)
+	}
+	if len(user.Emails) != 2 || user.PrimaryEmail() != "bob@new.example" || user.Emails[1].Value != "bob@home.example" {
+		t.Errorf("emails = %+v", user.Emails)
+	}
+	extension, _ := resource[SchemaEnterpriseUser].(map[string]any)
+	if extension["department"] != "R&D" {
+		t.Errorf("extension = %v", resource[SchemaEnterpriseUser])
+	}
+
+	if err := ApplyPatch(resource, []PatchOperation{{Op: "remove"}}); err == nil {
+		t.Error("expected an error for remove without a path")
+	}
+	if err := ApplyPatch(resource, []PatchOperation{{Op: "move", Path: "active"}}); err == nil {
+		t.Error("expected an error for an unknown op")
+	}
+}
+
+func TestApplyPatchGroupMembers(t *testing.T) {
+	resource, _ := ToMap(Group{
+		Schemas:     []string{SchemaGroup},
+		DisplayName: "eng",
+		Members:     []Reference{{Value: "1"}, {Value: "2"}},
+	})
+	operations := decodeOperations(t, `{"Operations": [
+		{"op": "add", "path": "members", "value": [{"value": "2"}, {"value": "3"}]},
+		{"op": "remove", "path": "members[value eq \"1\"]"},
+		{"op": "Remove", "path": "members", "value": [{"value": "3"}]},
+		{"op": "add", "path": "members", "value": [{"value": "4"}]}
+	]}`)
+	if err := ApplyPatch(resource, operations); err != nil {
+		t.Fatal(err)
+	}
+	var group Group
+	if err := FromMap(resource, &group); err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Members) != 2 || group.Members[0].Value != "2" || group.Members[1].Value != "4" {
+		t.Errorf("members = %+v", group.Members)
+	}
+
+	if err := ApplyPatch(resource, []PatchOperation{{Op: "remove", Path: "members"}}); err != nil {
+		t.Fatal(err)
+	}
+	group = Group{}
+	_ = FromMap(resource, &group)
+	if len(group.Members) != 0 {
+		t.Errorf("members = %+v", group.Members)
+	}
+}
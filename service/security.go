@@ -8,8 +8,13 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
 	governanceSvc "github.com/QuantumNous/new-api/service/governance"
+	"github.com/QuantumNous/new-api/setting/config"
 )
 
+func init() {
+	governanceSvc.SetSensitiveWordMatcher(SensitiveWordContains)
+}
+
 // CheckContentViolation checks if content violates policies
 func CheckContentViolation(content string) (bool, []string, string) {
 	if strings.TrimSpace(content) == "" {
@@ -26,7 +31,7 @@ func CheckContentViolation(content string) (bool, []string, string) {
 }
 
 // RecordViolation records a security violation
-func RecordViolation(userId int, tokenId *int, content string, keywords []string, model, ipAddress, requestId, severity, action string) error {
+func RecordViolation(userId int, tokenId *int, content string, keywords []string, modelName, ipAddress, requestId, severity, action string) error {
 	// Sanitize content snippet (limit to 500 chars, mask sensitive parts)
 	snippet := sanitizeContent(content)
 
@@ -36,7 +41,7 @@ func RecordViolation(userId int, tokenId *int, content string, keywords []string
 		ViolatedAt:      time.Now(),
 		ContentSnippet:  snippet,
 		MatchedKeywords: strings.Join(keywords, ", "),
-		Model:           model,
+		Model:           modelName,
 		IpAddress:       ipAddress,
 		RequestId:       requestId,
 		Severity:        severity,
@@ -150,12 +155,12 @@ func GetUserRedirectModel(userId int) (string, error) {
 // GetViolationRedirectModel gets global violation redirect model from options
 func GetViolationRedirectModel() string {
 	// Get from system options
-	model := model.GetOptionValue(common.OptionViolationRedirectModel)
-	if model == "" {
+	redirectModel := model.GetOptionValue(common.OptionViolationRedirectModel)
+	if redirectModel == "" {
 		// Fall back to governance config
-		model = common.GovernanceViolationFallbackAlias
+		redirectModel = config.GetGovernanceConfig().ViolationFallbackAlias
 	}
-	return model
+	return redirectModel
 }
 
 // SetViolationRedirectModel sets global violation redirect model
@@ -181,8 +186,8 @@ func GetSecuritySettings() map[string]interface{} {
 
 // UpdateSecuritySettings updates security settings
 func UpdateSecuritySettings(settings map[string]interface{}) error {
-	if model, ok := settings["violation_redirect_model"].(string); ok {
-		if err := SetViolationRedirectModel(model); err != nil {
+	if redirectModel, ok := settings["violation_redirect_model"].(string); ok {
+		if err := SetViolationRedirectModel(redirectModel); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service/servertool"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrServerToolInsufficientQuota = errors.New("user quota is not enough for this tool call")
+
+// enabled tools are cached briefly since every chat request consults them
+const serverToolCacheTTL = 30 * time.Second
+
+var (
+	serverToolClient = &http.Client{}
+
+	serverToolCacheLock sync.Mutex
+	serverToolCache     []*model.ServerTool
+	serverToolCacheTime time.Time
+
+	serverToolIndexLock sync.Mutex
+	serverToolIndexes   = make(map[int]*serverToolIndex)
+)
+
+type serverToolIndex struct {
+	version int64
+	index   *servertool.Index
+}
+
+// InvalidateServerTools drops the cached tool list and the tool's retrieval
+// index after an admin change.
+func InvalidateServerTools(toolId int) {
+	serverToolCacheLock.Lock()
+	serverToolCache = nil
+	serverToolCacheLock.Unlock()
+	serverToolIndexLock.Lock()
+	delete(serverToolIndexes, toolId)
+	serverToolIndexLock.Unlock()
+}
+
+func enabledServerTools() ([]*model.ServerTool, error) {
+	serverToolCacheLock.Lock()
+	defer serverToolCacheLock.Unlock()
+	if serverToolCache != nil && time.Since(serverToolCacheTime) < serverToolCacheTTL {
+		return serverToolCache, nil
+	}
+	tools, err := model.GetEnabledServerTools()
+	if err != nil {
+		return nil, err
+	}
+	serverToolCache = tools
+	serverToolCacheTime = time.Now()
+	return tools, nil
+}
+
+// ServerToolsForRequest returns the tools the gateway runs for this chat
+// request: auto-injected tools plus those named in server_tools, limited to
+// tools offered for the model. Client tools with the same name win.
+func ServerToolsForRequest(modelName string, request *dto.GeneralOpenAIRequest) []*model.ServerTool {
+	if !config.GetServerToolConfig().Enabled || len(request.Messages) == 0 {
+		return nil
+	}
+	tools, err := enabledServerTools()
+	if err != nil {
+		common.SysError("failed to load server tools: " + err.Error())
+		return nil
+	}
+	requested := make(map[string]bool, len(request.ServerTools))
+	for _, name := range request.ServerTools {
+		requested[name] = true
+	}
+	clientTools := make(map[string]bool, len(request.Tools))
+	for _, tool := range request.Tools {
+		clientTools[tool.Function.Name] = true
+	}
+	selected := make([]*model.ServerTool, 0)
+	for _, tool := range tools {
+		if (tool.AutoInject || requested[tool.Name]) && !clientTools[tool.Name] && tool.MatchesModel(modelName) {
+			selected = append(selected, tool)
+		}
+	}
+	return selected
+}
+
+func ServerToolParameters(tool *model.ServerTool) string {
+	if tool.Parameters != "" {
+		return tool.Parameters
+	}
+	switch tool.Type {
+	case model.ServerToolTypeCalculator:
+		return servertool.CalculatorParameters
+	case model.ServerToolTypeRetrieval:
+		return servertool.RetrievalParameters
+	}
+	return `{"type":"object","properties":{}}`
+}
+
+func ServerToolDefinition(tool *model.ServerTool) dto.ToolCallRequest {
+	description := tool.Description
+	if description == "" {
+		switch tool.Type {
+		case model.ServerToolTypeCalculator:
+			description = "Evaluate an arithmetic expression exactly."
+		case model.ServerToolTypeRetrieval:
+			description = "Search the internal knowledge base and return the most relevant passages."
+		}
+	}
+	return dto.ToolCallRequest{
+		Type: "function",
+		Function: dto.FunctionRequest{
+			Name:        tool.Name,
+			Description: description,
+			Parameters:  json.RawMessage(ServerToolParameters(tool)),
+		},
+	}
+}
+
+// ExecuteServerTool runs a tool without billing, for the loop and the admin
+// test endpoint.
+func ExecuteServerTool(ctx context.Context, tool *model.ServerTool, arguments string) (string, error) {
+	cfg := config.GetServerToolConfig()
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch tool.Type {
+	case model.ServerToolTypeHTTP:
+		return servertool.CallHTTP(ctx, serverToolClient, tool.Url, tool.HeaderMap(), arguments, cfg.MaxResultBytes)
+	case model.ServerToolTypeCalculator:
+		var args struct {
+			Expression string `json:"expression"`
+		}
+		if err := common.UnmarshalJsonStr(arguments, &args); err != nil || strings.TrimSpace(args.Expression) == "" {
+			return "", errors.New("expression is required")
+		}
+		value, err := servertool.Evaluate(args.Expression)
+		if err != nil {
+			return "", err
+		}
+		return servertool.FormatNumber(value), nil
+	case model.ServerToolTypeRetrieval:
+		var args struct {
+			Query string `json:"query"`
+		}
+		if err := common.UnmarshalJsonStr(arguments, &args); err != nil || strings.TrimSpace(args.Query) == "" {
+			return "", errors.New("query is required")
+		}
+		index, err := retrievalIndex(tool)
+		if err != nil {
+			return "", err
+		}
+		topK := tool.TopK
+		if topK <= 0 {
+			topK = 3
+		}
+		results := index.Search(args.Query, topK)
+		if len(results) == 0 {
+			return "No matching documents.", nil
+		}
+		var builder strings.Builder
+		for i, result := range results {
+			if i > 0 {
+				builder.WriteString("\n\n")
+			}
+			builder.WriteString(fmt.Sprintf("[%d] %s\n%s", i+1, result.Title, result.Content))
+		}
+		return servertool.Truncate(builder.String(), cfg.MaxResultBytes), nil
+	}
+	return "", fmt.Errorf("unsupported server tool type %q", tool.Type)
+}
+
+func retrievalIndex(tool *model.ServerTool) (*servertool.Index, error) {
+	serverToolIndexLock.Lock()
+	defer serverToolIndexLock.Unlock()
+	if cached, ok := serverToolIndexes[tool.Id]; ok && cached.version == tool.UpdatedTime {
+		return cached.index, nil
+	}
+	documents, err := model.GetServerToolDocuments(tool.Id)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]servertool.Document, 0, len(documents))
+	for _, document := range documents {
+		docs = append(docs, servertool.Document{Id: document.Id, Title: document.Title, Content: document.Content})
+	}
+	index := servertool.NewIndex(docs)
+	serverToolIndexes[tool.Id] = &serverToolIndex{version: tool.UpdatedTime, index: index}
+	return index, nil
+}
+
+// RunServerTool executes one tool call of a loop, then bills and logs it
+// under the originating request id. Failures are returned as text so the
+// model can react; they are logged but not billed.
+func RunServerTool(c *gin.Context, tool *model.ServerTool, arguments string) (output string, err error) {
+	userId := c.GetInt("id")
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	groupRatio := ratio_setting.GetGroupRatio(group)
+	relayInfo := toolCallRelayInfo(c)
+	quota := 0
+	if tool.Price > 0 {
+		quota = int(tool.Price * common.QuotaPerUnit * groupRatio)
+		if !toolCallQuotaAvailable(c, quota) {
+			return "Error: " + ErrServerToolInsufficientQuota.Error(), ErrServerToolInsufficientQuota
+		}
+		if err := checkToolCallBudgets(c, relayInfo, quota); err != nil {
+			return "Error: " + err.Error(), err
+		}
+	}
+
+	start := time.Now()
+	output, err = ExecuteServerTool(c.Request.Context(), tool, arguments)
+	logModel := "tool/" + tool.Name
+	other := map[string]interface{}{
+		"server_tool":      tool.Name,
+		"server_tool_type": tool.Type,
+		"server_tool_turn": common.GetContextKeyInt(c, constant.ContextKeyServerToolTurn),
+		"request_id":       c.GetString(common.RequestIdKey),
+		"use_time_ms":      time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		model.RecordErrorLog(c, userId, 0, logModel, c.GetString("token_name"), "服务端工具调用失败："+err.Error(),
+			c.GetInt("token_id"), int(time.Since(start).Seconds()), false, group, other)
+		return "Error: " + err.Error(), err
+	}
+	if quota > 0 {
+		if err := chargeToolCallQuota(c, relayInfo, quota); err != nil {
+			logger.LogError(c, "server tool charge failed: "+err.Error())
+		}
+	}
+	model.RecordConsumeLog(c, userId, model.RecordConsumeLogParams{
+		ModelName:      logModel,
+		TokenName:      c.GetString("token_name"),
+		Quota:          quota,
+		Content:        fmt.Sprintf("服务端工具调用，单价 %.4f，分组倍率 %.2f", tool.Price, groupRatio),
+		TokenId:        c.GetInt("token_id"),
+		UseTimeSeconds: int(time.Since(start).Seconds()),
+		Group:          group,
+		Other:          other,
+	})
+	return output, nil
+}
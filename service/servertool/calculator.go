@@ -0,0 +1,319 @@
+package servertool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const maxExpressionLength = 1024
+
+// CalculatorParameters is the schema offered for calculator tools.
+const CalculatorParameters = `{"type":"object","properties":{"expression":{"type":"string","description":"Arithmetic expression, e.g. (3.5 + 2) * sqrt(16) / 2^3. Supports + - * / % ^, parentheses, pi, e and sqrt, abs, ln, log, log2, exp, sin, cos, tan, asin, acos, atan, floor, ceil, round, min, max, pow."}},"required":["expression"]}`
+
+// Evaluate computes an arithmetic expression. "^" and "**" are right
+// associative powers; log takes an optional base and defaults to 10.
+func Evaluate(expression string) (float64, error) {
+	if len(expression) > maxExpressionLength {
+		return 0, errors.New("expression is too long")
+	}
+	p := &parser{input: expression}
+	p.next()
+	value, err := p.expression()
+	if err != nil {
+		return 0, err
+	}
+	if p.token.kind != tokenEOF {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.token.text, p.token.pos)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return value, nil
+}
+
+// FormatNumber prints integers exactly and other values with 12 significant
+// digits, which hides float noise such as 0.1+0.2.
+func FormatNumber(value float64) string {
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return strconv.FormatFloat(value, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(value, 'g', 12, 64)
+}
+
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  int
+	text  string
+	value float64
+	pos   int
+}
+
+type parser struct {
+	input string
+	pos   int
+	token token
+}
+
+func (p *parser) next() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.input) {
+		p.token = token{kind: tokenEOF, pos: start}
+		return
+	}
+	ch := p.input[p.pos]
+	switch {
+	case ch >= '0' && ch <= '9' || ch == '.':
+		for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		// exponent: 1e5, 2.5E-3
+		if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+			end := p.pos + 1
+			if end < len(p.input) && (p.input[end] == '+' || p.input[end] == '-') {
+				end++
+			}
+			if end < len(p.input) && isDigit(p.input[end]) {
+				for end < len(p.input) && isDigit(p.input[end]) {
+					end++
+				}
+				p.pos = end
+			}
+		}
+		text := p.input[start:p.pos]
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.token = token{kind: tokenOperator, text: text, pos: start}
+			return
+		}
+		p.token = token{kind: tokenNumber, text: text, value: value, pos: start}
+	case isLetter(ch):
+		for p.pos < len(p.input) && (isLetter(p.input[p.pos]) || isDigit(p.input[p.pos])) {
+			p.pos++
+		}
+		p.token = token{kind: tokenIdent, text: strings.ToLower(p.input[start:p.pos]), pos: start}
+	default:
+		p.pos++
+		if ch == '*' && p.pos < len(p.input) && p.input[p.pos] == '*' {
+			p.pos++
+			p.token = token{kind: tokenOperator, text: "^", pos: start}
+			return
+		}
+		p.token = token{kind: tokenOperator, text: string(ch), pos: start}
+	}
+}
+
+func (p *parser) isOperator(op string) bool {
+	return p.token.kind == tokenOperator && p.token.text == op
+}
+
+func (p *parser) expression() (float64, error) {
+	left, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for p.isOperator("+") || p.isOperator("-") {
+		op := p.token.text
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		if op == "+" {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) term() (float64, error) {
+	left, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	for p.isOperator("*") || p.isOperator("/") || p.isOperator("%") {
+		op := p.token.text
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "*":
+			left *= right
+		case "/":
+			if right == 0 {
+				return 0, errors.New("division by zero")
+			}
+			left /= right
+		case "%":
+			if right == 0 {
+				return 0, errors.New("modulo by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (float64, error) {
+	if p.isOperator("-") || p.isOperator("+") {
+		negative := p.token.text == "-"
+		p.next()
+		value, err := p.unary()
+		if negative {
+			value = -value
+		}
+		return value, err
+	}
+	return p.power()
+}
+
+func (p *parser) power() (float64, error) {
+	base, err := p.primary()
+	if err != nil {
+		return 0, err
+	}
+	if p.isOperator("^") {
+		p.next()
+		exponent, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exponent), nil
+	}
+	return base, nil
+}
+
+func (p *parser) primary() (float64, error) {
+	switch p.token.kind {
+	case tokenNumber:
+		value := p.token.value
+		p.next()
+		return value, nil
+	case tokenIdent:
+		name := p.token.text
+		p.next()
+		if !p.isOperator("(") {
+			switch name {
+			case "pi":
+				return math.Pi, nil
+			case "e":
+				return math.E, nil
+			}
+			return 0, fmt.Errorf("unknown constant %q", name)
+		}
+		p.next()
+		var args []float64
+		if !p.isOperator(")") {
+			for {
+				arg, err := p.expression()
+				if err != nil {
+					return 0, err
+				}
+				args = append(args, arg)
+				if !p.isOperator(",") {
+					break
+				}
+				p.next()
+			}
+		}
+		if !p.isOperator(")") {
+			return 0, fmt.Errorf("missing ')' after arguments of %s", name)
+		}
+		p.next()
+		return callFunction(name, args)
+	case tokenOperator:
+		if p.token.text == "(" {
+			p.next()
+			value, err := p.expression()
+			if err != nil {
+				return 0, err
+			}
+			if !p.isOperator(")") {
+				return 0, errors.New("missing ')'")
+			}
+			p.next()
+			return value, nil
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", p.token.text, p.token.pos)
+	}
+	return 0, errors.New("unexpected end of expression")
+}
+
+var unaryFunctions = map[string]func(float64) float64{
+	"sqrt":  math.Sqrt,
+	"abs":   math.Abs,
+	"ln":    math.Log,
+	"log2":  math.Log2,
+	"exp":   math.Exp,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"asin":  math.Asin,
+	"acos":  math.Acos,
+	"atan":  math.Atan,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"round": math.Round,
+}
+
+func callFunction(name string, args []float64) (float64, error) {
+	if fn, ok := unaryFunctions[name]; ok {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("%s takes one argument", name)
+		}
+		return fn(args[0]), nil
+	}
+	switch name {
+	case "log":
+		switch len(args) {
+		case 1:
+			return math.Log10(args[0]), nil
+		case 2:
+			return math.Log(args[0]) / math.Log(args[1]), nil
+		}
+		return 0, errors.New("log takes one or two arguments")
+	case "pow":
+		if len(args) != 2 {
+			return 0, errors.New("pow takes two arguments")
+		}
+		return math.Pow(args[0], args[1]), nil
+	case "min", "max":
+		if len(args) == 0 {
+			return 0, fmt.Errorf("%s needs at least one argument", name)
+		}
+		result := args[0]
+		for _, arg := range args[1:] {
+			if name == "min" {
+				result = math.Min(result, arg)
+			} else {
+				result = math.Max(result, arg)
+			}
+		}
+		return result, nil
+	}
+	return 0, fmt.Errorf("unknown function %q", name)
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isLetter(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_'
+}
@@ -0,0 +1,55 @@
+package servertool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// CallHTTP posts the model's arguments to an HTTP function endpoint and
+// returns the response body, truncated to maxBytes.
+func CallHTTP(ctx context.Context, client *http.Client, url string, headers map[string]string, arguments string, maxBytes int) (string, error) {
+	if arguments == "" {
+		arguments = "{}"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(arguments))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var reader io.Reader = resp.Body
+	if maxBytes > 0 {
+		reader = io.LimitReader(resp.Body, int64(maxBytes)+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("tool endpoint returned %d: %s", resp.StatusCode, Truncate(string(body), 512))
+	}
+	return Truncate(string(body), maxBytes), nil
+}
+
+// Truncate cuts s to at most maxBytes and marks the cut.
+func Truncate(s string, maxBytes int) string {
+	if maxBytes <= 0 || len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	// don't split a UTF-8 sequence
+	for cut > 0 && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + "\n...[truncated]"
+}
@@ -0,0 +1,140 @@
+package servertool
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// RetrievalParameters is the schema offered for retrieval tools.
+const RetrievalParameters = `{"type":"object","properties":{"query":{"type":"string","description":"What to look up in the knowledge base."}},"required":["query"]}`
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type Document struct {
+	Id      int    `json:"id"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+type Result struct {
+	Document
+	Score float64 `json:"score"`
+}
+
+// Index ranks documents against a query with BM25. Latin text is split into
+// lowercase words; CJK text is indexed as single characters and bigrams.
+type Index struct {
+	documents []Document
+	terms     []map[string]int
+	lengths   []int
+	average   float64
+	frequency map[string]int
+}
+
+func NewIndex(documents []Document) *Index {
+	index := &Index{
+		documents: documents,
+		terms:     make([]map[string]int, len(documents)),
+		lengths:   make([]int, len(documents)),
+		frequency: make(map[string]int),
+	}
+	total := 0
+	for i, document := range documents {
+		counts := make(map[string]int)
+		tokens := Tokenize(document.Title + "\n" + document.Content)
+		for _, term := range tokens {
+			counts[term]++
+		}
+		for term := range counts {
+			index.frequency[term]++
+		}
+		index.terms[i] = counts
+		index.lengths[i] = len(tokens)
+		total += len(tokens)
+	}
+	if len(documents) > 0 {
+		index.average = float64(total) / float64(len(documents))
+	}
+	return index
+}
+
+func (index *Index) Len() int {
+	return len(index.documents)
+}
+
+// Search returns at most limit documents with a positive score, best first.
+func (index *Index) Search(query string, limit int) []Result {
+	queryTerms := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		queryTerms[term] = true
+	}
+	if len(queryTerms) == 0 || len(index.documents) == 0 {
+		return nil
+	}
+	n := float64(len(index.documents))
+	results := make([]Result, 0)
+	for i, counts := range index.terms {
+		score := 0.0
+		for term := range queryTerms {
+			tf := float64(counts[term])
+			if tf == 0 {
+				continue
+			}
+			df := float64(index.frequency[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := 1 - bm25B + bm25B*float64(index.lengths[i])/math.Max(index.average, 1)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+		if score > 0 {
+			results = append(results, Result{Document: index.documents[i], Score: score})
+		}
+	}
+	sort.SliceStable(results, func(a, b int) bool {
+		return results[a].Score > results[b].Score
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func Tokenize(text string) []string {
+	tokens := make([]string, 0)
+	var word strings.Builder
+	var previous rune
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flush()
+			tokens = append(tokens, string(r))
+			if previous != 0 {
+				tokens = append(tokens, string(previous)+string(r))
+			}
+			previous = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+		previous = 0
+	}
+	flush()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
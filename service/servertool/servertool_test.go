@@ -0,0 +1,76 @@
+package servertool
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	cases := map[string]string{
+		"1 + 2 * 3":          "7",
+		"(3.5 + 2) * 2":      "11",
+		"2^3^2":              "512",
+		"-2 ** 2":            "-4",
+		"sqrt(16) + abs(-3)": "7",
+		"max(1, 5, 3) % 3":   "2",
+		"log(1000)":          "3",
+		"log(8, 2)":          "3",
+		"0.1 + 0.2":          "0.3",
+		"1.5e3 / 3":          "500",
+		"round(pi * 100)":    "314",
+	}
+	for expression, want := range cases {
+		value, err := Evaluate(expression)
+		if err != nil {
+			t.Errorf("Evaluate(%q): %v", expression, err)
+			continue
+		}
+		if got := FormatNumber(value); got != want {
+			t.Errorf("Evaluate(%q) = %s, want %s", expression, got, want)
+		}
+	}
+	for _, expression := range []string{"1 / 0", "2 +", "foo(1)", "(1", "x", "1 2"} {
+		if _, err := Evaluate(expression); err == nil {
+			t.Errorf("Evaluate(%q) succeeded, want error", expression)
+		}
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	index := NewIndex([]Document{
+		{Id: 1, Title: "Refund policy", Content: "Refunds are issued within 14 days of purchase."},
+		{Id: 2, Title: "Shipping", Content: "Orders ship in 2 business days."},
+		{Id: 3, Title: "退款说明", Content: "购买后七天内可以申请退款。"},
+	})
+	results := index.Search("how do refunds work", 2)
+	if len(results) != 1 || results[0].Id != 1 {
+		t.Fatalf("english search = %+v", results)
+	}
+	results = index.Search("如何退款", 3)
+	if len(results) == 0 || results[0].Id != 3 {
+		t.Fatalf("cjk search = %+v", results)
+	}
+	if results := index.Search("   ", 3); results != nil {
+		t.Errorf("empty query = %+v", results)
+	}
+}
+
+func TestCallHTTPMaxBytes(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	got, err := CallHTTP(context.Background(), server.Client(), server.URL, nil, "", 10)
+	if err != nil || got != strings.Repeat("a", 10)+"\n...[truncated]" {
+		t.Fatalf("limited call = %q (err %v)", got, err)
+	}
+	got, err = CallHTTP(context.Background(), server.Client(), server.URL, nil, "", 0)
+	if err != nil || got != body {
+		t.Fatalf("unlimited call returned %d bytes (err %v)", len(got), err)
+	}
+}
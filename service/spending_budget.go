@@ -0,0 +1,163 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service/budget"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// spendingBudgetCacheTTL bounds how long budget changes take to apply on
+// other nodes.
+const spendingBudgetCacheTTL = 30 * time.Second
+
+type spendingBudgetCacheEntry struct {
+	budgets   []*model.SpendingBudget
+	expiresAt time.Time
+}
+
+var spendingBudgetCache sync.Map
+
+// InvalidateSpendingBudgetCache drops the cached budgets of a user.
+func InvalidateSpendingBudgetCache(userId int) {
+	spendingBudgetCache.Delete(userId)
+}
+
+// getSpendingBudgets returns the enabled budgets that apply to a request:
+// the user's own budgets and those of the token.
+func getSpendingBudgets(userId int, tokenId int) ([]*model.SpendingBudget, error) {
+	var budgets []*model.SpendingBudget
+	if entry, ok := spendingBudgetCache.Load(userId); ok && time.Now().Before(entry.(*spendingBudgetCacheEntry).expiresAt) {
+		budgets = entry.(*spendingBudgetCacheEntry).budgets
+	} else {
+		var err error
+		budgets, err = model.GetUserSpendingBudgets(userId)
+		if err != nil {
+			return nil, err
+		}
+		spendingBudgetCache.Store(userId, &spendingBudgetCacheEntry{budgets: budgets, expiresAt: time.Now().Add(spendingBudgetCacheTTL)})
+	}
+	applicable := make([]*model.SpendingBudget, 0, len(budgets))
+	for _, b := range budgets {
+		if b.Enabled && (b.TokenId == 0 || b.TokenId == tokenId) {
+			applicable = append(applicable, b)
+		}
+	}
+	return applicable, nil
+}
+
+// SpendingBudgetWindow returns the current window of a budget.
+func SpendingBudgetWindow(b *model.SpendingBudget, now time.Time) (time.Time, time.Time) {
+	loc, err := budget.LoadLocation(b.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return budget.Bounds(b.Window, loc, now)
+}
+
+// checkSpendingBudgets rejects a request whose estimate would exceed a
+// budget, and reports the tightest budget in the response headers.
+func checkSpendingBudgets(c *gin.Context, estimate int, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	budgets, err := getSpendingBudgets(relayInfo.UserId, relayInfo.TokenId)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if len(budgets) == 0 {
+		return nil
+	}
+	now := time.Now()
+	var tightest *model.SpendingBudget
+	var tightestRemaining int64
+	var tightestEnd time.Time
+	for _, b := range budgets {
+		start, end := SpendingBudgetWindow(b, now)
+		usage, err := model.GetSpendingBudgetUsage(b.Id, start.Unix())
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+		}
+		remaining := b.LimitQuota - usage.SpentQuota
+		if remaining < 0 {
+			remaining = 0
+		}
+		if tightest == nil || remaining < tightestRemaining {
+			tightest, tightestRemaining, tightestEnd = b, remaining, end
+		}
+		if usage.SpentQuota+int64(estimate) > b.LimitQuota {
+			setSpendingBudgetHeaders(c, b, remaining, end)
+			return types.NewErrorWithStatusCode(fmt.Errorf("已超出消费预算, 周期: %s, 已用额度: %s, 预算: %s, 重置时间: %s", b.Window, logger.FormatQuota(int(usage.SpentQuota)), logger.FormatQuota(int(b.LimitQuota)), end.Format(time.RFC3339)), types.ErrorCodeSpendingBudgetExceeded, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+	}
+	setSpendingBudgetHeaders(c, tightest, tightestRemaining, tightestEnd)
+	return nil
+}
+
+func setSpendingBudgetHeaders(c *gin.Context, b *model.SpendingBudget, remaining int64, end time.Time) {
+	c.Header("X-Budget-Window", b.Window)
+	c.Header("X-Budget-Limit", strconv.FormatInt(b.LimitQuota, 10))
+	c.Header("X-Budget-Remaining", strconv.FormatInt(remaining, 10))
+	c.Header("X-Budget-Reset", strconv.FormatInt(end.Unix(), 10))
+}
+
+// recordSpendingBudgets adds quota, negative for refunds, to the current
+// window of every applicable budget and sends the soft threshold alerts.
+func recordSpendingBudgets(relayInfo *relaycommon.RelayInfo, quota int) {
+	if quota == 0 {
+		return
+	}
+	budgets, err := getSpendingBudgets(relayInfo.UserId, relayInfo.TokenId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to load spending budgets of user %d: %s", relayInfo.UserId, err.Error()))
+		return
+	}
+	now := time.Now()
+	for _, b := range budgets {
+		start, end := SpendingBudgetWindow(b, now)
+		if err := model.AddSpendingBudgetUsage(b.Id, start.Unix(), end.Unix(), int64(quota)); err != nil {
+			common.SysError(fmt.Sprintf("failed to record spending budget %d: %s", b.Id, err.Error()))
+			continue
+		}
+		if quota < 0 {
+			continue
+		}
+		usage, err := model.GetSpendingBudgetUsage(b.Id, start.Unix())
+		if err != nil {
+			continue
+		}
+		crossed := budget.Crossed(b.GetSoftThresholds(), b.LimitQuota, usage.SpentQuota-int64(quota), usage.SpentQuota)
+		if crossed <= usage.NotifiedThreshold {
+			continue
+		}
+		if ok, err := model.MarkSpendingBudgetNotified(b.Id, start.Unix(), crossed); err != nil || !ok {
+			continue
+		}
+		sendSpendingBudgetNotify(relayInfo, b, crossed, usage.SpentQuota, end)
+	}
+}
+
+func sendSpendingBudgetNotify(relayInfo *relaycommon.RelayInfo, b *model.SpendingBudget, threshold int, spent int64, end time.Time) {
+	userId, userEmail, userSetting := relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting
+	gopool.Go(func() {
+		subject := "消费预算提醒"
+		if b.TokenId != 0 {
+			subject = fmt.Sprintf("令牌 #%d 消费预算提醒", b.TokenId)
+		}
+		content := "{{value}}：本周期（{{value}}）已用额度 {{value}}，达到预算 {{value}} 的 {{value}}%，将于 {{value}} 重置。"
+		values := []interface{}{subject, b.Window, logger.FormatQuota(int(spent)), logger.FormatQuota(int(b.LimitQuota)), threshold, end.Format(time.RFC3339)}
+		err := NotifyUser(userId, userEmail, userSetting, dto.NewNotify(dto.NotifyTypeBudgetAlert, subject, content, values))
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to send spending budget notify to user %d: %s", userId, err.Error()))
+		}
+	})
+}
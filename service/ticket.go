@@ -23,7 +23,7 @@ func CheckTicketRateLimit(userId int) (bool, error) {
 	}
 	
 	// Get max tickets per day from option or use default
-	maxTicketsStr := common.OptionMapRWMutex.RLock()
+	common.OptionMapRWMutex.RLock()
 	maxTicketsPerDay := common.OptionMap["MaxTicketsPerUserPerDay"]
 	common.OptionMapRWMutex.RUnlock()
 	
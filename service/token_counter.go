@@ -18,6 +18,8 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service/tokenizers"
+	"github.com/QuantumNous/new-api/setting/config"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -38,6 +40,24 @@ func InitTokenEncoders() {
 	common.SysLog("initializing token encoders")
 	defaultTokenEncoder = codec.NewCl100kBase()
 	common.SysLog("token encoders initialized")
+	if _, err := ReloadTokenizers(); err != nil {
+		common.SysError("failed to load some tokenizers: " + err.Error())
+	}
+}
+
+// ReloadTokenizers (re)loads the per-family tokenizers configured in the
+// tokenizer config section. Models without a loaded tokenizer use tiktoken.
+func ReloadTokenizers() ([]tokenizers.Info, error) {
+	cfg := config.GetTokenizerConfig()
+	rules := make([]tokenizers.Rule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rules = append(rules, tokenizers.Rule{Pattern: rule.Pattern, File: rule.File})
+	}
+	infos, err := tokenizers.Load(cfg.Dir, rules)
+	if len(infos) > 0 {
+		common.SysLog(fmt.Sprintf("loaded %d tokenizer rules from %s", len(infos), cfg.Dir))
+	}
+	return infos, err
 }
 
 func getTokenEncoder(model string) tokenizer.Codec {
@@ -263,6 +283,19 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 	if info.RelayFormat == types.RelayFormatOpenAIRealtime {
 		return 0, nil
 	}
+	tkm, err := CountTokenMeta(c, meta, info.RelayFormat, info.IsStream)
+	if err != nil {
+		return 0, err
+	}
+	common.SetContextKey(c, constant.ContextKeyEstimatedPromptTokens, tkm)
+	tkm = tokenizers.Calibrate(common.GetContextKeyString(c, constant.ContextKeyOriginalModel), tkm)
+	common.SetContextKey(c, constant.ContextKeyPromptTokens, tkm)
+	return tkm, nil
+}
+
+// CountTokenMeta estimates the prompt tokens described by meta in the given
+// relay format, independently of the media token switches.
+func CountTokenMeta(c *gin.Context, meta *types.TokenCountMeta, relayFormat types.RelayFormat, isStream bool) (int, error) {
 	if meta == nil {
 		return 0, errors.New("token count meta is nil")
 	}
@@ -276,7 +309,7 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 		tkm += CountTextToken(meta.CombineText, model)
 	}
 
-	if info.RelayFormat == types.RelayFormatOpenAI {
+	if relayFormat == types.RelayFormatOpenAI {
 		tkm += meta.ToolsCount * 8
 		tkm += meta.MessagesCount * 3 // 每条消息的格式化token数量
 		tkm += meta.NameCount * 3
@@ -285,7 +318,7 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 
 	shouldFetchFiles := true
 
-	if info.RelayFormat == types.RelayFormatGemini {
+	if relayFormat == types.RelayFormatGemini {
 		shouldFetchFiles = false
 	}
 
@@ -337,10 +370,10 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 	for i, file := range meta.Files {
 		switch file.FileType {
 		case types.FileTypeImage:
-			if info.RelayFormat == types.RelayFormatGemini {
+			if relayFormat == types.RelayFormatGemini {
 				tkm += 256
 			} else {
-				token, err := getImageToken(file, model, info.IsStream)
+				token, err := getImageToken(file, model, isStream)
 				if err != nil {
 					return 0, fmt.Errorf("error counting image token, media index[%d], original data[%s], err: %v", i, file.OriginData, err)
 				}
@@ -357,7 +390,6 @@ func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relayco
 		}
 	}
 
-	common.SetContextKey(c, constant.ContextKeyPromptTokens, tkm)
 	return tkm, nil
 }
 
@@ -598,6 +630,9 @@ func CountTextToken(text string, model string) int {
 	if text == "" {
 		return 0
 	}
+	if counter := tokenizers.Resolve(model); counter != nil {
+		return counter.Count(text)
+	}
 	tokenEncoder := getTokenEncoder(model)
 	return getTokenNum(tokenEncoder, text)
 }
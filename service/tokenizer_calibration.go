@@ -0,0 +1,48 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service/tokenizers"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const tokenizerCalibrationMaxLogs = 50000
+
+// CalibrateTokenizers learns per-model factors from recent consume logs that
+// carry both the local estimate and the upstream prompt tokens, and installs
+// them for subsequent pre-consume estimates.
+func CalibrateTokenizers() (map[string]float64, error) {
+	cfg := config.GetTokenizerConfig()
+	if !cfg.CalibrationEnabled {
+		tokenizers.SetCalibration(nil)
+		return map[string]float64{}, nil
+	}
+	window := time.Duration(cfg.CalibrationWindowHours) * time.Hour
+	if window <= 0 {
+		window = 72 * time.Hour
+	}
+	logs, err := model.GetPromptEstimateLogs(time.Now().Add(-window).Unix(), tokenizerCalibrationMaxLogs)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]tokenizers.Sample, 0, len(logs))
+	for _, log := range logs {
+		other, err := common.StrToMap(log.Other)
+		if err != nil || other == nil {
+			continue
+		}
+		estimated, ok := other["estimated_prompt_tokens"].(float64)
+		if !ok {
+			continue
+		}
+		samples = append(samples, tokenizers.Sample{Model: log.ModelName, Estimated: int(estimated), Actual: log.PromptTokens})
+	}
+	factors := tokenizers.LearnFactors(samples, cfg.CalibrationMinSamples, cfg.CalibrationMinFactor, cfg.CalibrationMaxFactor)
+	tokenizers.SetCalibration(factors)
+	common.SysLog(fmt.Sprintf("tokenizer calibration: %d samples, %d models calibrated", len(samples), len(factors)))
+	return factors, nil
+}
@@ -0,0 +1,172 @@
+package tokenizers
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// preTokenizePattern approximates the GPT-2 / Llama 3 split regex without the
+// lookahead Go's regexp lacks; the difference only moves whitespace between
+// neighbouring words and barely changes counts.
+var preTokenizePattern = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)| ?\p{L}+| ?\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+const (
+	sentencePieceSpace = "▁"
+	wordCacheLimit     = 100000
+)
+
+type bpe struct {
+	vocab        map[string]int
+	ranks        map[[2]string]int
+	byteLevel    bool
+	byteFallback bool
+	special      []string
+
+	mu    sync.RWMutex
+	words map[string]int
+}
+
+func newBPE(vocab map[string]int, merges [][2]string, byteLevel bool, byteFallback bool) *bpe {
+	ranks := make(map[[2]string]int, len(merges))
+	for i, merge := range merges {
+		if _, ok := ranks[merge]; !ok {
+			ranks[merge] = i
+		}
+	}
+	return &bpe{
+		vocab:        vocab,
+		ranks:        ranks,
+		byteLevel:    byteLevel,
+		byteFallback: byteFallback,
+		words:        make(map[string]int),
+	}
+}
+
+func (b *bpe) setSpecialTokens(tokens []string) {
+	b.special = tokens
+}
+
+func (b *bpe) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	segments, count := splitSpecial(text, b.special)
+	for _, segment := range segments {
+		if b.byteLevel {
+			for _, word := range preTokenizePattern.FindAllString(segment, -1) {
+				count += b.countWord(word)
+			}
+			continue
+		}
+		// SentencePiece-style BPE: spaces become ▁ and a leading ▁ is added.
+		normalized := sentencePieceSpace + strings.ReplaceAll(segment, " ", sentencePieceSpace)
+		for _, word := range splitSentencePieceWords(normalized) {
+			count += b.countWord(word)
+		}
+	}
+	return count
+}
+
+func (b *bpe) countWord(word string) int {
+	b.mu.RLock()
+	n, ok := b.words[word]
+	b.mu.RUnlock()
+	if ok {
+		return n
+	}
+
+	var symbols []string
+	if b.byteLevel {
+		symbols = make([]string, 0, len(word))
+		for i := 0; i < len(word); i++ {
+			symbols = append(symbols, byteLevelAlphabet[word[i]])
+		}
+	} else {
+		symbols = make([]string, 0, utf8.RuneCountInString(word))
+		for _, r := range word {
+			symbols = append(symbols, string(r))
+		}
+	}
+	symbols = b.merge(symbols)
+
+	n = 0
+	for _, symbol := range symbols {
+		if _, ok := b.vocab[symbol]; ok || b.byteLevel || !b.byteFallback {
+			n++
+			continue
+		}
+		// <0xNN> byte fallback tokens
+		n += len(symbol)
+	}
+
+	b.mu.Lock()
+	if len(b.words) >= wordCacheLimit {
+		b.words = make(map[string]int)
+	}
+	b.words[word] = n
+	b.mu.Unlock()
+	return n
+}
+
+func (b *bpe) merge(symbols []string) []string {
+	for len(symbols) > 1 {
+		best := -1
+		bestRank := 0
+		for i := 0; i < len(symbols)-1; i++ {
+			rank, ok := b.ranks[[2]string{symbols[i], symbols[i+1]}]
+			if ok && (best < 0 || rank < bestRank) {
+				best = i
+				bestRank = rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		pair := [2]string{symbols[best], symbols[best+1]}
+		merged := make([]string, 0, len(symbols)-1)
+		for i := 0; i < len(symbols); i++ {
+			if i < len(symbols)-1 && symbols[i] == pair[0] && symbols[i+1] == pair[1] {
+				merged = append(merged, pair[0]+pair[1])
+				i++
+				continue
+			}
+			merged = append(merged, symbols[i])
+		}
+		symbols = merged
+	}
+	return symbols
+}
+
+// splitSentencePieceWords splits normalized text before every ▁ so each word
+// is merged independently, which is what SentencePiece BPE does in practice.
+func splitSentencePieceWords(text string) []string {
+	words := make([]string, 0, strings.Count(text, sentencePieceSpace)+1)
+	start := 0
+	for i := len(sentencePieceSpace); i < len(text); {
+		j := strings.Index(text[i:], sentencePieceSpace)
+		if j < 0 {
+			break
+		}
+		words = append(words, text[start:i+j])
+		start = i + j
+		i = start + len(sentencePieceSpace)
+	}
+	return append(words, text[start:])
+}
+
+// byteLevelAlphabet is GPT-2's bytes_to_unicode table.
+var byteLevelAlphabet = func() [256]string {
+	var table [256]string
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			table[b] = string(rune(b))
+			continue
+		}
+		table[b] = string(rune(256 + n))
+		n++
+	}
+	return table
+}()
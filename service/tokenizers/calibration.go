@@ -0,0 +1,94 @@
+package tokenizers
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Calibration factors correct systematic estimate errors per model. They are
+// learned from the ratio between upstream-reported prompt tokens and the local
+// estimate recorded with each consume log.
+var (
+	calibrationMu      sync.RWMutex
+	calibrationFactors = map[string]float64{}
+)
+
+// Sample is one estimate/actual pair for a model.
+type Sample struct {
+	Model     string
+	Estimated int
+	Actual    int
+}
+
+// SetCalibration replaces all factors.
+func SetCalibration(factors map[string]float64) {
+	copied := make(map[string]float64, len(factors))
+	for model, factor := range factors {
+		copied[model] = factor
+	}
+	calibrationMu.Lock()
+	calibrationFactors = copied
+	calibrationMu.Unlock()
+}
+
+func Calibrations() map[string]float64 {
+	calibrationMu.RLock()
+	defer calibrationMu.RUnlock()
+	copied := make(map[string]float64, len(calibrationFactors))
+	for model, factor := range calibrationFactors {
+		copied[model] = factor
+	}
+	return copied
+}
+
+func CalibrationFactor(model string) float64 {
+	calibrationMu.RLock()
+	factor, ok := calibrationFactors[model]
+	calibrationMu.RUnlock()
+	if !ok || factor <= 0 {
+		return 1
+	}
+	return factor
+}
+
+// Calibrate applies the model's factor to an estimate.
+func Calibrate(model string, tokens int) int {
+	factor := CalibrationFactor(model)
+	if factor == 1 || tokens == 0 {
+		return tokens
+	}
+	return int(math.Ceil(float64(tokens) * factor))
+}
+
+// LearnFactors computes one factor per model as the median actual/estimated
+// ratio, for models with at least minSamples samples. Factors are clamped to
+// [minFactor, maxFactor].
+func LearnFactors(samples []Sample, minSamples int, minFactor, maxFactor float64) map[string]float64 {
+	ratios := make(map[string][]float64)
+	for _, sample := range samples {
+		if sample.Model == "" || sample.Estimated <= 0 || sample.Actual <= 0 {
+			continue
+		}
+		ratios[sample.Model] = append(ratios[sample.Model], float64(sample.Actual)/float64(sample.Estimated))
+	}
+	factors := make(map[string]float64, len(ratios))
+	for model, values := range ratios {
+		if len(values) < minSamples || len(values) == 0 {
+			continue
+		}
+		sort.Float64s(values)
+		median := values[len(values)/2]
+		if len(values)%2 == 0 {
+			median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+		}
+		if minFactor > 0 {
+			median = math.Max(median, minFactor)
+		}
+		if maxFactor > 0 {
+			median = math.Min(median, maxFactor)
+		}
+		factors[model] = math.Round(median*1000) / 1000
+	}
+	return factors
+}
@@ -0,0 +1,135 @@
+package tokenizers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type hfTokenizer struct {
+	Model struct {
+		Type         string            `json:"type"`
+		Vocab        json.RawMessage   `json:"vocab"`
+		Merges       []json.RawMessage `json:"merges"`
+		ByteFallback bool              `json:"byte_fallback"`
+		UnkId        *int              `json:"unk_id"`
+	} `json:"model"`
+	PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+	Decoder      json.RawMessage `json:"decoder"`
+	AddedTokens  []struct {
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+}
+
+func parseHuggingFaceTokenizer(data []byte) (Counter, Info, error) {
+	var raw hfTokenizer
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, Info{}, fmt.Errorf("invalid tokenizer.json: %w", err)
+	}
+	switch raw.Model.Type {
+	case "BPE", "":
+		vocab := make(map[string]int)
+		if err := json.Unmarshal(raw.Model.Vocab, &vocab); err != nil {
+			return nil, Info{}, fmt.Errorf("invalid BPE vocab: %w", err)
+		}
+		merges := make([][2]string, 0, len(raw.Model.Merges))
+		for _, m := range raw.Model.Merges {
+			pair, err := parseMerge(m)
+			if err != nil {
+				return nil, Info{}, err
+			}
+			merges = append(merges, pair)
+		}
+		// Byte-level BPE (GPT-2 style: Llama 3, Qwen, DeepSeek, GLM-4) is marked
+		// by a ByteLevel pre-tokenizer; otherwise assume SentencePiece-style BPE.
+		byteLevel := bytes.Contains(raw.PreTokenizer, []byte(`"ByteLevel"`)) ||
+			bytes.Contains(raw.Decoder, []byte(`"ByteLevel"`))
+		counter := newBPE(vocab, merges, byteLevel, raw.Model.ByteFallback)
+		counter.setSpecialTokens(raw.specialTokens())
+		kind := "bpe"
+		if byteLevel {
+			kind = "bpe-bytelevel"
+		}
+		return counter, Info{Kind: kind, Vocab: len(vocab)}, nil
+	case "Unigram":
+		var vocab [][2]json.RawMessage
+		if err := json.Unmarshal(raw.Model.Vocab, &vocab); err != nil {
+			return nil, Info{}, fmt.Errorf("invalid Unigram vocab: %w", err)
+		}
+		pieces := make(map[string]float64, len(vocab))
+		for _, item := range vocab {
+			var piece string
+			var score float64
+			if err := json.Unmarshal(item[0], &piece); err != nil {
+				return nil, Info{}, fmt.Errorf("invalid Unigram piece: %w", err)
+			}
+			if err := json.Unmarshal(item[1], &score); err != nil {
+				return nil, Info{}, fmt.Errorf("invalid Unigram score: %w", err)
+			}
+			pieces[piece] = score
+		}
+		counter := newUnigram(pieces, raw.Model.ByteFallback)
+		counter.setSpecialTokens(raw.specialTokens())
+		return counter, Info{Kind: "unigram", Vocab: len(pieces)}, nil
+	default:
+		return nil, Info{}, fmt.Errorf("unsupported tokenizer model type %q", raw.Model.Type)
+	}
+}
+
+func (t *hfTokenizer) specialTokens() []string {
+	tokens := make([]string, 0, len(t.AddedTokens))
+	for _, added := range t.AddedTokens {
+		if added.Special && added.Content != "" {
+			tokens = append(tokens, added.Content)
+		}
+	}
+	return tokens
+}
+
+// parseMerge accepts both the legacy "a b" and the newer ["a", "b"] forms.
+func parseMerge(raw json.RawMessage) ([2]string, error) {
+	var pair [2]string
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		left, right, ok := strings.Cut(s, " ")
+		if !ok {
+			return pair, fmt.Errorf("invalid merge %q", s)
+		}
+		return [2]string{left, right}, nil
+	}
+	var parts []string
+	if err := json.Unmarshal(raw, &parts); err != nil || len(parts) != 2 {
+		return pair, errors.New("invalid merge entry")
+	}
+	return [2]string{parts[0], parts[1]}, nil
+}
+
+// splitSpecial cuts special tokens (e.g. <|im_start|>) out of text; each one
+// counts as a single token.
+func splitSpecial(text string, special []string) ([]string, int) {
+	if len(special) == 0 {
+		return []string{text}, 0
+	}
+	segments := []string{text}
+	count := 0
+	for _, token := range special {
+		if !strings.Contains(text, token) {
+			continue
+		}
+		next := make([]string, 0, len(segments))
+		for _, segment := range segments {
+			parts := strings.Split(segment, token)
+			count += len(parts) - 1
+			for _, part := range parts {
+				if part != "" {
+					next = append(next, part)
+				}
+			}
+		}
+		segments = next
+	}
+	return segments, count
+}
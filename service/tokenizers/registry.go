@@ -0,0 +1,185 @@
+// Package tokenizers provides offline tokenizers for model families that
+// tiktoken does not cover, loaded from HuggingFace tokenizer.json files or
+// SentencePiece vocab files, plus per-model calibration factors.
+package tokenizers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Counter counts the tokens of a text for one model family.
+type Counter interface {
+	Count(text string) int
+}
+
+// Rule maps a model name pattern to a tokenizer file relative to the
+// tokenizer directory. Patterns are case-insensitive and support '*'.
+type Rule struct {
+	Pattern string `json:"pattern"`
+	File    string `json:"file"`
+}
+
+// Info describes a loaded rule for display.
+type Info struct {
+	Pattern string `json:"pattern"`
+	File    string `json:"file"`
+	Kind    string `json:"kind"`
+	Vocab   int    `json:"vocab"`
+}
+
+type entry struct {
+	pattern string
+	counter Counter
+	info    Info
+}
+
+// Registry resolves model names to counters using the first matching rule.
+type Registry struct {
+	mu      sync.RWMutex
+	entries []entry
+	cache   map[string]Counter
+}
+
+func NewRegistry() *Registry {
+	return &Registry{cache: make(map[string]Counter)}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Resolve returns the counter for model, or nil when no rule matches and the
+// caller should fall back to its default encoder.
+func (r *Registry) Resolve(model string) Counter {
+	r.mu.RLock()
+	counter, ok := r.cache[model]
+	r.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if counter, ok := r.cache[model]; ok {
+		return counter
+	}
+	for _, e := range r.entries {
+		if MatchPattern(e.pattern, model) {
+			counter = e.counter
+			break
+		}
+	}
+	r.cache[model] = counter
+	return counter
+}
+
+// Load replaces the registry contents with the tokenizers referenced by rules.
+// Rules whose file is missing are skipped; other load failures are returned
+// together while the successfully loaded rules still take effect.
+func (r *Registry) Load(dir string, rules []Rule) ([]Info, error) {
+	loadedFiles := make(map[string]entry)
+	entries := make([]entry, 0, len(rules))
+	var errs []error
+	for _, rule := range rules {
+		if rule.Pattern == "" || rule.File == "" {
+			continue
+		}
+		path := rule.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		loaded, ok := loadedFiles[path]
+		if !ok {
+			counter, info, err := LoadFile(path)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, fmt.Errorf("%s: %w", rule.File, err))
+				}
+				continue
+			}
+			loaded = entry{counter: counter, info: info}
+			loadedFiles[path] = loaded
+		}
+		info := loaded.info
+		info.Pattern = rule.Pattern
+		info.File = rule.File
+		entries = append(entries, entry{pattern: rule.Pattern, counter: loaded.counter, info: info})
+	}
+
+	r.mu.Lock()
+	r.entries = entries
+	r.cache = make(map[string]Counter)
+	r.mu.Unlock()
+
+	infos := make([]Info, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, e.info)
+	}
+	return infos, errors.Join(errs...)
+}
+
+// Loaded lists the active rules in match order.
+func (r *Registry) Loaded() []Info {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	infos := make([]Info, 0, len(r.entries))
+	for _, e := range r.entries {
+		infos = append(infos, e.info)
+	}
+	return infos
+}
+
+func Resolve(model string) Counter {
+	return defaultRegistry.Resolve(model)
+}
+
+func Load(dir string, rules []Rule) ([]Info, error) {
+	return defaultRegistry.Load(dir, rules)
+}
+
+func Loaded() []Info {
+	return defaultRegistry.Loaded()
+}
+
+// LoadFile loads a tokenizer.json (BPE or Unigram model) or a SentencePiece
+// .vocab file, chosen by extension.
+func LoadFile(path string) (Counter, Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".vocab") {
+		counter, err := parseSentencePieceVocab(data)
+		if err != nil {
+			return nil, Info{}, err
+		}
+		return counter, Info{Kind: "sentencepiece", Vocab: len(counter.pieces)}, nil
+	}
+	return parseHuggingFaceTokenizer(data)
+}
+
+// MatchPattern reports whether name matches pattern, ignoring case. '*'
+// matches any run of characters, including '/'.
+func MatchPattern(pattern, name string) bool {
+	pattern = strings.ToLower(pattern)
+	name = strings.ToLower(name)
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
@@ -0,0 +1,154 @@
+package tokenizers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const byteLevelTokenizer = `{
+  "model": {
+    "type": "BPE",
+    "vocab": {"h": 0, "e": 1, "l": 2, "o": 3, "Ġ": 4, "w": 5, "r": 6, "d": 7, "he": 8, "ll": 9, "hell": 10, "hello": 11, "Ġw": 12, "or": 13, "Ġwor": 14, "Ġworl": 15, "Ġworld": 16},
+    "merges": ["h e", "l l", "he ll", "hell o", "Ġ w", "o r", "Ġw or", "Ġwor l", ["Ġworl", "d"]]
+  },
+  "pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false},
+  "added_tokens": [{"content": "<|im_start|>", "special": true}]
+}`
+
+const unigramTokenizer = `{
+  "model": {
+    "type": "Unigram",
+    "vocab": [["▁hello", -1.0], ["▁", -2.0], ["h", -5.0], ["e", -5.0], ["l", -5.0], ["o", -5.0], ["▁world", -1.5], ["wor", -3.0], ["ld", -3.0]]
+  }
+}`
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestByteLevelBPE(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "qwen/tokenizer.json", byteLevelTokenizer)
+	counter, info, err := LoadFile(filepath.Join(dir, "qwen/tokenizer.json"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if info.Kind != "bpe-bytelevel" {
+		t.Fatalf("expected byte-level kind, got %s", info.Kind)
+	}
+	if got := counter.Count("hello world"); got != 2 {
+		t.Fatalf("expected 2 tokens, got %d", got)
+	}
+	if got := counter.Count("<|im_start|>hello"); got != 2 {
+		t.Fatalf("expected special token to count once, got %d", got)
+	}
+}
+
+func TestUnigram(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "gemma/tokenizer.json", unigramTokenizer)
+	counter, info, err := LoadFile(filepath.Join(dir, "gemma/tokenizer.json"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if info.Kind != "unigram" {
+		t.Fatalf("expected unigram kind, got %s", info.Kind)
+	}
+	if got := counter.Count("hello world"); got != 2 {
+		t.Fatalf("expected 2 tokens, got %d", got)
+	}
+	// unknown runes still produce a segmentation
+	if got := counter.Count("hello zz"); got != 4 {
+		t.Fatalf("expected 4 tokens, got %d", got)
+	}
+}
+
+func TestSentencePieceVocab(t *testing.T) {
+	counter, err := parseSentencePieceVocab([]byte("<unk>\t0\n▁hello\t-1\n▁world\t-1.5\n▁\t-2\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got := counter.Count("hello world"); got != 2 {
+		t.Fatalf("expected 2 tokens, got %d", got)
+	}
+}
+
+func TestRegistryResolve(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "qwen/tokenizer.json", byteLevelTokenizer)
+	registry := NewRegistry()
+	infos, err := registry.Load(dir, []Rule{
+		{Pattern: "qwen*", File: "qwen/tokenizer.json"},
+		{Pattern: "claude*", File: "claude/tokenizer.json"}, // missing file is skipped
+	})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 loaded rule, got %d", len(infos))
+	}
+	if registry.Resolve("Qwen2.5-72B-Instruct") == nil {
+		t.Fatalf("expected qwen tokenizer")
+	}
+	if registry.Resolve("claude-3-5-sonnet") != nil {
+		t.Fatalf("expected fallback for claude")
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"qwen*", "qwen-max", true},
+		{"*llama*", "meta-llama/Llama-3.1-8B", true},
+		{"gpt-4o", "GPT-4o", true},
+		{"gpt-4o", "gpt-4o-mini", false},
+		{"deepseek*chat", "deepseek-v3-chat", true},
+		{"deepseek*chat", "deepseek-v3-coder", false},
+	}
+	for _, tc := range cases {
+		if got := MatchPattern(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLearnFactors(t *testing.T) {
+	samples := []Sample{
+		{Model: "claude-3", Estimated: 100, Actual: 120},
+		{Model: "claude-3", Estimated: 100, Actual: 130},
+		{Model: "claude-3", Estimated: 100, Actual: 125},
+		{Model: "gemini", Estimated: 100, Actual: 1000},
+		{Model: "gemini", Estimated: 100, Actual: 900},
+		{Model: "gemini", Estimated: 100, Actual: 800},
+		{Model: "rare", Estimated: 100, Actual: 200},
+	}
+	factors := LearnFactors(samples, 3, 0.5, 3)
+	if factors["claude-3"] != 1.25 {
+		t.Fatalf("expected median 1.25, got %v", factors["claude-3"])
+	}
+	if factors["gemini"] != 3 {
+		t.Fatalf("expected clamp to 3, got %v", factors["gemini"])
+	}
+	if _, ok := factors["rare"]; ok {
+		t.Fatalf("expected rare model to be skipped")
+	}
+
+	SetCalibration(factors)
+	defer SetCalibration(nil)
+	if got := Calibrate("claude-3", 100); got != 125 {
+		t.Fatalf("expected 125, got %d", got)
+	}
+	if got := Calibrate("unknown", 100); got != 100 {
+		t.Fatalf("expected unchanged estimate, got %d", got)
+	}
+}
@@ -0,0 +1,127 @@
+package tokenizers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// unigram is a SentencePiece unigram model (Gemma/Gemini, T5, Llama 2 vocab
+// files). Segmentation is the Viterbi path maximising the summed piece scores.
+type unigram struct {
+	pieces       map[string]float64
+	maxPieceLen  int // in bytes
+	unknownScore float64
+	byteFallback bool
+	special      []string
+}
+
+func newUnigram(pieces map[string]float64, byteFallback bool) *unigram {
+	u := &unigram{pieces: pieces, byteFallback: byteFallback}
+	minScore := 0.0
+	for piece, score := range pieces {
+		if len(piece) > u.maxPieceLen {
+			u.maxPieceLen = len(piece)
+		}
+		if score < minScore {
+			minScore = score
+		}
+	}
+	u.unknownScore = minScore - 10
+	if _, ok := pieces["<0x00>"]; ok {
+		u.byteFallback = true
+	}
+	return u
+}
+
+func (u *unigram) setSpecialTokens(tokens []string) {
+	u.special = tokens
+}
+
+// parseSentencePieceVocab reads the "piece<TAB>score" text format written by
+// spm_train next to the .model file.
+func parseSentencePieceVocab(data []byte) (*unigram, error) {
+	pieces := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		piece, scoreText, _ := strings.Cut(line, "\t")
+		score := 0.0
+		if scoreText != "" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(scoreText), 64)
+			if err != nil {
+				return nil, errors.New("invalid score in vocab line: " + line)
+			}
+			score = parsed
+		}
+		pieces[piece] = score
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pieces) == 0 {
+		return nil, errors.New("empty vocab")
+	}
+	return newUnigram(pieces, false), nil
+}
+
+func (u *unigram) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	segments, count := splitSpecial(text, u.special)
+	for _, segment := range segments {
+		normalized := sentencePieceSpace + strings.ReplaceAll(segment, " ", sentencePieceSpace)
+		for _, word := range splitSentencePieceWords(normalized) {
+			count += u.countWord(word)
+		}
+	}
+	return count
+}
+
+func (u *unigram) countWord(word string) int {
+	n := len(word)
+	scores := make([]float64, n+1)
+	counts := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		scores[i] = math.Inf(-1)
+	}
+	for end := 1; end <= n; end++ {
+		if end < n && !utf8.RuneStart(word[end]) {
+			continue
+		}
+		for start := max(0, end-u.maxPieceLen); start < end; start++ {
+			if math.IsInf(scores[start], -1) || !utf8.RuneStart(word[start]) {
+				continue
+			}
+			if score, ok := u.pieces[word[start:end]]; ok {
+				if candidate := scores[start] + score; candidate > scores[end] {
+					scores[end] = candidate
+					counts[end] = counts[start] + 1
+				}
+			}
+		}
+		// a single unknown rune is always reachable
+		_, size := utf8.DecodeLastRuneInString(word[:end])
+		start := end - size
+		if !math.IsInf(scores[start], -1) {
+			unknownTokens := 1
+			if u.byteFallback {
+				unknownTokens = size
+			}
+			if candidate := scores[start] + u.unknownScore*float64(unknownTokens); candidate > scores[end] {
+				scores[end] = candidate
+				counts[end] = counts[start] + unknownTokens
+			}
+		}
+	}
+	return counts[n]
+}
@@ -15,6 +15,17 @@ import (
 
 // ConsumePackageQuota consumes quota from available user packages prioritizing soon-to-expire packages
 func ConsumePackageQuota(userId int, modelName string, quota int64, specificPackageId *int) ([]*model.UserPackage, error) {
+	return consumePackageQuota(userId, modelName, quota, specificPackageId, false)
+}
+
+// DrawPackageQuota consumes up to quota from available user packages, prioritizing soon-to-expire
+// packages. The returned packages hold the quota drawn from each; they are empty when no package can pay.
+func DrawPackageQuota(userId int, modelName string, quota int64) ([]*model.UserPackage, error) {
+	return consumePackageQuota(userId, modelName, quota, nil, true)
+}
+
+// consumePackageQuota consumes quota from packages; unless partial, it fails when they cannot pay all of it.
+func consumePackageQuota(userId int, modelName string, quota int64, specificPackageId *int, partial bool) ([]*model.UserPackage, error) {
 	if userId <= 0 {
 		return nil, errors.New("invalid user id")
 	}
@@ -23,7 +34,7 @@ func ConsumePackageQuota(userId int, modelName string, quota int64, specificPack
 	}
 
 	var consumedPackages []*model.UserPackage
-	error := model.DB.Transaction(func(tx *gorm.DB) error {
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
 		query := tx.Set("gorm:query_option", "FOR UPDATE").
 			Where("user_id = ? AND status = ? AND token_quota > 0 AND expire_at > ?",
 				userId,
@@ -40,6 +51,9 @@ func ConsumePackageQuota(userId int, modelName string, quota int64, specificPack
 		}
 
 		if len(packages) == 0 {
+			if partial {
+				return nil
+			}
 			return errors.New("没有可用的积分包")
 		}
 
@@ -52,6 +66,9 @@ func ConsumePackageQuota(userId int, modelName string, quota int64, specificPack
 		}
 
 		if len(allowedPackages) == 0 {
+			if partial {
+				return nil
+			}
 			return errors.New("没有包含所选模型权限的积分包")
 		}
 
@@ -81,7 +98,7 @@ func ConsumePackageQuota(userId int, modelName string, quota int64, specificPack
 			consumedPackages = append(consumedPackages, &pkgCopy)
 		}
 
-		if remaining > 0 {
+		if remaining > 0 && !partial {
 			return errors.New("积分包额度不足")
 		}
 
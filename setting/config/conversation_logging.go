@@ -0,0 +1,37 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// ConversationLoggingConfig controls automatic capture of relay conversations
+// for tokens with conversation logging enabled. Captured conversations are
+// sealed to the owner's registered public key before they are stored.
+type ConversationLoggingConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxCaptureBytes bounds how much of the request and response is kept in
+	// memory per conversation; longer conversations are truncated.
+	MaxCaptureBytes int `json:"max_capture_bytes"`
+	// RetentionDays permanently removes relay-captured logs older than this
+	// many days; 0 keeps them forever. Logs uploaded by clients are never
+	// purged, their owners delete them.
+	RetentionDays       int `json:"retention_days"`
+	RetentionCheckHours int `json:"retention_check_hours"`
+	BufferSize          int `json:"buffer_size"`
+	ExportMaxRecords    int `json:"export_max_records"`
+}
+
+var conversationLoggingConfig = ConversationLoggingConfig{
+	Enabled:             common.GetEnvOrDefaultBool("CONVERSATION_LOGGING_ENABLED", true),
+	MaxCaptureBytes:     common.GetEnvOrDefault("CONVERSATION_LOGGING_MAX_BYTES", 1<<20),
+	RetentionDays:       common.GetEnvOrDefault("CONVERSATION_LOGGING_RETENTION_DAYS", 0),
+	RetentionCheckHours: common.GetEnvOrDefault("CONVERSATION_LOGGING_RETENTION_CHECK_HOURS", 6),
+	BufferSize:          common.GetEnvOrDefault("CONVERSATION_LOGGING_BUFFER_SIZE", 1024),
+	ExportMaxRecords:    common.GetEnvOrDefault("CONVERSATION_LOGGING_EXPORT_MAX_RECORDS", 10000),
+}
+
+func init() {
+	GlobalConfig.Register("conversation_logging", &conversationLoggingConfig)
+}
+
+func GetConversationLoggingConfig() *ConversationLoggingConfig {
+	return &conversationLoggingConfig
+}
@@ -0,0 +1,25 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// FundingConfig controls the funding waterfall, the order in which a
+// request's quota is drawn from credit packages, plan allowance and balance.
+type FundingConfig struct {
+	Enabled bool `json:"enabled"`
+	// Waterfall is the default order as a comma-separated list of package,
+	// plan and balance. Tokens and users may override it.
+	Waterfall string `json:"waterfall"`
+}
+
+var fundingConfig = FundingConfig{
+	Enabled:   common.GetEnvOrDefaultBool("FUNDING_WATERFALL_ENABLED", false),
+	Waterfall: common.GetEnvOrDefaultString("FUNDING_WATERFALL", "package,plan,balance"),
+}
+
+func init() {
+	GlobalConfig.Register("funding", &fundingConfig)
+}
+
+func GetFundingConfig() *FundingConfig {
+	return &fundingConfig
+}
@@ -2,21 +2,86 @@ package config
 
 import "github.com/QuantumNous/new-api/common"
 
+// Governance policy actions.
+const (
+    GovernanceActionFlag       = "flag"
+    GovernanceActionReroute    = "reroute"
+    GovernanceActionBlock      = "block"
+    GovernanceActionThrottle   = "throttle"
+    GovernanceActionRequire2FA = "require_2fa"
+)
+
 // GovernanceConfig controls request governance and abuse protection parameters.
 // These options are feature-gated and disabled by default.
 type GovernanceConfig struct {
     Enabled           bool   `json:"enabled"`
     AbuseRPMThreshold int    `json:"abuse_rpm_threshold"`
     RerouteModelAlias string `json:"reroute_model_alias"`
+    // MaliciousFallbackAlias and ViolationFallbackAlias override RerouteModelAlias per severity.
+    MaliciousFallbackAlias string `json:"malicious_fallback_alias"`
+    ViolationFallbackAlias string `json:"violation_fallback_alias"`
     // FlagTTLHours controls default TTL for RequestFlag rows without an explicit ttl_at.
     FlagTTLHours      int    `json:"flag_ttl_hours"`
+    // Prompt sanity detector thresholds.
+    PromptMaxLength     int      `json:"prompt_max_length"`
+    PromptMinEntropy    float64  `json:"prompt_min_entropy"`
+    PromptMaxRepetition float64  `json:"prompt_max_repetition"`
+    ViolationKeywords   []string `json:"violation_keywords"`
+    // DryRun evaluates every policy and records hits without enforcing any action.
+    DryRun bool `json:"dry_run"`
+    // Policies are evaluated in priority order. When empty, the built-in
+    // policies reproduce the historical detector behaviour.
+    Policies []GovernancePolicy `json:"policies"`
+}
+
+// GovernancePolicy is an admin-defined rule: when its conditions match, its action applies.
+type GovernancePolicy struct {
+    Name     string `json:"name"`
+    Enabled  bool   `json:"enabled"`
+    Priority int    `json:"priority"`
+    // Match is "all" (default) or "any".
+    Match      string                `json:"match"`
+    Conditions []GovernanceCondition `json:"conditions"`
+    Action     GovernanceAction      `json:"action"`
+    // DryRun records hits for this policy only, without enforcing its action.
+    DryRun bool `json:"dry_run"`
+    // Stop prevents lower-priority policies from being evaluated after a hit.
+    Stop bool `json:"stop"`
+}
+
+// GovernanceCondition compares a request fact with a value. Fields include
+// subject_type, subject_id, user_id, token_id, model, group, path, prompt,
+// prompt_length, prompt_tokens, entropy, repetition_ratio, rpm and
+// detector.<name>, detector.<name>.severity, detector.<name>.reason.
+type GovernanceCondition struct {
+    Field    string      `json:"field"`
+    Operator string      `json:"operator"`
+    Value    interface{} `json:"value"`
+}
+
+// GovernanceAction describes what happens when a policy matches.
+type GovernanceAction struct {
+    Type     string `json:"type"`
+    Severity string `json:"severity"`
+    Reason   string `json:"reason"`
+    // RerouteAlias overrides the configured fallback alias for reroute actions.
+    RerouteAlias string `json:"reroute_alias"`
+    // ThrottleRPM is the per-subject request budget enforced by throttle actions.
+    ThrottleRPM int    `json:"throttle_rpm"`
+    Message     string `json:"message"`
 }
 
 var governanceConfig = GovernanceConfig{
-    Enabled:           common.GetEnvOrDefaultBool("GOVERNANCE_ENABLED", false),
-    AbuseRPMThreshold: common.GetEnvOrDefault("GOVERNANCE_ABUSE_RPM_THRESHOLD", 3000),
-    RerouteModelAlias: common.GetEnvOrDefaultString("GOVERNANCE_REROUTE_MODEL_ALIAS", ""),
-    FlagTTLHours:      common.GetEnvOrDefault("GOVERNANCE_FLAG_TTL_HOURS", 24),
+    Enabled:                common.GetEnvOrDefaultBool("GOVERNANCE_ENABLED", false),
+    AbuseRPMThreshold:      common.GetEnvOrDefault("GOVERNANCE_ABUSE_RPM_THRESHOLD", 3000),
+    RerouteModelAlias:      common.GetEnvOrDefaultString("GOVERNANCE_REROUTE_MODEL_ALIAS", ""),
+    MaliciousFallbackAlias: common.GetEnvOrDefaultString("GOVERNANCE_MALICIOUS_FALLBACK_ALIAS", ""),
+    ViolationFallbackAlias: common.GetEnvOrDefaultString("GOVERNANCE_VIOLATION_FALLBACK_ALIAS", ""),
+    FlagTTLHours:           common.GetEnvOrDefault("GOVERNANCE_FLAG_TTL_HOURS", 24),
+    PromptMaxLength:        common.GetEnvOrDefault("GOVERNANCE_PROMPT_MAX_LENGTH", 8192),
+    ViolationKeywords:      []string{},
+    DryRun:                 common.GetEnvOrDefaultBool("GOVERNANCE_DRY_RUN", false),
+    Policies:               []GovernancePolicy{},
 }
 
 func init() {
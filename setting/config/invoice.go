@@ -0,0 +1,53 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// InvoiceConfig controls invoices for top-ups and monthly usage statements.
+type InvoiceConfig struct {
+	Enabled bool `json:"enabled"`
+	// Company details printed as the issuer of every invoice and statement.
+	CompanyName    string `json:"company_name"`
+	CompanyAddress string `json:"company_address"`
+	CompanyTaxId   string `json:"company_tax_id"`
+	CompanyEmail   string `json:"company_email"`
+	// TaxName labels the tax, e.g. VAT. TaxRate is a percentage included in
+	// the price paid; 0 prints no tax.
+	TaxName string  `json:"tax_name"`
+	TaxRate float64 `json:"tax_rate"`
+	// Currency is the currency of payments; ProviderCurrencies overrides it
+	// per payment provider, e.g. {"epay": "CNY"}.
+	Currency           string            `json:"currency"`
+	ProviderCurrencies map[string]string `json:"provider_currencies"`
+	InvoicePrefix      string            `json:"invoice_prefix"`
+	StatementPrefix    string            `json:"statement_prefix"`
+	Footer             string            `json:"footer"`
+	// EmailDelivery mails each new invoice and statement to the user.
+	EmailDelivery bool `json:"email_delivery"`
+}
+
+var invoiceConfig = InvoiceConfig{
+	Enabled:            common.GetEnvOrDefaultBool("INVOICE_ENABLED", false),
+	CompanyName:        common.GetEnvOrDefaultString("INVOICE_COMPANY_NAME", ""),
+	TaxName:            common.GetEnvOrDefaultString("INVOICE_TAX_NAME", "Tax"),
+	Currency:           common.GetEnvOrDefaultString("INVOICE_CURRENCY", "USD"),
+	ProviderCurrencies: map[string]string{},
+	InvoicePrefix:      common.GetEnvOrDefaultString("INVOICE_PREFIX", "INV-"),
+	StatementPrefix:    common.GetEnvOrDefaultString("INVOICE_STATEMENT_PREFIX", "STM-"),
+	EmailDelivery:      common.GetEnvOrDefaultBool("INVOICE_EMAIL_DELIVERY", false),
+}
+
+func init() {
+	GlobalConfig.Register("invoice", &invoiceConfig)
+}
+
+func GetInvoiceConfig() *InvoiceConfig {
+	return &invoiceConfig
+}
+
+// CurrencyOf returns the currency payments through a provider are made in.
+func (c *InvoiceConfig) CurrencyOf(provider string) string {
+	if currency, ok := c.ProviderCurrencies[provider]; ok && currency != "" {
+		return currency
+	}
+	return c.Currency
+}
@@ -0,0 +1,33 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// McpConfig controls the MCP gateway that proxies registered MCP servers
+// behind token auth.
+type McpConfig struct {
+	Enabled bool `json:"enabled"`
+	// RequireGrant denies MCP access to tokens without an MCP allowlist. When
+	// false such tokens may use every enabled server, like model limits.
+	RequireGrant bool `json:"require_grant"`
+	// StdioEnabled allows servers that run as local processes.
+	StdioEnabled       bool `json:"stdio_enabled"`
+	CallTimeoutSeconds int  `json:"call_timeout_seconds"`
+	// CatalogTTLSeconds is how long a server's tool list is cached.
+	CatalogTTLSeconds int `json:"catalog_ttl_seconds"`
+}
+
+var mcpConfig = McpConfig{
+	Enabled:            common.GetEnvOrDefaultBool("MCP_GATEWAY_ENABLED", true),
+	RequireGrant:       common.GetEnvOrDefaultBool("MCP_REQUIRE_GRANT", true),
+	StdioEnabled:       common.GetEnvOrDefaultBool("MCP_STDIO_ENABLED", false),
+	CallTimeoutSeconds: common.GetEnvOrDefault("MCP_CALL_TIMEOUT_SECONDS", 60),
+	CatalogTTLSeconds:  common.GetEnvOrDefault("MCP_CATALOG_TTL_SECONDS", 300),
+}
+
+func init() {
+	GlobalConfig.Register("mcp", &mcpConfig)
+}
+
+func GetMcpConfig() *McpConfig {
+	return &mcpConfig
+}
@@ -0,0 +1,67 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// ModerationConfig controls how /v1/moderations is answered. Models not
+// listed here are relayed to the channel's own moderation endpoint.
+type ModerationConfig struct {
+	// LocalModels are answered by the gateway from the sensitive word list
+	// and governance keywords, without a channel; hits are reported under
+	// LocalCategory.
+	LocalModels   []string `json:"local_models"`
+	LocalCategory string   `json:"local_category"`
+	// ClassifierModels are answered by prompting the chat model of the
+	// selected channel and parsing its category scores.
+	ClassifierModels []string `json:"classifier_models"`
+	// Threshold is the score at which a classifier category is flagged.
+	Threshold float64 `json:"threshold"`
+	// Prices is the price in USD per input, by model. Local models without
+	// an entry are free; other models without one use their model ratio.
+	Prices map[string]float64 `json:"prices"`
+}
+
+var moderationConfig = ModerationConfig{
+	LocalModels:      []string{common.GetEnvOrDefaultString("MODERATION_LOCAL_MODEL", "local-moderation")},
+	LocalCategory:    common.GetEnvOrDefaultString("MODERATION_LOCAL_CATEGORY", "illicit"),
+	ClassifierModels: []string{},
+	Threshold:        0.5,
+	Prices:           map[string]float64{},
+}
+
+func init() {
+	GlobalConfig.Register("moderation", &moderationConfig)
+}
+
+func GetModerationConfig() *ModerationConfig {
+	return &moderationConfig
+}
+
+// IsLocalModel reports whether the gateway answers the model itself.
+func (c *ModerationConfig) IsLocalModel(model string) bool {
+	return containsModel(c.LocalModels, model)
+}
+
+// IsClassifierModel reports whether the model is answered by a chat model.
+func (c *ModerationConfig) IsClassifierModel(model string) bool {
+	return containsModel(c.ClassifierModels, model)
+}
+
+// PriceFor returns the per-input price of a model, if it has one.
+func (c *ModerationConfig) PriceFor(model string) (float64, bool) {
+	if price, ok := c.Prices[model]; ok {
+		return price, true
+	}
+	if c.IsLocalModel(model) {
+		return 0, true
+	}
+	return 0, false
+}
+
+func containsModel(models []string, model string) bool {
+	for _, m := range models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// OutputModerationConfig controls scanning of model output, streamed or not,
+// against the sensitive word list and optionally a moderation channel.
+type OutputModerationConfig struct {
+	Enabled bool `json:"enabled"`
+	// Action is "truncate", "replace" or "flag". Empty follows
+	// StopOnSensitiveEnabled: truncate when set, replace otherwise.
+	Action      string `json:"action"`
+	Replacement string `json:"replacement"`
+	// WindowRunes is how much earlier text is rescanned with each chunk; it
+	// is raised to the longest sensitive word automatically.
+	WindowRunes int `json:"window_runes"`
+	// ChannelId delegates checks to the /v1/moderations endpoint of that
+	// channel, every CheckIntervalRunes of new output and at the end.
+	ChannelId          int    `json:"channel_id"`
+	Model              string `json:"model"`
+	CheckIntervalRunes int    `json:"check_interval_runes"`
+	// RecordViolations writes a security violation for flagged output.
+	RecordViolations bool `json:"record_violations"`
+}
+
+var outputModerationConfig = OutputModerationConfig{
+	Enabled:            common.GetEnvOrDefaultBool("OUTPUT_MODERATION_ENABLED", false),
+	Action:             common.GetEnvOrDefaultString("OUTPUT_MODERATION_ACTION", ""),
+	Replacement:        common.GetEnvOrDefaultString("OUTPUT_MODERATION_REPLACEMENT", "**###**"),
+	WindowRunes:        common.GetEnvOrDefault("OUTPUT_MODERATION_WINDOW_RUNES", 32),
+	ChannelId:          common.GetEnvOrDefault("OUTPUT_MODERATION_CHANNEL_ID", 0),
+	Model:              common.GetEnvOrDefaultString("OUTPUT_MODERATION_MODEL", "omni-moderation-latest"),
+	CheckIntervalRunes: common.GetEnvOrDefault("OUTPUT_MODERATION_CHECK_INTERVAL_RUNES", 400),
+	RecordViolations:   common.GetEnvOrDefaultBool("OUTPUT_MODERATION_RECORD_VIOLATIONS", true),
+}
+
+func init() {
+	GlobalConfig.Register("output_moderation", &outputModerationConfig)
+}
+
+func GetOutputModerationConfig() *OutputModerationConfig {
+	return &outputModerationConfig
+}
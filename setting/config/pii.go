@@ -0,0 +1,46 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// PIIConfig controls detection of personal data and secrets in prompts.
+type PIIConfig struct {
+	Enabled bool `json:"enabled"`
+	// DefaultPolicy applies to groups without an entry in GroupPolicies.
+	DefaultPolicy PIIPolicy            `json:"default_policy"`
+	GroupPolicies map[string]PIIPolicy `json:"group_policies"`
+	// FlagRequests records a governance RequestFlag for requests with hits.
+	FlagRequests bool `json:"flag_requests"`
+}
+
+// PIIPolicy is what happens to detected entities: "off", "block", "mask" or
+// "tokenize". Entities limits the detectors (email, phone, credit_card,
+// national_id, iban, secret); empty means all of them.
+type PIIPolicy struct {
+	Action   string   `json:"action"`
+	Entities []string `json:"entities"`
+}
+
+var piiConfig = PIIConfig{
+	Enabled: common.GetEnvOrDefaultBool("PII_ENABLED", false),
+	DefaultPolicy: PIIPolicy{
+		Action: common.GetEnvOrDefaultString("PII_DEFAULT_ACTION", "mask"),
+	},
+	GroupPolicies: map[string]PIIPolicy{},
+	FlagRequests:  common.GetEnvOrDefaultBool("PII_FLAG_REQUESTS", true),
+}
+
+func init() {
+	GlobalConfig.Register("pii", &piiConfig)
+}
+
+func GetPIIConfig() *PIIConfig {
+	return &piiConfig
+}
+
+// PolicyForGroup returns the policy of a group, falling back to the default.
+func (c *PIIConfig) PolicyForGroup(group string) PIIPolicy {
+	if policy, ok := c.GroupPolicies[group]; ok {
+		return policy
+	}
+	return c.DefaultPolicy
+}
@@ -0,0 +1,37 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// RealtimeBridgeConfig controls how /v1/realtime is served for channels whose
+// adaptor does not speak the OpenAI Realtime protocol. Gemini channels are
+// bridged to Gemini Live; every other channel type is served by a cascaded
+// speech-to-text -> chat -> text-to-speech pipeline.
+type RealtimeBridgeConfig struct {
+	GeminiLiveEnabled bool `json:"gemini_live_enabled"`
+	CascadeEnabled    bool `json:"cascade_enabled"`
+	// CascadeSTTModel and CascadeTTSModel are routed through regular channel
+	// selection in the caller's group, so any channel serving them can be used.
+	CascadeSTTModel string `json:"cascade_stt_model"`
+	CascadeTTSModel string `json:"cascade_tts_model"`
+	// CascadeVoice is used when the session does not request a voice.
+	CascadeVoice string `json:"cascade_voice"`
+	// CascadeMaxAudioSeconds bounds the audio buffered for one user turn.
+	CascadeMaxAudioSeconds int `json:"cascade_max_audio_seconds"`
+}
+
+var realtimeBridgeConfig = RealtimeBridgeConfig{
+	GeminiLiveEnabled:      common.GetEnvOrDefaultBool("REALTIME_GEMINI_LIVE_ENABLED", true),
+	CascadeEnabled:         common.GetEnvOrDefaultBool("REALTIME_CASCADE_ENABLED", true),
+	CascadeSTTModel:        common.GetEnvOrDefaultString("REALTIME_CASCADE_STT_MODEL", "whisper-1"),
+	CascadeTTSModel:        common.GetEnvOrDefaultString("REALTIME_CASCADE_TTS_MODEL", "tts-1"),
+	CascadeVoice:           common.GetEnvOrDefaultString("REALTIME_CASCADE_VOICE", "alloy"),
+	CascadeMaxAudioSeconds: common.GetEnvOrDefault("REALTIME_CASCADE_MAX_AUDIO_SECONDS", 120),
+}
+
+func init() {
+	GlobalConfig.Register("realtime_bridge", &realtimeBridgeConfig)
+}
+
+func GetRealtimeBridgeConfig() *RealtimeBridgeConfig {
+	return &realtimeBridgeConfig
+}
@@ -0,0 +1,46 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// RequestCaptureConfig controls the built-in relay request capture that feeds
+// heimdall_logs (and therefore the anomaly detector) without the Heimdall sidecar.
+// It is off by default; enable it with REQUEST_CAPTURE_ENABLED=true or the
+// request_capture.enabled option, but not with the sidecar in front, which
+// would count requests twice.
+type RequestCaptureConfig struct {
+	Enabled bool `json:"enabled"`
+	// SampleRate is the fraction of relay requests recorded, between 0 and 1.
+	SampleRate float64 `json:"sample_rate"`
+	// CaptureHeaders stores request headers with credentials redacted.
+	CaptureHeaders bool `json:"capture_headers"`
+	// CaptureBody stores a redacted, truncated copy of the request body.
+	// The content fingerprint is always computed regardless of this switch.
+	CaptureBody  bool `json:"capture_body"`
+	MaxBodyBytes int  `json:"max_body_bytes"`
+	// RedactHeaders lists extra header names (case-insensitive) to redact.
+	RedactHeaders []string `json:"redact_headers"`
+	// BufferSize bounds the in-memory queue; entries are dropped when it is full.
+	BufferSize           int `json:"buffer_size"`
+	BatchSize            int `json:"batch_size"`
+	FlushIntervalSeconds int `json:"flush_interval_seconds"`
+}
+
+var requestCaptureConfig = RequestCaptureConfig{
+	Enabled:              common.GetEnvOrDefaultBool("REQUEST_CAPTURE_ENABLED", false),
+	SampleRate:           1,
+	CaptureHeaders:       common.GetEnvOrDefaultBool("REQUEST_CAPTURE_HEADERS", true),
+	CaptureBody:          common.GetEnvOrDefaultBool("REQUEST_CAPTURE_BODY", false),
+	MaxBodyBytes:         common.GetEnvOrDefault("REQUEST_CAPTURE_MAX_BODY_BYTES", 10000),
+	RedactHeaders:        []string{},
+	BufferSize:           common.GetEnvOrDefault("REQUEST_CAPTURE_BUFFER_SIZE", 4096),
+	BatchSize:            common.GetEnvOrDefault("REQUEST_CAPTURE_BATCH_SIZE", 200),
+	FlushIntervalSeconds: common.GetEnvOrDefault("REQUEST_CAPTURE_FLUSH_INTERVAL", 5),
+}
+
+func init() {
+	GlobalConfig.Register("request_capture", &requestCaptureConfig)
+}
+
+func GetRequestCaptureConfig() *RequestCaptureConfig {
+	return &requestCaptureConfig
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// ScimGroupMapping maps members of a SCIM group to a gateway group and an
+// organization membership. Empty fields are not applied.
+type ScimGroupMapping struct {
+	// ScimGroup is the displayName of the SCIM group, matched case-insensitively.
+	ScimGroup        string `json:"scim_group"`
+	Group            string `json:"group"`
+	OrganizationId   int    `json:"organization_id"`
+	OrganizationRole string `json:"organization_role"`
+}
+
+// ScimConfig controls the SCIM 2.0 provisioning server under /scim/v2.
+type ScimConfig struct {
+	Enabled bool `json:"enabled"`
+	// TokenHash is the SHA-256 of the provisioning bearer token. The token
+	// itself is only shown once, when it is generated.
+	TokenHash string `json:"token_hash"`
+	// OidcSubject names the SCIM attribute carrying the user's OIDC subject,
+	// "externalId" or "userName", so OIDC logins find provisioned users.
+	// Empty disables the link.
+	OidcSubject   string             `json:"oidc_subject"`
+	GroupMappings []ScimGroupMapping `json:"group_mappings"`
+}
+
+var scimConfig = ScimConfig{
+	Enabled:       common.GetEnvOrDefaultBool("SCIM_ENABLED", false),
+	OidcSubject:   common.GetEnvOrDefaultString("SCIM_OIDC_SUBJECT", "externalId"),
+	GroupMappings: []ScimGroupMapping{},
+}
+
+func init() {
+	GlobalConfig.Register("scim", &scimConfig)
+}
+
+func GetScimConfig() *ScimConfig {
+	return &scimConfig
+}
+
+// MappingsFor returns the mappings of a SCIM group.
+func (c *ScimConfig) MappingsFor(scimGroup string) []ScimGroupMapping {
+	var mappings []ScimGroupMapping
+	for _, mapping := range c.GroupMappings {
+		if strings.EqualFold(mapping.ScimGroup, scimGroup) {
+			mappings = append(mappings, mapping)
+		}
+	}
+	return mappings
+}
+
+// MappedOrganizations returns every organization some mapping points at.
+// Membership of these organizations is managed by SCIM.
+func (c *ScimConfig) MappedOrganizations() map[int]bool {
+	orgs := make(map[int]bool)
+	for _, mapping := range c.GroupMappings {
+		if mapping.OrganizationId > 0 {
+			orgs[mapping.OrganizationId] = true
+		}
+	}
+	return orgs
+}
@@ -0,0 +1,29 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// ServerToolConfig controls gateway-executed tools for chat completions.
+type ServerToolConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxIterations caps the model turns per request; the last turn is sent
+	// with tool_choice "none" so the model has to answer.
+	MaxIterations  int `json:"max_iterations"`
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// MaxResultBytes truncates tool output before it is fed back to the model.
+	MaxResultBytes int `json:"max_result_bytes"`
+}
+
+var serverToolConfig = ServerToolConfig{
+	Enabled:        common.GetEnvOrDefaultBool("SERVER_TOOLS_ENABLED", true),
+	MaxIterations:  common.GetEnvOrDefault("SERVER_TOOLS_MAX_ITERATIONS", 5),
+	TimeoutSeconds: common.GetEnvOrDefault("SERVER_TOOLS_TIMEOUT_SECONDS", 30),
+	MaxResultBytes: common.GetEnvOrDefault("SERVER_TOOLS_MAX_RESULT_BYTES", 16384),
+}
+
+func init() {
+	GlobalConfig.Register("server_tool", &serverToolConfig)
+}
+
+func GetServerToolConfig() *ServerToolConfig {
+	return &serverToolConfig
+}
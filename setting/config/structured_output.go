@@ -0,0 +1,38 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// StructuredOutputConfig controls gateway validation of response_format
+// json_schema / json_object output.
+type StructuredOutputConfig struct {
+	// Enabled validates chat completion output and records the result in
+	// the consume log.
+	Enabled bool `json:"enabled"`
+	// RepairAttempts is how often an invalid non-stream answer is retried on
+	// the same channel with the validation errors as a repair prompt.
+	RepairAttempts int `json:"repair_attempts"`
+	// FallbackChannel moves the request to another channel when the output is
+	// still invalid after the repair attempts.
+	FallbackChannel bool `json:"fallback_channel"`
+	// RejectInvalid fails the request instead of returning invalid output.
+	RejectInvalid bool `json:"reject_invalid"`
+	// ClaudeToolForcing sends json_schema to Claude as a forced tool call and
+	// returns the tool input as the message content.
+	ClaudeToolForcing bool `json:"claude_tool_forcing"`
+}
+
+var structuredOutputConfig = StructuredOutputConfig{
+	Enabled:           common.GetEnvOrDefaultBool("STRUCTURED_OUTPUT_VALIDATION_ENABLED", true),
+	RepairAttempts:    common.GetEnvOrDefault("STRUCTURED_OUTPUT_REPAIR_ATTEMPTS", 1),
+	FallbackChannel:   common.GetEnvOrDefaultBool("STRUCTURED_OUTPUT_FALLBACK_CHANNEL", false),
+	RejectInvalid:     common.GetEnvOrDefaultBool("STRUCTURED_OUTPUT_REJECT_INVALID", false),
+	ClaudeToolForcing: common.GetEnvOrDefaultBool("STRUCTURED_OUTPUT_CLAUDE_TOOL_FORCING", true),
+}
+
+func init() {
+	GlobalConfig.Register("structured_output", &structuredOutputConfig)
+}
+
+func GetStructuredOutputConfig() *StructuredOutputConfig {
+	return &structuredOutputConfig
+}
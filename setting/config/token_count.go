@@ -0,0 +1,29 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// TokenCountConfig controls the Anthropic /v1/messages/count_tokens and
+// Gemini models/*:countTokens endpoints. Counts are computed locally with the
+// gateway tokenizer unless forwarding is enabled.
+type TokenCountConfig struct {
+	Enabled bool `json:"enabled"`
+	// ForwardUpstream asks the selected channel for an exact count when it is
+	// of the matching type, falling back to the local estimate on failure.
+	ForwardUpstream bool `json:"forward_upstream"`
+	// Price is charged per count request in USD, multiplied by the group ratio.
+	// Zero keeps the endpoints free.
+	Price float64 `json:"price"`
+}
+
+var tokenCountConfig = TokenCountConfig{
+	Enabled:         common.GetEnvOrDefaultBool("TOKEN_COUNT_ENABLED", true),
+	ForwardUpstream: common.GetEnvOrDefaultBool("TOKEN_COUNT_FORWARD_UPSTREAM", false),
+}
+
+func init() {
+	GlobalConfig.Register("token_count", &tokenCountConfig)
+}
+
+func GetTokenCountConfig() *TokenCountConfig {
+	return &tokenCountConfig
+}
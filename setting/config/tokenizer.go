@@ -0,0 +1,54 @@
+package config
+
+import "github.com/QuantumNous/new-api/common"
+
+// TokenizerRule maps a model name pattern ('*' wildcard, case-insensitive) to a
+// tokenizer.json or SentencePiece .vocab file under TokenizerConfig.Dir.
+type TokenizerRule struct {
+	Pattern string `json:"pattern"`
+	File    string `json:"file"`
+}
+
+// TokenizerConfig controls the offline per-family tokenizers used for
+// pre-consume estimates. Models without a matching rule, or whose file is
+// missing, keep using tiktoken with the cl100k_base fallback.
+type TokenizerConfig struct {
+	Dir string `json:"dir"`
+	// Rules are matched in order; the first match wins.
+	Rules []TokenizerRule `json:"rules"`
+	// CalibrationEnabled learns per-model correction factors from consume logs
+	// that carry both the local estimate and the upstream prompt tokens.
+	CalibrationEnabled     bool    `json:"calibration_enabled"`
+	CalibrationWindowHours int     `json:"calibration_window_hours"`
+	CalibrationMinSamples  int     `json:"calibration_min_samples"`
+	CalibrationMinFactor   float64 `json:"calibration_min_factor"`
+	CalibrationMaxFactor   float64 `json:"calibration_max_factor"`
+}
+
+var tokenizerConfig = TokenizerConfig{
+	Dir: common.GetEnvOrDefaultString("TOKENIZER_DIR", "./tokenizers"),
+	Rules: []TokenizerRule{
+		{Pattern: "claude*", File: "claude/tokenizer.json"},
+		{Pattern: "gemini*", File: "gemma/tokenizer.json"},
+		{Pattern: "gemma*", File: "gemma/tokenizer.json"},
+		{Pattern: "qwen*", File: "qwen/tokenizer.json"},
+		{Pattern: "qwq*", File: "qwen/tokenizer.json"},
+		{Pattern: "glm*", File: "glm/tokenizer.json"},
+		{Pattern: "chatglm*", File: "glm/tokenizer.json"},
+		{Pattern: "deepseek*", File: "deepseek/tokenizer.json"},
+		{Pattern: "*llama*", File: "llama/tokenizer.json"},
+	},
+	CalibrationEnabled:     common.GetEnvOrDefaultBool("TOKENIZER_CALIBRATION_ENABLED", true),
+	CalibrationWindowHours: common.GetEnvOrDefault("TOKENIZER_CALIBRATION_WINDOW_HOURS", 72),
+	CalibrationMinSamples:  common.GetEnvOrDefault("TOKENIZER_CALIBRATION_MIN_SAMPLES", 20),
+	CalibrationMinFactor:   0.5,
+	CalibrationMaxFactor:   3,
+}
+
+func init() {
+	GlobalConfig.Register("tokenizer", &tokenizerConfig)
+}
+
+func GetTokenizerConfig() *TokenizerConfig {
+	return &tokenizerConfig
+}
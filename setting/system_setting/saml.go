@@ -0,0 +1,67 @@
+package system_setting
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// SAMLGroupMapping maps a value of the group attribute to a gateway group.
+type SAMLGroupMapping struct {
+	SamlGroup string `json:"saml_group"`
+	Group     string `json:"group"`
+}
+
+type SAMLSettings struct {
+	Enabled bool `json:"enabled"`
+	// EntityId defaults to the metadata URL.
+	EntityId       string `json:"entity_id"`
+	IdpMetadataUrl string `json:"idp_metadata_url"`
+	// IdpMetadataXml takes precedence over IdpMetadataUrl when set.
+	IdpMetadataXml    string `json:"idp_metadata_xml"`
+	SpCertificate     string `json:"sp_certificate"`
+	SpPrivateKey      string `json:"sp_private_key"`
+	AllowIdpInitiated bool   `json:"allow_idp_initiated"`
+	// An empty UsernameAttribute takes the username from the NameID.
+	UsernameAttribute    string             `json:"username_attribute"`
+	EmailAttribute       string             `json:"email_attribute"`
+	DisplayNameAttribute string             `json:"display_name_attribute"`
+	GroupAttribute       string             `json:"group_attribute"`
+	GroupMappings        []SAMLGroupMapping `json:"group_mappings"`
+}
+
+var defaultSAMLSettings = SAMLSettings{
+	EmailAttribute:       "email",
+	DisplayNameAttribute: "displayName",
+	GroupMappings:        []SAMLGroupMapping{},
+}
+
+func init() {
+	config.GlobalConfig.Register("saml", &defaultSAMLSettings)
+}
+
+func GetSAMLSettings() *SAMLSettings {
+	return &defaultSAMLSettings
+}
+
+// MetadataURL is where the service provider metadata is served.
+func (s *SAMLSettings) MetadataURL() string {
+	return strings.TrimRight(ServerAddress, "/") + "/api/saml/metadata"
+}
+
+func (s *SAMLSettings) AcsURL() string {
+	return strings.TrimRight(ServerAddress, "/") + "/api/saml/acs"
+}
+
+// MapGroup returns the gateway group of the first mapped SAML group, or ""
+// when none of them is mapped.
+func (s *SAMLSettings) MapGroup(samlGroups []string) string {
+	for _, mapping := range s.GroupMappings {
+		for _, samlGroup := range samlGroups {
+			if strings.EqualFold(mapping.SamlGroup, samlGroup) {
+				return mapping.Group
+			}
+		}
+	}
+	return ""
+}
@@ -36,8 +36,10 @@ const (
 type ErrorCode string
 
 const (
-	ErrorCodeInvalidRequest         ErrorCode = "invalid_request"
-	ErrorCodeSensitiveWordsDetected ErrorCode = "sensitive_words_detected"
+	ErrorCodeInvalidRequest          ErrorCode = "invalid_request"
+	ErrorCodeSensitiveWordsDetected  ErrorCode = "sensitive_words_detected"
+	ErrorCodePIIDetected             ErrorCode = "pii_detected"
+	ErrorCodePIIRedactionUnsupported ErrorCode = "pii_redaction_unsupported"
 
 	// new api error
 	ErrorCodeCountTokenFailed   ErrorCode = "count_token_failed"
@@ -75,6 +77,9 @@ const (
 	ErrorCodeModelNotFound          ErrorCode = "model_not_found"
 	ErrorCodePromptBlocked          ErrorCode = "prompt_blocked"
 
+	// output does not match the requested response_format schema
+	ErrorCodeStructuredOutputInvalid ErrorCode = "structured_output_invalid"
+
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
 	ErrorCodeUpdateDataError ErrorCode = "update_data_error"
@@ -82,6 +87,7 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+	ErrorCodeSpendingBudgetExceeded     ErrorCode = "spending_budget_exceeded"
 )
 
 type NewAPIError struct {
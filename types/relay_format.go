@@ -12,6 +12,8 @@ const (
 	RelayFormatOpenAIRealtime              = "openai_realtime"
 	RelayFormatRerank                      = "rerank"
 	RelayFormatEmbedding                   = "embedding"
+	RelayFormatOllama                      = "ollama"
+	RelayFormatModeration                  = "moderation"
 
 	RelayFormatTask    = "task"
 	RelayFormatMjProxy = "mj_proxy"
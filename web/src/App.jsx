@@ -288,6 +288,14 @@ function App() {
             </Suspense>
           }
         />
+        <Route
+          path='/oauth/saml'
+          element={
+            <Suspense fallback={<Loading></Loading>}>
+              <OAuth2Callback type='saml'></OAuth2Callback>
+            </Suspense>
+          }
+        />
         <Route
           path='/oauth/linuxdo'
           element={
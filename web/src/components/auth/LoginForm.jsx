@@ -31,6 +31,7 @@ import {
   setUserData,
   onGitHubOAuthClicked,
   onOIDCClicked,
+  onSAMLClicked,
   onLinuxDOOAuthClicked,
   prepareCredentialRequestOptions,
   buildAssertionResult,
@@ -82,6 +83,7 @@ const LoginForm = () => {
   const [wechatLoading, setWechatLoading] = useState(false);
   const [githubLoading, setGithubLoading] = useState(false);
   const [oidcLoading, setOidcLoading] = useState(false);
+  const [samlLoading, setSamlLoading] = useState(false);
   const [linuxdoLoading, setLinuxdoLoading] = useState(false);
   const [emailLoginLoading, setEmailLoginLoading] = useState(false);
   const [loginLoading, setLoginLoading] = useState(false);
@@ -284,6 +286,20 @@ const LoginForm = () => {
     }
   };
 
+  // 包装的SAML登录点击处理
+  const handleSAMLClick = () => {
+    if ((hasUserAgreement || hasPrivacyPolicy) && !agreedToTerms) {
+      showInfo(t('请先阅读并同意用户协议和隐私政策'));
+      return;
+    }
+    setSamlLoading(true);
+    try {
+      onSAMLClicked();
+    } finally {
+      setTimeout(() => setSamlLoading(false), 3000);
+    }
+  };
+
   // 包装的OIDC登录点击处理
   const handleOIDCClick = () => {
     if ((hasUserAgreement || hasPrivacyPolicy) && !agreedToTerms) {
@@ -480,6 +496,19 @@ const LoginForm = () => {
               </Button>
             )}
 
+            {status.saml_enabled && (
+              <Button
+                theme='outline'
+                className='auth-secondary-button'
+                type='tertiary'
+                icon={<IconKey size='large' />}
+                onClick={handleSAMLClick}
+                loading={samlLoading}
+              >
+                <span>{t('使用 SAML 单点登录继续')}</span>
+              </Button>
+            )}
+
             {status.linuxdo_oauth && (
               <Button
                 theme='outline'
@@ -718,6 +747,7 @@ const LoginForm = () => {
 
             {(status.github_oauth ||
               status.oidc_enabled ||
+              status.saml_enabled ||
               status.wechat_login ||
               status.linuxdo_oauth ||
               status.telegram_oauth) && (
@@ -852,6 +882,7 @@ const LoginForm = () => {
         !(
           status.github_oauth ||
           status.oidc_enabled ||
+          status.saml_enabled ||
           status.wechat_login ||
           status.linuxdo_oauth ||
           status.telegram_oauth
@@ -247,6 +247,12 @@ export async function onOIDCClicked(auth_url, client_id, openInNewTab = false) {
   }
 }
 
+export async function onSAMLClicked() {
+  const state = await getOAuthState();
+  if (!state) return;
+  window.location.href = `/api/saml/login?state=${encodeURIComponent(state)}`;
+}
+
 export async function onGitHubOAuthClicked(github_client_id) {
   const state = await getOAuthState();
   if (!state) return;